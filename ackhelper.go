@@ -1,19 +1,37 @@
 package photon
 
 import (
+	"fmt"
+	"sync/atomic"
+
 	"github.com/SmartMeshFoundation/Photon/encoding"
+	"github.com/SmartMeshFoundation/Photon/log"
 	"github.com/SmartMeshFoundation/Photon/models"
 	"github.com/ethereum/go-ethereum/common"
 )
 
 //AckHelper save ack for sent and recevied  message
 type AckHelper struct {
-	dao models.Dao
+	dao             models.Dao
+	retentionBlocks int64 //0 表示永远不清理
+	/*
+		启动时数据库中的块号,处理第一个块之前保存的 ack 用的都是这个块号,
+		它们实际上是刚刚收到的,在 firstBlock+保留时间 之前不能清理
+	*/
+	openedBlock int64
+	firstBlock  int64
+	//blockNumber 主线程最新处理的块号,收到消息时在 PhotonProtocol 的 goroutine 中读取
+	blockNumber int64
 }
 
 //NewAckHelper create ack
 func NewAckHelper(dao models.Dao) *AckHelper {
-	return &AckHelper{dao}
+	blockNumber := dao.GetLatestBlockNumber()
+	return &AckHelper{
+		dao:         dao,
+		openedBlock: blockNumber,
+		blockNumber: blockNumber,
+	}
 }
 
 //GetAck return a message's ack
@@ -23,5 +41,71 @@ func (ah *AckHelper) GetAck(echohash common.Hash) []byte {
 
 //SaveAck save ack to dao
 func (ah *AckHelper) SaveAck(echohash common.Hash, msg encoding.Messager, ack []byte) {
-	ah.dao.SaveAckNoTx(echohash, ack)
+	ah.dao.SaveAckNoTx(echohash, ack, atomic.LoadInt64(&ah.blockNumber))
+}
+
+/*
+SetRetentionBlocks ack 保存 n 块以后可以清理,0 表示永远不清理.
+清理以后对方重发同一个消息不会再被当作重复消息,所以实际的保留时间不会小于 PruneAcks 的 horizon.
+只能在主线程中调用
+*/
+func (ah *AckHelper) SetRetentionBlocks(n int64) {
+	if n < 0 {
+		n = 0
+	}
+	ah.retentionBlocks = n
+}
+
+/*
+PruneAcks 删除 blockNumber 之前保留时间以外的 ack,之后保存的 ack 记录为 blockNumber.
+horizon 是消息还可能被合法重发的块数,保留时间小于它时按 horizon 计算.
+只能在主线程中调用
+*/
+func (ah *AckHelper) PruneAcks(blockNumber, horizon int64) {
+	atomic.StoreInt64(&ah.blockNumber, blockNumber)
+	if ah.retentionBlocks <= 0 {
+		return
+	}
+	if ah.firstBlock == 0 {
+		ah.firstBlock = blockNumber
+	}
+	retention := ah.retentionBlocks
+	if retention < horizon {
+		retention = horizon
+	}
+	before := blockNumber - retention
+	if blockNumber < ah.firstBlock+retention && before > ah.openedBlock {
+		before = ah.openedBlock
+	}
+	if before <= 0 {
+		return
+	}
+	removed, err := ah.dao.RemoveAcksBefore(before)
+	if err != nil {
+		log.Error(fmt.Sprintf("RemoveAcksBefore %d err %s", before, err))
+		return
+	}
+	if removed > 0 {
+		log.Trace(fmt.Sprintf("prune %d acks saved before block %d", removed, before))
+	}
+}
+
+/*
+pruneAcks 锁的过期时间不会超过通道的 settle timeout,超过以后消息即使被重发也不会再改变通道状态,
+所以以所有通道中最大的 settle timeout 作为 horizon.
+只能在主线程中调用
+*/
+func (rs *Service) pruneAcks(blockNumber int64) {
+	if rs.ackHelper == nil {
+		return
+	}
+	horizon := int64(rs.Config.SettleTimeout)
+	for _, g := range rs.Token2ChannelGraph {
+		for _, c := range g.ChannelIdentifier2Channel {
+			if int64(c.SettleTimeout) > horizon {
+				horizon = int64(c.SettleTimeout)
+			}
+		}
+	}
+	rs.ackHelper.PruneAcks(blockNumber, horizon)
 }
@@ -0,0 +1,58 @@
+package photon
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAckHelperPruneAcks(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	saveAt := func(ah *AckHelper, blockNumber int64) common.Hash {
+		atomic.StoreInt64(&ah.blockNumber, blockNumber)
+		echohash := utils.NewRandomHash()
+		ah.SaveAck(echohash, nil, echohash[:])
+		return echohash
+	}
+	ah := NewAckHelper(dao)
+	old := saveAt(ah, 500)
+	dao.SaveLatestBlockNumber(1000)
+	ah = NewAckHelper(dao)
+	ah.SetRetentionBlocks(100)
+	//处理第一个块之前收到的消息,用的是启动时数据库中的块号
+	startup := saveAt(ah, 1000)
+
+	ah.PruneAcks(1001, 50)
+	assert.Nil(t, ah.GetAck(old), "old ack should be evicted")
+	assert.NotNil(t, ah.GetAck(startup))
+
+	recent := saveAt(ah, 1050)
+	ah.PruneAcks(1150, 50)
+	assert.Nil(t, ah.GetAck(startup))
+	//保留时间之内的重发仍然可以被识别
+	assert.NotNil(t, ah.GetAck(recent))
+
+	//保留时间小于 horizon 时按 horizon 计算
+	ah.PruneAcks(1200, 500)
+	assert.NotNil(t, ah.GetAck(recent))
+}
+
+func TestAckHelperKeepAcksReceivedBeforeFirstBlock(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	//节点很久没有运行,数据库中的块号远远落后
+	dao.SaveLatestBlockNumber(100)
+	ah := NewAckHelper(dao)
+	ah.SetRetentionBlocks(100)
+	echohash := utils.NewRandomHash()
+	ah.SaveAck(echohash, nil, echohash[:])
+	ah.PruneAcks(5000, 50)
+	assert.NotNil(t, ah.GetAck(echohash))
+	ah.PruneAcks(5100, 50)
+	assert.Nil(t, ah.GetAck(echohash))
+}
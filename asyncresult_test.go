@@ -0,0 +1,143 @@
+package photon
+
+import (
+	"math/big"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/network/graph"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/notify"
+	"github.com/SmartMeshFoundation/Photon/transfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+//发起交易以后调用者不读取结果,重复的完成通知也不能阻塞主线程或者泄露goroutine
+func TestAbandonedTransferResult(t *testing.T) {
+	rs := &Service{Transfer2Result: make(map[common.Hash]*utils.AsyncResult)}
+	eh := newStateMachineEventHandler(rs)
+	before := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		lockSecretHash, token := utils.NewRandomHash(), utils.NewRandomAddress()
+		smkey := utils.Sha3(lockSecretHash[:], token[:])
+		result := utils.NewAsyncResult()
+		ev := &transfer.EventTransferSentSuccess{LockSecretHash: lockSecretHash, Token: token}
+		done := make(chan struct{})
+		go func() {
+			rs.Transfer2Result[smkey] = result
+			eh.finishOneTransfer(ev)
+			rs.Transfer2Result[smkey] = result
+			eh.finishOneTransfer(ev)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("finish transfer blocked on abandoned result")
+		}
+		assert.Empty(t, rs.Transfer2Result)
+	}
+	time.Sleep(10 * time.Millisecond)
+	assert.True(t, runtime.NumGoroutine() <= before, "goroutine leak before=%d,after=%d", before, runtime.NumGoroutine())
+}
+
+func TestTransferResultOutcome(t *testing.T) {
+	rs := &Service{Transfer2Result: make(map[common.Hash]*utils.AsyncResult)}
+	eh := newStateMachineEventHandler(rs)
+	lockSecretHash, token := utils.NewRandomHash(), utils.NewRandomAddress()
+	smkey := utils.Sha3(lockSecretHash[:], token[:])
+	result := utils.NewAsyncResult()
+	rs.Transfer2Result[smkey] = result
+	eh.finishOneTransfer(&transfer.EventTransferSentSuccess{
+		LockSecretHash: lockSecretHash,
+		Token:          token,
+		Outcome:        transfer.OutcomeSecretRegisteredOnChain,
+	})
+	assert.Nil(t, <-result.Result)
+	outcome, ok := TransferOutcome(result)
+	assert.True(t, ok)
+	assert.Equal(t, transfer.OutcomeSecretRegisteredOnChain, outcome)
+	assert.True(t, outcome.OnChain())
+
+	//失败的交易没有 outcome
+	result = utils.NewAsyncResult()
+	rs.Transfer2Result[smkey] = result
+	eh.finishOneTransfer(&transfer.EventTransferSentFailed{
+		LockSecretHash: lockSecretHash,
+		Token:          token,
+		Reason:         "no route",
+	})
+	assert.NotNil(t, <-result.Result)
+	_, ok = TransferOutcome(result)
+	assert.False(t, ok)
+}
+
+//接收方和中转节点的 outcome 保存在收款记录中,或者通过通知告诉上层
+func TestReceivedTransferOutcome(t *testing.T) {
+	our, partner, token := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	ch, err := channel.NewChannel(channel.NewChannelEndState(our, big.NewInt(100), nil, mtree.EmptyTree),
+		channel.NewChannelEndState(partner, big.NewInt(50), nil, mtree.EmptyTree), &channel.ExternalState{}, token,
+		&contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash(), OpenBlockNumber: 3}, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewChannelGraph(our, token, nil)
+	assert.Nil(t, g.AddChannel(ch))
+	rs := &Service{
+		NodeAddress:                 our,
+		Token2ChannelGraph:          map[common.Address]*graph.ChannelGraph{token: g},
+		NotifyHandler:               notify.NewNotifyHandler(),
+		BlockNumber:                 new(atomic.Value),
+		receivedTransferSubscribers: newReceivedTransferSubscribers(),
+		dao:                         codefortest.NewTestDB(""),
+	}
+	defer rs.dao.CloseDB()
+	rs.BlockNumber.Store(int64(10))
+	eh := newStateMachineEventHandler(rs)
+	err = eh.OnEvent(&transfer.EventTransferReceivedSuccess{
+		LockSecretHash:    utils.NewRandomHash(),
+		Amount:            big.NewInt(10),
+		Initiator:         utils.NewRandomAddress(),
+		ChannelIdentifier: ch.ChannelIdentifier.ChannelIdentifier,
+		Outcome:           transfer.OutcomeSecretRegisteredOnChain,
+	}, nil)
+	assert.Nil(t, err)
+	select {
+	case rt := <-rs.NotifyHandler.GetReceivedTransferChan():
+		assert.Equal(t, transfer.OutcomeSecretRegisteredOnChain.String(), rt.Outcome)
+		saved, err := rs.dao.GetReceivedTransfer(rt.Key)
+		if assert.Nil(t, err) {
+			assert.Equal(t, transfer.OutcomeSecretRegisteredOnChain.String(), saved.Outcome)
+		}
+	default:
+		t.Fatal("no received transfer notification")
+	}
+
+	lockSecretHash := utils.NewRandomHash()
+	err = eh.OnEvent(&mediatedtransfer.EventUnlockSuccess{
+		LockSecretHash:         lockSecretHash,
+		PayerChannelIdentifier: utils.NewRandomHash(),
+		PayeeChannelIdentifier: utils.NewRandomHash(),
+		Amount:                 big.NewInt(10),
+		Outcome:                transfer.OutcomeSecretRegisteredOnChain,
+	}, nil)
+	assert.Nil(t, err)
+	found := false
+	for len(rs.NotifyHandler.GetNoticeChan()) > 0 {
+		n := <-rs.NotifyHandler.GetNoticeChan()
+		if strings.Contains(n.Info, utils.HPex(lockSecretHash)) {
+			assert.Contains(t, n.Info, transfer.OutcomeSecretRegisteredOnChain.String())
+			found = true
+		}
+	}
+	assert.True(t, found)
+}
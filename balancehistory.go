@@ -0,0 +1,132 @@
+package photon
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+balanceHistory 缓存每个通道最后记录的余额,通道每次保存到数据库都要比较,不能每次都查数据库.
+通道可能在主线程以外保存,所以需要锁保护
+*/
+type balanceHistory struct {
+	lock sync.Mutex
+	last map[common.Hash]*models.BalancePoint
+}
+
+func newBalanceHistory() *balanceHistory {
+	return &balanceHistory{
+		last: make(map[common.Hash]*models.BalancePoint),
+	}
+}
+
+/*
+recordBalancePoint 通道保存到数据库以后调用,余额和上一次记录的不同时记录一个点.
+所有改变通道的操作都经过 UpdateChannel 系列函数,所以不会遗漏,锁的增减不改变余额,不会记录.
+它自己会开启写数据库的 tx,所以在调用者的 tx 中保存通道时,必须在 Commit 以后才能调用.
+记录失败不影响通道本身,只记录日志
+*/
+func (rs *Service) recordBalancePoint(c *channeltype.Serialization) {
+	h := rs.balanceHistory
+	if h == nil {
+		return
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	channelIdentifier := c.ChannelIdentifier.ChannelIdentifier
+	last, ok := h.last[channelIdentifier]
+	if !ok {
+		points, err := rs.dao.GetBalancePoints(channelIdentifier)
+		if err != nil {
+			log.Error(fmt.Sprintf("GetBalancePoints %s err %s", utils.HPex(channelIdentifier), err))
+			return
+		}
+		if len(points) > 0 {
+			last = points[len(points)-1]
+		}
+		h.last[channelIdentifier] = last
+	}
+	p := &models.BalancePoint{
+		ChannelIdentifier:      channelIdentifier[:],
+		OpenBlockNumber:        c.ChannelIdentifier.OpenBlockNumber,
+		BlockNumber:            rs.GetBlockNumber(),
+		Time:                   rs.Clock.Now().Unix(),
+		Balance:                c.OurBalance(),
+		PartnerBalance:         c.PartnerBalance(),
+		OurContractBalance:     c.OurContractBalance,
+		PartnerContractBalance: c.PartnerContractBalance,
+	}
+	p.Kind = balanceChangeKind(last, p)
+	if p.Kind == "" {
+		return
+	}
+	err := rs.dao.AddBalancePoint(p)
+	if err != nil {
+		log.Error(fmt.Sprintf("AddBalancePoint %s err %s", utils.HPex(channelIdentifier), err))
+		return
+	}
+	h.last[channelIdentifier] = p
+}
+
+/*
+balanceChangeKind 根据和上一个点的差别判断余额变化的原因,余额没有变化返回空.
+合约中的押金变了是存款或者取现,否则余额减少是付款,增加是收款
+*/
+func balanceChangeKind(last, p *models.BalancePoint) models.BalanceChangeKind {
+	if last == nil || last.OpenBlockNumber != p.OpenBlockNumber {
+		return models.BalanceChangeOpened
+	}
+	lastTotal := new(big.Int).Add(last.OurContractBalance, last.PartnerContractBalance)
+	total := new(big.Int).Add(p.OurContractBalance, p.PartnerContractBalance)
+	switch total.Cmp(lastTotal) {
+	case 1:
+		return models.BalanceChangeDeposit
+	case -1:
+		return models.BalanceChangeWithdraw
+	}
+	switch p.Balance.Cmp(last.Balance) {
+	case 1:
+		return models.BalanceChangeReceived
+	case -1:
+		return models.BalanceChangeSent
+	}
+	return ""
+}
+
+/*
+GetChannelBalanceHistory 通道 channelIdentifier 在 [fromBlock,toBlock] 之间的余额变化,按发生顺序排列,toBlock<=0 表示不限制.
+每次余额变化一个点,余额在两个点之间保持不变,所以没有变化的块不会出现在结果中.
+fromBlock 之前有记录时,第一个点是 fromBlock 开始时的余额,图表开头不会有空白.
+只能查到这个功能上线以后的变化.
+可以在任意线程中调用
+*/
+func (rs *Service) GetChannelBalanceHistory(channelIdentifier common.Hash, fromBlock, toBlock int64) (points []*models.BalancePoint, err error) {
+	all, err := rs.dao.GetBalancePoints(channelIdentifier)
+	if err != nil {
+		return
+	}
+	var before *models.BalancePoint
+	for _, p := range all {
+		if p.BlockNumber < fromBlock {
+			before = p
+			continue
+		}
+		if toBlock > 0 && p.BlockNumber > toBlock {
+			continue
+		}
+		points = append(points, p)
+	}
+	if before != nil {
+		start := *before
+		start.BlockNumber = fromBlock
+		points = append([]*models.BalancePoint{&start}, points...)
+	}
+	return
+}
@@ -0,0 +1,124 @@
+package photon
+
+import (
+	"math/big"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/notify"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelBalanceHistory(t *testing.T) {
+	our, partner := utils.NewRandomAddress(), utils.NewRandomAddress()
+	ourState := channel.NewChannelEndState(our, big.NewInt(100), nil, mtree.EmptyTree)
+	partnerState := channel.NewChannelEndState(partner, big.NewInt(50), nil, mtree.EmptyTree)
+	ch, err := channel.NewChannel(ourState, partnerState, &channel.ExternalState{}, utils.NewRandomAddress(),
+		&contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash(), OpenBlockNumber: 3}, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := &Service{
+		NodeAddress:    our,
+		NotifyHandler:  notify.NewNotifyHandler(),
+		Clock:          utils.NewRealClock(),
+		BlockNumber:    new(atomic.Value),
+		dao:            codefortest.NewTestDB(""),
+		balanceHistory: newBalanceHistory(),
+	}
+	defer rs.dao.CloseDB()
+	assert.Nil(t, rs.dao.NewChannel(channel.NewChannelSerialization(ch)))
+	save := func(blockNumber int64) {
+		rs.BlockNumber.Store(blockNumber)
+		assert.Nil(t, rs.UpdateChannelNoTx(channel.NewChannelSerialization(ch)))
+	}
+	save(10)
+	//锁的变化等不改变余额的保存不记录
+	save(12)
+	ch.OurState.ContractBalance = big.NewInt(150)
+	save(20)
+	ch.OurState.BalanceProofState.TransferAmount = big.NewInt(30)
+	save(30)
+	//重启以后从数据库中读取上一个点
+	rs.balanceHistory = newBalanceHistory()
+	save(35)
+	ch.PartnerState.BalanceProofState.TransferAmount = big.NewInt(10)
+	save(40)
+
+	id := ch.ChannelIdentifier.ChannelIdentifier
+	points, err := rs.GetChannelBalanceHistory(id, 0, 0)
+	if assert.Nil(t, err) && assert.Len(t, points, 4) {
+		kinds := []models.BalanceChangeKind{models.BalanceChangeOpened, models.BalanceChangeDeposit,
+			models.BalanceChangeSent, models.BalanceChangeReceived}
+		balances := []int64{100, 150, 120, 130}
+		blocks := []int64{10, 20, 30, 40}
+		for i, p := range points {
+			assert.Equal(t, kinds[i], p.Kind)
+			assert.EqualValues(t, big.NewInt(balances[i]), p.Balance)
+			assert.EqualValues(t, blocks[i], p.BlockNumber)
+		}
+		assert.EqualValues(t, big.NewInt(70), points[3].PartnerBalance)
+	}
+
+	//fromBlock 之前的余额带到区间开始
+	points, err = rs.GetChannelBalanceHistory(id, 25, 35)
+	if assert.Nil(t, err) && assert.Len(t, points, 2) {
+		assert.EqualValues(t, 25, points[0].BlockNumber)
+		assert.EqualValues(t, big.NewInt(150), points[0].Balance)
+		assert.EqualValues(t, 30, points[1].BlockNumber)
+		assert.EqualValues(t, big.NewInt(120), points[1].Balance)
+	}
+	points, err = rs.GetChannelBalanceHistory(utils.NewRandomHash(), 0, 0)
+	assert.Nil(t, err)
+	assert.Len(t, points, 0)
+}
+
+func TestUpdateChannelInTx(t *testing.T) {
+	our, partner := utils.NewRandomAddress(), utils.NewRandomAddress()
+	ourState := channel.NewChannelEndState(our, big.NewInt(100), nil, mtree.EmptyTree)
+	partnerState := channel.NewChannelEndState(partner, big.NewInt(50), nil, mtree.EmptyTree)
+	ch, err := channel.NewChannel(ourState, partnerState, &channel.ExternalState{}, utils.NewRandomAddress(),
+		&contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash(), OpenBlockNumber: 3}, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := &Service{
+		NodeAddress:    our,
+		NotifyHandler:  notify.NewNotifyHandler(),
+		Clock:          utils.NewRealClock(),
+		BlockNumber:    new(atomic.Value),
+		dao:            codefortest.NewTestDB(""),
+		balanceHistory: newBalanceHistory(),
+	}
+	defer rs.dao.CloseDB()
+	rs.BlockNumber.Store(int64(10))
+	assert.Nil(t, rs.dao.NewChannel(channel.NewChannelSerialization(ch)))
+	//通道还没有余额记录,在 tx 中保存不能再开启写 tx,否则会一直阻塞
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tx := rs.dao.StartTx()
+		assert.Nil(t, rs.UpdateChannel(channel.NewChannelSerialization(ch), tx))
+		assert.Nil(t, tx.Commit())
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("UpdateChannel in tx blocked")
+	}
+	id := ch.ChannelIdentifier.ChannelIdentifier
+	points, err := rs.GetChannelBalanceHistory(id, 0, 0)
+	assert.Nil(t, err)
+	assert.Len(t, points, 0)
+	rs.recordBalancePoint(channel.NewChannelSerialization(ch))
+	points, err = rs.GetChannelBalanceHistory(id, 0, 0)
+	assert.Nil(t, err)
+	assert.Len(t, points, 1)
+}
@@ -15,6 +15,8 @@ import (
 
 	"strconv"
 
+	"sync/atomic"
+
 	"github.com/SmartMeshFoundation/Photon/log"
 	"github.com/SmartMeshFoundation/Photon/models"
 	"github.com/SmartMeshFoundation/Photon/network/helper"
@@ -78,10 +80,23 @@ type Events struct {
 	rpcModuleDependency      RPCModuleDependency
 	client                   *helper.SafeEthClient
 	pollPeriod               time.Duration              // 轮询周期,必须与公链出块间隔一致
+	configuredPollPeriod     int64                      // SetPollPeriod 指定的轮询周期,0表示按链使用默认值,atomic 访问
 	stopChan                 chan int                   // has stopped?
 	txDone                   map[eventID]uint64         // 该map记录最近30块内处理的events流水,用于事件去重
 	firstStart               bool                       //保证ContractHistoryEventCompleteStateChange 只会发送一次
 	chainEventRecordDao      models.ChainEventRecordDao // 事件处理记录保存
+	/*
+		ConfirmationBlocks open,close,settle 这些影响通道状态的事件,要等到被ConfirmationBlocks个块确认以后才交给photon处理,
+		在确认之前被分叉掉的事件自然就不会再被查询到. 0表示不等待.
+		值越大越不容易受到分叉的影响,但是通道打开会更慢,留给对方关闭通道以后提交BalanceProof的时间也更少.
+	*/
+	ConfirmationBlocks int64
+	// 最早的还没有确认的通道事件所在的块,下次查询要从这里开始,否则追赶很多块或者重启以后会丢掉这些事件. 0表示没有
+	unconfirmedFromBlock int64
+	// 最近处理过的块的hash,用来发现分叉
+	recentBlockHashes map[int64]common.Hash
+	// 多个账户共享公链连接时,共享最新块和事件的查询,nil 表示自己查询
+	headerPoller *SharedHeaderPoller
 }
 
 //NewBlockChainEvents create BlockChainEvents
@@ -93,6 +108,7 @@ func NewBlockChainEvents(client *helper.SafeEthClient, rpcModuleDependency RPCMo
 		txDone:              make(map[eventID]uint64),
 		firstStart:          true,
 		chainEventRecordDao: chainEventRecordDao,
+		recentBlockHashes:   make(map[int64]common.Hash),
 	}
 	return be
 }
@@ -129,6 +145,7 @@ Start listening events send to  channel can duplicate but cannot lose.
 func (be *Events) Start(LastBlockNumber int64) {
 	log.Info(fmt.Sprintf("get state change since %d", LastBlockNumber))
 	be.lastBlockNumber = LastBlockNumber
+	be.restoreUnconfirmedFromBlock(LastBlockNumber)
 	/*
 		1. start alarm task
 	*/
@@ -165,7 +182,7 @@ func (be *Events) startAlarmTask() {
 	startUpBlockNumber := be.lastBlockNumber
 	currentBlock := be.lastBlockNumber
 	currentBlockTimestamp := be.lastBlockNumberTimestamp
-	logPeriod := int64(1)
+	var logPeriod int64
 	retryTime := 0
 	be.stopChan = make(chan int)
 	be.StateChangeChannel <- &transfer.BlockStateChange{BlockNumber: currentBlock}
@@ -185,20 +202,9 @@ func (be *Events) startAlarmTask() {
 	*/
 	for {
 		//get the lastest number imediatelly
-		if be.pollPeriod == 0 {
-			// first time
-			if params.ChainID.Int64() == params.TestPrivateChainID {
-				be.pollPeriod = params.DefaultEthRPCPollPeriodForTest
-				logPeriod = 10
-			} else if params.ChainID.Int64() == params.TestPrivateChainID2 {
-				be.pollPeriod = params.DefaultEthRPCPollPeriodForTest / 10
-				logPeriod = 1000
-			} else {
-				be.pollPeriod = params.DefaultEthRPCPollPeriod
-			}
-		}
+		be.pollPeriod, logPeriod = be.getPollPeriod()
 		ctx, cancelFunc := context.WithTimeout(context.Background(), params.EthRPCTimeout)
-		h, err := be.client.HeaderByNumber(ctx, nil)
+		h, err := be.latestHeader(ctx)
 		if err != nil {
 			//无论公链发生什么错误,都应该让photon启动起来,而不是卡主
 			be.notifyPhotonStartupCompleteIfNeeded(currentBlock)
@@ -270,10 +276,16 @@ func (be *Events) startAlarmTask() {
 			log.Info(fmt.Sprintf("new block :%d", lastedBlock))
 		}
 
-		fromBlockNumber := currentBlock - 2*params.ForkConfirmNumber
-		if fromBlockNumber < 0 {
-			fromBlockNumber = 0
+		if forkBlockNumber, reorged := be.detectReorg(h, be.canonicalHash); reorged {
+			log.Warn(fmt.Sprintf("chain reorg detected,blocks since %d changed,lasted block %d", forkBlockNumber, lastedBlock))
+			be.StateChangeChannel <- &transfer.ChainReorgStateChange{
+				ForkBlockNumber: forkBlockNumber,
+				BlockNumber:     lastedBlock,
+			}
 		}
+		be.recordBlockHash(h)
+
+		fromBlockNumber := be.queryFromBlock(currentBlock)
 		// get all state change between currentBlock and lastedBlock
 		stateChanges, err := be.queryAllStateChange(fromBlockNumber, lastedBlock)
 		if err != nil {
@@ -341,6 +353,50 @@ func (be *Events) startAlarmTask() {
 	}
 }
 
+/*
+SetPollPeriod 修改查询新块的周期,0表示按链使用默认值,可以在任意线程中调用,下一次查询开始生效.
+周期太长会延迟发现新块,影响整个节点对时间的判断,太短则浪费 rpc 调用
+*/
+func (be *Events) SetPollPeriod(period time.Duration) {
+	if period < 0 {
+		period = 0
+	}
+	atomic.StoreInt64(&be.configuredPollPeriod, int64(period))
+}
+
+/*
+SetSharedHeaderPoller 和其他账户共享最新块和事件的查询,需要在 Start 之前调用
+*/
+func (be *Events) SetSharedHeaderPoller(p *SharedHeaderPoller) {
+	be.headerPoller = p
+}
+
+//latestHeader 共享查询时,半个轮询周期之内其他账户查到的最新块也可以直接使用
+func (be *Events) latestHeader(ctx context.Context) (*types.Header, error) {
+	if be.headerPoller != nil {
+		return be.headerPoller.LatestHeader(ctx, be.pollPeriod/2)
+	}
+	return be.client.HeaderByNumber(ctx, nil)
+}
+
+//getPollPeriod 当前的轮询周期,以及每隔多少块输出一次日志
+func (be *Events) getPollPeriod() (pollPeriod time.Duration, logPeriod int64) {
+	logPeriod = 1
+	if params.ChainID.Int64() == params.TestPrivateChainID {
+		pollPeriod = params.DefaultEthRPCPollPeriodForTest
+		logPeriod = 10
+	} else if params.ChainID.Int64() == params.TestPrivateChainID2 {
+		pollPeriod = params.DefaultEthRPCPollPeriodForTest / 10
+		logPeriod = 1000
+	} else {
+		pollPeriod = params.DefaultEthRPCPollPeriod
+	}
+	if p := time.Duration(atomic.LoadInt64(&be.configuredPollPeriod)); p > 0 {
+		pollPeriod = p
+	}
+	return
+}
+
 func (be *Events) queryAllStateChange(fromBlock int64, toBlock int64) (stateChanges []mediatedtransfer.ContractStateChange, err error) {
 	/*
 		get all event of contract TokenNetworkRegistry, SecretRegistry , TokenNetwork
@@ -349,7 +405,7 @@ func (be *Events) queryAllStateChange(fromBlock int64, toBlock int64) (stateChan
 	if err != nil {
 		return
 	}
-	stateChanges, err = be.parseLogsToEvents(logs)
+	stateChanges, err = be.parseLogsToEvents(logs, toBlock)
 	if err != nil {
 		return
 	}
@@ -366,6 +422,9 @@ func (be *Events) getLogsFromChain(fromBlock int64, toBlock int64) (logs []types
 		be.rpcModuleDependency.GetRegistryAddress(),
 		be.rpcModuleDependency.GetSecretRegistryAddress(),
 	}
+	if be.headerPoller != nil {
+		return be.headerPoller.Logs(rpc.GetQueryConext(), contractAddresses, fromBlock, toBlock)
+	}
 	logs, err = rpc.EventsGetInternal(
 		rpc.GetQueryConext(), contractAddresses, fromBlock, toBlock, be.client)
 	if err != nil {
@@ -374,7 +433,16 @@ func (be *Events) getLogsFromChain(fromBlock int64, toBlock int64) (logs []types
 	return
 }
 
-func (be *Events) parseLogsToEvents(logs []types.Log) (stateChanges []mediatedtransfer.ContractStateChange, err error) {
+/*
+parseLogsToEvents latestBlock 是查询时的最新块,通道事件是否得到足够的确认以它为准
+*/
+func (be *Events) parseLogsToEvents(logs []types.Log, latestBlock int64) (stateChanges []mediatedtransfer.ContractStateChange, err error) {
+	var unconfirmedFromBlock int64
+	defer func() {
+		if err == nil {
+			be.unconfirmedFromBlock = unconfirmedFromBlock
+		}
+	}()
 	for _, l := range logs {
 		eventName := topicToEventName[l.Topics[0]]
 		// 根据已处理流水去重
@@ -402,6 +470,15 @@ func (be *Events) parseLogsToEvents(logs []types.Log) (stateChanges []mediatedtr
 			}
 			log.Info(fmt.Sprintf("event %s tx=%s happened at %d, confirmed at %d", eventName, l.TxHash.String(), l.BlockNumber, be.lastBlockNumber))
 		}
+		if be.ConfirmationBlocks > 0 && isChannelLifecycleEvent(eventName) {
+			if latestBlock-int64(l.BlockNumber) < be.ConfirmationBlocks {
+				if unconfirmedFromBlock == 0 || int64(l.BlockNumber) < unconfirmedFromBlock {
+					unconfirmedFromBlock = int64(l.BlockNumber)
+				}
+				continue
+			}
+			log.Info(fmt.Sprintf("event %s tx=%s happened at %d, confirmed by %d blocks at %d", eventName, l.TxHash.String(), l.BlockNumber, be.ConfirmationBlocks, latestBlock))
+		}
 		// registry secret事件延迟确认,否则在出现恶意分叉的情况下,中间节点有损失资金的风险
 		if eventName == params.NameSecretRevealed && params.EnableForkConfirm {
 			if be.lastBlockNumber-int64(l.BlockNumber) < params.ForkConfirmNumber {
@@ -489,6 +566,102 @@ func (be *Events) parseLogsToEvents(logs []types.Log) (stateChanges []mediatedtr
 	return
 }
 
+/*
+detectReorg 从高到低检查记录过的块是否还在链上,返回第一个被替换掉的块.
+latest 的父块直接比较 ParentHash,其他块通过 canonicalHash 查询,查询出错时不报告分叉,下次再检查.
+*/
+func (be *Events) detectReorg(latest *types.Header, canonicalHash func(number int64) (common.Hash, error)) (forkBlockNumber int64, reorged bool) {
+	number := latest.Number.Int64()
+	for n := number - 1; n >= number-2*be.confirmWindow() && n >= 0; n-- {
+		recorded, ok := be.recentBlockHashes[n]
+		if !ok {
+			continue
+		}
+		hash := latest.ParentHash
+		if n != number-1 {
+			var err error
+			hash, err = canonicalHash(n)
+			if err != nil {
+				log.Warn(fmt.Sprintf("get block %d hash err %s", n, err))
+				return
+			}
+		}
+		if hash == recorded {
+			return
+		}
+		forkBlockNumber = n
+		reorged = true
+		delete(be.recentBlockHashes, n)
+	}
+	return
+}
+
+func (be *Events) canonicalHash(number int64) (common.Hash, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), params.EthRPCTimeout)
+	defer cancel()
+	h, err := be.client.HeaderByNumber(ctx, big.NewInt(number))
+	if err != nil {
+		return utils.EmptyHash, err
+	}
+	return h.Hash(), nil
+}
+
+//recordBlockHash 只保留分叉检查范围内的块
+func (be *Events) recordBlockHash(h *types.Header) {
+	number := h.Number.Int64()
+	be.recentBlockHashes[number] = h.Hash()
+	for n := range be.recentBlockHashes {
+		if n < number-2*be.confirmWindow() {
+			delete(be.recentBlockHashes, n)
+		}
+	}
+}
+
+//restoreUnconfirmedFromBlock 上次退出前最后 ConfirmationBlocks 个块中的通道事件可能还没有确认,没有交给photon
+func (be *Events) restoreUnconfirmedFromBlock(lastBlockNumber int64) {
+	if be.ConfirmationBlocks <= 0 || lastBlockNumber <= 0 {
+		return
+	}
+	be.unconfirmedFromBlock = lastBlockNumber - be.ConfirmationBlocks
+	if be.unconfirmedFromBlock <= 0 {
+		be.unconfirmedFromBlock = 1
+	}
+}
+
+//confirmWindow 可能被分叉替换的块的范围,至少要覆盖所有还在等待确认的事件
+func (be *Events) confirmWindow() int64 {
+	if be.ConfirmationBlocks > params.ForkConfirmNumber {
+		return be.ConfirmationBlocks
+	}
+	return params.ForkConfirmNumber
+}
+
+/*
+queryFromBlock 每次查询都要覆盖所有还在等待确认的事件,
+除了最近的块,还要从最早的没有确认的通道事件开始查询
+*/
+func (be *Events) queryFromBlock(currentBlock int64) int64 {
+	fromBlockNumber := currentBlock - 2*params.ForkConfirmNumber
+	if be.unconfirmedFromBlock > 0 && be.unconfirmedFromBlock < fromBlockNumber {
+		fromBlockNumber = be.unconfirmedFromBlock
+	}
+	if fromBlockNumber < 0 {
+		fromBlockNumber = 0
+	}
+	return fromBlockNumber
+}
+
+func isChannelLifecycleEvent(eventName string) bool {
+	switch eventName {
+	case params.NameChannelOpenedAndDeposit,
+		params.NameChannelClosed,
+		params.NameChannelSettled,
+		params.NameChannelCooperativeSettled:
+		return true
+	}
+	return false
+}
+
 func needConfirm(eventName string) bool {
 
 	if eventName == params.NameChannelOpenedAndDeposit ||
@@ -1,6 +1,8 @@
 package blockchain
 
 import (
+	"context"
+	"errors"
 	"os"
 	"testing"
 
@@ -18,8 +20,10 @@ import (
 	"github.com/SmartMeshFoundation/Photon/models"
 	"github.com/SmartMeshFoundation/Photon/params"
 	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
 )
 
 func init() {
@@ -65,6 +69,23 @@ func TestNewBlockChainEvents(t *testing.T) {
 	}
 }
 
+func TestEvents_PollPeriod(t *testing.T) {
+	chainID := params.ChainID
+	defer func() {
+		params.ChainID = chainID
+	}()
+	params.ChainID = big.NewInt(params.TestPrivateChainID)
+	be := &Events{}
+	p, _ := be.getPollPeriod()
+	assert.Equal(t, params.DefaultEthRPCPollPeriodForTest, p)
+	be.SetPollPeriod(3 * time.Second)
+	p, _ = be.getPollPeriod()
+	assert.Equal(t, 3*time.Second, p)
+	be.SetPollPeriod(0)
+	p, _ = be.getPollPeriod()
+	assert.Equal(t, params.DefaultEthRPCPollPeriodForTest, p)
+}
+
 func TestEvents_Start(t *testing.T) {
 	client, err := codefortest.GetEthClient()
 	if err != nil {
@@ -141,3 +162,211 @@ func TestEvents_Start2(t *testing.T) {
 	}
 	t.Logf("chs=%s", utils.StringInterface(chs, 5))
 }
+
+func TestConfirmWindow(t *testing.T) {
+	be := &Events{}
+	if be.confirmWindow() != params.ForkConfirmNumber {
+		t.Error("confirm window should be ForkConfirmNumber by default")
+	}
+	be.ConfirmationBlocks = params.ForkConfirmNumber + 10
+	if be.confirmWindow() != be.ConfirmationBlocks {
+		t.Error("confirm window should cover ConfirmationBlocks")
+	}
+	if !isChannelLifecycleEvent(params.NameChannelClosed) || isChannelLifecycleEvent(params.NameChannelNewDeposit) {
+		t.Error("wrong channel lifecycle event")
+	}
+}
+
+func makeChannelClosedLog(t *testing.T, channelID common.Hash, blockNumber uint64) types.Log {
+	ev := tokenNetworkAbi.Events[params.NameChannelClosed]
+	data, err := ev.Inputs.NonIndexed().Pack(utils.NewRandomAddress(), utils.NewRandomHash(), big.NewInt(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return types.Log{
+		Topics:      []common.Hash{ev.Id(), channelID},
+		Data:        data,
+		BlockNumber: blockNumber,
+		TxHash:      utils.NewRandomHash(),
+	}
+}
+
+//通道事件以查询时的最新块确认,没有确认的事件无论追赶多少块或者重启都会再次查询到
+func TestChannelEventConfirmation(t *testing.T) {
+	be := NewBlockChainEvents(nil, &fakeRPCModule{}, &fakeChainEventRecordDao{})
+	be.ConfirmationBlocks = 30
+	closed := makeChannelClosedLog(t, utils.NewRandomHash(), 1000)
+	//最新块是1010,还没有确认
+	scs, err := be.parseLogsToEvents([]types.Log{closed}, 1010)
+	assert.Nil(t, err)
+	assert.Len(t, scs, 0)
+	//追赶了很多块以后,下次查询仍然覆盖没有确认的事件
+	from := be.queryFromBlock(5000)
+	assert.True(t, from <= 1000, "query from %d", from)
+	//最新块1030确认了30块,立即处理,不用等 lastBlockNumber 更新
+	scs, err = be.parseLogsToEvents([]types.Log{closed}, 1030)
+	assert.Nil(t, err)
+	if assert.Len(t, scs, 1) {
+		assert.EqualValues(t, 1000, scs[0].GetBlockNumber())
+	}
+	assert.EqualValues(t, 5000-2*params.ForkConfirmNumber, be.queryFromBlock(5000))
+	//重复查询到不会重复处理
+	scs, err = be.parseLogsToEvents([]types.Log{closed}, 1031)
+	assert.Nil(t, err)
+	assert.Len(t, scs, 0)
+
+	//重启以后,上次退出前最后 ConfirmationBlocks 个块的通道事件可能还没有处理
+	be = NewBlockChainEvents(nil, &fakeRPCModule{}, &fakeChainEventRecordDao{})
+	be.ConfirmationBlocks = 30
+	be.restoreUnconfirmedFromBlock(2000)
+	assert.True(t, be.queryFromBlock(2000) <= 2000-30)
+}
+
+func TestDetectReorg(t *testing.T) {
+	be := &Events{recentBlockHashes: make(map[int64]common.Hash)}
+	chain := make(map[int64]common.Hash)
+	header := func(number int64) *types.Header {
+		return &types.Header{Number: big.NewInt(number), ParentHash: chain[number-1]}
+	}
+	canonicalHash := func(number int64) (common.Hash, error) {
+		return chain[number], nil
+	}
+	for n := int64(1); n <= 10; n++ {
+		chain[n] = utils.NewRandomHash()
+		be.recentBlockHashes[n] = chain[n]
+	}
+	if _, reorged := be.detectReorg(header(11), canonicalHash); reorged {
+		t.Error("no reorg")
+	}
+	//8,9,10 被替换
+	for n := int64(8); n <= 10; n++ {
+		chain[n] = utils.NewRandomHash()
+	}
+	fork, reorged := be.detectReorg(header(11), canonicalHash)
+	if !reorged || fork != 8 {
+		t.Errorf("reorg should start from 8,got %d %v", fork, reorged)
+	}
+	if _, ok := be.recentBlockHashes[8]; ok {
+		t.Error("replaced block should be forgotten")
+	}
+	h11 := header(11)
+	chain[11] = h11.Hash()
+	be.recordBlockHash(h11)
+	if _, reorged = be.detectReorg(header(12), canonicalHash); reorged {
+		t.Error("reorg should be reported only once")
+	}
+}
+
+type countingHeaderReader struct {
+	base     int64
+	calls    int
+	err      error
+	logErr   error
+	logCalls []ethereum.FilterQuery
+}
+
+//FilterLogs 每个块返回一个事件
+func (r *countingHeaderReader) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	r.logCalls = append(r.logCalls, q)
+	if r.logErr != nil {
+		return nil, r.logErr
+	}
+	var logs []types.Log
+	for n := q.FromBlock.Int64(); n <= q.ToBlock.Int64(); n++ {
+		logs = append(logs, types.Log{Address: q.Addresses[0], BlockNumber: uint64(n)})
+	}
+	return logs, nil
+}
+
+func (r *countingHeaderReader) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	r.calls++
+	if r.err != nil {
+		return nil, r.err
+	}
+	return &types.Header{Number: big.NewInt(r.base + int64(r.calls))}, nil
+}
+
+func TestSharedHeaderPoller(t *testing.T) {
+	reader := &countingHeaderReader{}
+	p := NewSharedHeaderPoller(reader)
+	now := time.Now()
+	p.now = func() time.Time { return now }
+
+	h, err := p.LatestHeader(context.Background(), time.Second)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, h.Number.Int64())
+	//其他账户在 maxAge 之内查询,不访问公链
+	h, err = p.LatestHeader(context.Background(), time.Second)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, h.Number.Int64())
+	assert.Equal(t, 1, reader.calls)
+
+	now = now.Add(time.Second)
+	h, err = p.LatestHeader(context.Background(), time.Second)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 2, h.Number.Int64())
+
+	//出错不缓存
+	reader.err = errors.New("disconnected")
+	now = now.Add(time.Second)
+	_, err = p.LatestHeader(context.Background(), time.Second)
+	assert.NotNil(t, err)
+	_, err = p.LatestHeader(context.Background(), time.Second)
+	assert.NotNil(t, err)
+	assert.Equal(t, 4, reader.calls)
+}
+
+func TestSharedHeaderPollerLogs(t *testing.T) {
+	reader := &countingHeaderReader{base: 100}
+	p := NewSharedHeaderPoller(reader)
+	now := time.Now()
+	p.now = func() time.Time { return now }
+	addresses := []common.Address{utils.NewRandomAddress(), utils.NewRandomAddress()}
+	h, err := p.LatestHeader(context.Background(), time.Second)
+	assert.Nil(t, err)
+	latest := h.Number.Int64()
+
+	//第一个账户查询,第二个账户的范围包含在其中,不再访问公链
+	logs, err := p.Logs(context.Background(), addresses, latest-5, latest)
+	assert.Nil(t, err)
+	assert.Len(t, logs, 6)
+	logs, err = p.Logs(context.Background(), addresses, latest-2, latest)
+	assert.Nil(t, err)
+	assert.Len(t, logs, 3)
+	assert.EqualValues(t, latest-2, logs[0].BlockNumber)
+	assert.Len(t, reader.logCalls, 1)
+
+	//范围更大或者合约不同时重新查询
+	logs, err = p.Logs(context.Background(), addresses, latest-8, latest)
+	assert.Nil(t, err)
+	assert.Len(t, logs, 9)
+	_, err = p.Logs(context.Background(), addresses[:1], latest-8, latest)
+	assert.Nil(t, err)
+	assert.Len(t, reader.logCalls, 3)
+
+	//还在追赶历史的账户直接查询
+	logs, err = p.Logs(context.Background(), addresses[:1], 1, latest-10)
+	assert.Nil(t, err)
+	assert.Len(t, logs, int(latest-10))
+	_, err = p.Logs(context.Background(), addresses[:1], latest-8, latest)
+	assert.Nil(t, err)
+	assert.Len(t, reader.logCalls, 4)
+
+	//新块以后缓存失效
+	now = now.Add(time.Second)
+	h, err = p.LatestHeader(context.Background(), time.Second)
+	assert.Nil(t, err)
+	_, err = p.Logs(context.Background(), addresses[:1], latest, h.Number.Int64())
+	assert.Nil(t, err)
+	assert.Len(t, reader.logCalls, 5)
+	//出错不缓存
+	latest = h.Number.Int64()
+	reader.logErr = errors.New("disconnected")
+	_, err = p.Logs(context.Background(), addresses[:1], latest-3, latest)
+	assert.NotNil(t, err)
+	reader.logErr = nil
+	logs, err = p.Logs(context.Background(), addresses[:1], latest-3, latest)
+	assert.Nil(t, err)
+	assert.Len(t, logs, 4)
+	assert.Len(t, reader.logCalls, 7)
+}
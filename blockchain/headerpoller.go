@@ -0,0 +1,117 @@
+package blockchain
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+//HeaderReader 可以查询块头和事件,比如 helper.SafeEthClient
+type HeaderReader interface {
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error)
+}
+
+/*
+SharedHeaderPoller 一个进程中多个账户共享同一个公链连接时,共享对最新块以及合约事件的查询.
+在 maxAge 之内已经查询过的最新块直接返回,同时到达的查询只会有一个真正访问公链,
+到这个块为止的事件也只查询一次,这样账户再多,每个轮询周期也只需要一次 eth_blockNumber 和一次 eth_getLogs.
+查询出错不会缓存,下一个调用者会重新查询
+*/
+type SharedHeaderPoller struct {
+	reader    HeaderReader
+	lock      sync.Mutex
+	header    *types.Header
+	fetchTime time.Time
+	now       func() time.Time
+	//logs 到 header 为止,从 logsFrom 开始的 logAddresses 的事件,header 变化以后失效
+	logs         []types.Log
+	logsFrom     int64
+	logAddresses []common.Address
+	logsValid    bool
+}
+
+//NewSharedHeaderPoller create SharedHeaderPoller
+func NewSharedHeaderPoller(reader HeaderReader) *SharedHeaderPoller {
+	return &SharedHeaderPoller{
+		reader: reader,
+		now:    time.Now,
+	}
+}
+
+//LatestHeader 返回不超过 maxAge 的最新块头
+func (p *SharedHeaderPoller) LatestHeader(ctx context.Context, maxAge time.Duration) (*types.Header, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.header != nil && p.now().Sub(p.fetchTime) < maxAge {
+		return p.header, nil
+	}
+	h, err := p.reader.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	p.header = h
+	p.fetchTime = p.now()
+	//新块上可能有新的事件,分叉的话旧的事件也可能不再有效
+	p.logs = nil
+	p.logsValid = false
+	return h, nil
+}
+
+/*
+Logs 查询 addresses 在 [from,to] 之间的事件.
+to 是 LatestHeader 最近返回的块时,各个账户的查询共享结果:
+缓存的范围包含 [from,to] 时直接从缓存中过滤,否则查询 [from,to] 并替换缓存.
+to 不是最近的块,比如某个账户还在追赶历史事件时,直接查询,不影响缓存
+*/
+func (p *SharedHeaderPoller) Logs(ctx context.Context, addresses []common.Address, from, to int64) ([]types.Log, error) {
+	p.lock.Lock()
+	if p.header == nil || p.header.Number.Int64() != to {
+		p.lock.Unlock()
+		return p.filterLogs(ctx, addresses, from, to)
+	}
+	defer p.lock.Unlock()
+	if p.logsValid && p.logsFrom <= from && sameAddresses(p.logAddresses, addresses) {
+		var logs []types.Log
+		for _, l := range p.logs {
+			if int64(l.BlockNumber) >= from {
+				logs = append(logs, l)
+			}
+		}
+		return logs, nil
+	}
+	logs, err := p.filterLogs(ctx, addresses, from, to)
+	if err != nil {
+		return nil, err
+	}
+	p.logs = logs
+	p.logsFrom = from
+	p.logAddresses = append([]common.Address(nil), addresses...)
+	p.logsValid = true
+	return logs, nil
+}
+
+func (p *SharedHeaderPoller) filterLogs(ctx context.Context, addresses []common.Address, from, to int64) ([]types.Log, error) {
+	return p.reader.FilterLogs(ctx, ethereum.FilterQuery{
+		FromBlock: big.NewInt(from),
+		ToBlock:   big.NewInt(to),
+		Addresses: addresses,
+	})
+}
+
+func sameAddresses(a, b []common.Address) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,59 @@
+package photon
+
+import (
+	"sync"
+)
+
+/*
+blockNumberSubscribers 把主线程处理完的块号分发给外部订阅者,
+订阅和取消订阅可能发生在任意goroutine中,所以需要锁保护
+*/
+type blockNumberSubscribers struct {
+	lock sync.Mutex
+	subs map[chan int64]bool
+}
+
+func newBlockNumberSubscribers() *blockNumberSubscribers {
+	return &blockNumberSubscribers{
+		subs: make(map[chan int64]bool),
+	}
+}
+
+/*
+publish 不能阻塞主线程,订阅者处理不过来时丢弃旧的块号,只保留最新的
+*/
+func (b *blockNumberSubscribers) publish(blockNumber int64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for ch := range b.subs {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- blockNumber
+	}
+}
+
+/*
+SubscribeBlockNumber 订阅节点已经处理过的块号,订阅时立即收到当前块号,
+消费者处理慢时中间的块号会被丢弃,只保证能拿到最新的.
+调用返回的cancel以后chan会被关闭.
+*/
+func (rs *Service) SubscribeBlockNumber() (<-chan int64, func()) {
+	ch := make(chan int64, 1)
+	b := rs.blockNumberSubscribers
+	b.lock.Lock()
+	ch <- rs.GetBlockNumber()
+	b.subs[ch] = true
+	b.lock.Unlock()
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			b.lock.Lock()
+			delete(b.subs, ch)
+			close(ch)
+			b.lock.Unlock()
+		})
+	}
+	return ch, cancel
+}
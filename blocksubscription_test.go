@@ -0,0 +1,91 @@
+package photon
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/network/helper"
+	"github.com/SmartMeshFoundation/Photon/network/netshare"
+	"github.com/SmartMeshFoundation/Photon/network/rpc"
+	"github.com/SmartMeshFoundation/Photon/notify"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/transfer"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/SmartMeshFoundation/Photon/utils/utest"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func newBlockSubscriptionTestService(blockNumber int64) *Service {
+	rs := &Service{
+		BlockNumber:            new(atomic.Value),
+		blockNumberSubscribers: newBlockNumberSubscribers(),
+	}
+	rs.BlockNumber.Store(blockNumber)
+	return rs
+}
+
+//订阅时立即收到当前块号,处理不过来的订阅者只能拿到最新的块号
+func TestSubscribeBlockNumber(t *testing.T) {
+	rs := newBlockSubscriptionTestService(10)
+	ch, cancel := rs.SubscribeBlockNumber()
+	assert.EqualValues(t, 10, <-ch)
+
+	rs.blockNumberSubscribers.publish(11)
+	rs.blockNumberSubscribers.publish(12)
+	rs.blockNumberSubscribers.publish(13)
+	assert.EqualValues(t, 13, <-ch)
+	select {
+	case n := <-ch:
+		t.Fatalf("receive intermediate block number %d", n)
+	default:
+	}
+
+	//取消以后chan被关闭,重复取消和之后的publish都不会出错
+	cancel()
+	cancel()
+	_, ok := <-ch
+	assert.False(t, ok)
+	rs.blockNumberSubscribers.publish(14)
+	assert.Len(t, rs.blockNumberSubscribers.subs, 0)
+}
+
+//一个订阅者不读取不会影响其他订阅者
+func TestSubscribeBlockNumberSlowConsumer(t *testing.T) {
+	rs := newBlockSubscriptionTestService(1)
+	slow, cancelSlow := rs.SubscribeBlockNumber()
+	defer cancelSlow()
+	fast, cancelFast := rs.SubscribeBlockNumber()
+	defer cancelFast()
+	assert.EqualValues(t, 1, <-fast)
+	for i := int64(2); i <= 100; i++ {
+		rs.blockNumberSubscribers.publish(i)
+		assert.EqualValues(t, i, <-fast)
+	}
+	assert.EqualValues(t, 100, <-slow)
+}
+
+//handleBlockNumber 处理完新块以后才通知订阅者
+func TestHandleBlockNumberPublish(t *testing.T) {
+	rs := newBlockSubscriptionTestService(1)
+	rs.NodeAddress = utils.NewRandomAddress()
+	rs.Config = &params.Config{}
+	rs.NotifyHandler = notify.NewNotifyHandler()
+	rs.Clock = utest.NewFakeClock(time.Now())
+	rs.Chain = &rpc.BlockChainService{Client: &helper.SafeEthClient{Status: netshare.Connected}}
+	rs.IsChainEffective = true
+	rs.Transfer2StateManager = make(map[common.Hash]*transfer.StateManager)
+	rs.dao = codefortest.NewTestDB("")
+	defer rs.dao.CloseDB()
+	rs.StateMachineEventHandler = newStateMachineEventHandler(rs)
+	ch, cancel := rs.SubscribeBlockNumber()
+	defer cancel()
+	assert.EqualValues(t, 1, <-ch)
+
+	rs.handleBlockNumber(&transfer.BlockStateChange{BlockNumber: 2})
+	assert.EqualValues(t, 2, <-ch)
+	assert.EqualValues(t, 2, rs.GetBlockNumber())
+	assert.EqualValues(t, 2, rs.dao.GetLatestBlockNumber())
+}
@@ -0,0 +1,161 @@
+package photon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+	"sort"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+ChannelCapacity 我参与的一个通道的容量.
+Capacity 是现在可以发给对方的金额,通道不能交易(比如正在关闭,提现)时为0;
+AdjustedCapacity 另外加上了 RevealTimeout 个块以内就会过期的锁,这些锁的密码还不知道,过期以后会退回,
+所以它是 RevealTimeout 个块以后预计的容量,路由新交易应该使用 Capacity.
+Partner 开头的是对方发给我的方向,以我知道的对方的 balance proof 计算
+*/
+type ChannelCapacity struct {
+	ChannelIdentifier       common.Hash    `json:"channel_identifier"`
+	OpenBlockNumber         int64          `json:"open_block_number"`
+	PartnerAddress          common.Address `json:"partner_address"`
+	Capacity                *big.Int       `json:"capacity"`
+	AdjustedCapacity        *big.Int       `json:"adjusted_capacity"`
+	PartnerCapacity         *big.Int       `json:"partner_capacity"`
+	PartnerAdjustedCapacity *big.Int       `json:"partner_adjusted_capacity"`
+	RevealTimeout           int            `json:"reveal_timeout"`
+	SettleTimeout           int            `json:"settle_timeout"`
+}
+
+/*
+CapacityReport 某个 token 上我所有通道的容量,由节点私钥签名,可以上传给路由服务.
+报告只反映 BlockNumber 时的状态,每一笔交易都会改变容量,路由服务应该只保留 (BlockNumber,Timestamp) 最新的报告,
+节点可以在容量变化以后或者每隔 RevealTimeout 个块重新生成并上传
+*/
+type CapacityReport struct {
+	NodeAddress  common.Address     `json:"node_address"`
+	TokenAddress common.Address     `json:"token_address"`
+	BlockNumber  int64              `json:"block_number"`
+	Timestamp    int64              `json:"timestamp"` //生成报告的时间,毫秒
+	Channels     []*ChannelCapacity `json:"channels"`
+	Signature    []byte             `json:"signature"` //utils.SignAuthMessage 对 signData 的签名
+}
+
+func (r *CapacityReport) signData() []byte {
+	buf := new(bytes.Buffer)
+	buf.Write(r.NodeAddress[:])
+	buf.Write(r.TokenAddress[:])
+	binary.Write(buf, binary.BigEndian, r.BlockNumber)
+	binary.Write(buf, binary.BigEndian, r.Timestamp)
+	for _, c := range r.Channels {
+		buf.Write(c.ChannelIdentifier[:])
+		binary.Write(buf, binary.BigEndian, c.OpenBlockNumber)
+		buf.Write(c.PartnerAddress[:])
+		buf.Write(utils.BigIntTo32Bytes(c.Capacity))
+		buf.Write(utils.BigIntTo32Bytes(c.AdjustedCapacity))
+		buf.Write(utils.BigIntTo32Bytes(c.PartnerCapacity))
+		buf.Write(utils.BigIntTo32Bytes(c.PartnerAdjustedCapacity))
+		binary.Write(buf, binary.BigEndian, int64(c.RevealTimeout))
+		binary.Write(buf, binary.BigEndian, int64(c.SettleTimeout))
+	}
+	return buf.Bytes()
+}
+
+//VerifySignature 报告是否由 NodeAddress 签名
+func (r *CapacityReport) VerifySignature() error {
+	signer, err := utils.VerifyAuthMessage(r.signData(), r.Signature)
+	if err != nil {
+		return err
+	}
+	if signer != r.NodeAddress {
+		return rerr.ErrArgumentError.Printf("report signed by %s,not %s", utils.APex2(signer), utils.APex2(r.NodeAddress))
+	}
+	return nil
+}
+
+//ExportCapacityReport 生成 tokenAddress 上我所有通道的容量报告,可以在任意线程中调用
+func (rs *Service) ExportCapacityReport(tokenAddress common.Address) (report *CapacityReport, err error) {
+	result := rs.exportCapacityReportClient(tokenAddress)
+	err = <-result.Result
+	if err != nil {
+		return
+	}
+	report = result.Tag.(*CapacityReport)
+	return
+}
+
+/*
+exportCapacityReport 只能在主线程中调用
+*/
+func (rs *Service) exportCapacityReport(tokenAddress common.Address) (result *utils.AsyncResult) {
+	g := rs.getToken2ChannelGraph(tokenAddress)
+	if g == nil {
+		return utils.NewAsyncResultWithError(rerr.ErrTokenNotFound)
+	}
+	blockNumber := rs.GetBlockNumber()
+	report := &CapacityReport{
+		NodeAddress:  rs.NodeAddress,
+		TokenAddress: tokenAddress,
+		BlockNumber:  blockNumber,
+		Timestamp:    rs.Clock.Now().UnixNano() / 1e6,
+	}
+	for _, c := range g.ChannelIdentifier2Channel {
+		report.Channels = append(report.Channels, channelCapacity(c, blockNumber))
+	}
+	sort.Slice(report.Channels, func(i, j int) bool {
+		return bytes.Compare(report.Channels[i].ChannelIdentifier[:], report.Channels[j].ChannelIdentifier[:]) < 0
+	})
+	sig, err := utils.SignAuthMessage(rs.Signer, report.signData())
+	if err != nil {
+		return utils.NewAsyncResultWithError(err)
+	}
+	report.Signature = sig
+	result = utils.NewAsyncResult()
+	result.Tag = report
+	result.Result <- nil
+	return
+}
+
+func channelCapacity(c *channel.Channel, blockNumber int64) *ChannelCapacity {
+	cc := &ChannelCapacity{
+		ChannelIdentifier:       c.ChannelIdentifier.ChannelIdentifier,
+		OpenBlockNumber:         c.ChannelIdentifier.OpenBlockNumber,
+		PartnerAddress:          c.PartnerState.Address,
+		Capacity:                big.NewInt(0),
+		AdjustedCapacity:        big.NewInt(0),
+		PartnerCapacity:         big.NewInt(0),
+		PartnerAdjustedCapacity: big.NewInt(0),
+		RevealTimeout:           c.RevealTimeout,
+		SettleTimeout:           c.SettleTimeout,
+	}
+	if !c.CanTransfer() {
+		return cc
+	}
+	cc.Capacity = nonNegative(c.OurState.Distributable(c.PartnerState))
+	cc.AdjustedCapacity = new(big.Int).Add(cc.Capacity, expiringLocked(c.OurState, blockNumber, c.RevealTimeout))
+	cc.PartnerCapacity = nonNegative(c.PartnerState.Distributable(c.OurState))
+	cc.PartnerAdjustedCapacity = new(big.Int).Add(cc.PartnerCapacity, expiringLocked(c.PartnerState, blockNumber, c.RevealTimeout))
+	return cc
+}
+
+//expiringLocked 密码未知,并且 revealTimeout 个块以内就会过期的锁的金额
+func expiringLocked(node *channel.EndState, blockNumber int64, revealTimeout int) *big.Int {
+	sum := big.NewInt(0)
+	for _, l := range node.Lock2PendingLocks {
+		if l.Lock.Expiration-blockNumber <= int64(revealTimeout) {
+			sum.Add(sum, l.Lock.Amount)
+		}
+	}
+	return sum
+}
+
+func nonNegative(x *big.Int) *big.Int {
+	if x.Sign() < 0 {
+		return big.NewInt(0)
+	}
+	return x
+}
@@ -0,0 +1,87 @@
+package photon
+
+import (
+	"bytes"
+	"math/big"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
+	"github.com/SmartMeshFoundation/Photon/network/graph"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/SmartMeshFoundation/Photon/utils/utest"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportCapacityReport(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	our := crypto.PubkeyToAddress(key.PublicKey)
+	b, c := utils.NewRandomAddress(), utils.NewRandomAddress()
+	token := utils.NewRandomAddress()
+	g := graph.NewChannelGraph(our, token, nil)
+	var channels []*channel.Channel
+	for _, partner := range []common.Address{b, c} {
+		ourState := channel.NewChannelEndState(our, big.NewInt(100), nil, mtree.EmptyTree)
+		partnerState := channel.NewChannelEndState(partner, big.NewInt(50), nil, mtree.EmptyTree)
+		ch, err := channel.NewChannel(ourState, partnerState, &channel.ExternalState{}, token,
+			&contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash()}, 5, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Nil(t, g.AddChannel(ch))
+		channels = append(channels, ch)
+	}
+	//b 上有两个锁,一个 5 块以内就会过期
+	channels[0].OurState.Lock2PendingLocks[utils.NewRandomHash()] = channeltype.PendingLock{
+		Lock: &mtree.Lock{Expiration: 12, Amount: big.NewInt(10)},
+	}
+	channels[0].OurState.Lock2PendingLocks[utils.NewRandomHash()] = channeltype.PendingLock{
+		Lock: &mtree.Lock{Expiration: 50, Amount: big.NewInt(20)},
+	}
+	channels[1].State = channeltype.StateClosed
+	rs := &Service{
+		NodeAddress:        our,
+		Signer:             utils.NewPrivateKeySigner(key),
+		Clock:              utest.NewFakeClock(time.Now()),
+		BlockNumber:        new(atomic.Value),
+		Token2ChannelGraph: map[common.Address]*graph.ChannelGraph{token: g},
+	}
+	rs.BlockNumber.Store(int64(10))
+
+	result := rs.exportCapacityReport(utils.NewRandomAddress())
+	assert.NotNil(t, <-result.Result)
+
+	result = rs.exportCapacityReport(token)
+	assert.Nil(t, <-result.Result)
+	report := result.Tag.(*CapacityReport)
+	assert.Equal(t, our, report.NodeAddress)
+	assert.EqualValues(t, 10, report.BlockNumber)
+	assert.Len(t, report.Channels, 2)
+	assert.True(t, bytes.Compare(report.Channels[0].ChannelIdentifier[:], report.Channels[1].ChannelIdentifier[:]) < 0)
+	for _, cc := range report.Channels {
+		switch cc.PartnerAddress {
+		case b:
+			assert.EqualValues(t, 70, cc.Capacity.Int64())
+			assert.EqualValues(t, 80, cc.AdjustedCapacity.Int64())
+			assert.EqualValues(t, 50, cc.PartnerCapacity.Int64())
+			assert.EqualValues(t, 50, cc.PartnerAdjustedCapacity.Int64())
+		case c:
+			assert.EqualValues(t, 0, cc.Capacity.Int64())
+			assert.EqualValues(t, 0, cc.PartnerCapacity.Int64())
+		default:
+			t.Errorf("unexpected partner %s", cc.PartnerAddress.String())
+		}
+	}
+	assert.Nil(t, report.VerifySignature())
+	report.Channels[0].Capacity = big.NewInt(1000)
+	assert.NotNil(t, report.VerifySignature())
+}
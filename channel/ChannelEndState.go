@@ -29,6 +29,7 @@ type EndState struct {
 	Lock2UnclaimedLocks map[common.Hash]channeltype.UnlockPartialProof
 	Tree                *mtree.Merkletree
 	BalanceProofState   *transfer.BalanceProofState //race codition with Photonapi
+	DepositBlockNumber  int64                       //合约中的押金最后一次增加所在的块,0表示打开通道以后没有存过款
 }
 
 //NewChannelEndState create EndState
@@ -31,6 +31,7 @@ type ExternalState struct {
 	TokenNetwork                   *rpc.TokenNetworkProxy
 	auth                           *bind.TransactOpts
 	privKey                        *ecdsa.PrivateKey
+	signer                         utils.Signer
 	Client                         *helper.SafeEthClient
 	ClosedBlock                    int64 //通道被强制关闭的block,
 	SettledBlock                   int64 //初始为0,通道被强制关闭以后则是可以进行settle的块数,通道被settle以后,则是通道被settle的块数
@@ -48,6 +49,7 @@ func NewChannelExternalState(fun FuncRegisterChannelForHashlock,
 		TokenNetwork:                   tokenNetwork,
 		auth:                           bind.NewKeyedTransactor(privkey),
 		privKey:                        privkey,
+		signer:                         utils.NewPrivateKeySigner(privkey),
 		Client:                         client,
 		ChannelIdentifier:              *channelIdentifier,
 		db:                             db,
@@ -59,6 +61,11 @@ func NewChannelExternalState(fun FuncRegisterChannelForHashlock,
 	return cs
 }
 
+//SetSigner sign messages with an external signer instead of privKey
+func (e *ExternalState) SetSigner(signer utils.Signer) {
+	e.signer = signer
+}
+
 //SetClosed set the closed blocknubmer of this channel
 func (e *ExternalState) SetClosed(blocknumber int64) bool {
 	if e.ClosedBlock != 0 {
@@ -32,6 +32,7 @@ type Channel struct {
 	feeCharger        fee.Charger //calc fee for each transfer?
 	State             channeltype.State
 	DelegateState     channeltype.ChannelDelegateState
+	MaxPendingLocks   int //每一方未解锁的锁的数量上限,0表示不限制
 }
 
 /*
@@ -70,6 +71,34 @@ func (c *Channel) Distributable() *big.Int {
 	return c.OurState.Distributable(c.PartnerState)
 }
 
+/*
+Liquidity returns the current balances of both sides,for liquidity-aware fee policy
+*/
+func (c *Channel) Liquidity() *fee.ChannelLiquidity {
+	return &fee.ChannelLiquidity{
+		ChannelIdentifier: c.ChannelIdentifier.ChannelIdentifier,
+		OurBalance:        c.Distributable(),
+		PartnerBalance:    c.PartnerState.Distributable(c.OurState),
+		Capacity:          new(big.Int).Add(c.OurState.ContractBalance, c.PartnerState.ContractBalance),
+	}
+}
+
+/*
+CheckPendingLocksLimit es 在通道中再增加一个锁是否会超过 MaxPendingLocks,
+已知密码但是尚未 unlock 的锁也在 merkle tree 中,一样计算在内
+*/
+func (c *Channel) CheckPendingLocksLimit(es *EndState) error {
+	if c.MaxPendingLocks <= 0 {
+		return nil
+	}
+	n := len(es.Lock2PendingLocks) + len(es.Lock2UnclaimedLocks)
+	if n >= c.MaxPendingLocks {
+		return rerr.ErrTooManyLocks.Printf("%s has %d pending locks on channel %s,limit %d",
+			utils.APex2(es.Address), n, c.ChannelIdentifier.String(), c.MaxPendingLocks)
+	}
+	return nil
+}
+
 /*
 CanTransfer  a closed channel and has no Balance channel cannot
 transfer tokens to partner.
@@ -225,7 +254,7 @@ func (c *Channel) HandleChannelPunished(beneficiaries common.Address) {
 HandleClosed handles this channel was closed on blockchain
 1. 更新NonClosing 一方的 ContractTransferAmount 和 LocksRoot,
 2. 对方可能用旧的BalanceProof, 所以未必与我保存的 TransferAmount 和 LocksRoot一致
-3. 如果我不是关闭方,那么需要更新对方的 BalanceProof
+3. 如果我不是关闭方,对方的 BalanceProof 由调用者比较合约上的 nonce 以后提交,或者由用户自己调用 UpdateBalanceProof
 4. 我持有的知道密码的锁,需要解锁.
 */
 /*
@@ -233,16 +262,14 @@ HandleClosed handles this channel was closed on blockchain
  *
  *		1. Update ContractTransferAmount & LocksRoot of the non-closing participant.
  *		2. That participant may submit used BalanceProof, in which TransferAmount & LocksRoot are not consistent with mine.
- *		3. If I am not the non-closing participant, then update the BalanceProof of my channel partner.
+ *		3. If I am not the closing participant, the BalanceProof of my channel partner is submitted by the caller or by the user.
  *		4. All locks I am holding that have known secrets must be unlocked.
  */
 func (c *Channel) HandleClosed(closingAddress common.Address, transferredAmount *big.Int, locksRoot common.Hash) {
 	endStateUpdatedOnContract := c.PartnerState
-	balanceProof := c.PartnerState.BalanceProofState
 	//依据合约上保存的 ContractTransferAmount 以及 LocksRoot 来更新我本地的
 	//the channel was closed, update our half of the state if we need to
 	if closingAddress != c.OurState.Address {
-		c.ExternState.UpdateTransfer(balanceProof)
 		endStateUpdatedOnContract = c.OurState
 	}
 	endStateUpdatedOnContract.SetContractTransferAmount(transferredAmount)
@@ -801,6 +828,9 @@ func (c *Channel) CreateMediatedTransfer(initiator, target common.Address, fee *
 		log.Info(fmt.Sprintf("Insufficient funds  amount=%s,Distributable=%s", amount, c.Distributable()))
 		return nil, rerr.ErrInsufficientBalance
 	}
+	if err = c.CheckPendingLocksLimit(c.OurState); err != nil {
+		return nil, err
+	}
 	from := c.OurState
 	lock := &mtree.Lock{
 		Amount:         amount,
@@ -950,10 +980,11 @@ func (c *Channel) CreateWithdrawRequest(withdrawAmount *big.Int) (w *encoding.Wi
 		所以只要有一方持有锁,对于通道金额有争议,都不能发起 withdraw
 	*/
 	if len(c.OurState.Lock2PendingLocks) > 0 ||
-		len(c.OurState.Lock2PendingLocks) > 0 ||
+		len(c.OurState.Lock2UnclaimedLocks) > 0 ||
 		len(c.PartnerState.Lock2PendingLocks) > 0 ||
 		len(c.PartnerState.Lock2UnclaimedLocks) > 0 {
 		err = rerr.ErrChannelWithdrawButHasLocks
+		return
 	}
 	d := new(encoding.WithdrawRequestData)
 	d.ChannelIdentifier = c.ChannelIdentifier.ChannelIdentifier
@@ -963,7 +994,7 @@ func (c *Channel) CreateWithdrawRequest(withdrawAmount *big.Int) (w *encoding.Wi
 	d.Participant1Balance = c.OurState.Balance(c.PartnerState)
 	d.Participant1Withdraw = withdrawAmount
 	if withdrawAmount.Cmp(d.Participant1Balance) > 0 {
-		err = rerr.ErrChannelWithdrawAmount.Errorf("withdraw amount too large,current=%s,withdraw=%s", w.Participant1Balance, withdrawAmount)
+		err = rerr.ErrChannelWithdrawAmount.Errorf("withdraw amount too large,current=%s,withdraw=%s", d.Participant1Balance, withdrawAmount)
 		return
 	}
 	w = encoding.NewWithdrawRequest(d)
@@ -1080,7 +1111,7 @@ CreateWithdrawResponse :
  */
 func (c *Channel) CreateWithdrawResponse(req *encoding.WithdrawRequest) (w *encoding.WithdrawResponse, err error) {
 	if len(c.OurState.Lock2PendingLocks) > 0 ||
-		len(c.OurState.Lock2PendingLocks) > 0 {
+		len(c.OurState.Lock2UnclaimedLocks) > 0 {
 		log.Warn(fmt.Sprintf("CreateWithdrawResponse ,but i'm sending transfer on road,these transfer should canceled immediately"))
 	}
 	if len(c.PartnerState.Lock2PendingLocks) > 0 ||
@@ -1143,6 +1174,21 @@ CreateCooperativeSettleRequest 一定要不持有任何锁,否则双方可能对
  *	Note that there should be no lock, or both participants may have conflict with token allocation.
  */
 func (c *Channel) CreateCooperativeSettleRequest() (s *encoding.SettleRequest, err error) {
+	wd := new(encoding.SettleRequestData)
+	wd.ChannelIdentifier = c.ChannelIdentifier.ChannelIdentifier
+	wd.OpenBlockNumber = c.ChannelIdentifier.OpenBlockNumber
+	wd.Participant1 = c.OurState.Address
+	wd.Participant2 = c.PartnerState.Address
+	wd.Participant1Balance, wd.Participant2Balance, err = c.CooperativeSettleBalances()
+	s = encoding.NewSettleRequest(wd)
+	return
+}
+
+/*
+CooperativeSettleBalances 现在 cooperative settle 的话双方各自能拿到的金额,不会修改通道状态.
+持有锁时返回错误,但是金额仍然有效
+*/
+func (c *Channel) CooperativeSettleBalances() (ourBalance, partnerBalance *big.Int, err error) {
 	/*
 		SettleRequest 一旦发出去就只能关闭通道
 		无论是通过 cooperative settle 成功,造成通道关闭重开
@@ -1157,19 +1203,13 @@ func (c *Channel) CreateCooperativeSettleRequest() (s *encoding.SettleRequest, e
 	 *	they can not do cooperativesettle.
 	 */
 	if len(c.OurState.Lock2PendingLocks) > 0 ||
-		len(c.OurState.Lock2PendingLocks) > 0 ||
+		len(c.OurState.Lock2UnclaimedLocks) > 0 ||
 		len(c.PartnerState.Lock2PendingLocks) > 0 ||
 		len(c.PartnerState.Lock2UnclaimedLocks) > 0 {
 		err = rerr.ErrChannelCooperativeSettleButHasLocks
 	}
-	wd := new(encoding.SettleRequestData)
-	wd.ChannelIdentifier = c.ChannelIdentifier.ChannelIdentifier
-	wd.OpenBlockNumber = c.ChannelIdentifier.OpenBlockNumber
-	wd.Participant1 = c.OurState.Address
-	wd.Participant2 = c.PartnerState.Address
-	wd.Participant1Balance = c.OurState.Balance(c.PartnerState)
-	wd.Participant2Balance = c.PartnerState.Balance(c.OurState)
-	s = encoding.NewSettleRequest(wd)
+	ourBalance = c.OurState.Balance(c.PartnerState)
+	partnerBalance = c.PartnerState.Balance(c.OurState)
 	return
 }
 
@@ -1327,7 +1367,7 @@ CanWithdrawOrCooperativeSettle 只有在任何锁的情况下才能进行 withdr
  */
 func (c *Channel) CanWithdrawOrCooperativeSettle() bool {
 	if len(c.OurState.Lock2PendingLocks) > 0 ||
-		len(c.OurState.Lock2PendingLocks) > 0 ||
+		len(c.OurState.Lock2UnclaimedLocks) > 0 ||
 		len(c.PartnerState.Lock2PendingLocks) > 0 ||
 		len(c.PartnerState.Lock2UnclaimedLocks) > 0 {
 		return false
@@ -1420,9 +1460,13 @@ func (c *Channel) Settle(blockNumber int64) (err error) {
 }
 
 //GetNeedRegisterSecrets find all secres need to reveal on secret
+//对方还没有unlock,并且锁在RevealTimeout之内就要过期的,必须在过期之前到链上注册密码
 func (c *Channel) GetNeedRegisterSecrets(blockNumber int64) (secrets []common.Hash) {
 	for _, l := range c.PartnerState.Lock2UnclaimedLocks {
-		if l.Lock.Expiration > blockNumber-int64(c.RevealTimeout) && l.Lock.Expiration < blockNumber {
+		if l.IsRegisteredOnChain {
+			continue
+		}
+		if blockNumber >= l.Lock.Expiration-int64(c.RevealTimeout) && blockNumber < l.Lock.Expiration {
 			//底层负责处理重复的问题
 			// lower layer takes charge of handling issues that repeatitively happen.
 			secrets = append(secrets, l.Secret)
@@ -1442,11 +1486,11 @@ CooperativeSettleChannel 收到对方的 settle response, 关闭通道即可.
 func (c *Channel) CooperativeSettleChannel(res *encoding.SettleResponse) (result *utils.AsyncResult) {
 	w, err := c.CreateCooperativeSettleRequest()
 	if err != nil {
-		panic(err)
+		return utils.NewAsyncResultWithError(err)
 	}
-	err = w.Sign(c.ExternState.privKey, w)
+	err = w.SignBy(c.ExternState.signer, w)
 	if err != nil {
-		panic(err)
+		return utils.NewAsyncResultWithError(err)
 	}
 	return c.ExternState.TokenNetwork.CooperativeSettleAsync(
 		res.Participant1, res.Participant2,
@@ -1483,11 +1527,11 @@ func (c *Channel) Withdraw(res *encoding.WithdrawResponse) (result *utils.AsyncR
 	// No record, need to re-write signature.
 	w, err := c.CreateWithdrawRequest(res.Participant1Withdraw)
 	if err != nil {
-		panic(err)
+		return utils.NewAsyncResultWithError(err)
 	}
-	err = w.Sign(c.ExternState.privKey, w)
+	err = w.SignBy(c.ExternState.signer, w)
 	if err != nil {
-		panic(err)
+		return utils.NewAsyncResultWithError(err)
 	}
 	return c.ExternState.TokenNetwork.WithdrawAsync(
 		res.Participant1, res.Participant2,
@@ -1537,6 +1581,9 @@ func NewChannelSerialization(c *Channel) *channeltype.Serialization {
 		PartnerContractBalance: c.PartnerState.ContractBalance,
 		ClosedBlock:            c.ExternState.ClosedBlock,
 		SettledBlock:           c.ExternState.SettledBlock,
+
+		OurDepositBlockNumber:     c.OurState.DepositBlockNumber,
+		PartnerDepositBlockNumber: c.PartnerState.DepositBlockNumber,
 	}
 	return s
 }
@@ -1087,3 +1087,84 @@ func TestChannel_RegisterCooperativeSettleRequest(t *testing.T) {
 	//	return
 	//}
 }
+
+func TestGetNeedRegisterSecrets(t *testing.T) {
+	ch, _ := makePairChannel()
+	secret := utils.NewRandomHash()
+	lock := &mtree.Lock{
+		Expiration:     100,
+		Amount:         big.NewInt(1),
+		LockSecretHash: utils.ShaSecret(secret[:]),
+	}
+	ch.PartnerState.Lock2UnclaimedLocks[lock.LockSecretHash] = channeltype.UnlockPartialProof{
+		Lock:     lock,
+		LockHash: lock.Hash(),
+		Secret:   secret,
+	}
+	assert.Len(t, ch.GetNeedRegisterSecrets(lock.Expiration-int64(ch.RevealTimeout)-1), 0)
+	assert.EqualValues(t, []common.Hash{secret}, ch.GetNeedRegisterSecrets(lock.Expiration-int64(ch.RevealTimeout)))
+	assert.EqualValues(t, []common.Hash{secret}, ch.GetNeedRegisterSecrets(lock.Expiration-1))
+	//过期以后注册也没用了
+	assert.Len(t, ch.GetNeedRegisterSecrets(lock.Expiration), 0)
+}
+
+func TestChannel_MaxPendingLocks(t *testing.T) {
+	tokenAddress := utils.NewRandomAddress()
+	privkey1, address1 := utils.MakePrivateKeyAddress()
+	address2 := utils.NewRandomAddress()
+	var blockNumber int64 = 10
+	ourState := NewChannelEndState(address1, big.NewInt(100), nil, mtree.EmptyTree)
+	partnerState := NewChannelEndState(address2, big.NewInt(100), nil, mtree.EmptyTree)
+	externState := makeExternState()
+	testChannel, _ := NewChannel(ourState, partnerState, externState, tokenAddress, &externState.ChannelIdentifier, 5, 15)
+	testChannel.MaxPendingLocks = 2
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, testChannel.CheckPendingLocksLimit(testChannel.OurState))
+		tr, err := testChannel.CreateMediatedTransfer(address1, address2, utils.BigInt0, big10, blockNumber+15, utils.NewRandomHash(), []common.Address{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr.Sign(privkey1, tr)
+		err = testChannel.RegisterTransfer(blockNumber, tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := testChannel.CreateMediatedTransfer(address1, address2, utils.BigInt0, big10, blockNumber+15, utils.NewRandomHash(), []common.Address{})
+	if assert.Error(t, err) {
+		assert.Equal(t, rerr.ErrTooManyLocks.ErrorCode, err.(rerr.StandardError).ErrorCode)
+		assert.Contains(t, err.Error(), "2 pending locks")
+	}
+	//对方没有锁,不受我方锁数量的影响
+	assert.Nil(t, testChannel.CheckPendingLocksLimit(testChannel.PartnerState))
+	testChannel.MaxPendingLocks = 0
+	assert.Nil(t, testChannel.CheckPendingLocksLimit(testChannel.OurState))
+}
+
+//我方只有 unclaimed 锁时,同样不能 withdraw 或者合作关闭
+func TestWithdrawWithOurUnclaimedLock(t *testing.T) {
+	ch, _ := makePairChannel()
+	lock := &mtree.Lock{Expiration: 100, Amount: big.NewInt(1), LockSecretHash: utils.NewRandomHash()}
+	assert.True(t, ch.CanWithdrawOrCooperativeSettle())
+	ch.OurState.Lock2UnclaimedLocks[lock.LockSecretHash] = channeltype.UnlockPartialProof{
+		Lock:     lock,
+		LockHash: lock.Hash(),
+	}
+	assert.False(t, ch.CanWithdrawOrCooperativeSettle())
+	_, err := ch.CreateWithdrawRequest(big.NewInt(1))
+	if assert.Error(t, err) {
+		assert.Equal(t, rerr.ErrChannelWithdrawButHasLocks.ErrorCode, err.(rerr.StandardError).ErrorCode)
+	}
+	_, _, err = ch.CooperativeSettleBalances()
+	if assert.Error(t, err) {
+		assert.Equal(t, rerr.ErrChannelCooperativeSettleButHasLocks.ErrorCode, err.(rerr.StandardError).ErrorCode)
+	}
+	delete(ch.OurState.Lock2UnclaimedLocks, lock.LockSecretHash)
+	assert.True(t, ch.CanWithdrawOrCooperativeSettle())
+	_, err = ch.CreateWithdrawRequest(big.NewInt(1))
+	assert.Nil(t, err)
+	_, err = ch.CreateWithdrawRequest(big.NewInt(1000))
+	if assert.Error(t, err) {
+		assert.Equal(t, rerr.ErrChannelWithdrawAmount.ErrorCode, err.(rerr.StandardError).ErrorCode)
+	}
+}
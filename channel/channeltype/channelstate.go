@@ -104,6 +104,32 @@ func init() {
 	CanDealUnlock[StatePrepareForWithdraw] = true
 }
 
+/*
+Order 通道生命周期中的先后顺序:打开,withdraw/合作关闭/正在关闭这些中间状态,关闭,正在结算,结算.
+用来判断一个状态是否已经到达或者经过另一个状态,比如 settled 在 closed 之后.
+*/
+func (s State) Order() int {
+	switch s {
+	case StateInValid:
+		return 0
+	case StateOpened:
+		return 1
+	case StateClosed:
+		return 3
+	case StateSettling:
+		return 4
+	case StateSettled:
+		return 5
+	default:
+		return 2
+	}
+}
+
+//Reached returns true if s is target or after target in the channel life cycle
+func (s State) Reached(target State) bool {
+	return s.Order() >= target.Order()
+}
+
 func (s State) String() string {
 	switch s {
 	case StateInValid:
@@ -65,6 +65,9 @@ type Serialization struct {
 	SettledBlock           int64
 	SettleTimeout          int
 	UpdateAt               int64 // 保存最后一次更新的时间戳
+	//双方最后一次存款所在的块,见 EndState.DepositBlockNumber
+	OurDepositBlockNumber     int64
+	PartnerDepositBlockNumber int64
 }
 
 //NewEmptySerialization contstructs empty serialization to avoid panic
@@ -41,6 +41,11 @@ func (dao *FakeTXINfoDao) GetTXInfoList(channelIdentifier common.Hash, openBlock
 	return
 }
 
+// GetTXInfoListPackedSince :
+func (dao *FakeTXINfoDao) GetTXInfoListPackedSince(blockNumber int64) (list []*models.TXInfo, err error) {
+	return
+}
+
 func newTestBlockChainService() *rpc.BlockChainService {
 	conn, err := helper.NewSafeClient(rpc.TestRPCEndpoint)
 	if err != nil {
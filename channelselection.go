@@ -0,0 +1,113 @@
+package photon
+
+import (
+	"bytes"
+	"math/big"
+	"sort"
+	"sync"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/network/graph"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+type directChannelKey struct {
+	token  common.Address
+	target common.Address
+}
+
+/*
+directChannelRoundRobin 记录每个接收方上一次 DirectTransfer 使用的通道,
+checkDirectTransfer 也会在查询可达节点时调用,不一定在主线程,所以需要锁保护.
+零值可以直接使用
+*/
+type directChannelRoundRobin struct {
+	lock sync.Mutex
+	last map[directChannelKey]common.Hash
+}
+
+func (r *directChannelRoundRobin) get(key directChannelKey) common.Hash {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.last[key]
+}
+
+func (r *directChannelRoundRobin) set(key directChannelKey, channelIdentifier common.Hash) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.last == nil {
+		r.last = make(map[directChannelKey]common.Hash)
+	}
+	r.last[key] = channelIdentifier
+}
+
+/*
+directChannelCandidates 和 target 之间所有可以交易的通道,按通道标识排序.
+ChannelSelectionDefault 只使用通道图按节点记录的那一个通道,和只允许一个通道时的行为一致
+*/
+func (rs *Service) directChannelCandidates(g *graph.ChannelGraph, target common.Address) (cs []*channel.Channel) {
+	if rs.Config.DirectChannelSelection == params.ChannelSelectionDefault {
+		if c := g.GetPartenerAddress2Channel(target); c != nil && c.CanTransfer() {
+			cs = append(cs, c)
+		}
+		return
+	}
+	for _, c := range g.ChannelIdentifier2Channel {
+		if c.PartnerState.Address == target && c.CanTransfer() {
+			cs = append(cs, c)
+		}
+	}
+	sort.Slice(cs, func(i, j int) bool {
+		return bytes.Compare(cs[i].ChannelIdentifier.ChannelIdentifier[:], cs[j].ChannelIdentifier.ChannelIdentifier[:]) < 0
+	})
+	return
+}
+
+/*
+selectDirectChannel 按照 Config.DirectChannelSelection 从余额足够的通道中选择一个,cs 不能为空
+*/
+func (rs *Service) selectDirectChannel(tokenAddress, target common.Address, amount *big.Int, cs []*channel.Channel) *channel.Channel {
+	selected := cs[0]
+	switch rs.Config.DirectChannelSelection {
+	case params.ChannelSelectionMostBalance:
+		for _, c := range cs[1:] {
+			if c.Distributable().Cmp(selected.Distributable()) > 0 {
+				selected = c
+			}
+		}
+	case params.ChannelSelectionMostBalanced:
+		minDiff := balanceDiffAfterTransfer(selected, amount)
+		for _, c := range cs[1:] {
+			if diff := balanceDiffAfterTransfer(c, amount); diff.Cmp(minDiff) < 0 {
+				selected, minDiff = c, diff
+			}
+		}
+	case params.ChannelSelectionRoundRobin:
+		//使用排在上一次使用的通道后面的第一个通道,通道增减时也不会连续使用同一个通道
+		last := rs.directChannelRoundRobin.get(directChannelKey{tokenAddress, target})
+		for _, c := range cs {
+			if bytes.Compare(c.ChannelIdentifier.ChannelIdentifier[:], last[:]) > 0 {
+				selected = c
+				break
+			}
+		}
+	}
+	return selected
+}
+
+//balanceDiffAfterTransfer 转出 amount 以后双方余额之差的绝对值
+func balanceDiffAfterTransfer(c *channel.Channel, amount *big.Int) *big.Int {
+	diff := new(big.Int).Sub(c.Balance(), c.PartnerBalance())
+	diff.Sub(diff, amount)
+	diff.Sub(diff, amount)
+	return diff.Abs(diff)
+}
+
+//directChannelUsed 记录 DirectTransfer 实际使用的通道,供 ChannelSelectionRoundRobin 选择下一个
+func (rs *Service) directChannelUsed(tokenAddress, target common.Address, c *channel.Channel) {
+	if rs.Config.DirectChannelSelection != params.ChannelSelectionRoundRobin {
+		return
+	}
+	rs.directChannelRoundRobin.set(directChannelKey{tokenAddress, target}, c.ChannelIdentifier.ChannelIdentifier)
+}
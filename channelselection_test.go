@@ -0,0 +1,85 @@
+package photon
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/network/graph"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckDirectTransferChannelSelection(t *testing.T) {
+	our, partner := utils.NewRandomAddress(), utils.NewRandomAddress()
+	token := utils.NewRandomAddress()
+	g := graph.NewChannelGraph(our, token, nil)
+	newChannel := func(id byte, ourBalance, partnerBalance int64) *channel.Channel {
+		ourState := channel.NewChannelEndState(our, big.NewInt(ourBalance), nil, mtree.EmptyTree)
+		partnerState := channel.NewChannelEndState(partner, big.NewInt(partnerBalance), nil, mtree.EmptyTree)
+		ch, err := channel.NewChannel(ourState, partnerState, &channel.ExternalState{}, token,
+			&contracts.ChannelUniqueID{ChannelIdentifier: common.Hash{id}, OpenBlockNumber: 3}, 5, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Nil(t, g.AddChannel(ch))
+		return ch
+	}
+	//目前一对节点只有一个通道,这里直接在通道图中构造多个通道
+	small := newChannel(1, 20, 0)
+	rich := newChannel(2, 100, 200)
+	balanced := newChannel(3, 60, 20)
+	rs := &Service{
+		NodeAddress:        our,
+		Config:             &params.Config{},
+		Token2ChannelGraph: map[common.Address]*graph.ChannelGraph{token: g},
+		Clock:              utils.NewRealClock(),
+		IsChainEffective:   true,
+	}
+
+	//默认只使用通道图按节点记录的通道,也就是最后加入的那个
+	c, err := rs.checkDirectTransfer(token, partner, big.NewInt(10))
+	assert.Nil(t, err)
+	assert.Equal(t, balanced, c)
+	_, err = rs.checkDirectTransfer(token, partner, big.NewInt(61))
+	assert.Equal(t, rerr.ErrChannelNoEnoughBalance, err)
+
+	rs.Config.DirectChannelSelection = params.ChannelSelectionMostBalance
+	c, err = rs.checkDirectTransfer(token, partner, big.NewInt(10))
+	assert.Nil(t, err)
+	assert.Equal(t, rich, c)
+
+	//rich 转出以后 100-10 和 200+10 差 120,balanced 是 50 和 30,small 是 10 和 10
+	rs.Config.DirectChannelSelection = params.ChannelSelectionMostBalanced
+	c, err = rs.checkDirectTransfer(token, partner, big.NewInt(10))
+	assert.Nil(t, err)
+	assert.Equal(t, small, c)
+	//余额不够的通道不参与选择
+	c, err = rs.checkDirectTransfer(token, partner, big.NewInt(30))
+	assert.Nil(t, err)
+	assert.Equal(t, balanced, c)
+
+	rs.Config.DirectChannelSelection = params.ChannelSelectionRoundRobin
+	var used []*channel.Channel
+	for i := 0; i < 4; i++ {
+		c, err = rs.checkDirectTransfer(token, partner, big.NewInt(10))
+		assert.Nil(t, err)
+		rs.directChannelUsed(token, partner, c)
+		used = append(used, c)
+	}
+	assert.Equal(t, []*channel.Channel{small, rich, balanced, small}, used)
+	//只查询不记录时不会轮换
+	c, err = rs.checkDirectTransfer(token, partner, big.NewInt(10))
+	assert.Nil(t, err)
+	assert.Equal(t, rich, c)
+	c, err = rs.checkDirectTransfer(token, partner, big.NewInt(50))
+	assert.Nil(t, err)
+	assert.Equal(t, rich, c)
+	_, err = rs.checkDirectTransfer(token, partner, big.NewInt(101))
+	assert.Equal(t, rerr.ErrChannelNoEnoughBalance, err)
+}
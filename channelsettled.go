@@ -0,0 +1,61 @@
+package photon
+
+import (
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+ChannelSettledCallback 通道 settle 以后调用,finalBalance 是我方在通道中最终拿回的金额.
+在主线程中调用,不能阻塞.
+*/
+type ChannelSettledCallback func(channelIdentifier common.Hash, finalBalance *big.Int)
+
+/*
+channelSettledSubscribers 订阅和取消订阅可能发生在任意goroutine中,所以需要锁保护
+*/
+type channelSettledSubscribers struct {
+	lock sync.Mutex
+	subs map[*ChannelSettledCallback]bool
+}
+
+func newChannelSettledSubscribers() *channelSettledSubscribers {
+	return &channelSettledSubscribers{
+		subs: make(map[*ChannelSettledCallback]bool),
+	}
+}
+
+/*
+publish 不持有锁调用回调,回调中可以取消订阅
+*/
+func (s *channelSettledSubscribers) publish(channelIdentifier common.Hash, finalBalance *big.Int) {
+	s.lock.Lock()
+	var fs []ChannelSettledCallback
+	for f := range s.subs {
+		fs = append(fs, *f)
+	}
+	s.lock.Unlock()
+	for _, f := range fs {
+		f(channelIdentifier, new(big.Int).Set(finalBalance))
+	}
+}
+
+/*
+OnChannelSettled 通道 settle(包括合作 settle)并且数据库已经更新以后调用 f,
+钱包可以据此更新余额并归档通道.
+调用返回的 unsubscribe 以后不再通知.
+*/
+func (rs *Service) OnChannelSettled(f ChannelSettledCallback) (unsubscribe func()) {
+	s := rs.channelSettledSubscribers
+	p := &f
+	s.lock.Lock()
+	s.subs[p] = true
+	s.lock.Unlock()
+	return func() {
+		s.lock.Lock()
+		delete(s.subs, p)
+		s.lock.Unlock()
+	}
+}
@@ -0,0 +1,34 @@
+package photon
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOnChannelSettled(t *testing.T) {
+	rs := &Service{channelSettledSubscribers: newChannelSettledSubscribers()}
+	var got []common.Hash
+	var balance *big.Int
+	unsubscribe := rs.OnChannelSettled(func(channelIdentifier common.Hash, finalBalance *big.Int) {
+		got = append(got, channelIdentifier)
+		balance = finalBalance
+	})
+	c1, c2 := utils.NewRandomHash(), utils.NewRandomHash()
+	rs.channelSettledSubscribers.publish(c1, big.NewInt(10))
+	assert.Equal(t, []common.Hash{c1}, got)
+	assert.EqualValues(t, 10, balance.Int64())
+	unsubscribe()
+	rs.channelSettledSubscribers.publish(c2, big.NewInt(20))
+	assert.Equal(t, []common.Hash{c1}, got)
+	//回调中取消订阅不会死锁
+	var unsubscribe2 func()
+	unsubscribe2 = rs.OnChannelSettled(func(channelIdentifier common.Hash, finalBalance *big.Int) {
+		unsubscribe2()
+	})
+	rs.channelSettledSubscribers.publish(c2, big.NewInt(20))
+	assert.Empty(t, rs.channelSettledSubscribers.subs)
+}
@@ -0,0 +1,24 @@
+package photon
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+GetChannelStats 通道上成功交易的统计,包括发出,收到以及作为中间节点转发的笔数,以及发出和收到的金额.
+统计保存在数据库中,重启以后依然有效,没有任何记录的通道返回全 0.
+*/
+func (rs *Service) GetChannelStats(channelAddress common.Hash) (sentCount, recvCount, mediatedCount int, sentVolume, recvVolume *big.Int, err error) {
+	s, err := rs.dao.GetChannelStats(channelAddress)
+	if err != nil {
+		return
+	}
+	return s.SentCount, s.RecvCount, s.MediatedCount, s.SentVolume, s.RecvVolume, nil
+}
+
+//ResetChannelStats 清零通道上的交易统计
+func (rs *Service) ResetChannelStats(channelAddress common.Hash) error {
+	return rs.dao.ResetChannelStats(channelAddress)
+}
@@ -0,0 +1,42 @@
+package photon
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelStatsMediated(t *testing.T) {
+	rs := &Service{dao: codefortest.NewTestDB("")}
+	defer rs.dao.CloseDB()
+	eh := newStateMachineEventHandler(rs)
+	payer, payee := utils.NewRandomHash(), utils.NewRandomHash()
+	//发起方和接收方的 EventUnlockSuccess 不计入中转
+	err := eh.OnEvent(&mediatedtransfer.EventUnlockSuccess{LockSecretHash: utils.NewRandomHash()}, nil)
+	assert.Nil(t, err)
+	err = eh.OnEvent(&mediatedtransfer.EventUnlockSuccess{
+		LockSecretHash:         utils.NewRandomHash(),
+		PayerChannelIdentifier: payer,
+		PayeeChannelIdentifier: payee,
+		Amount:                 big.NewInt(10),
+	}, nil)
+	assert.Nil(t, err)
+	_, _, mediatedCount, _, _, err := rs.GetChannelStats(payer)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, mediatedCount)
+	sentCount, recvCount, mediatedCount, sentVolume, recvVolume, err := rs.GetChannelStats(payee)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0, sentCount)
+	assert.EqualValues(t, 0, recvCount)
+	assert.EqualValues(t, 1, mediatedCount)
+	assert.EqualValues(t, 0, sentVolume.Int64())
+	assert.EqualValues(t, 0, recvVolume.Int64())
+	assert.Nil(t, rs.ResetChannelStats(payee))
+	_, _, mediatedCount, _, _, err = rs.GetChannelStats(payee)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0, mediatedCount)
+}
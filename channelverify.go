@@ -0,0 +1,99 @@
+package photon
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/network/rpc"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//ChannelChainCheck 本地通道和合约记录的对比结果
+type ChannelChainCheck struct {
+	ChannelIdentifier common.Hash    `json:"channel_identifier"`
+	PartnerAddress    common.Address `json:"partner_address"`
+	OurDeposit        *big.Int       `json:"our_deposit"`     // 合约中我的押金
+	PartnerDeposit    *big.Int       `json:"partner_deposit"` // 合约中对方的押金
+	OurNonce          uint64         `json:"our_nonce"`       // 合约中我的 balance proof nonce
+	PartnerNonce      uint64         `json:"partner_nonce"`   // 合约中对方的 balance proof nonce
+	Consistent        bool           `json:"consistent"`
+	Reason            string         `json:"reason,omitempty"`
+}
+
+/*
+VerifyChannelsOnChain 把 tokenAddress 的所有本地通道和合约中的记录对比,
+押金不同或者合约中的 balance proof 比本地的新,都说明本地数据需要用 RepairChannelFromChain 修复.
+每个通道需要查询双方的信息,通道很多时逐个查询太慢,所以用 GetChannelParticipantInfos 批量查询.
+已经 settle 的通道在合约中的记录已经删除,不参与对比.
+只读取数据库和公链,可以在任意线程中调用
+*/
+func (rs *Service) VerifyChannelsOnChain(tokenAddress common.Address) (checks []*ChannelChainCheck, err error) {
+	all, err := rs.dao.GetChannelList(tokenAddress, utils.EmptyAddress)
+	if err != nil {
+		return
+	}
+	var cs []*channeltype.Serialization
+	for _, c := range all {
+		if c.State != channeltype.StateSettled {
+			cs = append(cs, c)
+		}
+	}
+	if len(cs) == 0 {
+		return
+	}
+	tokenNetwork, err := rs.Chain.TokenNetwork(tokenAddress)
+	if err != nil {
+		return
+	}
+	queries := make([]rpc.ChannelParticipantQuery, 0, len(cs)*2)
+	for _, c := range cs {
+		queries = append(queries,
+			rpc.ChannelParticipantQuery{Participant: c.OurAddress, Partner: c.PartnerAddress()},
+			rpc.ChannelParticipantQuery{Participant: c.PartnerAddress(), Partner: c.OurAddress},
+		)
+	}
+	infos := tokenNetwork.GetChannelParticipantInfos(queries, rpc.DefaultQueryConcurrency)
+	for i, c := range cs {
+		ourInfo, partnerInfo := infos[2*i], infos[2*i+1]
+		if ourInfo.Err != nil {
+			return nil, rerr.ErrContractQueryError.Errorf("GetChannelParticipantInfo err %s", ourInfo.Err)
+		}
+		if partnerInfo.Err != nil {
+			return nil, rerr.ErrContractQueryError.Errorf("GetChannelParticipantInfo err %s", partnerInfo.Err)
+		}
+		check := compareChannelWithChain(c, ourInfo, partnerInfo)
+		if !check.Consistent {
+			log.Warn(fmt.Sprintf("channel %s not consistent with chain: %s", utils.HPex(check.ChannelIdentifier), check.Reason))
+		}
+		checks = append(checks, check)
+	}
+	return
+}
+
+//compareChannelWithChain 合约中通道双方的信息是否和本地一致
+func compareChannelWithChain(c *channeltype.Serialization, ourInfo, partnerInfo *rpc.ChannelParticipantInfo) *ChannelChainCheck {
+	check := &ChannelChainCheck{
+		ChannelIdentifier: c.ChannelIdentifier.ChannelIdentifier,
+		PartnerAddress:    c.PartnerAddress(),
+		OurDeposit:        ourInfo.Deposit,
+		PartnerDeposit:    partnerInfo.Deposit,
+		OurNonce:          ourInfo.Nonce,
+		PartnerNonce:      partnerInfo.Nonce,
+	}
+	switch {
+	case c.OurContractBalance.Cmp(ourInfo.Deposit) != 0:
+		check.Reason = fmt.Sprintf("our deposit local %s,chain %s", c.OurContractBalance, ourInfo.Deposit)
+	case c.PartnerContractBalance.Cmp(partnerInfo.Deposit) != 0:
+		check.Reason = fmt.Sprintf("partner deposit local %s,chain %s", c.PartnerContractBalance, partnerInfo.Deposit)
+	case c.OurBalanceProof != nil && ourInfo.Nonce > c.OurBalanceProof.Nonce:
+		check.Reason = fmt.Sprintf("our nonce local %d,chain %d", c.OurBalanceProof.Nonce, ourInfo.Nonce)
+	case c.PartnerBalanceProof != nil && partnerInfo.Nonce > c.PartnerBalanceProof.Nonce:
+		check.Reason = fmt.Sprintf("partner nonce local %d,chain %d", c.PartnerBalanceProof.Nonce, partnerInfo.Nonce)
+	}
+	check.Consistent = check.Reason == ""
+	return check
+}
@@ -0,0 +1,65 @@
+package photon
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/network/rpc"
+	"github.com/SmartMeshFoundation/Photon/transfer"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareChannelWithChain(t *testing.T) {
+	c := channeltype.NewEmptySerialization()
+	c.ChannelIdentifier.ChannelIdentifier = utils.NewRandomHash()
+	c.PartnerAddressBytes = utils.NewRandomAddress().Bytes()
+	c.OurContractBalance = big.NewInt(100)
+	c.PartnerContractBalance = big.NewInt(50)
+	c.OurBalanceProof = &transfer.BalanceProofState{Nonce: 3, TransferAmount: big.NewInt(10)}
+	c.PartnerBalanceProof = &transfer.BalanceProofState{Nonce: 5, TransferAmount: big.NewInt(20)}
+
+	check := compareChannelWithChain(c,
+		&rpc.ChannelParticipantInfo{Deposit: big.NewInt(100)},
+		&rpc.ChannelParticipantInfo{Deposit: big.NewInt(50), Nonce: 5})
+	assert.True(t, check.Consistent, check.Reason)
+	assert.Equal(t, c.PartnerAddress(), check.PartnerAddress)
+	assert.EqualValues(t, 5, check.PartnerNonce)
+
+	//链上押金变了,比如对方存了钱而我没有收到事件
+	check = compareChannelWithChain(c,
+		&rpc.ChannelParticipantInfo{Deposit: big.NewInt(100)},
+		&rpc.ChannelParticipantInfo{Deposit: big.NewInt(80)})
+	assert.False(t, check.Consistent)
+	assert.Contains(t, check.Reason, "partner deposit")
+
+	//合约中的 balance proof 比本地的新
+	check = compareChannelWithChain(c,
+		&rpc.ChannelParticipantInfo{Deposit: big.NewInt(100), Nonce: 4},
+		&rpc.ChannelParticipantInfo{Deposit: big.NewInt(50)})
+	assert.False(t, check.Consistent)
+	assert.Contains(t, check.Reason, "our nonce")
+}
+
+//已经 settle 的通道合约中没有记录,不需要查询公链
+func TestVerifyChannelsOnChainSkipSettled(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	token := utils.NewRandomAddress()
+	c := channeltype.NewEmptySerialization()
+	c.ChannelIdentifier.ChannelIdentifier = utils.NewRandomHash()
+	c.Key = c.ChannelIdentifier.ChannelIdentifier[:]
+	c.TokenAddressBytes = token[:]
+	c.PartnerAddressBytes = utils.NewRandomAddress().Bytes()
+	c.OurContractBalance = big.NewInt(100)
+	c.PartnerContractBalance = big.NewInt(50)
+	c.State = channeltype.StateSettled
+	assert.Nil(t, dao.NewChannel(c))
+	//Chain 为 nil,如果查询公链会 panic
+	rs := &Service{dao: dao}
+	checks, err := rs.VerifyChannelsOnChain(token)
+	assert.Nil(t, err)
+	assert.Empty(t, checks)
+}
@@ -0,0 +1,81 @@
+package photon
+
+import (
+	"context"
+	"math/big"
+	"sync/atomic"
+
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
+	"github.com/SmartMeshFoundation/Photon/models/cb"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+WaitForChannelState 等待通道到达 target 状态,已经到达或者经过这个状态时立即返回,
+状态的先后顺序见 channeltype.State.Order.
+通过数据库的通道回调以及 OnChannelSettled 得到通知,不需要轮询,ctx 结束时返回 ctx.Err().
+不能在主线程中调用.
+*/
+func (rs *Service) WaitForChannelState(ctx context.Context, channelIdentifier common.Hash, target channeltype.State) error {
+	reached := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case reached <- struct{}{}:
+		default:
+		}
+	}
+	var done int32
+	//数据库的回调只能通过返回 true 移除,等待结束以后下次回调时移除
+	var f cb.ChannelCb = func(c *channeltype.Serialization) (remove bool) {
+		if atomic.LoadInt32(&done) == 1 {
+			return true
+		}
+		if c.ChannelIdentifier.ChannelIdentifier != channelIdentifier || !c.State.Reached(target) {
+			return false
+		}
+		notify()
+		return true
+	}
+	rs.dao.RegisterNewChannelCallback(f)
+	rs.dao.RegisterChannelStateCallback(f)
+	unsubscribe := rs.OnChannelSettled(func(id common.Hash, finalBalance *big.Int) {
+		if id == channelIdentifier && channeltype.State(channeltype.StateSettled).Reached(target) {
+			notify()
+		}
+	})
+	defer func() {
+		atomic.StoreInt32(&done, 1)
+		unsubscribe()
+	}()
+	//先注册再检查,避免错过检查和注册之间发生的状态变化
+	if rs.channelState(channelIdentifier).Reached(target) {
+		return nil
+	}
+	select {
+	case <-reached:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+/*
+channelState 从数据库中查询通道当前状态,已经 settle 的通道不在通道表中,
+通道还不存在时返回 StateInValid
+*/
+func (rs *Service) channelState(channelIdentifier common.Hash) channeltype.State {
+	c, err := rs.dao.GetChannelByAddress(channelIdentifier)
+	if err == nil {
+		return c.State
+	}
+	settled, err := rs.dao.GetAllSettledChannel()
+	if err != nil {
+		return channeltype.StateInValid
+	}
+	for _, c := range settled {
+		if c.ChannelIdentifier.ChannelIdentifier == channelIdentifier {
+			return channeltype.StateSettled
+		}
+	}
+	return channeltype.StateInValid
+}
@@ -0,0 +1,75 @@
+package photon
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateReached(t *testing.T) {
+	assert.True(t, channeltype.State(channeltype.StateSettled).Reached(channeltype.StateClosed))
+	assert.True(t, channeltype.State(channeltype.StateClosed).Reached(channeltype.StateOpened))
+	assert.True(t, channeltype.State(channeltype.StateClosed).Reached(channeltype.StateClosed))
+	assert.False(t, channeltype.State(channeltype.StateOpened).Reached(channeltype.StateClosed))
+	assert.False(t, channeltype.State(channeltype.StateClosing).Reached(channeltype.StateClosed))
+}
+
+func TestWaitForChannelState(t *testing.T) {
+	dbPath := path.Join(os.TempDir(), "testwaitchannel.db")
+	os.RemoveAll(dbPath)
+	os.RemoveAll(dbPath + ".lock")
+	dao := codefortest.NewTestDB(dbPath)
+	defer dao.CloseDB()
+	rs := &Service{dao: dao, channelSettledSubscribers: newChannelSettledSubscribers()}
+	token, partner := utils.NewRandomAddress(), utils.NewRandomAddress()
+	h := utils.NewRandomHash()
+	c := &channeltype.Serialization{
+		ChannelIdentifier: &contracts.ChannelUniqueID{
+			ChannelIdentifier: h,
+			OpenBlockNumber:   3,
+		},
+		Key:                 h[:],
+		TokenAddressBytes:   token[:],
+		PartnerAddressBytes: partner[:],
+		State:               channeltype.StateOpened,
+	}
+	//通道还不存在
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	assert.Equal(t, context.DeadlineExceeded, rs.WaitForChannelState(ctx, h, channeltype.StateOpened))
+	cancel()
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		assert.Nil(t, dao.NewChannel(c))
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	assert.Nil(t, rs.WaitForChannelState(ctx, h, channeltype.StateOpened))
+	cancel()
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		c.State = channeltype.StateClosed
+		assert.Nil(t, dao.UpdateChannelState(c))
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	assert.Nil(t, rs.WaitForChannelState(ctx, h, channeltype.StateClosed))
+	cancel()
+	//已经经过了 opened
+	assert.Nil(t, rs.WaitForChannelState(context.Background(), h, channeltype.StateOpened))
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		rs.channelSettledSubscribers.publish(h, utils.BigInt0)
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	assert.Nil(t, rs.WaitForChannelState(ctx, h, channeltype.StateSettled))
+	cancel()
+}
@@ -0,0 +1,78 @@
+//go:build debug
+// +build debug
+
+package photon
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/utils"
+)
+
+//chaosLock 保护 Config.ConditionQuit 中随机退出的参数,测试可以在运行过程中调整
+var chaosLock sync.Mutex
+
+/*
+SetChaosConfig 调整随机退出的概率以及每次随机等待的最长时间,只用于故障注入测试,
+probability 为0表示使用默认概率,只有使用 `-tags debug` 编译的版本才有这个函数.
+*/
+func (rs *Service) SetChaosConfig(probability float64, window time.Duration) error {
+	if probability < 0 || probability > 1 {
+		return rerr.ErrArgumentError.Printf("probability %f must be in [0,1]", probability)
+	}
+	if window < time.Millisecond {
+		return rerr.ErrArgumentError.Printf("window %s must be at least 1ms", window)
+	}
+	chaosLock.Lock()
+	rs.Config.ConditionQuit.RandomQuitProbability = probability
+	rs.Config.ConditionQuit.RandomQuitWindow = window
+	chaosLock.Unlock()
+	return nil
+}
+
+func (rs *Service) chaosConfig() (probability float64, window time.Duration) {
+	chaosLock.Lock()
+	defer chaosLock.Unlock()
+	probability = rs.Config.ConditionQuit.RandomQuitProbability
+	if probability == 0 {
+		probability = params.DefaultRandomQuitProbability
+	}
+	window = rs.Config.ConditionQuit.RandomQuitWindow
+	if window == 0 {
+		window = params.DefaultRandomQuitWindow
+	}
+	return
+}
+
+/*
+startChaos 配置了 RandomQuit 时,每次随机等待不超过 window 的时间,然后以 probability 的概率 panic,
+Stop 以后退出. 返回的 chan 在 goroutine 退出时关闭,没有启动则返回 nil
+*/
+func (rs *Service) startChaos() (done chan struct{}) {
+	if !rs.Config.ConditionQuit.RandomQuit {
+		return nil
+	}
+	done = make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			probability, window := rs.chaosConfig()
+			n := utils.NewRandomInt(int(window / time.Millisecond))
+			select {
+			case <-rs.quitChan:
+				return
+			case <-rs.Clock.After(time.Duration(n) * time.Millisecond):
+			}
+			if rand.Float64() < probability {
+				log.Error("random quit")
+				panic("random quit")
+			}
+		}
+	}()
+	return
+}
@@ -0,0 +1,40 @@
+//go:build debug
+// +build debug
+
+package photon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChaosExitOnQuit(t *testing.T) {
+	rs := &Service{
+		Config:   &params.Config{},
+		Clock:    utils.NewRealClock(),
+		quitChan: make(chan struct{}),
+	}
+	assert.Nil(t, rs.startChaos(), "random quit is not configured")
+
+	rs.Config.ConditionQuit.RandomQuit = true
+	assert.NotNil(t, rs.SetChaosConfig(2, time.Second))
+	assert.NotNil(t, rs.SetChaosConfig(0.5, 0))
+	assert.Nil(t, rs.SetChaosConfig(0, time.Millisecond))
+	probability, window := rs.chaosConfig()
+	assert.EqualValues(t, params.DefaultRandomQuitProbability, probability, "0 means default")
+	assert.Equal(t, time.Millisecond, window)
+	//概率设置得足够小,测试过程中不会真的退出
+	assert.Nil(t, rs.SetChaosConfig(1e-12, time.Millisecond))
+	done := rs.startChaos()
+	time.Sleep(20 * time.Millisecond)
+	close(rs.quitChan)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("chaos goroutine should exit after quit")
+	}
+}
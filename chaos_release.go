@@ -0,0 +1,16 @@
+//go:build !debug
+// +build !debug
+
+package photon
+
+import (
+	"github.com/SmartMeshFoundation/Photon/log"
+)
+
+//startChaos 正式版本不支持随机退出,避免误配置导致节点崩溃
+func (rs *Service) startChaos() (done chan struct{}) {
+	if rs.Config.ConditionQuit.RandomQuit {
+		log.Warn("random quit is only available in binaries built with `-tags debug`,ignore it")
+	}
+	return nil
+}
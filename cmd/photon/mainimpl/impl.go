@@ -194,6 +194,91 @@ func StartMain() (*photon.API, error) {
 			Name:  "db",
 			Usage: "use --db=gkv when need photon run with gkvdb,default db is boltdb,photon doesn't support change db type once db is created.",
 		},
+		cli.StringFlag{
+			Name:   "db-encryption-key",
+			Usage:  "encrypt values in db with this key,it can only be set when db is created,encryption hides db contents but not access patterns",
+			EnvVar: "PHOTON_DB_ENCRYPTION_KEY",
+		},
+		cli.BoolFlag{
+			Name:  "prefer-direct-transfer",
+			Usage: "send direct transfer instead of mediated transfer when there is a direct channel with enough balance to the target,direct transfers cannot be cancelled",
+		},
+		cli.BoolFlag{
+			Name:  "disable-direct-transfers",
+			Usage: "never send direct transfers,requests for a direct transfer are rejected and prefer-direct-transfer is ignored",
+		},
+		cli.BoolFlag{
+			Name:  "report-duplicate-transfer",
+			Usage: "count duplicate mediated transfers received as target per sender and notify them,by default they are ignored",
+		},
+		cli.DurationFlag{
+			Name:  "block-poll-interval",
+			Usage: "how often to poll the chain for new blocks,for example 5s,default depends on the chain",
+		},
+		cli.DurationFlag{
+			Name:  "max-startup-wait",
+			Usage: "maximum time to wait for history chain events at startup,for example 10m,0 means wait until all are processed",
+		},
+		cli.IntFlag{
+			Name:  "max-pending-locks-per-channel",
+			Usage: "maximum number of pending locks each side may hold in a channel,0 means no limit",
+		},
+		cli.Int64Flag{
+			Name:  "max-lock-expiration-blocks",
+			Usage: "locks of sent transfers expire within this many blocks,mediated transfers with a later expiration are refused,0 means no limit",
+		},
+		cli.StringFlag{
+			Name:  "secret-reveal-strategy",
+			Usage: "when to reveal the secret to the payer as a target,eager: as soon as it's validated,confirmed: after the payer channel's open is confirmed",
+			Value: "eager",
+		},
+		cli.Int64Flag{
+			Name:  "secret-reveal-confirmations",
+			Usage: "blocks to wait with secret-reveal-strategy confirmed,0 means the default fork confirm number",
+		},
+		cli.StringFlag{
+			Name:  "direct-channel-selection",
+			Usage: "which channel to use for a direct transfer when there are several channels with the target,default: the only one in the channel graph,most-balance,most-balanced,round-robin",
+			Value: "default",
+		},
+		cli.StringFlag{
+			Name:  "accepted-message-versions",
+			Usage: `older or newer message versions to accept besides the current one,json like {"MediatedTransfer":[1]},default accepts every version that can be decoded`,
+		},
+		cli.IntFlag{
+			Name:  "min-acceptable-settle-timeout",
+			Usage: "refuse to use channels opened by partners with a settle timeout below this,0 means the minimum allowed on chain",
+		},
+		cli.StringFlag{
+			Name:  "token-settle-timeout",
+			Usage: `default settle timeout of new channels by token in json,other tokens use the global default,for example {"0x6601F810eaF2fa749EEa10533Fd4CC23B8C791dc":100000}`,
+		},
+		cli.BoolTFlag{
+			Name:  "auto-respond-to-close",
+			Usage: "submit partner's latest balance proof automatically when partner closes a channel,use --auto-respond-to-close=false to do it manually",
+		},
+		cli.Int64Flag{
+			Name:  "ack-retention-blocks",
+			Usage: "delete acks of received messages after this many blocks,never shorter than settle timeout,0 keeps them forever",
+		},
+		cli.StringFlag{
+			Name:  "gas-limit",
+			Usage: `gas limit of transactions by operation in json,0 means estimate automatically,for example {"ChannelSettle":300000,"CooperateSettle":200000}`,
+		},
+		cli.IntFlag{
+			Name:  "message-compress-threshold",
+			Usage: "compress messages not smaller than this size in bytes before sending,0 disables compression,only partners able to decompress receive compressed messages",
+		},
+		cli.IntFlag{
+			Name:  "ack-failure-threshold",
+			Usage: "treat a partner as offline for routing after this many consecutive messages not acked in time,retries of one message count once,0 disables",
+			Value: 10,
+		},
+		cli.IntFlag{
+			Name:  "send-completion-workers",
+			Usage: "max goroutines waiting for results of sent messages,others are queued,0 means one goroutine per message",
+			Value: 16,
+		},
 		cli.StringFlag{
 			Name:  "debug-mdns-interval",
 			Usage: "for test only",
@@ -255,7 +340,7 @@ func mainCtx(ctx *cli.Context) (err error) {
 	if err != nil {
 		return
 	}
-	dao, err = stormdb.OpenDb(cfg.DataBasePath)
+	dao, err = stormdb.OpenDb(cfg.DataBasePath, cfg.DataBaseEncryptionKey)
 	//}
 	if err != nil {
 		err = fmt.Errorf("open db error %s", err)
@@ -481,6 +566,64 @@ func config(ctx *cli.Context) (config *params.Config, err error) {
 	databasePath := filepath.Join(userDbPath, "log.db")
 	config.Debug = ctx.Bool("debug")
 	config.DataBasePath = databasePath
+	config.DataBaseEncryptionKey = ctx.String("db-encryption-key")
+	config.MessageCompressThreshold = ctx.Int("message-compress-threshold")
+	config.AckFailureThreshold = ctx.Int("ack-failure-threshold")
+	config.SendCompletionWorkers = ctx.Int("send-completion-workers")
+	config.PreferDirectTransfer = ctx.Bool("prefer-direct-transfer")
+	config.DisableDirectTransfers = ctx.Bool("disable-direct-transfers")
+	config.ReportDuplicateTransfer = ctx.Bool("report-duplicate-transfer")
+	config.AutoRespondToClose = ctx.BoolT("auto-respond-to-close")
+	config.BlockPollInterval = ctx.Duration("block-poll-interval")
+	config.MaxStartupWait = ctx.Duration("max-startup-wait")
+	config.MinAcceptableSettleTimeout = ctx.Int("min-acceptable-settle-timeout")
+	config.MaxPendingLocksPerChannel = ctx.Int("max-pending-locks-per-channel")
+	config.MaxLockExpirationBlocks = ctx.Int64("max-lock-expiration-blocks")
+	config.AckRetentionBlocks = ctx.Int64("ack-retention-blocks")
+	switch ctx.String("secret-reveal-strategy") {
+	case "eager":
+		config.SecretRevealStrategy = params.SecretRevealEager
+	case "confirmed":
+		config.SecretRevealStrategy = params.SecretRevealConfirmed
+	default:
+		err = fmt.Errorf("unknown secret-reveal-strategy %s", ctx.String("secret-reveal-strategy"))
+		return
+	}
+	config.SecretRevealConfirmations = ctx.Int64("secret-reveal-confirmations")
+	switch ctx.String("direct-channel-selection") {
+	case "default":
+		config.DirectChannelSelection = params.ChannelSelectionDefault
+	case "most-balance":
+		config.DirectChannelSelection = params.ChannelSelectionMostBalance
+	case "most-balanced":
+		config.DirectChannelSelection = params.ChannelSelectionMostBalanced
+	case "round-robin":
+		config.DirectChannelSelection = params.ChannelSelectionRoundRobin
+	default:
+		err = fmt.Errorf("unknown direct-channel-selection %s", ctx.String("direct-channel-selection"))
+		return
+	}
+	if ctx.IsSet("accepted-message-versions") {
+		err = json.Unmarshal([]byte(ctx.String("accepted-message-versions")), &config.AcceptedMessageVersions)
+		if err != nil {
+			err = fmt.Errorf("accepted-message-versions parse error %s", err)
+			return
+		}
+	}
+	if ctx.IsSet("token-settle-timeout") {
+		err = json.Unmarshal([]byte(ctx.String("token-settle-timeout")), &config.TokenSettleTimeouts)
+		if err != nil {
+			err = fmt.Errorf("token-settle-timeout parse error %s", err)
+			return
+		}
+	}
+	if ctx.IsSet("gas-limit") {
+		err = json.Unmarshal([]byte(ctx.String("gas-limit")), &config.GasLimits)
+		if err != nil {
+			err = fmt.Errorf("gas-limit parse error %s", err)
+			return
+		}
+	}
 	if ctx.Bool("debugcrash") {
 		config.DebugCrash = true
 		conditionquit := ctx.String("conditionquit")
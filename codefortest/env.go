@@ -137,7 +137,7 @@ func NewTestDB(dbPath string) (dao models.Dao) {
 	//	}
 	//} else {
 	fmt.Println("use storm db")
-	dao, err = stormdb.OpenDb(dbPath)
+	dao, err = stormdb.OpenDb(dbPath, "")
 	if err != nil {
 		panic(err)
 	}
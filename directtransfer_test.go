@@ -0,0 +1,42 @@
+package photon
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/network/graph"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckDirectTransfer(t *testing.T) {
+	our, partner, token := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	ourState := channel.NewChannelEndState(our, big.NewInt(100), nil, mtree.EmptyTree)
+	partnerState := channel.NewChannelEndState(partner, big.NewInt(0), nil, mtree.EmptyTree)
+	c, err := channel.NewChannel(ourState, partnerState, &channel.ExternalState{}, token,
+		&contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash()}, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewChannelGraph(our, token, nil)
+	g.PartenerAddress2Channel[partner] = c
+	rs := &Service{
+		Config:             &params.Config{},
+		IsChainEffective:   true,
+		Token2ChannelGraph: map[common.Address]*graph.ChannelGraph{token: g},
+	}
+	ch, err := rs.checkDirectTransfer(token, partner, big.NewInt(100))
+	assert.Nil(t, err)
+	assert.Equal(t, c, ch)
+	_, err = rs.checkDirectTransfer(token, partner, big.NewInt(101))
+	assert.NotNil(t, err)
+	_, err = rs.checkDirectTransfer(token, utils.NewRandomAddress(), big.NewInt(1))
+	assert.NotNil(t, err)
+	_, err = rs.checkDirectTransfer(utils.NewRandomAddress(), partner, big.NewInt(1))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,71 @@
+package photon
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/encoding"
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/notify"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//duplicateTransferLogInterval 默认情况下重复交易的日志最多这么久输出一次
+const duplicateTransferLogInterval = time.Minute
+
+//DuplicateTransferEvent 作为接收方,又收到了同一个锁的 MediatedTransfer
+type DuplicateTransferEvent struct {
+	LockSecretHash common.Hash    `json:"lock_secret_hash"`
+	TokenAddress   common.Address `json:"token_address"`
+	Sender         common.Address `json:"sender"`
+	Count          int            `json:"count"` //这个节点累计发送的重复交易数量
+}
+
+/*
+duplicateTransferTracker 记录每个节点发送的重复交易,用于发现重放攻击.
+只能在主线程中使用
+*/
+type duplicateTransferTracker struct {
+	counts     map[common.Address]int
+	lastLog    time.Time
+	suppressed int //上次输出日志以后被忽略的重复交易
+}
+
+func newDuplicateTransferTracker() *duplicateTransferTracker {
+	return &duplicateTransferTracker{
+		counts: make(map[common.Address]int),
+	}
+}
+
+/*
+onDuplicateTargetTransfer 我是接收方,并且已经收到过这个锁的 MediatedTransfer.
+默认只是每隔 duplicateTransferLogInterval 输出一条简短的日志;
+配置了 ReportDuplicateTransfer 以后,按发送方计数并通知上层.
+只能在主线程中调用
+*/
+func (rs *Service) onDuplicateTargetTransfer(msg *encoding.MediatedTransfer, token common.Address) {
+	t := rs.duplicateTransfers
+	now := rs.Clock.Now()
+	if now.Sub(t.lastLog) >= duplicateTransferLogInterval {
+		log.Warn(fmt.Sprintf("ignore duplicate mediated transfer from %s,suppressed %d since last log", utils.APex2(msg.Sender), t.suppressed),
+			utils.TransferLogCtx(msg.LockSecretHash, token)...)
+		t.lastLog = now
+		t.suppressed = 0
+	} else {
+		t.suppressed++
+	}
+	if !rs.Config.ReportDuplicateTransfer {
+		return
+	}
+	t.counts[msg.Sender]++
+	rs.NotifyHandler.Notify(notify.LevelWarn, &notify.InfoStruct{
+		Type: notify.InfoTypeDuplicateTransfer,
+		Message: &DuplicateTransferEvent{
+			LockSecretHash: msg.LockSecretHash,
+			TokenAddress:   token,
+			Sender:         msg.Sender,
+			Count:          t.counts[msg.Sender],
+		},
+	})
+}
@@ -0,0 +1,47 @@
+package photon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/encoding"
+	"github.com/SmartMeshFoundation/Photon/notify"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/SmartMeshFoundation/Photon/utils/utest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOnDuplicateTargetTransfer(t *testing.T) {
+	clock := utest.NewFakeClock(time.Now())
+	rs := &Service{
+		Config:             &params.Config{},
+		Clock:              clock,
+		NotifyHandler:      notify.NewNotifyHandler(),
+		duplicateTransfers: newDuplicateTransferTracker(),
+	}
+	token := utils.NewRandomAddress()
+	msg := &encoding.MediatedTransfer{}
+	msg.Sender = utils.NewRandomAddress()
+	msg.LockSecretHash = utils.NewRandomHash()
+
+	//默认只记日志,并且限制频率
+	rs.onDuplicateTargetTransfer(msg, token)
+	rs.onDuplicateTargetTransfer(msg, token)
+	assert.Equal(t, 1, rs.duplicateTransfers.suppressed)
+	clock.Advance(duplicateTransferLogInterval)
+	rs.onDuplicateTargetTransfer(msg, token)
+	assert.Equal(t, 0, rs.duplicateTransfers.suppressed)
+	assert.Empty(t, rs.duplicateTransfers.counts)
+	assert.Len(t, rs.NotifyHandler.GetNoticeChan(), 0)
+
+	rs.Config.ReportDuplicateTransfer = true
+	rs.onDuplicateTargetTransfer(msg, token)
+	rs.onDuplicateTargetTransfer(msg, token)
+	assert.Equal(t, 2, rs.duplicateTransfers.counts[msg.Sender])
+	assert.Len(t, rs.NotifyHandler.GetNoticeChan(), 2)
+
+	result := rs.getLoopStats()
+	assert.Nil(t, <-result.Result)
+	assert.Equal(t, 2, result.Tag.(*LoopStats).DuplicateTransfers[msg.Sender])
+}
@@ -85,6 +85,13 @@ const (
 		因此针对错误消息,我的想法是保存一个lru进行管理,错误通知多了应该也没什么严重的问题, 只是用户体验不好而已.
 	*/
 	ErrorNotifyCmdID
+	/*
+		发起方在发送交易之前向路径上的中间节点询问当前的手续费
+	*/
+	// FeeQuoteRequestCmdID initiator asks a mediator for its current fee
+	FeeQuoteRequestCmdID
+	// FeeQuoteResponseCmdID mediator answers FeeQuoteRequest
+	FeeQuoteResponseCmdID
 )
 
 const signatureLength = 65
@@ -179,6 +186,7 @@ type SignedMessager interface {
 	Messager
 	GetSender() common.Address
 	Sign(priveKey *ecdsa.PrivateKey, pack MessagePacker) error
+	SignBy(signer utils.Signer, pack MessagePacker) error
 	verifySignature(data []byte) error
 }
 
@@ -222,6 +230,10 @@ func (t MessageType) String() string {
 		return "WithdrawResponse"
 	case ErrorNotifyCmdID:
 		return "ErrorNotify"
+	case FeeQuoteRequestCmdID:
+		return "FeeQuoteRequest"
+	case FeeQuoteResponseCmdID:
+		return "FeeQuoteResponse"
 	default:
 		return "<unknown>"
 	}
@@ -299,12 +311,20 @@ func (m *SignedMessage) GetSender() common.Address {
 
 //Sign this message
 func (m *SignedMessage) Sign(priveKey *ecdsa.PrivateKey, pack MessagePacker) error {
+	return m.SignBy(utils.NewPrivateKeySigner(priveKey), pack)
+}
+
+//SignBy sign this message with signer
+func (m *SignedMessage) SignBy(signer utils.Signer, pack MessagePacker) (err error) {
 	if len(m.Signature) > 0 {
 		log.Warn("duplicate Sign")
 		return errors.New("duplicate Sign")
 	}
-	m.Signature = SignMessage(priveKey, pack)
-	m.Sender = crypto.PubkeyToAddress(priveKey.PublicKey)
+	m.Signature, err = signer.SignMessage(pack.Pack())
+	if err != nil {
+		return
+	}
+	m.Sender = signer.Address()
 	return nil
 }
 
@@ -419,6 +439,8 @@ const (
 
 	//InvalidNonceErrorNotify 接收方收到了带有BalanceProof的消息,但是因为数据库不一致,导致Nonce错误
 	InvalidNonceErrorNotify = iota
+	//IncompatibleVersionErrorNotify 接收方无法解码或者不接受这个版本的消息,不会给 ack,发送方应该停止重发
+	IncompatibleVersionErrorNotify
 )
 
 //ErrorNotify 发消息通知对方发生了错误
@@ -706,20 +728,37 @@ func (m *EnvelopMessage) signData(datahash common.Hash) []byte {
 	return dataToSign
 }
 
+/*
+BalanceProofSigner 按照合约验证 balance proof 的格式恢复签名者,
+messageHash 是不含签名的消息的 hash,和 SignBy 签名的数据一致
+*/
+func BalanceProofSigner(bp *BalanceProof, messageHash common.Hash, signature []byte) (common.Address, error) {
+	m := &EnvelopMessage{BalanceProof: *bp}
+	//Ecrecover 会临时修改 signature
+	sig := make([]byte, len(signature))
+	copy(sig, signature)
+	return utils.Ecrecover(utils.Sha3(m.signData(messageHash)), sig)
+}
+
 /*
 Sign data=(once+transferamount+locksroot+channel+hash(data))
 */
 func (m *EnvelopMessage) Sign(privKey *ecdsa.PrivateKey, msg MessagePacker) error {
+	return m.SignBy(utils.NewPrivateKeySigner(privKey), msg)
+}
+
+//SignBy is SignedMessager
+func (m *EnvelopMessage) SignBy(signer utils.Signer, msg MessagePacker) error {
 	data := msg.Pack() //before signed, Sign twice will be error
 	datahash := utils.Sha3(data)
 	//compute data to Sign
 	dataToSign := m.signData(datahash)
-	sig, err := utils.SignData(privKey, dataToSign)
+	sig, err := signer.SignMessage(dataToSign)
 	if err != nil {
 		return err
 	}
 	m.Signature = sig
-	m.Sender = crypto.PubkeyToAddress(privKey.PublicKey)
+	m.Sender = signer.Address()
 	return nil
 }
 
@@ -1314,16 +1353,21 @@ func (m *AnnounceDisposed) GetAdditionalHash() common.Hash {
 Sign data=(once+transferamount+locksroot+channel+hash(data))
 */
 func (m *AnnounceDisposed) Sign(privKey *ecdsa.PrivateKey, msg MessagePacker) error {
+	return m.SignBy(utils.NewPrivateKeySigner(privKey), msg)
+}
+
+//SignBy is SignedMessager
+func (m *AnnounceDisposed) SignBy(signer utils.Signer, msg MessagePacker) error {
 	data := msg.Pack() //before signed, Sign twice will be error
 	datahash := utils.Sha3(data)
 	//compute data to Sign
 	dataToSign := m.signData(datahash)
-	sig, err := utils.SignData(privKey, dataToSign)
+	sig, err := signer.SignMessage(dataToSign)
 	if err != nil {
 		return err
 	}
 	m.Signature = sig
-	m.Sender = crypto.PubkeyToAddress(privKey.PublicKey)
+	m.Sender = signer.Address()
 	return nil
 }
 
@@ -1533,17 +1577,22 @@ func (m *WithdrawRequest) signDataForContract() []byte {
 }
 
 //Sign is SignedMessager
-func (m *WithdrawRequest) Sign(key *ecdsa.PrivateKey, msg MessagePacker) (err error) {
-	m.Participant1Signature, err = utils.SignData(key, m.signDataForContract())
+func (m *WithdrawRequest) Sign(key *ecdsa.PrivateKey, msg MessagePacker) error {
+	return m.SignBy(utils.NewPrivateKeySigner(key), msg)
+}
+
+//SignBy is SignedMessager
+func (m *WithdrawRequest) SignBy(signer utils.Signer, msg MessagePacker) (err error) {
+	m.Participant1Signature, err = signer.SignMessage(m.signDataForContract())
 	if err != nil {
 		return
 	}
 	data := msg.Pack()
-	m.Signature, err = utils.SignData(key, data)
+	m.Signature, err = signer.SignMessage(data)
 	if err != nil {
 		return
 	}
-	m.Sender = crypto.PubkeyToAddress(key.PublicKey)
+	m.Sender = signer.Address()
 	return
 }
 
@@ -1578,7 +1627,7 @@ func NewWithdrawResponse(wd *WithdrawReponseData, errorCode int, errorMsg string
 }
 
 // NewErrorWithdrawResponseAndSign 创建返回错误信息的SettleResponse
-func NewErrorWithdrawResponseAndSign(req *WithdrawRequest, privateKey *ecdsa.PrivateKey, errorCode int, errorMsg string) (res *WithdrawResponse) {
+func NewErrorWithdrawResponseAndSign(req *WithdrawRequest, signer utils.Signer, errorCode int, errorMsg string) (res *WithdrawResponse) {
 	res = &WithdrawResponse{
 		ErrorCode: errorCode,
 		ErrorMsg:  errorMsg,
@@ -1589,10 +1638,10 @@ func NewErrorWithdrawResponseAndSign(req *WithdrawRequest, privateKey *ecdsa.Pri
 	res.ChannelIdentifier = req.ChannelIdentifier
 	res.OpenBlockNumber = req.OpenBlockNumber
 	res.Participant1 = utils.EmptyAddress
-	res.Participant2 = crypto.PubkeyToAddress(privateKey.PublicKey)
+	res.Participant2 = signer.Address()
 	res.Participant1Balance = big.NewInt(0)
 	res.Participant1Withdraw = big.NewInt(0)
-	err2 := res.Sign(privateKey, res)
+	err2 := res.SignBy(signer, res)
 	if err2 != nil {
 		panic(fmt.Sprintf("sign message for withdraw response err %s", err2))
 	}
@@ -1716,14 +1765,19 @@ func (m *WithdrawResponse) signDataForContract() []byte {
 }
 
 //Sign is SignedMessager
-func (m *WithdrawResponse) Sign(key *ecdsa.PrivateKey, msg MessagePacker) (err error) {
-	m.Participant2Signature, err = utils.SignData(key, m.signDataForContract())
+func (m *WithdrawResponse) Sign(key *ecdsa.PrivateKey, msg MessagePacker) error {
+	return m.SignBy(utils.NewPrivateKeySigner(key), msg)
+}
+
+//SignBy is SignedMessager
+func (m *WithdrawResponse) SignBy(signer utils.Signer, msg MessagePacker) (err error) {
+	m.Participant2Signature, err = signer.SignMessage(m.signDataForContract())
 	if err != nil {
 		return
 	}
 	data := msg.Pack()
-	m.Signature, err = utils.SignData(key, data)
-	m.Sender = crypto.PubkeyToAddress(key.PublicKey)
+	m.Signature, err = signer.SignMessage(data)
+	m.Sender = signer.Address()
 	return
 }
 
@@ -1852,17 +1906,22 @@ func (m *SettleRequest) SignDataForContract() []byte {
 }
 
 //Sign is SignedMessager
-func (m *SettleRequest) Sign(key *ecdsa.PrivateKey, msg MessagePacker) (err error) {
-	m.Participant1Signature, err = utils.SignData(key, m.SignDataForContract())
+func (m *SettleRequest) Sign(key *ecdsa.PrivateKey, msg MessagePacker) error {
+	return m.SignBy(utils.NewPrivateKeySigner(key), msg)
+}
+
+//SignBy is SignedMessager
+func (m *SettleRequest) SignBy(signer utils.Signer, msg MessagePacker) (err error) {
+	m.Participant1Signature, err = signer.SignMessage(m.SignDataForContract())
 	if err != nil {
 		return
 	}
 	data := msg.Pack()
-	m.Signature, err = utils.SignData(key, data)
+	m.Signature, err = signer.SignMessage(data)
 	if err != nil {
 		return
 	}
-	m.Sender = crypto.PubkeyToAddress(key.PublicKey)
+	m.Sender = signer.Address()
 	return
 }
 
@@ -1894,7 +1953,7 @@ func NewSettleResponse(wd *SettleResponseData, errorCode int, errorMsg string) *
 }
 
 // NewErrorCooperativeSettleResponseAndSign 创建返回错误信息的SettleResponse
-func NewErrorCooperativeSettleResponseAndSign(req *SettleRequest, privateKey *ecdsa.PrivateKey, errorCode int, errorMsg string) (res *SettleResponse) {
+func NewErrorCooperativeSettleResponseAndSign(req *SettleRequest, signer utils.Signer, errorCode int, errorMsg string) (res *SettleResponse) {
 	res = &SettleResponse{
 		ErrorCode: errorCode,
 		ErrorMsg:  errorMsg,
@@ -1905,9 +1964,9 @@ func NewErrorCooperativeSettleResponseAndSign(req *SettleRequest, privateKey *ec
 	res.OpenBlockNumber = req.OpenBlockNumber
 	res.Participant1 = utils.EmptyAddress
 	res.Participant1Balance = big.NewInt(0)
-	res.Participant2 = crypto.PubkeyToAddress(privateKey.PublicKey)
+	res.Participant2 = signer.Address()
 	res.Participant2Balance = big.NewInt(0)
-	err2 := res.Sign(privateKey, res)
+	err2 := res.SignBy(signer, res)
 	if err2 != nil {
 		panic(fmt.Sprintf("sign message for settle response err %s", err2))
 	}
@@ -2038,20 +2097,166 @@ func (m *SettleResponse) SignDataForContract() []byte {
 }
 
 //Sign is SignedMessager
-func (m *SettleResponse) Sign(key *ecdsa.PrivateKey, msg MessagePacker) (err error) {
-	m.Participant2Signature, err = utils.SignData(key, m.SignDataForContract())
+func (m *SettleResponse) Sign(key *ecdsa.PrivateKey, msg MessagePacker) error {
+	return m.SignBy(utils.NewPrivateKeySigner(key), msg)
+}
+
+//SignBy is SignedMessager
+func (m *SettleResponse) SignBy(signer utils.Signer, msg MessagePacker) (err error) {
+	m.Participant2Signature, err = signer.SignMessage(m.SignDataForContract())
 	if err != nil {
 		return
 	}
 	data := msg.Pack()
-	m.Signature, err = utils.SignData(key, data)
+	m.Signature, err = signer.SignMessage(data)
 	if err != nil {
 		return
 	}
-	m.Sender = crypto.PubkeyToAddress(key.PublicKey)
+	m.Sender = signer.Address()
 	return
 }
 
+/*
+FeeQuoteRequest 询问中间节点转发给NextHop的金额为Amount时收取多少手续费,
+Amount 包含了NextHop之后所有中间节点的手续费
+*/
+type FeeQuoteRequest struct {
+	SignedMessage
+	QuoteID      common.Hash //random id, echoed by FeeQuoteResponse
+	TokenAddress common.Address
+	NextHop      common.Address
+	Amount       *big.Int
+}
+
+//NewFeeQuoteRequest create FeeQuoteRequest
+func NewFeeQuoteRequest(quoteID common.Hash, tokenAddress, nextHop common.Address, amount *big.Int) *FeeQuoteRequest {
+	p := &FeeQuoteRequest{
+		QuoteID:      quoteID,
+		TokenAddress: tokenAddress,
+		NextHop:      nextHop,
+		Amount:       new(big.Int).Set(amount),
+	}
+	p.CmdID = FeeQuoteRequestCmdID
+	return p
+}
+
+//Pack is MessagePacker
+func (m *FeeQuoteRequest) Pack() []byte {
+	var err error
+	buf := new(bytes.Buffer)
+	err = m.WriteCmdStructToBuf(buf)
+	_, err = buf.Write(m.QuoteID[:])
+	_, err = buf.Write(m.TokenAddress[:])
+	_, err = buf.Write(m.NextHop[:])
+	_, err = buf.Write(utils.BigIntTo32Bytes(m.Amount))
+	_, err = buf.Write(m.Signature)
+	if err != nil {
+		panic(fmt.Sprintf("FeeQuoteRequest Pack err %s", err))
+	}
+	return buf.Bytes()
+}
+
+//UnPack is MessageUnpacker
+func (m *FeeQuoteRequest) UnPack(data []byte) error {
+	var err error
+	buf := bytes.NewBuffer(data)
+	err = m.ReadCmdStructFromBuf(buf)
+	if FeeQuoteRequestCmdID != m.CmdID {
+		return fmt.Errorf("FeeQuoteRequest Unpack cmdid should be  %d,but get %d", FeeQuoteRequestCmdID, m.CmdID)
+	}
+	_, err = buf.Read(m.QuoteID[:])
+	_, err = buf.Read(m.TokenAddress[:])
+	_, err = buf.Read(m.NextHop[:])
+	m.Amount = utils.ReadBigInt(buf)
+	m.Signature = make([]byte, signatureLength)
+	n, err := buf.Read(m.Signature)
+	if err != nil {
+		return err
+	}
+	if n != signatureLength {
+		return errPacketLength
+	}
+	return m.verifySignature(data)
+}
+
+//String is fmt.Stringer
+func (m *FeeQuoteRequest) String() string {
+	return fmt.Sprintf("Message{type=FeeQuoteRequest quoteid=%s,token=%s,nexthop=%s,amount=%s,sender=%s,has signature=%v}",
+		utils.HPex(m.QuoteID), utils.APex2(m.TokenAddress), utils.APex2(m.NextHop), m.Amount, utils.APex2(m.Sender), len(m.Signature) != 0)
+}
+
+/*
+FeeQuoteResponse 中间节点对FeeQuoteRequest的回复,Fee是按照当前费率计算的手续费
+*/
+type FeeQuoteResponse struct {
+	SignedMessage
+	QuoteID      common.Hash
+	TokenAddress common.Address
+	NextHop      common.Address
+	Amount       *big.Int
+	Fee          *big.Int
+}
+
+//NewFeeQuoteResponse create response for `req`
+func NewFeeQuoteResponse(req *FeeQuoteRequest, fee *big.Int) *FeeQuoteResponse {
+	p := &FeeQuoteResponse{
+		QuoteID:      req.QuoteID,
+		TokenAddress: req.TokenAddress,
+		NextHop:      req.NextHop,
+		Amount:       new(big.Int).Set(req.Amount),
+		Fee:          new(big.Int).Set(fee),
+	}
+	p.CmdID = FeeQuoteResponseCmdID
+	return p
+}
+
+//Pack is MessagePacker
+func (m *FeeQuoteResponse) Pack() []byte {
+	var err error
+	buf := new(bytes.Buffer)
+	err = m.WriteCmdStructToBuf(buf)
+	_, err = buf.Write(m.QuoteID[:])
+	_, err = buf.Write(m.TokenAddress[:])
+	_, err = buf.Write(m.NextHop[:])
+	_, err = buf.Write(utils.BigIntTo32Bytes(m.Amount))
+	_, err = buf.Write(utils.BigIntTo32Bytes(m.Fee))
+	_, err = buf.Write(m.Signature)
+	if err != nil {
+		panic(fmt.Sprintf("FeeQuoteResponse Pack err %s", err))
+	}
+	return buf.Bytes()
+}
+
+//UnPack is MessageUnpacker
+func (m *FeeQuoteResponse) UnPack(data []byte) error {
+	var err error
+	buf := bytes.NewBuffer(data)
+	err = m.ReadCmdStructFromBuf(buf)
+	if FeeQuoteResponseCmdID != m.CmdID {
+		return fmt.Errorf("FeeQuoteResponse Unpack cmdid should be  %d,but get %d", FeeQuoteResponseCmdID, m.CmdID)
+	}
+	_, err = buf.Read(m.QuoteID[:])
+	_, err = buf.Read(m.TokenAddress[:])
+	_, err = buf.Read(m.NextHop[:])
+	m.Amount = utils.ReadBigInt(buf)
+	m.Fee = utils.ReadBigInt(buf)
+	m.Signature = make([]byte, signatureLength)
+	n, err := buf.Read(m.Signature)
+	if err != nil {
+		return err
+	}
+	if n != signatureLength {
+		return errPacketLength
+	}
+	return m.verifySignature(data)
+}
+
+//String is fmt.Stringer
+func (m *FeeQuoteResponse) String() string {
+	return fmt.Sprintf("Message{type=FeeQuoteResponse quoteid=%s,token=%s,nexthop=%s,amount=%s,fee=%s,sender=%s,has signature=%v}",
+		utils.HPex(m.QuoteID), utils.APex2(m.TokenAddress), utils.APex2(m.NextHop), m.Amount, m.Fee, utils.APex2(m.Sender), len(m.Signature) != 0)
+}
+
 //MessageMap contains all message can send and receive.
 //DirectTransfer has been deprecated
 var MessageMap = map[int]Messager{
@@ -2070,6 +2275,8 @@ var MessageMap = map[int]Messager{
 	SettleRequestCmdID:                    new(SettleRequest),
 	SettleResponseCmdID:                   new(SettleResponse),
 	ErrorNotifyCmdID:                      new(ErrorNotify),
+	FeeQuoteRequestCmdID:                  new(FeeQuoteRequest),
+	FeeQuoteResponseCmdID:                 new(FeeQuoteResponse),
 }
 
 func init() {
@@ -2082,6 +2289,7 @@ func init() {
 	gob.Register(&AnnounceDisposed{})
 	gob.Register(&UnLock{})
 	gob.Register(&SecretRequest{})
+	gob.Register(&RevealSecret{})
 	gob.Register(&RemoveExpiredHashlockTransfer{})
 	gob.Register(&AnnounceDisposedResponse{})
 	gob.Register(&WithdrawRequest{})
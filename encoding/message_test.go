@@ -484,3 +484,32 @@ func TestBigInt(t *testing.T) {
 		t.Error("not equal")
 	}
 }
+
+func TestFeeQuote(t *testing.T) {
+	req := NewFeeQuoteRequest(utils.NewRandomHash(), utils.NewRandomAddress(), utils.NewRandomAddress(), big.NewInt(300))
+	err := req.Sign(GetTestPrivKey(), req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	req2 := new(FeeQuoteRequest)
+	err = req2.UnPack(req.Pack())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.EqualValues(t, req, req2)
+	res := NewFeeQuoteResponse(req2, big.NewInt(3))
+	err = res.Sign(GetTestPrivKey(), res)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	res2 := new(FeeQuoteResponse)
+	err = res2.UnPack(res.Pack())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.EqualValues(t, res, res2)
+}
@@ -0,0 +1,193 @@
+package encoding
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+消息版本
+每个消息的前4个字节是消息头: cmdid(int16,小端) 和 version(int16,小端),见 CmdStruct.
+MessageVersionControlMap 中是每个消息支持的最低版本,也是发送时使用的版本.
+消息格式发生不兼容的变化时提高这个版本号,新版本只能在消息中增加字段,不能改变已有字段的格式,
+这样旧节点仍然能够解码新版本的消息.
+收到消息时:
+1. 为这个版本注册了 VersionDecoder,由它解码成当前版本的结构,MessageHandler 只会看到当前版本的消息
+2. 版本不低于最低版本,用当前格式解码,对方比我新也可以
+3. 否则返回 IncompatibleVersionError,不会用当前格式去解析旧版本的数据,
+PhotonProtocol 会用 IncompatibleVersionErrorNotify 通知对方,对方收到以后停止重发这条消息
+滚动升级时,新版本为仍在使用的旧版本注册 VersionDecoder,网络中的节点都升级以后,可以通过 Config.AcceptedMessageVersions 停止接受旧版本
+*/
+
+//VersionDecoder 把另一个版本的消息解码成当前版本的结构,需要自己验证签名
+type VersionDecoder func(data []byte) (Messager, error)
+
+type versionKey struct {
+	cmdID   int16
+	version int16
+}
+
+var versionDecoders = make(map[versionKey]VersionDecoder)
+
+//RegisterVersionDecoder 注册 cmdID 的 version 版本的解码方式,只能在 init 中调用
+func RegisterVersionDecoder(cmdID, version int16, decoder VersionDecoder) {
+	if version == CurrentVersion(cmdID) {
+		panic(fmt.Sprintf("%s version %d is the current version", MessageType(cmdID), version))
+	}
+	versionDecoders[versionKey{cmdID, version}] = decoder
+}
+
+//CurrentVersion 发送 cmdID 时使用的版本号,也是能用当前格式解码的最低版本
+func CurrentVersion(cmdID int16) int16 {
+	return MessageVersionControlMap[cmdID]
+}
+
+//HasVersionDecoder 能否解码 cmdID 的 version 版本
+func HasVersionDecoder(cmdID, version int16) bool {
+	if version >= CurrentVersion(cmdID) {
+		return true
+	}
+	_, ok := versionDecoders[versionKey{cmdID, version}]
+	return ok
+}
+
+//MessageTypeByName 根据消息名字(MessageType.String)找到 cmdid
+func MessageTypeByName(name string) (cmdID int16, ok bool) {
+	for id := range MessageMap {
+		if MessageType(id).String() == name {
+			return int16(id), true
+		}
+	}
+	return
+}
+
+//IncompatibleVersionError 对方使用了我无法解码或者不接受的消息版本
+type IncompatibleVersionError struct {
+	CmdID          int16
+	Version        int16
+	CurrentVersion int16
+}
+
+func (e *IncompatibleVersionError) Error() string {
+	return fmt.Sprintf("incompatible message version,%s version %d,local version %d",
+		MessageType(e.CmdID), e.Version, e.CurrentVersion)
+}
+
+//AcceptVersionFunc 除了能用当前格式解码的版本以外,是否接受 cmdID 的 version 版本
+type AcceptVersionFunc func(cmdID, version int16) bool
+
+//PeekCmdStruct 只读取消息头
+func PeekCmdStruct(data []byte) (cmd *CmdStruct, err error) {
+	if len(data) < 4 {
+		return nil, errPacketLength
+	}
+	cmd = new(CmdStruct)
+	err = cmd.ReadCmdStructFromBuf(bytes.NewBuffer(data[:4]))
+	return
+}
+
+/*
+DecodeMessage 解码收到的消息,版本的处理见文件开头的说明.
+accept 为 nil 表示接受所有注册了 VersionDecoder 的版本.
+解码过程中的 panic 也作为错误返回,对方发来的数据不应该让我崩溃.
+*/
+func DecodeMessage(data []byte, accept AcceptVersionFunc) (msg Messager, err error) {
+	cmd, err := PeekCmdStruct(data)
+	if err != nil {
+		return
+	}
+	sample, ok := MessageMap[int(cmd.CmdID)]
+	if !ok {
+		return nil, fmt.Errorf("unknown message cmdid %d", cmd.CmdID)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			msg = nil
+			err = fmt.Errorf("decode %s version %d panic: %v", cmd.Name(), cmd.Version, r)
+		}
+	}()
+	current := CurrentVersion(cmd.CmdID)
+	decoder, ok := versionDecoders[versionKey{cmd.CmdID, cmd.Version}]
+	if !ok && cmd.Version >= current {
+		msg = reflect.New(reflect.TypeOf(sample).Elem()).Interface().(Messager)
+		err = msg.UnPack(data)
+		if err != nil {
+			return nil, err
+		}
+		return
+	}
+	if !ok || (accept != nil && !accept(cmd.CmdID, cmd.Version)) {
+		return nil, &IncompatibleVersionError{
+			CmdID:          cmd.CmdID,
+			Version:        cmd.Version,
+			CurrentVersion: current,
+		}
+	}
+	return decoder(data)
+}
+
+//envelopLength EnvelopMessage.pack 写在消息末尾的长度,包括签名
+const envelopLength = 8 + 32 + 8 + 32 + 32 + signatureLength
+
+/*
+MessageSender 在无法解码消息的情况下恢复签名者,用于回复版本不兼容的消息.
+EnvelopMessage 总是在消息的末尾,签名也只和它以及整个消息的 hash 有关,所以和消息其他部分的格式无关.
+*/
+func MessageSender(data []byte) (sender common.Address, err error) {
+	cmd, err := PeekCmdStruct(data)
+	if err != nil {
+		return
+	}
+	sample, ok := MessageMap[int(cmd.CmdID)]
+	if !ok {
+		err = fmt.Errorf("unknown message cmdid %d", cmd.CmdID)
+		return
+	}
+	if _, ok = sample.(EnvelopMessager); !ok {
+		if len(data) <= 4+signatureLength {
+			err = errPacketLength
+			return
+		}
+		return VerifyMessage(data)
+	}
+	if len(data) < 4+envelopLength {
+		err = errPacketLength
+		return
+	}
+	m := new(EnvelopMessage)
+	err = m.unpack(bytes.NewBuffer(data[len(data)-envelopLength:]))
+	if err != nil {
+		return
+	}
+	err = m.verifySignature(data)
+	return m.Sender, err
+}
+
+//NewIncompatibleVersionErrorNotify 通知对方 echohash 对应的消息版本不兼容,RelatedData 是 echohash,cmdid,对方的版本,我的版本
+func NewIncompatibleVersionErrorNotify(echohash common.Hash, e *IncompatibleVersionError) *ErrorNotify {
+	buf := new(bytes.Buffer)
+	buf.Write(echohash[:])
+	binary.Write(buf, binary.BigEndian, e.CmdID)
+	binary.Write(buf, binary.BigEndian, e.Version)
+	binary.Write(buf, binary.BigEndian, e.CurrentVersion)
+	return NewErrorNotify(IncompatibleVersionErrorNotify, buf.Bytes())
+}
+
+//ParseIncompatibleVersionErrorNotify 解析 NewIncompatibleVersionErrorNotify 的 RelatedData
+func ParseIncompatibleVersionErrorNotify(en *ErrorNotify) (echohash common.Hash, e *IncompatibleVersionError, err error) {
+	if en.ErrorNotifyType != IncompatibleVersionErrorNotify || len(en.RelatedData) != len(echohash)+6 {
+		err = fmt.Errorf("not a IncompatibleVersionErrorNotify,type=%d,len=%d", en.ErrorNotifyType, len(en.RelatedData))
+		return
+	}
+	buf := bytes.NewBuffer(en.RelatedData)
+	buf.Read(echohash[:])
+	e = new(IncompatibleVersionError)
+	binary.Read(buf, binary.BigEndian, &e.CmdID)
+	binary.Read(buf, binary.BigEndian, &e.Version)
+	binary.Read(buf, binary.BigEndian, &e.CurrentVersion)
+	return
+}
@@ -0,0 +1,124 @@
+package encoding
+
+import (
+	"encoding/binary"
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeMessageVersion(t *testing.T) {
+	ping := NewPing(0x33)
+	err := ping.Sign(GetTestPrivKey(), ping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := DecodeMessage(ping.Pack(), nil)
+	if assert.Nil(t, err) {
+		assert.EqualValues(t, 0x33, msg.(*Ping).Nonce)
+	}
+
+	//比我新的版本,用当前格式解码
+	ping2 := NewPing(0x34)
+	ping2.Version = 3
+	err = ping2.Sign(GetTestPrivKey(), ping2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := ping2.Pack()
+	assert.EqualValues(t, 3, binary.LittleEndian.Uint16(data[2:4]))
+	msg, err = DecodeMessage(data, nil)
+	if assert.Nil(t, err) {
+		assert.EqualValues(t, 0x34, msg.(*Ping).Nonce)
+	}
+
+	//低于最低版本,并且没有注册 VersionDecoder
+	bp := &BalanceProof{
+		Nonce:             11,
+		ChannelIdentifier: utils.Sha3([]byte("123")),
+		TransferAmount:    big.NewInt(12),
+		OpenBlockNumber:   3,
+		Locksroot:         utils.EmptyHash,
+	}
+	lock := &mtree.Lock{
+		Amount:         big.NewInt(34),
+		Expiration:     4589895,
+		LockSecretHash: utils.ShaSecret([]byte("hashlock")),
+	}
+	mtr := NewMediatedTransfer(bp, lock, utils.NewRandomAddress(), utils.NewRandomAddress(), big.NewInt(33), nil)
+	mtr.Version = 0
+	err = mtr.Sign(GetTestPrivKey(), mtr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = mtr.Pack()
+	_, err = DecodeMessage(data, nil)
+	if assert.IsType(t, &IncompatibleVersionError{}, err) {
+		assert.Contains(t, err.Error(), "MediatedTransfer version 0")
+	}
+	sender, err := MessageSender(data)
+	assert.Nil(t, err)
+	assert.Equal(t, GetTestAddress(), sender)
+
+	RegisterVersionDecoder(MediatedTransferCmdID, 0, func(data []byte) (Messager, error) {
+		m := new(MediatedTransfer)
+		m.Version = CurrentVersion(MediatedTransferCmdID)
+		m.Expiration = int64(binary.BigEndian.Uint64(data[4:12]))
+		return m, nil
+	})
+	defer delete(versionDecoders, versionKey{MediatedTransferCmdID, 0})
+	msg, err = DecodeMessage(data, nil)
+	if assert.Nil(t, err) {
+		assert.EqualValues(t, 4589895, msg.(*MediatedTransfer).Expiration)
+		assert.EqualValues(t, 1, msg.(*MediatedTransfer).Version)
+	}
+	//配置中没有接受这个版本
+	_, err = DecodeMessage(data, func(cmdID, version int16) bool { return false })
+	assert.IsType(t, &IncompatibleVersionError{}, err)
+
+	//数据错误不能 panic
+	ack := NewAck(GetTestAddress(), [32]byte{})
+	data = ack.Pack()
+	_, err = DecodeMessage(data[:3], nil)
+	assert.Equal(t, errPacketLength, err)
+	_, err = DecodeMessage([]byte{0xff, 0, 0, 0}, nil)
+	assert.Error(t, err)
+}
+
+func TestMessageTypeByName(t *testing.T) {
+	cmdID, ok := MessageTypeByName("MediatedTransfer")
+	assert.True(t, ok)
+	assert.EqualValues(t, MediatedTransferCmdID, cmdID)
+	_, ok = MessageTypeByName("NoSuchMessage")
+	assert.False(t, ok)
+}
+
+func TestIncompatibleVersionErrorNotify(t *testing.T) {
+	echohash := utils.NewRandomHash()
+	en := NewIncompatibleVersionErrorNotify(echohash, &IncompatibleVersionError{
+		CmdID:          MediatedTransferCmdID,
+		Version:        0,
+		CurrentVersion: 1,
+	})
+	err := en.Sign(GetTestPrivKey(), en)
+	if err != nil {
+		t.Fatal(err)
+	}
+	en2 := new(ErrorNotify)
+	err = en2.UnPack(en.Pack())
+	if err != nil {
+		t.Fatal(err)
+	}
+	echohash2, verr, err := ParseIncompatibleVersionErrorNotify(en2)
+	if assert.Nil(t, err) {
+		assert.Equal(t, echohash, echohash2)
+		assert.EqualValues(t, MediatedTransferCmdID, verr.CmdID)
+		assert.EqualValues(t, 0, verr.Version)
+		assert.EqualValues(t, 1, verr.CurrentVersion)
+	}
+	_, _, err = ParseIncompatibleVersionErrorNotify(NewErrorNotify(InvalidNonceErrorNotify, nil))
+	assert.Error(t, err)
+}
@@ -7,6 +7,7 @@ import (
 
 	"errors"
 
+	"math/big"
 	"time"
 
 	"github.com/SmartMeshFoundation/Photon/channel"
@@ -17,6 +18,7 @@ import (
 	"github.com/SmartMeshFoundation/Photon/network/graph"
 	"github.com/SmartMeshFoundation/Photon/network/netshare"
 	"github.com/SmartMeshFoundation/Photon/notify"
+	"github.com/SmartMeshFoundation/Photon/rerr"
 	"github.com/SmartMeshFoundation/Photon/transfer"
 	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer"
 	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer/initiator"
@@ -112,7 +114,13 @@ func (eh *stateMachineEventHandler) eventSendRevealSecret(event *mediatedtransfe
 	revealMessage := encoding.NewRevealSecret(event.Secret)
 	// 带上交易附加信息
 	revealMessage.Data = []byte(event.Data)
-	err = revealMessage.Sign(eh.photon.PrivateKey, revealMessage)
+	err = revealMessage.SignBy(eh.photon.Signer, revealMessage)
+	if err != nil {
+		return
+	}
+	if eh.photon.holdRevealIfUnsafe(event.Receiver, revealMessage, stateManager) {
+		return nil
+	}
 	err = eh.photon.sendAsync(event.Receiver, revealMessage) //单独处理 reaveal secret
 	if err == nil {
 		std := eh.photon.dao.UpdateSentTransferDetailStatus(event.Token, revealMessage.LockSecretHash(), models.TransferStatusCanNotCancel, fmt.Sprintf("RevealSecret sending target=%s", utils.APex2(event.Receiver)), nil)
@@ -124,31 +132,39 @@ func (eh *stateMachineEventHandler) eventSendRevealSecret(event *mediatedtransfe
 }
 func (eh *stateMachineEventHandler) eventSendSecretRequest(event *mediatedtransfer.EventSendSecretRequest, stateManager *transfer.StateManager) (err error) {
 	secretRequest := encoding.NewSecretRequest(event.LockSecretHash, event.Amount)
-	err = secretRequest.Sign(eh.photon.PrivateKey, secretRequest)
+	err = secretRequest.SignBy(eh.photon.Signer, secretRequest)
+	if err != nil {
+		return
+	}
 	eh.photon.conditionQuit("EventSendSecretRequestBefore")
 	ch := eh.photon.getChannelWithAddr(event.ChannelIdentifier)
 	if ch == nil {
 		panic("should not found")
 	}
 	if stateManager.LastReceivedMessage == nil {
-		log.Warn(fmt.Sprintf("EventSendSecretRequest %s,but has no lastReceviedMessage", utils.StringInterface(event, 3)))
+		log.Warn(fmt.Sprintf("EventSendSecretRequest %s,but has no lastReceviedMessage", utils.StringInterface(event, 3)), utils.TransferLogCtx(event.LockSecretHash, ch.TokenAddress)...)
 		err = eh.photon.UpdateChannelNoTx(channel.NewChannelSerialization(ch))
 	} else {
 		eh.photon.UpdateChannelAndSaveAck(ch, stateManager.LastReceivedMessage.Tag())
 		stateManager.LastReceivedMessage = nil
 	}
+	// 公链断开时无法确认锁是否过期,暂不向发起方要密码
+	if eh.photon.holdRevealIfUnsafe(event.Receiver, secretRequest, stateManager) {
+		return
+	}
 	err = eh.photon.sendAsync(event.Receiver, secretRequest)
 	return
 }
 func (eh *stateMachineEventHandler) eventSendMediatedTransfer(event *mediatedtransfer.EventSendMediatedTransfer, stateManager *transfer.StateManager) (err error) {
 	receiver := event.Receiver
+	logCtx := utils.TransferLogCtx(event.LockSecretHash, event.Token)
 	g := eh.photon.getToken2ChannelGraph(event.Token)
 	ch := g.GetPartenerAddress2Channel(receiver)
 	if ch == nil {
 		err = fmt.Errorf("receive eventSendMediatedTransfer,but cannot found the channel,there must be error, event=%s,stateManager=%s",
 			utils.StringInterface(event, 3), utils.StringInterface(stateManager, 5),
 		)
-		log.Error(err.Error())
+		log.Error(err.Error(), logCtx...)
 		return
 	}
 	//log.Trace(fmt.Sprintf("eventSendMediatedTransfer g=%s", utils.StringInterface(g, 3)))
@@ -158,7 +174,10 @@ func (eh *stateMachineEventHandler) eventSendMediatedTransfer(event *mediatedtra
 		return
 	}
 	//log.Trace(fmt.Sprintf("mtr=%s", utils.StringInterface(mtr, 5)))
-	err = mtr.Sign(eh.photon.PrivateKey, mtr)
+	err = mtr.SignBy(eh.photon.Signer, mtr)
+	if err != nil {
+		return
+	}
 	err = ch.RegisterTransfer(eh.photon.GetBlockNumber(), mtr)
 	if err != nil {
 		return
@@ -166,7 +185,7 @@ func (eh *stateMachineEventHandler) eventSendMediatedTransfer(event *mediatedtra
 	eh.photon.conditionQuit("EventSendMediatedTransferBefore")
 	if stateManager.LastReceivedMessage == nil {
 		if stateManager.Name != initiator.NameInitiatorTransition {
-			log.Warn(fmt.Sprintf("EventSendMediatedTransfer %s,but has no lastReceviedMessage", utils.StringInterface(event, 3)))
+			log.Warn(fmt.Sprintf("EventSendMediatedTransfer %s,but has no lastReceviedMessage", utils.StringInterface(event, 3)), logCtx...)
 		}
 		err = eh.photon.UpdateChannelNoTx(channel.NewChannelSerialization(ch))
 	} else {
@@ -179,8 +198,9 @@ func (eh *stateMachineEventHandler) eventSendMediatedTransfer(event *mediatedtra
 		echohash := t.EchoHash
 		ack := eh.photon.Protocol.CreateAck(echohash)
 		tx := eh.photon.dao.StartTx()
-		eh.photon.dao.SaveAck(echohash, ack.Pack(), tx)
-		err = eh.photon.UpdateChannel(channel.NewChannelSerialization(ch), tx)
+		eh.photon.dao.SaveAck(echohash, ack.Pack(), eh.photon.GetBlockNumber(), tx)
+		chs := channel.NewChannelSerialization(ch)
+		err = eh.photon.UpdateChannel(chs, tx)
 		if err != nil {
 			//数据库保存错误,不可能发生,一旦发生了,程序只能向上层报告错误.
 			// database cache fault, impossible to happen.
@@ -188,7 +208,8 @@ func (eh *stateMachineEventHandler) eventSendMediatedTransfer(event *mediatedtra
 			//err=tx.Rollback()
 			panic(fmt.Sprintf("update channel err %s", err))
 		}
-		err = eh.photon.UpdateChannel(channel.NewChannelSerialization(fromCh), tx)
+		fromChs := channel.NewChannelSerialization(fromCh)
+		err = eh.photon.UpdateChannel(fromChs, tx)
 		if err != nil {
 			//数据库保存错误,不可能发生,一旦发生了,程序只能向上层报告错误.
 			// database cache fault, impossible to happen.
@@ -197,10 +218,19 @@ func (eh *stateMachineEventHandler) eventSendMediatedTransfer(event *mediatedtra
 			panic(fmt.Sprintf("update channel err %s", err))
 		}
 		err = tx.Commit()
+		if err == nil {
+			//写入余额记录需要新的 tx,必须在 tx 提交以后
+			eh.photon.recordBalancePoint(chs)
+			eh.photon.recordBalancePoint(fromChs)
+		}
 		stateManager.LastReceivedMessage = nil
 	}
 	err = eh.photon.sendAsync(receiver, mtr)
 	if err == nil {
+		if stateManager.Name == initiator.NameInitiatorTransition {
+			//换路径以后覆盖之前的路径
+			eh.photon.dao.UpdateSentTransferDetailRoute(ch.TokenAddress, mtr.LockSecretHash, event.Path)
+		}
 		std := eh.photon.dao.UpdateSentTransferDetailStatus(ch.TokenAddress, mtr.LockSecretHash, models.TransferStatusCanCancel, fmt.Sprintf("MediatedTransfer sending target=%s", utils.APex2(receiver)), nil)
 		//eh.photon.NotifyTransferStatusChange(ch.TokenAddress, mtr.LockSecretHash, models.TransferStatusCanCancel, fmt.Sprintf("MediatedTransfer 正在发送 target=%s", utils.APex2(receiver)))
 		eh.photon.NotifyHandler.NotifySentTransferDetail(std)
@@ -212,7 +242,7 @@ func (eh *stateMachineEventHandler) eventSendUnlock(event *mediatedtransfer.Even
 		// 在无有效公链的情况下,阻止本应该发送的unlock消息并保存在数据库,当切换到有效公链的时候,再视情况决定是否发送
 		eh.photon.dao.NewUnlockToSend(event.LockSecretHash, event.Token, event.Receiver, eh.photon.GetBlockNumber())
 		log.Info(fmt.Sprintf("unlock message [lockSecertHash=%s token=%s receiver=%s] saved in db and wait to send after effective chain",
-			event.LockSecretHash.String(), event.Token.String(), event.Receiver.String()))
+			event.LockSecretHash.String(), event.Token.String(), event.Receiver.String()), utils.TransferLogCtx(event.LockSecretHash, event.Token)...)
 		return
 	}
 	receiver := event.Receiver
@@ -221,14 +251,17 @@ func (eh *stateMachineEventHandler) eventSendUnlock(event *mediatedtransfer.Even
 	if ch == nil {
 		err = fmt.Errorf("receive EventSendBalanceProof,but cannot found the channel,there must be error, event=%s",
 			utils.StringInterface(event, 3))
-		log.Error(err.Error())
+		log.Error(err.Error(), utils.TransferLogCtx(event.LockSecretHash, event.Token)...)
 		return
 	}
 	tr, err := ch.CreateUnlock(event.LockSecretHash)
 	if err != nil {
 		return
 	}
-	err = tr.Sign(eh.photon.PrivateKey, tr)
+	err = tr.SignBy(eh.photon.Signer, tr)
+	if err != nil {
+		return
+	}
 	err = ch.RegisterTransfer(eh.photon.GetBlockNumber(), tr)
 	if err != nil {
 		return
@@ -251,24 +284,28 @@ func (eh *stateMachineEventHandler) eventSendAnnouncedDisposed(event *mediatedtr
 		err = fmt.Errorf("receive eventSendAnnouncedDisposed,but cannot found the channel,there must be error, event=%s,stateManager=%s",
 			utils.StringInterface(event, 3), utils.StringInterface(stateManager, 5),
 		)
-		log.Error(err.Error())
+		log.Error(err.Error(), utils.TransferLogCtx(event.LockSecretHash, event.Token)...)
+		return
+	}
+	blockNumber := eh.photon.GetBlockNumber()
+	mtr, err := ch.CreateAnnouceDisposed(event.LockSecretHash, blockNumber, event.Reason)
+	if err != nil {
 		return
 	}
-	mtr, err := ch.CreateAnnouceDisposed(event.LockSecretHash, eh.photon.GetBlockNumber(), event.Reason)
+	err = mtr.SignBy(eh.photon.Signer, mtr)
 	if err != nil {
 		return
 	}
-	err = mtr.Sign(eh.photon.PrivateKey, mtr)
 	err = ch.RegisterAnnouceDisposed(mtr)
 	if err != nil {
 		return
 	}
-	err = eh.photon.dao.MarkLockSecretHashDisposed(event.LockSecretHash, ch.ChannelIdentifier.ChannelIdentifier)
+	err = eh.photon.dao.MarkLockSecretHashDisposed(event.LockSecretHash, ch.ChannelIdentifier.ChannelIdentifier, blockNumber, event.Reason.Error())
 	if err != nil {
 		return
 	}
 	if stateManager.LastReceivedMessage == nil {
-		log.Warn(fmt.Sprintf("EventSendAnnounceDisposed %s,but has no lastReceviedMessage", utils.StringInterface(event, 3)))
+		log.Warn(fmt.Sprintf("EventSendAnnounceDisposed %s,but has no lastReceviedMessage", utils.StringInterface(event, 3)), utils.TransferLogCtx(event.LockSecretHash, event.Token)...)
 		err = eh.photon.UpdateChannelNoTx(channel.NewChannelSerialization(ch))
 	} else {
 		eh.photon.UpdateChannelAndSaveAck(ch, stateManager.LastReceivedMessage.Tag())
@@ -296,14 +333,17 @@ func (eh *stateMachineEventHandler) eventSendAnnouncedDisposedResponse(event *me
 	if err != nil {
 		return
 	}
-	err = mtr.Sign(eh.photon.PrivateKey, mtr)
+	err = mtr.SignBy(eh.photon.Signer, mtr)
+	if err != nil {
+		return
+	}
 	err = ch.RegisterAnnounceDisposedResponse(mtr, eh.photon.GetBlockNumber())
 	if err != nil {
 		return
 	}
 	eh.photon.conditionQuit("EventSendAnnouncedDisposedResponseBefore")
 	if stateManager.LastReceivedMessage == nil {
-		log.Warn(fmt.Sprintf("EventSendAnnounceDisposedResponse %s,but has no lastReceviedMessage", utils.StringInterface(event, 3)))
+		log.Warn(fmt.Sprintf("EventSendAnnounceDisposedResponse %s,but has no lastReceviedMessage", utils.StringInterface(event, 3)), utils.TransferLogCtx(event.LockSecretHash, event.Token)...)
 		err = eh.photon.UpdateChannelNoTx(channel.NewChannelSerialization(ch))
 	} else {
 		eh.photon.UpdateChannelAndSaveAck(ch, stateManager.LastReceivedMessage.Tag())
@@ -384,19 +424,24 @@ func (eh *stateMachineEventHandler) eventUnlockFailed(e2 *mediatedtransfer.Event
 		log.Error(fmt.Sprintf("payee's lock expired ,but cannot find channel %s, eh may happen long later restart after a stop", e2.ChannelIdentifier))
 		return
 	}
-	log.Info(fmt.Sprintf("remove expired hashlock channel=%s,hashlock=%s ", utils.HPex(e2.ChannelIdentifier), utils.HPex(e2.LockSecretHash)))
+	logCtx := utils.TransferLogCtx(e2.LockSecretHash, ch.TokenAddress)
+	log.Info(fmt.Sprintf("remove expired hashlock channel=%s,hashlock=%s ", utils.HPex(e2.ChannelIdentifier), utils.HPex(e2.LockSecretHash)), logCtx...)
 	/*
 		unlock 失败,谨慎起见, 只有在对方不知道密码的情况下,才可能成功移除锁.
 	*/
 	tr, err := ch.CreateRemoveExpiredHashLockTransfer(e2.LockSecretHash, eh.photon.GetBlockNumber())
 	if err != nil {
-		log.Warn(fmt.Sprintf("Get Event UnlockFailed ,but hashlock cannot be removed err:%s", err))
+		log.Warn(fmt.Sprintf("Get Event UnlockFailed ,but hashlock cannot be removed err:%s", err), logCtx...)
+		return
+	}
+	err = tr.SignBy(eh.photon.Signer, tr)
+	if err != nil {
+		log.Error(fmt.Sprintf("sign RemoveExpiredHashlockTransfer err %s", err), logCtx...)
 		return
 	}
-	err = tr.Sign(eh.photon.PrivateKey, tr)
 	err = ch.RegisterRemoveExpiredHashlockTransfer(tr, eh.photon.GetBlockNumber())
 	if err != nil {
-		log.Error(fmt.Sprintf("register mine RegisterRemoveExpiredHashlockTransfer err %s", err))
+		log.Error(fmt.Sprintf("register mine RegisterRemoveExpiredHashlockTransfer err %s", err), logCtx...)
 		return
 	}
 	eh.photon.conditionQuit("EventRemoveExpiredHashlockTransferBefore")
@@ -459,6 +504,7 @@ func (eh *stateMachineEventHandler) OnEvent(event transfer.Event, stateManager *
 		if err != nil {
 			log.Error(fmt.Sprintf("UpdateChannelNoTx err %s", err))
 		}
+		eh.addChannelStats(e2.ChannelIdentifier, models.ChannelStatsSent, e2.Amount)
 		//st := eh.photon.dao.NewSentTransfer(eh.photon.GetBlockNumber(), e2.ChannelIdentifier, ch.ChannelIdentifier.OpenBlockNumber, ch.TokenAddress, e2.Target, ch.GetNextNonce(), e2.Amount, e2.LockSecretHash, e2.Data)
 		//eh.photon.NotifyHandler.NotifySentTransfer(st)
 		eh.finishOneTransfer(event)
@@ -477,9 +523,21 @@ func (eh *stateMachineEventHandler) OnEvent(event transfer.Event, stateManager *
 		if err != nil {
 			log.Error(fmt.Sprintf("UpdateChannelNoTx err %s", err))
 		}
-		rt := eh.photon.dao.NewReceivedTransfer(eh.photon.GetBlockNumber(), e2.ChannelIdentifier, ch.ChannelIdentifier.OpenBlockNumber, ch.TokenAddress, e2.Initiator, ch.PartnerState.BalanceProofState.Nonce, e2.Amount, e2.LockSecretHash, e2.Data)
+		rt := eh.photon.dao.NewReceivedTransfer(eh.photon.GetBlockNumber(), e2.ChannelIdentifier, ch.ChannelIdentifier.OpenBlockNumber, ch.TokenAddress, e2.Initiator, ch.PartnerState.BalanceProofState.Nonce, e2.Amount, e2.LockSecretHash, e2.Data, e2.Outcome.String())
+		eh.photon.recordPaymentPointer(rt)
 		eh.photon.NotifyHandler.NotifyReceiveTransfer(rt)
+		eh.photon.receivedTransferSubscribers.publish(rt)
+		eh.addChannelStats(e2.ChannelIdentifier, models.ChannelStatsReceived, e2.Amount)
+		eh.photon.payInvoice(ch.TokenAddress, e2.Data, e2.Amount)
 	case *mediatedtransfer.EventUnlockSuccess:
+		//只有中间节点会设置通道
+		if e2.PayeeChannelIdentifier != utils.EmptyHash {
+			eh.addChannelStats(e2.PayerChannelIdentifier, models.ChannelStatsMediated, e2.Amount)
+			eh.addChannelStats(e2.PayeeChannelIdentifier, models.ChannelStatsMediated, e2.Amount)
+			if e2.Outcome.OnChain() {
+				eh.photon.NotifyHandler.NotifyString(notify.LevelInfo, fmt.Sprintf("mediated transfer %s finished,outcome=%s", utils.HPex(e2.LockSecretHash), e2.Outcome))
+			}
+		}
 	case *mediatedtransfer.EventWithdrawFailed:
 		log.Error(fmt.Sprintf("EventWithdrawFailed hashlock=%s,reason=%s", utils.HPex(e2.LockSecretHash), e2.Reason))
 		err = eh.eventWithdrawFailed(e2, stateManager)
@@ -499,6 +557,7 @@ func (eh *stateMachineEventHandler) OnEvent(event transfer.Event, stateManager *
 		err = eh.eventContractSendRegisterSecret(e2)
 	case *mediatedtransfer.EventRemoveStateManager:
 		delete(eh.photon.Transfer2StateManager, e2.Key)
+		eh.photon.tokenSwapTransferFinished(e2.Key)
 	case *mediatedtransfer.EventSaveFeeChargeRecord:
 		err = eh.eventSaveFeeChargeRecord(e2)
 	default:
@@ -508,33 +567,66 @@ func (eh *stateMachineEventHandler) OnEvent(event transfer.Event, stateManager *
 	return
 }
 
+//addChannelStats 统计失败不影响交易本身,只记录日志
+func (eh *stateMachineEventHandler) addChannelStats(channelIdentifier common.Hash, kind models.ChannelStatsKind, amount *big.Int) {
+	err := eh.photon.dao.AddChannelStats(channelIdentifier, kind, amount)
+	if err != nil {
+		log.Error(fmt.Sprintf("AddChannelStats %s err %s", utils.HPex(channelIdentifier), err))
+	}
+}
+
+/*
+transferSentFailedError 交易失败时交给调用者的错误,
+每条路由失败的原因以结构化的形式放在 data 中,错误码取最后一条路由的失败原因.
+*/
+func transferSentFailedError(e *transfer.EventTransferSentFailed) error {
+	if len(e.Failures) == 0 {
+		return errors.New(e.Reason)
+	}
+	code := e.Failures[len(e.Failures)-1].ErrorCode
+	if code == 0 {
+		//错误码为0会被当作成功
+		code = rerr.ErrNoAvailabeRoute.ErrorCode
+	}
+	return rerr.StandardError{
+		ErrorCode: code,
+		ErrorMsg:  e.Reason,
+	}.WithData(e.Failures)
+}
+
 //remove the successful transfer's state manager
 func (eh *stateMachineEventHandler) finishOneTransfer(ev transfer.Event) {
 	var err error
 	var lockSecretHash common.Hash
 	var tokenAddress common.Address
+	var outcome interface{}
 	switch e2 := ev.(type) {
 	case *transfer.EventTransferSentSuccess:
-		log.Info(fmt.Sprintf("EventTransferSentSuccess for LockSecretHash %s ", e2.LockSecretHash.String()))
+		log.Info(fmt.Sprintf("EventTransferSentSuccess for LockSecretHash %s,outcome=%s", e2.LockSecretHash.String(), e2.Outcome), utils.TransferLogCtx(e2.LockSecretHash, e2.Token)...)
 		lockSecretHash = e2.LockSecretHash
 		tokenAddress = e2.Token
+		outcome = e2.Outcome
 		err = nil
 	case *transfer.EventTransferSentFailed:
-		log.Warn(fmt.Sprintf("EventTransferSentFailed for LockSecretHash %s,because of %s", e2.LockSecretHash.String(), e2.Reason))
+		log.Warn(fmt.Sprintf("EventTransferSentFailed for LockSecretHash %s,because of %s", e2.LockSecretHash.String(), e2.Reason), utils.TransferLogCtx(e2.LockSecretHash, e2.Token)...)
 		lockSecretHash = e2.LockSecretHash
-		err = errors.New(e2.Reason)
+		err = transferSentFailedError(e2)
 		tokenAddress = e2.Token
 	default:
 		panic("unknow event")
 	}
 	if lockSecretHash != utils.EmptyHash {
 		smkey := utils.Sha3(lockSecretHash[:], tokenAddress[:])
+		eh.photon.tokenSwapTransferFinished(smkey)
 		r := eh.photon.Transfer2Result[smkey]
 		if r == nil { //restart after crash?
-			log.Error(fmt.Sprintf("transfer finished ,but have no relate results :%s", utils.StringInterface(ev, 2)))
+			log.Error(fmt.Sprintf("transfer finished ,but have no relate results :%s", utils.StringInterface(ev, 2)), utils.TransferLogCtx(lockSecretHash, tokenAddress)...)
 			return
 		}
-		r.Result <- err
+		if outcome != nil {
+			r.Tag = outcome
+		}
+		r.SetResult(err)
 		delete(eh.photon.Transfer2Result, smkey)
 	}
 }
@@ -554,6 +646,7 @@ func (eh *stateMachineEventHandler) HandleTokenAdded(st *mediatedtransfer.Contra
 		return err
 	}
 	g := graph.NewChannelGraph(eh.photon.NodeAddress, st.TokenAddress, nil)
+	g.BalanceAwareRouting = eh.photon.Config.BalanceAwareRouting
 	eh.photon.Token2TokenNetwork[tokenAddress] = utils.EmptyAddress
 	eh.photon.Token2ChannelGraph[tokenAddress] = g
 	return nil
@@ -575,9 +668,13 @@ func (eh *stateMachineEventHandler) handleChannelNew(st *mediatedtransfer.Contra
 		utils.APex2(participant1),
 		utils.APex2(participant2),
 	))
-	g := eh.photon.getToken2ChannelGraph(tokenAddress)
+	g, err := eh.photon.getOrRegisterTokenGraph(tokenAddress)
+	if err != nil {
+		log.Warn(fmt.Sprintf("ignore new channel %s, err %s", st.ChannelIdentifier.String(), err))
+		return nil
+	}
 	g.AddPath(participant1, participant2)
-	err := eh.photon.dao.NewNonParticipantChannel(tokenAddress, st.ChannelIdentifier.ChannelIdentifier, participant1, participant2)
+	err = eh.photon.dao.NewNonParticipantChannel(tokenAddress, st.ChannelIdentifier.ChannelIdentifier, participant1, participant2)
 	if err != nil {
 		log.Error(err.Error())
 		return err
@@ -595,12 +692,10 @@ func (eh *stateMachineEventHandler) handleChannelNew(st *mediatedtransfer.Contra
 			))
 			return nil
 		}
-		eh.photon.registerChannel(tokenAddress, partner, st.ChannelIdentifier, st.SettleTimeout)
-		other := participant2
-		if other == eh.photon.NodeAddress {
-			other = participant1
+		if !eh.photon.registerChannel(tokenAddress, partner, st.ChannelIdentifier, st.SettleTimeout) {
+			return nil
 		}
-		eh.photon.startHealthCheckFor(other)
+		eh.photon.startHealthCheckFor(partner)
 	} else {
 		log.Trace("ignoring new channel, this node is not a participant.")
 	}
@@ -659,6 +754,12 @@ func (eh *stateMachineEventHandler) handleClosed(st *mediatedtransfer.ContractCl
 	if err != nil {
 		log.Error(fmt.Sprintf("handleBalance ChannelStateTransition err=%s", err))
 	}
+	if st.ClosingAddress != eh.photon.NodeAddress {
+		if eh.photon.Config.AutoRespondToClose {
+			eh.photon.respondToPartnerClose(ch, ch.ExternState.TokenNetwork)
+		}
+		eh.photon.notifyPartnerClosed(ch)
+	}
 	err = eh.photon.UpdateChannelState(channel.NewChannelSerialization(ch))
 	return err
 }
@@ -691,6 +792,11 @@ func (eh *stateMachineEventHandler) removeSettledChannel(ch *channel.Channel) er
 		return err
 	}
 	err = eh.photon.dao.RemoveNonParticipantChannel(ch.ChannelIdentifier.ChannelIdentifier)
+	eh.photon.dao.RemoveTransferMessagesOnChannel(ch.ChannelIdentifier.ChannelIdentifier)
+	eh.photon.channelSettledSubscribers.publish(ch.ChannelIdentifier.ChannelIdentifier, ch.Balance())
+	if !eh.photon.hasChannelWith(ch.PartnerState.Address) {
+		eh.photon.StopHealthCheckFor(ch.PartnerState.Address)
+	}
 	/*
 		通知上层
 	*/
@@ -785,6 +891,15 @@ func (eh *stateMachineEventHandler) handleWithdraw(st *mediatedtransfer.Contract
 	// 通知该通道下所有存在pending lock的state manager,可以放心的announce disposed或者尝试新路由了
 	// nofity all statemanager with pending locks, and send announce disposed or try new route.
 	eh.dispatchByPendingLocksInChannel(ch, st)
+	/*
+		withdraw 完成以后通道回到 StateOpened,只是余额变少了,可以继续交易.
+		容量变化要告诉 pfs,withdraw 期间因为没有路由而排队的交易由 UpdateChannelState 触发重试
+	*/
+	if ch.State == channeltype.StateOpened {
+		log.Info(fmt.Sprintf("channel %s reopened after withdraw,our balance=%s,partner balance=%s",
+			utils.HPex(ch.ChannelIdentifier.ChannelIdentifier), ch.OurState.ContractBalance, ch.PartnerState.ContractBalance))
+		eh.photon.submitBalanceProofToPfs(ch)
+	}
 	return err
 }
 
@@ -899,6 +1014,27 @@ func (eh *stateMachineEventHandler) handleBlockStateChange(st *transfer.BlockSta
 	return nil
 }
 
+/*
+handleChainReorg 分叉块中打包的 tx 可能已经不在链上了,改回 pending 状态重新监控,
+重新打包以后会再次更新为成功或者失败
+*/
+func (eh *stateMachineEventHandler) handleChainReorg(st *transfer.ChainReorgStateChange) (err error) {
+	list, err := eh.photon.dao.GetTXInfoListPackedSince(st.ForkBlockNumber)
+	if err != nil {
+		return
+	}
+	for _, txInfo := range list {
+		log.Warn(fmt.Sprintf("tx %s packed at %d may be reorged out,monitor it again", txInfo.TXHash.String(), txInfo.PackBlockNumber))
+		txInfo, err = eh.photon.dao.UpdateTXInfoStatus(txInfo.TXHash, models.TXInfoStatusPending, 0, 0)
+		if err != nil {
+			return
+		}
+		eh.photon.NotifyHandler.NotifyContractCallTXInfo(txInfo)
+		eh.photon.Chain.RegisterReorgedTXInfo(txInfo)
+	}
+	return
+}
+
 /*
 	处理有效公链/无效公链状态切换的相关逻辑
 */
@@ -978,7 +1114,8 @@ func (eh *stateMachineEventHandler) startNoEffectiveChainNotifyLoop() {
 	if eh.noEffectiveChainNotifyLoopQuitChan == nil {
 		eh.noEffectiveChainNotifyLoopQuitChan = make(chan *struct{})
 	}
-	start := time.Now()
+	clock := eh.photon.Clock
+	start := clock.Now()
 	hasUpdateDelegateState := false
 	periodBlock := eh.photon.getMinSettleTimeout() / 10
 	var periodBlockSecond time.Duration
@@ -992,13 +1129,13 @@ func (eh *stateMachineEventHandler) startNoEffectiveChainNotifyLoop() {
 		select {
 		case <-eh.noEffectiveChainNotifyLoopQuitChan:
 			return
-		case <-time.After(periodSecond):
-			t := time.Since(time.Unix(eh.photon.EffectiveChangeTimestamp, 0)).Round(time.Second)
+		case <-clock.After(periodSecond):
+			t := clock.Now().Sub(time.Unix(eh.photon.EffectiveChangeTimestamp, 0)).Round(time.Second)
 			warning := fmt.Sprintf("photon has been worked without effective block chain for about %s", t)
 			eh.photon.NotifyHandler.NotifyString(notify.LevelWarn, warning)
 			log.Warn(warning)
 			// 如果进入无效公链的时间超过了最小SettleTimeout一半的时间,修改通道pms状态,该操作直到切入有效网络之前只进行一次
-			if !hasUpdateDelegateState && time.Since(start) > time.Duration(eh.photon.getMinSettleTimeout())/2*periodBlockSecond {
+			if !hasUpdateDelegateState && clock.Now().Sub(start) > time.Duration(eh.photon.getMinSettleTimeout())/2*periodBlockSecond {
 				channelList, err := eh.photon.dao.GetChannelList(utils.EmptyAddress, utils.EmptyAddress)
 				if err != nil {
 					log.Error(err.Error())
@@ -1041,6 +1178,7 @@ func (eh *stateMachineEventHandler) ChannelStateTransition(c *channel.Channel, s
 			c.State = channeltype.StateClosed
 			c.ExternState.SetClosed(st2.ClosedBlock)
 			c.ExternState.SetSettled(st2.ClosedBlock + int64(c.SettleTimeout) + params.PunishBlockNumber)
+			//对方的 balance proof 在 handleClosed 中比较过合约上的 nonce 以后再提交
 			c.HandleClosed(st2.ClosingAddress, st2.TransferredAmount, st2.LocksRoot)
 		} else {
 			log.Warn(fmt.Sprintf("channel closed on a different block or close event happened twice channel=%s,closedblock=%d,thisblock=%d",
@@ -1082,6 +1220,9 @@ func (eh *stateMachineEventHandler) ChannelStateTransition(c *channel.Channel, s
 		}
 		if channelState.ContractBalance.Cmp(balance) != 0 {
 			err = channelState.UpdateContractBalance(balance)
+			if err == nil {
+				channelState.DepositBlockNumber = st2.BlockNumber
+			}
 		}
 	case *mediatedtransfer.ContractUnlockStateChange:
 		var channelState *channel.EndState
@@ -1149,6 +1290,8 @@ func (eh *stateMachineEventHandler) OnBlockchainStateChange(st transfer.StateCha
 		err = eh.handleBlockStateChange(st2)
 	case *transfer.EffectiveChainStateChange:
 		err = eh.handleEffectiveChainStateChange(st2)
+	case *transfer.ChainReorgStateChange:
+		err = eh.handleChainReorg(st2)
 	default:
 		err = fmt.Errorf("OnBlockchainStateChange unknown statechange :%s", utils.StringInterface1(st))
 		log.Error(err.Error())
@@ -0,0 +1,182 @@
+package photon
+
+import (
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+导出文件格式: 8字节 stateExportMagic + 4字节大端版本号 + gob 编码的 exportedState
+格式与存储引擎无关,可以在不同机器,不同数据库之间迁移节点
+*/
+const stateExportVersion uint32 = 1
+
+var stateExportMagic = [8]byte{'P', 'H', 'O', 'T', 'O', 'N', 'S', 'T'}
+
+type exportedState struct {
+	NodeAddress       common.Address
+	ChainID           int64
+	LatestBlockNumber int64
+	Tokens            models.AddressMap
+	Channels          []*channeltype.Serialization
+	//没有收到ack的消息,导入以后重启会继续发送
+	SentEnvelopMessagers []*models.SentEnvelopMessager
+	UnlockToSends        []*models.UnlockToSend
+	//已经回复过的 ack,对方在迁移以后重发同一条消息时不会被重复处理
+	Acks []*models.Ack
+}
+
+/*
+ExportState 导出通道,token,ack以及未完成的交易,用于迁移节点
+*/
+func (rs *Service) ExportState(w io.Writer) (err error) {
+	s := &exportedState{
+		NodeAddress:          rs.NodeAddress,
+		ChainID:              rs.dao.GetChainID(),
+		LatestBlockNumber:    rs.dao.GetLatestBlockNumber(),
+		SentEnvelopMessagers: rs.dao.GetAllOrderedSentEnvelopMessager(),
+		UnlockToSends:        rs.dao.GetAllUnlockToSend(),
+	}
+	s.Tokens, err = rs.dao.GetAllTokens()
+	if err != nil {
+		return
+	}
+	s.Channels, err = rs.dao.GetChannelList(utils.EmptyAddress, utils.EmptyAddress)
+	if err != nil {
+		return
+	}
+	s.Acks, err = rs.dao.GetAllAcks()
+	if err != nil {
+		return
+	}
+	_, err = w.Write(stateExportMagic[:])
+	if err != nil {
+		return
+	}
+	err = binary.Write(w, binary.BigEndian, stateExportVersion)
+	if err != nil {
+		return
+	}
+	err = gob.NewEncoder(w).Encode(s)
+	if err != nil {
+		return
+	}
+	log.Info(fmt.Sprintf("export state tokens=%d,channels=%d,pending messages=%d,unlocks to send=%d,acks=%d",
+		len(s.Tokens), len(s.Channels), len(s.SentEnvelopMessagers), len(s.UnlockToSends), len(s.Acks)))
+	return
+}
+
+/*
+ImportState 导入 ExportState 导出的数据,必须在 Start 之前调用.
+只能导入同一个账户在同一条链上导出的数据,并且本节点不能有任何通道.
+所有数据在一个事务中写入,失败时数据库保持不变,可以重新导入
+*/
+func (rs *Service) ImportState(r io.Reader) (err error) {
+	var magic [8]byte
+	_, err = io.ReadFull(r, magic[:])
+	if err != nil {
+		return rerr.ErrArgumentError.Printf("read state header err %s", err)
+	}
+	if magic != stateExportMagic {
+		return rerr.ErrArgumentError.Append("not a photon state file")
+	}
+	var version uint32
+	err = binary.Read(r, binary.BigEndian, &version)
+	if err != nil {
+		return rerr.ErrArgumentError.Printf("read state version err %s", err)
+	}
+	if version != stateExportVersion {
+		return rerr.ErrArgumentError.Printf("unsupported state version %d,expect %d", version, stateExportVersion)
+	}
+	s := new(exportedState)
+	err = gob.NewDecoder(r).Decode(s)
+	if err != nil {
+		return rerr.ErrArgumentError.Printf("decode state err %s", err)
+	}
+	if s.NodeAddress != rs.NodeAddress {
+		return rerr.ErrArgumentError.Printf("state belongs to %s,but this node is %s", s.NodeAddress.String(), rs.NodeAddress.String())
+	}
+	chainID := rs.dao.GetChainID()
+	if chainID != 0 && s.ChainID != 0 && chainID != s.ChainID {
+		return rerr.ErrArgumentError.Printf("state is exported on chain %d,but this node is on chain %d", s.ChainID, chainID)
+	}
+	chs, err := rs.dao.GetChannelList(utils.EmptyAddress, utils.EmptyAddress)
+	if err != nil {
+		return
+	}
+	if len(chs) > 0 {
+		return rerr.ErrChannelAlreadExist.Printf("node already has %d channels,refuse to import state", len(chs))
+	}
+	//写事务中不能再读数据库,所以先读出需要合并的数据
+	tokens, err := rs.dao.GetAllTokens()
+	if err != nil {
+		return
+	}
+	for token, tokenNetwork := range s.Tokens {
+		if tokens[token] == utils.EmptyAddress {
+			tokens[token] = tokenNetwork
+		}
+	}
+	latestBlockNumber := rs.dao.GetLatestBlockNumber()
+	tx := rs.dao.StartTx()
+	defer func() {
+		if err != nil {
+			if err2 := tx.Rollback(); err2 != nil {
+				log.Error(fmt.Sprintf("import state rollback err %s", err2))
+			}
+			return
+		}
+		err = models.GeneratDBError(tx.Commit())
+	}()
+	err = tx.Set(models.BucketToken, models.KeyToken, tokens)
+	if err != nil {
+		return
+	}
+	for _, c := range s.Channels {
+		c.UpdateAt = time.Now().Unix()
+		err = tx.Save(c)
+		if err != nil {
+			return
+		}
+	}
+	for _, m := range s.SentEnvelopMessagers {
+		err = tx.Save(m)
+		if err != nil {
+			return
+		}
+	}
+	for _, u := range s.UnlockToSends {
+		err = tx.Save(u)
+		if err != nil {
+			return
+		}
+	}
+	for _, a := range s.Acks {
+		rs.dao.SaveAck(a.EchoHash, a.Ack, a.BlockNumber, tx)
+	}
+	if s.ChainID != 0 && chainID == 0 {
+		err = tx.Set(models.BucketChainID, models.KeyChainID, s.ChainID)
+		if err != nil {
+			return
+		}
+	}
+	if s.LatestBlockNumber > latestBlockNumber {
+		err = tx.Set(models.BucketBlockNumber, models.KeyBlockNumber, s.LatestBlockNumber)
+		if err != nil {
+			return
+		}
+	}
+	log.Info(fmt.Sprintf("import state tokens=%d,channels=%d,pending messages=%d,unlocks to send=%d,acks=%d",
+		len(s.Tokens), len(s.Channels), len(s.SentEnvelopMessagers), len(s.UnlockToSends), len(s.Acks)))
+	return
+}
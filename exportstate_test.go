@@ -0,0 +1,116 @@
+package photon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportImportState(t *testing.T) {
+	node := utils.NewRandomAddress()
+	newDB := func(name string) models.Dao {
+		dbPath := path.Join(os.TempDir(), name)
+		os.RemoveAll(dbPath)
+		os.RemoveAll(dbPath + ".lock")
+		return codefortest.NewTestDB(dbPath)
+	}
+	dao1 := newDB("testexport1.db")
+	defer dao1.CloseDB()
+	token, tokenNetwork, partner := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	assert.Nil(t, dao1.AddToken(token, tokenNetwork))
+	h := utils.NewRandomHash()
+	err := dao1.NewChannel(&channeltype.Serialization{
+		ChannelIdentifier: &contracts.ChannelUniqueID{
+			ChannelIdentifier: h,
+			OpenBlockNumber:   3,
+		},
+		Key:                 h[:],
+		TokenAddressBytes:   token[:],
+		PartnerAddressBytes: partner[:],
+	})
+	assert.Nil(t, err)
+	lockSecretHash := utils.NewRandomHash()
+	dao1.NewUnlockToSend(lockSecretHash, token, partner, 10)
+	dao1.SaveLatestBlockNumber(20)
+	echoHash := utils.NewRandomHash()
+	dao1.SaveAckNoTx(echoHash, []byte("ack"), 15)
+
+	var buf bytes.Buffer
+	rs1 := &Service{dao: dao1, NodeAddress: node}
+	assert.Nil(t, rs1.ExportState(&buf))
+	data := buf.Bytes()
+
+	dao2 := newDB("testexport2.db")
+	defer dao2.CloseDB()
+	//其他账户的数据不能导入
+	rs2 := &Service{dao: dao2, NodeAddress: utils.NewRandomAddress()}
+	assert.NotNil(t, rs2.ImportState(bytes.NewReader(data)))
+	//版本不对
+	bad := append([]byte{}, data...)
+	bad[len(stateExportMagic)+3]++
+	rs2.NodeAddress = node
+	assert.NotNil(t, rs2.ImportState(bytes.NewReader(bad)))
+
+	assert.Nil(t, rs2.ImportState(bytes.NewReader(data)))
+	tokens, err := dao2.GetAllTokens()
+	assert.Nil(t, err)
+	assert.EqualValues(t, tokenNetwork, tokens[token])
+	c, err := dao2.GetChannel(token, partner)
+	assert.Nil(t, err)
+	assert.EqualValues(t, h, c.ChannelIdentifier.ChannelIdentifier)
+	assert.Len(t, dao2.GetAllUnlockToSend(), 1)
+	assert.EqualValues(t, 20, dao2.GetLatestBlockNumber())
+	assert.EqualValues(t, []byte("ack"), dao2.GetAck(echoHash))
+	//ack 的块号也要导入,否则永远不会被清理
+	removed, err := dao2.RemoveAcksBefore(16)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, removed)
+	//已经有通道了,拒绝再次导入
+	assert.NotNil(t, rs2.ImportState(bytes.NewReader(data)))
+}
+
+func TestImportStateAtomic(t *testing.T) {
+	dbPath := path.Join(os.TempDir(), "testimportatomic.db")
+	os.RemoveAll(dbPath)
+	os.RemoveAll(dbPath + ".lock")
+	dao := codefortest.NewTestDB(dbPath)
+	defer dao.CloseDB()
+	node, token := utils.NewRandomAddress(), utils.NewRandomAddress()
+	h := utils.NewRandomHash()
+	s := &exportedState{
+		NodeAddress:       node,
+		LatestBlockNumber: 20,
+		Tokens:            models.AddressMap{token: utils.NewRandomAddress()},
+		Channels: []*channeltype.Serialization{
+			{
+				ChannelIdentifier: &contracts.ChannelUniqueID{ChannelIdentifier: h},
+				Key:               h[:],
+			},
+			//没有 key 的通道无法保存,整个导入都要回滚
+			{ChannelIdentifier: &contracts.ChannelUniqueID{}},
+		},
+	}
+	var buf bytes.Buffer
+	buf.Write(stateExportMagic[:])
+	assert.Nil(t, binary.Write(&buf, binary.BigEndian, stateExportVersion))
+	assert.Nil(t, gob.NewEncoder(&buf).Encode(s))
+	rs := &Service{dao: dao, NodeAddress: node}
+	assert.NotNil(t, rs.ImportState(&buf))
+	tokens, err := dao.GetAllTokens()
+	assert.Nil(t, err)
+	assert.Empty(t, tokens)
+	chs, err := dao.GetChannelList(utils.EmptyAddress, utils.EmptyAddress)
+	assert.Nil(t, err)
+	assert.Empty(t, chs)
+	assert.EqualValues(t, 0, dao.GetLatestBlockNumber())
+}
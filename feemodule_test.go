@@ -120,7 +120,7 @@ func newTestStormDb() (dao models.Dao, err error) {
 	dbPath := path.Join(os.TempDir(), "testxxxx.dao")
 	err = os.Remove(dbPath)
 	err = os.Remove(dbPath + ".lock")
-	return stormdb.OpenDb(dbPath)
+	return stormdb.OpenDb(dbPath, "")
 }
 
 func TestUninitMapMap(t *testing.T) {
@@ -0,0 +1,231 @@
+package photon
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/encoding"
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//feeQuoteValidDuration 询价结果只短暂缓存,中间节点随时可能调整费率
+const feeQuoteValidDuration = 30 * time.Second
+
+//feeQuoteKey Amount 是中间节点要转给 NextHop 的金额,包含了后面所有中间节点的手续费
+type feeQuoteKey struct {
+	Mediator     common.Address
+	TokenAddress common.Address
+	NextHop      common.Address
+	Amount       string
+}
+
+type feeQuote struct {
+	Fee         *big.Int
+	ReceiveTime time.Time
+}
+
+//feeQuoteWaiter 回复必须和询价的中间节点,token,下一跳以及金额一致
+type feeQuoteWaiter struct {
+	key feeQuoteKey
+	ch  chan *big.Int
+}
+
+/*
+feeQuoteCache 保存中间节点的报价,
+报价在主线程中收到,而询价是在api的goroutine中等待,所以需要锁保护.
+每次保存报价时清理过期的报价,所以缓存大小取决于 feeQuoteValidDuration 内询价的次数
+*/
+type feeQuoteCache struct {
+	lock    sync.Mutex
+	quotes  map[feeQuoteKey]*feeQuote
+	waiters map[common.Hash]*feeQuoteWaiter
+}
+
+func newFeeQuoteCache() *feeQuoteCache {
+	return &feeQuoteCache{
+		quotes:  make(map[feeQuoteKey]*feeQuote),
+		waiters: make(map[common.Hash]*feeQuoteWaiter),
+	}
+}
+
+func (c *feeQuoteCache) get(key feeQuoteKey) *big.Int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	q, ok := c.quotes[key]
+	if !ok {
+		return nil
+	}
+	if time.Since(q.ReceiveTime) > feeQuoteValidDuration {
+		delete(c.quotes, key)
+		return nil
+	}
+	return q.Fee
+}
+
+func (c *feeQuoteCache) addWaiter(quoteID common.Hash, key feeQuoteKey) chan *big.Int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	ch := make(chan *big.Int, 1)
+	c.waiters[quoteID] = &feeQuoteWaiter{key: key, ch: ch}
+	return ch
+}
+
+func (c *feeQuoteCache) removeWaiter(quoteID common.Hash) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.waiters, quoteID)
+}
+
+//pruneExpired 删除过期的报价,调用者必须持有锁
+func (c *feeQuoteCache) pruneExpired(now time.Time) {
+	for key, q := range c.quotes {
+		if now.Sub(q.ReceiveTime) > feeQuoteValidDuration {
+			delete(c.quotes, key)
+		}
+	}
+}
+
+/*
+onResponse 只接受自己发出的询价的回复,
+和询价不一致或者手续费无效的回复直接拒绝,继续等待正确的回复直到超时
+*/
+func (c *feeQuoteCache) onResponse(msg *encoding.FeeQuoteResponse) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	w, ok := c.waiters[msg.QuoteID]
+	if !ok {
+		log.Warn(fmt.Sprintf("receive unexpected fee quote %s", msg))
+		return nil
+	}
+	if msg.Fee == nil || msg.Fee.Sign() < 0 {
+		return rerr.ErrInvalidAmount.Printf("fee quote %s from %s has invalid fee %s", utils.HPex(msg.QuoteID), utils.APex2(msg.Sender), msg.Fee)
+	}
+	if msg.Amount == nil {
+		return rerr.ErrArgumentError.Printf("fee quote %s from %s has no amount", utils.HPex(msg.QuoteID), utils.APex2(msg.Sender))
+	}
+	key := feeQuoteKey{
+		Mediator:     msg.Sender,
+		TokenAddress: msg.TokenAddress,
+		NextHop:      msg.NextHop,
+		Amount:       msg.Amount.String(),
+	}
+	if key != w.key {
+		return rerr.ErrArgumentError.Printf("fee quote %s from %s does not match the request", utils.HPex(msg.QuoteID), utils.APex2(msg.Sender))
+	}
+	delete(c.waiters, msg.QuoteID)
+	now := time.Now()
+	c.pruneExpired(now)
+	c.quotes[key] = &feeQuote{
+		Fee:         msg.Fee,
+		ReceiveTime: now,
+	}
+	w.ch <- msg.Fee
+	return nil
+}
+
+/*
+routeFee 从 target 往回逐跳累计手续费,每个中间节点按照它要转给下一跳的金额报价,
+这个金额是 amount 加上它后面所有中间节点的手续费.
+path 是从下一跳到target的节点,最后一个是target,不收手续费
+*/
+func routeFee(amount *big.Int, path []common.Address, quote func(mediator, nextHop common.Address, hopAmount *big.Int) (*big.Int, error)) (totalFee *big.Int, err error) {
+	totalFee = big.NewInt(0)
+	hopAmount := new(big.Int).Set(amount)
+	for i := len(path) - 2; i >= 0; i-- {
+		var fee *big.Int
+		fee, err = quote(path[i], path[i+1], hopAmount)
+		if err != nil {
+			return nil, err
+		}
+		totalFee.Add(totalFee, fee)
+		hopAmount = new(big.Int).Add(hopAmount, fee)
+	}
+	return
+}
+
+/*
+getRouteFee 路径上所有中间节点都有有效报价时返回总手续费
+*/
+func (c *feeQuoteCache) getRouteFee(tokenAddress common.Address, amount *big.Int, path []common.Address) (totalFee *big.Int, ok bool) {
+	if len(path) < 2 {
+		return nil, false
+	}
+	totalFee, err := routeFee(amount, path, func(mediator, nextHop common.Address, hopAmount *big.Int) (*big.Int, error) {
+		fee := c.get(feeQuoteKey{
+			Mediator:     mediator,
+			TokenAddress: tokenAddress,
+			NextHop:      nextHop,
+			Amount:       hopAmount.String(),
+		})
+		if fee == nil {
+			return nil, rerr.ErrNotFound
+		}
+		return fee, nil
+	})
+	return totalFee, err == nil
+}
+
+/*
+QuoteRouteFee 在发送交易之前向 path 上的每个中间节点询问当前手续费,
+结果会短暂缓存,随后 startMediatedTransferInternal 会用报价替换路由中的手续费.
+path 是从下一跳到 target 的节点列表,与 pfs 返回的路径一致.
+不能在主线程中调用.
+*/
+func (rs *Service) QuoteRouteFee(tokenAddress common.Address, amount *big.Int, path []common.Address, timeout time.Duration) (totalFee *big.Int, err error) {
+	return routeFee(amount, path, func(mediator, nextHop common.Address, hopAmount *big.Int) (*big.Int, error) {
+		fee := rs.feeQuotes.get(feeQuoteKey{
+			Mediator:     mediator,
+			TokenAddress: tokenAddress,
+			NextHop:      nextHop,
+			Amount:       hopAmount.String(),
+		})
+		if fee != nil {
+			return fee, nil
+		}
+		return rs.requestFeeQuote(mediator, tokenAddress, nextHop, hopAmount, timeout)
+	})
+}
+
+func (rs *Service) requestFeeQuote(mediator, tokenAddress, nextHop common.Address, amount *big.Int, timeout time.Duration) (fee *big.Int, err error) {
+	req := encoding.NewFeeQuoteRequest(utils.NewRandomHash(), tokenAddress, nextHop, amount)
+	err = req.SignBy(rs.Signer, req)
+	if err != nil {
+		return
+	}
+	ch := rs.feeQuotes.addWaiter(req.QuoteID, feeQuoteKey{
+		Mediator:     mediator,
+		TokenAddress: tokenAddress,
+		NextHop:      nextHop,
+		Amount:       amount.String(),
+	})
+	defer rs.feeQuotes.removeWaiter(req.QuoteID)
+	// 不经过主线程,直接交给Protocol发送
+	rs.Protocol.SendAsync(mediator, req)
+	select {
+	case fee = <-ch:
+		log.Trace(fmt.Sprintf("fee quote from %s for %s is %s", utils.APex2(mediator), amount, fee))
+	case <-time.After(timeout):
+		err = rerr.ErrTransferTimeout.Printf("wait fee quote from %s timeout", utils.APex2(mediator))
+	}
+	return
+}
+
+//messageFeeQuoteRequest 作为中间节点,按照当前费率报价
+func (mh *photonMessageHandler) messageFeeQuoteRequest(msg *encoding.FeeQuoteRequest) error {
+	rs := mh.photon
+	ch := rs.getChannel(msg.TokenAddress, msg.NextHop)
+	if ch == nil {
+		return rerr.ErrNoAvailabeRoute.Printf("no channel with %s on token %s", utils.APex2(msg.NextHop), utils.APex2(msg.TokenAddress))
+	}
+	res := encoding.NewFeeQuoteResponse(msg, rs.GetNodeChargeFee(msg.NextHop, msg.TokenAddress, msg.Amount))
+	err := res.SignBy(rs.Signer, res)
+	if err != nil {
+		return err
+	}
+	return rs.sendAsync(msg.Sender, res)
+}
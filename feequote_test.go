@@ -0,0 +1,167 @@
+package photon
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/encoding"
+	"github.com/SmartMeshFoundation/Photon/network/graph"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/pfsproxy"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestFeeQuoteResponse(mediator, token, nextHop common.Address, amount, fee int64) *encoding.FeeQuoteResponse {
+	req := encoding.NewFeeQuoteRequest(utils.NewRandomHash(), token, nextHop, big.NewInt(amount))
+	res := encoding.NewFeeQuoteResponse(req, big.NewInt(fee))
+	res.Sender = mediator
+	return res
+}
+
+func newTestFeeQuoteKey(res *encoding.FeeQuoteResponse) feeQuoteKey {
+	return feeQuoteKey{
+		Mediator:     res.Sender,
+		TokenAddress: res.TokenAddress,
+		NextHop:      res.NextHop,
+		Amount:       res.Amount.String(),
+	}
+}
+
+//只缓存自己询问过的报价,并且报价过期以后不再使用
+func TestFeeQuoteCache(t *testing.T) {
+	c := newFeeQuoteCache()
+	mediator, token, nextHop := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	key := feeQuoteKey{
+		Mediator:     mediator,
+		TokenAddress: token,
+		NextHop:      nextHop,
+		Amount:       "10",
+	}
+	//没有询问过的报价直接忽略
+	assert.Nil(t, c.onResponse(newTestFeeQuoteResponse(mediator, token, nextHop, 10, 3)))
+	assert.Nil(t, c.get(key))
+
+	res := newTestFeeQuoteResponse(mediator, token, nextHop, 10, 3)
+	ch := c.addWaiter(res.QuoteID, newTestFeeQuoteKey(res))
+	assert.Nil(t, c.onResponse(res))
+	assert.EqualValues(t, big.NewInt(3), <-ch)
+	assert.EqualValues(t, big.NewInt(3), c.get(key))
+	assert.Len(t, c.waiters, 0)
+
+	c.quotes[key].ReceiveTime = time.Now().Add(-feeQuoteValidDuration - time.Second)
+	assert.Nil(t, c.get(key))
+	assert.Len(t, c.quotes, 0)
+
+	//没有再查询的过期报价在保存新报价时被清理
+	c.quotes[key] = &feeQuote{Fee: big.NewInt(3), ReceiveTime: time.Now().Add(-feeQuoteValidDuration - time.Second)}
+	res = newTestFeeQuoteResponse(mediator, token, nextHop, 20, 3)
+	c.addWaiter(res.QuoteID, newTestFeeQuoteKey(res))
+	assert.Nil(t, c.onResponse(res))
+	assert.Len(t, c.quotes, 1)
+	assert.Nil(t, c.quotes[key])
+}
+
+//和询价不一致或者手续费无效的回复不能进入缓存,也不能结束等待
+func TestFeeQuoteInvalidResponse(t *testing.T) {
+	c := newFeeQuoteCache()
+	mediator, token, nextHop := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	res := newTestFeeQuoteResponse(mediator, token, nextHop, 10, 3)
+	ch := c.addWaiter(res.QuoteID, newTestFeeQuoteKey(res))
+	for _, bad := range []func(r *encoding.FeeQuoteResponse){
+		func(r *encoding.FeeQuoteResponse) { r.Fee = nil },
+		func(r *encoding.FeeQuoteResponse) { r.Fee = big.NewInt(-1) },
+		func(r *encoding.FeeQuoteResponse) { r.Amount = nil },
+		func(r *encoding.FeeQuoteResponse) { r.Amount = big.NewInt(11) },
+		func(r *encoding.FeeQuoteResponse) { r.Sender = utils.NewRandomAddress() },
+		func(r *encoding.FeeQuoteResponse) { r.NextHop = utils.NewRandomAddress() },
+	} {
+		r := *res
+		bad(&r)
+		assert.NotNil(t, c.onResponse(&r))
+	}
+	assert.Len(t, c.quotes, 0)
+	assert.Len(t, c.waiters, 1)
+	assert.Nil(t, c.onResponse(res))
+	assert.EqualValues(t, big.NewInt(3), <-ch)
+}
+
+//每个中间节点按照它要转给下一跳的金额报价,这个金额包含后面中间节点的手续费
+func TestFeeQuoteRouteFee(t *testing.T) {
+	c := newFeeQuoteCache()
+	m1, m2, target, token := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	path := []common.Address{m1, m2, target}
+	amount := big.NewInt(100)
+	_, ok := c.getRouteFee(token, amount, []common.Address{target})
+	assert.False(t, ok)
+
+	for _, res := range []*encoding.FeeQuoteResponse{
+		newTestFeeQuoteResponse(m2, token, target, 100, 2),
+		//m1 按照 100 报价的结果不能使用
+		newTestFeeQuoteResponse(m1, token, m2, 100, 7),
+	} {
+		c.addWaiter(res.QuoteID, newTestFeeQuoteKey(res))
+		assert.Nil(t, c.onResponse(res))
+	}
+	_, ok = c.getRouteFee(token, amount, path)
+	assert.False(t, ok)
+
+	res := newTestFeeQuoteResponse(m1, token, m2, 102, 5)
+	c.addWaiter(res.QuoteID, newTestFeeQuoteKey(res))
+	assert.Nil(t, c.onResponse(res))
+	fee, ok := c.getRouteFee(token, amount, path)
+	assert.True(t, ok)
+	assert.EqualValues(t, big.NewInt(7), fee)
+
+	//所有报价都在缓存中时不需要再询问中间节点
+	rs := &Service{feeQuotes: c}
+	fee, err := rs.QuoteRouteFee(token, amount, path, time.Millisecond)
+	assert.Nil(t, err)
+	assert.EqualValues(t, big.NewInt(7), fee)
+}
+
+//有询价结果时,路由的手续费以询价结果为准
+func TestFindMediatedRoutesUseFeeQuote(t *testing.T) {
+	our, m1, m2, target, token := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	ch, err := channel.NewChannel(channel.NewChannelEndState(our, big.NewInt(200), nil, mtree.EmptyTree),
+		channel.NewChannelEndState(m1, big.NewInt(0), nil, mtree.EmptyTree), &channel.ExternalState{}, token,
+		&contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash(), OpenBlockNumber: 3}, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewChannelGraph(our, token, nil)
+	assert.Nil(t, g.AddChannel(ch))
+	rs := &Service{
+		NodeAddress:        our,
+		Config:             &params.Config{AllowOfflineFirstHop: true},
+		IsChainEffective:   true,
+		Token2ChannelGraph: map[common.Address]*graph.ChannelGraph{token: g},
+		feeQuotes:          newFeeQuoteCache(),
+	}
+	routeInfo := []pfsproxy.FindPathResponse{{
+		Result: []string{m1.String(), m2.String(), target.String()},
+		Fee:    big.NewInt(100),
+	}}
+	amount := big.NewInt(50)
+	routes, err := rs.findMediatedRoutes(token, target, amount, routeInfo)
+	if assert.Nil(t, err) && assert.Len(t, routes, 1) {
+		assert.EqualValues(t, big.NewInt(100), routes[0].TotalFee)
+	}
+
+	for _, res := range []*encoding.FeeQuoteResponse{
+		newTestFeeQuoteResponse(m2, token, target, 50, 1),
+		newTestFeeQuoteResponse(m1, token, m2, 51, 2),
+	} {
+		rs.feeQuotes.addWaiter(res.QuoteID, newTestFeeQuoteKey(res))
+		assert.Nil(t, rs.feeQuotes.onResponse(res))
+	}
+	routes, err = rs.findMediatedRoutes(token, target, amount, routeInfo)
+	if assert.Nil(t, err) && assert.Len(t, routes, 1) {
+		assert.EqualValues(t, big.NewInt(3), routes[0].TotalFee)
+	}
+}
@@ -0,0 +1,111 @@
+package photon
+
+import (
+	"math/big"
+
+	"github.com/SmartMeshFoundation/Photon/encoding"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/transfer"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//channel operations which can be estimated by EstimateChannelOperationGas
+const (
+	GasOpDeposit           = "deposit"
+	GasOpClose             = "close"
+	GasOpSettle            = "settle"
+	GasOpCooperativeSettle = "cooperativeSettle"
+	GasOpWithdraw          = "withdraw"
+)
+
+/*
+EstimateOpenChannelGas 估算创建通道并存款需要的gas,此时还没有通道标识,所以单独提供
+*/
+func (rs *Service) EstimateOpenChannelGas(tokenAddress, partnerAddress common.Address, settleTimeout int, amount *big.Int) (*big.Int, error) {
+	tokenNetwork, err := rs.Chain.TokenNetwork(tokenAddress)
+	if err != nil {
+		return nil, err
+	}
+	return tokenNetwork.EstimateNewChannelAndDepositGas(rs.NodeAddress, partnerAddress, settleTimeout, amount)
+}
+
+/*
+EstimateChannelOperationGas 估算通道上的链上操作需要的gas,参数和真正发起交易时一样从通道的当前状态构造,
+amount 只对 deposit 和 withdraw 有意义.
+withdraw 和 cooperativeSettle 的参数和发送 WithdrawRequest,SettleRequest 时一样构造并签名,
+但是对方的签名只有协商以后才能拿到,这里用空签名代替,合约验证签名的话估算会返回 ErrTxWouldRevert.
+拿到对方签名以后可以调用 TokenNetworkProxy 上对应的 Estimate 方法.
+*/
+func (rs *Service) EstimateChannelOperationGas(op string, channelIdentifier common.Hash, amount *big.Int) (*big.Int, error) {
+	c, err := rs.dao.GetChannelByAddress(channelIdentifier)
+	if err != nil {
+		return nil, rerr.ErrChannelNotFound.AppendError(err)
+	}
+	tokenNetwork, err := rs.Chain.TokenNetwork(c.TokenAddress())
+	if err != nil {
+		return nil, err
+	}
+	partner := c.PartnerAddress()
+	switch op {
+	case GasOpDeposit:
+		return tokenNetwork.EstimateNewChannelAndDepositGas(rs.NodeAddress, partner, 0, amount)
+	case GasOpClose:
+		bp := c.PartnerBalanceProof
+		if bp == nil {
+			bp = transfer.NewEmptyBalanceProofState()
+		}
+		return tokenNetwork.EstimateCloseChannelGas(partner, bp.TransferAmount, bp.LocksRoot, bp.Nonce, bp.MessageHash, bp.Signature)
+	case GasOpSettle:
+		myAmount, myLocksroot := contractTransferAmountAndLocksroot(c.OurBalanceProof)
+		partnerAmount, partnerLocksroot := contractTransferAmountAndLocksroot(c.PartnerBalanceProof)
+		return tokenNetwork.EstimateSettleChannelGas(rs.NodeAddress, partner, myAmount, partnerAmount, myLocksroot, partnerLocksroot)
+	case GasOpWithdraw:
+		if len(c.OurLeaves) > 0 || len(c.PartnerLeaves) > 0 {
+			return nil, rerr.ErrChannelWithdrawButHasLocks
+		}
+		d := new(encoding.WithdrawRequestData)
+		d.ChannelIdentifier = c.ChannelIdentifier.ChannelIdentifier
+		d.OpenBlockNumber = c.ChannelIdentifier.OpenBlockNumber
+		d.Participant1 = rs.NodeAddress
+		d.Participant2 = partner
+		d.Participant1Balance = c.OurBalance()
+		d.Participant1Withdraw = amount
+		if amount == nil || amount.Cmp(d.Participant1Balance) > 0 {
+			return nil, rerr.ErrChannelWithdrawAmount.Printf("withdraw amount %s,balance=%s", amount, d.Participant1Balance)
+		}
+		w := encoding.NewWithdrawRequest(d)
+		err = w.SignBy(rs.Signer, w)
+		if err != nil {
+			return nil, err
+		}
+		return tokenNetwork.EstimateWithdrawGas(w.Participant1, w.Participant2, w.Participant1Balance, w.Participant1Withdraw,
+			w.Participant1Signature, make([]byte, 65))
+	case GasOpCooperativeSettle:
+		if len(c.OurLeaves) > 0 || len(c.PartnerLeaves) > 0 {
+			return nil, rerr.ErrChannelCooperativeSettleButHasLocks
+		}
+		d := new(encoding.SettleRequestData)
+		d.ChannelIdentifier = c.ChannelIdentifier.ChannelIdentifier
+		d.OpenBlockNumber = c.ChannelIdentifier.OpenBlockNumber
+		d.Participant1 = rs.NodeAddress
+		d.Participant2 = partner
+		d.Participant1Balance = c.OurBalance()
+		d.Participant2Balance = c.PartnerBalance()
+		s := encoding.NewSettleRequest(d)
+		err = s.SignBy(rs.Signer, s)
+		if err != nil {
+			return nil, err
+		}
+		return tokenNetwork.EstimateCooperativeSettleGas(s.Participant1, s.Participant2, s.Participant1Balance, s.Participant2Balance,
+			s.Participant1Signature, make([]byte, 65))
+	}
+	return nil, rerr.ErrArgumentError.Printf("unknown channel operation %s", op)
+}
+
+func contractTransferAmountAndLocksroot(bp *transfer.BalanceProofState) (*big.Int, common.Hash) {
+	if bp == nil || bp.ContractTransferAmount == nil {
+		return utils.BigInt0, utils.EmptyHash
+	}
+	return bp.ContractTransferAmount, bp.ContractLocksRoot
+}
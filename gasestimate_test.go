@@ -0,0 +1,154 @@
+package photon
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/network/helper"
+	"github.com/SmartMeshFoundation/Photon/network/rpc"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/transfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	gethrpc "github.com/ethereum/go-ethereum/rpc"
+	"github.com/stretchr/testify/assert"
+)
+
+//FakeEstimateGasAPI 模拟公链节点的 eth_estimateGas,rpc.Server 只接受导出的类型
+type FakeEstimateGasAPI struct {
+	Data hexutil.Bytes
+}
+
+//EstimateGasArgs eth_estimateGas 的参数中需要检查的部分
+type EstimateGasArgs struct {
+	Data hexutil.Bytes
+}
+
+//EstimateGas 记录参数并返回固定的gas
+func (api *FakeEstimateGasAPI) EstimateGas(args EstimateGasArgs) (hexutil.Uint64, error) {
+	api.Data = args.Data
+	return 50000, nil
+}
+
+//关闭通道的估算使用通道中对方的 BalanceProof,和真正关闭时一样
+func TestEstimateChannelOperationGas(t *testing.T) {
+	our, partner, token := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	ch, err := channel.NewChannel(channel.NewChannelEndState(our, big.NewInt(100), nil, mtree.EmptyTree),
+		channel.NewChannelEndState(partner, big.NewInt(50), nil, mtree.EmptyTree), &channel.ExternalState{}, token,
+		&contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash(), OpenBlockNumber: 3}, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bp := &transfer.BalanceProofState{
+		Nonce:          3,
+		TransferAmount: big.NewInt(7),
+		LocksRoot:      utils.NewRandomHash(),
+		MessageHash:    utils.NewRandomHash(),
+		Signature:      make([]byte, 65),
+	}
+	ch.PartnerState.BalanceProofState = bp
+	api := &FakeEstimateGasAPI{}
+	server := gethrpc.NewServer()
+	assert.Nil(t, server.RegisterName("eth", api))
+	defer server.Stop()
+	rs := &Service{
+		NodeAddress: our,
+		Chain: &rpc.BlockChainService{
+			NodeAddress:   our,
+			Client:        &helper.SafeEthClient{Client: ethclient.NewClient(gethrpc.DialInProc(server))},
+			RegistryProxy: &rpc.RegistryProxy{Address: utils.NewRandomAddress()},
+		},
+		dao: codefortest.NewTestDB(""),
+	}
+	defer rs.dao.CloseDB()
+	assert.Nil(t, rs.dao.NewChannel(channel.NewChannelSerialization(ch)))
+
+	_, err = rs.EstimateChannelOperationGas(GasOpClose, utils.NewRandomHash(), nil)
+	assert.Equal(t, rerr.ErrChannelNotFound.ErrorCode, err.(rerr.StandardError).ErrorCode)
+	_, err = rs.EstimateChannelOperationGas("unknown", ch.ChannelIdentifier.ChannelIdentifier, nil)
+	assert.Equal(t, rerr.ErrArgumentError.ErrorCode, err.(rerr.StandardError).ErrorCode)
+
+	gas, err := rs.EstimateChannelOperationGas(GasOpClose, ch.ChannelIdentifier.ChannelIdentifier, nil)
+	assert.Nil(t, err)
+	assert.EqualValues(t, big.NewInt(50000), gas)
+	parsed, err := abi.JSON(strings.NewReader(contracts.TokensNetworkABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := parsed.Pack("prepareSettle", token, partner, bp.TransferAmount, bp.LocksRoot, bp.Nonce, bp.MessageHash, bp.Signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualValues(t, data, api.Data)
+}
+
+//withdraw 和 cooperativeSettle 的参数和发送请求时一样构造,我的签名有效,对方的签名为空
+func TestEstimateWithdrawAndCooperativeSettleGas(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	our, partner, token := crypto.PubkeyToAddress(key.PublicKey), utils.NewRandomAddress(), utils.NewRandomAddress()
+	ch, err := channel.NewChannel(channel.NewChannelEndState(our, big.NewInt(100), nil, mtree.EmptyTree),
+		channel.NewChannelEndState(partner, big.NewInt(50), nil, mtree.EmptyTree), &channel.ExternalState{}, token,
+		&contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash(), OpenBlockNumber: 3}, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &FakeEstimateGasAPI{}
+	server := gethrpc.NewServer()
+	assert.Nil(t, server.RegisterName("eth", api))
+	defer server.Stop()
+	rs := &Service{
+		NodeAddress: our,
+		Signer:      utils.NewPrivateKeySigner(key),
+		Chain: &rpc.BlockChainService{
+			NodeAddress:   our,
+			Client:        &helper.SafeEthClient{Client: ethclient.NewClient(gethrpc.DialInProc(server))},
+			RegistryProxy: &rpc.RegistryProxy{Address: utils.NewRandomAddress()},
+		},
+		dao: codefortest.NewTestDB(""),
+	}
+	defer rs.dao.CloseDB()
+	assert.Nil(t, rs.dao.NewChannel(channel.NewChannelSerialization(ch)))
+	channelIdentifier := ch.ChannelIdentifier.ChannelIdentifier
+	parsed, err := abi.JSON(strings.NewReader(contracts.TokensNetworkABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = rs.EstimateChannelOperationGas(GasOpWithdraw, channelIdentifier, big.NewInt(101))
+	assert.Equal(t, rerr.ErrChannelWithdrawAmount.ErrorCode, err.(rerr.StandardError).ErrorCode)
+	gas, err := rs.EstimateChannelOperationGas(GasOpWithdraw, channelIdentifier, big.NewInt(10))
+	assert.Nil(t, err)
+	assert.EqualValues(t, big.NewInt(50000), gas)
+	w, err := ch.CreateWithdrawRequest(big.NewInt(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, w.Sign(key, w))
+	data, err := parsed.Pack("withDraw", token, our, partner, big.NewInt(100), big.NewInt(10), w.Participant1Signature, make([]byte, 65))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualValues(t, data, api.Data)
+
+	gas, err = rs.EstimateChannelOperationGas(GasOpCooperativeSettle, channelIdentifier, nil)
+	assert.Nil(t, err)
+	assert.EqualValues(t, big.NewInt(50000), gas)
+	s, err := ch.CreateCooperativeSettleRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, s.Sign(key, s))
+	data, err = parsed.Pack("cooperativeSettle", token, our, big.NewInt(100), partner, big.NewInt(50), s.Participant1Signature, make([]byte, 65))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualValues(t, data, api.Data)
+}
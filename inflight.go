@@ -0,0 +1,77 @@
+package photon
+
+import (
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer/initiator"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer/mediator"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//LoopStats 主线程中的一些统计
+type LoopStats struct {
+	InFlightTransfers         int `json:"in_flight_transfers"`          //我发起的尚未结束的交易
+	InFlightMediatedTransfers int `json:"in_flight_mediated_transfers"` //我中转的尚未结束的交易
+	//DuplicateTransfers 每个节点发送的重复交易数量,只有配置了 ReportDuplicateTransfer 才统计
+	DuplicateTransfers map[common.Address]int `json:"duplicate_transfers,omitempty"`
+	Paused             bool                   `json:"paused"` //是否暂停了新交易,见 Pause
+}
+
+/*
+countInFlight 统计某种类型的 StateManager,已经到达终态(CurrentState 为 nil)的不算.
+只能在主线程中调用
+*/
+func (rs *Service) countInFlight(name string) (n int) {
+	for _, mgr := range rs.Transfer2StateManager {
+		if mgr.Name == name && mgr.CurrentState != nil {
+			n++
+		}
+	}
+	return
+}
+
+/*
+checkInFlightTransfers 我发起的交易数量达到 Config.MaxConcurrentTransfers 以后拒绝新的交易
+*/
+func (rs *Service) checkInFlightTransfers() error {
+	max := rs.Config.MaxConcurrentTransfers
+	if max <= 0 {
+		return nil
+	}
+	if n := rs.countInFlight(initiator.NameInitiatorTransition); n >= max {
+		return rerr.ErrTooManyInFlight.Printf("%d transfers in flight,limit %d", n, max)
+	}
+	return nil
+}
+
+/*
+checkInFlightMediatedTransfers 我中转的交易数量达到 Config.MaxConcurrentMediatedTransfers 以后不再中转
+*/
+func (rs *Service) checkInFlightMediatedTransfers() error {
+	max := rs.Config.MaxConcurrentMediatedTransfers
+	if max <= 0 {
+		return nil
+	}
+	if n := rs.countInFlight(mediator.NameMediatorTransition); n >= max {
+		return rerr.ErrTooManyInFlight.Printf("%d mediated transfers in flight,limit %d", n, max)
+	}
+	return nil
+}
+
+func (rs *Service) getLoopStats() (result *utils.AsyncResult) {
+	stats := &LoopStats{
+		InFlightTransfers:         rs.countInFlight(initiator.NameInitiatorTransition),
+		InFlightMediatedTransfers: rs.countInFlight(mediator.NameMediatorTransition),
+		Paused:                    rs.paused,
+	}
+	if rs.duplicateTransfers != nil && len(rs.duplicateTransfers.counts) > 0 {
+		stats.DuplicateTransfers = make(map[common.Address]int)
+		for sender, n := range rs.duplicateTransfers.counts {
+			stats.DuplicateTransfers[sender] = n
+		}
+	}
+	result = utils.NewAsyncResult()
+	result.Tag = stats
+	result.Result <- nil
+	return
+}
@@ -0,0 +1,58 @@
+package photon
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/transfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer/initiator"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer/mediator"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func isTooManyInFlight(err error) bool {
+	e, ok := err.(rerr.StandardError)
+	return ok && e.ErrorCode == rerr.ErrTooManyInFlight.ErrorCode
+}
+
+func TestInFlightTransfers(t *testing.T) {
+	rs := &Service{
+		Config:                &params.Config{},
+		Transfer2StateManager: make(map[common.Hash]*transfer.StateManager),
+	}
+	add := func(name string, state transfer.State) {
+		rs.Transfer2StateManager[utils.NewRandomHash()] = transfer.NewStateManager(nil, state, name, utils.NewRandomHash(), utils.EmptyAddress)
+	}
+	add(initiator.NameInitiatorTransition, &mediatedtransfer.InitiatorState{})
+	add(initiator.NameInitiatorTransition, nil) //已经结束的不算
+	add(mediator.NameMediatorTransition, &mediatedtransfer.MediatorState{})
+	add(mediator.NameMediatorTransition, &mediatedtransfer.MediatorState{})
+
+	//默认不限制
+	assert.Nil(t, rs.checkInFlightTransfers())
+	assert.Nil(t, rs.checkInFlightMediatedTransfers())
+
+	rs.Config.MaxConcurrentTransfers = 2
+	rs.Config.MaxConcurrentMediatedTransfers = 2
+	assert.Nil(t, rs.checkInFlightTransfers())
+	assert.True(t, isTooManyInFlight(rs.checkInFlightMediatedTransfers()))
+	rs.Config.MaxConcurrentTransfers = 1
+	assert.True(t, isTooManyInFlight(rs.checkInFlightTransfers()))
+
+	req := &apiReq{
+		Name:   transferReqName,
+		Req:    &transferReq{Target: utils.NewRandomAddress(), Amount: big.NewInt(1)},
+		result: make(chan *utils.AsyncResult, 1),
+	}
+	rs.handleReq(req)
+	assert.True(t, isTooManyInFlight(<-(<-req.result).Result))
+
+	result := rs.getLoopStats()
+	assert.Nil(t, <-result.Result)
+	assert.Equal(t, &LoopStats{InFlightTransfers: 1, InFlightMediatedTransfers: 2}, result.Tag)
+}
@@ -2,6 +2,7 @@ package rpanic
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/SmartMeshFoundation/Photon/utils"
 
@@ -29,6 +30,12 @@ errChan should never be closed, 否则有可能会引起崩溃
 var errChan chan error
 var notifier []string
 
+//PanicHandler 在 PanicRecover 捕获到 panic 时调用,比如把崩溃信息上报到监控系统
+type PanicHandler func(context string, recovered interface{}, stack []byte)
+
+var panicHandler PanicHandler
+var panicHandlerLock sync.RWMutex
+
 //永不关闭.
 var notifyChan chan error
 
@@ -54,8 +61,10 @@ PanicRecover 用于所有 go routine 错误通知,但是不一定都会被处理
 func PanicRecover(ctx string) {
 	if err := recover(); err != nil {
 		err2 := fmt.Errorf("%s occured err %s", ctx, err)
+		stack := utils.Stack()
 		log.Error(err2.Error())
-		log.Error(string(utils.Stack()))
+		log.Error(string(stack))
+		callPanicHandler(ctx, err, stack)
 		if params.MobileMode {
 			errChan <- err2
 		} else {
@@ -67,6 +76,32 @@ func PanicRecover(ctx string) {
 	}
 }
 
+/*
+SetPanicHandler 设置 PanicRecover 捕获到 panic 以后的处理函数,对整个进程有效,nil 表示只记录日志.
+handler 在发生 panic 的 goroutine 中同步调用,不应该阻塞太久
+*/
+func SetPanicHandler(handler PanicHandler) {
+	panicHandlerLock.Lock()
+	defer panicHandlerLock.Unlock()
+	panicHandler = handler
+}
+
+//callPanicHandler handler 自己 panic 也不能影响原有的处理
+func callPanicHandler(ctx string, recovered interface{}, stack []byte) {
+	panicHandlerLock.RLock()
+	handler := panicHandler
+	panicHandlerLock.RUnlock()
+	if handler == nil {
+		return
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			log.Error(fmt.Sprintf("panic handler of %s occured err %s", ctx, err))
+		}
+	}()
+	handler(ctx, recovered, stack)
+}
+
 //RegisterErrorNotifier who wants to know error
 func RegisterErrorNotifier(name string) {
 	log.Trace(fmt.Sprintf("RegisterErrorNotifier %s ", name))
@@ -18,3 +18,38 @@ func TestPanic(t *testing.T) {
 	defer handlePanic()
 	panic2()
 }
+
+func TestPanicHandler(t *testing.T) {
+	var gotCtx string
+	var gotRecovered interface{}
+	var gotStack []byte
+	SetPanicHandler(func(ctx string, recovered interface{}, stack []byte) {
+		gotCtx, gotRecovered, gotStack = ctx, recovered, stack
+	})
+	defer SetPanicHandler(nil)
+	func() {
+		//非手机模式下 PanicRecover 会继续 panic
+		defer handlePanic()
+		defer PanicRecover("test")
+		panic2()
+	}()
+	if gotCtx != "test" || gotRecovered != "33" || len(gotStack) == 0 {
+		t.Errorf("handler not called,ctx=%s,recovered=%v", gotCtx, gotRecovered)
+	}
+
+	//handler 自己 panic 不影响原来的处理
+	SetPanicHandler(func(ctx string, recovered interface{}, stack []byte) {
+		panic("handler")
+	})
+	var reraised interface{}
+	func() {
+		defer func() {
+			reraised = recover()
+		}()
+		defer PanicRecover("test")
+		panic2()
+	}()
+	if reraised == nil {
+		t.Error("PanicRecover should panic again")
+	}
+}
@@ -0,0 +1,89 @@
+package photon
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+CreateInvoice 创建一个收款账单,付款方在交易的 Data 中填写 invoiceID.Hex(),可以分多次付款.
+expiry 是到期的块号,0表示永不过期.
+多付的金额同样计入账单;到期之前没有付清的账单不再接受付款,之后的交易只是普通的收款,
+已经收到的部分需要商家自己处理.
+*/
+func (rs *Service) CreateInvoice(tokenAddress common.Address, amount *big.Int, expiry int64) (invoiceID common.Hash, err error) {
+	if amount == nil || amount.Cmp(utils.BigInt0) <= 0 {
+		err = rerr.ErrInvalidAmount.Printf("invoice amount must be positive,got %s", amount)
+		return
+	}
+	if expiry != 0 && expiry <= rs.GetBlockNumber() {
+		err = rerr.ErrArgumentError.Printf("invoice expiry %d is not after current block %d", expiry, rs.GetBlockNumber())
+		return
+	}
+	invoiceID = utils.NewRandomHash()
+	err = rs.dao.SaveInvoice(&models.Invoice{
+		InvoiceID:    invoiceID,
+		TokenAddress: tokenAddress,
+		Amount:       new(big.Int).Set(amount),
+		Received:     big.NewInt(0),
+		Expiry:       expiry,
+	})
+	return
+}
+
+//GetInvoiceStatus 返回账单以及当前的状态
+func (rs *Service) GetInvoiceStatus(invoiceID common.Hash) (inv *models.Invoice, err error) {
+	inv, err = rs.dao.GetInvoice(invoiceID)
+	if err != nil {
+		return
+	}
+	inv.Status = inv.StatusAt(rs.GetBlockNumber())
+	return
+}
+
+/*
+payInvoice 收到一笔交易,如果 data 是一个有效账单的 id,计入这个账单.
+只能在主线程中调用
+*/
+func (rs *Service) payInvoice(tokenAddress common.Address, data string, amount *big.Int) {
+	if len(data) != 2+2*common.HashLength || !strings.HasPrefix(data, "0x") {
+		return
+	}
+	inv, err := rs.dao.GetInvoice(common.HexToHash(data))
+	if err != nil {
+		return
+	}
+	blockNumber := rs.GetBlockNumber()
+	if inv.TokenAddress != tokenAddress {
+		log.Warn(fmt.Sprintf("receive payment for invoice %s with token %s,expect %s,ignore",
+			utils.HPex(inv.InvoiceID), utils.APex2(tokenAddress), utils.APex2(inv.TokenAddress)))
+		return
+	}
+	if inv.StatusAt(blockNumber) == models.InvoiceStatusExpired {
+		log.Warn(fmt.Sprintf("receive payment %s for expired invoice %s,ignore", amount, utils.HPex(inv.InvoiceID)))
+		return
+	}
+	inv.Received = new(big.Int).Add(inv.Received, amount)
+	inv.Payments++
+	paid := inv.PaidBlock == 0 && inv.Received.Cmp(inv.Amount) >= 0
+	if paid {
+		inv.PaidBlock = blockNumber
+	}
+	inv.Status = inv.StatusAt(blockNumber)
+	err = rs.dao.SaveInvoice(inv)
+	if err != nil {
+		log.Error(fmt.Sprintf("SaveInvoice %s err %s", utils.HPex(inv.InvoiceID), err))
+		return
+	}
+	if paid {
+		log.Info(fmt.Sprintf("invoice %s paid,received %s in %d payments", utils.HPex(inv.InvoiceID), inv.Received, inv.Payments))
+		rs.NotifyHandler.NotifyInvoicePaid(inv)
+	}
+}
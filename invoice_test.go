@@ -0,0 +1,83 @@
+package photon
+
+import (
+	"math/big"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/notify"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInvoice(t *testing.T) {
+	rs := &Service{
+		dao:           codefortest.NewTestDB(""),
+		NotifyHandler: notify.NewNotifyHandler(),
+		BlockNumber:   new(atomic.Value),
+	}
+	defer rs.dao.CloseDB()
+	rs.BlockNumber.Store(int64(10))
+	token := utils.NewRandomAddress()
+
+	_, err := rs.CreateInvoice(token, big.NewInt(0), 0)
+	assert.NotNil(t, err)
+	_, err = rs.CreateInvoice(token, big.NewInt(10), 5)
+	assert.NotNil(t, err)
+
+	id, err := rs.CreateInvoice(token, big.NewInt(100), 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := func() *models.Invoice {
+		inv, err := rs.GetInvoiceStatus(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return inv
+	}
+	assert.Equal(t, models.InvoiceStatusUnpaid, status().Status)
+	//不是账单或者 token 不对的交易不计入
+	rs.payInvoice(token, "hello", big.NewInt(60))
+	rs.payInvoice(utils.NewRandomAddress(), id.Hex(), big.NewInt(60))
+	assert.EqualValues(t, 0, status().Payments)
+
+	rs.payInvoice(token, id.Hex(), big.NewInt(60))
+	inv := status()
+	assert.Equal(t, models.InvoiceStatusPartiallyPaid, inv.Status)
+	assert.EqualValues(t, 60, inv.Received.Int64())
+	assert.Empty(t, rs.NotifyHandler.GetNoticeChan())
+
+	//多付的部分也计入,付清时通知一次
+	rs.payInvoice(token, id.Hex(), big.NewInt(60))
+	rs.payInvoice(token, id.Hex(), big.NewInt(1))
+	inv = status()
+	assert.Equal(t, models.InvoiceStatusPaid, inv.Status)
+	assert.EqualValues(t, 121, inv.Received.Int64())
+	assert.EqualValues(t, 3, inv.Payments)
+	assert.Len(t, rs.NotifyHandler.GetNoticeChan(), 1)
+	n := <-rs.NotifyHandler.GetNoticeChan()
+	assert.True(t, strings.Contains(n.Info, id.Hex()))
+	//付清以后不会过期
+	rs.BlockNumber.Store(int64(30))
+	assert.Equal(t, models.InvoiceStatusPaid, status().Status)
+
+	//过期以后的付款不计入
+	expired, err := rs.CreateInvoice(token, big.NewInt(100), 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.payInvoice(token, expired.Hex(), big.NewInt(10))
+	rs.BlockNumber.Store(int64(41))
+	rs.payInvoice(token, expired.Hex(), big.NewInt(90))
+	inv, err = rs.GetInvoiceStatus(expired)
+	assert.Nil(t, err)
+	assert.Equal(t, models.InvoiceStatusExpired, inv.Status)
+	assert.EqualValues(t, 10, inv.Received.Int64())
+
+	_, err = rs.GetInvoiceStatus(utils.NewRandomHash())
+	assert.NotNil(t, err)
+}
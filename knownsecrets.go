@@ -0,0 +1,155 @@
+package photon
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//SecretLockInfo 通道中引用这个密码的锁
+type SecretLockInfo struct {
+	ChannelIdentifier common.Hash    `json:"channel_identifier"`
+	TokenAddress      common.Address `json:"token_address"`
+	PartnerAddress    common.Address `json:"partner_address"`
+	Sent              bool           `json:"sent"`     //true 是我发出的锁,false 是对方发给我的锁
+	Revealed          bool           `json:"revealed"` //锁在 Lock2UnclaimedLocks 中,通道已经知道密码,只差对方的 unlock
+	Amount            *big.Int       `json:"amount"`
+	Expiration        int64          `json:"expiration"`
+}
+
+/*
+SecretInfo 我知道的一个密码,用于崩溃恢复之后确认是否还有别人欠我的钱.
+Secret 只有在 includeSecret 时才会给出,否则为空
+*/
+type SecretInfo struct {
+	LockSecretHash    common.Hash       `json:"lock_secret_hash"`
+	Secret            common.Hash       `json:"secret"`
+	Revealed          bool              `json:"revealed"`            //至少有一个通道中的锁已经披露了密码
+	RegisteredOnChain bool              `json:"registered_on_chain"` //密码已经在 SecretRegistry 中注册
+	Locks             []*SecretLockInfo `json:"locks"`
+}
+
+/*
+GetKnownSecrets 列出我知道的所有密码,以及引用它们的通道和锁的状态.
+密码来自通道中已经披露的锁和还在进行中的交易,没有任何通道引用的密码不会列出.
+本地不知道是否已经在链上注册的密码会查询 SecretRegistry,
+查询失败不影响结果,只记录日志.
+不能在主线程中调用.
+*/
+func (rs *Service) GetKnownSecrets(includeSecret bool) ([]SecretInfo, error) {
+	result := rs.getKnownSecretsClient()
+	err := <-result.Result
+	if err != nil {
+		return nil, err
+	}
+	infos := result.Tag.([]SecretInfo)
+	for i := range infos {
+		info := &infos[i]
+		if !info.RegisteredOnChain {
+			registered, err := rs.Chain.SecretRegistryProxy.IsSecretRegistered(info.Secret)
+			if err != nil {
+				log.Warn(fmt.Sprintf("query secret %s registered err %s", utils.HPex(info.LockSecretHash), err))
+			} else {
+				info.RegisteredOnChain = registered
+			}
+		}
+		if !includeSecret {
+			info.Secret = utils.EmptyHash
+		}
+	}
+	return infos, nil
+}
+
+/*
+getKnownSecrets 只能在主线程中调用
+*/
+func (rs *Service) getKnownSecrets() (result *utils.AsyncResult) {
+	infos := make(map[common.Hash]*SecretInfo)
+	for _, sm := range rs.Transfer2StateManager {
+		lockSecretHash, secret := stateManagerSecret(sm.CurrentState)
+		if secret != utils.EmptyHash {
+			infos[lockSecretHash] = &SecretInfo{LockSecretHash: lockSecretHash, Secret: secret}
+		}
+	}
+	var channels []*channel.Channel
+	for _, g := range rs.Token2ChannelGraph {
+		for _, c := range g.ChannelIdentifier2Channel {
+			channels = append(channels, c)
+		}
+	}
+	//通道中已经披露的锁
+	for _, c := range channels {
+		for _, es := range []*channel.EndState{c.OurState, c.PartnerState} {
+			for lockSecretHash, proof := range es.Lock2UnclaimedLocks {
+				info := infos[lockSecretHash]
+				if info == nil {
+					info = &SecretInfo{LockSecretHash: lockSecretHash, Secret: proof.Secret}
+					infos[lockSecretHash] = info
+				}
+				info.Revealed = true
+				info.RegisteredOnChain = info.RegisteredOnChain || proof.IsRegisteredOnChain
+				info.Locks = append(info.Locks, newSecretLockInfo(c, es, proof.Lock, true))
+			}
+		}
+	}
+	//我知道密码,但是通道中还没有披露的锁,比如我发起的交易
+	for _, c := range channels {
+		for _, es := range []*channel.EndState{c.OurState, c.PartnerState} {
+			for lockSecretHash, pending := range es.Lock2PendingLocks {
+				if info := infos[lockSecretHash]; info != nil {
+					info.Locks = append(info.Locks, newSecretLockInfo(c, es, pending.Lock, false))
+				}
+			}
+		}
+	}
+	var list []SecretInfo
+	for _, info := range infos {
+		//锁都已经 unlock 或者移除了,这个密码没有意义了
+		if len(info.Locks) == 0 {
+			continue
+		}
+		sort.Slice(info.Locks, func(i, j int) bool {
+			return info.Locks[i].ChannelIdentifier.Hex() < info.Locks[j].ChannelIdentifier.Hex()
+		})
+		list = append(list, *info)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].LockSecretHash.Hex() < list[j].LockSecretHash.Hex()
+	})
+	result = utils.NewAsyncResult()
+	result.Tag = list
+	result.Result <- nil
+	return
+}
+
+func newSecretLockInfo(c *channel.Channel, es *channel.EndState, lock *mtree.Lock, revealed bool) *SecretLockInfo {
+	return &SecretLockInfo{
+		ChannelIdentifier: c.ChannelIdentifier.ChannelIdentifier,
+		TokenAddress:      c.TokenAddress,
+		PartnerAddress:    c.PartnerState.Address,
+		Sent:              es == c.OurState,
+		Revealed:          revealed,
+		Amount:            new(big.Int).Set(lock.Amount),
+		Expiration:        lock.Expiration,
+	}
+}
+
+//stateManagerSecret 交易已经知道的密码
+func stateManagerSecret(state interface{}) (lockSecretHash, secret common.Hash) {
+	switch s := state.(type) {
+	case *mediatedtransfer.InitiatorState:
+		return s.LockSecretHash, s.Secret
+	case *mediatedtransfer.MediatorState:
+		return s.LockSecretHash, s.Secret
+	case *mediatedtransfer.TargetState:
+		return s.FromTransfer.LockSecretHash, s.Secret
+	}
+	return
+}
@@ -0,0 +1,88 @@
+package photon
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
+	"github.com/SmartMeshFoundation/Photon/network/graph"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/transfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer/initiator"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetKnownSecrets(t *testing.T) {
+	our, partner, token := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	ourState := channel.NewChannelEndState(our, big.NewInt(100), nil, mtree.EmptyTree)
+	partnerState := channel.NewChannelEndState(partner, big.NewInt(100), nil, mtree.EmptyTree)
+	c, err := channel.NewChannel(ourState, partnerState, &channel.ExternalState{}, token,
+		&contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash()}, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewChannelGraph(our, token, nil)
+	g.ChannelIdentifier2Channel[c.ChannelIdentifier.ChannelIdentifier] = c
+	rs := &Service{
+		NodeAddress:           our,
+		Config:                &params.Config{},
+		Token2ChannelGraph:    map[common.Address]*graph.ChannelGraph{token: g},
+		Transfer2StateManager: make(map[common.Hash]*transfer.StateManager),
+	}
+	//对方发给我的锁,密码已经披露
+	receivedSecret := utils.NewRandomHash()
+	receivedHash := utils.ShaSecret(receivedSecret[:])
+	partnerState.Lock2UnclaimedLocks[receivedHash] = channeltype.UnlockPartialProof{
+		Lock:                &mtree.Lock{Amount: big.NewInt(3), Expiration: 30, LockSecretHash: receivedHash},
+		Secret:              receivedSecret,
+		IsRegisteredOnChain: true,
+	}
+	//我发起的交易,密码还没有披露
+	sentSecret := utils.NewRandomHash()
+	sentHash := utils.ShaSecret(sentSecret[:])
+	ourState.Lock2PendingLocks[sentHash] = channeltype.PendingLock{
+		Lock: &mtree.Lock{Amount: big.NewInt(5), Expiration: 40, LockSecretHash: sentHash},
+	}
+	rs.Transfer2StateManager[utils.NewRandomHash()] = transfer.NewStateManager(nil,
+		&mediatedtransfer.InitiatorState{LockSecretHash: sentHash, Secret: sentSecret}, initiator.NameInitiatorTransition, utils.NewRandomHash(), token)
+	//不知道密码的锁不会列出
+	unknownHash := utils.NewRandomHash()
+	partnerState.Lock2PendingLocks[unknownHash] = channeltype.PendingLock{
+		Lock: &mtree.Lock{Amount: big.NewInt(7), LockSecretHash: unknownHash},
+	}
+
+	result := rs.getKnownSecrets()
+	assert.Nil(t, <-result.Result)
+	infos := result.Tag.([]SecretInfo)
+	if !assert.Len(t, infos, 2) {
+		return
+	}
+	byHash := make(map[common.Hash]SecretInfo)
+	for _, info := range infos {
+		byHash[info.LockSecretHash] = info
+	}
+	received := byHash[receivedHash]
+	assert.Equal(t, receivedSecret, received.Secret)
+	assert.True(t, received.Revealed)
+	assert.True(t, received.RegisteredOnChain)
+	if assert.Len(t, received.Locks, 1) {
+		assert.False(t, received.Locks[0].Sent)
+		assert.Equal(t, partner, received.Locks[0].PartnerAddress)
+		assert.EqualValues(t, 3, received.Locks[0].Amount.Int64())
+	}
+	sent := byHash[sentHash]
+	assert.Equal(t, sentSecret, sent.Secret)
+	assert.False(t, sent.Revealed)
+	assert.False(t, sent.RegisteredOnChain)
+	if assert.Len(t, sent.Locks, 1) {
+		assert.True(t, sent.Locks[0].Sent)
+		assert.False(t, sent.Locks[0].Revealed)
+		assert.Equal(t, c.ChannelIdentifier.ChannelIdentifier, sent.Locks[0].ChannelIdentifier)
+	}
+}
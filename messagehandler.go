@@ -3,6 +3,7 @@ package photon
 import (
 	"encoding/hex"
 	"fmt"
+	"time"
 
 	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer/target"
 
@@ -37,12 +38,17 @@ import (
 type photonMessageHandler struct {
 	photon        *Service
 	blockedTokens map[common.Address]bool
+	rateLimiter   *messageRateLimiter
 }
 
 func newPhotonMessageHandler(photon *Service) *photonMessageHandler {
 	h := &photonMessageHandler{
 		photon:        photon,
 		blockedTokens: make(map[common.Address]bool),
+		rateLimiter:   newMessageRateLimiter(photon.Config),
+	}
+	h.rateLimiter.timeFunc = func() time.Time {
+		return photon.Clock.Now()
 	}
 	return h
 }
@@ -51,6 +57,11 @@ func newPhotonMessageHandler(photon *Service) *photonMessageHandler {
  Handles `message` and sends an ACK on success.
 */
 func (mh *photonMessageHandler) onMessage(msg encoding.SignedMessager, hash common.Hash) (err error) {
+	//超过限速的消息不回复ack,对方会稍后重发
+	err = mh.rateLimiter.allow(msg.GetSender(), msg.Cmd())
+	if err != nil {
+		return
+	}
 	msg.SetTag(&transfer.MessageTag{
 		EchoHash: hash,
 	})
@@ -105,8 +116,8 @@ func (mh *photonMessageHandler) onMessage(msg encoding.SignedMessager, hash comm
 				errorCode = rerr.ErrUnknown.ErrorCode
 				errorMsg = err.Error()
 			}
-			msg := encoding.NewErrorCooperativeSettleResponseAndSign(m2, mh.photon.PrivateKey, errorCode, errorMsg)
-			err2 := mh.photon.sendAsync(m2.Sender, msg)
+			msg := encoding.NewErrorCooperativeSettleResponseAndSign(m2, mh.photon.Signer, errorCode, errorMsg)
+			err2 := mh.photon.sendAsyncWithPolicy(m2.Sender, msg, mh.photon.handshakeSendPolicy(true))
 			if err2 != nil {
 				log.Error(fmt.Sprintf("send message %s, to %s ,err %s", msg, msg.Sender, err2))
 			}
@@ -125,8 +136,8 @@ func (mh *photonMessageHandler) onMessage(msg encoding.SignedMessager, hash comm
 				errorCode = rerr.ErrUnknown.ErrorCode
 				errorMsg = err.Error()
 			}
-			msg := encoding.NewErrorWithdrawResponseAndSign(m2, mh.photon.PrivateKey, errorCode, errorMsg)
-			err2 := mh.photon.sendAsync(m2.Sender, msg)
+			msg := encoding.NewErrorWithdrawResponseAndSign(m2, mh.photon.Signer, errorCode, errorMsg)
+			err2 := mh.photon.sendAsyncWithPolicy(m2.Sender, msg, mh.photon.handshakeSendPolicy(true))
 			if err2 != nil {
 				log.Error(fmt.Sprintf("send message %s, to %s ,err %s", msg, msg.Sender, err2))
 			}
@@ -135,10 +146,17 @@ func (mh *photonMessageHandler) onMessage(msg encoding.SignedMessager, hash comm
 		err = mh.messageWithdrawResponse(m2)
 	case *encoding.ErrorNotify:
 		err = mh.messageErrorNotify(m2)
+	case *encoding.FeeQuoteRequest:
+		err = mh.messageFeeQuoteRequest(m2)
+	case *encoding.FeeQuoteResponse:
+		err = mh.photon.feeQuotes.onResponse(m2)
 	default:
 		log.Error(fmt.Sprintf("photonMessageHandler unknown msg:%s", utils.StringInterface1(msg)))
 		return fmt.Errorf("unhandled message cmdid:%d", msg.Cmd())
 	}
+	if err == nil {
+		mh.photon.saveTransferMessage(msg, false)
+	}
 	return err
 }
 
@@ -350,18 +368,20 @@ A-B-C交易,B给A发送AnnounceDisposed,这时候发生了以外,A崩溃,很长
  *	2. As to MediatedStateManager, there is no atomic operation between EventSendMediatedTransfer and EventSendAnnounceDisposedResponse.
  */
 func (mh *photonMessageHandler) messageAnnounceDisposed(msg *encoding.AnnounceDisposed) (err error) {
+	/*
+		开始接收消息之前所有的通道都已经加载,通道未知说明已经 settle 或者根本不存在,
+		这个锁已经没有意义,只能忽略,但是要回复 ack,否则对方会一直重发.
+	*/
 	graph := mh.photon.getChannelGraph(msg.ChannelIdentifier)
 	if graph == nil {
-		log.Error(fmt.Sprintf("unkonwn channel %s", msg.ChannelIdentifier.String()))
-		return nil
-	}
-	if !graph.HasChannel(mh.photon.NodeAddress, msg.Sender) {
-		log.Error(fmt.Sprintf("direct transfer from node without an existing channel: %s", msg.Sender))
+		log.Error(fmt.Sprintf("receive AnnounceDisposed on unkonwn channel %s, ignore it", msg.ChannelIdentifier.String()))
 		return nil
 	}
 	ch := graph.GetPartenerAddress2Channel(msg.Sender)
-	if ch == nil {
-		return rerr.ChannelNotFound(fmt.Sprintf("channel:%s,partner:%s", utils.HPex(msg.ChannelIdentifier), utils.APex2(msg.Sender)))
+	if ch == nil || ch.ChannelIdentifier.ChannelIdentifier != msg.ChannelIdentifier {
+		log.Error(fmt.Sprintf("receive AnnounceDisposed from node without an existing channel,channel:%s,partner:%s, ignore it",
+			utils.HPex(msg.ChannelIdentifier), utils.APex2(msg.Sender)))
+		return nil
 	}
 	err = ch.RegisterAnnouceDisposed(msg)
 	if err != nil {
@@ -467,6 +487,10 @@ func (mh *photonMessageHandler) messageDirectTransfer(msg *encoding.DirectTransf
 	if mh.photon.StopCreateNewTransfers {
 		return rerr.ErrStopCreateNewTransfer
 	}
+	// 暂停期间不回复 ack,对方稍后会重发
+	if mh.photon.paused {
+		return rerr.ErrPaused
+	}
 	//mh.balanceProof(msg)
 	graph := mh.photon.getChannelGraph(msg.ChannelIdentifier)
 	token := mh.photon.getTokenForChannelIdentifier(msg.ChannelIdentifier)
@@ -508,6 +532,19 @@ func (mh *photonMessageHandler) messageDirectTransfer(msg *encoding.DirectTransf
 //交易过程中不应该出现密码为0的情况,除非有人恶意攻击.目前忽略这种交易,可以改进为直接发送reveal secret,收下来.
 var emptySecretHash = utils.ShaSecret(utils.EmptyHash[:])
 
+/*
+checkTransferChannel 消息中没有 token,token 是根据消息中的通道 ch 推导出来的,这个通道必须是我和发送方的通道,
+否则我和别人的通道可以让我用错误的 token 计算 smkey(Sha3(lockSecretHash, tokenAddress)),
+并且在 RegisterTransfer 检查之前就按错误的通道放弃交易,按恶意消息处理
+*/
+func checkTransferChannel(msg *encoding.MediatedTransfer, ch *channel.Channel) error {
+	if ch.PartnerState.Address != msg.Sender {
+		return rerr.ErrChannelIdentifierMismatch.Printf("channel %s is with %s,not with sender %s",
+			ch.ChannelIdentifier.String(), utils.APex2(ch.PartnerState.Address), utils.APex2(msg.Sender))
+	}
+	return nil
+}
+
 /*
 收到 MediatedTransfer, 如果验证不通过,说明节点之间状态不同步,通道只能关闭
 验证通过:
@@ -538,6 +575,10 @@ func (mh *photonMessageHandler) messageMediatedTransfer(msg *encoding.MediatedTr
 	if mh.photon.StopCreateNewTransfers {
 		return rerr.ErrStopCreateNewTransfer
 	}
+	// 暂停期间不回复 ack,对方稍后会重发
+	if mh.photon.paused {
+		return rerr.ErrPaused
+	}
 	if msg.LockSecretHash == emptySecretHash {
 		/*
 			接收到制定了密码为空的交易,直接忽略
@@ -545,7 +586,6 @@ func (mh *photonMessageHandler) messageMediatedTransfer(msg *encoding.MediatedTr
 		*/
 		return fmt.Errorf("receive mediated transfer,it's secret is zero")
 	}
-	token := mh.photon.getTokenForChannelIdentifier(msg.ChannelIdentifier)
 	if mh.photon.Config.IgnoreMediatedNodeRequest && msg.Target != mh.photon.NodeAddress {
 		//todo what about return a AnnounceDisposed Message ?
 		/*
@@ -558,21 +598,32 @@ func (mh *photonMessageHandler) messageMediatedTransfer(msg *encoding.MediatedTr
 	if !mh.photon.IsChainEffective {
 		return rerr.ErrNotAllowMediatedTransfer
 	}
+	// 消息中没有 token,只能根据通道推导,通道未知的话 token 也无从得知
+	ch, err := mh.photon.findChannelByIdentifier(msg.ChannelIdentifier)
+	if err != nil {
+		return rerr.ChannelNotFound(fmt.Sprintf("received transfer on unknown channel %s", utils.HPex(msg.ChannelIdentifier)))
+	}
+	err = checkTransferChannel(msg, ch)
+	if err != nil {
+		log.Error(fmt.Sprintf("receive malformed mediated transfer %s", err))
+		return err
+	}
+	token := ch.TokenAddress
 	if _, ok := mh.blockedTokens[token]; ok {
 		return rerr.ErrTransferUnwanted
 	}
-	graph := mh.photon.getToken2ChannelGraph(token)
-	if graph == nil {
-		return fmt.Errorf("received transfer on unkown token :%s", utils.APex2(token))
-	}
-	ch := graph.GetPartenerAddress2Channel(msg.Sender)
-	if ch == nil {
-		return rerr.ChannelNotFound(fmt.Sprintf("token:%s,partner:%s", utils.APex2(token), utils.APex2(msg.Sender)))
+	// 正在合作关闭或者取现的通道,不能再接收任何交易,接收下来以后立即放弃
+	if channeltype.CannotReceiveAnyTransferAndAnnounceDisposedImmediately[ch.State] {
+		return mh.photon.disposeTransferOnUnavailableChannel(msg, ch)
 	}
 	if !ch.CanTransfer() {
 		return rerr.TransferWhenClosed(fmt.Sprintf("Mediated transfer received but the channel is  can not accept any transfer %s", ch.ChannelIdentifier.String()))
 	}
-	err := ch.RegisterTransfer(mh.photon.GetBlockNumber(), msg)
+	// 对方在通道中的锁太多,收下以后立即放弃,对方会换一条路由
+	if err = ch.CheckPendingLocksLimit(ch.PartnerState); err != nil {
+		return mh.photon.disposeReceivedTransfer(msg, ch, err.(rerr.StandardError))
+	}
+	err = ch.RegisterTransfer(mh.photon.GetBlockNumber(), msg)
 	if err != nil {
 		mh.processRegisterTransferError(err, msg)
 		return err
@@ -625,6 +676,9 @@ func (mh *photonMessageHandler) messageMediatedTransfer(msg *encoding.MediatedTr
 		remove := mh.photon.messageTokenSwapTaker(msg, tokenswap)
 		if remove { //once the swap start,remove mh key immediately. otherwise,maker may repeat mh tokenswap operation.
 			delete(mh.photon.SwapKey2TokenSwap, key)
+			if p := mh.photon.pendingTokenSwaps[key]; p != nil {
+				p.takerActed = true
+			}
 		}
 		//return nil
 	}
@@ -684,11 +738,12 @@ func (mh *photonMessageHandler) messageSettleRequest(msg *encoding.SettleRequest
 	//	}()
 	//	return nil
 	//}
-	err = settleResponse.Sign(mh.photon.PrivateKey, settleResponse)
+	err = settleResponse.SignBy(mh.photon.Signer, settleResponse)
 	if err != nil {
-		panic(fmt.Sprintf("sign message for settle response err %s", err))
+		log.Error(fmt.Sprintf("sign message for settle response err %s", err))
+		return err
 	}
-	err = mh.photon.sendAsync(msg.Sender, settleResponse)
+	err = mh.photon.sendAsyncWithPolicy(msg.Sender, settleResponse, mh.photon.handshakeSendPolicy(true))
 	if err != nil {
 		log.Error(fmt.Sprintf("send message %s, to %s ,err %s", settleResponse, msg.Sender, err))
 	}
@@ -787,11 +842,12 @@ func (mh *photonMessageHandler) messageWithdrawRequest(msg *encoding.WithdrawReq
 	//	}()
 	//	return nil
 	//}
-	err = withdrawResponse.Sign(mh.photon.PrivateKey, withdrawResponse)
+	err = withdrawResponse.SignBy(mh.photon.Signer, withdrawResponse)
 	if err != nil {
-		panic(fmt.Sprintf("sign message for withdraw response err %s", err))
+		log.Error(fmt.Sprintf("sign message for withdraw response err %s", err))
+		return err
 	}
-	err = mh.photon.sendAsync(msg.Sender, withdrawResponse)
+	err = mh.photon.sendAsyncWithPolicy(msg.Sender, withdrawResponse, mh.photon.handshakeSendPolicy(true))
 	if err != nil {
 		log.Error(fmt.Sprintf("send message %s, to %s ,err %s", withdrawResponse, msg.Sender, err))
 	}
@@ -906,9 +962,10 @@ func (mh *photonMessageHandler) processRegisterTransferError(err error, msg enco
 			//专门处理InvalidNonce这个错误,只是发送消息,但是这个消息本身还是不应该给Ack
 			data := msg.Pack()
 			em := encoding.NewErrorNotify(encoding.InvalidNonceErrorNotify, data)
-			err2 := em.Sign(mh.photon.PrivateKey, em)
+			err2 := em.SignBy(mh.photon.Signer, em)
 			if err2 != nil {
-				panic(fmt.Sprintf("sign message error %s", err2))
+				log.Error(fmt.Sprintf("sign InvalidNonceErrorNotify err %s", err2))
+				return
 			}
 			err2 = mh.photon.sendAsync(msg.GetSender(), em)
 			if err2 != nil {
@@ -0,0 +1,147 @@
+package photon
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
+	"github.com/SmartMeshFoundation/Photon/encoding"
+	"github.com/SmartMeshFoundation/Photon/network/graph"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/SmartMeshFoundation/Photon/utils/utest"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckTransferChannel(t *testing.T) {
+	our, partner, other := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	token, otherToken := utils.NewRandomAddress(), utils.NewRandomAddress()
+	ch, err := channel.NewChannel(channel.NewChannelEndState(our, big.NewInt(100), nil, mtree.EmptyTree),
+		channel.NewChannelEndState(partner, big.NewInt(50), nil, mtree.EmptyTree), &channel.ExternalState{}, token,
+		&contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash(), OpenBlockNumber: 3}, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewChannelGraph(our, token, nil)
+	assert.Nil(t, g.AddChannel(ch))
+	otherCh, err := channel.NewChannel(channel.NewChannelEndState(our, big.NewInt(100), nil, mtree.EmptyTree),
+		channel.NewChannelEndState(other, big.NewInt(50), nil, mtree.EmptyTree), &channel.ExternalState{}, otherToken,
+		&contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash(), OpenBlockNumber: 3}, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherChGraph := graph.NewChannelGraph(our, otherToken, nil)
+	assert.Nil(t, otherChGraph.AddChannel(otherCh))
+	msg := &encoding.MediatedTransfer{LockSecretHash: utils.NewRandomHash()}
+	msg.Sender = partner
+	assert.Nil(t, checkTransferChannel(msg, ch))
+	//通过我和别人的通道伪造 token
+	assert.NotNil(t, checkTransferChannel(msg, otherCh))
+
+	//在按通道状态放弃交易之前就拒绝
+	rs := &Service{
+		Config:             &params.Config{},
+		IsChainEffective:   true,
+		Token2ChannelGraph: map[common.Address]*graph.ChannelGraph{token: g},
+	}
+	rs.Token2ChannelGraph[otherToken] = map[common.Address]*graph.ChannelGraph{otherToken: otherChGraph}[otherToken]
+	mh := newPhotonMessageHandler(rs)
+	otherCh.State = channeltype.StateWithdraw
+	msg.ChannelIdentifier = otherCh.ChannelIdentifier.ChannelIdentifier
+	msg.OpenBlockNumber = otherCh.ChannelIdentifier.OpenBlockNumber
+	err = mh.messageMediatedTransfer(msg)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, rerr.ErrChannelIdentifierMismatch.ErrorCode, err.(rerr.StandardError).ErrorCode)
+	}
+}
+
+func TestReceiveTransferOnUnknownToken(t *testing.T) {
+	rs := &Service{
+		Config:           &params.Config{},
+		IsChainEffective: true,
+	}
+	mh := &photonMessageHandler{
+		photon:        rs,
+		blockedTokens: make(map[common.Address]bool),
+	}
+	msg := &encoding.MediatedTransfer{LockSecretHash: utils.NewRandomHash()}
+	msg.Sender = utils.NewRandomAddress()
+	msg.ChannelIdentifier = utils.NewRandomHash()
+	assert.NotNil(t, mh.messageMediatedTransfer(msg))
+	_, err := rs.getOrRegisterTokenGraph(utils.NewRandomAddress())
+	assert.NotNil(t, err)
+	//通道所属的 token 没有注册,不能 panic
+	ch := &channel.Channel{TokenAddress: utils.NewRandomAddress()}
+	rs.mediateMediatedTransfer(msg, ch)
+}
+
+/*
+启动过程中,消息涉及的通道可能还没有加载,这些消息都必须返回错误,
+这样不会回复 ack,对方稍后会重发
+*/
+func TestEarlyMessagesOnUnknownChannel(t *testing.T) {
+	rs := &Service{
+		Config: &params.Config{},
+		Clock:  utest.NewFakeClock(time.Now()),
+	}
+	mh := newPhotonMessageHandler(rs)
+	newMessages := func() []encoding.SignedMessager {
+		mt := &encoding.MediatedTransfer{LockSecretHash: utils.NewRandomHash()}
+		mt.ChannelIdentifier = utils.NewRandomHash()
+		return []encoding.SignedMessager{
+			&encoding.UnLock{},
+			&encoding.DirectTransfer{},
+			mt,
+			&encoding.AnnounceDisposedResponse{},
+			&encoding.RemoveExpiredHashlockTransfer{},
+			&encoding.SettleResponse{},
+			&encoding.WithdrawResponse{},
+		}
+	}
+	for _, effective := range []bool{false, true} {
+		rs.IsChainEffective = effective
+		for _, msg := range newMessages() {
+			assert.NotNil(t, mh.onMessage(msg, utils.NewRandomHash()), "%T,chain effective=%v", msg, effective)
+		}
+	}
+}
+
+//通道未知说明已经 settle,AnnounceDisposed 只能忽略,但是要回复 ack,否则对方会一直重发
+func TestAnnounceDisposedOnUnknownChannel(t *testing.T) {
+	our, partner, token := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	ch, err := channel.NewChannel(channel.NewChannelEndState(our, big.NewInt(100), nil, mtree.EmptyTree),
+		channel.NewChannelEndState(partner, big.NewInt(50), nil, mtree.EmptyTree), &channel.ExternalState{}, token,
+		&contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash(), OpenBlockNumber: 3}, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewChannelGraph(our, token, nil)
+	assert.Nil(t, g.AddChannel(ch))
+	rs := &Service{
+		NodeAddress:        our,
+		Config:             &params.Config{},
+		Token2ChannelGraph: map[common.Address]*graph.ChannelGraph{token: g},
+		IsChainEffective:   true,
+		Clock:              utest.NewFakeClock(time.Now()),
+	}
+	mh := newPhotonMessageHandler(rs)
+	for _, c := range []struct {
+		channelIdentifier common.Hash
+		sender            common.Address
+	}{
+		{utils.NewRandomHash(), partner},
+		{ch.ChannelIdentifier.ChannelIdentifier, utils.NewRandomAddress()},
+	} {
+		msg := &encoding.AnnounceDisposed{}
+		msg.ChannelIdentifier = c.channelIdentifier
+		msg.Sender = c.sender
+		assert.Nil(t, mh.onMessage(msg, utils.NewRandomHash()))
+	}
+	assert.EqualValues(t, 0, ch.PartnerState.BalanceProofState.Nonce)
+}
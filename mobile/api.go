@@ -518,7 +518,7 @@ func (a *API) Transfers(tokenAddress, targetAddress string, amountstr string, se
 			return dto.NewErrorMobileResponse(err)
 		}
 	}
-	tr, err := a.api.TransferAsync(tokenAddr, amount, targetAddr, secret, isDirect, data, routeInfo)
+	tr, err := a.api.TransferAsync(tokenAddr, amount, targetAddr, secret, isDirect, data, routeInfo, nil)
 	if err != nil {
 		log.Error(err.Error())
 		return dto.NewErrorMobileResponse(err)
@@ -727,7 +727,7 @@ func (a *API) GetReceivedTransfers(tokenAddressStr string, from, to int64) (resu
 	if tokenAddressStr != "" {
 		tokenAddress = common.HexToAddress(tokenAddressStr)
 	}
-	trs, err := a.api.GetReceivedTransfers(tokenAddress, from, to, -1, -1)
+	trs, err := a.api.GetReceivedTransfers(tokenAddress, from, to, -1, -1, "")
 	if err != nil {
 		log.Error(err.Error())
 		return dto.NewErrorMobileResponse(err)
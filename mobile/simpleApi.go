@@ -35,7 +35,7 @@ func NewSimpleAPI(datadir, address string) (api *SimpleAPI, err error) {
 		}
 	}
 	databasePath := filepath.Join(userDbPath, "log.db")
-	dao, err := stormdb.OpenDb(databasePath)
+	dao, err := stormdb.OpenDb(databasePath, "")
 	if err != nil {
 		err = fmt.Errorf("open db error %s", err)
 		return
@@ -20,6 +20,11 @@ type SentEnvelopMessager struct {
 	EchoHash []byte `storm:"id"`
 }
 
+// GetKey : impl dao.KeyGetter
+func (s *SentEnvelopMessager) GetKey() []byte {
+	return s.EchoHash
+}
+
 type envelopMessageSorter []*SentEnvelopMessager
 
 func (c envelopMessageSorter) Len() int {
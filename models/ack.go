@@ -0,0 +1,13 @@
+package models
+
+import "github.com/ethereum/go-ethereum/common"
+
+/*
+Ack 已经处理过的消息的 ack,对方重发同一条消息时直接回复,不再处理.
+BlockNumber 是保存时的块号,没有块号索引的 ack 为0
+*/
+type Ack struct {
+	EchoHash    common.Hash
+	Ack         []byte
+	BlockNumber int64
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"encoding/gob"
+	"math/big"
+)
+
+//BalanceChangeKind 通道余额变化的原因
+type BalanceChangeKind string
+
+const (
+	//BalanceChangeOpened 第一次记录这个通道,包括 settle 以后重新打开
+	BalanceChangeOpened BalanceChangeKind = "opened"
+	//BalanceChangeDeposit 合约中的押金增加
+	BalanceChangeDeposit BalanceChangeKind = "deposit"
+	//BalanceChangeWithdraw 合约中的押金减少
+	BalanceChangeWithdraw BalanceChangeKind = "withdraw"
+	//BalanceChangeSent 我在通道上付款,包括作为中间节点转出
+	BalanceChangeSent BalanceChangeKind = "sent"
+	//BalanceChangeReceived 我在通道上收款,包括作为中间节点转入
+	BalanceChangeReceived BalanceChangeKind = "received"
+)
+
+/*
+BalancePoint 通道余额每变化一次记录一个点,余额在两个点之间保持不变.
+Balance 和 PartnerBalance 不包括还没有解锁的锁
+*/
+type BalancePoint struct {
+	ID                     int               `storm:"id,increment" json:"-"`
+	ChannelIdentifier      []byte            `storm:"index" json:"-"`
+	OpenBlockNumber        int64             `json:"open_block_number"`
+	BlockNumber            int64             `json:"block_number"`
+	Time                   int64             `json:"time"`
+	Kind                   BalanceChangeKind `json:"kind"`
+	Balance                *big.Int          `json:"balance"`
+	PartnerBalance         *big.Int          `json:"partner_balance"`
+	OurContractBalance     *big.Int          `json:"-"`
+	PartnerContractBalance *big.Int          `json:"-"`
+}
+
+func init() {
+	gob.Register(&BalancePoint{})
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"encoding/gob"
+	"math/big"
+)
+
+//ChannelStatsKind 统计的交易类型
+type ChannelStatsKind int
+
+const (
+	//ChannelStatsSent 我发出的交易
+	ChannelStatsSent ChannelStatsKind = iota
+	//ChannelStatsReceived 我收到的交易
+	ChannelStatsReceived
+	//ChannelStatsMediated 我作为中间节点转发的交易,付款和收款两个通道都会计数
+	ChannelStatsMediated
+)
+
+/*
+ChannelStats 通道上成功交易的笔数和金额,通道 settle 以后依然保留,可以手工清零
+*/
+type ChannelStats struct {
+	SentCount     int
+	RecvCount     int
+	MediatedCount int
+	SentVolume    *big.Int
+	RecvVolume    *big.Int
+}
+
+//NewChannelStats returns empty stats
+func NewChannelStats() *ChannelStats {
+	return &ChannelStats{
+		SentVolume: big.NewInt(0),
+		RecvVolume: big.NewInt(0),
+	}
+}
+
+func init() {
+	gob.Register(&ChannelStats{})
+}
@@ -52,6 +52,12 @@ const (
 	BucketTXInfo                   = "TXInfo"
 	BucketSentTransferDetail       = "SentTransferDetail"
 	BucketChainEventRecord         = "ChainEventRecord"
+	BucketRouteDenylist            = "RouteDenylist"
+	BucketChannelStats             = "ChannelStats"
+	/*
+		ack 的块号索引,key 为 8字节大端块号+echohash,用于按块号清理 ack
+	*/
+	BucketAckBlock = "AckBlock"
 )
 
 /*
@@ -75,4 +81,6 @@ const (
 	KeyFeePolicy string = "feePolicy"
 	// keys of BucketToken
 	KeyToken = "tokens"
+	// keys of BucketRouteDenylist
+	KeyRouteDenylist = "denylist"
 )
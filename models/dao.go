@@ -29,8 +29,13 @@ type TX interface {
 // AckDao :
 type AckDao interface {
 	GetAck(echoHash common.Hash) []byte
-	SaveAck(echoHash common.Hash, ack []byte, tx TX)
-	SaveAckNoTx(echoHash common.Hash, ack []byte)
+	//SaveAck blockNumber 是收到消息时的块号,清理 ack 时使用
+	SaveAck(echoHash common.Hash, ack []byte, blockNumber int64, tx TX)
+	SaveAckNoTx(echoHash common.Hash, ack []byte, blockNumber int64)
+	//RemoveAcksBefore 删除在 blockNumber 之前保存的 ack,返回删除的数量
+	RemoveAcksBefore(blockNumber int64) (removed int, err error)
+	//GetAllAcks 所有保存的 ack,用于导出节点状态
+	GetAllAcks() (acks []*Ack, err error)
 }
 
 // BlockNumberDao :
@@ -53,7 +58,7 @@ type ChannelUpdateDao interface {
 	UpdateChannelNoTx(c *channeltype.Serialization) error
 	UpdateChannelState(c *channeltype.Serialization) error
 	// mix update
-	UpdateChannelAndSaveAck(c *channeltype.Serialization, echoHash common.Hash, ack []byte) (err error)
+	UpdateChannelAndSaveAck(c *channeltype.Serialization, echoHash common.Hash, ack []byte, blockNumber int64) (err error)
 	UpdateChannelContractBalance(c *channeltype.Serialization) error
 }
 
@@ -68,6 +73,8 @@ type ChannelDao interface {
 	GetChannel(token, partner common.Address) (c *channeltype.Serialization, err error)
 	GetChannelByAddress(channelIdentifier common.Hash) (c *channeltype.Serialization, err error)
 	GetChannelList(token, partner common.Address) (cs []*channeltype.Serialization, err error)
+	//GetChannelListByTokenPage 分批读取 token 下的通道,按 Key 排序,返回 Key 大于 after 的最多 limit 个,after 为空从头开始
+	GetChannelListByTokenPage(token common.Address, after []byte, limit int) (cs []*channeltype.Serialization, err error)
 }
 
 // UnlockDao :
@@ -125,10 +132,11 @@ type NonParticipantChannelDao interface {
 
 // SentAnnounceDisposedDao :
 type SentAnnounceDisposedDao interface {
-	MarkLockSecretHashDisposed(lockSecretHash common.Hash, channelIdentifier common.Hash) error
+	MarkLockSecretHashDisposed(lockSecretHash common.Hash, channelIdentifier common.Hash, blockNumber int64, reason string) error
 	IsLockSecretHashDisposed(lockSecretHash common.Hash) bool
 	IsLockSecretHashChannelIdentifierDisposed(lockSecretHash common.Hash, ChannelIdentifier common.Hash) bool
 	GetSendAnnounceDisposeByChannel(channelIdentifier common.Hash, isSubmitToPms bool) (list []*SentAnnounceDisposed)
+	GetDisposedLocks(channelIdentifier common.Hash) (locks []*DisposedLock, err error)
 	MarkSendAnnounceDisposeSubmittedByChannel(channelIdentifier common.Hash)
 }
 
@@ -156,9 +164,10 @@ type TokenDao interface {
 
 // ReceivedTransferDao :
 type ReceivedTransferDao interface {
-	NewReceivedTransfer(blockNumber int64, channelIdentifier common.Hash, openBlockNumber int64, tokenAddr, fromAddr common.Address, nonce uint64, amount *big.Int, lockSecretHash common.Hash, data string) *ReceivedTransfer
+	NewReceivedTransfer(blockNumber int64, channelIdentifier common.Hash, openBlockNumber int64, tokenAddr, fromAddr common.Address, nonce uint64, amount *big.Int, lockSecretHash common.Hash, data string, outcome string) *ReceivedTransfer
 	GetReceivedTransfer(key string) (*ReceivedTransfer, error)
 	GetReceivedTransferList(tokenAddress common.Address, fromBlock, toBlock, fromTime, toTime int64) (transfers []*ReceivedTransfer, err error)
+	UpdateReceivedTransferPaymentPointer(key string, pointer string) error
 }
 
 // SentTransferDetailDao :
@@ -166,6 +175,8 @@ type SentTransferDetailDao interface {
 	NewSentTransferDetail(tokenAddress, target common.Address, amount *big.Int, data string, isDirect bool, lockSecretHash common.Hash)
 	UpdateSentTransferDetailStatus(tokenAddress common.Address, lockSecretHash common.Hash, status TransferStatusCode, statusMessage string, otherParams interface{}) (transfer *SentTransferDetail)
 	UpdateSentTransferDetailStatusMessage(tokenAddress common.Address, lockSecretHash common.Hash, statusMessage string) (transfer *SentTransferDetail)
+	UpdateSentTransferDetailMetadata(tokenAddress common.Address, lockSecretHash common.Hash, metadata map[string]string) (transfer *SentTransferDetail)
+	UpdateSentTransferDetailRoute(tokenAddress common.Address, lockSecretHash common.Hash, route []common.Address) (transfer *SentTransferDetail)
 	GetSentTransferDetail(tokenAddress common.Address, lockSecretHash common.Hash) (*SentTransferDetail, error)
 	GetSentTransferDetailList(tokenAddress common.Address, fromTime, toTime int64, fromBlock, toBlock int64) (transfers []*SentTransferDetail, err error)
 }
@@ -183,6 +194,7 @@ type TXInfoDao interface {
 	SaveEventToTXInfo(event interface{}) (txInfo *TXInfo, err error)
 	UpdateTXInfoStatus(txHash common.Hash, status TXInfoStatus, pendingBlockNumber int64, gasUsed uint64) (txInfo *TXInfo, err error)
 	GetTXInfoList(channelIdentifier common.Hash, openBlockNumber int64, tokenAddress common.Address, txType TXInfoType, status TXInfoStatus) (list []*TXInfo, err error)
+	GetTXInfoListPackedSince(blockNumber int64) (list []*TXInfo, err error)
 }
 
 // ChainEventRecordDao :
@@ -200,6 +212,46 @@ type UnlockToSendDao interface {
 	RemoveUnlockToSend(key []byte)
 }
 
+// RouteDenylistDao :
+type RouteDenylistDao interface {
+	AddRouteDenylist(addr common.Address) error
+	RemoveRouteDenylist(addr common.Address) error
+	GetRouteDenylist() (addrs []common.Address)
+}
+
+// TransferMessageDao :
+type TransferMessageDao interface {
+	NewTransferMessage(lockSecretHash, channelIdentifier common.Hash, msg encoding.SignedMessager, isSent bool)
+	GetTransferMessages(lockSecretHash common.Hash) (list []*TransferMessage, err error)
+	RemoveTransferMessagesOnChannel(channelIdentifier common.Hash)
+}
+
+// ChannelStatsDao :
+type ChannelStatsDao interface {
+	AddChannelStats(channelIdentifier common.Hash, kind ChannelStatsKind, amount *big.Int) error
+	GetChannelStats(channelIdentifier common.Hash) (s *ChannelStats, err error)
+	ResetChannelStats(channelIdentifier common.Hash) error
+}
+
+// BalanceHistoryDao :
+type BalanceHistoryDao interface {
+	AddBalancePoint(p *BalancePoint) error
+	GetBalancePoints(channelIdentifier common.Hash) (points []*BalancePoint, err error)
+}
+
+// InvoiceDao :
+type InvoiceDao interface {
+	SaveInvoice(inv *Invoice) error
+	GetInvoice(invoiceID common.Hash) (inv *Invoice, err error)
+}
+
+// PaymentPointerDao :
+type PaymentPointerDao interface {
+	SavePaymentPointer(pp *PaymentPointer) error
+	GetPaymentPointer(label string) (pp *PaymentPointer, err error)
+	GetPaymentPointerList() (pps []*PaymentPointer, err error)
+}
+
 // Dao :
 type Dao interface {
 	AckDao
@@ -224,6 +276,12 @@ type Dao interface {
 	SentTransferDetailDao
 	ChainEventRecordDao
 	UnlockToSendDao
+	RouteDenylistDao
+	TransferMessageDao
+	ChannelStatsDao
+	InvoiceDao
+	BalanceHistoryDao
+	PaymentPointerDao
 
 	StartTx() (tx TX)
 	CloseDB()
@@ -233,6 +291,7 @@ type Dao interface {
 	RegisterChannelDepositCallback(f cb.ChannelCb)
 	RegisterChannelStateCallback(f cb.ChannelCb)
 	RegisterChannelSettleCallback(f cb.ChannelCb)
+	RegisterTXInfoCallback(f TXInfoCb)
 }
 
 //GeneratDBError helper function
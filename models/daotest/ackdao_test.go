@@ -5,7 +5,9 @@ import (
 
 	"reflect"
 
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
 	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
 	"github.com/SmartMeshFoundation/Photon/utils"
 )
 
@@ -15,7 +17,7 @@ func TestAckDao(t *testing.T) {
 	echoHash := utils.NewRandomHash()
 	tx := dao.StartTx()
 	// save with tx and get
-	dao.SaveAck(echoHash, echoHash.Bytes(), tx)
+	dao.SaveAck(echoHash, echoHash.Bytes(), 0, tx)
 	tx.Commit()
 	r1 := dao.GetAck(echoHash)
 	if !reflect.DeepEqual(r1, echoHash.Bytes()) {
@@ -24,10 +26,65 @@ func TestAckDao(t *testing.T) {
 	}
 	// save and get
 	echoHash = utils.NewRandomHash()
-	dao.SaveAckNoTx(echoHash, echoHash.Bytes())
+	dao.SaveAckNoTx(echoHash, echoHash.Bytes(), 0)
 	r2 := dao.GetAck(echoHash)
 	if !reflect.DeepEqual(r2, echoHash.Bytes()) {
 		t.Error("not equal")
 		return
 	}
 }
+
+func TestRemoveAcksBefore(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	old := utils.NewRandomHash()
+	dao.SaveAckNoTx(old, old.Bytes(), 10)
+	recent := utils.NewRandomHash()
+	tx := dao.StartTx()
+	dao.SaveAck(recent, recent.Bytes(), 20, tx)
+	tx.Commit()
+	removed, err := dao.RemoveAcksBefore(20)
+	if err != nil || removed != 1 {
+		t.Errorf("removed=%d,err=%v", removed, err)
+	}
+	if dao.GetAck(old) != nil {
+		t.Error("old ack should be removed")
+	}
+	if dao.GetAck(recent) == nil {
+		t.Error("recent ack should be kept")
+	}
+}
+
+func TestUpdateChannelAndSaveAckBlockNumber(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	h := utils.NewRandomHash()
+	token, partner := utils.NewRandomAddress(), utils.NewRandomAddress()
+	ch := &channeltype.Serialization{
+		ChannelIdentifier: &contracts.ChannelUniqueID{
+			ChannelIdentifier: h,
+			OpenBlockNumber:   3,
+		},
+		Key:                 h[:],
+		TokenAddressBytes:   token[:],
+		PartnerAddressBytes: partner[:],
+	}
+	if err := dao.NewChannel(ch); err != nil {
+		t.Fatal(err)
+	}
+	//数据库中的块号和消息的块号无关
+	dao.SaveLatestBlockNumber(100)
+	echoHash := utils.NewRandomHash()
+	if err := dao.UpdateChannelAndSaveAck(ch, echoHash, echoHash.Bytes(), 20); err != nil {
+		t.Fatal(err)
+	}
+	if removed, err := dao.RemoveAcksBefore(20); err != nil || removed != 0 {
+		t.Errorf("removed=%d,err=%v", removed, err)
+	}
+	if removed, err := dao.RemoveAcksBefore(21); err != nil || removed != 1 {
+		t.Errorf("removed=%d,err=%v", removed, err)
+	}
+	if dao.GetAck(echoHash) != nil {
+		t.Error("ack should be removed")
+	}
+}
@@ -0,0 +1,35 @@
+package daotest
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModelDB_BalancePoints(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	ch1, ch2 := utils.NewRandomHash(), utils.NewRandomHash()
+	points, err := dao.GetBalancePoints(ch1)
+	assert.Nil(t, err)
+	assert.Len(t, points, 0)
+	for i, ch := range [][]byte{ch1[:], ch2[:], ch1[:]} {
+		err = dao.AddBalancePoint(&models.BalancePoint{
+			ChannelIdentifier: ch,
+			BlockNumber:       int64(i),
+			Kind:              models.BalanceChangeSent,
+			Balance:           big.NewInt(int64(i)),
+		})
+		assert.Nil(t, err)
+	}
+	points, err = dao.GetBalancePoints(ch1)
+	if assert.Nil(t, err) && assert.Len(t, points, 2) {
+		assert.EqualValues(t, 0, points[0].BlockNumber)
+		assert.EqualValues(t, 2, points[1].BlockNumber)
+		assert.EqualValues(t, big.NewInt(2), points[1].Balance)
+	}
+}
@@ -1,6 +1,7 @@
 package daotest
 
 import (
+	"bytes"
 	"fmt"
 	"testing"
 
@@ -194,3 +195,60 @@ func TestModelDB_NewSettledChannel(t *testing.T) {
 	}
 	assert.EqualValues(t, len(chs), 2)
 }
+
+func TestGetChannelListByTokenPage(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	token := utils.NewRandomAddress()
+	for i := 0; i < 5; i++ {
+		h := utils.NewRandomHash()
+		partner := utils.NewRandomAddress()
+		err := dao.NewChannel(&channeltype.Serialization{
+			ChannelIdentifier: &contracts.ChannelUniqueID{
+				ChannelIdentifier: h,
+				OpenBlockNumber:   3,
+			},
+			Key:                 h[:],
+			TokenAddressBytes:   token[:],
+			PartnerAddressBytes: partner[:],
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	//其他 token 的通道不会出现在结果中
+	other := utils.NewRandomAddress()
+	h := utils.NewRandomHash()
+	assert.Nil(t, dao.NewChannel(&channeltype.Serialization{
+		ChannelIdentifier:   &contracts.ChannelUniqueID{ChannelIdentifier: h, OpenBlockNumber: 3},
+		Key:                 h[:],
+		TokenAddressBytes:   other[:],
+		PartnerAddressBytes: utils.NewRandomAddress().Bytes(),
+	}))
+	seen := make(map[common.Hash]bool)
+	var after []byte
+	for i := 0; i < 3; i++ {
+		cs, err := dao.GetChannelListByTokenPage(token, after, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range cs {
+			assert.EqualValues(t, token[:], c.TokenAddressBytes)
+			seen[c.ChannelIdentifier.ChannelIdentifier] = true
+			assert.True(t, bytes.Compare(c.Key, after) > 0)
+			after = c.Key
+		}
+		if i < 2 {
+			assert.Len(t, cs, 2)
+		} else {
+			assert.Len(t, cs, 1)
+		}
+	}
+	assert.Len(t, seen, 5)
+	cs, err := dao.GetChannelListByTokenPage(token, after, 2)
+	assert.Nil(t, err)
+	assert.Empty(t, cs)
+	cs, err = dao.GetChannelListByTokenPage(utils.NewRandomAddress(), nil, 2)
+	assert.Nil(t, err)
+	assert.Empty(t, cs)
+}
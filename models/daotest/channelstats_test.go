@@ -0,0 +1,55 @@
+package daotest
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModelDB_ChannelStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "channelstats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dbPath := path.Join(dir, "stats.db")
+	dao := codefortest.NewTestDB(dbPath)
+	ch := utils.NewRandomHash()
+	s, err := dao.GetChannelStats(ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualValues(t, models.NewChannelStats(), s)
+	assert.Nil(t, dao.AddChannelStats(ch, models.ChannelStatsSent, big.NewInt(10)))
+	assert.Nil(t, dao.AddChannelStats(ch, models.ChannelStatsSent, big.NewInt(5)))
+	assert.Nil(t, dao.AddChannelStats(ch, models.ChannelStatsReceived, big.NewInt(3)))
+	assert.Nil(t, dao.AddChannelStats(ch, models.ChannelStatsMediated, big.NewInt(7)))
+	dao.CloseDB()
+
+	//重启以后统计依然存在
+	dao = codefortest.NewTestDB(dbPath)
+	defer dao.CloseDB()
+	s, err = dao.GetChannelStats(ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualValues(t, 2, s.SentCount)
+	assert.EqualValues(t, 1, s.RecvCount)
+	assert.EqualValues(t, 1, s.MediatedCount)
+	assert.EqualValues(t, 15, s.SentVolume.Int64())
+	assert.EqualValues(t, 3, s.RecvVolume.Int64())
+	assert.Nil(t, dao.ResetChannelStats(ch))
+	s, err = dao.GetChannelStats(ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualValues(t, models.NewChannelStats(), s)
+	assert.Nil(t, dao.ResetChannelStats(ch))
+}
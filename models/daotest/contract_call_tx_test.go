@@ -7,6 +7,7 @@ import (
 	"github.com/SmartMeshFoundation/Photon/codefortest"
 	"github.com/SmartMeshFoundation/Photon/models"
 	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/stretchr/testify/assert"
 )
@@ -87,3 +88,121 @@ func TestModelDB_ContractCallTX(t *testing.T) {
 	assert.EqualValues(t, models.TXInfoStatusSuccess, list[0].Status)
 	assert.EqualValues(t, 2, list[0].PackBlockNumber)
 }
+
+func TestModelDB_GetTXInfoListPackedSince(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	newTX := func(nonce uint64, status models.TXInfoStatus, packBlockNumber int64) *types.Transaction {
+		tx := types.NewTransaction(nonce, utils.NewRandomAddress(), big.NewInt(1), 0, nil, nil)
+		_, err := dao.NewPendingTXInfo(tx, models.TXInfoTypeClose, utils.NewRandomHash(), 1, "")
+		assert.Empty(t, err)
+		if status != models.TXInfoStatusPending {
+			_, err = dao.UpdateTXInfoStatus(tx.Hash(), status, packBlockNumber, 0)
+			assert.Empty(t, err)
+		}
+		return tx
+	}
+	newTX(1, models.TXInfoStatusSuccess, 9)
+	success := newTX(2, models.TXInfoStatusSuccess, 10)
+	failed := newTX(3, models.TXInfoStatusFailed, 11)
+	newTX(4, models.TXInfoStatusPending, 0)
+	list, err := dao.GetTXInfoListPackedSince(10)
+	assert.Empty(t, err)
+	assert.EqualValues(t, 2, len(list))
+	for _, txInfo := range list {
+		assert.True(t, txInfo.TXHash == success.Hash() || txInfo.TXHash == failed.Hash())
+	}
+	list, err = dao.GetTXInfoListPackedSince(12)
+	assert.Empty(t, err)
+	assert.EqualValues(t, 0, len(list))
+}
+
+/*
+BenchmarkGetTXInfoListDuringWrites 比较没有写入和持续写入时并发查询的耗时,
+查询使用 bolt 的读事务,不应该因为写入明显变慢
+*/
+func BenchmarkGetTXInfoListDuringWrites(b *testing.B) {
+	for _, writing := range []bool{false, true} {
+		name := "idle"
+		if writing {
+			name = "writing"
+		}
+		b.Run(name, func(b *testing.B) {
+			dao := codefortest.NewTestDB("")
+			defer dao.CloseDB()
+			//写入只更新已有 tx 的状态,保持数据量不变,以免查询变慢只是因为数据变多
+			var hashes []common.Hash
+			for i := 0; i < 100; i++ {
+				tx := types.NewTransaction(uint64(i), utils.NewRandomAddress(), big.NewInt(1), 0, nil, nil)
+				_, err := dao.NewPendingTXInfo(tx, models.TXInfoTypeDeposit, utils.NewRandomHash(), 1, "")
+				if err != nil {
+					b.Fatal(err)
+				}
+				hashes = append(hashes, tx.Hash())
+			}
+			stop := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := int64(1); writing; i++ {
+					select {
+					case <-stop:
+						return
+					default:
+						_, err := dao.UpdateTXInfoStatus(hashes[i%int64(len(hashes))], models.TXInfoStatusSuccess, i, 0)
+						if err != nil {
+							b.Error(err)
+							return
+						}
+					}
+				}
+			}()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					_, err := dao.GetTXInfoList(utils.EmptyHash, 0, utils.EmptyAddress, models.TXInfoTypeDeposit, "")
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+			b.StopTimer()
+			close(stop)
+			<-done
+		})
+	}
+}
+
+//tx 打包或者失败以后才通知,回调返回 true 以后移除
+func TestModelDB_TXInfoCallback(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	var got []*models.TXInfo
+	dao.RegisterTXInfoCallback(func(txInfo *models.TXInfo) (remove bool) {
+		got = append(got, txInfo)
+		return txInfo.Status == models.TXInfoStatusFailed
+	})
+	tx := types.NewTransaction(1, utils.NewRandomAddress(), big.NewInt(1), 0, nil, nil)
+	_, err := dao.NewPendingTXInfo(tx, models.TXInfoTypeDeposit, utils.NewRandomHash(), 1, "")
+	assert.Empty(t, err)
+	assert.Len(t, got, 0)
+	_, err = dao.UpdateTXInfoStatus(tx.Hash(), models.TXInfoStatusSuccess, 2, 0)
+	assert.Empty(t, err)
+	if assert.Len(t, got, 1) {
+		assert.EqualValues(t, models.TXInfoStatusSuccess, got[0].Status)
+	}
+
+	//提交失败时保存的虚构 tx 也通知
+	fakeTx := types.NewTransaction(2, utils.NewRandomAddress(), big.NewInt(models.FakeTXAmount), 0, big.NewInt(0), nil)
+	_, err = dao.NewPendingTXInfo(fakeTx, models.TXInfoTypeDeposit, utils.NewRandomHash(), 1, "", true)
+	assert.Empty(t, err)
+	if assert.Len(t, got, 2) {
+		assert.EqualValues(t, fakeTx.Hash(), got[1].TXHash)
+		assert.EqualValues(t, models.TXInfoStatusFailed, got[1].Status)
+	}
+
+	//已经移除
+	_, err = dao.UpdateTXInfoStatus(tx.Hash(), models.TXInfoStatusFailed, 3, 0)
+	assert.Empty(t, err)
+	assert.Len(t, got, 2)
+}
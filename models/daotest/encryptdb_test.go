@@ -0,0 +1,146 @@
+package daotest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
+	"github.com/SmartMeshFoundation/Photon/models/stormdb"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	bolt "github.com/coreos/bbolt"
+)
+
+func isEncryptionKeyError(err error) bool {
+	e, ok := err.(rerr.StandardError)
+	return ok && e.ErrorCode == rerr.ErrDBEncryptionKey.ErrorCode
+}
+
+func TestEncryptedDb(t *testing.T) {
+	dir, err := ioutil.TempDir("", "encryptdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dbPath := path.Join(dir, "encrypted.db")
+	dao, err := stormdb.OpenDb(dbPath, "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := utils.NewRandomAddress()
+	err = dao.AddToken(token, utils.EmptyAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dao.CloseDB()
+	content, err := ioutil.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(content, token[:]) {
+		t.Error("token should be encrypted")
+	}
+
+	_, err = stormdb.OpenDb(dbPath, "456")
+	if !isEncryptionKeyError(err) {
+		t.Errorf("wrong key should fail,err=%v", err)
+	}
+	_, err = stormdb.OpenDb(dbPath, "")
+	if !isEncryptionKeyError(err) {
+		t.Errorf("open without key should fail,err=%v", err)
+	}
+	dao, err = stormdb.OpenDb(dbPath, "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := dao.GetAllTokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tokens[token]; !ok {
+		t.Error("token should be read back")
+	}
+	settled := &channeltype.Serialization{
+		ChannelIdentifier: &contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash()},
+		State:             channeltype.StateSettled,
+	}
+	err = dao.NewSettledChannel(settled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chs, err := dao.GetAllSettledChannel()
+	if err != nil || len(chs) != 1 {
+		t.Errorf("settled channels should be read back,err=%v", err)
+	}
+	dao.CloseDB()
+
+	plainPath := path.Join(dir, "plain.db")
+	dao, err = stormdb.OpenDb(plainPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dao.CloseDB()
+	_, err = stormdb.OpenDb(plainPath, "123")
+	if !isEncryptionKeyError(err) {
+		t.Errorf("plain db opened with key should fail,err=%v", err)
+	}
+	dao, err = stormdb.OpenDb(plainPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dao.CloseDB()
+}
+
+//相同的密码,每个数据库使用不同的随机 salt,salt 丢失以后无法打开
+func TestEncryptedDbSalt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "encryptdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bucket, key := []byte("__photon_encryption"), []byte("salt")
+	var salts [][]byte
+	for _, name := range []string{"a.db", "b.db"} {
+		dbPath := path.Join(dir, name)
+		dao, err := stormdb.OpenDb(dbPath, "123")
+		if err != nil {
+			t.Fatal(err)
+		}
+		dao.CloseDB()
+		bdb, err := bolt.Open(dbPath, os.ModePerm, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bdb.View(func(tx *bolt.Tx) error {
+			if b := tx.Bucket(bucket); b != nil {
+				salts = append(salts, append([]byte(nil), b.Get(key)...))
+			}
+			return nil
+		})
+		bdb.Close()
+	}
+	if len(salts) != 2 || len(salts[0]) != 32 || bytes.Equal(salts[0], salts[1]) {
+		t.Fatalf("each db should have a random salt,salts=%x", salts)
+	}
+
+	dbPath := path.Join(dir, "a.db")
+	bdb, err := bolt.Open(dbPath, os.ModePerm, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = bdb.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket(bucket)
+	})
+	bdb.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = stormdb.OpenDb(dbPath, "123")
+	if !isEncryptionKeyError(err) {
+		t.Errorf("db without salt should fail,err=%v", err)
+	}
+}
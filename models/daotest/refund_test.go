@@ -15,7 +15,7 @@ func TestModelDB_MarkLockSecretHashDisposed(t *testing.T) {
 	lock1 := utils.NewRandomHash()
 	lock2 := utils.NewRandomHash()
 	ch := utils.NewRandomHash()
-	err := dao.MarkLockSecretHashDisposed(lock1, ch)
+	err := dao.MarkLockSecretHashDisposed(lock1, ch, 10, "")
 	if err != nil {
 		t.Error(err)
 	}
@@ -39,6 +39,29 @@ func TestModelDB_MarkLockSecretHashDisposed(t *testing.T) {
 	assert.EqualValues(t, true, l[0].IsSubmitToPms)
 }
 
+func TestGetDisposedLocks(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	lock1, lock2 := utils.NewRandomHash(), utils.NewRandomHash()
+	ch := utils.NewRandomHash()
+	locks, err := dao.GetDisposedLocks(ch)
+	assert.Nil(t, err)
+	assert.Empty(t, locks)
+
+	assert.Nil(t, dao.MarkLockSecretHashDisposed(lock1, ch, 20, "expired"))
+	assert.Nil(t, dao.MarkLockSecretHashDisposed(lock2, ch, 10, "no route"))
+	assert.Nil(t, dao.MarkLockSecretHashDisposed(lock1, utils.NewRandomHash(), 30, "other channel"))
+	locks, err = dao.GetDisposedLocks(ch)
+	assert.Nil(t, err)
+	assert.Len(t, locks, 2)
+	assert.Equal(t, lock2, locks[0].LockSecretHash)
+	assert.EqualValues(t, 10, locks[0].BlockNumber)
+	assert.Equal(t, "no route", locks[0].Reason)
+	assert.Equal(t, lock1, locks[1].LockSecretHash)
+	assert.Equal(t, ch, locks[1].ChannelIdentifier)
+	assert.Equal(t, "expired", locks[1].Reason)
+}
+
 func TestNewReceivedAnnounceDisposed(t *testing.T) {
 	lockHash := utils.NewRandomHash()
 	channel := utils.NewRandomHash()
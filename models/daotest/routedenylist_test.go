@@ -0,0 +1,46 @@
+package daotest
+
+import (
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestModelDB_RouteDenylist(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	contains := func(addr common.Address) (n int) {
+		for _, a := range dao.GetRouteDenylist() {
+			if a == addr {
+				n++
+			}
+		}
+		return
+	}
+	addr := utils.NewRandomAddress()
+	if contains(addr) != 0 {
+		t.Error("should not in denylist")
+		return
+	}
+	if err := dao.AddRouteDenylist(addr); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := dao.AddRouteDenylist(addr); err != nil {
+		t.Error(err)
+		return
+	}
+	if contains(addr) != 1 {
+		t.Error("should in denylist only once")
+		return
+	}
+	if err := dao.RemoveRouteDenylist(addr); err != nil {
+		t.Error(err)
+		return
+	}
+	if contains(addr) != 0 {
+		t.Error("should removed from denylist")
+	}
+}
@@ -19,7 +19,7 @@ func TestModelDB_NewReceivedTransfer(t *testing.T) {
 	caddr := utils.NewRandomHash()
 	var openBlockNumber int64 = 3
 	lockSecertHash := utils.NewRandomHash()
-	dao.NewReceivedTransfer(2, caddr, openBlockNumber, taddr, taddr, 3, big.NewInt(10), lockSecertHash, "123")
+	dao.NewReceivedTransfer(2, caddr, openBlockNumber, taddr, taddr, 3, big.NewInt(10), lockSecertHash, "123", "SecretRegisteredOnChain")
 	key := fmt.Sprintf("%s-%d-%d", caddr.String(), openBlockNumber, 3)
 	r, err := dao.GetReceivedTransfer(key)
 	if err != nil {
@@ -30,8 +30,9 @@ func TestModelDB_NewReceivedTransfer(t *testing.T) {
 	assert.Equal(t, r.ChannelIdentifier, caddr)
 	assert.EqualValues(t, r.Nonce, 3)
 	assert.EqualValues(t, r.Amount, big.NewInt(10))
-	dao.NewReceivedTransfer(3, caddr, openBlockNumber, taddr, taddr, 4, big.NewInt(10), lockSecertHash, "123")
-	dao.NewReceivedTransfer(5, caddr, openBlockNumber, taddr, taddr, 6, big.NewInt(10), lockSecertHash, "123")
+	assert.Equal(t, "SecretRegisteredOnChain", r.Outcome)
+	dao.NewReceivedTransfer(3, caddr, openBlockNumber, taddr, taddr, 4, big.NewInt(10), lockSecertHash, "123", "")
+	dao.NewReceivedTransfer(5, caddr, openBlockNumber, taddr, taddr, 6, big.NewInt(10), lockSecertHash, "123", "")
 
 	trs, err := dao.GetReceivedTransferList(utils.EmptyAddress, 0, 3, -1, -1)
 	if err != nil {
@@ -86,7 +87,7 @@ func TestBatchWriteDb(t *testing.T) {
 			//dao.SaveLatestBlockNumber(111)
 			//dao.UpdateTransferStatusMessage(taddr, lockSecertHash, strconv.Itoa(int(index)))
 			dao.NewSentTransferDetail(utils.NewRandomAddress(), taddr, big.NewInt(10), "123", true, lockSecertHash)
-			//dao.NewSentTransfer(3, caddr, openBlockNumber, taddr, taddr, index, big.NewInt(10), lockSecertHash, "123")
+			//dao.NewSentTransfer(3, caddr, openBlockNumber, taddr, taddr, index, big.NewInt(10), lockSecertHash, "123", "")
 			//fmt.Println("use ", time.Since(b).Seconds())
 			wg.Done()
 		}(i)
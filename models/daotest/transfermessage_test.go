@@ -0,0 +1,42 @@
+package daotest
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/encoding"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModelDB_TransferMessage(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	secret := utils.NewRandomHash()
+	lockSecretHash := utils.ShaSecret(secret[:])
+	ch1, ch2 := utils.NewRandomHash(), utils.NewRandomHash()
+	unlock1 := encoding.NewUnlock(&encoding.BalanceProof{Nonce: 1, TransferAmount: big.NewInt(1), ChannelIdentifier: ch1}, secret)
+	unlock2 := encoding.NewUnlock(&encoding.BalanceProof{Nonce: 2, TransferAmount: big.NewInt(1), ChannelIdentifier: ch2}, secret)
+	dao.NewTransferMessage(lockSecretHash, ch1, unlock1, false)
+	dao.NewTransferMessage(lockSecretHash, ch2, unlock2, true)
+	dao.NewTransferMessage(lockSecretHash, utils.EmptyHash, encoding.NewRevealSecret(secret), true)
+	//重复的消息只保存一次
+	dao.NewTransferMessage(lockSecretHash, ch1, unlock1, false)
+	list, err := dao.GetTransferMessages(lockSecretHash)
+	assert.Nil(t, err)
+	assert.Len(t, list, 3)
+	assert.EqualValues(t, unlock1.Pack(), list[0].Message.Pack())
+	_, ok := list[2].Message.(*encoding.RevealSecret)
+	assert.True(t, ok)
+
+	//另一个通道还没有 settle,RevealSecret 需要保留
+	dao.RemoveTransferMessagesOnChannel(ch1)
+	list, err = dao.GetTransferMessages(lockSecretHash)
+	assert.Nil(t, err)
+	assert.Len(t, list, 2)
+	dao.RemoveTransferMessagesOnChannel(ch2)
+	list, err = dao.GetTransferMessages(lockSecretHash)
+	assert.Nil(t, err)
+	assert.Len(t, list, 0)
+}
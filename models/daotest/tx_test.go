@@ -14,7 +14,7 @@ func TestTX(t *testing.T) {
 	// tx commit
 	echoHash := utils.NewRandomHash()
 	tx := dao.StartTx()
-	dao.SaveAck(echoHash, echoHash.Bytes(), tx)
+	dao.SaveAck(echoHash, echoHash.Bytes(), 0, tx)
 	err := tx.Commit()
 	if err != nil {
 		t.Error(err)
@@ -28,7 +28,7 @@ func TestTX(t *testing.T) {
 	// tx rollback
 	tx2 := dao.StartTx()
 	echoHash = utils.NewRandomHash()
-	dao.SaveAck(echoHash, echoHash.Bytes(), tx2)
+	dao.SaveAck(echoHash, echoHash.Bytes(), 0, tx2)
 	err = tx2.Rollback()
 	if err != nil {
 		panic(err)
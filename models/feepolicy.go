@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"math/big"
 
-	"crypto/ecdsa"
 
 	"bytes"
 	"encoding/binary"
@@ -25,19 +24,15 @@ type FeeSetting struct {
 	Signature   []byte   `json:"signature"` // used when set fee policy to pfs
 }
 
-func (fs *FeeSetting) sign(key *ecdsa.PrivateKey) []byte {
-	var err error
+func (fs *FeeSetting) sign(signer utils.Signer) (err error) {
 	buf := new(bytes.Buffer)
 	err = binary.Write(buf, binary.BigEndian, fs.FeePercent)
 	_, err = buf.Write(utils.BigIntTo32Bytes(fs.FeeConstant))
 	if err != nil {
 		log.Error(fmt.Sprintf("signData err %s", err))
 	}
-	fs.Signature, err = utils.SignData(key, buf.Bytes())
-	if err != nil {
-		panic(fmt.Sprintf("signDataFor FeeSetting err %s", err))
-	}
-	return fs.Signature
+	fs.Signature, err = signer.SignMessage(buf.Bytes())
+	return
 }
 
 // FeePolicy :
@@ -48,15 +43,25 @@ type FeePolicy struct {
 	ChannelFeeMap map[common.Hash]*FeeSetting    `json:"channel_fee_map"`
 }
 
-// Sign for pfs
-func (fp *FeePolicy) Sign(key *ecdsa.PrivateKey) {
-	fp.AccountFee.sign(key)
+// SignBy sign for pfs with signer
+func (fp *FeePolicy) SignBy(signer utils.Signer) (err error) {
+	err = fp.AccountFee.sign(signer)
+	if err != nil {
+		return
+	}
 	for _, fs := range fp.TokenFeeMap {
-		fs.sign(key)
+		err = fs.sign(signer)
+		if err != nil {
+			return
+		}
 	}
 	for _, fs := range fp.ChannelFeeMap {
-		fs.sign(key)
+		err = fs.sign(signer)
+		if err != nil {
+			return
+		}
 	}
+	return
 }
 
 const defaultKey string = "feePolicy"
@@ -21,7 +21,7 @@ func (dao *GkvDB) GetAck(echoHash common.Hash) []byte {
 }
 
 //SaveAck save a new ack to db
-func (dao *GkvDB) SaveAck(echoHash common.Hash, ack []byte, tx models.TX) {
+func (dao *GkvDB) SaveAck(echoHash common.Hash, ack []byte, blockNumber int64, tx models.TX) {
 	log.Trace(fmt.Sprintf("save ack %s to db", utils.HPex(echoHash)))
 	err := tx.Set(models.BucketAck, echoHash[:], ack)
 	if err != nil {
@@ -30,7 +30,7 @@ func (dao *GkvDB) SaveAck(echoHash common.Hash, ack []byte, tx models.TX) {
 }
 
 //SaveAckNoTx save a ack to db
-func (dao *GkvDB) SaveAckNoTx(echoHash common.Hash, ack []byte) {
+func (dao *GkvDB) SaveAckNoTx(echoHash common.Hash, ack []byte, blockNumber int64) {
 	err := dao.saveKeyValueToBucket(models.BucketAck, echoHash[:], ack)
 	if err != nil {
 		log.Error(fmt.Sprintf("save ack to db err %s", err))
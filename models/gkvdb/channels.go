@@ -36,7 +36,7 @@ func (dao *GkvDB) UpdateChannelNoTx(c *channeltype.Serialization) error {
 }
 
 //UpdateChannelAndSaveAck update channel and save ack, must atomic
-func (dao *GkvDB) UpdateChannelAndSaveAck(c *channeltype.Serialization, echohash common.Hash, ack []byte) (err error) {
+func (dao *GkvDB) UpdateChannelAndSaveAck(c *channeltype.Serialization, echohash common.Hash, ack []byte, blockNumber int64) (err error) {
 	// 这里是多表操作,不传表名
 	tx := dao.StartTx()
 	defer func() {
@@ -50,7 +50,7 @@ func (dao *GkvDB) UpdateChannelAndSaveAck(c *channeltype.Serialization, echohash
 		err = models.GeneratDBError(err)
 		return
 	}
-	dao.SaveAck(echohash, ack, tx)
+	dao.SaveAck(echohash, ack, blockNumber, tx)
 	err = tx.Commit()
 	err = models.GeneratDBError(err)
 	return
@@ -7,12 +7,14 @@ import (
 )
 
 //MarkLockSecretHashDisposed mark `locksecrethash` disposed on channel `ChannelIdentifier`
-func (dao *GkvDB) MarkLockSecretHashDisposed(lockSecretHash common.Hash, ChannelIdentifier common.Hash) error {
+func (dao *GkvDB) MarkLockSecretHashDisposed(lockSecretHash common.Hash, ChannelIdentifier common.Hash, blockNumber int64, reason string) error {
 	key := utils.Sha3(lockSecretHash[:], ChannelIdentifier[:])
 	sad := &models.SentAnnounceDisposed{
 		Key:               key[:],
 		LockSecretHash:    lockSecretHash[:],
 		ChannelIdentifier: ChannelIdentifier[:],
+		BlockNumber:       blockNumber,
+		Reason:            reason,
 	}
 	err := dao.saveKeyValueToBucket(models.BucketSentAnnounceDisposed, sad.Key, sad)
 	return models.GeneratDBError(err)
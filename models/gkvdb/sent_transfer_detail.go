@@ -97,6 +97,50 @@ func (dao *GkvDB) UpdateSentTransferDetailStatusMessage(tokenAddress common.Addr
 	return
 }
 
+// UpdateSentTransferDetailMetadata :
+func (dao *GkvDB) UpdateSentTransferDetailMetadata(tokenAddress common.Address, lockSecretHash common.Hash, metadata map[string]string) (transfer *models.SentTransferDetail) {
+	transfer = &models.SentTransferDetail{}
+	key := utils.Sha3(tokenAddress[:], lockSecretHash[:]).String()
+	err := dao.getKeyValueToBucket(models.BucketSentTransferDetail, key, transfer)
+	if err == ErrorNotFound {
+		return
+	}
+	if err != nil {
+		log.Error(fmt.Sprintf("UpdateMetadata err %s", err))
+		return
+	}
+	transfer.Metadata = metadata
+	err = dao.saveKeyValueToBucket(models.BucketSentTransferDetail, transfer.Key, transfer)
+	if err != nil {
+		log.Error(fmt.Sprintf("UpdateMetadata err %s", err))
+		return
+	}
+	log.Trace(fmt.Sprintf("UpdateMetadata key=%s lockSecretHash=%s %v", key, lockSecretHash.String(), metadata))
+	return
+}
+
+// UpdateSentTransferDetailRoute :
+func (dao *GkvDB) UpdateSentTransferDetailRoute(tokenAddress common.Address, lockSecretHash common.Hash, route []common.Address) (transfer *models.SentTransferDetail) {
+	transfer = &models.SentTransferDetail{}
+	key := utils.Sha3(tokenAddress[:], lockSecretHash[:]).String()
+	err := dao.getKeyValueToBucket(models.BucketSentTransferDetail, key, transfer)
+	if err == ErrorNotFound {
+		return
+	}
+	if err != nil {
+		log.Error(fmt.Sprintf("UpdateRoute err %s", err))
+		return
+	}
+	transfer.Route = route
+	err = dao.saveKeyValueToBucket(models.BucketSentTransferDetail, transfer.Key, transfer)
+	if err != nil {
+		log.Error(fmt.Sprintf("UpdateRoute err %s", err))
+		return
+	}
+	log.Trace(fmt.Sprintf("UpdateRoute key=%s lockSecretHash=%s %s", key, lockSecretHash.String(), utils.StringInterface(route, 2)))
+	return
+}
+
 // GetSentTransferDetail :
 func (dao *GkvDB) GetSentTransferDetail(tokenAddress common.Address, lockSecretHash common.Hash) (*models.SentTransferDetail, error) {
 	var std models.SentTransferDetail
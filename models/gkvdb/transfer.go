@@ -14,7 +14,7 @@ import (
 )
 
 //NewReceivedTransfer save a new received transfer to db
-func (dao *GkvDB) NewReceivedTransfer(blockNumber int64, channelIdentifier common.Hash, openBlockNumber int64, tokenAddr, fromAddr common.Address, nonce uint64, amount *big.Int, lockSecretHash common.Hash, data string) *models.ReceivedTransfer {
+func (dao *GkvDB) NewReceivedTransfer(blockNumber int64, channelIdentifier common.Hash, openBlockNumber int64, tokenAddr, fromAddr common.Address, nonce uint64, amount *big.Int, lockSecretHash common.Hash, data string, outcome string) *models.ReceivedTransfer {
 	if lockSecretHash == utils.EmptyHash {
 		// direct transfer, use fakeLockSecretHash
 		lockSecretHash = utils.NewRandomHash()
@@ -31,6 +31,7 @@ func (dao *GkvDB) NewReceivedTransfer(blockNumber int64, channelIdentifier commo
 		Amount:            amount,
 		Data:              data,
 		OpenBlockNumber:   openBlockNumber,
+		Outcome:           outcome,
 		TimeStamp:         time.Now().Unix(),
 	}
 	var ost models.ReceivedTransfer
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"encoding/gob"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//InvoiceStatus 账单状态
+type InvoiceStatus int
+
+const (
+	//InvoiceStatusUnpaid 还没有收到任何付款
+	InvoiceStatusUnpaid InvoiceStatus = iota
+	//InvoiceStatusPartiallyPaid 收到了部分付款
+	InvoiceStatusPartiallyPaid
+	//InvoiceStatusPaid 已经付清,多付的部分也计入 Received
+	InvoiceStatusPaid
+	//InvoiceStatusExpired 到期之前没有付清,之后的付款不再计入这个账单
+	InvoiceStatusExpired
+)
+
+/*
+Invoice 商家创建的账单,付款方在交易的 Data 中填写 InvoiceID.Hex() 就可以付款,
+一个账单可以分多次付款,直到累计金额达到 Amount.
+*/
+type Invoice struct {
+	Key          []byte         `storm:"id" json:"-"`
+	InvoiceID    common.Hash    `json:"invoice_id"`
+	TokenAddress common.Address `json:"token_address"`
+	Amount       *big.Int       `json:"amount"`
+	Received     *big.Int       `json:"received"`
+	Payments     int            `json:"payments"`
+	Expiry       int64          `json:"expiry"`     //到期块,0表示永不过期
+	PaidBlock    int64          `json:"paid_block"` //付清时的块,0表示还没付清
+	Status       InvoiceStatus  `json:"status"`
+}
+
+//StatusAt 账单在块 blockNumber 时的状态
+func (i *Invoice) StatusAt(blockNumber int64) InvoiceStatus {
+	if i.PaidBlock > 0 {
+		return InvoiceStatusPaid
+	}
+	if i.Expiry > 0 && blockNumber > i.Expiry {
+		return InvoiceStatusExpired
+	}
+	if i.Payments > 0 {
+		return InvoiceStatusPartiallyPaid
+	}
+	return InvoiceStatusUnpaid
+}
+
+func init() {
+	gob.Register(&Invoice{})
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"encoding/gob"
+)
+
+/*
+PaymentPointer 商家用来区分付款来源的收款标识,格式是 label$节点地址,
+目录服务把它解析到我的节点,付款方在交易的 Data 中填写完整的 pointer.
+同一个 label 总是对应同一个 pointer
+*/
+type PaymentPointer struct {
+	Label     string `storm:"id" json:"label"`
+	Pointer   string `json:"pointer"`
+	TimeStamp int64  `json:"time_stamp"`
+}
+
+func init() {
+	gob.Register(&PaymentPointer{})
+}
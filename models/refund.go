@@ -21,6 +21,17 @@ type SentAnnounceDisposed struct {
 	LockSecretHash    []byte `storm:"index"` //假设非恶意的情况下,锁肯定是不会重复的.但是我有可能在多个通道上发送 AnnounceDisposed,但是肯定不会在同一个通道上发送多次 announce disposed // Assume in honest case, locks are not repeated, but maybe I send AnnounceDisposed in multiple channels.
 	ChannelIdentifier []byte `storm:"index"`
 	IsSubmitToPms     bool   `storm:"index"`
+	BlockNumber       int64  //发出 AnnounceDisposed 时的块号,之前版本保存的记录为0
+	Reason            string //放弃这个锁的原因
+}
+
+//DisposedLock 我在通道上声明放弃过的锁,用于排查交易为什么被忽略
+type DisposedLock struct {
+	LockSecretHash    common.Hash `json:"lock_secret_hash"`
+	ChannelIdentifier common.Hash `json:"channel_identifier"`
+	BlockNumber       int64       `json:"block_number"`
+	Reason            string      `json:"reason"`
+	IsSubmitToPms     bool        `json:"is_submit_to_pms"`
 }
 
 /*
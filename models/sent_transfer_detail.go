@@ -28,6 +28,17 @@ type SentTransferDetail struct {
 	*/
 	ChannelIdentifier common.Hash `json:"channel_identifier"`
 	OpenBlockNumber   int64       `json:"open_block_number"`
+
+	/*
+		发起交易时应用指定的元数据,比如订单号,只保存在本地,不会发送给其他节点
+	*/
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	/*
+		MediatedTransfer 最后一次发出时使用的路径,从第一个节点到接收方,中途换路径时会更新,
+		交易成功以后就是实际使用的路径
+	*/
+	Route []common.Address `json:"route,omitempty"`
 }
 
 func init() {
@@ -1,12 +1,14 @@
 package stormdb
 
 import (
+	"encoding/binary"
 	"fmt"
 
 	"github.com/SmartMeshFoundation/Photon/log"
 	"github.com/SmartMeshFoundation/Photon/models"
 	"github.com/SmartMeshFoundation/Photon/utils"
 	"github.com/asdine/storm"
+	bolt "github.com/coreos/bbolt"
 	"github.com/ethereum/go-ethereum/common"
 )
 
@@ -21,19 +23,111 @@ func (model *StormDB) GetAck(echoHash common.Hash) []byte {
 	return data
 }
 
-//SaveAck save a new ack to db
-func (model *StormDB) SaveAck(echoHash common.Hash, ack []byte, tx models.TX) {
+//ackBlockKey 按块号排序,清理时从头开始遍历即可
+func ackBlockKey(blockNumber int64, echoHash common.Hash) []byte {
+	key := make([]byte, 8+len(echoHash))
+	binary.BigEndian.PutUint64(key, uint64(blockNumber))
+	copy(key[8:], echoHash[:])
+	return key
+}
+
+/*
+SaveAck save a new ack to db
+块号由调用者传入,不能在这里读取数据库中的块号,tx 是写事务,在其中再打开读事务,数据库需要扩容时会死锁
+*/
+func (model *StormDB) SaveAck(echoHash common.Hash, ack []byte, blockNumber int64, tx models.TX) {
 	log.Trace(fmt.Sprintf("save ack %s to db", utils.HPex(echoHash)))
 	err := tx.Set(models.BucketAck, echoHash[:], ack)
 	if err != nil {
 		log.Error(fmt.Sprintf("db err %s", err))
 	}
+	err = tx.Set(models.BucketAckBlock, ackBlockKey(blockNumber, echoHash), echoHash[:])
+	if err != nil {
+		log.Error(fmt.Sprintf("db err %s", err))
+	}
 }
 
 //SaveAckNoTx save a ack to db
-func (model *StormDB) SaveAckNoTx(echoHash common.Hash, ack []byte) {
-	err := model.db.Set(models.BucketAck, echoHash[:], ack)
+func (model *StormDB) SaveAckNoTx(echoHash common.Hash, ack []byte, blockNumber int64) {
+	tx := model.StartTx()
+	model.SaveAck(echoHash, ack, blockNumber, tx)
+	err := tx.Commit()
 	if err != nil {
 		log.Error(fmt.Sprintf("save ack to db err %s", err))
 	}
 }
+
+//GetAllAcks returns all saved acks with the block number they are saved at
+func (model *StormDB) GetAllAcks() (acks []*models.Ack, err error) {
+	err = model.db.Bolt.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(models.BucketAck))
+		if b == nil {
+			return nil
+		}
+		blockNumbers := make(map[common.Hash]int64)
+		if index := tx.Bucket([]byte(models.BucketAckBlock)); index != nil {
+			err := index.ForEach(func(k, v []byte) error {
+				if len(k) == 8+common.HashLength {
+					blockNumbers[common.BytesToHash(k[8:])] = int64(binary.BigEndian.Uint64(k))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return b.ForEach(func(k, v []byte) error {
+			if len(k) != common.HashLength {
+				return nil
+			}
+			a := &models.Ack{EchoHash: common.BytesToHash(k)}
+			if err := model.db.Codec().Unmarshal(v, &a.Ack); err != nil {
+				return err
+			}
+			a.BlockNumber = blockNumbers[a.EchoHash]
+			acks = append(acks, a)
+			return nil
+		})
+	})
+	err = models.GeneratDBError(err)
+	return
+}
+
+/*
+RemoveAcksBefore 删除块号小于 blockNumber 时保存的 ack,
+没有块号索引的 ack 不会被删除
+*/
+func (model *StormDB) RemoveAcksBefore(blockNumber int64) (removed int, err error) {
+	err = model.db.Bolt.Update(func(tx *bolt.Tx) error {
+		index := tx.Bucket([]byte(models.BucketAckBlock))
+		if index == nil {
+			return nil
+		}
+		acks := tx.Bucket([]byte(models.BucketAck))
+		var keys [][]byte
+		c := index.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			if len(k) != 8+common.HashLength {
+				continue
+			}
+			if int64(binary.BigEndian.Uint64(k)) >= blockNumber {
+				break
+			}
+			keys = append(keys, append([]byte(nil), k...))
+		}
+		for _, k := range keys {
+			if acks != nil {
+				if err := acks.Delete(k[8:]); err != nil {
+					return err
+				}
+			}
+			if err := index.Delete(k); err != nil {
+				return err
+			}
+		}
+		removed = len(keys)
+		return nil
+	})
+	err = models.GeneratDBError(err)
+	return
+}
@@ -0,0 +1,30 @@
+package stormdb
+
+import (
+	"sort"
+
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/asdine/storm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//AddBalancePoint save one balance change of a channel
+func (model *StormDB) AddBalancePoint(p *models.BalancePoint) error {
+	return models.GeneratDBError(model.db.Save(p))
+}
+
+//GetBalancePoints returns all balance changes of channel `channelIdentifier` in the order they are saved
+func (model *StormDB) GetBalancePoints(channelIdentifier common.Hash) (points []*models.BalancePoint, err error) {
+	err = model.db.Find("ChannelIdentifier", channelIdentifier[:], &points)
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	if err != nil {
+		err = models.GeneratDBError(err)
+		return
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].ID < points[j].ID
+	})
+	return
+}
@@ -1,6 +1,9 @@
 package stormdb
 
-import "github.com/SmartMeshFoundation/Photon/models/cb"
+import (
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/models/cb"
+)
 
 // RegisterNewTokenCallback register a new token callback
 func (model *StormDB) RegisterNewTokenCallback(f cb.NewTokenCb) {
@@ -37,6 +40,13 @@ func (model *StormDB) RegisterChannelSettleCallback(f cb.ChannelCb) {
 	model.mlock.Unlock()
 }
 
+//RegisterTXInfoCallback notify when a tx is packed successfully or failed
+func (model *StormDB) RegisterTXInfoCallback(f models.TXInfoCb) {
+	model.mlock.Lock()
+	model.txInfoCallbacks[&f] = true
+	model.mlock.Unlock()
+}
+
 /*
 do we need remove a callback?
 */
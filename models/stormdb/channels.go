@@ -1,6 +1,7 @@
 package stormdb
 
 import (
+	"bytes"
 	"fmt"
 	"time"
 
@@ -12,9 +13,16 @@ import (
 	"github.com/SmartMeshFoundation/Photon/models/cb"
 	"github.com/SmartMeshFoundation/Photon/utils"
 	"github.com/asdine/storm"
+	bolt "github.com/coreos/bbolt"
 	"github.com/ethereum/go-ethereum/common"
 )
 
+//channelBucketName 和 channelTokenIndexName 是 storm 保存 channeltype.Serialization 使用的 bucket
+const (
+	channelBucketName     = "Serialization"
+	channelTokenIndexName = "__storm_index_TokenAddressBytes"
+)
+
 // NewChannel save a just created channel to db
 func (model *StormDB) NewChannel(c *channeltype.Serialization) error {
 	//log.Trace(fmt.Sprintf("new channel %s", utils.StringInterface(c, 2)))
@@ -41,7 +49,7 @@ func (model *StormDB) UpdateChannelNoTx(c *channeltype.Serialization) error {
 }
 
 //UpdateChannelAndSaveAck update channel and save ack, must atomic
-func (model *StormDB) UpdateChannelAndSaveAck(c *channeltype.Serialization, echohash common.Hash, ack []byte) (err error) {
+func (model *StormDB) UpdateChannelAndSaveAck(c *channeltype.Serialization, echohash common.Hash, ack []byte, blockNumber int64) (err error) {
 	tx := model.StartTx()
 	defer func() {
 		if err != nil {
@@ -55,7 +63,7 @@ func (model *StormDB) UpdateChannelAndSaveAck(c *channeltype.Serialization, echo
 		err = models.GeneratDBError(err)
 		return
 	}
-	model.SaveAck(echohash, ack, tx)
+	model.SaveAck(echohash, ack, blockNumber, tx)
 	err = tx.Commit()
 	err = models.GeneratDBError(err)
 	return
@@ -171,6 +179,44 @@ func (model *StormDB) GetChannelList(token, partner common.Address) (cs []*chann
 	return
 }
 
+/*
+GetChannelListByTokenPage returns at most limit channels of token whose Key is greater than after.
+storm.Skip 每次都要从头遍历索引,通道很多时分批读取就变成了 O(n²),
+所以这里直接在 TokenAddressBytes 的索引上从 after 开始遍历.
+storm 的列表索引中 key 是 value+"__"+id,值是 id,同一个 token 下的通道按 Key 排序
+*/
+func (model *StormDB) GetChannelListByTokenPage(token common.Address, after []byte, limit int) (cs []*channeltype.Serialization, err error) {
+	err = model.db.Bolt.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(channelBucketName))
+		if b == nil {
+			return nil
+		}
+		index := b.Bucket([]byte(channelTokenIndexName))
+		if index == nil {
+			return nil
+		}
+		prefix := append(token[:], '_', '_')
+		seek := append(append([]byte{}, prefix...), after...)
+		c := index.Cursor()
+		for k, id := c.Seek(seek); k != nil && bytes.HasPrefix(k, prefix); k, id = c.Next() {
+			if len(after) > 0 && bytes.Equal(k, seek) {
+				continue
+			}
+			if limit > 0 && len(cs) >= limit {
+				break
+			}
+			ch := new(channeltype.Serialization)
+			if err := model.db.Codec().Unmarshal(b.Get(id), ch); err != nil {
+				return err
+			}
+			cs = append(cs, ch)
+		}
+		return nil
+	})
+	err = models.GeneratDBError(err)
+	return
+}
+
 /*
 IsThisLockHasUnlocked return ture when  lockhash has unlocked on channel?
 */
@@ -0,0 +1,60 @@
+package stormdb
+
+import (
+	"math/big"
+
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/asdine/storm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//AddChannelStats count one successful transfer of `kind` on channel `channelIdentifier`
+func (model *StormDB) AddChannelStats(channelIdentifier common.Hash, kind models.ChannelStatsKind, amount *big.Int) (err error) {
+	tx, err := model.db.Begin(true)
+	if err != nil {
+		return models.GeneratDBError(err)
+	}
+	defer tx.Rollback()
+	s := models.NewChannelStats()
+	err = tx.Get(models.BucketChannelStats, channelIdentifier[:], s)
+	if err != nil && err != storm.ErrNotFound {
+		return models.GeneratDBError(err)
+	}
+	switch kind {
+	case models.ChannelStatsSent:
+		s.SentCount++
+		s.SentVolume.Add(s.SentVolume, amount)
+	case models.ChannelStatsReceived:
+		s.RecvCount++
+		s.RecvVolume.Add(s.RecvVolume, amount)
+	case models.ChannelStatsMediated:
+		s.MediatedCount++
+	}
+	err = tx.Set(models.BucketChannelStats, channelIdentifier[:], s)
+	if err != nil {
+		return models.GeneratDBError(err)
+	}
+	return models.GeneratDBError(tx.Commit())
+}
+
+//GetChannelStats returns stats of channel `channelIdentifier`,empty stats if nothing recorded
+func (model *StormDB) GetChannelStats(channelIdentifier common.Hash) (s *models.ChannelStats, err error) {
+	s = models.NewChannelStats()
+	err = model.db.Get(models.BucketChannelStats, channelIdentifier[:], s)
+	if err == storm.ErrNotFound {
+		return models.NewChannelStats(), nil
+	}
+	if err != nil {
+		return nil, models.GeneratDBError(err)
+	}
+	return
+}
+
+//ResetChannelStats clear stats of channel `channelIdentifier`
+func (model *StormDB) ResetChannelStats(channelIdentifier common.Hash) (err error) {
+	err = model.db.Delete(models.BucketChannelStats, channelIdentifier[:])
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	return models.GeneratDBError(err)
+}
@@ -70,6 +70,9 @@ func (model *StormDB) NewPendingTXInfo(tx *types.Transaction, txType models.TXIn
 		return
 	}
 	log.Info(fmt.Sprintf("NewPendingTXInfo : \n%s", txInfo))
+	if txInfo.Status == models.TXInfoStatusFailed {
+		model.handleTXInfoCallback(txInfo)
+	}
 	return
 }
 
@@ -124,9 +127,27 @@ func (model *StormDB) UpdateTXInfoStatus(txHash common.Hash, status models.TXInf
 	}
 	log.Info(fmt.Sprintf("UpdateTXInfoStatus txhash=%s status=%s packBlockNumber=%d", txHash.String(), status, packBlockNumber))
 	txInfo = tis.ToTXInfo()
+	if status != models.TXInfoStatusPending {
+		model.handleTXInfoCallback(txInfo)
+	}
 	return
 }
 
+func (model *StormDB) handleTXInfoCallback(txInfo *models.TXInfo) {
+	var cbs []*models.TXInfoCb
+	model.mlock.Lock()
+	for f := range model.txInfoCallbacks {
+		remove := (*f)(txInfo)
+		if remove {
+			cbs = append(cbs, f)
+		}
+	}
+	for _, f := range cbs {
+		delete(model.txInfoCallbacks, f)
+	}
+	model.mlock.Unlock()
+}
+
 // GetTXInfoList :
 // 如果参数不为空,则根据参数查询
 func (model *StormDB) GetTXInfoList(channelIdentifier common.Hash, openBlockNumber int64, tokenAddress common.Address, txType models.TXInfoType, status models.TXInfoStatus) (list []*models.TXInfo, err error) {
@@ -180,3 +201,24 @@ func (model *StormDB) GetTXInfoList(channelIdentifier common.Hash, openBlockNumb
 	}
 	return
 }
+
+// GetTXInfoListPackedSince : 已经打包在块 blockNumber 及以后的 tx,公链分叉以后需要重新确认
+func (model *StormDB) GetTXInfoListPackedSince(blockNumber int64) (list []*models.TXInfo, err error) {
+	var l []*models.TXInfoSerialization
+	err = model.db.Select(
+		q.Gte("PackBlockNumber", blockNumber),
+		q.Not(q.Eq("Status", models.TXInfoStatusPending)),
+	).Find(&l)
+	if err == storm.ErrNotFound {
+		err = nil
+		return
+	}
+	if err != nil {
+		err = models.GeneratDBError(err)
+		return
+	}
+	for _, tis := range l {
+		list = append(list, tis.ToTXInfo())
+	}
+	return
+}
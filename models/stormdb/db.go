@@ -14,13 +14,25 @@ import (
 	"github.com/SmartMeshFoundation/Photon/log"
 	"github.com/SmartMeshFoundation/Photon/models"
 	"github.com/SmartMeshFoundation/Photon/models/cb"
+	"github.com/SmartMeshFoundation/Photon/rerr"
 	"github.com/asdine/storm"
+	"github.com/asdine/storm/codec"
 	gobcodec "github.com/asdine/storm/codec/gob"
 	bolt "github.com/coreos/bbolt"
 	"github.com/ethereum/go-ethereum/common"
 )
 
-//StormDB is thread safe
+/*
+initialMmapSize bolt 的写事务在数据库增长需要重新 mmap 时,要等所有的读事务结束,
+预先 mmap 这么大的空间,数据库不超过这个大小时,耗时的查询(交易历史,统计等)不会阻塞写入
+*/
+const initialMmapSize = 64 << 20
+
+/*
+StormDB is thread safe
+bolt 支持一个写事务和多个读事务并发执行,读事务看到的是开始时的快照,
+所以查询不需要 lock,也不会和写入互相等待,写入由 bolt 自己串行化,lock 只用于关闭数据库
+*/
 type StormDB struct {
 	db                      *storm.DB
 	lock                    sync.Mutex
@@ -29,6 +41,7 @@ type StormDB struct {
 	channelDepositCallbacks map[*cb.ChannelCb]bool
 	channelStateCallbacks   map[*cb.ChannelCb]bool
 	channelSettledCallbacks map[*cb.ChannelCb]bool
+	txInfoCallbacks         map[*models.TXInfoCb]bool
 	mlock                   sync.Mutex
 	Name                    string
 }
@@ -40,17 +53,52 @@ func newStormDB() (db *StormDB) {
 		channelDepositCallbacks: make(map[*cb.ChannelCb]bool),
 		channelStateCallbacks:   make(map[*cb.ChannelCb]bool),
 		channelSettledCallbacks: make(map[*cb.ChannelCb]bool),
+		txInfoCallbacks:         make(map[*models.TXInfoCb]bool),
 	}
 
 }
 
-//OpenDb open or create a bolt db at dbPath
-func OpenDb(dbPath string) (model *StormDB, err error) {
+/*
+OpenDb open or create a bolt db at dbPath,
+encryptionKey 不为空时数据库中的值会被加密保存,只能隐藏内容,不能隐藏访问模式,详见 encryptedCodec.
+加密与否在创建数据库时决定,以后打开时 encryptionKey 错误或者加密与否不一致会返回 ErrDBEncryptionKey,不会破坏数据库.
+*/
+func OpenDb(dbPath string, encryptionKey string) (model *StormDB, err error) {
 	log.Trace(fmt.Sprintf("dbpath=%s", dbPath))
+	var c codec.MarshalUnmarshaler = gobcodec.Codec
+	codecName := c.Name()
+	if len(encryptionKey) > 0 {
+		codecName = encryptedCodecName
+	}
 	model = newStormDB()
 	needCreateDb := !common.FileExist(dbPath)
 	var ver int
-	model.db, err = storm.Open(dbPath, storm.BoltOptions(os.ModePerm, &bolt.Options{Timeout: 1 * time.Second}), storm.Codec(gobcodec.Codec))
+	bdb, err := bolt.Open(dbPath, os.ModePerm, &bolt.Options{Timeout: 1 * time.Second, InitialMmapSize: initialMmapSize})
+	if err != nil {
+		err = fmt.Errorf("cannot create or open db:%s,makesure you have write permission err:%v", dbPath, err)
+		panic(err.Error())
+	}
+	storedName := storedCodecName(bdb)
+	if len(storedName) > 0 && storedName != codecName {
+		bdb.Close()
+		return nil, rerr.ErrDBEncryptionKey.Printf("db %s is created with codec %s,but config wants %s", dbPath, storedName, codecName)
+	}
+	if len(encryptionKey) > 0 {
+		var salt []byte
+		salt, err = encryptionSalt(bdb, len(storedName) > 0)
+		if err == nil {
+			c, err = newEncryptedCodec(encryptionKey, salt)
+		}
+		if err != nil {
+			bdb.Close()
+			return nil, err
+		}
+	}
+	model.db, err = storm.Open(dbPath, storm.UseDB(bdb), storm.Codec(c))
+	if err == errDecrypt {
+		bdb.Close()
+		return nil, rerr.ErrDBEncryptionKey.Printf("wrong encryption key for db %s", dbPath)
+	}
 	if err != nil {
 		err = fmt.Errorf("cannot create or open db:%s,makesure you have write permission err:%v", dbPath, err)
 		panic(err.Error())
@@ -88,6 +136,26 @@ func OpenDb(dbPath string) (model *StormDB, err error) {
 	return
 }
 
+/*
+storedCodecName 返回创建数据库时使用的 codec,storm 在 bucket 的元数据中以明文记录了 codec 的名字,
+据此可以在读取任何值之前判断数据库是否加密,新数据库返回空
+*/
+func storedCodecName(bdb *bolt.DB) (name string) {
+	bdb.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("ReceivedTransfer"))
+		if b == nil {
+			return nil
+		}
+		m := b.Bucket([]byte("__storm_metadata"))
+		if m == nil {
+			return nil
+		}
+		name = string(m.Get([]byte("codec")))
+		return nil
+	})
+	return
+}
+
 /*
 MarkDbOpenedStatus First step   open the database
 Second step detection for normal closure IsDbCrashedLastTime
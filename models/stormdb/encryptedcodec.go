@@ -0,0 +1,123 @@
+package stormdb
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/asdine/storm/codec"
+	gobcodec "github.com/asdine/storm/codec/gob"
+	bolt "github.com/coreos/bbolt"
+	"golang.org/x/crypto/scrypt"
+)
+
+const encryptedCodecName = "gob-aes256gcm"
+
+/*
+scrypt 参数,打开数据库时计算一次,大约需要 100ms.
+修改参数以后已有的数据库无法打开
+*/
+const (
+	encryptionScryptN     = 1 << 15
+	encryptionScryptR     = 8
+	encryptionScryptP     = 1
+	encryptionSaltLength  = 32
+	encryptionBucketName  = "__photon_encryption"
+	encryptionSaltKeyName = "salt"
+	encryptionKeyLength   = 32
+)
+
+var errDecrypt = errors.New("cannot decrypt db value")
+
+/*
+encryptedCodec 在 gob 编码以后用 AES-256-GCM 加密,每个值使用随机 nonce,格式为 nonce+密文.
+只加密 value,bucket 名字,storm 的 id 以及索引都是 bolt 的 key,仍然是明文,
+所以它只能隐藏数据内容,不能隐藏访问模式以及数据的数量和大小.
+*/
+type encryptedCodec struct {
+	inner codec.MarshalUnmarshaler
+	aead  cipher.AEAD
+}
+
+/*
+newEncryptedCodec 使用 scrypt(key,salt) 作为 AES-256 密钥,salt 见 encryptionSalt
+*/
+func newEncryptedCodec(key string, salt []byte) (codec.MarshalUnmarshaler, error) {
+	k, err := scrypt.Key([]byte(key), salt, encryptionScryptN, encryptionScryptR, encryptionScryptP, encryptionKeyLength)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedCodec{
+		inner: gobcodec.Codec,
+		aead:  aead,
+	}, nil
+}
+
+func (c *encryptedCodec) Marshal(v interface{}) ([]byte, error) {
+	plain, err := c.inner.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(plain)+c.aead.Overhead())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+//Unmarshal returns errDecrypt when key is wrong or value is not encrypted
+func (c *encryptedCodec) Unmarshal(b []byte, v interface{}) error {
+	n := c.aead.NonceSize()
+	if len(b) < n+c.aead.Overhead() {
+		return errDecrypt
+	}
+	plain, err := c.aead.Open(nil, b[:n], b[n:], nil)
+	if err != nil {
+		return errDecrypt
+	}
+	return c.inner.Unmarshal(plain, v)
+}
+
+func (c *encryptedCodec) Name() string {
+	return encryptedCodecName
+}
+
+/*
+encryptionSalt 返回数据库的 salt,明文保存在单独的 bucket 中,不经过 codec.
+还没有加密数据的数据库生成一个随机的 salt,已经加密的数据库缺少 salt 时无法解密,返回 ErrDBEncryptionKey
+*/
+func encryptionSalt(bdb *bolt.DB, hasEncryptedData bool) (salt []byte, err error) {
+	err = bdb.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(encryptionBucketName))
+		if b != nil {
+			salt = append([]byte(nil), b.Get([]byte(encryptionSaltKeyName))...)
+		}
+		if len(salt) > 0 {
+			return nil
+		}
+		if hasEncryptedData {
+			return rerr.ErrDBEncryptionKey.Printf("salt of encrypted db is missing")
+		}
+		b, err := tx.CreateBucketIfNotExists([]byte(encryptionBucketName))
+		if err != nil {
+			return err
+		}
+		salt = make([]byte, encryptionSaltLength)
+		if _, err = io.ReadFull(rand.Reader, salt); err != nil {
+			return err
+		}
+		return b.Put([]byte(encryptionSaltKeyName), salt)
+	})
+	return
+}
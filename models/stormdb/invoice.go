@@ -0,0 +1,28 @@
+package stormdb
+
+import (
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/asdine/storm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//GetInvoice returns invoice `invoiceID`
+func (model *StormDB) GetInvoice(invoiceID common.Hash) (inv *models.Invoice, err error) {
+	inv = new(models.Invoice)
+	err = model.db.One("Key", invoiceID[:], inv)
+	if err == storm.ErrNotFound {
+		return nil, rerr.ErrNotFound.Printf("invoice %s not found", invoiceID.String())
+	}
+	if err != nil {
+		return nil, models.GeneratDBError(err)
+	}
+	return
+}
+
+//SaveInvoice save a new invoice or update payments of an invoice
+func (model *StormDB) SaveInvoice(inv *models.Invoice) error {
+	inv.Key = inv.InvoiceID[:]
+	err := model.db.Save(inv)
+	return models.GeneratDBError(err)
+}
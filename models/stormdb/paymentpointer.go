@@ -0,0 +1,36 @@
+package stormdb
+
+import (
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/asdine/storm"
+)
+
+//GetPaymentPointer returns payment pointer registered with `label`
+func (model *StormDB) GetPaymentPointer(label string) (pp *models.PaymentPointer, err error) {
+	pp = new(models.PaymentPointer)
+	err = model.db.One("Label", label, pp)
+	if err == storm.ErrNotFound {
+		return nil, rerr.ErrNotFound.Printf("payment pointer %s not found", label)
+	}
+	if err != nil {
+		return nil, models.GeneratDBError(err)
+	}
+	return
+}
+
+//SavePaymentPointer save a new payment pointer
+func (model *StormDB) SavePaymentPointer(pp *models.PaymentPointer) error {
+	err := model.db.Save(pp)
+	return models.GeneratDBError(err)
+}
+
+//GetPaymentPointerList returns all payment pointers
+func (model *StormDB) GetPaymentPointerList() (pps []*models.PaymentPointer, err error) {
+	err = model.db.All(&pps)
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	err = models.GeneratDBError(err)
+	return
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/asdine/storm"
 
 	"fmt"
+	"sort"
 
 	"github.com/SmartMeshFoundation/Photon/log"
 	"github.com/SmartMeshFoundation/Photon/models"
@@ -12,13 +13,15 @@ import (
 )
 
 //MarkLockSecretHashDisposed mark `locksecrethash` disposed on channel `ChannelIdentifier`
-func (model *StormDB) MarkLockSecretHashDisposed(lockSecretHash common.Hash, ChannelIdentifier common.Hash) error {
+func (model *StormDB) MarkLockSecretHashDisposed(lockSecretHash common.Hash, ChannelIdentifier common.Hash, blockNumber int64, reason string) error {
 	key := utils.Sha3(lockSecretHash[:], ChannelIdentifier[:])
 	err := model.db.Save(&models.SentAnnounceDisposed{
 		Key:               key[:],
 		LockSecretHash:    lockSecretHash[:],
 		ChannelIdentifier: ChannelIdentifier[:],
 		IsSubmitToPms:     false,
+		BlockNumber:       blockNumber,
+		Reason:            reason,
 	})
 	err = models.GeneratDBError(err)
 	return err
@@ -68,6 +71,31 @@ func (model *StormDB) GetSendAnnounceDisposeByChannel(channelIdentifier common.H
 	return
 }
 
+//GetDisposedLocks 我在 channelIdentifier 上声明放弃过的锁,按块号排序
+func (model *StormDB) GetDisposedLocks(channelIdentifier common.Hash) (locks []*models.DisposedLock, err error) {
+	var sads []*models.SentAnnounceDisposed
+	err = model.db.Find("ChannelIdentifier", channelIdentifier[:], &sads)
+	if err == storm.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, models.GeneratDBError(err)
+	}
+	for _, sad := range sads {
+		locks = append(locks, &models.DisposedLock{
+			LockSecretHash:    common.BytesToHash(sad.LockSecretHash),
+			ChannelIdentifier: channelIdentifier,
+			BlockNumber:       sad.BlockNumber,
+			Reason:            sad.Reason,
+			IsSubmitToPms:     sad.IsSubmitToPms,
+		})
+	}
+	sort.Slice(locks, func(i, j int) bool {
+		return locks[i].BlockNumber < locks[j].BlockNumber
+	})
+	return
+}
+
 // MarkSendAnnounceDisposeSubmittedByChannel :
 func (model *StormDB) MarkSendAnnounceDisposeSubmittedByChannel(channelIdentifier common.Hash) {
 	list := model.GetSendAnnounceDisposeByChannel(channelIdentifier, false)
@@ -0,0 +1,51 @@
+package stormdb
+
+import (
+	"fmt"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/asdine/storm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//GetRouteDenylist returns all nodes which should not be used as intermediate hops
+func (model *StormDB) GetRouteDenylist() (addrs []common.Address) {
+	err := model.db.Get(models.BucketRouteDenylist, models.KeyRouteDenylist, &addrs)
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	if err != nil {
+		log.Error(fmt.Sprintf("GetRouteDenylist err %s", err))
+	}
+	return
+}
+
+//AddRouteDenylist add `addr` to route denylist,adding an existing node is ignored
+func (model *StormDB) AddRouteDenylist(addr common.Address) error {
+	addrs := model.GetRouteDenylist()
+	for _, a := range addrs {
+		if a == addr {
+			return nil
+		}
+	}
+	addrs = append(addrs, addr)
+	err := model.db.Set(models.BucketRouteDenylist, models.KeyRouteDenylist, addrs)
+	return models.GeneratDBError(err)
+}
+
+//RemoveRouteDenylist remove `addr` from route denylist
+func (model *StormDB) RemoveRouteDenylist(addr common.Address) error {
+	addrs := model.GetRouteDenylist()
+	var left []common.Address
+	for _, a := range addrs {
+		if a != addr {
+			left = append(left, a)
+		}
+	}
+	if len(left) == len(addrs) {
+		return nil
+	}
+	err := model.db.Set(models.BucketRouteDenylist, models.KeyRouteDenylist, left)
+	return models.GeneratDBError(err)
+}
@@ -99,6 +99,50 @@ func (model *StormDB) UpdateSentTransferDetailStatusMessage(tokenAddress common.
 	return
 }
 
+// UpdateSentTransferDetailMetadata :
+func (model *StormDB) UpdateSentTransferDetailMetadata(tokenAddress common.Address, lockSecretHash common.Hash, metadata map[string]string) (transfer *models.SentTransferDetail) {
+	transfer = &models.SentTransferDetail{}
+	key := utils.Sha3(tokenAddress[:], lockSecretHash[:]).String()
+	err := model.db.One("Key", key, transfer)
+	if err == storm.ErrNotFound {
+		return
+	}
+	if err != nil {
+		log.Error(fmt.Sprintf("UpdateMetadata err %s", err))
+		return
+	}
+	transfer.Metadata = metadata
+	err = model.db.Save(transfer)
+	if err != nil {
+		log.Error(fmt.Sprintf("UpdateMetadata err %s", err))
+		return
+	}
+	log.Trace(fmt.Sprintf("UpdateMetadata key=%s lockSecretHash=%s %v", key, lockSecretHash.String(), metadata))
+	return
+}
+
+// UpdateSentTransferDetailRoute :
+func (model *StormDB) UpdateSentTransferDetailRoute(tokenAddress common.Address, lockSecretHash common.Hash, route []common.Address) (transfer *models.SentTransferDetail) {
+	transfer = &models.SentTransferDetail{}
+	key := utils.Sha3(tokenAddress[:], lockSecretHash[:]).String()
+	err := model.db.One("Key", key, transfer)
+	if err == storm.ErrNotFound {
+		return
+	}
+	if err != nil {
+		log.Error(fmt.Sprintf("UpdateRoute err %s", err))
+		return
+	}
+	transfer.Route = route
+	err = model.db.Save(transfer)
+	if err != nil {
+		log.Error(fmt.Sprintf("UpdateRoute err %s", err))
+		return
+	}
+	log.Trace(fmt.Sprintf("UpdateRoute key=%s lockSecretHash=%s %s", key, lockSecretHash.String(), utils.StringInterface(route, 2)))
+	return
+}
+
 // GetSentTransferDetail :
 func (model *StormDB) GetSentTransferDetail(tokenAddress common.Address, lockSecretHash common.Hash) (*models.SentTransferDetail, error) {
 	var ts models.SentTransferDetail
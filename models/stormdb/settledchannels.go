@@ -3,21 +3,12 @@ package stormdb
 import (
 	"fmt"
 
-	"bytes"
-	"encoding/gob"
-
 	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
 	"github.com/SmartMeshFoundation/Photon/models"
 	"github.com/coreos/bbolt"
 	"github.com/ethereum/go-ethereum/common"
 )
 
-func unmarshal(b []byte, v interface{}) error {
-	r := bytes.NewReader(b)
-	dec := gob.NewDecoder(r)
-	return dec.Decode(v)
-}
-
 //NewSettledChannel save a settled channel to db
 func (model *StormDB) NewSettledChannel(c *channeltype.Serialization) error {
 	if c.State != channeltype.StateSettled {
@@ -32,13 +23,16 @@ func (model *StormDB) NewSettledChannel(c *channeltype.Serialization) error {
 func (model *StormDB) GetAllSettledChannel() (chs []*channeltype.Serialization, err error) {
 	err = model.db.Bolt.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(models.BucketSettledChannel))
+		if b == nil { //还没有 settle 过任何通道
+			return nil
+		}
 		err = b.ForEach(func(k, v []byte) error {
 			if string(k) == "__storm_metadata" {
 				return nil
 			}
 			//log.Trace(fmt.Sprintf("GetAllSettledChannel key=%s, value=%s\n", string(k), hex.EncodeToString(v)))
 			var c channeltype.Serialization
-			err = unmarshal(v, &c)
+			err = model.db.Codec().Unmarshal(v, &c)
 			if err != nil {
 				return err
 			}
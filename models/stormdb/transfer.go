@@ -15,7 +15,7 @@ import (
 )
 
 //NewReceivedTransfer save a new received transfer to db
-func (model *StormDB) NewReceivedTransfer(blockNumber int64, channelIdentifier common.Hash, openBlockNumber int64, tokenAddr, fromAddr common.Address, nonce uint64, amount *big.Int, lockSecretHash common.Hash, data string) *models.ReceivedTransfer {
+func (model *StormDB) NewReceivedTransfer(blockNumber int64, channelIdentifier common.Hash, openBlockNumber int64, tokenAddr, fromAddr common.Address, nonce uint64, amount *big.Int, lockSecretHash common.Hash, data string, outcome string) *models.ReceivedTransfer {
 	if lockSecretHash == utils.EmptyHash {
 		// direct transfer, use fakeLockSecretHash
 		lockSecretHash = utils.NewRandomHash()
@@ -32,6 +32,7 @@ func (model *StormDB) NewReceivedTransfer(blockNumber int64, channelIdentifier c
 		Amount:            amount,
 		Data:              data,
 		OpenBlockNumber:   openBlockNumber,
+		Outcome:           outcome,
 		TimeStamp:         time.Now().Unix(),
 	}
 	if ost, err := model.GetReceivedTransfer(key); err == nil {
@@ -54,6 +55,12 @@ func (model *StormDB) GetReceivedTransfer(key string) (*models.ReceivedTransfer,
 	return &r, err
 }
 
+//UpdateReceivedTransferPaymentPointer record the payment pointer a received transfer targeted
+func (model *StormDB) UpdateReceivedTransferPaymentPointer(key string, pointer string) error {
+	err := model.db.UpdateField(&models.ReceivedTransfer{Key: key}, "PaymentPointer", pointer)
+	return models.GeneratDBError(err)
+}
+
 //GetReceivedTransferList returns the received transfer between from and to blocks
 func (model *StormDB) GetReceivedTransferList(tokenAddress common.Address, fromBlock, toBlock, fromTime, toTime int64) (transfers []*models.ReceivedTransfer, err error) {
 	var selectList []q.Matcher
@@ -0,0 +1,97 @@
+package stormdb
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/encoding"
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/asdine/storm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//NewTransferMessage save a signed message sent or received during transfer `lockSecretHash`,the same message is saved only once
+func (model *StormDB) NewTransferMessage(lockSecretHash, channelIdentifier common.Hash, msg encoding.SignedMessager, isSent bool) {
+	key := utils.Sha3(msg.Pack())
+	var old models.TransferMessage
+	if model.db.One("Key", key[:], &old) == nil {
+		return
+	}
+	tm := &models.TransferMessage{
+		Key:               key[:],
+		LockSecretHash:    lockSecretHash[:],
+		ChannelIdentifier: channelIdentifier[:],
+		Message:           msg,
+		IsSent:            isSent,
+		Time:              time.Now(),
+	}
+	err := model.db.Save(tm)
+	if err != nil {
+		log.Error(fmt.Sprintf("NewTransferMessage err %s", err))
+	}
+}
+
+//GetTransferMessages returns all signed messages of transfer `lockSecretHash` in the order they are saved
+func (model *StormDB) GetTransferMessages(lockSecretHash common.Hash) (list []*models.TransferMessage, err error) {
+	err = model.db.Find("LockSecretHash", lockSecretHash[:], &list)
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	if err != nil {
+		err = models.GeneratDBError(err)
+		return
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Time.Before(list[j].Time)
+	})
+	return
+}
+
+/*
+RemoveTransferMessagesOnChannel 通道 settle 以后删除通道上的消息,
+如果一个锁在其他通道上已经没有消息了,同时删除这个锁的 SecretRequest 和 RevealSecret
+*/
+func (model *StormDB) RemoveTransferMessagesOnChannel(channelIdentifier common.Hash) {
+	var list []*models.TransferMessage
+	err := model.db.Find("ChannelIdentifier", channelIdentifier[:], &list)
+	if err != nil {
+		if err != storm.ErrNotFound {
+			log.Error(fmt.Sprintf("RemoveTransferMessagesOnChannel err %s", err))
+		}
+		return
+	}
+	locks := make(map[common.Hash]bool)
+	for _, tm := range list {
+		locks[common.BytesToHash(tm.LockSecretHash)] = true
+		err = model.db.DeleteStruct(tm)
+		if err != nil {
+			log.Error(fmt.Sprintf("RemoveTransferMessagesOnChannel err %s", err))
+		}
+	}
+	for lockSecretHash := range locks {
+		var left []*models.TransferMessage
+		err = model.db.Find("LockSecretHash", lockSecretHash[:], &left)
+		if err != nil {
+			continue
+		}
+		hasChannelMessage := false
+		for _, tm := range left {
+			if common.BytesToHash(tm.ChannelIdentifier) != utils.EmptyHash {
+				hasChannelMessage = true
+				break
+			}
+		}
+		if hasChannelMessage {
+			continue
+		}
+		for _, tm := range left {
+			err = model.db.DeleteStruct(tm)
+			if err != nil {
+				log.Error(fmt.Sprintf("RemoveTransferMessagesOnChannel err %s", err))
+			}
+		}
+	}
+}
@@ -20,6 +20,8 @@ type ReceivedTransfer struct {
 	Amount            *big.Int       `json:"amount"`
 	Data              string         `json:"data"`
 	TimeStamp         int64          `json:"time_stamp" storm:"index"`
+	PaymentPointer    string         `json:"payment_pointer,omitempty"` //Data 是我登记过的 payment pointer
+	Outcome           string         `json:"outcome,omitempty"`         //交易是链下完成还是链上注册密码以后才完成,见 transfer.SettlementOutcome
 }
 
 func init() {
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"encoding/gob"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/encoding"
+)
+
+/*
+TransferMessage 交易过程中收发的签名消息,包括 MediatedTransfer,SecretRequest,RevealSecret 以及 Unlock,
+发生争议时可以作为证据,也可以用来还原交易过程.
+在所属通道 settle 以后删除,SecretRequest 和 RevealSecret 不属于任何通道,随同一个锁在通道上的消息一起删除.
+*/
+type TransferMessage struct {
+	Key               []byte `storm:"id"` //utils.Sha3(msg.Pack())
+	LockSecretHash    []byte `storm:"index"`
+	ChannelIdentifier []byte `storm:"index"` //SecretRequest,RevealSecret 为空
+	Message           encoding.SignedMessager
+	IsSent            bool //true 我发出的,false 我收到的
+	Time              time.Time
+}
+
+func init() {
+	gob.Register(&TransferMessage{})
+}
@@ -40,6 +40,10 @@ const (
 // FakeTXAmount 虚构tx的amount
 const FakeTXAmount = 1
 
+//TXInfoCb notify when a tx is packed successfully or failed
+//return true to remove this callback, all the callback should never block.
+type TXInfoCb func(txInfo *TXInfo) (remove bool)
+
 // TXInfo 记录已经提交到公链节点的tx信息
 type TXInfo struct {
 	TXHash            common.Hash    `json:"tx_hash"`
@@ -17,3 +17,8 @@ type UnlockToSend struct {
 func init() {
 	gob.Register(&UnlockToSend{})
 }
+
+// GetKey : impl dao.KeyGetter
+func (u *UnlockToSend) GetKey() []byte {
+	return u.Key
+}
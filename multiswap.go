@@ -0,0 +1,98 @@
+package photon
+
+import (
+	"math/big"
+
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//SwapLeg multi-leg swap 中的一段,From 向 To 支付 Amount 个 Token
+type SwapLeg struct {
+	From   common.Address `json:"from"`
+	To     common.Address `json:"to"`
+	Token  common.Address `json:"token"`
+	Amount *big.Int       `json:"amount"`
+}
+
+/*
+validateSwapLegs 所有的 leg 必须首尾相连组成一个环: legs[i].To == legs[i+1].From,最后一段付给 legs[0].From,
+每个参与者只出现一次,并且收到和付出的不能是同一种 token,否则对这个参与者来说只是一次中转.
+*/
+func validateSwapLegs(legs []*SwapLeg) error {
+	if len(legs) < 2 {
+		return rerr.ErrArgumentError.Append("swap needs at least two legs")
+	}
+	participants := make(map[common.Address]bool)
+	for i, leg := range legs {
+		next := legs[(i+1)%len(legs)]
+		if leg.Amount == nil || leg.Amount.Cmp(utils.BigInt0) <= 0 {
+			return rerr.ErrArgumentError.Printf("leg %d amount must be positive", i)
+		}
+		if leg.From == leg.To {
+			return rerr.ErrArgumentError.Printf("leg %d pays to itself", i)
+		}
+		if leg.To != next.From {
+			return rerr.ErrArgumentError.Printf("leg %d pays to %s,but next leg is paid by %s", i, utils.APex2(leg.To), utils.APex2(next.From))
+		}
+		if leg.Token == next.Token {
+			return rerr.ErrArgumentError.Printf("%s receives and pays the same token", utils.APex2(leg.To))
+		}
+		if participants[leg.From] {
+			return rerr.ErrArgumentError.Printf("%s appears more than once", utils.APex2(leg.From))
+		}
+		participants[leg.From] = true
+	}
+	return nil
+}
+
+/*
+multiLegTokenSwap 根据我在环中的位置生成 TokenSwap:
+legs[0].From 是 maker,知道密码,发起第一段,收到最后一段以后才泄露密码;
+其他参与者是 taker,收到上一段以后发起下一段.
+*/
+func (rs *Service) multiLegTokenSwap(lockSecretHash, secret common.Hash, legs []*SwapLeg) (tokenSwap *TokenSwap, isMaker bool, err error) {
+	err = validateSwapLegs(legs)
+	if err != nil {
+		return
+	}
+	last := legs[len(legs)-1]
+	if legs[0].From == rs.NodeAddress {
+		if utils.ShaSecret(secret[:]) != lockSecretHash {
+			err = rerr.ErrArgumentError.Append("maker must provide the secret of lock secret hash")
+			return
+		}
+		tokenSwap = &TokenSwap{
+			LockSecretHash:   lockSecretHash,
+			Secret:           secret,
+			FromToken:        legs[0].Token,
+			FromAmount:       new(big.Int).Set(legs[0].Amount),
+			FromNodeAddress:  rs.NodeAddress,
+			ToToken:          last.Token,
+			ToAmount:         new(big.Int).Set(last.Amount),
+			ToNodeAddress:    legs[0].To,
+			LastPayerAddress: last.From,
+		}
+		return tokenSwap, true, nil
+	}
+	for i := 1; i < len(legs); i++ {
+		if legs[i].From != rs.NodeAddress {
+			continue
+		}
+		in, out := legs[i-1], legs[i]
+		tokenSwap = &TokenSwap{
+			LockSecretHash:  lockSecretHash,
+			FromToken:       in.Token,
+			FromAmount:      new(big.Int).Set(in.Amount),
+			FromNodeAddress: in.From,
+			ToToken:         out.Token,
+			ToAmount:        new(big.Int).Set(out.Amount),
+			ToNodeAddress:   rs.NodeAddress,
+			NextNodeAddress: out.To,
+		}
+		return tokenSwap, false, nil
+	}
+	err = rerr.ErrArgumentError.Printf("%s is not a participant of this swap", utils.APex2(rs.NodeAddress))
+	return
+}
@@ -0,0 +1,226 @@
+package photon
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/network"
+	"github.com/SmartMeshFoundation/Photon/network/graph"
+	"github.com/SmartMeshFoundation/Photon/network/helper"
+	"github.com/SmartMeshFoundation/Photon/network/netshare"
+	"github.com/SmartMeshFoundation/Photon/network/rpc"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/notify"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiLegTokenSwap(t *testing.T) {
+	a, b, c := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	t1, t2, t3 := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	legs := []*SwapLeg{
+		{From: a, To: b, Token: t1, Amount: big.NewInt(10)},
+		{From: b, To: c, Token: t2, Amount: big.NewInt(20)},
+		{From: c, To: a, Token: t3, Amount: big.NewInt(30)},
+	}
+	assert.Nil(t, validateSwapLegs(legs))
+	assert.NotNil(t, validateSwapLegs(legs[:1]))
+	//没有首尾相连
+	assert.NotNil(t, validateSwapLegs(legs[:2]))
+	//同一个节点收到和付出同一种 token
+	assert.NotNil(t, validateSwapLegs([]*SwapLeg{
+		{From: a, To: b, Token: t1, Amount: big.NewInt(10)},
+		{From: b, To: c, Token: t1, Amount: big.NewInt(20)},
+		{From: c, To: a, Token: t3, Amount: big.NewInt(30)},
+	}))
+
+	secret := utils.NewRandomHash()
+	lockSecretHash := utils.ShaSecret(secret[:])
+	rs := &Service{NodeAddress: a}
+	_, _, err := rs.multiLegTokenSwap(lockSecretHash, utils.NewRandomHash(), legs)
+	assert.NotNil(t, err, "maker must know the secret")
+	ts, isMaker, err := rs.multiLegTokenSwap(lockSecretHash, secret, legs)
+	assert.Nil(t, err)
+	assert.True(t, isMaker)
+	assert.Equal(t, t1, ts.FromToken)
+	assert.Equal(t, b, ts.ToNodeAddress)
+	assert.Equal(t, t3, ts.ToToken)
+	assert.EqualValues(t, 30, ts.ToAmount.Int64())
+	assert.Equal(t, c, ts.LastPayerAddress)
+
+	rs.NodeAddress = b
+	ts, isMaker, err = rs.multiLegTokenSwap(lockSecretHash, utils.EmptyHash, legs)
+	assert.Nil(t, err)
+	assert.False(t, isMaker)
+	assert.Equal(t, a, ts.FromNodeAddress)
+	assert.Equal(t, t1, ts.FromToken)
+	assert.Equal(t, t2, ts.ToToken)
+	assert.Equal(t, c, ts.takerPayee())
+
+	rs.NodeAddress = utils.NewRandomAddress()
+	_, _, err = rs.multiLegTokenSwap(lockSecretHash, utils.EmptyHash, legs)
+	assert.NotNil(t, err)
+}
+
+func TestTokenSwapTakerExpiration(t *testing.T) {
+	var blockNumber int64 = 100
+	revealTimeout := 10
+	//刚好安全: taker 的锁比 maker 早 revealTimeout 过期,距离当前块也多于 revealTimeout
+	exp, err := tokenSwapTakerExpiration(blockNumber+21, blockNumber, revealTimeout)
+	if assert.Nil(t, err) {
+		assert.EqualValues(t, blockNumber+11, exp)
+		assert.True(t, exp < blockNumber+21)
+	}
+	//差一个块就不安全了
+	_, err = tokenSwapTakerExpiration(blockNumber+20, blockNumber, revealTimeout)
+	assert.Error(t, err)
+	_, err = tokenSwapTakerExpiration(blockNumber+5, blockNumber, revealTimeout)
+	assert.Error(t, err)
+	//maker 的锁已经过期
+	_, err = tokenSwapTakerExpiration(blockNumber-1, blockNumber, revealTimeout)
+	assert.Error(t, err)
+	//revealTimeout 无效时 taker 的锁不会早于 maker 的锁过期
+	_, err = tokenSwapTakerExpiration(blockNumber+100, blockNumber, 0)
+	assert.Error(t, err)
+}
+
+/*
+newSwapTestNode 除了没有连接公链以外完整的节点,通过 MemoryNetwork 和其他节点交换消息.
+通道直接写入图和数据库,相当于已经在链上打开并且确认
+*/
+func newSwapTestNode(t *testing.T, memNetwork *network.MemoryNetwork, dir string) *Service {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := crypto.PubkeyToAddress(key.PublicKey)
+	config := &params.Config{
+		DataBasePath:  filepath.Join(dir, addr.String()+".db"),
+		RevealTimeout: 10,
+		SettleTimeout: 100,
+	}
+	chain := &rpc.BlockChainService{Client: &helper.SafeEthClient{Status: netshare.Connected}}
+	rs, err := NewPhotonService(chain, key, memNetwork.NewTransport(addr), config, notify.NewNotifyHandler(), codefortest.NewTestDB(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.IsChainEffective = true
+	rs.BlockNumber.Store(int64(50))
+	return rs
+}
+
+func openSwapTestChannel(t *testing.T, p1, p2 *Service, token common.Address, balance int64) {
+	id := &contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash(), OpenBlockNumber: 1}
+	for _, p := range []struct{ our, partner *Service }{{p1, p2}, {p2, p1}} {
+		rs := p.our
+		ourState := channel.NewChannelEndState(rs.NodeAddress, big.NewInt(balance), nil, mtree.NewMerkleTree(nil))
+		partnerState := channel.NewChannelEndState(p.partner.NodeAddress, big.NewInt(balance), nil, mtree.NewMerkleTree(nil))
+		externState := channel.NewChannelExternalState(rs.registerChannelForHashlock, nil, id, rs.PrivateKey, rs.Chain.Client, rs.dao, 0, rs.NodeAddress, p.partner.NodeAddress)
+		externState.SetSigner(rs.Signer)
+		ch, err := channel.NewChannel(ourState, partnerState, externState, token, id, rs.Config.RevealTimeout, rs.Config.SettleTimeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g := rs.Token2ChannelGraph[token]
+		if g == nil {
+			g = graph.NewChannelGraph(rs.NodeAddress, token, nil)
+			rs.Token2ChannelGraph[token] = g
+			rs.Token2TokenNetwork[token] = utils.EmptyAddress
+			assert.Nil(t, rs.dao.AddToken(token, utils.NewRandomAddress()))
+		}
+		assert.Nil(t, g.AddChannel(ch))
+		assert.Nil(t, rs.dao.NewChannel(channel.NewChannelSerialization(ch)))
+	}
+}
+
+//swapTestBalance 从数据库中读取,不和主线程竞争
+func swapTestBalance(t *testing.T, rs *Service, token, partner common.Address) (our, theirs int64) {
+	c, err := rs.dao.GetChannel(token, partner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.OurBalance().Int64(), c.PartnerBalance().Int64()
+}
+
+//a,b,c 三方 swap: a 付给 b t1,b 付给 c t2,c 付给 a t3,最后所有的锁都解开
+func TestMultiLegTokenSwapEndToEnd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "multiswap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	memNetwork := network.NewMemoryNetwork()
+	a, b, c := newSwapTestNode(t, memNetwork, dir), newSwapTestNode(t, memNetwork, dir), newSwapTestNode(t, memNetwork, dir)
+	t1, t2, t3 := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	openSwapTestChannel(t, a, b, t1, 100)
+	openSwapTestChannel(t, b, c, t2, 100)
+	openSwapTestChannel(t, c, a, t3, 100)
+	for _, rs := range []*Service{a, b, c} {
+		rs.Protocol.Start(true)
+		go rs.loop()
+		defer func(rs *Service) {
+			close(rs.quitChan)
+			rs.Protocol.StopAndWait()
+			rs.dao.CloseDB()
+			assert.Nil(t, rs.FileLocker.Unlock())
+		}(rs)
+	}
+
+	legs := []*SwapLeg{
+		{From: a.NodeAddress, To: b.NodeAddress, Token: t1, Amount: big.NewInt(10)},
+		{From: b.NodeAddress, To: c.NodeAddress, Token: t2, Amount: big.NewInt(20)},
+		{From: c.NodeAddress, To: a.NodeAddress, Token: t3, Amount: big.NewInt(30)},
+	}
+	secret := utils.NewRandomHash()
+	lockSecretHash := utils.ShaSecret(secret[:])
+	//taker 先登记,然后 maker 发起第一段
+	assert.Nil(t, NewPhotonAPI(c).MultiLegTokenSwap(lockSecretHash, utils.EmptyHash, legs))
+	assert.Nil(t, NewPhotonAPI(b).MultiLegTokenSwap(lockSecretHash, utils.EmptyHash, legs))
+	assert.Nil(t, NewPhotonAPI(a).MultiLegTokenSwap(lockSecretHash, secret, legs))
+
+	type expect struct {
+		rs             *Service
+		token, partner common.Address
+		our, theirs    int64
+	}
+	expects := []expect{
+		{a, t1, b.NodeAddress, 90, 110},
+		{b, t1, a.NodeAddress, 110, 90},
+		{b, t2, c.NodeAddress, 80, 120},
+		{c, t2, b.NodeAddress, 120, 80},
+		{c, t3, a.NodeAddress, 70, 130},
+		{a, t3, c.NodeAddress, 130, 70},
+	}
+	done := func() bool {
+		for _, e := range expects {
+			our, theirs := swapTestBalance(t, e.rs, e.token, e.partner)
+			if our != e.our || theirs != e.theirs {
+				return false
+			}
+		}
+		return true
+	}
+	for i := 0; i < 100 && !done(); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	for _, e := range expects {
+		our, theirs := swapTestBalance(t, e.rs, e.token, e.partner)
+		assert.Equal(t, e.our, our, "%s on %s", utils.APex2(e.rs.NodeAddress), utils.APex2(e.token))
+		assert.Equal(t, e.theirs, theirs, "%s on %s", utils.APex2(e.rs.NodeAddress), utils.APex2(e.token))
+		c, err := e.rs.dao.GetChannel(e.token, e.partner)
+		if assert.Nil(t, err) {
+			assert.Empty(t, c.OurLeaves, "lock of %s on %s not unlocked", utils.APex2(e.rs.NodeAddress), utils.APex2(e.token))
+			assert.Empty(t, c.PartnerLeaves, "lock of %s's partner on %s not unlocked", utils.APex2(e.rs.NodeAddress), utils.APex2(e.token))
+		}
+	}
+}
@@ -22,6 +22,11 @@ type MatrixMixTransport struct {
 	protocol ProtocolReceiver
 }
 
+//AnnouncePresence 局域网不需要广播,只通过 matrix 广播
+func (t *MatrixMixTransport) AnnouncePresence() error {
+	return t.matirx.AnnouncePresence()
+}
+
 //NewMatrixMixTransporter create a MixTransport and discover
 func NewMatrixMixTransporter(name, host string, port int, key *ecdsa.PrivateKey, protocol ProtocolReceiver, policy Policier, deviceType string) (t *MatrixMixTransport, err error) {
 	t = &MatrixMixTransport{
@@ -0,0 +1,56 @@
+package network
+
+import (
+	"fmt"
+
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+SetAckFailureThreshold 发给同一个节点的连续 threshold 条消息都没有在第一个重发间隔内等到 ack,
+就认为这个节点不可达,GetNetworkStatus 返回离线,路由不再选择它,而不用等健康检查发现.
+同一条消息的多次重发只算一次.收到它的 ack 或者任何消息以后计数清零.0 表示不根据 ack 判断
+*/
+func (p *PhotonProtocol) SetAckFailureThreshold(threshold int) {
+	p.statusLock.Lock()
+	defer p.statusLock.Unlock()
+	p.ackFailureThreshold = threshold
+}
+
+//ackFailed 发给 addr 的一条消息在重发间隔内没有收到 ack,每条消息最多调用一次
+func (p *PhotonProtocol) ackFailed(addr common.Address) {
+	p.statusLock.Lock()
+	defer p.statusLock.Unlock()
+	if p.ackFailureThreshold <= 0 {
+		return
+	}
+	if p.ackFailures == nil {
+		p.ackFailures = make(map[common.Address]int)
+	}
+	p.ackFailures[addr]++
+	if p.ackFailures[addr] == p.ackFailureThreshold {
+		p.log.Warn(fmt.Sprintf("%d consecutive messages to %s not acked,mark it unreachable", p.ackFailureThreshold, utils.APex2(addr)))
+	}
+}
+
+//peerResponded 收到了 addr 的 ack 或者消息,说明它可以到达
+func (p *PhotonProtocol) peerResponded(addr common.Address) {
+	p.statusLock.Lock()
+	defer p.statusLock.Unlock()
+	n, ok := p.ackFailures[addr]
+	if !ok {
+		return
+	}
+	delete(p.ackFailures, addr)
+	if p.ackFailureThreshold > 0 && n >= p.ackFailureThreshold {
+		p.log.Info(fmt.Sprintf("%s responded,mark it reachable again", utils.APex2(addr)))
+	}
+}
+
+//ackUnreachable 连续没有 ack 的次数达到了阈值
+func (p *PhotonProtocol) ackUnreachable(addr common.Address) bool {
+	p.statusLock.RLock()
+	defer p.statusLock.RUnlock()
+	return p.ackFailureThreshold > 0 && p.ackFailures[addr] >= p.ackFailureThreshold
+}
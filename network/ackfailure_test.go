@@ -0,0 +1,65 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/encoding"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+//onlineTransport 所有节点都在线,发出的数据直接丢弃,所以永远收不到 ack
+type onlineTransport struct{}
+
+func (t *onlineTransport) Send(receiver common.Address, data []byte) error { return nil }
+func (t *onlineTransport) Start()                                          {}
+func (t *onlineTransport) Stop()                                           {}
+func (t *onlineTransport) StopAccepting()                                  {}
+func (t *onlineTransport) RegisterProtocol(protcol ProtocolReceiver)       {}
+func (t *onlineTransport) NodeStatus(addr common.Address) (deviceType string, isOnline bool) {
+	return DeviceTypeOther, true
+}
+
+func TestAckFailureThreshold(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewPhotonProtocol(&onlineTransport{}, key, &testChannelStatusGetter{})
+	addr := utils.NewRandomAddress()
+
+	//没有设置阈值时不影响在线状态
+	p.ackFailed(addr)
+	_, isOnline := p.GetNetworkStatus(addr)
+	assert.True(t, isOnline)
+
+	p.SetAckFailureThreshold(2)
+	policy := SendPolicy{RetryInterval: 10 * time.Millisecond, MaxAttempts: 3}
+	ping := encoding.NewPing(32)
+	assert.Nil(t, ping.Sign(key, ping))
+	err = p.SendAndWaitWithPolicy(addr, ping, time.Minute, policy)
+	assert.Equal(t, errRetryExhausted, err)
+	//同一条消息的多次重发只算一次
+	_, isOnline = p.GetNetworkStatus(addr)
+	assert.True(t, isOnline)
+	ping = encoding.NewPing(33)
+	assert.Nil(t, ping.Sign(key, ping))
+	err = p.SendAndWaitWithPolicy(addr, ping, time.Minute, policy)
+	assert.Equal(t, errRetryExhausted, err)
+	_, isOnline = p.GetNetworkStatus(addr)
+	assert.False(t, isOnline)
+	//只影响没有 ack 的节点
+	_, isOnline = p.GetNetworkStatus(utils.NewRandomAddress())
+	assert.True(t, isOnline)
+
+	//收到对方的消息以后恢复
+	p.peerResponded(addr)
+	_, isOnline = p.GetNetworkStatus(addr)
+	assert.True(t, isOnline)
+	p.ackFailed(addr)
+	_, isOnline = p.GetNetworkStatus(addr)
+	assert.True(t, isOnline)
+}
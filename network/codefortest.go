@@ -2,7 +2,9 @@ package network
 
 import (
 	"crypto/ecdsa"
+	"errors"
 	"math/rand"
+	"sync"
 	"time"
 
 	"fmt"
@@ -113,3 +115,95 @@ func SubscribeNeighbor(p *PhotonProtocol, addr common.Address) error {
 	xt := p.Transport.(*XMPPTransport)
 	return xt.conn.SubscribeNeighbour(addr)
 }
+
+/*
+MemoryNetwork test only,在同一个进程中直接投递消息,用于测试多个节点之间的完整交互.
+所有加入的节点都在线,发给同一个节点的数据按发送顺序投递
+*/
+type MemoryNetwork struct {
+	lock  sync.RWMutex
+	nodes map[common.Address]*MemoryTransport
+}
+
+//NewMemoryNetwork test only
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{
+		nodes: make(map[common.Address]*MemoryTransport),
+	}
+}
+
+//NewTransport create a transport for addr in this network
+func (n *MemoryNetwork) NewTransport(addr common.Address) *MemoryTransport {
+	t := &MemoryTransport{
+		network: n,
+		data:    make(chan []byte, 1000),
+		quit:    make(chan struct{}),
+	}
+	n.lock.Lock()
+	n.nodes[addr] = t
+	n.lock.Unlock()
+	return t
+}
+
+func (n *MemoryNetwork) get(addr common.Address) *MemoryTransport {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	return n.nodes[addr]
+}
+
+//MemoryTransport test only,see MemoryNetwork
+type MemoryTransport struct {
+	network  *MemoryNetwork
+	protocol ProtocolReceiver
+	data     chan []byte
+	quit     chan struct{}
+	stopOnce sync.Once
+}
+
+//Send a message to receiver
+func (t *MemoryTransport) Send(receiver common.Address, data []byte) error {
+	r := t.network.get(receiver)
+	if r == nil {
+		return errors.New("receiver not in memory network")
+	}
+	select {
+	case r.data <- append([]byte{}, data...):
+	case <-r.quit:
+	}
+	return nil
+}
+
+//Start delivering received data to protocol
+func (t *MemoryTransport) Start() {
+	go func() {
+		for {
+			select {
+			case data := <-t.data:
+				t.protocol.receive(data)
+			case <-t.quit:
+				return
+			}
+		}
+	}()
+}
+
+//Stop send and receive
+func (t *MemoryTransport) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.quit)
+	})
+}
+
+//StopAccepting stops receiving
+func (t *MemoryTransport) StopAccepting() {
+}
+
+//RegisterProtocol a receiver
+func (t *MemoryTransport) RegisterProtocol(protocol ProtocolReceiver) {
+	t.protocol = protocol
+}
+
+//NodeStatus nodes in the same network are always online
+func (t *MemoryTransport) NodeStatus(addr common.Address) (deviceType string, isOnline bool) {
+	return DeviceTypeOther, t.network.get(addr) != nil
+}
@@ -0,0 +1,134 @@
+package network
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/SmartMeshFoundation/Photon/encoding"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+compressedCmdID 压缩后的数据包以这个字节开头,后面是原始数据包 deflate 的结果.
+消息的 cmdid 都很小,不会和它冲突.
+旧版本节点无法解析这样的数据包,所以只有对方通过 advertiseCompression 表明自己能够解压以后才压缩,
+并且发送方配置了阈值才会压缩.
+echohash 始终按照原始数据包计算,压缩与否不影响 ack.
+*/
+const compressedCmdID = 0xff
+
+var errDecompressTooLarge = errors.New("decompressed packet larger than maximum size")
+
+//flate.Writer 创建代价很大(接近1M内存),重复使用
+var flateWriterPool = sync.Pool{
+	New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.BestCompression)
+		return w
+	},
+}
+
+/*
+compressPacket 数据包长度小于 threshold(比如 ping,ack)或者压缩以后没有变小,原样返回
+*/
+func compressPacket(data []byte, threshold int) []byte {
+	if threshold <= 0 || len(data) < threshold {
+		return data
+	}
+	compressed, err := deflatePacket(data)
+	if err != nil || len(compressed) >= len(data) {
+		return data
+	}
+	return compressed
+}
+
+//deflatePacket 不管压缩以后是否变小都压缩
+func deflatePacket(data []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	buf.WriteByte(compressedCmdID)
+	w := flateWriterPool.Get().(*flate.Writer)
+	defer flateWriterPool.Put(w)
+	w.Reset(buf)
+	_, err := w.Write(data)
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func isCompressedPacket(data []byte) bool {
+	return len(data) > 0 && data[0] == compressedCmdID
+}
+
+/*
+decompressPacket 不是压缩包的原样返回,解压以后超过 UDPMaxMessageSize 的视为非法
+*/
+func decompressPacket(data []byte) ([]byte, error) {
+	if !isCompressedPacket(data) {
+		return data, nil
+	}
+	r := flate.NewReader(bytes.NewReader(data[1:]))
+	defer r.Close()
+	out, err := ioutil.ReadAll(io.LimitReader(r, params.UDPMaxMessageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > params.UDPMaxMessageSize {
+		return nil, errDecompressTooLarge
+	}
+	return out, nil
+}
+
+/*
+peerCanDecompress 对方发送过压缩的数据包,说明它能够解压
+*/
+func (p *PhotonProtocol) peerCanDecompress(addr common.Address) bool {
+	p.statusLock.RLock()
+	defer p.statusLock.RUnlock()
+	return p.compressPeers[addr]
+}
+
+/*
+advertiseCompression 收到 addr 的消息以后,记录它是否能够解压,
+并且第一次收到它的消息时发送一个压缩的 ping,告诉对方我能够解压.
+这个 ping 不等待 ack,旧版本节点无法解析,直接丢弃,不影响和它通信.
+*/
+func (p *PhotonProtocol) advertiseCompression(addr common.Address, compressed bool) {
+	p.statusLock.Lock()
+	if p.compressPeers == nil {
+		p.compressPeers = make(map[common.Address]bool)
+		p.compressAdvertised = make(map[common.Address]bool)
+	}
+	if compressed {
+		p.compressPeers[addr] = true
+	}
+	//对方已经在压缩,说明它知道我能够解压
+	advertised := p.compressAdvertised[addr] || compressed
+	p.compressAdvertised[addr] = true
+	p.statusLock.Unlock()
+	if advertised {
+		return
+	}
+	ping := encoding.NewPing(utils.NewRandomInt64())
+	err := ping.SignBy(p.signer, ping)
+	if err != nil {
+		p.log.Warn(fmt.Sprintf("sign compression advertisement err %s", err))
+		return
+	}
+	data, err := deflatePacket(ping.Pack())
+	if err == nil {
+		err = p.Transport.Send(addr, data)
+	}
+	if err != nil {
+		p.log.Info(fmt.Sprintf("send compression advertisement to %s err %s", utils.APex2(addr), err))
+	}
+}
@@ -0,0 +1,166 @@
+package network
+
+import (
+	"bytes"
+	"compress/flate"
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/encoding"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func newTestMediatedTransferData(t testing.TB) []byte {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bp := encoding.NewBalanceProof(1, big.NewInt(100), utils.EmptyHash, &contracts.ChannelUniqueID{
+		ChannelIdentifier: utils.NewRandomHash(),
+		OpenBlockNumber:   3,
+	})
+	lock := &mtree.Lock{
+		Expiration:     1000,
+		Amount:         big.NewInt(10),
+		LockSecretHash: utils.NewRandomHash(),
+	}
+	mtr := encoding.NewMediatedTransfer(bp, lock, utils.NewRandomAddress(), utils.NewRandomAddress(), big.NewInt(1), []common.Address{utils.NewRandomAddress()})
+	err = mtr.Sign(key, mtr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mtr.Pack()
+}
+
+func TestCompressPacket(t *testing.T) {
+	data := newTestMediatedTransferData(t)
+	if got := compressPacket(data, 0); !bytes.Equal(got, data) {
+		t.Error("threshold 0 should not compress")
+	}
+	if got := compressPacket(data, len(data)+1); !bytes.Equal(got, data) {
+		t.Error("packet smaller than threshold should not compress")
+	}
+	compressed := compressPacket(data, 1)
+	if compressed[0] != compressedCmdID || len(compressed) >= len(data) {
+		t.Errorf("packet should be compressed,before=%d,after=%d", len(data), len(compressed))
+	}
+	got, err := decompressPacket(compressed)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("decompress err %v", err)
+	}
+	got, err = decompressPacket(data)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Error("uncompressed packet should be returned as is")
+	}
+	//解压以后过大的包应该拒绝
+	buf := new(bytes.Buffer)
+	buf.WriteByte(compressedCmdID)
+	w, _ := flate.NewWriter(buf, flate.BestCompression)
+	w.Write(make([]byte, params.UDPMaxMessageSize*10))
+	w.Close()
+	_, err = decompressPacket(buf.Bytes())
+	if err != errDecompressTooLarge {
+		t.Errorf("expect errDecompressTooLarge,got %v", err)
+	}
+}
+
+//recordTransport 记录发出的数据包
+type recordTransport struct {
+	onlineTransport
+	sent [][]byte
+}
+
+func (t *recordTransport) Send(receiver common.Address, data []byte) error {
+	t.sent = append(t.sent, data)
+	return nil
+}
+
+func (t *recordTransport) take() (sent [][]byte) {
+	sent, t.sent = t.sent, nil
+	return
+}
+
+//只有对方表明自己能够解压以后才压缩,旧版本节点只会收到一个无法解析的 ping
+func TestCompressOnlyForCapablePeers(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	tr := &recordTransport{}
+	p := NewPhotonProtocol(tr, key, &testChannelStatusGetter{})
+	p.SetCompressThreshold(1)
+	partnerKey, _ := crypto.GenerateKey()
+	partner := crypto.PubkeyToAddress(partnerKey.PublicKey)
+	data := newTestMediatedTransferData(t)
+	newPing := func() []byte {
+		ping := encoding.NewPing(utils.NewRandomInt64())
+		ping.Sign(partnerKey, ping)
+		return ping.Pack()
+	}
+
+	//旧版本节点
+	p.sendRawWitNoAck(partner, data)
+	if sent := tr.take(); !bytes.Equal(sent[0], data) {
+		t.Error("should not compress before partner advertises")
+	}
+
+	//第一次收到对方的消息,除了 ack,还会告诉对方我能够解压
+	p.receiveInternal(newPing())
+	sent := tr.take()
+	if len(sent) != 2 || !isCompressedPacket(sent[0]) || isCompressedPacket(sent[1]) {
+		t.Fatalf("expect compression advertisement and ack,got %d packets", len(sent))
+	}
+	ad, err := decompressPacket(sent[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := encoding.DecodeMessage(ad, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ping, ok := m.(*encoding.Ping); !ok || ping.Sender != p.nodeAddr {
+		t.Errorf("advertisement should be a ping signed by me,got %s", m)
+	}
+	p.receiveInternal(newPing())
+	if sent = tr.take(); len(sent) != 1 {
+		t.Errorf("advertise only once,got %d packets", len(sent))
+	}
+	p.sendRawWitNoAck(partner, data)
+	if sent = tr.take(); !bytes.Equal(sent[0], data) {
+		t.Error("partner has not advertised,should not compress")
+	}
+
+	//对方发来压缩的数据包,说明它能够解压
+	compressed, _ := deflatePacket(newPing())
+	p.receiveInternal(compressed)
+	tr.take()
+	p.sendRawWitNoAck(partner, data)
+	if sent = tr.take(); !isCompressedPacket(sent[0]) {
+		t.Error("should compress for partner able to decompress")
+	}
+	p.sendRawWitNoAck(utils.NewRandomAddress(), data)
+	if sent = tr.take(); !bytes.Equal(sent[0], data) {
+		t.Error("should not compress for other nodes")
+	}
+}
+
+func BenchmarkCompressPacket(b *testing.B) {
+	data := newTestMediatedTransferData(b)
+	var compressed []byte
+	for i := 0; i < b.N; i++ {
+		compressed = compressPacket(data, 1)
+	}
+	b.ReportMetric(float64(len(compressed))/float64(len(data)), "ratio")
+}
+
+func BenchmarkDecompressPacket(b *testing.B) {
+	compressed := compressPacket(newTestMediatedTransferData(b), 1)
+	for i := 0; i < b.N; i++ {
+		_, err := decompressPacket(compressed)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -56,6 +56,10 @@ type ChannelGraph struct {
 	ChannelIdentifier2Channel map[common.Hash]*channel.Channel
 	address2index             map[common.Address]int
 	index2address             map[int]common.Address
+	/*
+		BalanceAwareRouting 跳数相同的路由中,优先选择交易以后第一跳通道更平衡的
+	*/
+	BalanceAwareRouting bool
 }
 
 /*
@@ -340,6 +344,7 @@ func (cg *ChannelGraph) GetBestRoutes(nodesStatus NodesStatusGetter, ourAddress
 		return
 	}
 	//log.Trace(fmt.Sprintf("nws=%s", utils.StringInterface(nws, 5)))
+	weights := make(map[*route.State]int64)
 	for _, nw := range nws {
 		c := cg.GetPartenerAddress2Channel(nw.neighbor)
 		if c == nil {
@@ -371,10 +376,89 @@ func (cg *ChannelGraph) GetBestRoutes(nodesStatus NodesStatusGetter, ourAddress
 			routeState.TotalFee = utils.BigInt0
 		}
 
+		weights[routeState] = nw.weight
 		onlineNodes = append(onlineNodes, routeState)
 	}
+	if cg.BalanceAwareRouting {
+		SortRoutesByBalance(onlineNodes, amount, func(r *route.State) *big.Int {
+			return big.NewInt(weights[r])
+		})
+	}
 	return
 }
+
+/*
+PostTransferImbalance 通过通道 c 转账 amount 以后,我方余额和对方余额之差的绝对值
+*/
+func PostTransferImbalance(c *channel.Channel, amount *big.Int) *big.Int {
+	our := new(big.Int).Sub(c.Balance(), amount)
+	partner := new(big.Int).Add(c.PartnerBalance(), amount)
+	return our.Sub(our, partner).Abs(our)
+}
+
+/*
+SortRoutesByBalance 只作为 tiebreaker 使用: routes 已经按照 rank 排好序,
+对于 rank 相同的相邻路由,交易以后第一跳通道更平衡的排在前面,
+这样可以减少以后 rebalance 的需要.
+*/
+func SortRoutesByBalance(routes []*route.State, amount *big.Int, rank func(r *route.State) *big.Int) {
+	imbalances := make(map[*route.State]*big.Int)
+	for _, r := range routes {
+		imbalances[r] = PostTransferImbalance(r.Channel(), amount)
+	}
+	for i := 0; i < len(routes); {
+		j := i + 1
+		for j < len(routes) && rank(routes[j]).Cmp(rank(routes[i])) == 0 {
+			j++
+		}
+		same := routes[i:j]
+		sort.SliceStable(same, func(a, b int) bool {
+			return imbalances[same[a]].Cmp(imbalances[same[b]]) < 0
+		})
+		i = j
+	}
+}
+/*
+ReachableNodes 从 sources 出发沿着通道能够到达的所有节点,包括 sources 本身,不包括我自己.
+exclude 中的节点可以被到达,但是不能作为中间节点继续往外走.
+sources 算作第一跳,最多走到第 maxHops 跳,maxHops<=0 表示不限制
+*/
+func (cg *ChannelGraph) ReachableNodes(sources []common.Address, exclude map[common.Address]bool, maxHops int) map[common.Address]bool {
+	reached := make(map[common.Address]bool)
+	var queue []int
+	for _, s := range sources {
+		index, ok := cg.address2index[s]
+		if !ok || s == cg.OurAddress || reached[s] {
+			continue
+		}
+		reached[s] = true
+		if !exclude[s] {
+			queue = append(queue, index)
+		}
+	}
+	for hops := 1; len(queue) > 0 && (maxHops <= 0 || hops < maxHops); hops++ {
+		var next []int
+		for _, index := range queue {
+			neighbors, err := cg.g.GetAllNeighbors(index)
+			if err != nil {
+				continue
+			}
+			for _, n := range neighbors {
+				addr := cg.index2address[n]
+				if addr == cg.OurAddress || reached[addr] {
+					continue
+				}
+				reached[addr] = true
+				if !exclude[addr] {
+					next = append(next, n)
+				}
+			}
+		}
+		queue = next
+	}
+	return reached
+}
+
 func (cg *ChannelGraph) haveNodes() bool {
 	return len(cg.g.Verticies) > 0
 }
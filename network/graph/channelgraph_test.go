@@ -0,0 +1,45 @@
+package graph
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/transfer/route"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRoute(t *testing.T, ourBalance, partnerBalance int64) *route.State {
+	ourState := channel.NewChannelEndState(utils.NewRandomAddress(), big.NewInt(ourBalance), nil, mtree.EmptyTree)
+	partnerState := channel.NewChannelEndState(utils.NewRandomAddress(), big.NewInt(partnerBalance), nil, mtree.EmptyTree)
+	c, err := channel.NewChannel(ourState, partnerState, &channel.ExternalState{}, utils.NewRandomAddress(),
+		&contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash()}, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return route.NewState(c, nil)
+}
+
+func TestPostTransferImbalance(t *testing.T) {
+	r := newTestRoute(t, 100, 20)
+	//80-40
+	assert.EqualValues(t, 40, PostTransferImbalance(r.Channel(), big.NewInt(20)).Int64())
+	//50-70
+	assert.EqualValues(t, 20, PostTransferImbalance(r.Channel(), big.NewInt(50)).Int64())
+}
+
+func TestSortRoutesByBalance(t *testing.T) {
+	worse := newTestRoute(t, 50, 50)  //0 -> 20
+	better := newTestRoute(t, 60, 30) //30 -> 10
+	best := newTestRoute(t, 70, 50)   //20 -> 0,但是 rank 更大
+	cheap := newTestRoute(t, 10, 200) //rank 更小,不管平衡与否都在最前面
+	rank := map[*route.State]int64{cheap: 1, worse: 2, better: 2, best: 3}
+	routes := []*route.State{cheap, worse, better, best}
+	SortRoutesByBalance(routes, big.NewInt(10), func(r *route.State) *big.Int {
+		return big.NewInt(rank[r])
+	})
+	assert.Equal(t, []*route.State{cheap, better, worse, best}, routes)
+}
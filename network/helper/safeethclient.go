@@ -4,6 +4,7 @@ import (
 	"context"
 	"math/big"
 	"sync"
+	"sync/atomic"
 
 	"github.com/SmartMeshFoundation/Photon/rerr"
 
@@ -32,6 +33,9 @@ type SafeEthClient struct {
 	Status     netshare.Status
 	StatusChan chan netshare.Status
 	quitChan   chan struct{}
+	//多个 photon 共享一个连接时,每个都需要收到连接状态的变化
+	statusSubscribers []chan netshare.Status
+	recovering        int32 //正在重连,atomic 访问
 }
 
 //NewSafeClient create safeclient
@@ -81,6 +85,43 @@ func (c *SafeEthClient) RegisterReConnectNotify(name string) <-chan struct{} {
 	c.ReConnect[name] = ch
 	return ch
 }
+
+/*
+SubscribeStatus 除了 StatusChan 以外再订阅一份连接状态的变化,供共享这个连接的其他使用者使用.
+订阅时如果已经连上,会立即收到一个 Connected
+*/
+func (c *SafeEthClient) SubscribeStatus() <-chan netshare.Status {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	ch := make(chan netshare.Status, 10)
+	if c.Status == netshare.Connected {
+		ch <- c.Status
+	}
+	c.statusSubscribers = append(c.statusSubscribers, ch)
+	return ch
+}
+
+/*
+UnsubscribeStatus 取消 SubscribeStatus 的订阅,ch 不会被关闭
+*/
+func (c *SafeEthClient) UnsubscribeStatus(ch <-chan netshare.Status) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for i, s := range c.statusSubscribers {
+		if s == ch {
+			c.statusSubscribers = append(c.statusSubscribers[:i], c.statusSubscribers[i+1:]...)
+			return
+		}
+	}
+}
+
+//StatusSubscribers 订阅连接状态的数量
+func (c *SafeEthClient) StatusSubscribers() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.statusSubscribers)
+}
+
 func (c *SafeEthClient) changeStatus(newStatus netshare.Status) {
 	log.Info(fmt.Sprintf("ethclient connection status changed from %d to %d", c.Status, newStatus))
 	c.Status = newStatus
@@ -89,10 +130,23 @@ func (c *SafeEthClient) changeStatus(newStatus netshare.Status) {
 	default:
 		//never block
 	}
+	c.lock.Lock()
+	for _, ch := range c.statusSubscribers {
+		select {
+		case ch <- newStatus:
+		default:
+			//never block
+		}
+	}
+	c.lock.Unlock()
 }
 
-//RecoverDisconnect try to reconnect with geth after a restart of geth
+//RecoverDisconnect try to reconnect with geth after a restart of geth,共享连接的使用者同时调用时只会有一个在重连
 func (c *SafeEthClient) RecoverDisconnect() {
+	if !atomic.CompareAndSwapInt32(&c.recovering, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&c.recovering, 0)
 	var err error
 	var client *ethclient.Client
 	c.changeStatus(netshare.Reconnecting)
@@ -244,6 +244,17 @@ func (m *MatrixTransport) RegisterProtocol(protcol ProtocolReceiver) {
 	m.protocol = protcol
 }
 
+//AnnouncePresence 重新告诉服务器我在线,服务器会通知订阅了我的节点
+func (m *MatrixTransport) AnnouncePresence() error {
+	if !m.running || m.matrixcli == nil {
+		return errors.New("matrix not running")
+	}
+	return m.matrixcli.SetPresenceState(&gomatrix.ReqPresenceUser{
+		Presence:  ONLINE,
+		StatusMsg: m.NodeDeviceType,
+	})
+}
+
 // Stop Does Stop need to destroy matrix resource ?
 func (m *MatrixTransport) Stop() {
 	if m.running == false {
@@ -29,6 +29,7 @@ import (
 
 var errTimeout = errors.New("wait timeout")
 var errExpired = errors.New("message expired")
+var errRetryExhausted = errors.New("no ack after max attempts")
 
 /*
 MessageToPhoton message and it's echo hash
@@ -48,6 +49,16 @@ type SentMessageState struct {
 	Message  encoding.Messager //message to send
 	EchoHash common.Hash       //message echo hash
 	Data     []byte            //packed message
+	Policy   SendPolicy        //retry policy of this message
+}
+
+/*
+SendPolicy 单条消息的重发策略,零值表示使用 PhotonProtocol 的默认策略.
+握手类的消息(比如合作关闭,取现)可以设置更短的重发间隔,后台消息可以设置更长的间隔.
+*/
+type SendPolicy struct {
+	RetryInterval time.Duration //第一次重发之前等待的时间,之后指数退避,0表示使用默认值
+	MaxAttempts   int           //最多发送几次,0表示一直重发直到收到ack
 }
 
 // PingSender do send ping task
@@ -119,6 +130,7 @@ every message needs a ack to make sure sent success.
 type PhotonProtocol struct {
 	Transport           Transporter
 	privKey             *ecdsa.PrivateKey
+	signer              utils.Signer //签名 ping,默认使用 privKey
 	nodeAddr            common.Address
 	SentHashesToChannel map[common.Hash]*SentMessageState
 	retryTimes          int
@@ -144,6 +156,16 @@ type PhotonProtocol struct {
 	receiveChan chan []byte
 	log         log.Logger
 	isReceiving bool
+	//不小于这个长度的数据包压缩以后再发送,0表示不压缩
+	compressThreshold int
+	//除了当前版本,还接受哪些消息版本,nil 表示接受所有能解码的版本
+	acceptVersion encoding.AcceptVersionFunc
+	//连续没有收到 ack 的次数,达到 ackFailureThreshold 认为对方不可达,由 statusLock 保护
+	ackFailureThreshold int
+	ackFailures         map[common.Address]int
+	//能够解压的节点,以及已经告诉过对方我能够解压的节点,由 statusLock 保护
+	compressPeers      map[common.Address]bool
+	compressAdvertised map[common.Address]bool
 }
 
 // NewPhotonProtocol create PhotonProtocol
@@ -164,6 +186,7 @@ func NewPhotonProtocol(transport Transporter, privKey *ecdsa.PrivateKey, channel
 		mapLock:                   sync.Mutex{},
 	}
 	rp.nodeAddr = crypto.PubkeyToAddress(privKey.PublicKey)
+	rp.signer = utils.NewPrivateKeySigner(privKey)
 	transport.RegisterProtocol(rp)
 	rp.log = log.New("name", utils.APex2(rp.nodeAddr))
 	return rp
@@ -180,6 +203,11 @@ func New(sample interface{}) interface{} {
 	return v
 }
 
+// SetSigner 私钥保存在外部时,使用 signer 签名,地址必须和节点地址一致
+func (p *PhotonProtocol) SetSigner(signer utils.Signer) {
+	p.signer = signer
+}
+
 // SetReceivedMessageSaver set db saver
 func (p *PhotonProtocol) SetReceivedMessageSaver(saver ReceivedMessageSaver) {
 	p.receivedMessageSaver = saver
@@ -200,13 +228,50 @@ func (p *PhotonProtocol) sendRawAck(receiver common.Address, data []byte) {
 	}
 }
 func (p *PhotonProtocol) sendRawWitNoAck(receiver common.Address, data []byte) error {
+	if p.compressThreshold > 0 && p.peerCanDecompress(receiver) {
+		data = compressPacket(data, p.compressThreshold)
+	}
 	return p.Transport.Send(receiver, data)
 }
 
+/*
+SetCompressThreshold 不小于 threshold 字节的消息压缩以后再发送,0 表示不压缩.
+只压缩发给能够解压的节点的消息,见 advertiseCompression.
+*/
+func (p *PhotonProtocol) SetCompressThreshold(threshold int) {
+	p.compressThreshold = threshold
+}
+
+/*
+SetAcceptedMessageVersions 除了当前版本以外,还接受哪些版本的消息,key 是消息名字,比如 MediatedTransfer.
+没有调用表示接受所有能解码的版本,没有列出的消息只接受当前版本.
+*/
+func (p *PhotonProtocol) SetAcceptedMessageVersions(versions map[string][]int16) error {
+	accepted := make(map[int16]map[int16]bool)
+	for name, vs := range versions {
+		cmdID, ok := encoding.MessageTypeByName(name)
+		if !ok {
+			return fmt.Errorf("unknown message type %s", name)
+		}
+		accepted[cmdID] = make(map[int16]bool)
+		for _, v := range vs {
+			if !encoding.HasVersionDecoder(cmdID, v) {
+				p.log.Warn(fmt.Sprintf("%s version %d cannot be decoded,ignore it", name, v))
+				continue
+			}
+			accepted[cmdID][v] = true
+		}
+	}
+	p.acceptVersion = func(cmdID, version int16) bool {
+		return accepted[cmdID][version]
+	}
+	return nil
+}
+
 // SendPing PingSender
 func (p *PhotonProtocol) SendPing(receiver common.Address) error {
 	ping := encoding.NewPing(utils.NewRandomInt64())
-	err := ping.Sign(p.privKey, ping)
+	err := ping.SignBy(p.signer, ping)
 	if err != nil {
 		return err
 	}
@@ -311,10 +376,17 @@ func (p *PhotonProtocol) sendMessage(receiver common.Address, msgState *SentMess
 	p.log.Trace(fmt.Sprintf("send to %s,msg=%s, echohash=%s",
 		utils.APex2(msgState.ReceiverAddress), msgState.Message,
 		utils.HPex(msgState.EchoHash)))
-	nextTimeout := timeoutExponentialBackoff(p.retryTimes, p.retryInterval, p.retryInterval*100)
+	retryInterval := p.retryInterval
+	if msgState.Policy.RetryInterval > 0 {
+		retryInterval = msgState.Policy.RetryInterval
+	}
+	nextTimeout := timeoutExponentialBackoff(p.retryTimes, retryInterval, retryInterval*100)
+	attempts := 0
+	//一条消息不管重发多少次,只算一次没有 ack,否则重发间隔越短越容易被当作不可达
+	ackFailureCounted := false
 	for {
 		if !p.messageCanBeSent(msgState.Message) {
-			msgState.AsyncResult.Result <- errExpired
+			msgState.AsyncResult.SetResult(errExpired)
 			p.mapLock.Lock()
 			delete(p.SentHashesToChannel, msgState.EchoHash)
 			p.mapLock.Unlock()
@@ -324,22 +396,44 @@ func (p *PhotonProtocol) sendMessage(receiver common.Address, msgState *SentMess
 		if err != nil {
 			p.log.Info(fmt.Sprintf("sendRawWitNoAck msg echoHash=%s error %s", utils.HPex(msgState.EchoHash), err.Error()))
 		}
+		attempts++
 		timeout := time.After(nextTimeout())
 		var ok bool
 		select {
-		case _, ok = <-msgState.AckChannel:
-			if ok {
+		case err, ok = <-msgState.AckChannel:
+			if ok && err != nil {
+				//对方明确拒绝了这条消息,重发也没有用
+				p.log.Error(fmt.Sprintf("msg=%s EchoHash=%s, rejected by %s: %s", encoding.MessageType(msgState.Message.Cmd()), utils.HPex(msgState.EchoHash), utils.APex2(receiver), err))
+				msgState.AsyncResult.SetResult(err)
+				p.mapLock.Lock()
+				delete(p.SentHashesToChannel, msgState.EchoHash)
+				p.mapLock.Unlock()
+				p.peerResponded(receiver)
+			} else if ok {
 				p.log.Trace(fmt.Sprintf("msg=%s EchoHash=%s, sent success", encoding.MessageType(msgState.Message.Cmd()), utils.HPex(msgState.EchoHash)))
-				msgState.AsyncResult.Result <- nil
+				msgState.AsyncResult.SetResult(nil)
 				p.mapLock.Lock()
 				delete(p.SentHashesToChannel, msgState.EchoHash)
 				p.mapLock.Unlock()
+				p.peerResponded(receiver)
 
 			} else {
 				p.log.Info(fmt.Sprintf("sendMessage EchoHash=%s stop retry, because of chan closed", utils.HPex(msgState.EchoHash)))
 			}
 			return
 		case <-timeout: //retry
+			if !ackFailureCounted {
+				ackFailureCounted = true
+				p.ackFailed(receiver)
+			}
+			if msgState.Policy.MaxAttempts > 0 && attempts >= msgState.Policy.MaxAttempts {
+				p.log.Info(fmt.Sprintf("msg=%s EchoHash=%s, give up after %d attempts", encoding.MessageType(msgState.Message.Cmd()), utils.HPex(msgState.EchoHash), attempts))
+				msgState.AsyncResult.SetResult(errRetryExhausted)
+				p.mapLock.Lock()
+				delete(p.SentHashesToChannel, msgState.EchoHash)
+				p.mapLock.Unlock()
+				return
+			}
 			// 如果是matrix且对方不在线,挂起并等待唤醒
 			_, isOnline := p.Transport.NodeStatus(receiver)
 			transport, ok1 := p.Transport.(*MatrixMixTransport)
@@ -393,7 +487,7 @@ func getMessageChannelIdentifier(msg encoding.Messager) (common.Hash, int64) {
 	msg must be sent success.
 */
 func (p *PhotonProtocol) sendWithResult(receiver common.Address,
-	msg encoding.Messager) (result *utils.AsyncResult) {
+	msg encoding.Messager, policy SendPolicy) (result *utils.AsyncResult) {
 	//no more message...
 	if p.onStop {
 		return utils.NewAsyncResult()
@@ -423,6 +517,7 @@ because they sending same SecretRequest and RevealSecret,msg=%s`, msg))
 		Message:         msg,
 		Data:            data,
 		EchoHash:        echohash,
+		Policy:          policy,
 	}
 	p.SentHashesToChannel[echohash] = msgState
 	p.mapLock.Unlock()
@@ -434,8 +529,13 @@ because they sending same SecretRequest and RevealSecret,msg=%s`, msg))
 
 // SendAndWait send this packet and wait ack until timeout
 func (p *PhotonProtocol) SendAndWait(receiver common.Address, msg encoding.Messager, timeout time.Duration) error {
+	return p.SendAndWaitWithPolicy(receiver, msg, timeout, SendPolicy{})
+}
+
+// SendAndWaitWithPolicy same as SendAndWait, but retry this message according to `policy`
+func (p *PhotonProtocol) SendAndWaitWithPolicy(receiver common.Address, msg encoding.Messager, timeout time.Duration, policy SendPolicy) error {
 	var err error
-	result := p.sendWithResult(receiver, msg)
+	result := p.sendWithResult(receiver, msg, policy)
 	timeoutCh := time.After(timeout)
 	select {
 	case err = <-result.Result:
@@ -449,7 +549,12 @@ func (p *PhotonProtocol) SendAndWait(receiver common.Address, msg encoding.Messa
 
 // SendAsync send a message asynchronize ,notify by `AsyncResult`
 func (p *PhotonProtocol) SendAsync(receiver common.Address, msg encoding.Messager) *utils.AsyncResult {
-	return p.sendWithResult(receiver, msg)
+	return p.sendWithResult(receiver, msg, SendPolicy{})
+}
+
+// SendAsyncWithPolicy same as SendAsync, but retry this message according to `policy`
+func (p *PhotonProtocol) SendAsyncWithPolicy(receiver common.Address, msg encoding.Messager, policy SendPolicy) *utils.AsyncResult {
+	return p.sendWithResult(receiver, msg, policy)
 }
 
 // CreateAck creat a ack message,
@@ -457,9 +562,16 @@ func (p *PhotonProtocol) CreateAck(echohash common.Hash) *encoding.Ack {
 	return encoding.NewAck(p.nodeAddr, echohash)
 }
 
-// GetNetworkStatus return `addr` node's network status
+/*
+GetNetworkStatus return `addr` node's network status,
+transport 认为在线但是连续多条消息没有 ack 的节点也当作离线,见 SetAckFailureThreshold
+*/
 func (p *PhotonProtocol) GetNetworkStatus(addr common.Address) (deviceType string, isOnline bool) {
-	return p.Transport.NodeStatus(addr)
+	deviceType, isOnline = p.Transport.NodeStatus(addr)
+	if isOnline && p.ackUnreachable(addr) {
+		isOnline = false
+	}
+	return
 }
 
 func (p *PhotonProtocol) receive(data []byte) {
@@ -494,16 +606,24 @@ func (p *PhotonProtocol) receiveInternal(data []byte) {
 		p.log.Info("receive message,but protocol already stopped")
 		return
 	}
-	cmdid := int(data[0])
-	messager, ok := encoding.MessageMap[cmdid]
-	if !ok {
-		p.log.Warn("receive unknown message:", hex.Dump(data))
+	compressed := isCompressedPacket(data)
+	data, err := decompressPacket(data)
+	if err != nil {
+		p.log.Warn(fmt.Sprintf("decompress packet error : %s", err))
+		return
+	}
+	if len(data) == 0 {
 		return
 	}
-	messager = New(messager).(encoding.Messager)
-	err := messager.UnPack(data)
+	messager, err := encoding.DecodeMessage(data, p.acceptVersion)
 	if err != nil {
-		p.log.Warn(fmt.Sprintf("message unpack error : %s", err))
+		if verr, ok := err.(*encoding.IncompatibleVersionError); ok {
+			//对方需要升级,或者我需要升级,不能回复 ack,通知对方停止重发
+			p.log.Error(fmt.Sprintf("receive message with %s,message dropped", err))
+			p.notifyIncompatibleVersion(data, verr)
+		} else {
+			p.log.Warn(fmt.Sprintf("message unpack error : %s,data=%s", err, hex.Dump(data)))
+		}
 		return
 	}
 	echohash := utils.Sha3(data, p.nodeAddr[:])
@@ -539,7 +659,12 @@ func (p *PhotonProtocol) receiveInternal(data []byte) {
 			p.log.Warn("message should be signed except for ack")
 			return
 		}
-		if messager.Cmd() == encoding.PingCmdID { //send ack
+		p.peerResponded(signedMessager.GetSender())
+		p.advertiseCompression(signedMessager.GetSender(), compressed)
+		if en, ok := messager.(*encoding.ErrorNotify); ok && en.ErrorNotifyType == encoding.IncompatibleVersionErrorNotify {
+			p.receiveIncompatibleVersion(en)
+			p.sendAck(en.Sender, p.CreateAck(echohash))
+		} else if messager.Cmd() == encoding.PingCmdID { //send ack
 			p.sendAck(signedMessager.GetSender(), p.CreateAck(echohash))
 		} else {
 			//send message to photon ,and wait result
@@ -567,6 +692,49 @@ func (p *PhotonProtocol) receiveInternal(data []byte) {
 
 }
 
+/*
+notifyIncompatibleVersion 告诉发送方我无法处理这个版本的消息,
+不能给 ack,否则对方会认为我已经处理了这条消息,比如一个 MediatedTransfer.
+*/
+func (p *PhotonProtocol) notifyIncompatibleVersion(data []byte, verr *encoding.IncompatibleVersionError) {
+	sender, err := encoding.MessageSender(data)
+	if err != nil {
+		p.log.Warn(fmt.Sprintf("cannot recover sender of incompatible message: %s", err))
+		return
+	}
+	echohash := utils.Sha3(data, p.nodeAddr[:])
+	en := encoding.NewIncompatibleVersionErrorNotify(echohash, verr)
+	err = en.SignBy(p.signer, en)
+	if err != nil {
+		p.log.Error(fmt.Sprintf("sign IncompatibleVersionErrorNotify err %s", err))
+		return
+	}
+	//不需要 ack,对方每次重发我都会回复
+	err = p.sendRawWitNoAck(sender, en.Pack())
+	if err != nil {
+		p.log.Warn(fmt.Sprintf("send IncompatibleVersionErrorNotify to %s err %s", utils.APex2(sender), err))
+	}
+}
+
+//receiveIncompatibleVersion 对方无法处理我发出的消息,停止重发并把错误返回给发送者
+func (p *PhotonProtocol) receiveIncompatibleVersion(en *encoding.ErrorNotify) {
+	echohash, verr, err := encoding.ParseIncompatibleVersionErrorNotify(en)
+	if err != nil {
+		p.log.Warn(err.Error())
+		return
+	}
+	p.mapLock.Lock()
+	defer p.mapLock.Unlock()
+	msgState, ok := p.SentHashesToChannel[echohash]
+	if !ok || msgState.Success || msgState.ReceiverAddress != en.Sender {
+		p.log.Debug(fmt.Sprintf("receive IncompatibleVersionErrorNotify from %s for unknown message %s", utils.APex2(en.Sender), utils.HPex(echohash)))
+		return
+	}
+	msgState.AckChannel <- verr
+	close(msgState.AckChannel)
+	msgState.Success = true
+}
+
 // StopAndWait stop andf wait for clean.
 func (p *PhotonProtocol) StopAndWait() {
 	p.log.Info("PhotonProtocol stop...")
@@ -22,6 +22,7 @@ import (
 	"github.com/SmartMeshFoundation/Photon/utils"
 	"github.com/davecgh/go-spew/spew"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
 )
 
 func init() {
@@ -207,3 +208,95 @@ func TestPhotonProtocolSendMediatedTransferExpired(t *testing.T) {
 	}
 
 }
+
+func TestPhotonProtocolSendWithPolicy(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	p1 := MakeTestPhotonProtocol("p1")
+	p1.Start(true)
+	defer p1.StopAndWait()
+	ping := encoding.NewPing(32)
+	ping.Sign(p1.privKey, ping)
+	policy := SendPolicy{
+		RetryInterval: 10 * time.Millisecond,
+		MaxAttempts:   3,
+	}
+	start := time.Now()
+	err := p1.SendAndWaitWithPolicy(utils.NewRandomAddress(), ping, time.Minute, policy)
+	if err != errRetryExhausted {
+		t.Errorf("should give up after %d attempts but get %v", policy.MaxAttempts, err)
+		return
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("should give up quickly with short retry interval, but takes %s", time.Since(start))
+	}
+}
+
+//版本不兼容的消息不给 ack,而是通知对方,对方停止重发并得到 IncompatibleVersionError
+func TestIncompatibleVersionNotify(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	tr := &recordTransport{}
+	p := NewPhotonProtocol(tr, key, &testChannelStatusGetter{})
+	partnerKey, _ := crypto.GenerateKey()
+	partner := crypto.PubkeyToAddress(partnerKey.PublicKey)
+
+	//旧版本的 MediatedTransfer,低于我支持的最低版本
+	bp := encoding.NewBalanceProof(1, big.NewInt(100), utils.EmptyHash, &contracts.ChannelUniqueID{
+		ChannelIdentifier: utils.NewRandomHash(),
+		OpenBlockNumber:   3,
+	})
+	lock := &mtree.Lock{
+		Expiration:     1000,
+		Amount:         big.NewInt(10),
+		LockSecretHash: utils.NewRandomHash(),
+	}
+	mtr := encoding.NewMediatedTransfer(bp, lock, utils.NewRandomAddress(), partner, big.NewInt(1), nil)
+	mtr.Version = encoding.CurrentVersion(encoding.MediatedTransferCmdID) - 1
+	err := mtr.Sign(partnerKey, mtr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := mtr.Pack()
+	sender, err := encoding.MessageSender(data)
+	if err != nil || sender != partner {
+		t.Fatalf("recover sender err %v,sender=%s", err, sender.String())
+	}
+	p.receiveInternal(data)
+	sent := tr.take()
+	if len(sent) != 1 {
+		t.Fatalf("expect one notify,got %d packets", len(sent))
+	}
+	m, err := encoding.DecodeMessage(sent[0], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	en, ok := m.(*encoding.ErrorNotify)
+	if !ok || en.Sender != p.nodeAddr {
+		t.Fatalf("expect ErrorNotify signed by me,got %s", m)
+	}
+	echohash, verr, err := encoding.ParseIncompatibleVersionErrorNotify(en)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if echohash != utils.Sha3(data, p.nodeAddr[:]) || verr.CmdID != encoding.MediatedTransferCmdID || verr.Version != mtr.Version {
+		t.Errorf("wrong notify echohash=%s,err=%s", utils.HPex(echohash), verr)
+	}
+
+	//发送方收到通知以后停止重发
+	q := NewPhotonProtocol(&onlineTransport{}, partnerKey, &testChannelStatusGetter{})
+	ping := encoding.NewPing(32)
+	ping.Sign(partnerKey, ping)
+	result := q.SendAsync(p.nodeAddr, ping)
+	en = encoding.NewIncompatibleVersionErrorNotify(utils.Sha3(ping.Pack(), p.nodeAddr[:]), verr)
+	en.Sign(key, en)
+	q.receiveInternal(en.Pack())
+	select {
+	case err = <-result.Result:
+		if _, ok := err.(*encoding.IncompatibleVersionError); !ok {
+			t.Errorf("expect IncompatibleVersionError,got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("sender should stop after notify")
+	}
+}
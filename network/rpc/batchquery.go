@@ -0,0 +1,68 @@
+package rpc
+
+import (
+	"math/big"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//DefaultQueryConcurrency 批量查询合约时同时进行的 rpc 调用数量
+const DefaultQueryConcurrency = 8
+
+//ChannelParticipantQuery 查询 Participant 在和 Partner 的通道中的信息
+type ChannelParticipantQuery struct {
+	Participant common.Address
+	Partner     common.Address
+}
+
+//ChannelParticipantInfo GetChannelParticipantInfo 的结果,Err 不为 nil 时其他字段无效
+type ChannelParticipantInfo struct {
+	Deposit     *big.Int
+	BalanceHash common.Hash
+	Nonce       uint64
+	Err         error
+}
+
+/*
+GetChannelParticipantInfos 批量查询通道参与方信息,结果和 queries 一一对应.
+合约没有提供 multicall,所以用最多 concurrency 个并发的 rpc 调用代替逐个查询,
+通道很多时可以省掉大部分等待时间. concurrency<=0 时使用 DefaultQueryConcurrency.
+单个查询失败不影响其他查询,错误记录在对应结果的 Err 中
+*/
+func (t *TokenNetworkProxy) GetChannelParticipantInfos(queries []ChannelParticipantQuery, concurrency int) []*ChannelParticipantInfo {
+	infos := make([]*ChannelParticipantInfo, len(queries))
+	parallelDo(len(queries), concurrency, func(i int) {
+		info := new(ChannelParticipantInfo)
+		info.Deposit, info.BalanceHash, info.Nonce, info.Err = t.GetChannelParticipantInfo(queries[i].Participant, queries[i].Partner)
+		infos[i] = info
+	})
+	return infos
+}
+
+//parallelDo 用最多 concurrency 个 goroutine 对 0...n-1 分别调用 fn,全部完成以后返回
+func parallelDo(n, concurrency int, fn func(i int)) {
+	if concurrency <= 0 {
+		concurrency = DefaultQueryConcurrency
+	}
+	if concurrency > n {
+		concurrency = n
+	}
+	var wg sync.WaitGroup
+	next := int64(-1)
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= n {
+					return
+				}
+				fn(i)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,39 @@
+package rpc
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParallelDo(t *testing.T) {
+	const n = 50
+	var running, maxRunning int32
+	var lock sync.Mutex
+	done := make(map[int]int)
+	parallelDo(n, 4, func(i int) {
+		r := atomic.AddInt32(&running, 1)
+		lock.Lock()
+		if r > maxRunning {
+			maxRunning = r
+		}
+		done[i]++
+		lock.Unlock()
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&running, -1)
+	})
+	assert.Len(t, done, n)
+	for i := 0; i < n; i++ {
+		assert.Equal(t, 1, done[i], "index %d", i)
+	}
+	assert.True(t, maxRunning <= 4, "max running %d", maxRunning)
+	assert.True(t, maxRunning > 1, "queries should run in parallel")
+
+	//没有查询时直接返回
+	parallelDo(0, 0, func(i int) {
+		t.Errorf("unexpected call %d", i)
+	})
+}
@@ -71,6 +71,12 @@ type BlockChainService struct {
 	TXInfoDao         models.TXInfoDao
 	pendingTXInfoChan chan *models.TXInfo
 	quitChan          chan error
+	// 因为分叉重新监控的 tx,再次成功以后不再执行后续操作(比如 approve 以后的 deposit),避免重复执行
+	reorgedTXs     map[common.Hash]bool
+	reorgedTXsLock sync.Mutex
+	// 按交易类型覆盖自动估算的 gas limit
+	gasLimits     map[models.TXInfoType]uint64
+	gasLimitsLock sync.RWMutex
 }
 
 //NewBlockChainService create BlockChainService
@@ -86,6 +92,8 @@ func NewBlockChainService(privateKey *ecdsa.PrivateKey, registryAddress common.A
 		TXInfoDao:           txInfoDao,
 		pendingTXInfoChan:   make(chan *models.TXInfo, 10), // TODO 这里缓冲区多大合适???
 		quitChan:            make(chan error),
+		reorgedTXs:          make(map[common.Hash]bool),
+		gasLimits:           make(map[models.TXInfoType]uint64),
 	}
 	// remove gas limit config and let it calculate automatically
 	//bcs.Auth.GasLimit = uint64(params.GasLimit)
@@ -202,6 +210,26 @@ func (bcs *BlockChainService) RegisterPendingTXInfo(txInfo *models.TXInfo) {
 	bcs.pendingTXInfoChan <- txInfo
 }
 
+/*
+RegisterReorgedTXInfo 已经打包的 tx 因为公链分叉重新变成 pending 状态,重新监控它的执行结果,
+之前成功时已经执行过的后续操作不会再次执行.
+在主线程中调用,一次分叉可能涉及很多 tx,所以直接启动监控线程,不经过 pendingTXInfoChan,避免阻塞主线程
+*/
+func (bcs *BlockChainService) RegisterReorgedTXInfo(txInfo *models.TXInfo) {
+	bcs.reorgedTXsLock.Lock()
+	bcs.reorgedTXs[txInfo.TXHash] = true
+	bcs.reorgedTXsLock.Unlock()
+	go bcs.checkPendingTXDone(txInfo)
+}
+
+func (bcs *BlockChainService) takeReorgedTX(txHash common.Hash) bool {
+	bcs.reorgedTXsLock.Lock()
+	defer bcs.reorgedTXsLock.Unlock()
+	reorged := bcs.reorgedTXs[txHash]
+	delete(bcs.reorgedTXs, txHash)
+	return reorged
+}
+
 /*
 pending状态的tx执行结果监控线程,常驻线程,启动时启动
 */
@@ -239,6 +267,7 @@ func (bcs *BlockChainService) checkPendingTXDone(pendingTXInfo *models.TXInfo) {
 	if len(receipt.Logs) > 0 {
 		packBlockNumber = int64(receipt.Logs[0].BlockNumber)
 	}
+	reorged := bcs.takeReorgedTX(pendingTXInfo.TXHash)
 	var savedTxInfo *models.TXInfo
 	// 3. 处理
 	if receipt.Status != types.ReceiptStatusSuccessful {
@@ -262,6 +291,9 @@ func (bcs *BlockChainService) checkPendingTXDone(pendingTXInfo *models.TXInfo) {
 	}
 	// b. 通知上层
 	bcs.NotifyHandler.NotifyContractCallTXInfo(savedTxInfo)
+	if reorged {
+		return
+	}
 	// b. 部分tx需要在执行成功后进行后续处理
 	switch pendingTXInfo.Type {
 	case models.TXInfoTypeApproveDeposit: //approve成功之后需要继续调用deposit
@@ -280,7 +312,7 @@ func (bcs *BlockChainService) checkPendingTXDone(pendingTXInfo *models.TXInfo) {
 			break
 		}
 		//log.Info(fmt.Sprintf("RegistryProxy proxy=%s", utils.StringInterface(proxy, 5)))
-		tx, err := proxy.GetContract().Deposit(bcs.Auth, depositParams.TokenAddress, depositParams.ParticipantAddress, depositParams.PartnerAddress, depositParams.Amount, depositParams.SettleTimeout)
+		tx, err := proxy.GetContract().Deposit(bcs.authFor(models.TXInfoTypeDeposit), depositParams.TokenAddress, depositParams.ParticipantAddress, depositParams.PartnerAddress, depositParams.Amount, depositParams.SettleTimeout)
 		if err != nil {
 			log.Error(err.Error())
 			// 构造一个虚假的tx来保存这次错误的调用供前端查询和通知
@@ -53,6 +53,11 @@ func (dao *FakeTXINfoDao) GetTXInfoList(channelIdentifier common.Hash, openBlock
 	return
 }
 
+// GetTXInfoListPackedSince :
+func (dao *FakeTXINfoDao) GetTXInfoListPackedSince(blockNumber int64) (list []*models.TXInfo, err error) {
+	return
+}
+
 func init() {
 	if encoding.IsTest {
 		keybin, err := hex.DecodeString(os.Getenv("KEY1"))
@@ -14,3 +14,37 @@ type Charger interface {
 	//GetNodeChargeFee returns how many tokens charge for transfer 'amount' tokens on token who's address is tokenAddress.
 	GetNodeChargeFee(nodeAddress, tokenAddress common.Address, amount *big.Int) *big.Int
 }
+
+/*
+ChannelLiquidity is the state of the channel with the next hop when charging fee for a mediated transfer.
+*/
+type ChannelLiquidity struct {
+	ChannelIdentifier common.Hash
+	//OurBalance how many tokens we can transfer to the next hop right now
+	OurBalance *big.Int
+	//PartnerBalance how many tokens the next hop can transfer to us right now
+	PartnerBalance *big.Int
+	//Capacity total deposit of both participants
+	Capacity *big.Int
+}
+
+/*
+LiquidityCharger is an optional richer Charger,
+policy implements it can charge more on directions with low liquidity.
+*/
+type LiquidityCharger interface {
+	Charger
+	//GetNodeChargeFeeWithLiquidity is GetNodeChargeFee with the current liquidity of the channel with nodeAddress
+	GetNodeChargeFeeWithLiquidity(nodeAddress, tokenAddress common.Address, amount *big.Int, liquidity *ChannelLiquidity) *big.Int
+}
+
+/*
+ChargeFee calls GetNodeChargeFeeWithLiquidity when charger is a LiquidityCharger and liquidity is known,
+otherwise falls back to GetNodeChargeFee.
+*/
+func ChargeFee(charger Charger, nodeAddress, tokenAddress common.Address, amount *big.Int, liquidity *ChannelLiquidity) *big.Int {
+	if lc, ok := charger.(LiquidityCharger); ok && liquidity != nil {
+		return lc.GetNodeChargeFeeWithLiquidity(nodeAddress, tokenAddress, amount, liquidity)
+	}
+	return charger.GetNodeChargeFee(nodeAddress, tokenAddress, amount)
+}
@@ -0,0 +1,80 @@
+package rpc
+
+import (
+	"math/big"
+	"strings"
+
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts/test/tokens/smttoken"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+estimateGas 按照和真正交易一样的方式打包参数,然后调用 eth_estimateGas,
+如果交易会被合约拒绝,公链节点会直接返回错误
+*/
+func (bcs *BlockChainService) estimateGas(contract common.Address, contractABI string, value *big.Int, method string, args ...interface{}) (gas *big.Int, err error) {
+	parsed, err := abi.JSON(strings.NewReader(contractABI))
+	if err != nil {
+		return nil, rerr.ErrUnknown.AppendError(err)
+	}
+	input, err := parsed.Pack(method, args...)
+	if err != nil {
+		return nil, rerr.ErrArgumentError.AppendError(err)
+	}
+	msg := ethereum.CallMsg{
+		From:  bcs.NodeAddress,
+		To:    &contract,
+		Value: value,
+		Data:  input,
+	}
+	g, err := bcs.Client.EstimateGas(GetQueryConext(), msg)
+	if err != nil {
+		return nil, rerr.ErrTxWouldRevert.Printf("%s: %s", method, err)
+	}
+	return new(big.Int).SetUint64(g), nil
+}
+
+/*
+EstimateNewChannelAndDepositGas 估算创建通道并存款或者单纯存款需要的gas,
+只估算第一种尝试的方式,即token的fallback,对于需要approve的token结果会偏小
+*/
+func (t *TokenNetworkProxy) EstimateNewChannelAndDepositGas(participantAddress, partnerAddress common.Address, settleTimeout int, amount *big.Int) (gas *big.Int, err error) {
+	token, err := t.bcs.Token(t.token)
+	if err != nil {
+		return nil, rerr.ContractCallError(err)
+	}
+	name, err := token.Token.Name(nil)
+	if err != nil {
+		return nil, rerr.ContractCallError(err)
+	}
+	data := makeNewChannelAndDepositData(participantAddress, partnerAddress, settleTimeout)
+	if name == params.SMTTokenName {
+		return t.bcs.estimateGas(t.token, smttoken.SMTTokenABI, amount, "buyAndTransfer", data)
+	}
+	return t.bcs.estimateGas(t.token, contracts.TokenABI, nil, "transfer", t.Address, amount, data)
+}
+
+//EstimateCloseChannelGas gas needed by CloseChannel
+func (t *TokenNetworkProxy) EstimateCloseChannelGas(partnerAddr common.Address, transferAmount *big.Int, locksRoot common.Hash, nonce uint64, extraHash common.Hash, signature []byte) (gas *big.Int, err error) {
+	return t.bcs.estimateGas(t.Address, contracts.TokensNetworkABI, nil, "prepareSettle", t.token, partnerAddr, transferAmount, locksRoot, nonce, extraHash, signature)
+}
+
+//EstimateSettleChannelGas gas needed by SettleChannel
+func (t *TokenNetworkProxy) EstimateSettleChannelGas(p1Addr, p2Addr common.Address, p1Amount, p2Amount *big.Int, p1Locksroot, p2Locksroot common.Hash) (gas *big.Int, err error) {
+	return t.bcs.estimateGas(t.Address, contracts.TokensNetworkABI, nil, "settle", t.token, p1Addr, p1Amount, p1Locksroot, p2Addr, p2Amount, p2Locksroot)
+}
+
+//EstimateWithdrawGas gas needed by Withdraw
+func (t *TokenNetworkProxy) EstimateWithdrawGas(p1Addr, p2Addr common.Address, p1Balance, p1Withdraw *big.Int, p1Signature, p2Signature []byte) (gas *big.Int, err error) {
+	return t.bcs.estimateGas(t.Address, contracts.TokensNetworkABI, nil, "withDraw", t.token, p1Addr, p2Addr, p1Balance, p1Withdraw, p1Signature, p2Signature)
+}
+
+//EstimateCooperativeSettleGas gas needed by CooperativeSettle
+func (t *TokenNetworkProxy) EstimateCooperativeSettleGas(p1Addr, p2Addr common.Address, p1Balance, p2Balance *big.Int, p1Signature, p2Signatue []byte) (gas *big.Int, err error) {
+	return t.bcs.estimateGas(t.Address, contracts.TokensNetworkABI, nil, "cooperativeSettle", t.token, p1Addr, p1Balance, p2Addr, p2Balance, p1Signature, p2Signatue)
+}
@@ -0,0 +1,124 @@
+package rpc
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/network/helper"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/stretchr/testify/assert"
+)
+
+//FakeEstimateGasAPI 模拟公链节点的 eth_estimateGas,rpc.Server 只接受导出的类型
+type FakeEstimateGasAPI struct {
+	args EstimateGasArgs
+	gas  hexutil.Uint64
+	err  error
+}
+
+//EstimateGasArgs eth_estimateGas 的参数中需要检查的部分
+type EstimateGasArgs struct {
+	From common.Address
+	To   common.Address
+	Data hexutil.Bytes
+}
+
+//EstimateGas 记录参数并返回预设的结果
+func (api *FakeEstimateGasAPI) EstimateGas(args EstimateGasArgs) (hexutil.Uint64, error) {
+	api.args = args
+	return api.gas, api.err
+}
+
+func TestEstimateSettleChannelGas(t *testing.T) {
+	api := &FakeEstimateGasAPI{gas: 123456}
+	server := rpc.NewServer()
+	assert.Nil(t, server.RegisterName("eth", api))
+	defer server.Stop()
+	registry, token := utils.NewRandomAddress(), utils.NewRandomAddress()
+	bcs := &BlockChainService{
+		NodeAddress:   utils.NewRandomAddress(),
+		Client:        &helper.SafeEthClient{Client: ethclient.NewClient(rpc.DialInProc(server))},
+		RegistryProxy: &RegistryProxy{Address: registry},
+	}
+	tn, err := bcs.TokenNetwork(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p1, p2 := utils.NewRandomAddress(), utils.NewRandomAddress()
+	p1Locksroot, p2Locksroot := utils.NewRandomHash(), utils.NewRandomHash()
+	gas, err := tn.EstimateSettleChannelGas(p1, p2, big.NewInt(10), big.NewInt(20), p1Locksroot, p2Locksroot)
+	assert.Nil(t, err)
+	assert.EqualValues(t, big.NewInt(123456), gas)
+
+	//参数和真正调用 settle 时一样
+	parsed, err := abi.JSON(strings.NewReader(contracts.TokensNetworkABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := parsed.Pack("settle", token, p1, big.NewInt(10), p1Locksroot, p2, big.NewInt(20), p2Locksroot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualValues(t, data, api.args.Data)
+	assert.Equal(t, registry, api.args.To)
+	assert.Equal(t, bcs.NodeAddress, api.args.From)
+
+	//交易会失败时返回明确的错误
+	api.err = errors.New("gas required exceeds allowance or always failing transaction")
+	_, err = tn.EstimateCloseChannelGas(p2, big.NewInt(20), p2Locksroot, 3, utils.NewRandomHash(), make([]byte, 65))
+	if assert.Error(t, err) {
+		assert.Equal(t, rerr.ErrTxWouldRevert.ErrorCode, err.(rerr.StandardError).ErrorCode)
+	}
+}
+
+//withdraw 和 cooperativeSettle 的参数顺序和合约一致
+func TestEstimateWithdrawAndCooperativeSettleGas(t *testing.T) {
+	api := &FakeEstimateGasAPI{gas: 80000}
+	server := rpc.NewServer()
+	assert.Nil(t, server.RegisterName("eth", api))
+	defer server.Stop()
+	token := utils.NewRandomAddress()
+	bcs := &BlockChainService{
+		NodeAddress:   utils.NewRandomAddress(),
+		Client:        &helper.SafeEthClient{Client: ethclient.NewClient(rpc.DialInProc(server))},
+		RegistryProxy: &RegistryProxy{Address: utils.NewRandomAddress()},
+	}
+	tn, err := bcs.TokenNetwork(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := abi.JSON(strings.NewReader(contracts.TokensNetworkABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p1, p2 := utils.NewRandomAddress(), utils.NewRandomAddress()
+	sig1, sig2 := make([]byte, 65), make([]byte, 65)
+	sig1[0], sig2[0] = 1, 2
+
+	gas, err := tn.EstimateWithdrawGas(p1, p2, big.NewInt(30), big.NewInt(10), sig1, sig2)
+	assert.Nil(t, err)
+	assert.EqualValues(t, big.NewInt(80000), gas)
+	data, err := parsed.Pack("withDraw", token, p1, p2, big.NewInt(30), big.NewInt(10), sig1, sig2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualValues(t, data, api.args.Data)
+
+	gas, err = tn.EstimateCooperativeSettleGas(p1, p2, big.NewInt(30), big.NewInt(40), sig1, sig2)
+	assert.Nil(t, err)
+	assert.EqualValues(t, big.NewInt(80000), gas)
+	data, err = parsed.Pack("cooperativeSettle", token, p1, big.NewInt(30), p2, big.NewInt(40), sig1, sig2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualValues(t, data, api.args.Data)
+}
@@ -0,0 +1,127 @@
+package rpc
+
+import (
+	"fmt"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/network/netshare"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+)
+
+//gasLimitOperations 可以指定 gas limit 的交易类型,打开通道包含在 ChannelDeposit 中
+var gasLimitOperations = map[models.TXInfoType]bool{
+	models.TXInfoTypeDeposit:            true,
+	models.TXInfoTypeApproveDeposit:     true,
+	models.TXInfoTypeClose:              true,
+	models.TXInfoTypeSettle:             true,
+	models.TXInfoTypeCooperateSettle:    true,
+	models.TXInfoTypeWithdraw:           true,
+	models.TXInfoTypeUpdateBalanceProof: true,
+	models.TXInfoTypeUnlock:             true,
+	models.TXInfoTypePunish:             true,
+	models.TXInfoTypeRegisterSecret:     true,
+}
+
+/*
+checkGasLimit limit 为0表示自动估算,总是合法的;
+blockGasLimit 为0表示不知道当前块的 gas limit,不做检查
+*/
+func checkGasLimit(op models.TXInfoType, limit, blockGasLimit uint64) error {
+	if !gasLimitOperations[op] {
+		return rerr.ErrArgumentError.Printf("unknown operation %s", op)
+	}
+	if limit > 0 && blockGasLimit > 0 && limit > blockGasLimit {
+		return rerr.ErrArgumentError.Printf("gas limit %d of %s exceeds block gas limit %d", limit, op, blockGasLimit)
+	}
+	return nil
+}
+
+func (bcs *BlockChainService) blockGasLimit() (uint64, error) {
+	if bcs.Client.Status != netshare.Connected {
+		return 0, rerr.ErrSpectrumNotConnected
+	}
+	h, err := bcs.Client.HeaderByNumber(GetQueryConext(), nil)
+	if err != nil {
+		return 0, rerr.ErrSpectrumNotConnected.AppendError(err)
+	}
+	return h.GasLimit, nil
+}
+
+//SetGasLimit limit 为0表示恢复自动估算,不为0时必须连接公链,并且不能超过最新块的 gas limit
+func (bcs *BlockChainService) SetGasLimit(op models.TXInfoType, limit uint64) error {
+	var blockGasLimit uint64
+	if limit > 0 {
+		var err error
+		blockGasLimit, err = bcs.blockGasLimit()
+		if err != nil {
+			return err
+		}
+	}
+	err := checkGasLimit(op, limit, blockGasLimit)
+	if err != nil {
+		return err
+	}
+	bcs.setGasLimit(op, limit)
+	return nil
+}
+
+/*
+SetGasLimits 启动时使用配置文件中的 gas limit,没有连接公链时只检查交易类型,
+等连接以后交易失败再调整
+*/
+func (bcs *BlockChainService) SetGasLimits(limits map[models.TXInfoType]uint64) error {
+	if len(limits) == 0 {
+		return nil
+	}
+	blockGasLimit, err := bcs.blockGasLimit()
+	if err != nil {
+		log.Warn(fmt.Sprintf("cannot check gas limits against block gas limit,err %s", err))
+	}
+	for op, limit := range limits {
+		err = checkGasLimit(op, limit, blockGasLimit)
+		if err != nil {
+			return err
+		}
+	}
+	for op, limit := range limits {
+		bcs.setGasLimit(op, limit)
+	}
+	return nil
+}
+
+func (bcs *BlockChainService) setGasLimit(op models.TXInfoType, limit uint64) {
+	bcs.gasLimitsLock.Lock()
+	defer bcs.gasLimitsLock.Unlock()
+	if limit == 0 {
+		delete(bcs.gasLimits, op)
+	} else {
+		bcs.gasLimits[op] = limit
+	}
+	log.Info(fmt.Sprintf("gas limit of %s set to %d", op, limit))
+}
+
+//GasLimits 返回所有指定了 gas limit 的交易类型,没有出现的自动估算
+func (bcs *BlockChainService) GasLimits() map[models.TXInfoType]uint64 {
+	bcs.gasLimitsLock.RLock()
+	defer bcs.gasLimitsLock.RUnlock()
+	m := make(map[models.TXInfoType]uint64, len(bcs.gasLimits))
+	for op, limit := range bcs.gasLimits {
+		m[op] = limit
+	}
+	return m
+}
+
+//authFor 复制一份 Auth 并设置 op 的 gas limit,没有指定时返回 bcs.Auth,由合约调用自动估算
+func (bcs *BlockChainService) authFor(op models.TXInfoType) *bind.TransactOpts {
+	bcs.gasLimitsLock.RLock()
+	limit := bcs.gasLimits[op]
+	bcs.gasLimitsLock.RUnlock()
+	if limit == 0 {
+		return bcs.Auth
+	}
+	auth := *bcs.Auth
+	auth.GasLimit = limit
+	return &auth
+}
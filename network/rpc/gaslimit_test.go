@@ -0,0 +1,40 @@
+package rpc
+
+import (
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGasLimit(t *testing.T) {
+	assert.Nil(t, checkGasLimit(models.TXInfoTypeSettle, 0, 100))
+	assert.Nil(t, checkGasLimit(models.TXInfoTypeSettle, 100, 100))
+	assert.NotNil(t, checkGasLimit(models.TXInfoTypeSettle, 101, 100))
+	//不知道块的 gas limit 时不检查
+	assert.Nil(t, checkGasLimit(models.TXInfoTypeSettle, 101, 0))
+	assert.NotNil(t, checkGasLimit(models.TXInfoType("unknown"), 0, 100))
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bcs := &BlockChainService{
+		Auth:      bind.NewKeyedTransactor(key),
+		gasLimits: make(map[models.TXInfoType]uint64),
+	}
+	assert.Nil(t, bcs.SetGasLimits(nil))
+	assert.True(t, bcs.authFor(models.TXInfoTypeSettle) == bcs.Auth)
+	bcs.setGasLimit(models.TXInfoTypeSettle, 300000)
+	auth := bcs.authFor(models.TXInfoTypeSettle)
+	assert.EqualValues(t, 300000, auth.GasLimit)
+	assert.EqualValues(t, 0, bcs.Auth.GasLimit, "must not change the shared auth")
+	assert.Equal(t, bcs.Auth.From, auth.From)
+	assert.True(t, bcs.authFor(models.TXInfoTypeClose) == bcs.Auth)
+	assert.Len(t, bcs.GasLimits(), 1)
+	//0 恢复自动估算,不需要连接公链
+	assert.Nil(t, bcs.SetGasLimit(models.TXInfoTypeSettle, 0))
+	assert.Empty(t, bcs.GasLimits())
+}
@@ -0,0 +1,36 @@
+package rpc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+//分叉以后重新监控的 tx 不能因为 pendingTXInfoChan 满了而阻塞主线程
+func TestRegisterReorgedTXInfoNeverBlock(t *testing.T) {
+	bcs := &BlockChainService{
+		pendingTXInfoChan: make(chan *models.TXInfo, 1),
+		reorgedTXs:        make(map[common.Hash]bool),
+	}
+	bcs.RegisterPendingTXInfo(&models.TXInfo{})
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 20; i++ {
+			//不是 pending 状态的 tx,监控线程会立即退出
+			bcs.RegisterReorgedTXInfo(&models.TXInfo{TXHash: utils.NewRandomHash(), Status: models.TXInfoStatusSuccess})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("RegisterReorgedTXInfo blocked")
+	}
+	bcs.reorgedTXsLock.Lock()
+	assert.Len(t, bcs.reorgedTXs, 20)
+	bcs.reorgedTXsLock.Unlock()
+}
@@ -42,7 +42,7 @@ func (s *SecretRegistryProxy) RegisterSecret(secret common.Hash) (err error) {
 		err = rerr.ErrSecretAlreadyRegistered.Errorf("secret %s,secret hash=%s  already registered", secret.String(), utils.ShaSecret(secret[:]).String())
 		return
 	}
-	tx, err := s.registry.RegisterSecret(s.bcs.Auth, secret)
+	tx, err := s.registry.RegisterSecret(s.bcs.authFor(models.TXInfoTypeRegisterSecret), secret)
 	if err != nil {
 		return rerr.ContractCallError(err)
 	}
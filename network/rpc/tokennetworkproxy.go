@@ -94,7 +94,7 @@ func (t *TokenNetworkProxy) newChannelAndDepositByApprove(token *TokenProxy, par
 	log.Info(fmt.Sprintf("newChannelAndDepositByApprove participant=%s,partner=%s,settletimeout=%d,amount=%s,token=%s",
 		utils.APex2(participantAddress), utils.APex2(partnerAddress), settleTimeout, amount, utils.APex2(t.token),
 	))
-	tx, err := token.Token.Approve(t.bcs.Auth, t.Address, amount)
+	tx, err := token.Token.Approve(t.bcs.authFor(models.TXInfoTypeApproveDeposit), t.Address, amount)
 	if err != nil {
 		return rerr.ContractCallError(err)
 	}
@@ -186,6 +186,7 @@ func (t *TokenNetworkProxy) newChannelAndDepositOnSMTToken(tokenAddress common.A
 	// 在Auth中设置金额,不用t.bcs.Auth,避免影响其他交易
 	auth := bind.NewKeyedTransactor(t.bcs.PrivKey)
 	auth.Value = amount
+	auth.GasLimit = t.bcs.authFor(models.TXInfoTypeDeposit).GasLimit
 	tx, err := smtTokenProxy.BuyAndTransfer(auth, data)
 	if err != nil {
 		return rerr.ContractCallError(err)
@@ -266,6 +267,33 @@ func (t *TokenNetworkProxy) GetChannelParticipantInfo(participant, partner commo
 	return
 }
 
+/*
+GetChannelClosedEvent 查询通道在 closedBlock 块关闭时的事件,合约上只保存了 balance hash,
+关闭方以及提交的 transferred amount 和 locksroot 只能从事件中获得
+*/
+func (t *TokenNetworkProxy) GetChannelClosedEvent(channelID common.Hash, closedBlock uint64) (ev *contracts.TokensNetworkChannelClosed, err error) {
+	opts := &bind.FilterOpts{
+		Start:   closedBlock,
+		End:     &closedBlock,
+		Context: GetQueryConext(),
+	}
+	it, err := t.ch.FilterChannelClosed(opts, [][32]byte{channelID})
+	if err != nil {
+		return
+	}
+	defer it.Close()
+	for it.Next() {
+		ev = it.Event
+	}
+	if it.Error() != nil {
+		return nil, it.Error()
+	}
+	if ev == nil {
+		err = fmt.Errorf("no ChannelClosed event of channel %s at block %d", channelID.String(), closedBlock)
+	}
+	return
+}
+
 //GetContract return contract
 func (t *TokenNetworkProxy) GetContract() *contracts.TokensNetwork {
 	return t.ch
@@ -273,7 +301,7 @@ func (t *TokenNetworkProxy) GetContract() *contracts.TokensNetwork {
 
 //CloseChannel close channel
 func (t *TokenNetworkProxy) CloseChannel(partnerAddr common.Address, transferAmount *big.Int, locksRoot common.Hash, nonce uint64, extraHash common.Hash, signature []byte) (err error) {
-	tx, err := t.GetContract().PrepareSettle(t.bcs.Auth, t.token, partnerAddr, transferAmount, locksRoot, uint64(nonce), extraHash, signature)
+	tx, err := t.GetContract().PrepareSettle(t.bcs.authFor(models.TXInfoTypeClose), t.token, partnerAddr, transferAmount, locksRoot, uint64(nonce), extraHash, signature)
 	if err != nil {
 		return rerr.ContractCallError(err)
 	}
@@ -308,7 +336,7 @@ func (t *TokenNetworkProxy) CloseChannel(partnerAddr common.Address, transferAmo
 
 //CloseChannelAsync close channel async 认为只要交易进入了缓冲池中,肯定会成功.
 func (t *TokenNetworkProxy) CloseChannelAsync(partnerAddr common.Address, transferAmount *big.Int, locksRoot common.Hash, nonce uint64, extraHash common.Hash, signature []byte) (err error) {
-	tx, err := t.GetContract().PrepareSettle(t.bcs.Auth, t.token, partnerAddr, transferAmount, locksRoot, uint64(nonce), extraHash, signature)
+	tx, err := t.GetContract().PrepareSettle(t.bcs.authFor(models.TXInfoTypeClose), t.token, partnerAddr, transferAmount, locksRoot, uint64(nonce), extraHash, signature)
 	if err != nil {
 		return rerr.ContractCallError(err)
 	}
@@ -347,7 +375,7 @@ func (t *TokenNetworkProxy) CloseChannelAsync(partnerAddr common.Address, transf
 
 //UpdateBalanceProof update balance proof of partner
 func (t *TokenNetworkProxy) UpdateBalanceProof(partnerAddr common.Address, transferAmount *big.Int, locksRoot common.Hash, nonce uint64, extraHash common.Hash, signature []byte) (err error) {
-	tx, err := t.GetContract().UpdateBalanceProof(t.bcs.Auth, t.token, partnerAddr, transferAmount, locksRoot, nonce, extraHash, signature)
+	tx, err := t.GetContract().UpdateBalanceProof(t.bcs.authFor(models.TXInfoTypeUpdateBalanceProof), t.token, partnerAddr, transferAmount, locksRoot, nonce, extraHash, signature)
 	if err != nil {
 		return rerr.ContractCallError(err)
 	}
@@ -396,7 +424,7 @@ func (t *TokenNetworkProxy) UpdateBalanceProofAsync(partnerAddr common.Address,
 
 //Unlock a partner's lock
 func (t *TokenNetworkProxy) Unlock(partnerAddr common.Address, transferAmount *big.Int, lock *mtree.Lock, proof []byte) (err error) {
-	tx, err := t.GetContract().Unlock(t.bcs.Auth, t.token, partnerAddr, transferAmount, big.NewInt(lock.Expiration), lock.Amount, lock.LockSecretHash, proof)
+	tx, err := t.GetContract().Unlock(t.bcs.authFor(models.TXInfoTypeUnlock), t.token, partnerAddr, transferAmount, big.NewInt(lock.Expiration), lock.Amount, lock.LockSecretHash, proof)
 	if err != nil {
 		return rerr.ContractCallError(err)
 	}
@@ -441,7 +469,7 @@ func (t *TokenNetworkProxy) UnlockAsync(partnerAddr common.Address, transferAmou
 
 //SettleChannel settle a channel
 func (t *TokenNetworkProxy) SettleChannel(p1Addr, p2Addr common.Address, p1Amount, p2Amount *big.Int, p1Locksroot, p2Locksroot common.Hash) (err error) {
-	tx, err := t.GetContract().Settle(t.bcs.Auth, t.token, p1Addr, p1Amount, p1Locksroot, p2Addr, p2Amount, p2Locksroot)
+	tx, err := t.GetContract().Settle(t.bcs.authFor(models.TXInfoTypeSettle), t.token, p1Addr, p1Amount, p1Locksroot, p2Addr, p2Amount, p2Locksroot)
 	if err != nil {
 		return rerr.ContractCallError(err)
 	}
@@ -475,7 +503,7 @@ func (t *TokenNetworkProxy) SettleChannel(p1Addr, p2Addr common.Address, p1Amoun
 
 //SettleChannelAsync settle a channel async 进入缓冲池就认为成功了
 func (t *TokenNetworkProxy) SettleChannelAsync(p1Addr, p2Addr common.Address, p1Amount, p2Amount, p1Balance, p2Balance *big.Int, p1Locksroot, p2Locksroot common.Hash) (err error) {
-	tx, err := t.GetContract().Settle(t.bcs.Auth, t.token, p1Addr, p1Amount, p1Locksroot, p2Addr, p2Amount, p2Locksroot)
+	tx, err := t.GetContract().Settle(t.bcs.authFor(models.TXInfoTypeSettle), t.token, p1Addr, p1Amount, p1Locksroot, p2Addr, p2Amount, p2Locksroot)
 	if err != nil {
 		return rerr.ContractCallError(err)
 	}
@@ -515,7 +543,7 @@ func (t *TokenNetworkProxy) SettleChannelAsync(p1Addr, p2Addr common.Address, p1
 //Withdraw  to  a channel
 func (t *TokenNetworkProxy) Withdraw(p1Addr, p2Addr common.Address, p1Balance,
 	p1Withdraw *big.Int, p1Signature, p2Signature []byte) (err error) {
-	tx, err := t.GetContract().WithDraw(t.bcs.Auth, t.token, p1Addr, p2Addr, p1Balance, p1Withdraw,
+	tx, err := t.GetContract().WithDraw(t.bcs.authFor(models.TXInfoTypeWithdraw), t.token, p1Addr, p2Addr, p1Balance, p1Withdraw,
 		p1Signature, p2Signature,
 	)
 	if err != nil {
@@ -562,7 +590,7 @@ func (t *TokenNetworkProxy) WithdrawAsync(p1Addr, p2Addr common.Address, p1Balan
 
 //PunishObsoleteUnlock  to  a channel
 func (t *TokenNetworkProxy) PunishObsoleteUnlock(beneficiary, cheater common.Address, lockhash, extraHash common.Hash, cheaterSignature []byte) (err error) {
-	tx, err := t.GetContract().PunishObsoleteUnlock(t.bcs.Auth, t.token, beneficiary, cheater, lockhash, extraHash, cheaterSignature)
+	tx, err := t.GetContract().PunishObsoleteUnlock(t.bcs.authFor(models.TXInfoTypePunish), t.token, beneficiary, cheater, lockhash, extraHash, cheaterSignature)
 	if err != nil {
 		return rerr.ContractCallError(err)
 	}
@@ -605,7 +633,7 @@ func (t *TokenNetworkProxy) PunishObsoleteUnlockAsync(beneficiary, cheater commo
 
 //CooperativeSettle  settle  a channel
 func (t *TokenNetworkProxy) CooperativeSettle(p1Addr, p2Addr common.Address, p1Balance, p2Balance *big.Int, p1Signature, p2Signatue []byte) (err error) {
-	tx, err := t.GetContract().CooperativeSettle(t.bcs.Auth, t.token, p1Addr, p1Balance, p2Addr, p2Balance, p1Signature, p2Signatue)
+	tx, err := t.GetContract().CooperativeSettle(t.bcs.authFor(models.TXInfoTypeCooperateSettle), t.token, p1Addr, p1Balance, p2Addr, p2Balance, p1Signature, p2Signatue)
 	if err != nil {
 		return rerr.ContractCallError(err)
 	}
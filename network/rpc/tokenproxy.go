@@ -111,7 +111,7 @@ func (t *TokenProxy) TransferAsync(spender common.Address, value *big.Int) (resu
 
 //TransferWithFallback ERC223 TokenFallback,进入缓冲池以后就认为不可能会失败,不等待打包
 func (t *TokenProxy) TransferWithFallback(to common.Address, value *big.Int, extraData []byte, txParams *models.DepositTXParams) (err error) {
-	tx, err := t.Token.Transfer(t.bcs.Auth, to, value, extraData)
+	tx, err := t.Token.Transfer(t.bcs.authFor(models.TXInfoTypeDeposit), to, value, extraData)
 	if err != nil {
 		return rerr.ContractCallError(err)
 	}
@@ -145,7 +145,7 @@ func (t *TokenProxy) TransferWithFallback(to common.Address, value *big.Int, ext
 
 //ApproveAndCall ERC20 extend,进入缓冲池以后就认为不可能会失败,不等待打包
 func (t *TokenProxy) ApproveAndCall(spender common.Address, value *big.Int, extraData []byte, txParams *models.DepositTXParams) (err error) {
-	tx, err := t.Token.ApproveAndCall(t.bcs.Auth, spender, value, extraData)
+	tx, err := t.Token.ApproveAndCall(t.bcs.authFor(models.TXInfoTypeDeposit), spender, value, extraData)
 	if err != nil {
 		return rerr.ContractCallError(err)
 	}
@@ -60,6 +60,14 @@ type Transporter interface {
 	NodeStatus(addr common.Address) (deviceType string, isOnline bool)
 }
 
+/*
+PresenceAnnouncer transport 可以主动广播我在线,不用等其他节点下一次查询,
+没有实现的 transport 只能靠 ping 让对方知道我在线
+*/
+type PresenceAnnouncer interface {
+	AnnouncePresence() error
+}
+
 type dummyPolicy struct {
 }
 
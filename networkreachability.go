@@ -0,0 +1,113 @@
+package photon
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+networkReachabilityDebounce 能到达的节点数变成 0 或者从 0 恢复以后,新的状态至少要保持这么久才通知订阅者,
+健康检查每 10 秒 ping 一次,这样一次 ping 失败不会让钱包显示离线
+*/
+const networkReachabilityDebounce = 25 * time.Second
+
+/*
+networkReachability 根据健康检查的结果汇总出节点是否在线:至少能到达一个通道对方就认为在线.
+健康检查在各自的goroutine中,订阅可能发生在任意goroutine中,所以需要锁保护
+*/
+type networkReachability struct {
+	lock      sync.Mutex
+	reachable map[common.Address]bool
+	online    bool      //已经通知过的状态
+	changedAt time.Time //汇总结果和 online 不同的开始时间,零值表示相同
+	subs      map[chan bool]bool
+}
+
+func newNetworkReachability() *networkReachability {
+	return &networkReachability{
+		reachable: make(map[common.Address]bool),
+		online:    true,
+		subs:      make(map[chan bool]bool),
+	}
+}
+
+/*
+update 记录一次对 addr 的健康检查结果,汇总状态变化并且保持了 networkReachabilityDebounce 以后通知订阅者
+*/
+func (n *networkReachability) update(addr common.Address, reachable bool, now time.Time) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.reachable[addr] = reachable
+	n.evaluate(now)
+}
+
+//remove 不再和 addr 有通道,它的结果不再参与汇总
+func (n *networkReachability) remove(addr common.Address, now time.Time) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if _, ok := n.reachable[addr]; !ok {
+		return
+	}
+	delete(n.reachable, addr)
+	n.evaluate(now)
+}
+
+//evaluate 调用者持有锁
+func (n *networkReachability) evaluate(now time.Time) {
+	online := false
+	for _, r := range n.reachable {
+		if r {
+			online = true
+			break
+		}
+	}
+	if online == n.online {
+		n.changedAt = time.Time{}
+		return
+	}
+	if n.changedAt.IsZero() {
+		n.changedAt = now
+	}
+	if now.Sub(n.changedAt) < networkReachabilityDebounce {
+		return
+	}
+	n.online = online
+	n.changedAt = time.Time{}
+	log.Info(fmt.Sprintf("network reachability changed,online=%v", online))
+	for ch := range n.subs {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- online
+	}
+}
+
+/*
+SubscribeNetworkReachability 订阅节点是否在线,也就是能否到达至少一个通道对方.
+订阅时立即收到当前状态,之后只在状态变化时收到通知,短暂的波动会被过滤掉.
+结果来自健康检查,没有打开 EnableHealthCheck 时总是在线.
+消费者处理慢时只保留最新的状态,调用返回的cancel以后chan会被关闭.
+*/
+func (rs *Service) SubscribeNetworkReachability() (<-chan bool, func()) {
+	ch := make(chan bool, 1)
+	n := rs.networkReachability
+	n.lock.Lock()
+	ch <- n.online
+	n.subs[ch] = true
+	n.lock.Unlock()
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			n.lock.Lock()
+			delete(n.subs, ch)
+			close(ch)
+			n.lock.Unlock()
+		})
+	}
+	return ch, cancel
+}
@@ -0,0 +1,46 @@
+package photon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscribeNetworkReachability(t *testing.T) {
+	rs := &Service{networkReachability: newNetworkReachability()}
+	n := rs.networkReachability
+	ch, cancel := rs.SubscribeNetworkReachability()
+	assert.True(t, <-ch)
+
+	b, c := utils.NewRandomAddress(), utils.NewRandomAddress()
+	now := time.Now()
+	n.update(b, true, now)
+	n.update(c, false, now)
+	//一个节点不可达,仍然在线
+	n.update(b, false, now.Add(time.Second))
+	assert.Len(t, ch, 0)
+	//短暂的波动不通知
+	n.update(c, true, now.Add(10*time.Second))
+	n.update(c, false, now.Add(20*time.Second))
+	n.update(b, false, now.Add(30*time.Second))
+	assert.Len(t, ch, 0)
+	//持续不可达以后通知离线
+	n.update(b, false, now.Add(20*time.Second+networkReachabilityDebounce))
+	if assert.Len(t, ch, 1) {
+		assert.False(t, <-ch)
+	}
+	//恢复同样需要持续一段时间
+	n.update(c, true, now.Add(time.Minute))
+	assert.Len(t, ch, 0)
+	n.update(c, true, now.Add(time.Minute+networkReachabilityDebounce))
+	if assert.Len(t, ch, 1) {
+		assert.True(t, <-ch)
+	}
+
+	cancel()
+	_, ok := <-ch
+	assert.False(t, ok)
+	cancel()
+}
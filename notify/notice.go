@@ -45,6 +45,14 @@ const (
 	InfoTypeContractCallTXInfo
 	//InfoTypeInconsistentDatabase 交易发送方和接收方数据库不一致
 	InfoTypeInconsistentDatabase
+	//InfoTypeInvoicePaid 账单已经付清,Message类型为models.Invoice
+	InfoTypeInvoicePaid
+	//InfoTypeDuplicateTransfer 作为接收方重复收到了同一个锁的交易,Message类型为photon.DuplicateTransferEvent
+	InfoTypeDuplicateTransfer
+	//InfoTypePartnerClosed 对方关闭了和我的通道,Message类型为photon.PartnerClosedEvent
+	InfoTypePartnerClosed
+	//InfoTypeUnsafeChannel 对方打开的通道 settle timeout 太短,没有使用,Message类型为photon.UnsafeChannelEvent
+	InfoTypeUnsafeChannel
 )
 
 //InfoStruct for notify to mobile
@@ -179,3 +179,11 @@ func (h *Handler) NotifyInconsistentDatabase(channelIdentifier common.Hash, targ
 		},
 	})
 }
+
+//NotifyInvoicePaid 通知账单已经付清
+func (h *Handler) NotifyInvoicePaid(inv *models.Invoice) {
+	h.Notify(LevelInfo, &InfoStruct{
+		Type:    InfoTypeInvoicePaid,
+		Message: inv,
+	})
+}
@@ -2,6 +2,7 @@ package params
 
 import (
 	"crypto/ecdsa"
+	"math/big"
 	"os"
 	"os/user"
 	"path/filepath"
@@ -9,6 +10,7 @@ import (
 
 	"time"
 
+	"github.com/SmartMeshFoundation/Photon/utils"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/node"
 )
@@ -20,6 +22,15 @@ type protocolConfig struct {
 	ThrottleFillRate     float64
 }
 
+/*
+MessageRateLimit 每个节点每种消息的限速,使用令牌桶算法,
+Capacity 为0表示不限速
+*/
+type MessageRateLimit struct {
+	Capacity float64 //允许的突发消息数
+	FillRate float64 //每秒恢复的消息数
+}
+
 //NetworkMode is transport status
 type NetworkMode int
 
@@ -41,6 +52,37 @@ const (
 	MixUDPMatrix
 )
 
+//SecretRevealStrategy 作为接收方,什么时候把密码披露给上家
+type SecretRevealStrategy int
+
+const (
+	/*
+		SecretRevealEager 收到发起方的密码并且验证通过以后立即披露给上家,延迟最低,默认值.
+		如果上家的通道刚刚打开,链发生重组时通道可能被回滚,这时已经披露的密码让我无法在链上取回这笔钱
+	*/
+	SecretRevealEager SecretRevealStrategy = iota
+	/*
+		SecretRevealConfirmed 等收到锁的通道的打开(取现后重新打开)以及上家最后一次存款被 SecretRevealConfirmations 个块确认以后再披露,
+		能防止链重组,代价是新通道上的交易要多等这么多块才能完成.
+		等待期间锁快要过期时照常到链上注册密码
+	*/
+	SecretRevealConfirmed
+)
+
+//ChannelSelectionStrategy 和同一个节点有多个通道时,DirectTransfer 使用哪一个
+type ChannelSelectionStrategy int
+
+const (
+	//ChannelSelectionDefault 每个节点只使用通道图中记录的那一个通道,默认值
+	ChannelSelectionDefault ChannelSelectionStrategy = iota
+	//ChannelSelectionMostBalance 使用我方可用余额最多的通道
+	ChannelSelectionMostBalance
+	//ChannelSelectionMostBalanced 使用交易以后双方余额最接近的通道,让通道两个方向都保持可用
+	ChannelSelectionMostBalanced
+	//ChannelSelectionRoundRobin 余额足够的通道轮流使用
+	ChannelSelectionRoundRobin
+)
+
 //Config is configuration for Photon,
 type Config struct {
 	/*
@@ -61,6 +103,11 @@ type Config struct {
 		该Photon节点的私钥,因为节点之间来往消息需要签名,因此必须保存该私钥在内存中
 	*/
 	PrivateKey *ecdsa.PrivateKey
+	/*
+		Signer 可选,设置以后节点间消息通过它签名,私钥可以放在硬件钱包等外部签名服务中,
+		链上交易仍然使用PrivateKey
+	*/
+	Signer utils.Signer
 	/*
 		专门留给节点进行链上unlock的时间,
 	*/
@@ -89,6 +136,167 @@ type Config struct {
 	HTTPPassword              string
 	PmsHost                   string // pms server host
 	PmsAddress                common.Address
+	/*
+		MessageRateLimits 按消息类型(encoding中的CmdID)对每个节点限速,
+		没有配置的消息类型使用DefaultMessageRateLimit
+	*/
+	MessageRateLimits       map[int]MessageRateLimit
+	DefaultMessageRateLimit MessageRateLimit
+	/*
+		MessageRatePenalty 节点超过限速以后,在这段时间内丢弃它的所有消息,0表示只丢弃超出的消息
+	*/
+	MessageRatePenalty time.Duration
+	/*
+		MaxChannelsPerPartner 同一个token上和同一个节点最多同时存在几个通道,0表示使用默认值1.
+		已经关闭或者结算的通道不计算在内.
+		目前通道标识由token和双方地址决定,合约上同一对节点最多只有一个通道,所以大于1的值暂时没有意义
+	*/
+	MaxChannelsPerPartner int
+	/*
+		ConfirmationBlocks 通道的打开,关闭,结算事件要经过多少个块确认以后才处理,0表示看到就处理.
+		在经常出现短分叉的链上应该设置,代价是通道打开更慢,对方关闭通道以后我方提交BalanceProof的时间更短,
+		所以应该远小于通道的SettleTimeout.
+	*/
+	ConfirmationBlocks int64
+	/*
+		HandshakeRetryInterval 合作关闭,取现这些握手消息第一次重发之前等待的时间,0表示和其他消息一样
+		HandshakeMaxAttempts 握手请求最多发送几次,之后放弃等待用户重试,0表示一直重发
+	*/
+	HandshakeRetryInterval time.Duration
+	HandshakeMaxAttempts   int
+	/*
+		AutoRegisterTokenOnReceive 遇到本地没有的 token 时,去链上确认这个 token 已经注册,然后自动添加,
+		否则直接拒绝相关的交易和通道事件
+	*/
+	AutoRegisterTokenOnReceive bool
+	/*
+		MinTransferAmount 发起以及中转的交易金额不能低于这个值,nil表示只要求大于0
+	*/
+	MinTransferAmount *big.Int
+	/*
+		MinMediationFee 中转一笔交易能收到的手续费低于这个值时拒绝中转,避免为了很小的手续费承担以后链上结算的 gas,
+		nil表示不限制
+	*/
+	MinMediationFee *big.Int
+	/*
+		BalanceAwareRouting 费用和跳数相同的路由中,优先选择交易以后第一跳通道更平衡的,减少以后 rebalance 的需要
+	*/
+	BalanceAwareRouting bool
+	/*
+		AllowOfflineFirstHop 发起交易时,用户或者 pfs 给出的路由第一跳不在线也照常使用.
+		默认去掉这些路由,全部不在线时立即返回没有路由,而不是发出以后等重试超时才失败.
+		本地通道图给出的路由总是只包含在线的第一跳
+	*/
+	AllowOfflineFirstHop bool
+	/*
+		DataBaseEncryptionKey 不为空时数据库中保存的值(balance proof,交易记录等)会被加密,只能在创建数据库时设置.
+		bucket 名字和索引等 key 仍然是明文,所以只能隐藏内容,不能隐藏访问模式以及数据量
+	*/
+	DataBaseEncryptionKey string
+	/*
+		MessageCompressThreshold 不小于这个长度的消息压缩以后再发送,适合 mesh 等带宽很小的网络,0 表示不压缩.
+		只压缩发给能够解压的节点的消息,旧版本节点不受影响
+	*/
+	MessageCompressThreshold int
+	/*
+		AckFailureThreshold 发给同一个节点的连续这么多条消息都没有及时收到 ack,就认为它不可达,路由立即避开它,
+		同一条消息的重发只算一次,收到它的 ack 或者消息以后恢复.0 表示只根据 transport 和健康检查判断
+	*/
+	AckFailureThreshold int
+	/*
+		SendCompletionWorkers 最多同时有多少个 goroutine 等待发出的消息的结果,超出的排队,0 表示每条消息一个 goroutine.
+		限制以后突发大量消息时资源占用有上限,排队的消息轮流等待,发给离线节点的消息不会推迟其他消息的完成通知
+	*/
+	SendCompletionWorkers int
+	/*
+		MaxConcurrentTransfers 我发起的尚未结束的交易数量上限,达到以后新的交易直接拒绝,0表示不限制
+	*/
+	MaxConcurrentTransfers int
+	/*
+		MaxConcurrentMediatedTransfers 我中转的尚未结束的交易数量上限,达到以后不再提供路由,0表示不限制
+	*/
+	MaxConcurrentMediatedTransfers int
+	/*
+		MaxPendingLocksPerChannel 通道中我或者对方尚未解锁的锁的数量上限,0表示不限制.
+		锁越多 settle 时需要的 unlock 越多,gas 越高; 达到上限以后不再通过这个通道发出交易,对方发来的交易直接声明放弃
+	*/
+	MaxPendingLocksPerChannel int
+	/*
+		MaxLockExpirationBlocks 锁最多在多少块以后过期,0表示不限制.
+		交易卡住时资金会一直锁定到锁过期,所以发起交易时过期时间不超过当前块加上这个值,
+		中转时上家的锁过期时间超过这个值直接声明放弃
+	*/
+	MaxLockExpirationBlocks int64
+	/*
+		PreferDirectTransfer 发起交易时,如果和接收方有余额足够的直接通道,自动改用 DirectTransfer,省去手续费以及多次消息往返.
+		DirectTransfer 一旦发出就不能取消,也不能等待超时失败,所以默认关闭,指定了密码的交易不受影响
+	*/
+	PreferDirectTransfer bool
+	/*
+		DisableDirectTransfers 不发起 DirectTransfer,指定了 DirectTransfer 的请求直接拒绝,PreferDirectTransfer 也不再生效.
+		DirectTransfer 不能取消也不会过期,不愿意承担这种信任的节点可以打开
+	*/
+	DisableDirectTransfers bool
+	/*
+		DirectChannelSelection 和接收方有多个通道时 DirectTransfer 选择哪一个,见 MaxChannelsPerPartner.
+		只有余额足够的通道参与选择,默认 ChannelSelectionDefault
+	*/
+	DirectChannelSelection ChannelSelectionStrategy
+	/*
+		SecretRevealStrategy 作为接收方什么时候向上家披露密码,默认 SecretRevealEager.
+		SecretRevealConfirmations 使用 SecretRevealConfirmed 时需要的确认块数,0表示使用 params.ForkConfirmNumber
+	*/
+	SecretRevealStrategy      SecretRevealStrategy
+	SecretRevealConfirmations int64
+	/*
+		AcceptedMessageVersions 除了当前版本以外,还接受对方使用哪些版本的消息,key 是消息名字,比如 MediatedTransfer.
+		nil 表示接受所有能够兼容解码的版本; 网络中的节点都升级以后,可以配置为空 map,只接受当前版本.
+		无法兼容的版本总是拒绝
+	*/
+	AcceptedMessageVersions map[string][]int16
+	/*
+		ReportDuplicateTransfer 作为接收方重复收到同一个锁的 MediatedTransfer 时,按发送方计数并通知上层,用于发现重放攻击.
+		默认只是忽略,并且限制日志的频率
+	*/
+	ReportDuplicateTransfer bool
+	/*
+		AckRetentionBlocks 收到的消息的 ack 保存这么多块以后删除,限制数据库的大小,0 表示永远不删除.
+		删除以后重复的消息就不能再识别了,所以实际保留时间不会小于通道的 settle timeout
+	*/
+	AckRetentionBlocks int64
+	/*
+		GasLimits 按交易类型(ChannelDeposit,ChannelClose,ChannelSettle,CooperateSettle,Withdraw 等)指定交易的 gas limit,
+		用于 estimateGas 估算不足的情况,0 或者没有配置表示自动估算. 打开通道使用 ChannelDeposit
+	*/
+	GasLimits map[string]uint64
+	/*
+		AutoRespondToClose 对方关闭通道以后,自动提交我持有的对方最新的 balance proof,默认打开.
+		关闭以后只通知上层,需要在 settle timeout 以内自己调用 UpdateBalanceProof,否则可能损失对方已经支付给我的 token
+	*/
+	AutoRespondToClose bool
+	/*
+		BlockPollInterval 查询公链新块的周期,0表示按链使用默认值.
+		新块驱动整个节点对时间的判断,周期越短反应越快,但是 rpc 调用也越多
+	*/
+	BlockPollInterval time.Duration
+	/*
+		MinAcceptableSettleTimeout 对方打开的通道 settle timeout 低于这个值时不使用这个通道,0表示只要求链上允许的最小值.
+		settle timeout 太短的话,对方关闭通道以后我可能来不及提交 balance proof.
+		链上的打开无法阻止,只能不注册这个通道,既不通过它交易,也不对它做健康检查
+	*/
+	MinAcceptableSettleTimeout int
+	/*
+		TokenSettleTimeouts 按 token 指定新建通道时默认的 settle timeout,没有配置的 token 使用 SettleTimeout.
+		价值高的 token 可以用更长的时间,经常需要重新平衡的稳定币可以用更短的时间.
+		每个值都必须在链上允许的范围内,并且大于 RevealTimeout
+	*/
+	TokenSettleTimeouts map[common.Address]int
+	/*
+		MaxStartupWait 启动时最多等待多久让积压的链上事件处理完毕,0表示一直等到处理完毕.
+		离线很久的节点积压的事件可能要处理很久,超时以后 Start 照常返回,开始接收用户请求,
+		剩下的历史事件仍然在主循环中按顺序处理,处理完以后才开始接收其他节点的消息
+	*/
+	MaxStartupWait time.Duration
 }
 
 //DefaultConfig default config
@@ -107,15 +315,33 @@ var DefaultConfig = Config{
 	MsgTimeout:        100 * time.Second,
 	EnableHealthCheck: false,
 	XMPPServer:        DefaultXMPPServer,
+	DefaultMessageRateLimit: MessageRateLimit{
+		Capacity: defaultMessageRateCapacity,
+		FillRate: defaultMessageRateFillRate,
+	},
+	MaxChannelsPerPartner: DefaultMaxChannelsPerPartner,
+	AutoRespondToClose:    true,
 }
 
 //ConditionQuit is for test
 type ConditionQuit struct {
 	QuitEvent  string //name match
 	IsBefore   bool   //quit before event occur
-	RandomQuit bool   //random exit
+	RandomQuit bool   //random exit,only works in binaries built with `-tags debug`
+	/*
+		RandomQuitProbability 每次随机等待以后退出的概率,0表示使用默认的 DefaultRandomQuitProbability
+		RandomQuitWindow 每次随机等待的最长时间,0表示使用默认的 DefaultRandomQuitWindow
+	*/
+	RandomQuitProbability float64
+	RandomQuitWindow      time.Duration
 }
 
+//DefaultRandomQuitProbability 与之前按素数退出的概率大致相同
+const DefaultRandomQuitProbability = 0.13
+
+//DefaultRandomQuitWindow 默认每次最多随机等待5秒
+const DefaultRandomQuitWindow = 5 * time.Second
+
 //DefaultDataDir default work directory
 func DefaultDataDir() string {
 	// Try to place the data folder in the user's home dir
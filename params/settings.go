@@ -25,6 +25,10 @@ const defaultProtocolRhrottleCapacity = 10.
 const defaultProtocolThrottleFillRate = 10.
 const defaultprotocolRetryInterval = 1.
 
+//正常情况下一个节点每秒不会给我们发这么多同类消息
+const defaultMessageRateCapacity = 100.
+const defaultMessageRateFillRate = 20.
+
 //DefaultRevealTimeout blocks needs to update transfer
 //this time is used for a participant to register secret on chain
 // and unlock the lock if need.
@@ -33,6 +37,15 @@ var DefaultRevealTimeout = 30
 //DefaultSettleTimeout settle time of channel
 const DefaultSettleTimeout = 600
 
+/*
+LockExpirationSkewBlocks 中转时检查上家的锁过期时间,允许上家的块号比我领先这么多块,
+否则发起方按上限设置的过期时间,在落后一两块的中转节点看来总是超过上限
+*/
+const LockExpirationSkewBlocks = 3
+
+//DefaultMaxChannelsPerPartner channels with the same partner on one token
+const DefaultMaxChannelsPerPartner = 1
+
 //DefaultPollTimeout  request wait time
 const DefaultPollTimeout = 180 * time.Second
 
@@ -217,6 +230,15 @@ var ForkConfirmNumber int64 = 17
 // MaxTransferDataLen : 交易附件信息最大长度
 var MaxTransferDataLen = 256
 
+// MaxTransferMetadataKeys : 交易本地元数据最多能有多少项
+var MaxTransferMetadataKeys = 16
+
+// MaxTransferMetadataKeyLen : 交易本地元数据 key 的最大长度
+var MaxTransferMetadataKeyLen = 64
+
+// MaxTransferMetadataValueLen : 交易本地元数据 value 的最大长度
+var MaxTransferMetadataValueLen = 256
+
 // SMTTokenName SMTToken名,固定
 const SMTTokenName = "SMTToken"
 
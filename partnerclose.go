@@ -0,0 +1,145 @@
+package photon
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/notify"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/transfer"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//PartnerClosedEvent 对方关闭了和我的通道
+type PartnerClosedEvent struct {
+	ChannelIdentifier common.Hash    `json:"channel_identifier"`
+	TokenAddress      common.Address `json:"token_address"`
+	PartnerAddress    common.Address `json:"partner_address"`
+	ClosedBlock       int64          `json:"closed_block"`
+	SettleBlock       int64          `json:"settle_block"` //这个块以后可以 settle,在此之前必须提交对方的 balance proof
+	/*
+		AutoResponded 是否已经自动提交了我持有的对方最新的 balance proof,
+		false 表示配置了不自动处理,需要在 SettleBlock 之前调用 UpdateBalanceProof
+	*/
+	AutoResponded bool `json:"auto_responded"`
+}
+
+/*
+notifyPartnerClosed 不管是否自动提交 balance proof,都通知上层对方关闭了通道.
+只能在主线程中调用
+*/
+func (rs *Service) notifyPartnerClosed(c *channel.Channel) {
+	ev := &PartnerClosedEvent{
+		ChannelIdentifier: c.ChannelIdentifier.ChannelIdentifier,
+		TokenAddress:      c.TokenAddress,
+		PartnerAddress:    c.PartnerState.Address,
+		ClosedBlock:       c.ExternState.ClosedBlock,
+		SettleBlock:       c.ExternState.SettledBlock,
+		AutoResponded:     rs.Config.AutoRespondToClose,
+	}
+	if !ev.AutoResponded {
+		log.Warn(fmt.Sprintf("partner %s closed channel %s,balance proof must be updated manually before block %d",
+			utils.APex2(ev.PartnerAddress), utils.HPex(ev.ChannelIdentifier), ev.SettleBlock))
+	}
+	rs.NotifyHandler.Notify(notify.LevelWarn, &notify.InfoStruct{
+		Type:    notify.InfoTypePartnerClosed,
+		Message: ev,
+	})
+}
+
+/*
+partnerBalanceProofToSubmit 对方关闭通道时提交的是我的 balance proof,合约上对方的 nonce 比我持有的对方 balance proof 旧的话,
+必须在 settle 之前提交我持有的,否则对方转给我的 token 就丢了.
+onChainNonce 是合约上记录的对方的 nonce,返回 nil 表示不需要提交
+*/
+func partnerBalanceProofToSubmit(held *transfer.BalanceProofState, onChainNonce uint64) *transfer.BalanceProofState {
+	if held == nil || held.Nonce <= onChainNonce {
+		return nil
+	}
+	bp := *held
+	return &bp
+}
+
+/*
+partnerCloseResponder 对方关闭通道以后查询合约上对方的 nonce 以及提交对方的 balance proof,*rpc.TokenNetworkProxy 实现了它
+*/
+type partnerCloseResponder interface {
+	GetChannelParticipantInfo(participant, partner common.Address) (deposit *big.Int, balanceHash common.Hash, nonce uint64, err error)
+	UpdateBalanceProofAsync(partnerAddr common.Address, transferAmount *big.Int, locksRoot common.Hash, nonce uint64, extraHash common.Hash, signature []byte) (result *utils.AsyncResult)
+}
+
+/*
+respondToPartnerClose 对方关闭通道以后,先查询合约上对方的 nonce,如果我持有更新的对方的 balance proof,在 settle 之前提交.
+已经提交过的(比如重启以后重复处理,或者 pms 已经代为提交)不会再提交.
+只能在主线程中调用,查询和提交在另外的线程中进行,不需要提交或者提交成功时 result 为 nil
+*/
+func (rs *Service) respondToPartnerClose(c *channel.Channel, responder partnerCloseResponder) (result *utils.AsyncResult) {
+	held := partnerBalanceProofToSubmit(c.PartnerState.BalanceProofState, 0)
+	if held == nil {
+		log.Info(fmt.Sprintf("partner %s closed channel %s,no balance proof of partner to update",
+			utils.APex2(c.PartnerState.Address), c.ChannelIdentifier.String()))
+		return utils.NewAsyncResultWithError(nil)
+	}
+	deadline := c.ExternState.ClosedBlock + int64(c.SettleTimeout)
+	if rs.GetBlockNumber() >= deadline {
+		log.Error(fmt.Sprintf("partner %s closed channel %s,too late to update balance proof,deadline=%d",
+			utils.APex2(c.PartnerState.Address), c.ChannelIdentifier.String(), deadline))
+		return utils.NewAsyncResultWithError(rerr.ErrUpdateBalanceProof.Printf("too late to update balance proof,deadline=%d", deadline))
+	}
+	result = utils.NewAsyncResult()
+	channelIdentifier := c.ChannelIdentifier.String()
+	partner, our := c.PartnerState.Address, c.OurState.Address
+	go func() {
+		_, _, onChainNonce, err := responder.GetChannelParticipantInfo(partner, our)
+		if err != nil {
+			//查不到的时候当作合约上没有对方的 balance proof,最多浪费一次 gas
+			log.Warn(fmt.Sprintf("GetChannelParticipantInfo of channel %s err %s", channelIdentifier, err))
+			onChainNonce = 0
+		}
+		bp := partnerBalanceProofToSubmit(held, onChainNonce)
+		if bp == nil {
+			log.Info(fmt.Sprintf("balance proof of %s on channel %s already up to date,nonce=%d",
+				utils.APex2(partner), channelIdentifier, onChainNonce))
+			result.Result <- nil
+			return
+		}
+		log.Info(fmt.Sprintf("partner %s closed channel %s with nonce %d on chain,update to nonce %d",
+			utils.APex2(partner), channelIdentifier, onChainNonce, bp.Nonce))
+		err = <-responder.UpdateBalanceProofAsync(partner, bp.TransferAmount, bp.LocksRoot, bp.Nonce, bp.MessageHash, bp.Signature).Result
+		if err != nil {
+			log.Error(fmt.Sprintf("update balance proof of channel %s err %s", channelIdentifier, err))
+			rs.NotifyHandler.NotifyString(notify.LevelError,
+				fmt.Sprintf("提交对方的 balance proof 失败,请在块 %d 之前调用 UpdateBalanceProof,channel=%s,err=%s", deadline, channelIdentifier, err))
+		}
+		result.Result <- err
+	}()
+	return
+}
+
+/*
+UpdateBalanceProof 对方关闭通道以后,提交我持有的对方最新的 balance proof,等待交易执行完毕.
+只有 Config.AutoRespondToClose 为 false 时才需要调用
+*/
+func (rs *Service) UpdateBalanceProof(channelIdentifier common.Hash) error {
+	result := rs.updateBalanceProofClient(channelIdentifier)
+	return <-result.Result
+}
+
+/*
+updateBalanceProof 只能在主线程中调用
+*/
+func (rs *Service) updateBalanceProof(channelIdentifier common.Hash) (result *utils.AsyncResult) {
+	c, err := rs.findChannelByIdentifier(channelIdentifier)
+	if err != nil {
+		return utils.NewAsyncResultWithError(rerr.ErrChannelNotFound)
+	}
+	if c.State != channeltype.StateClosed {
+		return utils.NewAsyncResultWithError(rerr.ChannelStateError(c.State))
+	}
+	log.Info(fmt.Sprintf("update balance proof of channel %s", utils.HPex(channelIdentifier)))
+	return c.ExternState.UpdateTransfer(c.PartnerState.BalanceProofState)
+}
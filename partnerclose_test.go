@@ -0,0 +1,158 @@
+package photon
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"sync/atomic"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
+	"github.com/SmartMeshFoundation/Photon/network/graph"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/notify"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/transfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartnerClosedWithoutAutoRespond(t *testing.T) {
+	our, partner := utils.NewRandomAddress(), utils.NewRandomAddress()
+	token := utils.NewRandomAddress()
+	ourState := channel.NewChannelEndState(our, big.NewInt(100), nil, mtree.EmptyTree)
+	partnerState := channel.NewChannelEndState(partner, big.NewInt(100), nil, mtree.EmptyTree)
+	ch, err := channel.NewChannel(ourState, partnerState, &channel.ExternalState{}, token,
+		&contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash()}, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewChannelGraph(our, token, nil)
+	assert.Nil(t, g.AddChannel(ch))
+	rs := &Service{
+		NodeAddress:        our,
+		Config:             &params.Config{},
+		NotifyHandler:      notify.NewNotifyHandler(),
+		Token2ChannelGraph: map[common.Address]*graph.ChannelGraph{token: g},
+	}
+
+	result := rs.updateBalanceProof(utils.NewRandomHash())
+	assert.NotNil(t, <-result.Result)
+	result = rs.updateBalanceProof(ch.ChannelIdentifier.ChannelIdentifier)
+	assert.NotNil(t, <-result.Result, "channel is not closed")
+
+	//不自动处理时不会调用合约,ExternalState 没有 TokenNetwork 也不会出错
+	ch.State = channeltype.StateClosed
+	ch.ExternState.SetClosed(10)
+	ch.ExternState.SetSettled(10 + int64(ch.SettleTimeout) + params.PunishBlockNumber)
+	ch.HandleClosed(partner, big.NewInt(0), utils.EmptyHash)
+	rs.notifyPartnerClosed(ch)
+	notices := rs.NotifyHandler.GetNoticeChan()
+	assert.Len(t, notices, 1)
+	var info struct {
+		Type    int
+		Message *PartnerClosedEvent
+	}
+	assert.Nil(t, json.Unmarshal([]byte((<-notices).Info), &info))
+	assert.Equal(t, notify.InfoTypePartnerClosed, info.Type)
+	ev := info.Message
+	assert.Equal(t, partner, ev.PartnerAddress)
+	assert.EqualValues(t, 10, ev.ClosedBlock)
+	assert.EqualValues(t, 10+100+params.PunishBlockNumber, ev.SettleBlock)
+	assert.False(t, ev.AutoResponded)
+}
+
+func TestPartnerClosedWithStaleProof(t *testing.T) {
+	held := &transfer.BalanceProofState{
+		Nonce:          5,
+		TransferAmount: big.NewInt(30),
+		LocksRoot:      utils.NewRandomHash(),
+	}
+	//对方关闭时合约上还没有对方的 balance proof,或者只有旧的,都要提交我持有的
+	bp := partnerBalanceProofToSubmit(held, 0)
+	assert.NotNil(t, bp)
+	assert.EqualValues(t, 5, bp.Nonce)
+	bp = partnerBalanceProofToSubmit(held, 3)
+	assert.NotNil(t, bp)
+	assert.Equal(t, held.LocksRoot, bp.LocksRoot)
+	bp.Nonce = 100
+	assert.EqualValues(t, 5, held.Nonce, "must be a copy")
+	//合约上已经是最新的,不重复提交
+	assert.Nil(t, partnerBalanceProofToSubmit(held, 5))
+	//对方从来没有给我转过账
+	assert.Nil(t, partnerBalanceProofToSubmit(&transfer.BalanceProofState{}, 0))
+	assert.Nil(t, partnerBalanceProofToSubmit(nil, 0))
+}
+
+//fakeCloseResponder 合约上对方的 nonce 是 onChainNonce,记录提交的 balance proof
+type fakeCloseResponder struct {
+	onChainNonce uint64
+	updateErr    error
+	queried      int
+	updated      []uint64
+}
+
+func (f *fakeCloseResponder) GetChannelParticipantInfo(participant, partner common.Address) (deposit *big.Int, balanceHash common.Hash, nonce uint64, err error) {
+	f.queried++
+	return big.NewInt(0), utils.EmptyHash, f.onChainNonce, nil
+}
+
+func (f *fakeCloseResponder) UpdateBalanceProofAsync(partnerAddr common.Address, transferAmount *big.Int, locksRoot common.Hash, nonce uint64, extraHash common.Hash, signature []byte) *utils.AsyncResult {
+	f.updated = append(f.updated, nonce)
+	return utils.NewAsyncResultWithError(f.updateErr)
+}
+
+func TestRespondToPartnerClose(t *testing.T) {
+	our, partner := utils.NewRandomAddress(), utils.NewRandomAddress()
+	token := utils.NewRandomAddress()
+	ch, err := channel.NewChannel(channel.NewChannelEndState(our, big.NewInt(100), nil, mtree.EmptyTree),
+		channel.NewChannelEndState(partner, big.NewInt(100), nil, mtree.EmptyTree), &channel.ExternalState{}, token,
+		&contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash(), OpenBlockNumber: 3}, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := &Service{
+		NodeAddress:   our,
+		Config:        &params.Config{AutoRespondToClose: true},
+		NotifyHandler: notify.NewNotifyHandler(),
+		BlockNumber:   new(atomic.Value),
+	}
+	rs.BlockNumber.Store(int64(20))
+	ch.State = channeltype.StateClosed
+	ch.ExternState.SetClosed(10)
+
+	//对方从来没有给我转过账,不需要提交
+	f := &fakeCloseResponder{}
+	assert.Nil(t, <-rs.respondToPartnerClose(ch, f).Result)
+	assert.Equal(t, 0, f.queried)
+
+	ch.PartnerState.BalanceProofState = &transfer.BalanceProofState{
+		Nonce:          5,
+		TransferAmount: big.NewInt(30),
+		LocksRoot:      utils.NewRandomHash(),
+	}
+	//合约上已经是最新的
+	f = &fakeCloseResponder{onChainNonce: 5}
+	assert.Nil(t, <-rs.respondToPartnerClose(ch, f).Result)
+	assert.Equal(t, 1, f.queried)
+	assert.Len(t, f.updated, 0)
+
+	//对方关闭时提交的是旧的
+	f = &fakeCloseResponder{onChainNonce: 3}
+	assert.Nil(t, <-rs.respondToPartnerClose(ch, f).Result)
+	assert.Equal(t, []uint64{5}, f.updated)
+
+	//提交失败要通知用户手动提交
+	f = &fakeCloseResponder{updateErr: errors.New("tx failed")}
+	assert.EqualError(t, <-rs.respondToPartnerClose(ch, f).Result, "tx failed")
+	assert.Len(t, rs.NotifyHandler.GetNoticeChan(), 1)
+
+	//超过了 settle timeout,来不及提交
+	rs.BlockNumber.Store(int64(10 + ch.SettleTimeout))
+	f = &fakeCloseResponder{}
+	assert.NotNil(t, <-rs.respondToPartnerClose(ch, f).Result)
+	assert.Equal(t, 0, f.queried)
+}
@@ -0,0 +1,41 @@
+package photon
+
+import (
+	"fmt"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/utils"
+)
+
+/*
+Pause 暂停处理新的交易,用于数据库压缩,备份等维护工作,Resume 以后恢复,重启以后自动恢复.
+暂停期间不再处理:
+	1. 用户发起的交易,包括 direct transfer 和 token swap,返回 ErrPaused
+	2. 排队等待路由的交易不会启动,但是超过 deadline 仍然会失败
+	3. 收到的新 MediatedTransfer 和 DirectTransfer,不回复 ack,对方稍后会重发
+继续处理:
+	1. 已经开始的交易的后续消息,比如 SecretRequest,RevealSecret,UnLock,以及这些消息的 ack
+	2. 新块,链上事件,锁过期以后的 RemoveExpiredHashlock
+	3. 节点健康检查
+	4. 通道的创建,存款,close,settle,withdraw,cooperative settle 等链上操作
+*/
+func (rs *Service) Pause() error {
+	return <-rs.setPausedClient(true).Result
+}
+
+//Resume 恢复处理新的交易,见 Pause
+func (rs *Service) Resume() error {
+	return <-rs.setPausedClient(false).Result
+}
+
+//setPaused 只能在主线程中调用
+func (rs *Service) setPaused(paused bool) (result *utils.AsyncResult) {
+	if rs.paused != paused {
+		log.Info(fmt.Sprintf("%s paused=%v", utils.APex2(rs.NodeAddress), paused))
+	}
+	rs.paused = paused
+	if !paused {
+		rs.retryQueuedTransfers()
+	}
+	return utils.NewAsyncResultWithError(nil)
+}
@@ -0,0 +1,51 @@
+package photon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/encoding"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPause(t *testing.T) {
+	rs := &Service{
+		Config:      &params.Config{},
+		Clock:       utils.NewRealClock(),
+		NodeAddress: utils.NewRandomAddress(),
+	}
+	handle := func(name string, r interface{}) error {
+		req := &apiReq{
+			Name:   name,
+			Req:    r,
+			result: make(chan *utils.AsyncResult, 1),
+		}
+		rs.handleReq(req)
+		return <-(<-req.result).Result
+	}
+	assert.Nil(t, handle(setPausedReqName, &setPausedReq{Paused: true}))
+	assert.Equal(t, rerr.ErrPaused, handle(transferReqName, &transferReq{}))
+	assert.Equal(t, rerr.ErrPaused, handle(tokenSwapMakerReqName, &tokenSwapMakerReq{}))
+	assert.Equal(t, rerr.ErrPaused, handle(tokenSwapTakerReqName, &tokenSwapTakerReq{}))
+	result := rs.getLoopStats()
+	assert.Nil(t, <-result.Result)
+	assert.True(t, result.Tag.(*LoopStats).Paused)
+
+	mh := newPhotonMessageHandler(rs)
+	assert.Equal(t, rerr.ErrPaused, mh.messageMediatedTransfer(&encoding.MediatedTransfer{}))
+	assert.Equal(t, rerr.ErrPaused, mh.messageDirectTransfer(&encoding.DirectTransfer{}))
+
+	//排队的交易不会启动,但是超时仍然失败
+	rs.IsChainEffective = true
+	q := &queuedTransfer{Deadline: time.Now().Add(-time.Second), result: utils.NewAsyncResult()}
+	rs.queuedTransfers = map[common.Hash]*queuedTransfer{utils.NewRandomHash(): q}
+	rs.retryQueuedTransfers()
+	assert.NotNil(t, <-q.result.Result)
+
+	assert.Nil(t, handle(setPausedReqName, &setPausedReq{Paused: false}))
+	assert.False(t, rs.paused)
+}
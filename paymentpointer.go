@@ -0,0 +1,109 @@
+package photon
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//maxPaymentPointerLabelLen payment pointer 中 label 的最大长度
+const maxPaymentPointerLabelLen = 32
+
+//paymentPointerSeparator 分隔 label 和节点地址
+const paymentPointerSeparator = "$"
+
+/*
+normalizePaymentPointerLabel label 不区分大小写,只能包含小写字母,数字,'-','_' 和 '.'
+*/
+func normalizePaymentPointerLabel(label string) (string, error) {
+	label = strings.ToLower(strings.TrimSpace(label))
+	if len(label) == 0 || len(label) > maxPaymentPointerLabelLen {
+		return "", rerr.ErrArgumentError.Printf("payment pointer label must be 1 to %d characters", maxPaymentPointerLabelLen)
+	}
+	for _, c := range label {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '_' || c == '.' {
+			continue
+		}
+		return "", rerr.ErrArgumentError.Printf("payment pointer label %q contains invalid character %q", label, c)
+	}
+	return label, nil
+}
+
+/*
+RegisterPaymentPointer 登记一个收款标识,格式是 label$节点地址,比如 shop-1$0x...
+目录服务把它解析到我的节点,付款方把完整的 pointer 填写在交易的 Data 中,
+收到的交易会记录它对应的 pointer,GetReceivedTransfers 可以按 pointer 过滤.
+pointer 包含节点地址,不同节点之间不会冲突;同一个 label 重复登记返回同一个 pointer,
+所以商家可以放心地在每次启动时登记.
+可以在任意线程中调用
+*/
+func (rs *Service) RegisterPaymentPointer(label string) (pointer string, err error) {
+	label, err = normalizePaymentPointerLabel(label)
+	if err != nil {
+		return
+	}
+	if pp, err2 := rs.dao.GetPaymentPointer(label); err2 == nil {
+		return pp.Pointer, nil
+	}
+	pointer = label + paymentPointerSeparator + rs.NodeAddress.String()
+	err = rs.dao.SavePaymentPointer(&models.PaymentPointer{
+		Label:     label,
+		Pointer:   pointer,
+		TimeStamp: time.Now().Unix(),
+	})
+	if err != nil {
+		pointer = ""
+	}
+	return
+}
+
+//GetPaymentPointers 所有登记过的 payment pointer
+func (rs *Service) GetPaymentPointers() ([]*models.PaymentPointer, error) {
+	return rs.dao.GetPaymentPointerList()
+}
+
+/*
+resolvePaymentPointer data 是我登记过的 payment pointer 时返回这个 pointer,否则返回空字符串
+*/
+func (rs *Service) resolvePaymentPointer(data string) string {
+	i := strings.LastIndex(data, paymentPointerSeparator)
+	if i <= 0 {
+		return ""
+	}
+	addr := data[i+len(paymentPointerSeparator):]
+	if !common.IsHexAddress(addr) || common.HexToAddress(addr) != rs.NodeAddress {
+		return ""
+	}
+	pp, err := rs.dao.GetPaymentPointer(strings.ToLower(data[:i]))
+	if err != nil {
+		return ""
+	}
+	return pp.Pointer
+}
+
+/*
+recordPaymentPointer 收到的交易付给我的某个 payment pointer 时,记录下来.
+只能在主线程中调用
+*/
+func (rs *Service) recordPaymentPointer(rt *models.ReceivedTransfer) {
+	if rt == nil {
+		return
+	}
+	pointer := rs.resolvePaymentPointer(rt.Data)
+	if pointer == "" {
+		return
+	}
+	err := rs.dao.UpdateReceivedTransferPaymentPointer(rt.Key, pointer)
+	if err != nil {
+		log.Error(fmt.Sprintf("UpdateReceivedTransferPaymentPointer %s err %s", rt.Key, err))
+		return
+	}
+	rt.PaymentPointer = pointer
+	log.Info(fmt.Sprintf("receive %s of token %s for payment pointer %s", rt.Amount, utils.APex2(rt.TokenAddress), pointer))
+}
@@ -0,0 +1,64 @@
+package photon
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaymentPointer(t *testing.T) {
+	rs := &Service{
+		dao:         codefortest.NewTestDB(""),
+		NodeAddress: utils.NewRandomAddress(),
+	}
+	defer rs.dao.CloseDB()
+	api := NewPhotonAPI(rs)
+
+	for _, label := range []string{"", "shop 1", "shop$1", strings.Repeat("a", maxPaymentPointerLabelLen+1)} {
+		_, err := rs.RegisterPaymentPointer(label)
+		assert.NotNil(t, err, label)
+	}
+	pointer, err := rs.RegisterPaymentPointer("Shop-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "shop-1$"+rs.NodeAddress.String(), pointer)
+	//重复登记返回同一个 pointer
+	again, err := rs.RegisterPaymentPointer("shop-1")
+	assert.Nil(t, err)
+	assert.Equal(t, pointer, again)
+	_, err = rs.RegisterPaymentPointer("shop-2")
+	assert.Nil(t, err)
+	pps, err := rs.GetPaymentPointers()
+	assert.Nil(t, err)
+	assert.Len(t, pps, 2)
+
+	token, channelID := utils.NewRandomAddress(), utils.NewRandomHash()
+	receive := func(nonce uint64, data string) {
+		rt := rs.dao.NewReceivedTransfer(1, channelID, 3, token, utils.NewRandomAddress(), nonce, big.NewInt(10), utils.NewRandomHash(), data, "")
+		rs.recordPaymentPointer(rt)
+	}
+	receive(1, pointer)
+	receive(2, strings.ToUpper(pointer[:6])+pointer[6:])
+	receive(3, "shop-1$"+utils.NewRandomAddress().String()) //别的节点的 pointer
+	receive(4, "unknown$"+rs.NodeAddress.String())
+	receive(5, "")
+
+	all, err := api.GetReceivedTransfers(token, -1, -1, -1, -1, "")
+	assert.Nil(t, err)
+	assert.Len(t, all, 5)
+	trs, err := api.GetReceivedTransfers(token, -1, -1, -1, -1, pointer)
+	assert.Nil(t, err)
+	if assert.Len(t, trs, 2) {
+		for _, tr := range trs {
+			assert.Equal(t, pointer, tr.PaymentPointer)
+		}
+	}
+	trs, err = api.GetReceivedTransfers(token, -1, -1, -1, -1, "shop-2$"+rs.NodeAddress.String())
+	assert.Nil(t, err)
+	assert.Len(t, trs, 0)
+}
@@ -16,7 +16,6 @@ import (
 	"github.com/SmartMeshFoundation/Photon/rerr"
 	"github.com/SmartMeshFoundation/Photon/utils"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/pkg/errors"
 )
 
@@ -30,17 +29,28 @@ var ErrConnect = errors.New("pfsClient connect to pfs error")
 pfsClient :
 */
 type pfsClient struct {
-	host       string
-	privateKey *ecdsa.PrivateKey
+	host   string
+	signer utils.Signer
 }
 
 /*
 NewPfsProxy :
 */
 func NewPfsProxy(pfgHost string, privateKey *ecdsa.PrivateKey) (pfsProxy PfsProxy) {
+	var signer utils.Signer
+	if privateKey != nil {
+		signer = utils.NewPrivateKeySigner(privateKey)
+	}
+	return NewPfsProxyWithSigner(pfgHost, signer)
+}
+
+/*
+NewPfsProxyWithSigner : 私钥保存在外部时,通过 signer 签名
+*/
+func NewPfsProxyWithSigner(pfgHost string, signer utils.Signer) (pfsProxy PfsProxy) {
 	pfsProxy = &pfsClient{
-		host:       pfgHost,
-		privateKey: privateKey,
+		host:   pfgHost,
+		signer: signer,
 	}
 	return
 }
@@ -78,8 +88,7 @@ type balanceProof struct {
 	Signature         []byte      `json:"signature"`
 }
 
-func (p *submitBalancePayload) sign(key *ecdsa.PrivateKey) []byte {
-	var err error
+func (p *submitBalancePayload) sign(signer utils.Signer) (err error) {
 	buf := new(bytes.Buffer)
 	err = binary.Write(buf, binary.BigEndian, p.BalanceProof.Nonce)
 	_, err = buf.Write(utils.BigIntTo32Bytes(p.BalanceProof.TransferAmount))
@@ -93,18 +102,15 @@ func (p *submitBalancePayload) sign(key *ecdsa.PrivateKey) []byte {
 	if err != nil {
 		log.Error(fmt.Sprintf("signData err %s", err))
 	}
-	p.BalanceSignature, err = utils.SignData(key, buf.Bytes())
-	if err != nil {
-		panic(fmt.Sprintf("signDataFor submitBalancePayload err %s", err))
-	}
-	return p.BalanceSignature
+	p.BalanceSignature, err = signer.SignMessage(buf.Bytes())
+	return
 }
 
 /*
 SubmitBalance :
 */
 func (pfg *pfsClient) SubmitBalance(nonce uint64, transferAmount, lockAmount *big.Int, openBlockNumber int64, locksroot, channelIdentifier, additionHash common.Hash, proofSigner common.Address, signature []byte) (err error) {
-	if pfg.host == "" || pfg.privateKey == nil {
+	if pfg.host == "" || pfg.signer == nil {
 		return ErrNotInit
 	}
 	payload := &submitBalancePayload{
@@ -120,9 +126,12 @@ func (pfg *pfsClient) SubmitBalance(nonce uint64, transferAmount, lockAmount *bi
 		LockAmount:  lockAmount,
 		ProofSigner: proofSigner,
 	}
-	payload.sign(pfg.privateKey)
+	err = payload.sign(pfg.signer)
+	if err != nil {
+		return
+	}
 	req := &utils.Req{
-		FullURL: pfg.host + "/pfs/1/" + crypto.PubkeyToAddress(pfg.privateKey.PublicKey).String() + "/balance",
+		FullURL: pfg.host + "/pfs/1/" + pfg.signer.Address().String() + "/balance",
 		Method:  http.MethodPut,
 		Payload: utils.Marshal(payload),
 		Timeout: time.Second * 10,
@@ -155,8 +164,7 @@ type findPathPayload struct {
 	PeerFromChargeFee bool           `json:"peer_from_charge_fee"`
 }
 
-func (p *findPathPayload) sign(key *ecdsa.PrivateKey) []byte {
-	var err error
+func (p *findPathPayload) sign(signer utils.Signer) (err error) {
 	buf := new(bytes.Buffer)
 	_, err = buf.Write(p.PeerFrom[:])
 	_, err = buf.Write(p.PeerTo[:])
@@ -173,11 +181,8 @@ func (p *findPathPayload) sign(key *ecdsa.PrivateKey) []byte {
 	if err != nil {
 		log.Error(fmt.Sprintf("signData err %s", err))
 	}
-	p.Signature, err = utils.SignData(key, buf.Bytes())
-	if err != nil {
-		panic(fmt.Sprintf("signDataFor FindPathPayload err %s", err))
-	}
-	return p.Signature
+	p.Signature, err = signer.SignMessage(buf.Bytes())
+	return
 }
 
 // FindPathResponse :
@@ -201,7 +206,7 @@ func (fpr *FindPathResponse) GetPath() []common.Address {
 FindPath : find path
 */
 func (pfg *pfsClient) FindPath(peerFrom, peerTo, token common.Address, amount *big.Int, isInitiator bool) (resp []FindPathResponse, err error) {
-	if pfg.host == "" || pfg.privateKey == nil {
+	if pfg.host == "" || pfg.signer == nil {
 		err = ErrNotInit
 		return
 	}
@@ -214,7 +219,10 @@ func (pfg *pfsClient) FindPath(peerFrom, peerTo, token common.Address, amount *b
 		SortDemand:        "",
 		PeerFromChargeFee: !isInitiator,
 	}
-	payload.sign(pfg.privateKey)
+	err = payload.sign(pfg.signer)
+	if err != nil {
+		return
+	}
 	req := &utils.Req{
 		FullURL: pfg.host + "/pfs/1/paths",
 		Method:  http.MethodPost,
@@ -249,19 +257,15 @@ type setFeePayload struct {
 	Signature   []byte   `json:"signature"`
 }
 
-func (p *setFeePayload) sign(key *ecdsa.PrivateKey) []byte {
-	var err error
+func (p *setFeePayload) sign(signer utils.Signer) (err error) {
 	buf := new(bytes.Buffer)
 	err = binary.Write(buf, binary.BigEndian, p.FeePercent)
 	_, err = buf.Write(utils.BigIntTo32Bytes(p.FeeConstant))
 	if err != nil {
 		log.Error(fmt.Sprintf("signData err %s", err))
 	}
-	p.Signature, err = utils.SignData(key, buf.Bytes())
-	if err != nil {
-		panic(fmt.Sprintf("signDataFor SetFeeRatePayload err %s", err))
-	}
-	return p.Signature
+	p.Signature, err = signer.SignMessage(buf.Bytes())
+	return
 }
 
 // getFeeResponse :
@@ -275,12 +279,15 @@ type getFeeResponse struct {
 SetFeePolicy :set fee rate by account
 */
 func (pfg *pfsClient) SetFeePolicy(fp *models.FeePolicy) (err error) {
-	if pfg.host == "" || pfg.privateKey == nil {
+	if pfg.host == "" || pfg.signer == nil {
 		return ErrNotInit
 	}
-	fp.Sign(pfg.privateKey)
+	err = fp.SignBy(pfg.signer)
+	if err != nil {
+		return
+	}
 	req := &utils.Req{
-		FullURL: pfg.host + "/pfs/1/feerate/" + crypto.PubkeyToAddress(pfg.privateKey.PublicKey).String(),
+		FullURL: pfg.host + "/pfs/1/feerate/" + pfg.signer.Address().String(),
 		Method:  http.MethodPut,
 		Payload: utils.Marshal(fp),
 		Timeout: time.Second * 10,
@@ -304,16 +311,19 @@ func (pfg *pfsClient) SetFeePolicy(fp *models.FeePolicy) (err error) {
 SetAccountFeeRate :set fee rate by account
 */
 func (pfg *pfsClient) SetAccountFee(feeConstant *big.Int, feePercent int64) (err error) {
-	if pfg.host == "" || pfg.privateKey == nil {
+	if pfg.host == "" || pfg.signer == nil {
 		return ErrNotInit
 	}
 	payload := &setFeePayload{
 		FeeConstant: feeConstant,
 		FeePercent:  feePercent,
 	}
-	payload.sign(pfg.privateKey)
+	err = payload.sign(pfg.signer)
+	if err != nil {
+		return
+	}
 	req := &utils.Req{
-		FullURL: pfg.host + "/pfs/1/account_rate/" + crypto.PubkeyToAddress(pfg.privateKey.PublicKey).String(),
+		FullURL: pfg.host + "/pfs/1/account_rate/" + pfg.signer.Address().String(),
 		Method:  http.MethodPut,
 		Payload: utils.Marshal(payload),
 		Timeout: time.Second * 10,
@@ -336,12 +346,12 @@ func (pfg *pfsClient) SetAccountFee(feeConstant *big.Int, feePercent int64) (err
 GetAccountFee : get fee rate by account
 */
 func (pfg *pfsClient) GetAccountFee() (feeConstant *big.Int, feePercent int64, err error) {
-	if pfg.host == "" || pfg.privateKey == nil {
+	if pfg.host == "" || pfg.signer == nil {
 		err = ErrNotInit
 		return
 	}
 	req := &utils.Req{
-		FullURL: pfg.host + "/pfs/1/account_rate/" + crypto.PubkeyToAddress(pfg.privateKey.PublicKey).String(),
+		FullURL: pfg.host + "/pfs/1/account_rate/" + pfg.signer.Address().String(),
 		Method:  http.MethodGet,
 		Timeout: time.Second * 10,
 	}
@@ -368,16 +378,19 @@ func (pfg *pfsClient) GetAccountFee() (feeConstant *big.Int, feePercent int64, e
 SetTokenFee :set fee rate of a token
 */
 func (pfg *pfsClient) SetTokenFee(feeConstant *big.Int, feePercent int64, tokenAddress common.Address) (err error) {
-	if pfg.host == "" || pfg.privateKey == nil {
+	if pfg.host == "" || pfg.signer == nil {
 		return ErrNotInit
 	}
 	payload := &setFeePayload{
 		FeeConstant: feeConstant,
 		FeePercent:  feePercent,
 	}
-	payload.sign(pfg.privateKey)
+	err = payload.sign(pfg.signer)
+	if err != nil {
+		return
+	}
 	req := &utils.Req{
-		FullURL: pfg.host + "/pfs/1/token_rate/" + tokenAddress.String() + "/" + crypto.PubkeyToAddress(pfg.privateKey.PublicKey).String(),
+		FullURL: pfg.host + "/pfs/1/token_rate/" + tokenAddress.String() + "/" + pfg.signer.Address().String(),
 		Method:  http.MethodPut,
 		Payload: utils.Marshal(payload),
 		Timeout: time.Second * 10,
@@ -400,12 +413,12 @@ func (pfg *pfsClient) SetTokenFee(feeConstant *big.Int, feePercent int64, tokenA
 GetTokenFee : get fee rate by token
 */
 func (pfg *pfsClient) GetTokenFee(tokenAddress common.Address) (feeConstant *big.Int, feePercent int64, err error) {
-	if pfg.host == "" || pfg.privateKey == nil {
+	if pfg.host == "" || pfg.signer == nil {
 		err = ErrNotInit
 		return
 	}
 	req := &utils.Req{
-		FullURL: pfg.host + "/pfs/1/token_rate/" + tokenAddress.String() + "/" + crypto.PubkeyToAddress(pfg.privateKey.PublicKey).String(),
+		FullURL: pfg.host + "/pfs/1/token_rate/" + tokenAddress.String() + "/" + pfg.signer.Address().String(),
 		Method:  http.MethodGet,
 		Timeout: time.Second * 10,
 	}
@@ -432,16 +445,19 @@ func (pfg *pfsClient) GetTokenFee(tokenAddress common.Address) (feeConstant *big
 SetChannelFee :set fee rate of a channel
 */
 func (pfg *pfsClient) SetChannelFee(feeConstant *big.Int, feePercent int64, channelIdentifier common.Hash) (err error) {
-	if pfg.host == "" || pfg.privateKey == nil {
+	if pfg.host == "" || pfg.signer == nil {
 		return ErrNotInit
 	}
 	payload := &setFeePayload{
 		FeeConstant: feeConstant,
 		FeePercent:  feePercent,
 	}
-	payload.sign(pfg.privateKey)
+	err = payload.sign(pfg.signer)
+	if err != nil {
+		return
+	}
 	req := &utils.Req{
-		FullURL: pfg.host + "/pfs/1/channel_rate/" + channelIdentifier.String() + "/" + crypto.PubkeyToAddress(pfg.privateKey.PublicKey).String(),
+		FullURL: pfg.host + "/pfs/1/channel_rate/" + channelIdentifier.String() + "/" + pfg.signer.Address().String(),
 		Method:  http.MethodPut,
 		Payload: utils.Marshal(payload),
 		Timeout: time.Second * 10,
@@ -464,12 +480,12 @@ func (pfg *pfsClient) SetChannelFee(feeConstant *big.Int, feePercent int64, chan
 GetChannelFee : get fee rate by channel
 */
 func (pfg *pfsClient) GetChannelFee(channelIdentifier common.Hash) (feeConstant *big.Int, feePercent int64, err error) {
-	if pfg.host == "" || pfg.privateKey == nil {
+	if pfg.host == "" || pfg.signer == nil {
 		err = ErrNotInit
 		return
 	}
 	req := &utils.Req{
-		FullURL: pfg.host + "/pfs/1/channel_rate/" + channelIdentifier.String() + "/" + crypto.PubkeyToAddress(pfg.privateKey.PublicKey).String(),
+		FullURL: pfg.host + "/pfs/1/channel_rate/" + channelIdentifier.String() + "/" + pfg.signer.Address().String(),
 		Method:  http.MethodGet,
 		Timeout: time.Second * 10,
 	}
@@ -10,10 +10,12 @@ import (
 
 	"encoding/binary"
 
+	"errors"
 	"fmt"
 
 	"github.com/SmartMeshFoundation/Photon/codefortest"
 	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/models"
 	"github.com/SmartMeshFoundation/Photon/params"
 	"github.com/SmartMeshFoundation/Photon/utils"
 	"github.com/ethereum/go-ethereum/common"
@@ -207,3 +209,55 @@ func TestPfsClient_GetChannelFee(t *testing.T) {
 	}
 	fmt.Println(feeConstant, feePercent)
 }
+
+type failingSigner struct {
+	addr common.Address
+}
+
+func (s *failingSigner) SignMessage(data []byte) ([]byte, error) {
+	return nil, errors.New("signer unavailable")
+}
+func (s *failingSigner) Address() common.Address {
+	return s.addr
+}
+
+//签名使用 signer,失败时不发出请求
+func TestPfsClientSigner(t *testing.T) {
+	key, addr := utils.MakePrivateKeyAddress()
+	payload := &setFeePayload{FeeConstant: big.NewInt(5), FeePercent: 10000}
+	err := payload.sign(utils.NewPrivateKeySigner(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, payload.FeePercent)
+	buf.Write(utils.BigIntTo32Bytes(payload.FeeConstant))
+	sig, err := utils.SignData(key, buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sig, payload.Signature) {
+		t.Error("signature not match")
+	}
+
+	//host 不可达,签名失败的话不会走到发送请求
+	c := NewPfsProxyWithSigner("http://127.0.0.1:1", &failingSigner{addr: addr})
+	for _, err = range []error{
+		c.SetAccountFee(big.NewInt(5), 10000),
+		c.SetTokenFee(big.NewInt(5), 10000, utils.NewRandomAddress()),
+		c.SetChannelFee(big.NewInt(5), 10000, utils.NewRandomHash()),
+		c.SetFeePolicy(models.NewDefaultFeePolicy()),
+		c.SubmitBalance(1, big.NewInt(1), big.NewInt(0), 3, utils.EmptyHash, utils.NewRandomHash(), utils.EmptyHash, addr, nil),
+	} {
+		if err == nil || err.Error() != "signer unavailable" {
+			t.Errorf("expect signer error,got %v", err)
+		}
+	}
+	_, err = c.FindPath(addr, utils.NewRandomAddress(), utils.NewRandomAddress(), big.NewInt(1), true)
+	if err == nil || err.Error() != "signer unavailable" {
+		t.Errorf("expect signer error,got %v", err)
+	}
+	if NewPfsProxy("http://127.0.0.1:1", nil).SetAccountFee(big.NewInt(5), 10000) != ErrNotInit {
+		t.Error("expect ErrNotInit without key")
+	}
+}
@@ -7,6 +7,7 @@ import (
 
 	"time"
 
+	"sync"
 	"sync/atomic"
 
 	"math/big"
@@ -54,6 +55,7 @@ message sent complete notification
 type protocolMessage struct {
 	receiver common.Address
 	Message  encoding.Messager
+	err      error //nil 表示对方已经收到,否则是放弃发送的原因
 }
 
 // BuildInfo 保存构建信息
@@ -100,6 +102,26 @@ type Service struct {
 	/*
 	 */
 	PrivateKey            *ecdsa.PrivateKey
+	Signer                utils.Signer //all messages to other nodes are signed by Signer
+	feeQuotes             *feeQuoteCache
+	queuedTransfers       map[common.Hash]*queuedTransfer //transfers waiting for an available route
+	queueChanged          bool                            //channels changed,retry queued transfers,see channelChanged
+	timedTransfers        map[common.Hash]*timedTransfer  //transfers canceled automatically if secret is not revealed before deadline
+	heldReveals           []*heldReveal                   //secret requests/reveals held while eth is disconnected
+	secretsRegistering    map[common.Hash]int64           //secret -> block number after which the lock must have expired
+	routeDenylist         map[common.Address]bool         //nodes never used as intermediate hops
+	duplicateTransfers    *duplicateTransferTracker       //mediated transfers received again as target
+	paused                bool                            //new transfers are refused,see Pause
+	pendingTokenSwaps     map[swapKey]*pendingTokenSwap   //token swaps I take part in,see GetPendingTokenSwaps
+	sendCompletions       *sendCompletionPool             //goroutines waiting for results of sendAsync
+	ackHelper             *AckHelper                      //acks of received messages,pruned by block number
+	startupProgress       StartupProgress                 //guarded by startupProgressLock,readable before Start returns
+	startupProgressLock   sync.Mutex
+	reachableTargetsCache map[reachableTargetsKey]*reachableTargetsEntry //results of GetReachableTargets,expire after reachableTargetsCacheTTL
+	Clock                 utils.Clock                                    //tests can replace it to drive time deterministically
+	reqSequencer          *reqSequencer                                  //user requests on the same channel are FIFO
+	ethStatusChan         <-chan netshare.Status                         //connection status of Chain.Client,see ServiceManager
+	ethClientShared       bool                                           //Chain.Client is owned by a ServiceManager,do not close it on Stop
 	NodeAddress           common.Address
 	Token2ChannelGraph    map[common.Address]*graph.ChannelGraph
 	Token2TokenNetwork    map[common.Address]common.Address
@@ -115,6 +137,12 @@ type Service struct {
 	Token2LockSecretHash2Channels map[common.Address]map[common.Hash][]*channel.Channel
 	FileLocker                    *flock.Flock
 	BlockNumber                   *atomic.Value
+	blockNumberSubscribers        *blockNumberSubscribers
+	receivedTransferSubscribers   *receivedTransferSubscribers
+	channelSettledSubscribers     *channelSettledSubscribers
+	networkReachability           *networkReachability
+	balanceHistory                *balanceHistory
+	directChannelRoundRobin       directChannelRoundRobin
 	/*
 		chan for user request
 	*/
@@ -133,7 +161,9 @@ type Service struct {
 	*/
 	ReceivedMediatedTrasnferListenerMap   map[*ReceivedMediatedTrasnferListener]bool //for tokenswap
 	SentMediatedTransferListenerMap       map[*SentMediatedTransferListener]bool     //for tokenswap
-	HealthCheckMap                        map[common.Address]bool
+	HealthCheckMap                        map[common.Address]chan struct{}           //健康检查的goroutine,关闭chan让它退出
+	healthCheckNow                        map[common.Address]chan time.Time          //让健康检查立即 ping,不用等下一轮
+	healthCheckLock                       sync.Mutex
 	quitChan                              chan struct{} //for quit notification
 	isStarting                            bool
 	StopCreateNewTransfers                bool // 是否停止接收新交易,默认false,目前仅在用户调用prepare-update接口的时候,会被置为true,直到重启		// boolean to check whether stop receiving new transfers, default to false. Currently it sets to true when clients invoke prepare-update, till it reconnects.
@@ -179,7 +209,8 @@ func NewPhotonService(chain *rpc.BlockChainService, privateKey *ecdsa.PrivateKey
 		RevealSecretListenerMap:               make(map[common.Hash]RevealSecretListener),
 		ReceivedMediatedTrasnferListenerMap:   make(map[*ReceivedMediatedTrasnferListener]bool),
 		SentMediatedTransferListenerMap:       make(map[*SentMediatedTransferListener]bool),
-		HealthCheckMap:                        make(map[common.Address]bool),
+		HealthCheckMap:                        make(map[common.Address]chan struct{}),
+		healthCheckNow:                        make(map[common.Address]chan time.Time),
 		quitChan:                              make(chan struct{}),
 		isStarting:                            true,
 		StopCreateNewTransfers:                false,
@@ -189,11 +220,48 @@ func NewPhotonService(chain *rpc.BlockChainService, privateKey *ecdsa.PrivateKey
 		ChanSubmitBalanceProofToPFS:           make(chan *channel.Channel, 100),
 		ChanSubmitDelegateToPMS:               make(chan *channel.Channel, 100),
 		IsChainEffective:                      false,
+		feeQuotes:                             newFeeQuoteCache(),
+		queuedTransfers:                       make(map[common.Hash]*queuedTransfer),
+		timedTransfers:                        make(map[common.Hash]*timedTransfer),
+		pendingTokenSwaps:                     make(map[swapKey]*pendingTokenSwap),
+		secretsRegistering:                    make(map[common.Hash]int64),
+		routeDenylist:                         make(map[common.Address]bool),
+		duplicateTransfers:                    newDuplicateTransferTracker(),
+		Clock:                                 utils.NewRealClock(),
+		reqSequencer:                          newReqSequencer(),
+		blockNumberSubscribers:                newBlockNumberSubscribers(),
+		receivedTransferSubscribers:           newReceivedTransferSubscribers(),
+		channelSettledSubscribers:             newChannelSettledSubscribers(),
+		networkReachability:                   newNetworkReachability(),
+		balanceHistory:                        newBalanceHistory(),
+	}
+	rs.sendCompletions = newSendCompletionPool(config.SendCompletionWorkers, rs.ProtocolMessageSendComplete, rs.quitChan)
+	rs.Signer = config.Signer
+	if rs.Signer == nil {
+		rs.Signer = utils.NewPrivateKeySigner(privateKey)
+	}
+	if rs.Signer.Address() != rs.NodeAddress {
+		err = rerr.ErrArgumentError.Errorf("signer address %s not match private key %s", rs.Signer.Address().String(), rs.NodeAddress.String())
+		return
+	}
+	err = checkTokenSettleTimeouts(config.TokenSettleTimeouts, rs.getMinSettleTimeout(), config.RevealTimeout)
+	if err != nil {
+		return
 	}
 	rs.BlockNumber.Store(int64(0))
+	rs.ethStatusChan = chain.Client.StatusChan
 	rs.MessageHandler = newPhotonMessageHandler(rs)
 	rs.StateMachineEventHandler = newStateMachineEventHandler(rs)
 	rs.Protocol = network.NewPhotonProtocol(transport, privateKey, rs)
+	rs.Protocol.SetSigner(rs.Signer)
+	rs.Protocol.SetCompressThreshold(config.MessageCompressThreshold)
+	rs.Protocol.SetAckFailureThreshold(config.AckFailureThreshold)
+	if config.AcceptedMessageVersions != nil {
+		err = rs.Protocol.SetAcceptedMessageVersions(config.AcceptedMessageVersions)
+		if err != nil {
+			return
+		}
+	}
 	//todo fixme MatrixTransport should have a better contructor function
 	mtransport, ok := rs.Transport.(*network.MatrixMixTransport)
 	if ok {
@@ -202,7 +270,17 @@ func NewPhotonService(chain *rpc.BlockChainService, privateKey *ecdsa.PrivateKey
 			return
 		}
 	}
-	rs.Protocol.SetReceivedMessageSaver(NewAckHelper(rs.dao))
+	rs.ackHelper = NewAckHelper(rs.dao)
+	rs.ackHelper.SetRetentionBlocks(config.AckRetentionBlocks)
+	rs.Protocol.SetReceivedMessageSaver(rs.ackHelper)
+	gasLimits := make(map[models.TXInfoType]uint64, len(config.GasLimits))
+	for op, limit := range config.GasLimits {
+		gasLimits[models.TXInfoType(op)] = limit
+	}
+	err = rs.Chain.SetGasLimits(gasLimits)
+	if err != nil {
+		return
+	}
 	/*
 		only one instance for one data directory
 	*/
@@ -218,12 +296,17 @@ func NewPhotonService(chain *rpc.BlockChainService, privateKey *ecdsa.PrivateKey
 	if err != nil {
 		return
 	}
+	for _, addr := range rs.dao.GetRouteDenylist() {
+		rs.routeDenylist[addr] = true
+	}
 	rs.BlockChainEvents = blockchain.NewBlockChainEvents(chain.Client, chain, rs.dao)
+	rs.BlockChainEvents.ConfirmationBlocks = config.ConfirmationBlocks
+	rs.BlockChainEvents.SetPollPeriod(config.BlockPollInterval)
 	// fee module
 	if config.EnableMediationFee {
 		// pathfinder
 		if config.PfsHost != "" {
-			rs.PfsProxy = pfsproxy.NewPfsProxy(config.PfsHost, rs.PrivateKey)
+			rs.PfsProxy = pfsproxy.NewPfsProxyWithSigner(config.PfsHost, rs.Signer)
 		}
 		rs.FeePolicy, err = NewFeeModule(dao, rs.PfsProxy)
 		if err != nil {
@@ -263,36 +346,10 @@ func (rs *Service) Start() (err error) {
 	rs.Protocol.Start(false)
 	//restore 一定要在历史事件处理之前进行,比如链上注册密码事件,需要相应的statemanager发送unlock消息
 	rs.restore()
+	//主循环处理完历史事件以后会把 ChanHistoryContractEventsDealComplete 置为 nil,所以要在启动主循环之前取出来
+	historyEventsComplete := rs.ChanHistoryContractEventsDealComplete
 	go func() {
-		if rs.Config.ConditionQuit.RandomQuit {
-			go func() {
-				isPrime := func(value int) bool {
-					if value <= 3 {
-						return value >= 2
-					}
-					if value%2 == 0 || value%3 == 0 {
-						return false
-					}
-					for i := 5; i*i <= value; i += 6 {
-						if value%i == 0 || value%(i+2) == 0 {
-							return false
-						}
-					}
-					return true
-				}
-				for {
-					/*
-							Random sleep is no more than five seconds. If the number of dormancy milliseconds is prime,
-						    it will exit directly. This probability is probably 13%.
-					*/
-					n := utils.NewRandomInt(5000)
-					time.Sleep(time.Duration(n) * time.Millisecond)
-					if isPrime(n) {
-						panic("random quit")
-					}
-				}
-			}()
-		}
+		rs.startChaos()
 		rs.loop()
 	}()
 
@@ -300,12 +357,22 @@ func (rs *Service) Start() (err error) {
 	// 如果状态不为connected,则直接启动api以及订阅其他节点的消息,这样做可能带来的风险:
 	// 1. 积压事件处理完毕之前,用户/其他节点通过api/消息对本地数据作出修改,是否会给后续的链上事件同步工作带来问题???
 	// Here if status is connected, then after block events completes, we should restart api and subscribe messages from other nodes.
+	historyEventsDone := true
 	if rs.Chain.Client.Status == netshare.Connected {
 		//wait for start up complete.
-		<-rs.ChanHistoryContractEventsDealComplete
-		log.Info(fmt.Sprintf("Photon Startup complete and history events process complete."))
+		historyEventsDone = rs.waitHistoryEvents(historyEventsComplete)
+		if historyEventsDone {
+			log.Info(fmt.Sprintf("Photon Startup complete and history events process complete."))
+		}
 	}
 
+	/*
+		开始接收消息之前,数据库中所有的 token 和通道都已经加载(registerRegistry),StateManager 也已经恢复(restore),
+		公链连接正常时,积压的链上事件也已经处理完毕.
+		公链没有连接时只能使用数据库中的状态,这时候 IsChainEffective 为 false,MediatedTransfer 会被拒绝.
+		通道或者 token 未知的消息都会返回错误,不回复 ack,对方会在稍后重发,所以启动过程中收到的消息不会丢失,也不会作用在不完整的状态上.
+		AnnounceDisposed 例外,这时候通道未知说明已经 settle,回复 ack 但是不处理.
+	*/
 	/*
 		将protocol接受消息移到历史事件处理之后,
 		保证不在历史事件处理完毕之前进入事件主循环.
@@ -314,7 +381,7 @@ func (rs *Service) Start() (err error) {
 		虽然实际中可能会尝试重发,但是会让测试代码进入一种不确定的等待.
 		这么做有可能因为接收到过多的消息,而阻塞接受线程,导致消息丢失.但是因为没有处理,对方一定会反复重新发送.
 	*/
-	rs.Protocol.StartReceive()
+	rs.startReceiving(historyEventsComplete, historyEventsDone, rs.Protocol.StartReceive)
 	/*
 		启动定时提交balance_proof到pfs及pms的线程
 	*/
@@ -322,7 +389,15 @@ func (rs *Service) Start() (err error) {
 	go rs.submitDelegateToPmsLoop()
 	//
 	rs.isStarting = false
+	rs.updateStartupProgress(func(p *StartupProgress) {
+		p.Ready = true
+	})
 	rs.startNeighboursHealthCheck()
+	// 不用等健康检查,立即告诉邻居我已经上线
+	err = rs.AnnouncePresence()
+	if err != nil {
+		log.Warn(fmt.Sprintf("AnnouncePresence err %s", err))
+	}
 	// 只有在混合模式下启动时,才订阅其他节点的在线状态
 	// Only when starting under MixUDPXMPP, we can subscribe online status of other nodes.
 	if rs.Config.NetworkMode == params.MixUDPXMPP || rs.Config.NetworkMode == params.MixUDPMatrix {
@@ -332,13 +407,23 @@ func (rs *Service) Start() (err error) {
 	return nil
 }
 
+/*
+SetPanicHandler 主循环,消息发送,健康检查等 goroutine 中被捕获的 panic 除了记录日志,还会交给 handler,
+用于上报到监控系统. 对进程中所有的 Service 都有效, nil 表示只记录日志
+*/
+func (rs *Service) SetPanicHandler(handler func(context string, recovered interface{}, stack []byte)) {
+	rpanic.SetPanicHandler(handler)
+}
+
 //Stop the node.
 func (rs *Service) Stop() {
 	log.Info("photon service stop...")
 	close(rs.quitChan)
 	rs.Protocol.StopAndWait()
 	rs.BlockChainEvents.Stop()
-	rs.Chain.Client.Close()
+	if !rs.ethClientShared {
+		rs.Chain.Client.Close()
+	}
 	rs.NotifyHandler.Stop()
 	time.Sleep(100 * time.Millisecond) // let other goroutines quit
 	rs.dao.CloseDB()
@@ -367,6 +452,8 @@ func (rs *Service) loop() {
 
 	defer rpanic.PanicRecover("photon service")
 	for {
+		//上一个事件改变了通道或者余额的话,排队的交易可能有路由了
+		rs.retryQueuedTransfersIfChanged()
 		select {
 		//message from other nodes
 		case m, ok = <-rs.Protocol.ReceivedMessageChan:
@@ -425,7 +512,7 @@ func (rs *Service) loop() {
 				log.Info("ProtocolMessageSendComplete closed")
 				return
 			}
-		case s := <-rs.Chain.Client.StatusChan:
+		case s := <-rs.ethStatusChan:
 			if s == netshare.Connected {
 				rs.handleEthRPCConnectionOK()
 			} else {
@@ -447,12 +534,37 @@ func (rs *Service) registerRegistry() (err error) {
 		err = fmt.Errorf("registerRegistry err:%s", err)
 		return
 	}
-	for token := range token2TokenNetworks {
-		err = rs.registerTokenNetwork(token)
+	return rs.registerTokenNetworks(token2TokenNetworks, rs.Chain.TokenNetwork)
+}
+
+/*
+registerTokenNetworks 创建不了 TokenNetworkProxy 的 token 跳过并给出警告,不影响其他 token 正常启动,
+等下次启动再加载; 读取数据库出错则启动失败.
+*/
+func (rs *Service) registerTokenNetworks(tokens models.AddressMap, newTokenNetwork func(tokenAddress common.Address) (*rpc.TokenNetworkProxy, error)) error {
+	rs.updateStartupProgress(func(p *StartupProgress) {
+		p.TotalTokens = len(tokens)
+	})
+	for token := range tokens {
+		tokenNetwork, err := newTokenNetwork(token)
+		if err == nil && tokenNetwork == nil {
+			err = fmt.Errorf("empty token network")
+		}
 		if err != nil {
-			err = fmt.Errorf("registerTokenNetwork err:%s", err)
-			return
+			log.Warn(fmt.Sprintf("skip token %s,cannot create token network err %s", utils.APex2(token), err))
+			delete(rs.Token2TokenNetwork, token)
+			rs.updateStartupProgress(func(p *StartupProgress) {
+				p.SkippedTokens++
+			})
+			continue
+		}
+		err = rs.registerTokenNetwork(token, tokenNetwork)
+		if err != nil {
+			return fmt.Errorf("registerTokenNetwork err:%s", err)
 		}
+		rs.updateStartupProgress(func(p *StartupProgress) {
+			p.LoadedTokens++
+		})
 	}
 	return nil
 }
@@ -480,7 +592,12 @@ func (rs *Service) newChannelFromEvent(tokenNetwork *rpc.TokenNetworkProxy, toke
 	partenerState := channel.NewChannelEndState(partnerAddress, big.NewInt(0), nil, mtree.NewMerkleTree(nil))
 
 	externState := channel.NewChannelExternalState(rs.registerChannelForHashlock, tokenNetwork, channelIdentifier, rs.PrivateKey, rs.Chain.Client, rs.dao, 0, rs.NodeAddress, partnerAddress)
+	externState.SetSigner(rs.Signer)
 	ch, err = channel.NewChannel(ourState, partenerState, externState, tokenAddress, channelIdentifier, rs.Config.RevealTimeout, settleTimeout)
+	if err != nil {
+		return
+	}
+	ch.MaxPendingLocks = rs.Config.MaxPendingLocksPerChannel
 	return
 }
 
@@ -500,14 +617,71 @@ func (rs *Service) handleBlockNumber(st *transfer.BlockStateChange) {
 		}
 	}
 	rs.dao.SaveLatestBlockNumber(st.BlockNumber)
+	rs.pruneAcks(st.BlockNumber)
+	rs.registerSecretsNearExpiration(st.BlockNumber)
+	rs.retryQueuedTransfers()
+	rs.cancelTimedOutTransfers()
+	rs.releaseHeldReveals()
+	rs.blockNumberSubscribers.publish(st.BlockNumber)
 	return
 }
 
+/*
+registerSecretsNearExpiration 作为接收方或者中间节点,已经知道密码但是对方一直没有unlock,
+锁在RevealTimeout之内就要过期时,主动到链上注册密码,否则锁过期以后就拿不到这笔钱了.
+交易对应的statemanager可能已经不在了,所以这里直接检查通道中的锁.
+每个密码只发起一次注册,锁过期以后清除记录.
+*/
+func (rs *Service) registerSecretsNearExpiration(blockNumber int64) {
+	for secret, expiration := range rs.secretsRegistering {
+		if blockNumber > expiration {
+			delete(rs.secretsRegistering, secret)
+		}
+	}
+	if !rs.isSafeToTransact() {
+		return
+	}
+	for _, g := range rs.Token2ChannelGraph {
+		for _, c := range g.ChannelIdentifier2Channel {
+			if c.State != channeltype.StateOpened && c.State != channeltype.StateClosed {
+				continue
+			}
+			for _, secret := range c.GetNeedRegisterSecrets(blockNumber) {
+				if _, ok := rs.secretsRegistering[secret]; ok {
+					continue
+				}
+				rs.secretsRegistering[secret] = blockNumber + int64(c.RevealTimeout)
+				log.Info(fmt.Sprintf("lock on channel %s is about to expire, register secret on chain", c.ChannelIdentifier.String()),
+					utils.TransferLogCtx(utils.ShaSecret(secret[:]), c.TokenAddress)...)
+				err := rs.StateMachineEventHandler.eventContractSendRegisterSecret(&mediatedtransfer.EventContractSendRegisterSecret{
+					Secret: secret,
+				})
+				if err != nil {
+					log.Error(fmt.Sprintf("register secret on chain err %s", err))
+					delete(rs.secretsRegistering, secret)
+				}
+			}
+		}
+	}
+}
+
 //GetBlockNumber return latest blocknumber of ethereum
 func (rs *Service) GetBlockNumber() int64 {
 	return rs.BlockNumber.Load().(int64)
 }
 
+/*
+SetBlockPollInterval 修改查询公链新块的周期,0表示按链使用默认值,下一次查询开始生效.
+可以在任意线程中调用
+*/
+func (rs *Service) SetBlockPollInterval(interval time.Duration) error {
+	if interval < 0 {
+		return rerr.ErrArgumentError.Printf("block poll interval must not be negative,got %s", interval)
+	}
+	rs.BlockChainEvents.SetPollPeriod(interval)
+	return nil
+}
+
 // GetChannelStatus return status of channel
 func (rs *Service) GetChannelStatus(channelIdentifier common.Hash) (int, int64) {
 	c := rs.getChannelWithAddr(channelIdentifier)
@@ -535,6 +709,14 @@ Send `message` to `recipient` using the photon protocol.
        tries.
 */
 func (rs *Service) sendAsync(recipient common.Address, msg encoding.SignedMessager) error {
+	return rs.sendAsyncWithPolicy(recipient, msg, network.SendPolicy{})
+}
+
+/*
+sendAsyncWithPolicy 与 sendAsync 相同,但是按照 policy 重发,
+超过最大发送次数以后放弃,和通道已经无效时一样,只记录错误日志
+*/
+func (rs *Service) sendAsyncWithPolicy(recipient common.Address, msg encoding.SignedMessager, policy network.SendPolicy) error {
 	if recipient == rs.NodeAddress {
 		log.Error(fmt.Sprintf("rs must be a bug ,sending message to it self"))
 	}
@@ -551,23 +733,49 @@ func (rs *Service) sendAsync(recipient common.Address, msg encoding.SignedMessag
 	if ok && envelopMessager != nil {
 		rs.dao.NewSentEnvelopMessager(envelopMessager, recipient)
 	}
-	result := rs.Protocol.SendAsync(recipient, msg)
-	go func() {
-		defer rpanic.PanicRecover(fmt.Sprintf("send %s, msg:%s", utils.APex(recipient), msg))
-		err := <-result.Result //如果通道已经settle,那么这个消息是没必要再发送了.这时候会失败
-		if err == nil {
-			rs.ProtocolMessageSendComplete <- &protocolMessage{
-				receiver: recipient,
-				Message:  msg,
-			}
-		} else {
-			log.Error(fmt.Sprintf("message %s send finished ,but err=%s", utils.StringInterface(msg, 3), err))
-		}
-
-	}()
+	rs.saveTransferMessage(msg, true)
+	logCtx := append(rs.messageLogCtx(msg), "to", utils.APex2(recipient))
+	log.Trace(fmt.Sprintf("send %s", encoding.MessageType(msg.Cmd())), logCtx...)
+	result := rs.Protocol.SendAsyncWithPolicy(recipient, msg, policy)
+	if rs.sendCompletions == nil {
+		rs.sendCompletions = newSendCompletionPool(0, rs.ProtocolMessageSendComplete, rs.quitChan)
+	}
+	rs.sendCompletions.add(result, &pendingSend{
+		recipient: recipient,
+		msg:       msg,
+		logCtx:    logCtx,
+	})
 	return nil
 }
 
+/*
+messageLogCtx 交易相关消息的日志字段,token 从消息所在的通道获取
+*/
+func (rs *Service) messageLogCtx(msg encoding.SignedMessager) []interface{} {
+	var lockSecretHash, channelIdentifier common.Hash
+	switch m := msg.(type) {
+	case *encoding.MediatedTransfer:
+		lockSecretHash, channelIdentifier = m.LockSecretHash, m.ChannelIdentifier
+	case *encoding.UnLock:
+		lockSecretHash, channelIdentifier = m.LockSecretHash(), m.ChannelIdentifier
+	case *encoding.AnnounceDisposed:
+		lockSecretHash, channelIdentifier = m.Lock.LockSecretHash, m.ChannelIdentifier
+	case *encoding.RemoveExpiredHashlockTransfer:
+		lockSecretHash, channelIdentifier = m.LockSecretHash, m.ChannelIdentifier
+	case *encoding.SecretRequest:
+		lockSecretHash = m.LockSecretHash
+	case *encoding.RevealSecret:
+		lockSecretHash = m.LockSecretHash()
+	default:
+		return nil
+	}
+	token := utils.EmptyAddress
+	if ch := rs.getChannelWithAddr(channelIdentifier); ch != nil {
+		token = ch.TokenAddress
+	}
+	return utils.TransferLogCtx(lockSecretHash, token)
+}
+
 /*
 SendAndWait Send `message` to `recipient` and wait for the response or `timeout`.
 
@@ -585,6 +793,26 @@ func (rs *Service) SendAndWait(recipient common.Address, message encoding.Signed
 	return rs.Protocol.SendAndWait(recipient, message, timeout)
 }
 
+//SendAndWaitWithPolicy same as SendAndWait, but `message` is resent according to `policy` instead of the default one
+func (rs *Service) SendAndWaitWithPolicy(recipient common.Address, message encoding.SignedMessager, timeout time.Duration, policy network.SendPolicy) error {
+	return rs.Protocol.SendAndWaitWithPolicy(recipient, message, timeout, policy)
+}
+
+/*
+handshakeSendPolicy 合作关闭,取现请求的重发策略,
+应答只使用重发间隔,不限制次数,否则对方可能永远等不到我的签名
+*/
+func (rs *Service) handshakeSendPolicy(isResponse bool) network.SendPolicy {
+	policy := network.SendPolicy{
+		RetryInterval: rs.Config.HandshakeRetryInterval,
+		MaxAttempts:   rs.Config.HandshakeMaxAttempts,
+	}
+	if isResponse {
+		policy.MaxAttempts = 0
+	}
+	return policy
+}
+
 /*
 Register the secret with any channel that has a hashlock on it.
 
@@ -658,10 +886,12 @@ func (rs *Service) channelSerilization2Channel(c *channeltype.Serialization, tok
 		c.ChannelIdentifier, rs.PrivateKey,
 		rs.Chain.Client, rs.dao, c.ClosedBlock,
 		c.OurAddress, c.PartnerAddress())
+	ExternState.SetSigner(rs.Signer)
 	ch, err = channel.NewChannel(OurState, PartnerState, ExternState, c.TokenAddress(), c.ChannelIdentifier, c.RevealTimeout, c.SettleTimeout)
 	if err != nil {
 		return
 	}
+	ch.MaxPendingLocks = rs.Config.MaxPendingLocksPerChannel
 
 	ch.OurState.Lock2PendingLocks = c.OurLock2PendingLocks()
 	ch.OurState.Lock2UnclaimedLocks = c.OurLock2UnclaimedLocks()
@@ -670,54 +900,52 @@ func (rs *Service) channelSerilization2Channel(c *channeltype.Serialization, tok
 	ch.State = c.State
 	ch.OurState.ContractBalance = c.OurContractBalance
 	ch.PartnerState.ContractBalance = c.PartnerContractBalance
+	ch.OurState.DepositBlockNumber = c.OurDepositBlockNumber
+	ch.PartnerState.DepositBlockNumber = c.PartnerDepositBlockNumber
 	ch.ExternState.ClosedBlock = c.ClosedBlock
 	ch.ExternState.SettledBlock = c.SettledBlock
 	return
 }
 
 //read a token network info from dao
-func (rs *Service) registerTokenNetwork(tokenAddress common.Address) (err error) {
+func (rs *Service) registerTokenNetwork(tokenAddress common.Address, tokenNetwork *rpc.TokenNetworkProxy) (err error) {
 	log.Trace(fmt.Sprintf("registerTokenNetwork tokenaddress=%s ", tokenAddress.String()))
-	var tokenNetwork *rpc.TokenNetworkProxy
-	tokenNetwork, err = rs.Chain.TokenNetwork(tokenAddress)
-	if err != nil {
-		return
-	}
 	edges, err := rs.dao.GetAllNonParticipantChannelByToken(tokenAddress)
 	if err != nil {
 		return
 	}
 	g := graph.NewChannelGraph(rs.NodeAddress, tokenAddress, edges)
+	g.BalanceAwareRouting = rs.Config.BalanceAwareRouting
 	rs.Token2TokenNetwork[tokenAddress] = utils.EmptyAddress
 	rs.Token2ChannelGraph[tokenAddress] = g
 	//add channel I participant
-	var css []*channeltype.Serialization
-	css, err = rs.dao.GetChannelList(tokenAddress, utils.EmptyAddress)
-	if err != nil {
-		return
-	}
-
-	for _, cs := range css {
-		//跳过已经 settle 的 channel 加入没有任何意义.
-		if cs.State == channeltype.StateSettled {
-			continue
-		}
-		ch, err := rs.channelSerilization2Channel(cs, tokenNetwork)
-		if err != nil {
-			return err
-		}
-		err = g.AddChannel(ch)
-		if err != nil {
-			return err
+	return rs.loadTokenChannels(tokenAddress, func(css []*channeltype.Serialization) error {
+		for _, cs := range css {
+			//跳过已经 settle 的 channel 加入没有任何意义.
+			if cs.State == channeltype.StateSettled {
+				continue
+			}
+			ch, err := rs.channelSerilization2Channel(cs, tokenNetwork)
+			if err != nil {
+				return err
+			}
+			err = g.AddChannel(ch)
+			if err != nil {
+				return err
+			}
 		}
-	}
-	return
+		return nil
+	})
 }
 
 /*
 found new channel on blockchain when running...
+返回 false 表示没有注册这个通道
 */
-func (rs *Service) registerChannel(tokenAddress common.Address, partnerAddress common.Address, channelIdentifier *contracts.ChannelUniqueID, settleTimeout int) {
+func (rs *Service) registerChannel(tokenAddress common.Address, partnerAddress common.Address, channelIdentifier *contracts.ChannelUniqueID, settleTimeout int) (registered bool) {
+	if !rs.checkSettleTimeoutAcceptable(tokenAddress, partnerAddress, channelIdentifier, settleTimeout) {
+		return
+	}
 	tokenNetwork, err := rs.Chain.TokenNetwork(tokenAddress)
 	if err != nil {
 		log.Error(fmt.Sprintf("receive new channel %s-%s,but cannot create tokennetwork err %s",
@@ -749,7 +977,67 @@ func (rs *Service) registerChannel(tokenAddress common.Address, partnerAddress c
 	//	g := rs.getChannelGraph(ch.ChannelIdentifier.ChannelIdentifier)
 	//	log.Trace(fmt.Sprintf("receive new channel g=%s", utils.StringInterface(g, 3)))
 	//}
-	return
+	return true
+}
+
+/*
+checkTransferAmount 交易金额必须为正数,并且不能低于 Config.MinTransferAmount
+*/
+func (rs *Service) checkTransferAmount(amount *big.Int) error {
+	if amount == nil || amount.Cmp(utils.BigInt0) <= 0 {
+		return rerr.ErrInvalidAmount.Printf("transfer amount must be positive,got %s", amount)
+	}
+	if rs.Config.MinTransferAmount != nil && amount.Cmp(rs.Config.MinTransferAmount) < 0 {
+		return rerr.ErrAmountTooSmall.Printf("transfer amount %s is less than minimum %s", amount, rs.Config.MinTransferAmount)
+	}
+	return nil
+}
+
+/*
+checkDirectTransfer 和 target 之间有可以直接交易的通道,并且余额足够,
+有多个这样的通道时按照 Config.DirectChannelSelection 选择一个
+*/
+func (rs *Service) checkDirectTransfer(tokenAddress, target common.Address, amount *big.Int) (directChannel *channel.Channel, err error) {
+	if err = rs.checkTransferAmount(amount); err != nil {
+		return
+	}
+	g := rs.getToken2ChannelGraph(tokenAddress)
+	if g == nil {
+		return nil, rerr.ErrTokenNotFound
+	}
+	candidates := rs.directChannelCandidates(g, target)
+	if len(candidates) == 0 {
+		return nil, rerr.ErrChannelNotFound.Append("no available direct channel")
+	}
+	var usable []*channel.Channel
+	for _, c := range candidates {
+		if !rs.IsChainEffective && rs.Clock.Now().Unix()-rs.EffectiveChangeTimestamp >= c.GetHalfSettleTimeoutSeconds() {
+			err = rerr.ErrNotAllowDirectTransfer
+			continue
+		}
+		if c.Distributable().Cmp(amount) < 0 {
+			err = rerr.ErrChannelNoEnoughBalance
+			continue
+		}
+		usable = append(usable, c)
+	}
+	if len(usable) == 0 {
+		return nil, err
+	}
+	return rs.selectDirectChannel(tokenAddress, target, amount, usable), nil
+}
+
+/*
+preferDirectTransfer 配置了 PreferDirectTransfer 时,和 target 之间有余额足够的直接通道就改用 DirectTransfer,
+DirectTransfer 没有锁,发出以后无法取消,所以用户指定了密码的交易不会改用 DirectTransfer,
+配置了 DisableDirectTransfers 时也不会改用
+*/
+func (rs *Service) preferDirectTransfer(tokenAddress, target common.Address, amount *big.Int, secret common.Hash) bool {
+	if !rs.Config.PreferDirectTransfer || rs.Config.DisableDirectTransfers || secret != utils.EmptyHash {
+		return false
+	}
+	_, err := rs.checkDirectTransfer(tokenAddress, target, amount)
+	return err == nil
 }
 
 /*
@@ -775,23 +1063,17 @@ Do a direct tranfer with target.
 */
 func (rs *Service) directTransferAsync(tokenAddress, target common.Address, amount *big.Int, data string) (result *utils.AsyncResult) {
 	result = utils.NewAsyncResult()
-	g := rs.getToken2ChannelGraph(tokenAddress)
-	if g == nil {
-		result.Result <- rerr.ErrTokenNotFound
-		return
-	}
-	directChannel := g.GetPartenerAddress2Channel(target)
-	if directChannel == nil || !directChannel.CanTransfer() {
-		result.Result <- rerr.ErrChannelNotFound.Append("no available direct channel")
-		return
-	}
-	if !rs.IsChainEffective && time.Now().Unix()-rs.EffectiveChangeTimestamp >= directChannel.GetHalfSettleTimeoutSeconds() {
-		result.Result <- rerr.ErrNotAllowDirectTransfer
+	/*
+		DirectTransfer 不能取消,发送时就会增加 nonce 和 transferred amount,
+		金额为0的交易只会白白推进通道状态,所以直接拒绝
+	*/
+	if amount == nil || amount.Cmp(utils.BigInt0) <= 0 {
+		result.Result <- rerr.ErrAmountTooSmall.Printf("direct transfer amount must be positive,got %s", amount)
 		return
 	}
-
-	if directChannel.Distributable().Cmp(amount) < 0 {
-		result.Result <- rerr.ErrChannelNoEnoughBalance
+	directChannel, err := rs.checkDirectTransfer(tokenAddress, target, amount)
+	if err != nil {
+		result.Result <- err
 		return
 	}
 	tr, err := directChannel.CreateDirectTransfer(amount)
@@ -800,12 +1082,17 @@ func (rs *Service) directTransferAsync(tokenAddress, target common.Address, amou
 		return
 	}
 	tr.Data = []byte(data)
-	err = tr.Sign(rs.PrivateKey, tr)
+	err = tr.SignBy(rs.Signer, tr)
+	if err != nil {
+		result.Result <- err
+		return
+	}
 	err = directChannel.RegisterTransfer(rs.GetBlockNumber(), tr)
 	if err != nil {
 		result.Result <- err
 		return
 	}
+	rs.directChannelUsed(tokenAddress, target, directChannel)
 	//This should be set once the direct transfer is acknowledged
 	transferSuccess := &transfer.EventTransferSentSuccess{
 		LockSecretHash:    utils.EmptyHash,
@@ -846,6 +1133,55 @@ func (rs *Service) directTransferAsync(tokenAddress, target common.Address, amou
 	return
 }
 
+/*
+findMediatedRoutes 发起 MediatedTransfer 之前的检查,返回可用的路由,
+如果事先询问过中间节点的手续费,路由的手续费以询价结果为准
+*/
+func (rs *Service) findMediatedRoutes(tokenAddress, target common.Address, amount *big.Int, routeInfo []pfsproxy.FindPathResponse) (availableRoutes []*route.State, err error) {
+	if err = rs.checkTransferAmount(amount); err != nil {
+		return
+	}
+	g := rs.getToken2ChannelGraph(tokenAddress)
+	if g == nil {
+		return nil, rerr.ErrTokenNotFound
+	}
+	availableRoutes = rs.findAvailableRoutes(g, tokenAddress, target, amount, routeInfo)
+	for _, r := range availableRoutes {
+		if fee, ok := rs.feeQuotes.getRouteFee(tokenAddress, amount, r.Path); ok {
+			r.TotalFee = fee
+		}
+	}
+	if len(availableRoutes) <= 0 {
+		return nil, rerr.ErrNoAvailabeRoute
+	}
+	if !rs.Config.AllowOfflineFirstHop {
+		availableRoutes = rs.filterOfflineFirstHop(availableRoutes)
+		if len(availableRoutes) <= 0 {
+			return nil, rerr.ErrNoAvailabeRoute.Append("no reachable route,first hop of all routes offline")
+		}
+	}
+	// 当没有有效公链的时候,不支持发送MediatedTransfer,否则有安全隐患
+	if !rs.IsChainEffective {
+		return nil, rerr.ErrNotAllowMediatedTransfer
+	}
+	return
+}
+
+/*
+filterOfflineFirstHop 去掉第一跳不在线的路由,在线状态来自 transport 以及健康检查,
+和 GetBestRoutes 使用同样的数据
+*/
+func (rs *Service) filterOfflineFirstHop(routes []*route.State) (online []*route.State) {
+	for _, r := range routes {
+		if _, isOnline := rs.Protocol.GetNetworkStatus(r.HopNode()); !isOnline {
+			log.Info(fmt.Sprintf("ignore route %s,first hop is offline", utils.APex2(r.HopNode())))
+			continue
+		}
+		online = append(online, r)
+	}
+	return
+}
+
 /*
 lauch a new mediated trasfer
 Args:
@@ -874,58 +1210,18 @@ Calls:
  *			2.2 maker should contain lockSecretHash and secret.
  */
 func (rs *Service) startMediatedTransferInternal(tokenAddress, target common.Address, amount *big.Int, lockSecretHash common.Hash, expiration int64, secret common.Hash, data string, routeInfo []pfsproxy.FindPathResponse) (result *utils.AsyncResult, stateManager *transfer.StateManager) {
-	var availableRoutes []*route.State
 	//var err error
 	//targetAmount := new(big.Int).Sub(amount, fee)
 	result = utils.NewAsyncResult()
-	g := rs.getToken2ChannelGraph(tokenAddress)
-	if g == nil {
-		result.Result <- rerr.ErrTokenNotFound
-		return
-	}
-	// 2019-03消息升级过后,如果参数没有RouteInfo,仅支持与target直接拥有通道的情况下发送交易或是在不收费的网络下使用本地路由
-	if routeInfo == nil || len(routeInfo) == 0 {
-		// 当前为不支持收费的网络下时,使用本地路由
-		if rs.PfsProxy == nil {
-			log.Trace("get available routes without fee from local channel graph")
-			availableRoutes = g.GetBestRoutes(rs.Protocol, rs.NodeAddress, target, amount, amount, graph.EmptyExlude, rs)
-		} else {
-			log.Trace("get available routes to partner from local channel graph")
-			ch := rs.getChannel(tokenAddress, target)
-			if ch != nil {
-				r := route.NewState(ch, []common.Address{ch.PartnerState.Address})
-				r.TotalFee = utils.BigInt0
-				availableRoutes = append(availableRoutes, r)
-			}
-		}
-	} else {
-		// 用户指定了路由的话,采用用户指定的路由,否则从pfs或者本地查询路由
-		log.Trace("get available routes from user req")
-		for _, path := range routeInfo {
-			if path.Result == nil || len(path.Result) == 0 {
-				continue
-			}
-			partnerAddress := common.HexToAddress(path.Result[0])
-			ch := rs.getChannel(tokenAddress, partnerAddress)
-			if ch == nil {
-				continue
-			}
-			r := route.NewState(ch, path.GetPath())
-			//r.Fee = rs.FeePolicy.GetNodeChargeFee(partnerAddress, tokenAddress, amount) // 发起方不收取手续费
-			r.TotalFee = path.Fee
-			availableRoutes = append(availableRoutes, r)
-		}
-	}
-	log.Trace(fmt.Sprintf("availableRoutes=%s", utils.StringInterface(availableRoutes, 3)))
-	if len(availableRoutes) <= 0 {
-		result.Result <- rerr.ErrNoAvailabeRoute
-		return
-	}
-	// 当没有有效公链的时候,不支持发送MediatedTransfer,否则有安全隐患
-	if !rs.IsChainEffective {
-		result.Result <- rerr.ErrNotAllowMediatedTransfer
+	logCtx := utils.TransferLogCtx(lockSecretHash, tokenAddress)
+	expiration = rs.capLockExpiration(expiration, rs.GetBlockNumber())
+	availableRoutes, err := rs.findMediatedRoutes(tokenAddress, target, amount, routeInfo)
+	if err != nil {
+		log.Warn(fmt.Sprintf("refuse to start mediated transfer to %s,err=%s", utils.APex2(target), err), logCtx...)
+		result.Result <- err
 		return
 	}
+	log.Trace(fmt.Sprintf("availableRoutes=%s", utils.StringInterface(availableRoutes, 3)), logCtx...)
 	/*
 		when user specified fee, for test or other purpose.
 	*/
@@ -960,15 +1256,18 @@ func (rs *Service) startMediatedTransferInternal(tokenAddress, target common.Add
 		Secret:         secret,
 		LockSecretHash: lockSecretHash,
 		Db:             rs.dao,
+		RevealTimeout:  rs.Config.RevealTimeout,
 	}
 	//log.Trace(fmt.Sprintf("start mediated transfer availableRoutes=%s", utils.StringInterface(availableRoutes, 2)))
 	stateManager = transfer.NewStateManager(initiator.StateTransition, nil, initiator.NameInitiatorTransition, lockSecretHash, transferState.Token)
 	smkey := utils.Sha3(lockSecretHash[:], tokenAddress[:])
 	manager := rs.Transfer2StateManager[smkey]
 	if manager != nil {
+		log.Warn("duplicate transfer", logCtx...)
 		result.Result <- rerr.ErrDuplicateTransfer
 		return
 	}
+	log.Info(fmt.Sprintf("start mediated transfer to %s amount=%s routes=%d", utils.APex2(target), amount, len(availableRoutes)), logCtx...)
 	rs.Transfer2StateManager[smkey] = stateManager
 	rs.Transfer2Result[smkey] = result
 	//rs.dao.AddStateManager(stateManager)
@@ -976,11 +1275,77 @@ func (rs *Service) startMediatedTransferInternal(tokenAddress, target common.Add
 	return
 }
 
+/*
+findAvailableRoutes 查找发起交易可用的路由,
+用户指定了路由的话,采用用户指定的路由,否则从本地查询路由
+*/
+func (rs *Service) findAvailableRoutes(g *graph.ChannelGraph, tokenAddress, target common.Address, amount *big.Int, routeInfo []pfsproxy.FindPathResponse) (availableRoutes []*route.State) {
+	// 2019-03消息升级过后,如果参数没有RouteInfo,仅支持与target直接拥有通道的情况下发送交易或是在不收费的网络下使用本地路由
+	if routeInfo == nil || len(routeInfo) == 0 {
+		// 当前为不支持收费的网络下时,使用本地路由
+		if rs.PfsProxy == nil {
+			log.Trace("get available routes without fee from local channel graph")
+			availableRoutes = g.GetBestRoutes(rs.Protocol, rs.NodeAddress, target, amount, amount, rs.makeRouteExclude(target), rs)
+		} else {
+			log.Trace("get available routes to partner from local channel graph")
+			ch := rs.getChannel(tokenAddress, target)
+			if ch != nil {
+				r := route.NewState(ch, []common.Address{ch.PartnerState.Address})
+				r.TotalFee = utils.BigInt0
+				availableRoutes = append(availableRoutes, r)
+			}
+		}
+	} else {
+		// 用户指定了路由的话,采用用户指定的路由,否则从pfs或者本地查询路由
+		log.Trace("get available routes from user req")
+		for _, path := range routeInfo {
+			if path.Result == nil || len(path.Result) == 0 {
+				continue
+			}
+			if rs.hasDeniedHop(path.GetPath(), target) {
+				log.Info(fmt.Sprintf("ignore route %s,which contains denied node", path.Result))
+				continue
+			}
+			partnerAddress := common.HexToAddress(path.Result[0])
+			ch := rs.getChannel(tokenAddress, partnerAddress)
+			if ch == nil {
+				continue
+			}
+			r := route.NewState(ch, path.GetPath())
+			//r.Fee = rs.FeePolicy.GetNodeChargeFee(partnerAddress, tokenAddress, amount) // 发起方不收取手续费
+			r.TotalFee = path.Fee
+			availableRoutes = append(availableRoutes, r)
+		}
+		if rs.Config.BalanceAwareRouting {
+			graph.SortRoutesByBalance(availableRoutes, amount, func(r *route.State) *big.Int {
+				if r.TotalFee == nil {
+					return utils.BigInt0
+				}
+				return r.TotalFee
+			})
+		}
+	}
+	return
+}
+
 /*
 1. user start a mediated transfer
 2. user start a mediated transfer with secret
 */
 func (rs *Service) startMediatedTransfer(tokenAddress, target common.Address, amount *big.Int, secret common.Hash, data string, routeInfo []pfsproxy.FindPathResponse) (result *utils.AsyncResult) {
+	if rs.preferDirectTransfer(tokenAddress, target, amount, secret) {
+		log.Info(fmt.Sprintf("direct channel with %s available,use direct transfer amount=%s", utils.APex2(target), amount))
+		return rs.directTransferAsync(tokenAddress, target, amount, data)
+	}
+	secret, lockSecretHash := rs.newTransferSecret(secret)
+	return rs.startMediatedTransferWithSecret(tokenAddress, target, amount, secret, lockSecretHash, data, routeInfo)
+}
+
+/*
+newTransferSecret 用户没有指定密码时随机生成,
+指定了密码的话注册 SecretRequestPredictor,用户允许之前不会发送密码
+*/
+func (rs *Service) newTransferSecret(secret common.Hash) (common.Hash, common.Hash) {
 	lockSecretHash := utils.EmptyHash
 	if secret != utils.EmptyHash {
 		lockSecretHash = utils.ShaSecret(secret.Bytes())
@@ -1006,6 +1371,11 @@ func (rs *Service) startMediatedTransfer(tokenAddress, target common.Address, am
 		secret = utils.NewRandomHash()
 		lockSecretHash = utils.ShaSecret(secret[:])
 	}
+	return secret, lockSecretHash
+}
+
+//startMediatedTransferWithSecret 使用 newTransferSecret 生成的密码发起交易
+func (rs *Service) startMediatedTransferWithSecret(tokenAddress, target common.Address, amount *big.Int, secret, lockSecretHash common.Hash, data string, routeInfo []pfsproxy.FindPathResponse) (result *utils.AsyncResult) {
 	/*
 		发起方在这里记录发起的交易状态,后续UpdateTransferStatus会更新DB中的值
 	*/
@@ -1021,6 +1391,12 @@ func (rs *Service) mediateMediatedTransfer(msg *encoding.MediatedTransfer, ch *c
 	tokenAddress := ch.TokenAddress
 	smkey := utils.Sha3(msg.LockSecretHash[:], tokenAddress[:])
 	stateManager := rs.Transfer2StateManager[smkey]
+	logCtx := utils.TransferLogCtx(msg.LockSecretHash, tokenAddress)
+	g := rs.getToken2ChannelGraph(tokenAddress)
+	if g == nil {
+		log.Error(fmt.Sprintf("receive mediated transfer on unknown token %s,ignore", utils.APex2(tokenAddress)), logCtx...)
+		return
+	}
 	/*
 			第一次收到这个密码,
 		首先要判断这个密码是否是我声明放弃过的,如果是,就应该谨慎处理.
@@ -1032,7 +1408,7 @@ func (rs *Service) mediateMediatedTransfer(msg *encoding.MediatedTransfer, ch *c
 	 *	Locks can be duplicated, like in token swap.
 	 */
 	if rs.dao.IsLockSecretHashChannelIdentifierDisposed(msg.LockSecretHash, ch.ChannelIdentifier.ChannelIdentifier) {
-		log.Error(fmt.Sprintf("receive a lock secret hash,and it's my annouce disposed. %s", msg.LockSecretHash.String()))
+		log.Error(fmt.Sprintf("receive a lock secret hash,and it's my annouce disposed. %s", msg.LockSecretHash.String()), logCtx...)
 		//忽略,什么都不做
 		// do nothing.
 		return
@@ -1045,12 +1421,12 @@ func (rs *Service) mediateMediatedTransfer(msg *encoding.MediatedTransfer, ch *c
 	fromTransfer := mediatedtransfer.LockedTransferFromMessage(msg, ch.TokenAddress)
 	if stateManager != nil {
 		if stateManager.Name != mediator.NameMediatorTransition {
-			log.Error(fmt.Sprintf("receive mediator transfer,but i'm not a mediator,msg=%s,stateManager=%s", msg, utils.StringInterface(stateManager, 3)))
+			log.Error(fmt.Sprintf("receive mediator transfer,but i'm not a mediator,msg=%s,stateManager=%s", msg, utils.StringInterface(stateManager, 3)), logCtx...)
 			return
 		}
 		// 2019-03 消息升级后,仅在不收费的情况下支持重复交易
 		if rs.PfsProxy != nil {
-			log.Error(fmt.Sprintf("receive repeate mediator transfer,but i'm not a disable-fee node ,msg=%s,stateManager=%s", msg, utils.StringInterface(stateManager, 3)))
+			log.Error(fmt.Sprintf("receive repeate mediator transfer,but i'm not a disable-fee node ,msg=%s,stateManager=%s", msg, utils.StringInterface(stateManager, 3)), logCtx...)
 			return
 		}
 		stateChange := &mediatedtransfer.MediatorReReceiveStateChange{
@@ -1061,14 +1437,27 @@ func (rs *Service) mediateMediatedTransfer(msg *encoding.MediatedTransfer, ch *c
 		}
 		rs.StateMachineEventHandler.dispatch(stateManager, stateChange)
 	} else {
+		if err := rs.checkLockExpiration(msg.Expiration, rs.GetBlockNumber()); err != nil {
+			// 锁定时间太长,直接放弃,原因中带有上限
+			err = rs.disposeRegisteredTransfer(msg, ch, err.(rerr.StandardError))
+			if err != nil {
+				log.Error(fmt.Sprintf("dispose transfer err %s", err), logCtx...)
+			}
+			return
+		}
 		// 2019-03 消息升级后,路由以mtr中带有的path为准,有且只有一条,如果在不支持手续费的网络中,则根据本地路由继续交易
-		if len(msg.Path) == 0 {
+		if err := rs.checkTransferAmount(msg.PaymentAmount); err != nil {
+			// 低于最小金额的交易不转发,不提供路由,交给状态机拒绝这笔交易
+			log.Info(fmt.Sprintf("refuse to mediate %s", err), logCtx...)
+		} else if err = rs.checkInFlightMediatedTransfers(); err != nil {
+			// 中转的交易太多,同样交给状态机拒绝
+			log.Info(fmt.Sprintf("refuse to mediate %s", err), logCtx...)
+		} else if len(msg.Path) == 0 {
 			if rs.PfsProxy != nil {
-				log.Error("receive MediatedTransfer without route info,ignore")
+				log.Error("receive MediatedTransfer without route info,ignore", logCtx...)
 				return
 			}
-			exclude := graph.MakeExclude(msg.Sender, msg.Initiator)
-			g := rs.getToken2ChannelGraph(ch.TokenAddress) //must exist
+			exclude := rs.makeRouteExclude(msg.Target, msg.Sender, msg.Initiator)
 			avaiableRoutes = g.GetBestRoutes(rs.Protocol, rs.NodeAddress, msg.Target, amount, msg.PaymentAmount, exclude, rs)
 		} else {
 			// 获取下一跳的通道
@@ -1080,25 +1469,43 @@ func (rs *Service) mediateMediatedTransfer(msg *encoding.MediatedTransfer, ch *c
 				}
 			}
 			if myIndexInPath == -1 {
-				log.Error("can not found myself in msg.Path")
+				log.Error("can not found myself in msg.Path", logCtx...)
 				return
 			}
 			//传递参数有问题,导致没有下一跳
 			if myIndexInPath+1 >= len(msg.Path) {
-				log.Error(fmt.Sprintf("i'm not target,but cannot find more hop node,msg=%s", utils.StringInterface(msg, 5)))
+				log.Error(fmt.Sprintf("i'm not target,but cannot find more hop node,msg=%s", utils.StringInterface(msg, 5)), logCtx...)
 				return
 			}
 			nextChan := rs.getChannel(ch.TokenAddress, msg.Path[myIndexInPath+1])
 			if nextChan == nil {
-				log.Error(fmt.Sprintf("receive path,but channel between me and %s doesn't exist", msg.Path[myIndexInPath+1].String()))
+				log.Error(fmt.Sprintf("receive path,but channel between me and %s doesn't exist", msg.Path[myIndexInPath+1].String()), logCtx...)
 				return
 			}
-			// 构造路由,手续费根据TargetAmount在下家通道中的费率计算
-			availableRoute := route.NewState(nextChan, msg.Path)
-			targetAmount := new(big.Int).Sub(msg.PaymentAmount, msg.Fee)
-			availableRoute.Fee = rs.FeePolicy.GetNodeChargeFee(nextChan.PartnerState.Address, nextChan.TokenAddress, targetAmount)
-			avaiableRoutes = append(avaiableRoutes, availableRoute)
+			// 后续路径中有被拒绝的中间节点,不提供路由,交给状态机拒绝这笔交易
+			if rs.hasDeniedHop(msg.Path[myIndexInPath+1:], msg.Target) {
+				log.Info("path contains denied node,refuse to mediate", logCtx...)
+			} else {
+				// 构造路由,手续费根据TargetAmount在下家通道中的费率计算
+				availableRoute := route.NewState(nextChan, msg.Path)
+				targetAmount := new(big.Int).Sub(msg.PaymentAmount, msg.Fee)
+				availableRoute.Fee = rs.GetNodeChargeFee(nextChan.PartnerState.Address, nextChan.TokenAddress, targetAmount)
+				avaiableRoutes = append(avaiableRoutes, availableRoute)
+			}
 		}
+		if len(avaiableRoutes) > 0 {
+			var err error
+			avaiableRoutes, err = rs.checkMediationFee(avaiableRoutes)
+			if err != nil {
+				err = rs.disposeRegisteredTransfer(msg, ch, err.(rerr.StandardError))
+				if err != nil {
+					log.Error(fmt.Sprintf("dispose transfer err %s", err), logCtx...)
+				}
+				return
+			}
+		}
+		log.Info(fmt.Sprintf("mediate transfer from %s to %s amount=%s routes=%d",
+			utils.APex2(msg.Sender), utils.APex2(msg.Target), msg.PaymentAmount, len(avaiableRoutes)), logCtx...)
 		routesState := route.NewRoutesState(avaiableRoutes)
 		blockNumber := rs.GetBlockNumber()
 		initMediator := &mediatedtransfer.ActionInitMediatorStateChange{
@@ -1119,10 +1526,145 @@ func (rs *Service) mediateMediatedTransfer(msg *encoding.MediatedTransfer, ch *c
 	}
 }
 
+/*
+checkMediationFee 去掉我收取的手续费低于 Config.MinMediationFee 的路由,
+全部低于下限时返回错误,错误中带有下限,作为放弃锁的原因告诉上家
+*/
+func (rs *Service) checkMediationFee(routes []*route.State) ([]*route.State, error) {
+	floor := rs.Config.MinMediationFee
+	if floor == nil {
+		return routes, nil
+	}
+	var kept []*route.State
+	maxFee := utils.BigInt0
+	for _, r := range routes {
+		fee := r.Fee
+		if fee == nil {
+			fee = utils.BigInt0
+		}
+		if fee.Cmp(maxFee) > 0 {
+			maxFee = fee
+		}
+		if fee.Cmp(floor) >= 0 {
+			kept = append(kept, r)
+		}
+	}
+	if len(kept) == 0 {
+		return nil, rerr.ErrMediationFeeTooLow.Printf("fee %s below min mediation fee %s", maxFee, floor)
+	}
+	return kept, nil
+}
+
+/*
+capLockExpiration 发起交易时锁的过期时间不超过 Config.MaxLockExpirationBlocks,
+expiration 为0表示由状态机根据通道的 settle timeout 计算,有上限时改为上限
+*/
+func (rs *Service) capLockExpiration(expiration, blockNumber int64) int64 {
+	max := rs.Config.MaxLockExpirationBlocks
+	if max <= 0 {
+		return expiration
+	}
+	if expiration == 0 || expiration > blockNumber+max {
+		return blockNumber + max
+	}
+	return expiration
+}
+
+/*
+checkLockExpiration 上家的锁过期时间超过 Config.MaxLockExpirationBlocks 时拒绝中转,错误中带有上限.
+各个节点看到的最新块不完全相同,所以允许 params.LockExpirationSkewBlocks 的误差
+*/
+func (rs *Service) checkLockExpiration(expiration, blockNumber int64) error {
+	max := rs.Config.MaxLockExpirationBlocks
+	if max <= 0 || expiration-blockNumber <= max+params.LockExpirationSkewBlocks {
+		return nil
+	}
+	return rerr.ErrLockExpirationTooFar.Printf("lock expires in %d blocks,max lock expiration blocks %d", expiration-blockNumber, max)
+}
+
+/*
+disposeTransferOnUnavailableChannel 通道正在合作关闭或者取现,对方却还在这个通道上给我发交易,
+先接收这个交易,保证双方的 BalanceProof 一致,然后立即发送 AnnounceDisposed 放弃这个锁,
+不会为它创建 statemanager,也不会继续转发或者申请密码.
+*/
+func (rs *Service) disposeTransferOnUnavailableChannel(msg *encoding.MediatedTransfer, ch *channel.Channel) error {
+	return rs.disposeReceivedTransfer(msg, ch, rerr.ChannelStateError(ch.State))
+}
+
+/*
+disposeReceivedTransfer 收下对方的交易以后立即声明放弃,对方不会重发,可以尝试其他路由
+*/
+func (rs *Service) disposeReceivedTransfer(msg *encoding.MediatedTransfer, ch *channel.Channel, reason rerr.StandardError) error {
+	err := ch.RegisterTransfer(rs.GetBlockNumber(), msg)
+	if err != nil {
+		rs.MessageHandler.processRegisterTransferError(err, msg)
+		return err
+	}
+	return rs.disposeRegisteredTransfer(msg, ch, reason)
+}
+
+/*
+disposeRegisteredTransfer 交易已经在通道中登记过了,立即声明放弃
+*/
+func (rs *Service) disposeRegisteredTransfer(msg *encoding.MediatedTransfer, ch *channel.Channel, reason rerr.StandardError) error {
+	logCtx := utils.TransferLogCtx(msg.LockSecretHash, ch.TokenAddress)
+	log.Warn(fmt.Sprintf("receive transfer from %s on channel %s,dispose it because %s",
+		utils.APex2(msg.Sender), ch.ChannelIdentifier.String(), reason), logCtx...)
+	blockNumber := rs.GetBlockNumber()
+	ad, err := ch.CreateAnnouceDisposed(msg.LockSecretHash, blockNumber, reason)
+	if err != nil {
+		return err
+	}
+	err = ad.SignBy(rs.Signer, ad)
+	if err != nil {
+		return err
+	}
+	err = ch.RegisterAnnouceDisposed(ad)
+	if err != nil {
+		return err
+	}
+	err = rs.dao.MarkLockSecretHashDisposed(msg.LockSecretHash, ch.ChannelIdentifier.ChannelIdentifier, blockNumber, reason.Error())
+	if err != nil {
+		return err
+	}
+	rs.UpdateChannelAndSaveAck(ch, msg.Tag())
+	return rs.sendAsync(msg.Sender, ad)
+}
+
+/*
+GetDisposedLocks 我在通道上声明放弃过的锁,以及放弃时的块号和原因,
+收到的交易因为 "it's my annouce disposed" 被忽略时,可以用来排查.
+可以在任意线程中调用
+*/
+func (rs *Service) GetDisposedLocks(channelIdentifier common.Hash) ([]*models.DisposedLock, error) {
+	return rs.dao.GetDisposedLocks(channelIdentifier)
+}
+
+/*
+secretRevealBlockNumber 作为接收方,在哪个块以后才能向上家披露密码,0表示立即披露.
+锁的钱来自上家在这个通道中的押金,通道打开以后上家又存过款的话,最后一次存款被回滚时这个锁同样可能拿不到钱,
+所以要等打开和上家最后一次存款中较晚的那个被确认
+*/
+func (rs *Service) secretRevealBlockNumber(fromChannel *channel.Channel) int64 {
+	if rs.Config.SecretRevealStrategy != params.SecretRevealConfirmed {
+		return 0
+	}
+	confirmations := rs.Config.SecretRevealConfirmations
+	if confirmations <= 0 {
+		confirmations = params.ForkConfirmNumber
+	}
+	fundedBlock := fromChannel.ChannelIdentifier.OpenBlockNumber
+	if fromChannel.PartnerState.DepositBlockNumber > fundedBlock {
+		fundedBlock = fromChannel.PartnerState.DepositBlockNumber
+	}
+	return fundedBlock + confirmations
+}
+
 //receive a MediatedTransfer, i'm the target
 func (rs *Service) targetMediatedTransfer(msg *encoding.MediatedTransfer, ch *channel.Channel) {
 	smkey := utils.Sha3(msg.LockSecretHash[:], ch.TokenAddress[:])
 	stateManager := rs.Transfer2StateManager[smkey]
+	logCtx := utils.TransferLogCtx(msg.LockSecretHash, ch.TokenAddress)
 	/*
 		第一次收到这个密码,
 		首先要判断这个密码是否是我声明放弃过的,如果是,就应该谨慎处理.
@@ -1135,25 +1677,29 @@ func (rs *Service) targetMediatedTransfer(msg *encoding.MediatedTransfer, ch *ch
 	 */
 	if rs.dao.IsLockSecretHashChannelIdentifierDisposed(msg.LockSecretHash, ch.ChannelIdentifier.ChannelIdentifier) {
 		//todo 需要通知photon用户
-		log.Error(fmt.Sprintf("receive a lock secret hash,and it's my annouce disposed. %s", msg.LockSecretHash.String()))
+		log.Error(fmt.Sprintf("receive a lock secret hash,and it's my annouce disposed. %s", msg.LockSecretHash.String()), logCtx...)
 		return
 	}
 	if stateManager != nil {
 		if stateManager.Name != target.NameTargetTransition {
-			log.Error(fmt.Sprintf("receive mediator transfer,but i'm not a target,msg=%s,stateManager=%s", msg, utils.StringInterface(stateManager, 3)))
+			log.Error(fmt.Sprintf("receive mediator transfer from %s,but i'm a %s,not a target", utils.APex2(msg.Sender), stateManager.Name), logCtx...)
 			return
 		}
-		log.Error(fmt.Sprintf("receive mediator transfer msg=%s,duplicate? attack?,i'm a target,and has received mediator message. statemanager=%s",
-			msg, utils.StringInterface(stateManager, 3)))
+		rs.onDuplicateTargetTransfer(msg, ch.TokenAddress)
 		return
 	}
 	g := rs.getToken2ChannelGraph(ch.TokenAddress)
+	if g == nil {
+		log.Error(fmt.Sprintf("receive mediated transfer on unknown token %s,ignore", utils.APex2(ch.TokenAddress)), logCtx...)
+		return
+	}
 	fromChannel := g.GetPartenerAddress2Channel(msg.Sender)
 	if fromChannel == nil {
 		log.Error(fmt.Sprintf("GetPartenerAddress2Channel returns nil ,but %s should have channel with %s on token %s",
-			utils.APex2(g.OurAddress), utils.APex2(msg.Sender), utils.APex2(g.TokenAddress)))
+			utils.APex2(g.OurAddress), utils.APex2(msg.Sender), utils.APex2(g.TokenAddress)), logCtx...)
 		return
 	}
+	log.Info(fmt.Sprintf("receive transfer from %s initiator=%s amount=%s", utils.APex2(msg.Sender), utils.APex2(msg.Initiator), msg.PaymentAmount), logCtx...)
 	fromRoute := graph.Channel2RouteState(fromChannel, msg.Sender, msg.PaymentAmount, rs, msg.Path)
 	fromTransfer := mediatedtransfer.LockedTransferFromMessage(msg, ch.TokenAddress)
 	initTarget := &mediatedtransfer.ActionInitTargetStateChange{
@@ -1165,6 +1711,7 @@ func (rs *Service) targetMediatedTransfer(msg *encoding.MediatedTransfer, ch *ch
 		Db:                       rs.dao,
 		IsEffectiveChain:         rs.IsChainEffective,
 		EffectiveChangeTimestamp: rs.EffectiveChangeTimestamp,
+		RevealBlockNumber:        rs.secretRevealBlockNumber(fromChannel),
 	}
 	stateManager = transfer.NewStateManager(target.StateTransiton, nil, target.NameTargetTransition, fromTransfer.LockSecretHash, fromTransfer.Token)
 	//rs.dao.AddStateManager(stateManager)
@@ -1178,24 +1725,102 @@ func (rs *Service) startHealthCheckFor(address common.Address) {
 	if !rs.Config.EnableHealthCheck {
 		return
 	}
-	if rs.HealthCheckMap[address] {
+	rs.healthCheckLock.Lock()
+	defer rs.healthCheckLock.Unlock()
+	if rs.HealthCheckMap[address] != nil {
 		log.Info(fmt.Sprintf("addr %s check already start.", utils.APex(address)))
 		return
 	}
-	rs.HealthCheckMap[address] = true
+	stop := make(chan struct{})
+	now := make(chan time.Time, 1)
+	rs.HealthCheckMap[address] = stop
+	rs.healthCheckNow[address] = now
 	go func() {
 		defer rpanic.PanicRecover(fmt.Sprintf("ping %s", utils.APex(address)))
 		log.Trace(fmt.Sprintf("health check for %s started", utils.APex(address)))
+		//只有这个goroutine更新 address 的结果,退出时移除才不会被覆盖
+		defer func() {
+			rs.networkReachability.remove(address, rs.Clock.Now())
+			log.Trace(fmt.Sprintf("health check for %s stopped", utils.APex(address)))
+		}()
 		for {
+			pingAt := rs.Clock.Now()
 			err := rs.Protocol.SendPing(address)
 			if err != nil {
 				log.Info(fmt.Sprintf("health check ping %s err %s", utils.APex(address), err))
 			}
-			time.Sleep(time.Second * 10)
+			_, isOnline := rs.Protocol.GetNetworkStatus(address)
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			rs.networkReachability.update(address, err == nil && isOnline, rs.Clock.Now())
+			timeout := rs.Clock.After(time.Second * 10)
+		wait:
+			for {
+				select {
+				case <-stop:
+					return
+				case requestAt := <-now:
+					//这次 ping 之前的请求已经满足了
+					if requestAt.After(pingAt) {
+						break wait
+					}
+				case <-timeout:
+					break wait
+				}
+			}
 		}
 	}()
 }
 
+/*
+StopHealthCheckFor 停止对 address 的健康检查,和它的所有通道都结算以后自动调用,
+否则每个曾经有过通道的节点都会留下一个一直 ping 的goroutine.
+正在进行的 ping 不会被打断,但是结果会被丢弃,address 也不再参与 SubscribeNetworkReachability 的汇总
+*/
+func (rs *Service) StopHealthCheckFor(address common.Address) {
+	rs.healthCheckLock.Lock()
+	stop := rs.HealthCheckMap[address]
+	delete(rs.HealthCheckMap, address)
+	delete(rs.healthCheckNow, address)
+	rs.healthCheckLock.Unlock()
+	if stop != nil {
+		close(stop)
+	}
+}
+
+/*
+checkHealthNow 让 address 的健康检查立即 ping 一次,结果照常由健康检查汇报,
+如果健康检查在请求之后已经 ping 过,不会重复 ping.
+没有对 address 进行健康检查时返回 false
+*/
+func (rs *Service) checkHealthNow(address common.Address) bool {
+	rs.healthCheckLock.Lock()
+	defer rs.healthCheckLock.Unlock()
+	now, ok := rs.healthCheckNow[address]
+	if !ok {
+		return false
+	}
+	select {
+	case now <- rs.Clock.Now():
+	default:
+		//已经有未处理的请求
+	}
+	return true
+}
+
+//hasChannelWith 在任何 token 上和 partner 还有通道
+func (rs *Service) hasChannelWith(partner common.Address) bool {
+	for _, g := range rs.Token2ChannelGraph {
+		if g.GetPartenerAddress2Channel(partner) != nil {
+			return true
+		}
+	}
+	return false
+}
+
 func (rs *Service) startNeighboursHealthCheck() {
 	for _, g := range rs.Token2ChannelGraph {
 		for addr := range g.PartenerAddress2Channel {
@@ -1264,27 +1889,242 @@ func (rs *Service) getChannel(tokenAddr, partnerAddr common.Address) *channel.Ch
 	return g.GetPartenerAddress2Channel(partnerAddr)
 }
 
+func (rs *Service) maxChannelsPerPartner() int {
+	if rs.Config.MaxChannelsPerPartner <= 0 {
+		return params.DefaultMaxChannelsPerPartner
+	}
+	return rs.Config.MaxChannelsPerPartner
+}
+
+/*
+countActiveChannels 和partner在token上还没有关闭的通道数,
+已经关闭或者结算的通道不会再有新的交易,不计算在内
+*/
+func (rs *Service) countActiveChannels(tokenAddr, partnerAddr common.Address) (n int) {
+	g := rs.getToken2ChannelGraph(tokenAddr)
+	if g == nil {
+		return 0
+	}
+	for _, c := range g.ChannelIdentifier2Channel {
+		if c.PartnerState.Address != partnerAddr {
+			continue
+		}
+		switch c.State {
+		case channeltype.StateInValid, channeltype.StateClosed, channeltype.StateSettling, channeltype.StateSettled:
+			continue
+		}
+		n++
+	}
+	return
+}
+
 /*
 Process user's new channel request
+force 为 false 时,如果通道上还有没打包的存款交易就拒绝,避免用户重复点击导致存两次
 */
-func (rs *Service) newChannelAndDeposit(token, partner common.Address, settleTimeout int, amount *big.Int, isNewChannel bool) *utils.AsyncResult {
+func (rs *Service) newChannelAndDeposit(token, partner common.Address, settleTimeout int, amount *big.Int, isNewChannel, force bool) *utils.AsyncResult {
 	if isNewChannel {
-		minSettleTimeout := rs.getMinSettleTimeout()
+		minSettleTimeout := rs.minAcceptableSettleTimeout()
 		if settleTimeout < minSettleTimeout {
 			return utils.NewAsyncResultWithError(rerr.ErrArgumentError.Append(fmt.Sprintf("settle_timeout must bigger than %d", minSettleTimeout)))
 		}
-		g := rs.Token2ChannelGraph[token]
-		if g != nil {
-			if g.GetPartenerAddress2Channel(partner) != nil {
-				return utils.NewAsyncResultWithError(rerr.ErrChannelAlreadExist)
-			}
+		//先检查通道数上限,否则只要已有通道就会在下面返回,上限永远不会生效
+		if rs.countActiveChannels(token, partner) >= rs.maxChannelsPerPartner() {
+			return utils.NewAsyncResultWithError(rerr.ErrChannelAlreadExist.Printf("already has %d channels with %s", rs.maxChannelsPerPartner(), utils.APex2(partner)))
+		}
+		/*
+			合约上同一对节点只能有一个通道,关闭或者正在结算的通道也会导致打开失败,必须等它 settle 以后才能打开
+		*/
+		if c := rs.getChannel(token, partner); c != nil {
+			return utils.NewAsyncResultWithError(rerr.ErrChannelAlreadExist.Printf("channel %s with %s is %s",
+				c.ChannelIdentifier.String(), utils.APex2(partner), c.State))
+		}
+	}
+	if !force {
+		channelIdentifier := utils.CalcChannelID(token, rs.Chain.GetRegistryAddress(), partner, rs.NodeAddress)
+		if err := rs.checkNoPendingDeposit(channelIdentifier); err != nil {
+			return utils.NewAsyncResultWithError(err)
 		}
 	}
 	tokenNetwork, err := rs.Chain.TokenNetwork(token)
 	if err != nil {
 		return utils.NewAsyncResultWithError(err)
 	}
-	return utils.NewAsyncResultWithError(tokenNetwork.NewChannelAndDepositAsync(rs.NodeAddress, partner, settleTimeout, amount))
+	expectedBalance := new(big.Int).Set(amount)
+	if !isNewChannel {
+		if c := rs.getChannel(token, partner); c != nil {
+			expectedBalance.Add(expectedBalance, c.OurState.ContractBalance)
+		}
+	}
+	progress := rs.watchChannelOpenAndDeposit(token, partner, expectedBalance, isNewChannel)
+	err = tokenNetwork.NewChannelAndDepositAsync(rs.NodeAddress, partner, settleTimeout, amount)
+	if err != nil {
+		progress.cancel()
+	}
+	result := utils.NewAsyncResultWithError(err)
+	result.Tag = progress
+	return result
+}
+
+/*
+checkNoPendingDeposit 存款交易提交以后到打包之前,TXInfo 中有它的 pending 记录,
+授权方式存款时先提交的是 approve 交易,所以两种都要检查
+*/
+func (rs *Service) checkNoPendingDeposit(channelIdentifier common.Hash) error {
+	list, err := rs.dao.GetTXInfoList(channelIdentifier, 0, utils.EmptyAddress, "", models.TXInfoStatusPending)
+	if err != nil {
+		return err
+	}
+	for _, tx := range list {
+		if tx.Type == models.TXInfoTypeDeposit || tx.Type == models.TXInfoTypeApproveDeposit {
+			return rerr.ErrChannelDepositInProgress.Errorf("tx %s of channel %s not packed yet", tx.TXHash.String(), utils.HPex(channelIdentifier))
+		}
+	}
+	return nil
+}
+
+/*
+ChannelOpenProgress 创建通道和存款可能在不同的事件中完成,调用者分别等待这两个阶段
+ChannelCreated: 通道已经创建,此时通道标识已知,如果只是存款则不会收到
+DepositConfirmed: 合约中我方的存款已经更新
+两个chan都只会收到一次,如果交易失败则都不会收到
+*/
+type ChannelOpenProgress struct {
+	ChannelCreated   chan *channeltype.Serialization
+	DepositConfirmed chan *channeltype.Serialization
+	canceled         int32
+	rs               *Service
+	token            common.Address
+	partner          common.Address
+}
+
+/*
+Result 查询这次创建通道和存款进行到了哪一步,交易失败时两个chan都收不到,调用者用它区分失败的步骤
+*/
+func (p *ChannelOpenProgress) Result() (*ChannelOpenResult, error) {
+	return p.rs.getChannelOpenResult(p.token, p.partner)
+}
+
+func (p *ChannelOpenProgress) cancel() {
+	atomic.StoreInt32(&p.canceled, 1)
+}
+
+func (p *ChannelOpenProgress) isCanceled() bool {
+	return atomic.LoadInt32(&p.canceled) == 1
+}
+
+/*
+watchChannelOpenAndDeposit 通过dao的回调跟踪通道创建和存款,
+回调在持有dao的锁时调用,不能阻塞,所以chan都是有缓冲的.
+存款确认,交易提交失败,approve 或者 deposit 交易失败都会结束跟踪,
+dao的回调只能通过返回 true 移除,所以结束以后所有回调在下一次被调用时移除自己
+*/
+func (rs *Service) watchChannelOpenAndDeposit(token, partner common.Address, expectedBalance *big.Int, isNewChannel bool) *ChannelOpenProgress {
+	p := &ChannelOpenProgress{
+		ChannelCreated:   make(chan *channeltype.Serialization, 1),
+		DepositConfirmed: make(chan *channeltype.Serialization, 1),
+		rs:               rs,
+		token:            token,
+		partner:          partner,
+	}
+	match := func(c *channeltype.Serialization) bool {
+		return c.TokenAddress() == token && c.PartnerAddress() == partner
+	}
+	if isNewChannel {
+		rs.dao.RegisterNewChannelCallback(func(c *channeltype.Serialization) (remove bool) {
+			if p.isCanceled() {
+				return true
+			}
+			if !match(c) {
+				return false
+			}
+			p.ChannelCreated <- c
+			return true
+		})
+	}
+	rs.dao.RegisterChannelDepositCallback(func(c *channeltype.Serialization) (remove bool) {
+		if p.isCanceled() {
+			return true
+		}
+		if !match(c) || c.OurContractBalance.Cmp(expectedBalance) < 0 {
+			return false
+		}
+		p.DepositConfirmed <- c
+		p.cancel()
+		return true
+	})
+	channelIdentifier := utils.CalcChannelID(token, rs.Chain.GetRegistryAddress(), partner, rs.NodeAddress)
+	rs.dao.RegisterTXInfoCallback(func(txInfo *models.TXInfo) (remove bool) {
+		if p.isCanceled() {
+			return true
+		}
+		if txInfo.ChannelIdentifier != channelIdentifier || txInfo.Status != models.TXInfoStatusFailed {
+			return false
+		}
+		if txInfo.Type != models.TXInfoTypeDeposit && txInfo.Type != models.TXInfoTypeApproveDeposit {
+			return false
+		}
+		p.cancel()
+		return true
+	})
+	return p
+}
+
+/*
+ChannelOpenResult 创建通道并存款的结果.
+合约的 deposit 在通道不存在时同时创建通道,但是授权方式需要先 approve 再 deposit 两个交易,
+存款失败时通道可能已经存在(单纯存款),也可能不存在(新建通道),客户端根据 ChannelOpened 决定只重试存款还是重新创建通道.
+ChannelIdentifier 只有通道已经创建时才有效
+*/
+type ChannelOpenResult struct {
+	ChannelIdentifier common.Hash    `json:"channel_identifier"`
+	ChannelOpened     bool           `json:"channel_opened"`
+	DepositPending    bool           `json:"deposit_pending"`
+	DepositConfirmed  bool           `json:"deposit_confirmed"`
+	DepositFailed     bool           `json:"deposit_failed"`
+	FailedTX          *models.TXInfo `json:"failed_tx,omitempty"`
+}
+
+/*
+getChannelOpenResult 根据通道和最后一次存款相关的交易(approve 或者 deposit)判断每一步的结果,
+只读取数据库,可以在任意线程中调用
+*/
+func (rs *Service) getChannelOpenResult(token, partner common.Address) (*ChannelOpenResult, error) {
+	r := &ChannelOpenResult{}
+	c, err := rs.dao.GetChannel(token, partner)
+	if err == nil && c.State != channeltype.StateInValid {
+		r.ChannelOpened = true
+		r.ChannelIdentifier = c.ChannelIdentifier.ChannelIdentifier
+	}
+	channelIdentifier := utils.CalcChannelID(token, rs.Chain.GetRegistryAddress(), partner, rs.NodeAddress)
+	list, err := rs.dao.GetTXInfoList(channelIdentifier, 0, utils.EmptyAddress, models.TXInfoTypeDeposit+","+models.TXInfoTypeApproveDeposit, "")
+	if err != nil {
+		return nil, err
+	}
+	var last *models.TXInfo
+	for _, tx := range list {
+		//approve 之后的 deposit 发起得更晚,同一秒内发起时 deposit 优先
+		if last == nil || tx.CallTime > last.CallTime ||
+			(tx.CallTime == last.CallTime && tx.Type == models.TXInfoTypeDeposit) {
+			last = tx
+		}
+	}
+	if last == nil {
+		return r, nil
+	}
+	switch {
+	case last.Status == models.TXInfoStatusPending:
+		r.DepositPending = true
+	case last.Status == models.TXInfoStatusFailed:
+		r.DepositFailed = true
+		r.FailedTX = last
+	case last.Type == models.TXInfoTypeApproveDeposit:
+		//approve 成功,deposit 还没有提交
+		r.DepositPending = true
+	default:
+		r.DepositConfirmed = true
+	}
+	return r, nil
 }
 
 /*
@@ -1301,7 +2141,11 @@ func (rs *Service) closeOrSettleChannel(channelIdentifier common.Hash, op string
 	if op == closeChannelReqName {
 		err = c.Close()
 	} else {
-		err = c.Settle(rs.GetBlockNumber())
+		blockNumber := rs.GetBlockNumber()
+		err = checkSettleTimeout(c, blockNumber)
+		if err == nil {
+			err = c.Settle(blockNumber)
+		}
 	}
 	if err == nil {
 		err = rs.UpdateChannelState(channel.NewChannelSerialization(c))
@@ -1310,6 +2154,23 @@ func (rs *Service) closeOrSettleChannel(channelIdentifier common.Hash, op string
 	//通道变化的通知来自于事件,而不是执行结果
 	return
 }
+
+/*
+checkSettleTimeout 合约要求块号大于 ClosedBlock+SettleTimeout+PunishBlockNumber 才能 settle,
+留给 punish 的块内提交的 tx 一样会失败并浪费 gas.
+通道不是关闭状态时由 Settle 报告错误
+*/
+func checkSettleTimeout(c *channel.Channel, blockNumber int64) error {
+	if c.State != channeltype.StateClosed {
+		return nil
+	}
+	settleBlock := c.ExternState.ClosedBlock + int64(c.SettleTimeout) + params.PunishBlockNumber + 1
+	if blockNumber < settleBlock {
+		return rerr.ErrChannelSettleTimeout.Printf("channel %s can not settle until block %d,current block %d,%d blocks remain",
+			c.ChannelIdentifier.String(), settleBlock, blockNumber, settleBlock-blockNumber)
+	}
+	return nil
+}
 func (rs *Service) cooperativeSettleChannel(channelIdentifier common.Hash) (result *utils.AsyncResult) {
 	result = utils.NewAsyncResult()
 	c, err := rs.findChannelByIdentifier(channelIdentifier)
@@ -1328,13 +2189,18 @@ func (rs *Service) cooperativeSettleChannel(channelIdentifier common.Hash) (resu
 		result.Result <- err
 		return
 	}
+	err = s.SignBy(rs.Signer, s)
+	if err != nil {
+		result.Result <- err
+		return
+	}
 	c.State = channeltype.StateCooprativeSettle
 	err = rs.UpdateChannelNoTx(channel.NewChannelSerialization(c))
 	if err != nil {
 		result.Result <- err
+		return
 	}
-	err = s.Sign(rs.PrivateKey, s)
-	err = rs.sendAsync(c.PartnerState.Address, s)
+	err = rs.sendAsyncWithPolicy(c.PartnerState.Address, s, rs.handshakeSendPolicy(false))
 	result.Result <- err
 	return
 }
@@ -1417,13 +2283,18 @@ func (rs *Service) withdraw(channelIdentifier common.Hash, amount *big.Int) (res
 		result.Result <- err
 		return
 	}
+	err = s.SignBy(rs.Signer, s)
+	if err != nil {
+		result.Result <- err
+		return
+	}
 	c.State = channeltype.StateWithdraw
 	err = rs.UpdateChannelNoTx(channel.NewChannelSerialization(c))
 	if err != nil {
 		result.Result <- err
+		return
 	}
-	err = s.Sign(rs.PrivateKey, s)
-	err = rs.sendAsync(c.PartnerState.Address, s)
+	err = rs.sendAsyncWithPolicy(c.PartnerState.Address, s, rs.handshakeSendPolicy(false))
 	result.Result <- err
 	return
 }
@@ -1455,6 +2326,7 @@ func (rs *Service) tokenSwapMaker(tokenswap *TokenSwap) (result *utils.AsyncResu
 	var sentMtrHook SentMediatedTransferListener
 	var receiveMtrHook ReceivedMediatedTrasnferListener
 	var secretRequestHook SecretRequestPredictor
+	var pending *pendingTokenSwap
 	secretRequestHook = func(msg *encoding.SecretRequest) (ignore bool) {
 		if !hasReceiveTakerMediatedTransfer {
 			/*
@@ -1475,6 +2347,7 @@ func (rs *Service) tokenSwapMaker(tokenswap *TokenSwap) (result *utils.AsyncResu
 			}
 			lockSecretHash = mtr.LockSecretHash //hashlock may change when select new route path
 			rs.SecretRequestPredictorMap[lockSecretHash] = secretRequestHook
+			pending.secretRequest = lockSecretHash
 		}
 		return false
 	}
@@ -1483,18 +2356,61 @@ func (rs *Service) tokenSwapMaker(tokenswap *TokenSwap) (result *utils.AsyncResu
 			recevive taker's mediated transfer , the transfer must use argument of tokenswap and have the same hashlock
 		*/
 		if mtr.LockSecretHash == tokenswap.LockSecretHash && lockSecretHash == mtr.LockSecretHash && rs.getTokenForChannelIdentifier(mtr.ChannelIdentifier) == tokenswap.ToToken && mtr.Target == tokenswap.FromNodeAddress && mtr.PaymentAmount.Cmp(tokenswap.ToAmount) == 0 {
+			if tokenswap.LastPayerAddress != utils.EmptyAddress && mtr.Initiator != tokenswap.LastPayerAddress {
+				log.Warn(fmt.Sprintf("tokenswap maker receive transfer from %s,expect %s", utils.APex2(mtr.Initiator), utils.APex2(tokenswap.LastPayerAddress)))
+				return false
+			}
+			/*
+				收到的锁必须留有足够的时间让我拿到钱,否则泄露密码以后只有我会损失,
+				继续等待,所有的锁过期以后整个 swap 自动回滚
+			*/
+			if mtr.Expiration-rs.GetBlockNumber() <= int64(rs.Config.RevealTimeout) {
+				log.Warn(fmt.Sprintf("tokenswap maker receive transfer expiring at %d,too late to reveal secret", mtr.Expiration))
+				return false
+			}
 			hasReceiveTakerMediatedTransfer = true
+			pending.takerActed = true
 			delete(rs.SentMediatedTransferListenerMap, &sentMtrHook)
 			return true
 		}
 		return false
 	}
+	pending = &pendingTokenSwap{
+		role:         TokenSwapRoleMaker,
+		tokenSwap:    tokenswap,
+		sentHook:     &sentMtrHook,
+		receivedHook: &receiveMtrHook,
+	}
+	rs.pendingTokenSwaps[newSwapKey(tokenswap)] = pending
 	rs.SentMediatedTransferListenerMap[&sentMtrHook] = true
 	rs.ReceivedMediatedTrasnferListenerMap[&receiveMtrHook] = true
-	result, _ = rs.startMediatedTransferInternal(tokenswap.FromToken, tokenswap.ToNodeAddress, tokenswap.FromAmount, tokenswap.LockSecretHash, 0, tokenswap.Secret, "", tokenswap.RouteInfo)
+	result, stateManager := rs.startMediatedTransferInternal(tokenswap.FromToken, tokenswap.ToNodeAddress, tokenswap.FromAmount, tokenswap.LockSecretHash, 0, tokenswap.Secret, "", tokenswap.RouteInfo)
+	if smkey, _ := pending.ourTransferKey(); stateManager == nil || rs.Transfer2StateManager[smkey] != stateManager {
+		//交易没有发起,swap 直接结束
+		rs.removeTokenSwap(newSwapKey(tokenswap), pending)
+	}
 	return
 }
 
+/*
+tokenSwapTakerExpiration taker 发出的锁的过期时间.
+maker 最晚在 taker 的锁过期时拿钱并泄露密码,这之后 taker 还要有 revealTimeout 个块到链上注册密码拿到 maker 的钱,
+所以 taker 的锁必须比 maker 的锁早至少 revealTimeout 个块过期.
+同时 taker 的锁距离当前块也要多于 revealTimeout 个块,否则 maker 会因为来不及拿钱而不接受,
+maker 的锁给的时间不够时拒绝这次 swap,而不是发出一笔不安全的交易
+*/
+func tokenSwapTakerExpiration(makerExpiration, blockNumber int64, revealTimeout int) (int64, error) {
+	if revealTimeout <= 0 {
+		return 0, rerr.ErrArgumentError.Printf("invalid reveal timeout %d", revealTimeout)
+	}
+	takerExpiration := makerExpiration - int64(revealTimeout)
+	if takerExpiration-blockNumber <= int64(revealTimeout) {
+		return 0, rerr.ErrRouteLockExpirationTooNear.Printf("maker's lock expires at %d,current block %d,need more than %d blocks",
+			makerExpiration, blockNumber, 2*revealTimeout)
+	}
+	return takerExpiration, nil
+}
+
 /*
 taker process token swap
 taker's action is triggered by maker's mediated transfer.
@@ -1543,9 +2459,15 @@ func (rs *Service) messageTokenSwapTaker(msg *encoding.MediatedTransfer, tokensw
 	/*
 		taker's Expiration must be smaller than maker's ,
 		taker and maker may have direct channels on these two tokens.
+		in a multi-leg swap,expiration decreases by RevealTimeout at each participant,
+		so every participant learns the secret early enough to claim what it receives.
 	*/
-	takerExpiration := msg.Expiration - int64(rs.Config.RevealTimeout)
-	result, stateManager := rs.startMediatedTransferInternal(tokenswap.ToToken, tokenswap.FromNodeAddress, tokenswap.ToAmount, tokenswap.LockSecretHash, takerExpiration, utils.EmptyHash, "", tokenswap.RouteInfo)
+	takerExpiration, err := tokenSwapTakerExpiration(msg.Expiration, rs.GetBlockNumber(), rs.Config.RevealTimeout)
+	if err != nil {
+		log.Warn(fmt.Sprintf("refuse token swap %s,maker's lock expires at %d,err %s", utils.HPex(hashlock), msg.Expiration, err))
+		return false
+	}
+	result, stateManager := rs.startMediatedTransferInternal(tokenswap.ToToken, tokenswap.takerPayee(), tokenswap.ToAmount, tokenswap.LockSecretHash, takerExpiration, utils.EmptyHash, "", tokenswap.RouteInfo)
 	if stateManager == nil {
 		log.Error(fmt.Sprintf("taker tokenwap error %s", <-result.Result))
 		return false
@@ -1562,39 +2484,47 @@ only mark, if i receive a valid mediated transfer, then start token swap
 func (rs *Service) tokenSwapTaker(tokenswap *TokenSwap) (result *utils.AsyncResult) {
 	result = utils.NewAsyncResult()
 	result.Result <- nil
-	key := swapKey{
-		LockSecretHash: tokenswap.LockSecretHash,
-		FromToken:      tokenswap.FromToken,
-		FromAmount:     tokenswap.FromAmount.String(),
-	}
+	key := newSwapKey(tokenswap)
 	rs.SwapKey2TokenSwap[key] = tokenswap
+	rs.pendingTokenSwaps[key] = &pendingTokenSwap{
+		role:      TokenSwapRoleTaker,
+		tokenSwap: tokenswap,
+	}
 	return
 }
 
 /*
-cancel a transfer before secret send
-only initiator can call
+checkCancelTransfer 交易是否还能撤销,只有发起方在密码泄露之前才能撤销
 */
-func (rs *Service) cancelTransfer(req *cancelTransferReq) (result *utils.AsyncResult) {
-	result = utils.NewAsyncResult()
+func (rs *Service) checkCancelTransfer(req *cancelTransferReq) (manager *transfer.StateManager, err error) {
 	// get transfer info and check
 	smKey := utils.Sha3(req.LockSecretHash[:], req.TokenAddress[:])
-	manager := rs.Transfer2StateManager[smKey]
+	manager = rs.Transfer2StateManager[smKey]
 	if manager == nil {
-		result.Result <- rerr.ErrTransferNotFound
-		return
+		return nil, rerr.ErrTransferNotFound
 	}
 	if manager.Name != initiator.NameInitiatorTransition {
-		result.Result <- rerr.ErrTransferCannotCancel.Append("you can only cancel transfers you send")
-		return
+		return nil, rerr.ErrTransferCannotCancel.Append("you can only cancel transfers you send")
 	}
 	transferStatus, err := rs.dao.GetSentTransferDetail(req.TokenAddress, req.LockSecretHash)
 	if err != nil {
-		result.Result <- rerr.ErrTransferNotFound.Append("can not found transfer status")
-		return
+		return nil, rerr.ErrTransferNotFound.Append("can not found transfer status")
 	}
 	if transferStatus.Status != models.TransferStatusCanCancel {
-		result.Result <- rerr.ErrTransferCannotCancel.Printf("status=%d", transferStatus.Status)
+		return nil, rerr.ErrTransferCannotCancel.Printf("status=%d", transferStatus.Status)
+	}
+	return
+}
+
+/*
+cancel a transfer before secret send
+only initiator can call
+*/
+func (rs *Service) cancelTransfer(req *cancelTransferReq) (result *utils.AsyncResult) {
+	result = utils.NewAsyncResult()
+	manager, err := rs.checkCancelTransfer(req)
+	if err != nil {
+		result.Result <- err
 		return
 	}
 	stateChange := &transfer.ActionCancelTransferStateChange{
@@ -1608,8 +2538,42 @@ func (rs *Service) cancelTransfer(req *cancelTransferReq) (result *utils.AsyncRe
 	return
 }
 
+/*
+handleSendFailed 消息放弃发送.
+合作关闭和取现请求达到最大发送次数时,对方可能已经收到并且签名,只是 ack 丢失了,
+这时候在本地恢复为 StateOpened 会和对方的通道状态不一致,对方甚至可以用这个请求完成 withdraw.
+所以保持通道状态不变,请求一旦发出去就只能关闭通道,通知用户主动 close 来和对方重新同步
+*/
+func (rs *Service) handleSendFailed(sentMessage *protocolMessage) {
+	var channelIdentifier common.Hash
+	var state channeltype.State
+	switch msg := sentMessage.Message.(type) {
+	case *encoding.SettleRequest:
+		channelIdentifier, state = msg.ChannelIdentifier, channeltype.StateCooprativeSettle
+	case *encoding.WithdrawRequest:
+		channelIdentifier, state = msg.ChannelIdentifier, channeltype.StateWithdraw
+	default:
+		return
+	}
+	c, err := rs.findChannelByIdentifier(channelIdentifier)
+	if err != nil {
+		return
+	}
+	if c.State != state {
+		return
+	}
+	notifyString := fmt.Sprintf("%s on channel %s is not acknowledged by partner,err=%s,channel keeps state %s,close it to resync with partner",
+		encoding.MessageType(sentMessage.Message.Cmd()), c.ChannelIdentifier.String(), sentMessage.err, c.State)
+	rs.NotifyHandler.NotifyString(notify.InfoTypeString, notifyString)
+	log.Warn(notifyString)
+}
+
 //recieve a ack from
 func (rs *Service) handleSentMessage(sentMessage *protocolMessage) {
+	if sentMessage.err != nil {
+		rs.handleSendFailed(sentMessage)
+		return
+	}
 	data := sentMessage.Message.Pack()
 	echohash := utils.Sha3(data, sentMessage.receiver[:])
 	_, ok2 := sentMessage.Message.(encoding.EnvelopMessager)
@@ -1625,7 +2589,9 @@ func (rs *Service) handleSentMessage(sentMessage *protocolMessage) {
 		}
 		smkey := utils.Sha3(msg.FakeLockSecretHash[:], ch.TokenAddress[:])
 		if r, ok := rs.Transfer2Result[smkey]; ok {
-			r.Result <- nil
+			r.Tag = transfer.OutcomeOffChain
+			r.SetResult(nil)
+			delete(rs.Transfer2Result, smkey)
 		}
 		std := rs.dao.UpdateSentTransferDetailStatus(ch.TokenAddress, msg.FakeLockSecretHash, models.TransferStatusSuccess, "DirectTransfer send success,transfer success", ch.ChannelIdentifier)
 		//rs.NotifyTransferStatusChange(ch.TokenAddress, msg.FakeLockSecretHash, models.TransferStatusSuccess, "DirectTransfer 发送成功,交易成功")
@@ -1665,9 +2631,15 @@ func (rs *Service) handleSentMessage(sentMessage *protocolMessage) {
 }
 
 /*
-GetNodeChargeFee implement of FeeCharger
+GetNodeChargeFee implement of FeeCharger,
+和 nodeAddress 有通道时,把通道当前的流动性交给实现了 fee.LiquidityCharger 的 FeePolicy
 */
 func (rs *Service) GetNodeChargeFee(nodeAddress, tokenAddress common.Address, amount *big.Int) *big.Int {
+	if g := rs.Token2ChannelGraph[tokenAddress]; g != nil {
+		if ch := g.PartenerAddress2Channel[nodeAddress]; ch != nil {
+			return fee.ChargeFee(rs.FeePolicy, nodeAddress, tokenAddress, amount, ch.Liquidity())
+		}
+	}
 	return rs.FeePolicy.GetNodeChargeFee(nodeAddress, tokenAddress, amount)
 }
 
@@ -1718,15 +2690,22 @@ func (rs *Service) handleReq(req *apiReq) {
 	switch req.Name {
 	case transferReqName: //mediated transfer only
 		r := req.Req.(*transferReq)
-		if r.IsDirectTransfer {
+		if err := rs.checkTransferReq(r); err != nil {
+			result = utils.NewAsyncResultWithError(err)
+		} else if r.IsDirectTransfer {
 			result = rs.directTransferAsync(r.TokenAddress, r.Target, r.Amount, r.Data)
+		} else if !r.QueueDeadline.IsZero() {
+			result = rs.startOrQueueMediatedTransfer(r)
+		} else if !r.CancelDeadline.IsZero() {
+			result = rs.startMediatedTransferWithTimeout(r)
 		} else {
 			result = rs.startMediatedTransfer(r.TokenAddress, r.Target, r.Amount, r.Secret, r.Data, r.RouteInfo)
 		}
+		rs.saveTransferMetadata(r.TokenAddress, result.LockSecretHash, r.Metadata)
 	case newChannelReqName:
 		r := req.Req.(*newChannelReq)
 		if r.amount != nil && r.amount.Cmp(utils.BigInt0) > 0 {
-			result = rs.newChannelAndDeposit(r.tokenAddress, r.partnerAddress, r.settleTimeout, r.amount, r.isNewChannel)
+			result = rs.newChannelAndDeposit(r.tokenAddress, r.partnerAddress, r.settleTimeout, r.amount, r.isNewChannel, r.force)
 		} else {
 			panic("amount must biggner than zero")
 		}
@@ -1738,10 +2717,18 @@ func (rs *Service) handleReq(req *apiReq) {
 		result = rs.closeOrSettleChannel(r.addr, req.Name)
 	case tokenSwapMakerReqName:
 		r := req.Req.(*tokenSwapMakerReq)
-		result = rs.tokenSwapMaker(r.tokenSwap)
+		if rs.paused {
+			result = utils.NewAsyncResultWithError(rerr.ErrPaused)
+		} else {
+			result = rs.tokenSwapMaker(r.tokenSwap)
+		}
 	case tokenSwapTakerReqName:
 		r := req.Req.(*tokenSwapTakerReq)
-		result = rs.tokenSwapTaker(r.tokenSwap)
+		if rs.paused {
+			result = utils.NewAsyncResultWithError(rerr.ErrPaused)
+		} else {
+			result = rs.tokenSwapTaker(r.tokenSwap)
+		}
 	case cooperativeSettleChannelReqName:
 		r := req.Req.(*closeSettleChannelReq)
 		result = rs.cooperativeSettleChannel(r.addr)
@@ -1772,12 +2759,70 @@ func (rs *Service) handleReq(req *apiReq) {
 	case registerSecretOnChainReqName:
 		r := req.Req.(*registerSecretReq)
 		result = rs.registerSecretOnChain(r)
+	case registerSecretToChannelsReqName:
+		r := req.Req.(*registerSecretReq)
+		result = rs.registerSecretToChannels(r.Secret)
 	case getUnfinishedReceviedTransferReqName:
 		r := req.Req.(*getUnfinishedReceivedTransferReq)
 		result = rs.getUnfinishedReceivedTransfer(r)
 	case forceUnlockReqName:
 		r := req.Req.(*forceUnlockReq)
 		result = rs.forceUnlock(r)
+	case getQueuedTransfersReqName:
+		result = rs.getQueuedTransfers()
+	case cancelQueuedTransferReqName:
+		r := req.Req.(*cancelQueuedTransferReq)
+		result = rs.cancelQueuedTransfer(r.QueueID)
+	case repairChannelFromChainReqName:
+		r := req.Req.(*repairChannelFromChainReq)
+		result = rs.repairChannelFromChain(r)
+	case setDefaultRevealTimeoutReqName:
+		r := req.Req.(*setDefaultRevealTimeoutReq)
+		result = rs.setDefaultRevealTimeout(r.RevealTimeout)
+	case updateRouteDenylistReqName:
+		r := req.Req.(*updateRouteDenylistReq)
+		result = rs.updateRouteDenylist(r.Addr, r.Remove)
+	case settleAllReadyReqName:
+		result = rs.settleAllReady()
+	case getLoopStatsReqName:
+		result = rs.getLoopStats()
+	case getStuckTransfersReqName:
+		result = rs.getStuckTransfers()
+	case setPausedReqName:
+		r := req.Req.(*setPausedReq)
+		result = rs.setPaused(r.Paused)
+	case getReachableTargetsReqName:
+		r := req.Req.(*getReachableTargetsReq)
+		result = rs.getReachableTargets(r.TokenAddress, r.Amount)
+	case exportCapacityReportReqName:
+		r := req.Req.(*exportCapacityReportReq)
+		result = rs.exportCapacityReport(r.TokenAddress)
+	case updateBalanceProofReqName:
+		r := req.Req.(*closeSettleChannelReq)
+		result = rs.updateBalanceProof(r.addr)
+	case previewCooperativeSettleReqName:
+		r := req.Req.(*closeSettleChannelReq)
+		result = rs.previewCooperativeSettle(r.addr)
+	case prepareTransferReqName:
+		r := req.Req.(*transferReq)
+		result = rs.prepareTransfer(r)
+	case getKnownSecretsReqName:
+		result = rs.getKnownSecrets()
+	case reloadChannelReqName:
+		r := req.Req.(*closeSettleChannelReq)
+		result = rs.reloadChannel(r.addr)
+	case dumpStateReqName:
+		result = rs.dumpState()
+	case getPendingTokenSwapsReqName:
+		result = rs.getPendingTokenSwaps()
+	case cancelTokenSwapReqName:
+		r := req.Req.(*cancelTransferReq)
+		result = rs.cancelTokenSwap(r.LockSecretHash, r.TokenAddress)
+	case verifyChannelProofsReqName:
+		r := req.Req.(*closeSettleChannelReq)
+		result = rs.verifyChannelProofs(r.addr)
+	case getSafeToTransactReqName:
+		result = rs.getSafeToTransact()
 	default:
 		panic("unkown req")
 	}
@@ -1801,10 +2846,13 @@ func (rs *Service) UpdateChannelAndSaveAck(c *channel.Channel, tag interface{})
 	echohash := t.EchoHash
 	ack := rs.Protocol.CreateAck(echohash)
 	cs := channel.NewChannelSerialization(c)
-	err := rs.dao.UpdateChannelAndSaveAck(cs, echohash, ack.Pack())
+	err := rs.dao.UpdateChannelAndSaveAck(cs, echohash, ack.Pack(), rs.GetBlockNumber())
 	if err != nil {
 		log.Error(fmt.Sprintf("UpdateChannelAndSaveAck %s", err))
+	} else {
+		rs.recordBalancePoint(cs)
 	}
+	rs.channelChanged()
 	rs.NotifyHandler.NotifyChannelStatus(channeltype.ChannelSerialization2ChannelDataDetail(cs))
 }
 
@@ -1814,6 +2862,8 @@ func (rs *Service) UpdateChannel(c *channeltype.Serialization, tx models.TX) err
 	if err != nil {
 		return err
 	}
+	//tx 还没有提交,余额记录由调用者在 Commit 以后调用 recordBalancePoint
+	rs.channelChanged()
 	rs.NotifyHandler.NotifyChannelStatus(channeltype.ChannelSerialization2ChannelDataDetail(c))
 	return nil
 }
@@ -1824,6 +2874,8 @@ func (rs *Service) UpdateChannelNoTx(c *channeltype.Serialization) error {
 	if err != nil {
 		return err
 	}
+	rs.recordBalancePoint(c)
+	rs.channelChanged()
 	rs.NotifyHandler.NotifyChannelStatus(channeltype.ChannelSerialization2ChannelDataDetail(c))
 	return nil
 }
@@ -1834,6 +2886,8 @@ func (rs *Service) UpdateChannelState(c *channeltype.Serialization) error {
 	if err != nil {
 		return err
 	}
+	rs.recordBalancePoint(c)
+	rs.channelChanged()
 	rs.NotifyHandler.NotifyChannelStatus(channeltype.ChannelSerialization2ChannelDataDetail(c))
 	return nil
 }
@@ -1844,6 +2898,8 @@ func (rs *Service) UpdateChannelContractBalance(c *channeltype.Serialization) er
 	if err != nil {
 		return err
 	}
+	rs.recordBalancePoint(c)
+	rs.channelChanged()
 	rs.NotifyHandler.NotifyChannelStatus(channeltype.ChannelSerialization2ChannelDataDetail(c))
 	return nil
 }
@@ -2018,13 +3074,16 @@ func (rs *Service) getBestRoutesFromPfs(peerFrom, peerTo, token common.Address,
 		if path.Result == nil || path.Result[0] == "" {
 			continue
 		}
+		if rs.hasDeniedHop(path.GetPath(), peerTo) {
+			continue
+		}
 		partnerAddress := common.HexToAddress(path.Result[0])
 		ch := rs.getChannel(token, partnerAddress)
 		if ch == nil {
 			continue
 		}
 		r := route.NewState(ch, path.GetPath())
-		r.Fee = rs.FeePolicy.GetNodeChargeFee(partnerAddress, token, amount)
+		r.Fee = rs.GetNodeChargeFee(partnerAddress, token, amount)
 		r.TotalFee = path.Fee
 		routes = append(routes, r)
 	}
@@ -2130,6 +3189,137 @@ func (rs *Service) forceUnlock(req *forceUnlockReq) (result *utils.AsyncResult)
 	return
 }
 
+/*
+RepairChannelFromChain 以链上数据为准修复本地通道信息
+1. 押金,关闭块以及settle块以链上为准
+2. 链上不记录链下的交易金额,所以本地的balance proof保持不变
+3. 本地没有处理过的关闭,按照收到关闭事件处理,提交对方的 balance proof 以及解锁
+*/
+/*
+RepairChannelFromChain reconciles local channel with the contract.
+contract balances and closed/settle blocks are authoritative on chain,
+but off-chain balance proofs are kept since transferred amounts are not on chain.
+*/
+func (rs *Service) RepairChannelFromChain(channelIdentifier common.Hash) error {
+	c, err := rs.dao.GetChannelByAddress(channelIdentifier)
+	if err != nil {
+		return rerr.ErrChannelNotFound.Printf("can not find channel %s", channelIdentifier.String())
+	}
+	tokenNetwork, err := rs.Chain.TokenNetwork(c.TokenAddress())
+	if err != nil {
+		return err
+	}
+	id, settleBlockNumber, openBlockNumber, state, settleTimeout, err := tokenNetwork.GetChannelInfo(c.OurAddress, c.PartnerAddress())
+	if err != nil {
+		return rerr.ErrContractQueryError.Errorf("GetChannelInfo err %s", err)
+	}
+	if state == contracts.ChannelStateSettledOrNotExist {
+		return rerr.ErrChannelNotFound.Printf("channel %s settled or not exist on chain", utils.HPex(channelIdentifier))
+	}
+	if id != channelIdentifier {
+		return rerr.ErrChannelIdentifierMismatch.Printf("local channel %s,but chain channel %s", utils.HPex(channelIdentifier), utils.HPex(id))
+	}
+	infos := tokenNetwork.GetChannelParticipantInfos([]rpc.ChannelParticipantQuery{
+		{Participant: c.OurAddress, Partner: c.PartnerAddress()},
+		{Participant: c.PartnerAddress(), Partner: c.OurAddress},
+	}, 2)
+	for _, info := range infos {
+		if info.Err != nil {
+			return rerr.ErrContractQueryError.Errorf("GetChannelParticipantInfo err %s", info.Err)
+		}
+	}
+	ourDeposit, ourNonce := infos[0].Deposit, infos[0].Nonce
+	partnerDeposit, partnerNonce := infos[1].Deposit, infos[1].Nonce
+	var closed *mediatedtransfer.ContractClosedStateChange
+	if state == contracts.ChannelStateClosed {
+		//closed 状态下SettleBlockNumber是合约允许settle的块,也就是关闭块+settle timeout
+		ev, err := tokenNetwork.GetChannelClosedEvent(channelIdentifier, settleBlockNumber-settleTimeout)
+		if err != nil {
+			return rerr.ErrContractQueryError.Errorf("GetChannelClosedEvent err %s", err)
+		}
+		closed = &mediatedtransfer.ContractClosedStateChange{
+			ChannelIdentifier: channelIdentifier,
+			ClosingAddress:    ev.ClosingParticipant,
+			LocksRoot:         ev.Locksroot,
+			ClosedBlock:       int64(ev.Raw.BlockNumber),
+			TransferredAmount: ev.TransferredAmount,
+		}
+		if closed.TransferredAmount == nil {
+			closed.TransferredAmount = new(big.Int)
+		}
+	}
+	result := rs.repairChannelFromChainClient(&repairChannelFromChainReq{
+		ChannelIdentifier: channelIdentifier,
+		OpenBlockNumber:   int64(openBlockNumber),
+		State:             state,
+		SettleBlockNumber: settleBlockNumber,
+		SettleTimeout:     settleTimeout,
+		OurDeposit:        ourDeposit,
+		PartnerDeposit:    partnerDeposit,
+		OurNonce:          ourNonce,
+		PartnerNonce:      partnerNonce,
+		Closed:            closed,
+	})
+	return <-result.Result
+}
+
+//repairChannelFromChain 必须在主线程中修改通道,否则会和交易处理冲突
+func (rs *Service) repairChannelFromChain(req *repairChannelFromChainReq) (result *utils.AsyncResult) {
+	result = utils.NewAsyncResult()
+	c := rs.getChannelWithAddr(req.ChannelIdentifier)
+	if c == nil {
+		result.Result <- rerr.ErrChannelNotFound.Printf("can not find channel %s", req.ChannelIdentifier.String())
+		return
+	}
+	// 查询期间通道可能已经被重新打开或者取现
+	if c.ChannelIdentifier.OpenBlockNumber != req.OpenBlockNumber {
+		result.Result <- rerr.ErrChannelIdentifierMismatch.Printf("channel %s open block number local=%d,chain=%d",
+			utils.HPex(req.ChannelIdentifier), c.ChannelIdentifier.OpenBlockNumber, req.OpenBlockNumber)
+		return
+	}
+	if c.OurState.ContractBalance.Cmp(req.OurDeposit) != 0 {
+		log.Info(fmt.Sprintf("repair channel %s our deposit %s->%s", utils.HPex(req.ChannelIdentifier), c.OurState.ContractBalance, req.OurDeposit))
+		c.OurState.ContractBalance = new(big.Int).Set(req.OurDeposit)
+		//不知道存款所在的块,按当前块计算确认
+		c.OurState.DepositBlockNumber = rs.GetBlockNumber()
+	}
+	if c.PartnerState.ContractBalance.Cmp(req.PartnerDeposit) != 0 {
+		log.Info(fmt.Sprintf("repair channel %s partner deposit %s->%s", utils.HPex(req.ChannelIdentifier), c.PartnerState.ContractBalance, req.PartnerDeposit))
+		c.PartnerState.ContractBalance = new(big.Int).Set(req.PartnerDeposit)
+		c.PartnerState.DepositBlockNumber = rs.GetBlockNumber()
+	}
+	if req.State == contracts.ChannelStateClosed {
+		//closed 状态下SettleBlockNumber是合约允许settle的块,也就是关闭块+settle timeout
+		closedBlock := int64(req.SettleBlockNumber - req.SettleTimeout)
+		if c.State == channeltype.StateClosed && c.ExternState.ClosedBlock != closedBlock {
+			log.Info(fmt.Sprintf("repair channel %s closedBlock %d->%d", utils.HPex(req.ChannelIdentifier), c.ExternState.ClosedBlock, closedBlock))
+			c.ExternState.ClosedBlock = closedBlock
+			c.ExternState.SettledBlock = closedBlock + int64(c.SettleTimeout) + params.PunishBlockNumber
+		}
+	} else if req.SettleTimeout > 0 && int(req.SettleTimeout) != c.SettleTimeout {
+		//opened 状态下SettleBlockNumber是0,settle timeout 由合约单独返回
+		log.Info(fmt.Sprintf("repair channel %s settle timeout %d->%d", utils.HPex(req.ChannelIdentifier), c.SettleTimeout, req.SettleTimeout))
+		c.SettleTimeout = int(req.SettleTimeout)
+	}
+	// 链上的balance proof 只有hash,无法恢复,只能提示
+	if req.OurNonce > c.OurState.BalanceProofState.Nonce {
+		log.Error(fmt.Sprintf("channel %s our nonce on chain=%d,local=%d,local balance proof is stale",
+			utils.HPex(req.ChannelIdentifier), req.OurNonce, c.OurState.BalanceProofState.Nonce))
+	}
+	if req.PartnerNonce > c.PartnerState.BalanceProofState.Nonce {
+		log.Error(fmt.Sprintf("channel %s partner nonce on chain=%d,local=%d,local balance proof is stale",
+			utils.HPex(req.ChannelIdentifier), req.PartnerNonce, c.PartnerState.BalanceProofState.Nonce))
+	}
+	err := rs.UpdateChannelNoTx(channel.NewChannelSerialization(c))
+	if err == nil && req.Closed != nil && c.State != channeltype.StateClosed {
+		//错过了关闭事件,和收到关闭事件一样处理,否则不会提交对方的 balance proof,也不会解锁
+		log.Info(fmt.Sprintf("repair channel %s state %s->closed,closedBlock=%d", utils.HPex(req.ChannelIdentifier), c.State, req.Closed.ClosedBlock))
+		err = rs.StateMachineEventHandler.OnBlockchainStateChange(req.Closed)
+	}
+	result.Result <- err
+	return
+}
+
 func (rs *Service) getUnfinishedReceivedTransfer(req *getUnfinishedReceivedTransferReq) (result *utils.AsyncResult) {
 	lockSecretHash := req.LockSecretHash
 	tokenAddress := req.TokenAddress
@@ -2303,7 +3493,7 @@ func (rs *Service) GetDelegateForPms(c *channeltype.Serialization, thirdAddr com
 		c3.UpdateTransfer.Locksroot = c.PartnerBalanceProof.LocksRoot
 		c3.UpdateTransfer.ExtraHash = c.PartnerBalanceProof.MessageHash
 		c3.UpdateTransfer.ClosingSignature = c.PartnerBalanceProof.Signature
-		sig, err = pmsproxy.SignBalanceProofFor3rd(c, rs.PrivateKey)
+		sig, err = pmsproxy.SignBalanceProofFor3rd(c, rs.Signer)
 		if err != nil {
 			return
 		}
@@ -2318,7 +3508,7 @@ func (rs *Service) GetDelegateForPms(c *channeltype.Serialization, thirdAddr com
 			Lock:        l,
 			MerkleProof: mtree.Proof2Bytes(proof.MerkleProof),
 		}
-		w.Signature, err = pmsproxy.SignUnlockFor3rd(c, w, thirdAddr, rs.PrivateKey)
+		w.Signature, err = pmsproxy.SignUnlockFor3rd(c, w, thirdAddr, rs.Signer)
 		ws = append(ws, w)
 	}
 	c3.Unlocks = ws
@@ -0,0 +1,615 @@
+package photon
+
+import (
+	"encoding/json"
+	"math/big"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/network"
+	"github.com/SmartMeshFoundation/Photon/network/graph"
+	"github.com/SmartMeshFoundation/Photon/network/rpc"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/fee"
+	"github.com/SmartMeshFoundation/Photon/notify"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/pfsproxy"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/transfer/route"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckTransferAmount(t *testing.T) {
+	rs := &Service{Config: &params.Config{}}
+	assert.NotNil(t, rs.checkTransferAmount(nil))
+	assert.NotNil(t, rs.checkTransferAmount(big.NewInt(0)))
+	assert.NotNil(t, rs.checkTransferAmount(big.NewInt(-1)))
+	assert.Nil(t, rs.checkTransferAmount(big.NewInt(1)))
+
+	rs.Config.MinTransferAmount = big.NewInt(10)
+	assert.NotNil(t, rs.checkTransferAmount(big.NewInt(9)))
+	assert.Nil(t, rs.checkTransferAmount(big.NewInt(10)))
+	assert.Nil(t, rs.checkTransferAmount(big.NewInt(11)))
+	assert.NotNil(t, rs.checkTransferAmount(big.NewInt(0)))
+}
+
+func TestDirectTransferNonPositiveAmount(t *testing.T) {
+	rs := &Service{Config: &params.Config{}}
+	for _, amount := range []*big.Int{nil, big.NewInt(0), big.NewInt(-1)} {
+		result := rs.directTransferAsync(utils.NewRandomAddress(), utils.NewRandomAddress(), amount, "")
+		err := <-result.Result
+		e, ok := err.(rerr.StandardError)
+		assert.True(t, ok && e.ErrorCode == rerr.ErrAmountTooSmall.ErrorCode, "amount=%s err=%v", amount, err)
+	}
+}
+
+func TestCheckNoPendingDeposit(t *testing.T) {
+	rs := &Service{dao: codefortest.NewTestDB("")}
+	defer rs.dao.CloseDB()
+	channelIdentifier := utils.NewRandomHash()
+	assert.Nil(t, rs.checkNoPendingDeposit(channelIdentifier))
+
+	//其他通道的存款和本通道的其他交易不影响
+	tx := types.NewTransaction(1, utils.NewRandomAddress(), big.NewInt(1), 0, nil, nil)
+	_, err := rs.dao.NewPendingTXInfo(tx, models.TXInfoTypeDeposit, utils.NewRandomHash(), 0, "")
+	assert.Nil(t, err)
+	tx = types.NewTransaction(2, utils.NewRandomAddress(), big.NewInt(1), 0, nil, nil)
+	_, err = rs.dao.NewPendingTXInfo(tx, models.TXInfoTypeClose, channelIdentifier, 3, "")
+	assert.Nil(t, err)
+	assert.Nil(t, rs.checkNoPendingDeposit(channelIdentifier))
+
+	//重复点击存款
+	tx = types.NewTransaction(3, utils.NewRandomAddress(), big.NewInt(1), 0, nil, nil)
+	_, err = rs.dao.NewPendingTXInfo(tx, models.TXInfoTypeApproveDeposit, channelIdentifier, 0, "")
+	assert.Nil(t, err)
+	err = rs.checkNoPendingDeposit(channelIdentifier)
+	e, ok := err.(rerr.StandardError)
+	assert.True(t, ok && e.ErrorCode == rerr.ErrChannelDepositInProgress.ErrorCode, "err=%v", err)
+
+	//打包以后可以再次存款
+	_, err = rs.dao.UpdateTXInfoStatus(tx.Hash(), models.TXInfoStatusSuccess, 10, 0)
+	assert.Nil(t, err)
+	assert.Nil(t, rs.checkNoPendingDeposit(channelIdentifier))
+}
+
+//授权方式新建通道时 approve 成功以后 deposit 失败,以及已有通道的存款失败,客户端需要区分两种情况
+func TestGetChannelOpenResult(t *testing.T) {
+	rs := &Service{
+		NodeAddress: utils.NewRandomAddress(),
+		Chain:       &rpc.BlockChainService{},
+		dao:         codefortest.NewTestDB(""),
+	}
+	defer rs.dao.CloseDB()
+	token, partner := utils.NewRandomAddress(), utils.NewRandomAddress()
+	channelIdentifier := utils.CalcChannelID(token, rs.Chain.GetRegistryAddress(), partner, rs.NodeAddress)
+	r, err := rs.getChannelOpenResult(token, partner)
+	assert.Nil(t, err)
+	assert.EqualValues(t, &ChannelOpenResult{}, r)
+
+	//approve 打包以后 deposit 还没有提交
+	approve := types.NewTransaction(1, utils.NewRandomAddress(), big.NewInt(1), 0, nil, nil)
+	_, err = rs.dao.NewPendingTXInfo(approve, models.TXInfoTypeApproveDeposit, channelIdentifier, 0, "")
+	assert.Nil(t, err)
+	_, err = rs.dao.UpdateTXInfoStatus(approve.Hash(), models.TXInfoStatusSuccess, 10, 0)
+	assert.Nil(t, err)
+	r, err = rs.getChannelOpenResult(token, partner)
+	assert.Nil(t, err)
+	assert.True(t, r.DepositPending)
+	assert.False(t, r.ChannelOpened)
+
+	//deposit 失败,通道也没有创建
+	deposit := types.NewTransaction(2, utils.NewRandomAddress(), big.NewInt(1), 0, nil, nil)
+	_, err = rs.dao.NewPendingTXInfo(deposit, models.TXInfoTypeDeposit, channelIdentifier, 0, "")
+	assert.Nil(t, err)
+	_, err = rs.dao.UpdateTXInfoStatus(deposit.Hash(), models.TXInfoStatusFailed, 11, 0)
+	assert.Nil(t, err)
+	r, err = rs.getChannelOpenResult(token, partner)
+	assert.Nil(t, err)
+	assert.False(t, r.ChannelOpened)
+	assert.EqualValues(t, utils.EmptyHash, r.ChannelIdentifier)
+	assert.True(t, r.DepositFailed)
+	if assert.NotNil(t, r.FailedTX) {
+		assert.EqualValues(t, deposit.Hash(), r.FailedTX.TXHash)
+	}
+
+	//通道已经创建,再次存款失败,只需要重试存款
+	c := channeltype.NewEmptySerialization()
+	c.ChannelIdentifier.ChannelIdentifier = channelIdentifier
+	c.ChannelIdentifier.OpenBlockNumber = 12
+	c.Key = channelIdentifier[:]
+	c.TokenAddressBytes = token[:]
+	c.OurAddress = rs.NodeAddress
+	c.PartnerAddressBytes = partner[:]
+	c.State = channeltype.StateOpened
+	assert.Nil(t, rs.dao.NewChannel(c))
+	r, err = rs.getChannelOpenResult(token, partner)
+	assert.Nil(t, err)
+	assert.True(t, r.ChannelOpened)
+	assert.EqualValues(t, channelIdentifier, r.ChannelIdentifier)
+	assert.True(t, r.DepositFailed)
+	assert.False(t, r.DepositConfirmed)
+}
+
+//存款交易失败以后不再跟踪,之后的通道事件不会再送到 progress
+func TestWatchChannelOpenAndDeposit(t *testing.T) {
+	rs := &Service{
+		NodeAddress: utils.NewRandomAddress(),
+		Chain:       &rpc.BlockChainService{},
+		dao:         codefortest.NewTestDB(""),
+	}
+	defer rs.dao.CloseDB()
+	token, partner := utils.NewRandomAddress(), utils.NewRandomAddress()
+	channelIdentifier := utils.CalcChannelID(token, rs.Chain.GetRegistryAddress(), partner, rs.NodeAddress)
+	newTX := func(nonce uint64, txType models.TXInfoType, channelIdentifier common.Hash, status models.TXInfoStatus) {
+		tx := types.NewTransaction(nonce, utils.NewRandomAddress(), big.NewInt(1), 0, nil, nil)
+		_, err := rs.dao.NewPendingTXInfo(tx, txType, channelIdentifier, 0, "")
+		assert.Nil(t, err)
+		_, err = rs.dao.UpdateTXInfoStatus(tx.Hash(), status, 10, 0)
+		assert.Nil(t, err)
+	}
+	c := channeltype.NewEmptySerialization()
+	c.ChannelIdentifier.ChannelIdentifier = channelIdentifier
+	c.ChannelIdentifier.OpenBlockNumber = 12
+	c.Key = channelIdentifier[:]
+	c.TokenAddressBytes = token[:]
+	c.OurAddress = rs.NodeAddress
+	c.PartnerAddressBytes = partner[:]
+	c.State = channeltype.StateOpened
+	c.OurContractBalance = big.NewInt(10)
+
+	p := rs.watchChannelOpenAndDeposit(token, partner, big.NewInt(10), true)
+	//其他通道的失败和本通道 approve 成功都不影响
+	newTX(1, models.TXInfoTypeDeposit, utils.NewRandomHash(), models.TXInfoStatusFailed)
+	newTX(2, models.TXInfoTypeApproveDeposit, channelIdentifier, models.TXInfoStatusSuccess)
+	assert.False(t, p.isCanceled())
+	newTX(3, models.TXInfoTypeDeposit, channelIdentifier, models.TXInfoStatusFailed)
+	assert.True(t, p.isCanceled())
+	assert.Nil(t, rs.dao.NewChannel(c))
+	assert.Nil(t, rs.dao.UpdateChannelContractBalance(c))
+	assert.Len(t, p.ChannelCreated, 0)
+	assert.Len(t, p.DepositConfirmed, 0)
+
+	//存款确认以后结束跟踪
+	p = rs.watchChannelOpenAndDeposit(token, partner, big.NewInt(10), false)
+	assert.Nil(t, rs.dao.UpdateChannelContractBalance(c))
+	assert.Len(t, p.DepositConfirmed, 1)
+	assert.True(t, p.isCanceled())
+}
+
+func TestNewChannelWithExistingChannel(t *testing.T) {
+	our, partner, token := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	c, err := channel.NewChannel(channel.NewChannelEndState(our, big.NewInt(100), nil, mtree.EmptyTree),
+		channel.NewChannelEndState(partner, big.NewInt(50), nil, mtree.EmptyTree), &channel.ExternalState{}, token,
+		&contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash(), OpenBlockNumber: 3}, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewChannelGraph(our, token, nil)
+	assert.Nil(t, g.AddChannel(c))
+	rs := &Service{
+		NodeAddress:        our,
+		Config:             &params.Config{},
+		Token2ChannelGraph: map[common.Address]*graph.ChannelGraph{token: g},
+	}
+	settleTimeout := rs.minAcceptableSettleTimeout()
+	//关闭或者正在结算的通道还在,合约上的打开一定会失败,不会发出 tx
+	for _, state := range []channeltype.State{channeltype.StateOpened, channeltype.StateClosed, channeltype.StateSettling} {
+		c.State = state
+		err := <-rs.newChannelAndDeposit(token, partner, settleTimeout, big.NewInt(10), true, true).Result
+		if assert.NotNil(t, err, "state=%s", state) {
+			assert.Equal(t, rerr.ErrChannelAlreadExist.ErrorCode, err.(rerr.StandardError).ErrorCode)
+		}
+	}
+}
+
+func TestNewChannelMaxChannelsPerPartner(t *testing.T) {
+	our, partner, token := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	c, err := channel.NewChannel(channel.NewChannelEndState(our, big.NewInt(100), nil, mtree.EmptyTree),
+		channel.NewChannelEndState(partner, big.NewInt(50), nil, mtree.EmptyTree), &channel.ExternalState{}, token,
+		&contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash(), OpenBlockNumber: 3}, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewChannelGraph(our, token, nil)
+	assert.Nil(t, g.AddChannel(c))
+	rs := &Service{
+		NodeAddress:        our,
+		Config:             &params.Config{},
+		Token2ChannelGraph: map[common.Address]*graph.ChannelGraph{token: g},
+	}
+	settleTimeout := rs.minAcceptableSettleTimeout()
+	//打开的通道达到上限
+	err = <-rs.newChannelAndDeposit(token, partner, settleTimeout, big.NewInt(10), true, true).Result
+	if assert.NotNil(t, err) {
+		assert.Equal(t, rerr.ErrChannelAlreadExist.ErrorCode, err.(rerr.StandardError).ErrorCode)
+		assert.Contains(t, err.Error(), "already has 1 channels")
+	}
+	//关闭的通道不计入上限,但是合约上仍然不能再打开
+	c.State = channeltype.StateClosed
+	err = <-rs.newChannelAndDeposit(token, partner, settleTimeout, big.NewInt(10), true, true).Result
+	if assert.NotNil(t, err) {
+		assert.NotContains(t, err.Error(), "already has")
+	}
+}
+
+func TestCheckMediationFee(t *testing.T) {
+	rs := &Service{Config: &params.Config{}}
+	cheap := &route.State{Fee: big.NewInt(1)}
+	noFee := &route.State{}
+	routes, err := rs.checkMediationFee([]*route.State{cheap, noFee})
+	assert.Nil(t, err)
+	assert.Len(t, routes, 2)
+
+	rs.Config.MinMediationFee = big.NewInt(5)
+	expensive := &route.State{Fee: big.NewInt(5)}
+	routes, err = rs.checkMediationFee([]*route.State{cheap, expensive, noFee})
+	assert.Nil(t, err)
+	assert.Equal(t, []*route.State{expensive}, routes)
+
+	//所有路由的手续费都低于下限,拒绝中转,原因中带有下限
+	_, err = rs.checkMediationFee([]*route.State{cheap, noFee})
+	e, ok := err.(rerr.StandardError)
+	if assert.True(t, ok, "err=%v", err) {
+		assert.Equal(t, rerr.ErrMediationFeeTooLow.ErrorCode, e.ErrorCode)
+		assert.Contains(t, e.Error(), "min mediation fee 5")
+	}
+}
+
+//scarceFeePolicy 我这一侧的余额低于容量的一半时手续费加倍
+type scarceFeePolicy struct {
+	liquidity *fee.ChannelLiquidity
+}
+
+func (p *scarceFeePolicy) GetNodeChargeFee(nodeAddress, tokenAddress common.Address, amount *big.Int) *big.Int {
+	return big.NewInt(1)
+}
+
+func (p *scarceFeePolicy) GetNodeChargeFeeWithLiquidity(nodeAddress, tokenAddress common.Address, amount *big.Int, liquidity *fee.ChannelLiquidity) *big.Int {
+	p.liquidity = liquidity
+	if new(big.Int).Mul(liquidity.OurBalance, big.NewInt(2)).Cmp(liquidity.Capacity) < 0 {
+		return big.NewInt(2)
+	}
+	return big.NewInt(1)
+}
+
+func TestLiquidityAwareFee(t *testing.T) {
+	our, partner, token := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	channelID := &contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash(), OpenBlockNumber: 3}
+	ourState := channel.NewChannelEndState(our, big.NewInt(30), nil, mtree.EmptyTree)
+	partnerState := channel.NewChannelEndState(partner, big.NewInt(70), nil, mtree.EmptyTree)
+	c, err := channel.NewChannel(ourState, partnerState, &channel.ExternalState{}, token, channelID, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewChannelGraph(our, token, nil)
+	assert.Nil(t, g.AddChannel(c))
+	policy := &scarceFeePolicy{}
+	rs := &Service{
+		NodeAddress:        our,
+		Token2ChannelGraph: map[common.Address]*graph.ChannelGraph{token: g},
+		FeePolicy:          policy,
+	}
+	assert.EqualValues(t, 2, rs.GetNodeChargeFee(partner, token, big.NewInt(10)).Int64())
+	if assert.NotNil(t, policy.liquidity) {
+		assert.Equal(t, channelID.ChannelIdentifier, policy.liquidity.ChannelIdentifier)
+		assert.EqualValues(t, 30, policy.liquidity.OurBalance.Int64())
+		assert.EqualValues(t, 70, policy.liquidity.PartnerBalance.Int64())
+		assert.EqualValues(t, 100, policy.liquidity.Capacity.Int64())
+	}
+	//没有通道时不知道流动性
+	policy.liquidity = nil
+	assert.EqualValues(t, 1, rs.GetNodeChargeFee(utils.NewRandomAddress(), token, big.NewInt(10)).Int64())
+	assert.Nil(t, policy.liquidity)
+
+	//只实现了 fee.Charger 的 FeePolicy 不受影响
+	rs.FeePolicy = &NoFeePolicy{}
+	assert.EqualValues(t, 0, rs.GetNodeChargeFee(partner, token, big.NewInt(10)).Int64())
+}
+
+func TestLockExpirationCap(t *testing.T) {
+	rs := &Service{Config: &params.Config{}}
+	assert.EqualValues(t, 0, rs.capLockExpiration(0, 100))
+	assert.EqualValues(t, 10000, rs.capLockExpiration(10000, 100))
+	assert.Nil(t, rs.checkLockExpiration(10000, 100))
+
+	rs.Config.MaxLockExpirationBlocks = 500
+	assert.EqualValues(t, 600, rs.capLockExpiration(0, 100))
+	assert.EqualValues(t, 600, rs.capLockExpiration(10000, 100))
+	assert.EqualValues(t, 300, rs.capLockExpiration(300, 100))
+
+	assert.Nil(t, rs.checkLockExpiration(600, 100))
+	//发起方的块号领先,按上限设置的过期时间在我看来超过了上限,误差之内仍然接受
+	initiatorBlock := int64(100)
+	expiration := rs.capLockExpiration(0, initiatorBlock)
+	assert.Nil(t, rs.checkLockExpiration(expiration, initiatorBlock-1))
+	assert.Nil(t, rs.checkLockExpiration(expiration, initiatorBlock-params.LockExpirationSkewBlocks))
+	assert.NotNil(t, rs.checkLockExpiration(expiration, initiatorBlock-params.LockExpirationSkewBlocks-1))
+	//上家的锁定时间太长,拒绝中转,原因中带有上限
+	err := rs.checkLockExpiration(601+params.LockExpirationSkewBlocks, 100)
+	e, ok := err.(rerr.StandardError)
+	if assert.True(t, ok, "err=%v", err) {
+		assert.Equal(t, rerr.ErrLockExpirationTooFar.ErrorCode, e.ErrorCode)
+		assert.Contains(t, e.Error(), "max lock expiration blocks 500")
+	}
+}
+
+func TestStopHealthCheckFor(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := &presenceTransport{sent: make(map[common.Address]int)}
+	rs := &Service{
+		Config:              &params.Config{EnableHealthCheck: true},
+		Protocol:            network.NewPhotonProtocol(tr, key, nil),
+		Clock:               utils.NewRealClock(),
+		HealthCheckMap:      make(map[common.Address]chan struct{}),
+		healthCheckNow:      make(map[common.Address]chan time.Time),
+		networkReachability: newNetworkReachability(),
+	}
+	b := utils.NewRandomAddress()
+	rs.startHealthCheckFor(b)
+	rs.startHealthCheckFor(b)
+	assert.Len(t, rs.HealthCheckMap, 1)
+	waitFor := func(cond func() bool) bool {
+		deadline := time.Now().Add(time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				return false
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return true
+	}
+	reachable := func() bool {
+		n := rs.networkReachability
+		n.lock.Lock()
+		defer n.lock.Unlock()
+		_, ok := n.reachable[b]
+		return ok
+	}
+	assert.True(t, waitFor(reachable), "health check not started")
+
+	//停止以后goroutine退出,b 不再参与汇总
+	rs.StopHealthCheckFor(b)
+	assert.Len(t, rs.HealthCheckMap, 0)
+	assert.True(t, waitFor(func() bool { return !reachable() }), "health check not stopped")
+	rs.StopHealthCheckFor(b)
+
+	//可以重新开始
+	rs.startHealthCheckFor(b)
+	assert.Len(t, rs.HealthCheckMap, 1)
+	assert.True(t, waitFor(reachable), "health check not restarted")
+	rs.StopHealthCheckFor(b)
+}
+
+//用户指定的路由第一跳不在线时立即失败,而不是发出以后等重试超时
+func TestFindMediatedRoutesOfflineFirstHop(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	our, target := utils.NewRandomAddress(), utils.NewRandomAddress()
+	offline, online := utils.NewRandomAddress(), utils.NewRandomAddress()
+	token := utils.NewRandomAddress()
+	g := graph.NewChannelGraph(our, token, nil)
+	for _, partner := range []common.Address{offline, online} {
+		ourState := channel.NewChannelEndState(our, big.NewInt(100), nil, mtree.EmptyTree)
+		partnerState := channel.NewChannelEndState(partner, big.NewInt(100), nil, mtree.EmptyTree)
+		c, err := channel.NewChannel(ourState, partnerState, &channel.ExternalState{}, token,
+			&contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash(), OpenBlockNumber: 3}, 5, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Nil(t, g.AddChannel(c))
+	}
+	tr := &presenceTransport{
+		sent:    make(map[common.Address]int),
+		offline: map[common.Address]bool{offline: true},
+	}
+	rs := &Service{
+		NodeAddress:        our,
+		Config:             &params.Config{},
+		Protocol:           network.NewPhotonProtocol(tr, key, nil),
+		Token2ChannelGraph: map[common.Address]*graph.ChannelGraph{token: g},
+		feeQuotes:          newFeeQuoteCache(),
+		IsChainEffective:   true,
+	}
+	path := func(hop common.Address) pfsproxy.FindPathResponse {
+		return pfsproxy.FindPathResponse{Result: []string{hop.String(), target.String()}, Fee: big.NewInt(1)}
+	}
+	routes, err := rs.findMediatedRoutes(token, target, big.NewInt(10), []pfsproxy.FindPathResponse{path(offline), path(online)})
+	assert.Nil(t, err)
+	if assert.Len(t, routes, 1) {
+		assert.Equal(t, online, routes[0].HopNode())
+	}
+
+	_, err = rs.findMediatedRoutes(token, target, big.NewInt(10), []pfsproxy.FindPathResponse{path(offline)})
+	e, ok := err.(rerr.StandardError)
+	assert.True(t, ok && e.ErrorCode == rerr.ErrNoAvailabeRoute.ErrorCode, "err=%v", err)
+
+	rs.Config.AllowOfflineFirstHop = true
+	routes, err = rs.findMediatedRoutes(token, target, big.NewInt(10), []pfsproxy.FindPathResponse{path(offline)})
+	assert.Nil(t, err)
+	assert.Len(t, routes, 1)
+}
+
+//链上已经关闭但是本地错过了关闭事件,修复时和收到关闭事件一样处理
+func TestRepairChannelClosedOnChain(t *testing.T) {
+	our, partner, token := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	ch, err := channel.NewChannel(channel.NewChannelEndState(our, big.NewInt(100), nil, mtree.EmptyTree),
+		channel.NewChannelEndState(partner, big.NewInt(50), nil, mtree.EmptyTree), &channel.ExternalState{}, token,
+		&contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash(), OpenBlockNumber: 3}, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewChannelGraph(our, token, nil)
+	assert.Nil(t, g.AddChannel(ch))
+	rs := &Service{
+		NodeAddress:        our,
+		Config:             &params.Config{AutoRespondToClose: true},
+		NotifyHandler:      notify.NewNotifyHandler(),
+		Token2ChannelGraph: map[common.Address]*graph.ChannelGraph{token: g},
+		BlockNumber:        new(atomic.Value),
+		dao:                codefortest.NewTestDB(""),
+	}
+	rs.BlockNumber.Store(int64(20))
+	rs.StateMachineEventHandler = newStateMachineEventHandler(rs)
+	defer rs.dao.CloseDB()
+	assert.Nil(t, rs.dao.NewChannel(channel.NewChannelSerialization(ch)))
+	req := &repairChannelFromChainReq{
+		ChannelIdentifier: ch.ChannelIdentifier.ChannelIdentifier,
+		OpenBlockNumber:   ch.ChannelIdentifier.OpenBlockNumber,
+		State:             contracts.ChannelStateClosed,
+		SettleBlockNumber: uint64(10 + ch.SettleTimeout),
+		SettleTimeout:     uint64(ch.SettleTimeout),
+		OurDeposit:        big.NewInt(100),
+		PartnerDeposit:    big.NewInt(60),
+		Closed: &mediatedtransfer.ContractClosedStateChange{
+			ChannelIdentifier: ch.ChannelIdentifier.ChannelIdentifier,
+			ClosingAddress:    partner,
+			ClosedBlock:       10,
+			TransferredAmount: big.NewInt(0),
+		},
+	}
+	assert.Nil(t, <-rs.repairChannelFromChain(req).Result)
+	assert.EqualValues(t, channeltype.StateClosed, ch.State)
+	assert.EqualValues(t, 10, ch.ExternState.ClosedBlock)
+	assert.EqualValues(t, 10+int64(ch.SettleTimeout)+params.PunishBlockNumber, ch.ExternState.SettledBlock)
+	assert.EqualValues(t, big.NewInt(60), ch.PartnerState.ContractBalance)
+	cs, err := rs.dao.GetChannelByAddress(ch.ChannelIdentifier.ChannelIdentifier)
+	if assert.Nil(t, err) {
+		assert.EqualValues(t, channeltype.StateClosed, cs.State)
+		assert.EqualValues(t, big.NewInt(60), cs.PartnerContractBalance)
+	}
+	//对方关闭的,通知用户已经自动提交 balance proof
+	partnerClosed := func() (evs []*PartnerClosedEvent) {
+		notices := rs.NotifyHandler.GetNoticeChan()
+		for len(notices) > 0 {
+			var info struct {
+				Type    int
+				Message json.RawMessage
+			}
+			assert.Nil(t, json.Unmarshal([]byte((<-notices).Info), &info))
+			if info.Type == notify.InfoTypePartnerClosed {
+				ev := new(PartnerClosedEvent)
+				assert.Nil(t, json.Unmarshal(info.Message, ev))
+				evs = append(evs, ev)
+			}
+		}
+		return
+	}
+	evs := partnerClosed()
+	if assert.Len(t, evs, 1) {
+		assert.True(t, evs[0].AutoResponded)
+		assert.EqualValues(t, 10, evs[0].ClosedBlock)
+	}
+
+	//再次修复不会重复处理关闭
+	assert.Nil(t, <-rs.repairChannelFromChain(req).Result)
+	assert.Len(t, partnerClosed(), 0)
+}
+
+//修复打开的通道时,settle timeout 以合约返回的为准,而不是 SettleBlockNumber
+func TestRepairChannelOpenedOnChain(t *testing.T) {
+	our, partner, token := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	ch, err := channel.NewChannel(channel.NewChannelEndState(our, big.NewInt(100), nil, mtree.EmptyTree),
+		channel.NewChannelEndState(partner, big.NewInt(50), nil, mtree.EmptyTree), &channel.ExternalState{}, token,
+		&contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash(), OpenBlockNumber: 3}, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewChannelGraph(our, token, nil)
+	assert.Nil(t, g.AddChannel(ch))
+	rs := &Service{
+		NodeAddress:        our,
+		Config:             &params.Config{},
+		NotifyHandler:      notify.NewNotifyHandler(),
+		Token2ChannelGraph: map[common.Address]*graph.ChannelGraph{token: g},
+		dao:                codefortest.NewTestDB(""),
+	}
+	defer rs.dao.CloseDB()
+	rs.BlockNumber = new(atomic.Value)
+	rs.BlockNumber.Store(int64(40))
+	assert.Nil(t, rs.dao.NewChannel(channel.NewChannelSerialization(ch)))
+	req := &repairChannelFromChainReq{
+		ChannelIdentifier: ch.ChannelIdentifier.ChannelIdentifier,
+		OpenBlockNumber:   ch.ChannelIdentifier.OpenBlockNumber,
+		State:             contracts.ChannelStateOpened,
+		SettleTimeout:     uint64(ch.SettleTimeout),
+		OurDeposit:        big.NewInt(120),
+		PartnerDeposit:    big.NewInt(50),
+	}
+	assert.Nil(t, <-rs.repairChannelFromChain(req).Result)
+	assert.EqualValues(t, channeltype.StateOpened, ch.State)
+	assert.EqualValues(t, 100, ch.SettleTimeout)
+	assert.EqualValues(t, big.NewInt(120), ch.OurState.ContractBalance)
+	//不知道漏掉的存款在哪个块,按修复时的块计算确认
+	assert.EqualValues(t, 40, ch.OurState.DepositBlockNumber)
+	assert.EqualValues(t, 0, ch.PartnerState.DepositBlockNumber)
+
+	req.SettleTimeout = 200
+	assert.Nil(t, <-rs.repairChannelFromChain(req).Result)
+	assert.EqualValues(t, 200, ch.SettleTimeout)
+	cs, err := rs.dao.GetChannelByAddress(ch.ChannelIdentifier.ChannelIdentifier)
+	if assert.Nil(t, err) {
+		assert.EqualValues(t, 200, cs.SettleTimeout)
+		assert.EqualValues(t, big.NewInt(120), cs.OurContractBalance)
+	}
+}
+
+//上家在通道打开以后又存了款,等到这次存款被确认以后才披露密码
+func TestSecretRevealBlockNumberWaitsForDeposit(t *testing.T) {
+	our, partner, token := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	ch, err := channel.NewChannel(channel.NewChannelEndState(our, big.NewInt(100), nil, mtree.EmptyTree),
+		channel.NewChannelEndState(partner, big.NewInt(50), nil, mtree.EmptyTree), &channel.ExternalState{}, token,
+		&contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash(), OpenBlockNumber: 10}, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := &Service{Config: &params.Config{}}
+	rs.StateMachineEventHandler = newStateMachineEventHandler(rs)
+	assert.EqualValues(t, 0, rs.secretRevealBlockNumber(ch))
+	rs.Config.SecretRevealStrategy = params.SecretRevealConfirmed
+	rs.Config.SecretRevealConfirmations = 5
+	assert.EqualValues(t, 15, rs.secretRevealBlockNumber(ch))
+
+	//我自己存款不影响上家的锁
+	assert.Nil(t, rs.StateMachineEventHandler.ChannelStateTransition(ch, &mediatedtransfer.ContractBalanceStateChange{
+		ChannelIdentifier:  ch.ChannelIdentifier.ChannelIdentifier,
+		ParticipantAddress: our,
+		Balance:            big.NewInt(200),
+		BlockNumber:        20,
+	}))
+	assert.EqualValues(t, 15, rs.secretRevealBlockNumber(ch))
+	assert.Nil(t, rs.StateMachineEventHandler.ChannelStateTransition(ch, &mediatedtransfer.ContractBalanceStateChange{
+		ChannelIdentifier:  ch.ChannelIdentifier.ChannelIdentifier,
+		ParticipantAddress: partner,
+		Balance:            big.NewInt(80),
+		BlockNumber:        30,
+	}))
+	assert.EqualValues(t, 35, rs.secretRevealBlockNumber(ch))
+	//重复的事件不改变存款所在的块
+	assert.Nil(t, rs.StateMachineEventHandler.ChannelStateTransition(ch, &mediatedtransfer.ContractBalanceStateChange{
+		ChannelIdentifier:  ch.ChannelIdentifier.ChannelIdentifier,
+		ParticipantAddress: partner,
+		Balance:            big.NewInt(80),
+		BlockNumber:        31,
+	}))
+	assert.EqualValues(t, 35, rs.secretRevealBlockNumber(ch))
+	//重启以后仍然知道存款所在的块
+	cs := channel.NewChannelSerialization(ch)
+	assert.EqualValues(t, 30, cs.PartnerDepositBlockNumber)
+	assert.EqualValues(t, 20, cs.OurDepositBlockNumber)
+}
@@ -15,10 +15,12 @@ import (
 	"bytes"
 
 	"sort"
+	"strings"
 
 	"context"
 
 	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
+	"github.com/SmartMeshFoundation/Photon/encoding"
 	"github.com/SmartMeshFoundation/Photon/log"
 	"github.com/SmartMeshFoundation/Photon/models"
 	"github.com/SmartMeshFoundation/Photon/network"
@@ -92,12 +94,43 @@ settleTimeout: 如果为0 表示已经知道通道存在,只是为了存款,如
 如果是单纯deposit,那么err为nil时,ch一定有效
 */
 func (r *API) DepositAndOpenChannel(tokenAddress, partnerAddress common.Address, settleTimeout, revealTimeout int, deposit *big.Int, newChannel bool) (ch *channeltype.Serialization, err error) {
+	ch, _, err = r.DepositAndOpenChannelWithProgress(tokenAddress, partnerAddress, settleTimeout, revealTimeout, deposit, newChannel)
+	return
+}
+
+/*
+DepositAndOpenChannelWithProgress same as DepositAndOpenChannel,
+and `progress` reports channel created and deposit confirmed separately after the tx is mined.
+*/
+func (r *API) DepositAndOpenChannelWithProgress(tokenAddress, partnerAddress common.Address, settleTimeout, revealTimeout int, deposit *big.Int, newChannel bool) (ch *channeltype.Serialization, progress *ChannelOpenProgress, err error) {
+	return r.depositAndOpenChannel(tokenAddress, partnerAddress, settleTimeout, revealTimeout, deposit, newChannel, false)
+}
+
+/*
+DepositAndOpenChannelForce same as DepositAndOpenChannel,
+but submits the deposit even if a previous deposit tx of this channel is still pending.
+DepositAndOpenChannel rejects it with ErrChannelDepositInProgress to avoid depositing twice by accident.
+*/
+func (r *API) DepositAndOpenChannelForce(tokenAddress, partnerAddress common.Address, settleTimeout, revealTimeout int, deposit *big.Int, newChannel bool) (ch *channeltype.Serialization, err error) {
+	ch, _, err = r.depositAndOpenChannel(tokenAddress, partnerAddress, settleTimeout, revealTimeout, deposit, newChannel, true)
+	return
+}
+
+/*
+GetChannelOpenResult 查询和 partnerAddress 最后一次创建通道或者存款的结果,
+存款失败时 ChannelOpened 说明通道是否已经存在,存在则只需要重试存款
+*/
+func (r *API) GetChannelOpenResult(tokenAddress, partnerAddress common.Address) (result *ChannelOpenResult, err error) {
+	return r.Photon.getChannelOpenResult(tokenAddress, partnerAddress)
+}
+
+func (r *API) depositAndOpenChannel(tokenAddress, partnerAddress common.Address, settleTimeout, revealTimeout int, deposit *big.Int, newChannel, force bool) (ch *channeltype.Serialization, progress *ChannelOpenProgress, err error) {
 	if revealTimeout <= 0 {
 		revealTimeout = r.Photon.Config.RevealTimeout
 	}
 	if newChannel {
 		if settleTimeout <= 0 {
-			settleTimeout = r.Photon.Config.SettleTimeout
+			settleTimeout = r.Photon.defaultSettleTimeout(tokenAddress)
 		}
 		if settleTimeout <= revealTimeout {
 			err = rerr.ErrChannelInvalidSettleTimeout
@@ -140,8 +173,9 @@ func (r *API) DepositAndOpenChannel(tokenAddress, partnerAddress common.Address,
 			return
 		}
 	}
-	result := r.Photon.depositAndOpenChannelClient(tokenAddress, partnerAddress, settleTimeout, deposit, newChannel)
+	result := r.Photon.depositAndOpenChannelClient(tokenAddress, partnerAddress, settleTimeout, deposit, newChannel, force)
 	err = <-result.Result
+	progress, _ = result.Tag.(*ChannelOpenProgress)
 	return
 }
 
@@ -223,6 +257,33 @@ func (r *API) ExpectTokenSwap(lockSecretHash string, makerToken, takerToken, mak
 	return nil
 }
 
+/*
+MultiLegTokenSwap atomic swap among three or more nodes sharing one lock secret hash,
+every participant calls it with the same `legs`, which must form a ring.
+legs[0].From is the maker, who knows the secret and waits until the last leg arrives,
+the others send their leg after receiving the previous one.
+No secret is revealed before the maker holds the last leg, so if any leg cannot be sent,
+all locks just expire and every leg rolls back.
+Once the maker reveals the secret, every participant can claim what it receives,
+because expiration decreases by reveal timeout at each participant.
+*/
+func (r *API) MultiLegTokenSwap(lockSecretHash, secret common.Hash, legs []*SwapLeg) (err error) {
+	tokenSwap, isMaker, err := r.Photon.multiLegTokenSwap(lockSecretHash, secret, legs)
+	if err != nil {
+		return
+	}
+	for _, token := range []common.Address{tokenSwap.FromToken, tokenSwap.ToToken} {
+		chs, err := r.Photon.dao.GetChannelList(token, utils.EmptyAddress)
+		if err != nil || len(chs) == 0 {
+			return rerr.ErrTokenNotFound
+		}
+	}
+	if !isMaker {
+		return <-r.Photon.tokenSwapTakerClient(tokenSwap).Result
+	}
+	return <-r.Photon.tokenSwapMakerClient(tokenSwap).Result
+}
+
 //GetNodeNetworkState Returns the currently network status of `node_address
 func (r *API) GetNodeNetworkState(nodeAddress common.Address) (deviceType string, isOnline bool) {
 	return r.Photon.Protocol.GetNetworkStatus(nodeAddress)
@@ -234,6 +295,11 @@ func (r *API) StartHealthCheckFor(nodeAddress common.Address) (deviceType string
 	return r.GetNodeNetworkState(nodeAddress)
 }
 
+//StopHealthCheckFor stops pinging `node_address`, it's done automatically after all channels with it are settled.
+func (r *API) StopHealthCheckFor(nodeAddress common.Address) {
+	r.Photon.StopHealthCheckFor(nodeAddress)
+}
+
 //GetTokenList returns all available tokens
 func (r *API) GetTokenList() (tokens []common.Address) {
 	tokensmap, err := r.Photon.dao.GetAllTokens()
@@ -259,8 +325,8 @@ func (r *API) GetTokenTokenNetorks() (tokens []string) {
 }
 
 //Transfer transfer and wait
-func (r *API) Transfer(token common.Address, amount *big.Int, target common.Address, secret common.Hash, timeout time.Duration, isDirectTransfer bool, data string, routeInfo []pfsproxy.FindPathResponse) (result *utils.AsyncResult, err error) {
-	result, err = r.TransferInternal(token, amount, target, secret, isDirectTransfer, data, routeInfo)
+func (r *API) Transfer(token common.Address, amount *big.Int, target common.Address, secret common.Hash, timeout time.Duration, isDirectTransfer bool, data string, routeInfo []pfsproxy.FindPathResponse, metadata map[string]string) (result *utils.AsyncResult, err error) {
+	result, err = r.TransferInternal(token, amount, target, secret, isDirectTransfer, data, routeInfo, metadata)
 	if err != nil {
 		return
 	}
@@ -277,9 +343,18 @@ func (r *API) Transfer(token common.Address, amount *big.Int, target common.Addr
 	return result, err
 }
 
+/*
+TransferOutcome 交易成功以后,交易是否是在链上注册密码以后才完成的.
+只能在从 result.Result 读取到 nil 之后调用,交易没有成功或者结果还没有返回时 ok 为 false
+*/
+func TransferOutcome(result *utils.AsyncResult) (outcome transfer.SettlementOutcome, ok bool) {
+	outcome, ok = result.Tag.(transfer.SettlementOutcome)
+	return
+}
+
 // TransferAsync :
-func (r *API) TransferAsync(tokenAddress common.Address, amount *big.Int, target common.Address, secret common.Hash, isDirectTransfer bool, data string, routeInfo []pfsproxy.FindPathResponse) (result *utils.AsyncResult, err error) {
-	result, err = r.TransferInternal(tokenAddress, amount, target, secret, isDirectTransfer, data, routeInfo)
+func (r *API) TransferAsync(tokenAddress common.Address, amount *big.Int, target common.Address, secret common.Hash, isDirectTransfer bool, data string, routeInfo []pfsproxy.FindPathResponse, metadata map[string]string) (result *utils.AsyncResult, err error) {
+	result, err = r.TransferInternal(tokenAddress, amount, target, secret, isDirectTransfer, data, routeInfo, metadata)
 	if err != nil {
 		return
 	}
@@ -292,11 +367,64 @@ func (r *API) TransferAsync(tokenAddress common.Address, amount *big.Int, target
 	return result, err
 }
 
-//TransferInternal :
-func (r *API) TransferInternal(tokenAddress common.Address, amount *big.Int, target common.Address, secret common.Hash, isDirectTransfer bool, data string, routeInfo []pfsproxy.FindPathResponse) (result *utils.AsyncResult, err error) {
+/*
+TransferWithQueue : same as TransferAsync, but when there is no available route now,
+the transfer is queued and retried on new blocks or channel changes until `queueTimeout`.
+`queueID` can be used with CancelQueuedTransfer while the transfer is still queued,
+result.LockSecretHash is set even if the transfer is queued
+*/
+func (r *API) TransferWithQueue(tokenAddress common.Address, amount *big.Int, target common.Address, secret common.Hash, data string, routeInfo []pfsproxy.FindPathResponse, queueTimeout time.Duration) (result *utils.AsyncResult, queueID common.Hash, err error) {
+	queueID = utils.NewRandomHash()
+	result = r.Photon.transferWithQueueClient(tokenAddress, amount, target, secret, data, routeInfo, queueID, queueTimeout)
+	timeoutCh := time.After(300 * time.Millisecond)
+	select {
+	case <-timeoutCh:
+		return result, queueID, nil
+	case err = <-result.Result:
+	}
+	return result, queueID, err
+}
+
+/*
+TransferWithTimeout : same as TransferAsync, but if the secret is not revealed within `cancelTimeout`,
+the transfer is canceled and fails with ErrTransferTimeout, the timeout is checked on every new block
+*/
+func (r *API) TransferWithTimeout(tokenAddress common.Address, amount *big.Int, target common.Address, secret common.Hash, data string, routeInfo []pfsproxy.FindPathResponse, cancelTimeout time.Duration) (result *utils.AsyncResult, err error) {
+	result = r.Photon.transferWithTimeoutClient(tokenAddress, amount, target, secret, data, routeInfo, cancelTimeout)
+	timeoutCh := time.After(300 * time.Millisecond)
+	select {
+	case <-timeoutCh:
+		return result, nil
+	case err = <-result.Result:
+	}
+	return result, err
+}
+
+// GetQueuedTransfers : transfers waiting for an available route
+func (r *API) GetQueuedTransfers() (transfers []*QueuedTransferDetail, err error) {
+	result := r.Photon.getQueuedTransfersClient()
+	err = <-result.Result
+	if err != nil {
+		return
+	}
+	transfers = result.Tag.([]*QueuedTransferDetail)
+	return
+}
+
+// CancelQueuedTransfer : remove a transfer from queue, it will fail with ErrTransferCanceled
+func (r *API) CancelQueuedTransfer(queueID common.Hash) error {
+	result := r.Photon.cancelQueuedTransferClient(queueID)
+	return <-result.Result
+}
+
+/*
+TransferInternal :
+metadata 只保存在本地,交易发起以后可以通过 SentTransferDetail 查询到,不会发送给其他节点
+*/
+func (r *API) TransferInternal(tokenAddress common.Address, amount *big.Int, target common.Address, secret common.Hash, isDirectTransfer bool, data string, routeInfo []pfsproxy.FindPathResponse, metadata map[string]string) (result *utils.AsyncResult, err error) {
 	log.Debug(fmt.Sprintf("initiating transfer initiator=%s target=%s token=%s amount=%d secret=%s,currentblock=%d",
 		r.Photon.NodeAddress.String(), target.String(), tokenAddress.String(), amount, secret.String(), r.Photon.GetBlockNumber()))
-	result = r.Photon.transferAsyncClient(tokenAddress, amount, target, secret, isDirectTransfer, data, routeInfo)
+	result = r.Photon.transferAsyncClient(tokenAddress, amount, target, secret, isDirectTransfer, data, routeInfo, metadata)
 	return
 }
 
@@ -381,6 +509,14 @@ func (r *API) Settle(tokenAddress, partnerAddress common.Address) (c *channeltyp
 	return r.Photon.dao.GetChannelByAddress(c.ChannelIdentifier.ChannelIdentifier)
 }
 
+//SettleAllReady settle all closed channels whose settle timeout has passed, channels not ready yet have an error result explaining why
+func (r *API) SettleAllReady() (results map[common.Hash]*utils.AsyncResult, err error) {
+	if err = r.checkSmcStatus(); err != nil {
+		return
+	}
+	return r.Photon.SettleAllReady()
+}
+
 //CooperativeSettle a channel opened with `partner_address` for the given `token_address`. return when state has been updated to database
 func (r *API) CooperativeSettle(tokenAddress, partnerAddress common.Address) (c *channeltype.Serialization, err error) {
 	if err = r.checkSmcStatus(); err != nil {
@@ -664,10 +800,28 @@ func (r *API) GetSentTransferDetails(tokenAddress common.Address, from, to int64
 }
 
 /*
-GetReceivedTransfers query received transfers from dao
+GetTransferRoute 查询我发起的交易使用的路径,交易还没有结束时 inProgress 为 true
+*/
+func (r *API) GetTransferRoute(tokenAddress common.Address, lockSecretHash common.Hash) (route []common.Address, inProgress bool, err error) {
+	return r.Photon.GetTransferRoute(tokenAddress, lockSecretHash)
+}
+
+/*
+GetReceivedTransfers query received transfers from dao,
+paymentPointer 不为空时只返回付给这个 payment pointer 的交易
 */
-func (r *API) GetReceivedTransfers(tokenAddress common.Address, fromBlock, toBlock, fromTime, toTime int64) ([]*models.ReceivedTransfer, error) {
-	return r.Photon.dao.GetReceivedTransferList(tokenAddress, fromBlock, toBlock, fromTime, toTime)
+func (r *API) GetReceivedTransfers(tokenAddress common.Address, fromBlock, toBlock, fromTime, toTime int64, paymentPointer string) ([]*models.ReceivedTransfer, error) {
+	trs, err := r.Photon.dao.GetReceivedTransferList(tokenAddress, fromBlock, toBlock, fromTime, toTime)
+	if err != nil || paymentPointer == "" {
+		return trs, err
+	}
+	var result []*models.ReceivedTransfer
+	for _, tr := range trs {
+		if tr.PaymentPointer != "" && strings.EqualFold(tr.PaymentPointer, paymentPointer) {
+			result = append(result, tr)
+		}
+	}
+	return result, nil
 }
 
 //Stop stop for mobile app
@@ -779,6 +933,11 @@ func (r *API) ForceUnlock(channelIdentifier, secret common.Hash) (err error) {
 	return
 }
 
+// RegisterSecretToChannels : register a secret learned out-of-band to all channels holding its lock, optionally register it on chain too
+func (r *API) RegisterSecretToChannels(secret common.Hash, registerOnChain bool) (err error) {
+	return r.Photon.RegisterSecret(secret, registerOnChain)
+}
+
 // RegisterSecretOnChain : only for debug
 func (r *API) RegisterSecretOnChain(secret common.Hash) (err error) {
 	result := r.Photon.registerSecretOnChainClient(secret)
@@ -786,6 +945,21 @@ func (r *API) RegisterSecretOnChain(secret common.Hash) (err error) {
 	return
 }
 
+// RepairChannelFromChain : update local contract balance and closed block of channel with data on chain
+func (r *API) RepairChannelFromChain(channelIdentifier common.Hash) (err error) {
+	return r.Photon.RepairChannelFromChain(channelIdentifier)
+}
+
+// VerifyChannelsOnChain : compare every local channel of `tokenAddress` with the contract
+func (r *API) VerifyChannelsOnChain(tokenAddress common.Address) (checks []*ChannelChainCheck, err error) {
+	return r.Photon.VerifyChannelsOnChain(tokenAddress)
+}
+
+// QuoteRouteFee : ask mediators on `path` for their current fee, quotes are cached briefly and used by next transfer
+func (r *API) QuoteRouteFee(tokenAddress common.Address, amount *big.Int, path []common.Address) (totalFee *big.Int, err error) {
+	return r.Photon.QuoteRouteFee(tokenAddress, amount, path, r.Photon.Config.MsgTimeout)
+}
+
 // CancelTransfer : cancel a transfer when haven't send secret
 func (r *API) CancelTransfer(lockSecretHash common.Hash, tokenAddress common.Address) error {
 	result := r.Photon.cancelTransferClient(lockSecretHash, tokenAddress)
@@ -839,7 +1013,7 @@ func (r *API) BalanceProofForPFS(channelIdentifier common.Hash) (proof *ProofFor
 	_, err = buf.Write(bpf.Signature)
 	_, err = buf.Write(utils.BigIntTo32Bytes(proof.LockAmount))
 	dataToSign := buf.Bytes()
-	proof.Signature, err = utils.SignData(r.Photon.PrivateKey, dataToSign)
+	proof.Signature, err = r.Photon.Signer.SignMessage(dataToSign)
 	return
 }
 
@@ -940,6 +1114,7 @@ func (r *API) SystemStatus() (resp interface{}, err error) {
 		ChannelNum          int                               `json:"channel_num"`
 		Transfers           *transfers                        `json:"transfers,omitempty"`
 		SyncProcess         *ethereum.SyncProgress            `json:"sync_process"`
+		StartupProgress     StartupProgress                   `json:"startup_progress"`
 	}
 	var data systemStatus
 	data.EthRPCEndpoint = r.Photon.Config.EthRPCEndPoint
@@ -961,6 +1136,7 @@ func (r *API) SystemStatus() (resp interface{}, err error) {
 	data.LastBlockNumber = r.Photon.dao.GetLatestBlockNumber()
 	data.LastBlockNumberTime = r.Photon.dao.GetLastBlockNumberTime()
 	data.IsMobileMode = params.MobileMode
+	data.StartupProgress = r.Photon.GetStartupProgress()
 	// network type
 	switch r.Photon.Transport.(type) {
 	case *network.XMPPTransport:
@@ -991,7 +1167,7 @@ func (r *API) SystemStatus() (resp interface{}, err error) {
 	if err != nil {
 		return
 	}
-	rts, err := r.GetReceivedTransfers(utils.EmptyAddress, -1, -1, -1, -1)
+	rts, err := r.GetReceivedTransfers(utils.EmptyAddress, -1, -1, -1, -1, "")
 	if err != nil {
 		return
 	}
@@ -1312,3 +1488,200 @@ func (r *API) GetAssetsOnToken(tokenList []common.Address) (resp []*GetAssetsOnT
 	}
 	return
 }
+
+// GetRevealTimeout : reveal timeout for new channels and new transfers
+func (r *API) GetRevealTimeout() int {
+	return r.Photon.GetRevealTimeout()
+}
+
+// SetDefaultRevealTimeout : only affects new channels and new transfers, existing channels keep their reveal timeout.
+// Lowering it leaves new transfers less time to register secret and unlock on chain.
+func (r *API) SetDefaultRevealTimeout(revealTimeout int) error {
+	return r.Photon.SetDefaultRevealTimeout(revealTimeout)
+}
+
+// GetSafeToTransact : false means eth is disconnected, secrets of received transfers will not be revealed until reconnected
+func (r *API) GetSafeToTransact() bool {
+	return r.Photon.GetSafeToTransact()
+}
+
+// GetDroppedMessageStats : messages dropped because peers exceed the rate limit
+func (r *API) GetDroppedMessageStats() []*DroppedMessageStats {
+	return r.Photon.MessageHandler.rateLimiter.stats()
+}
+
+// AddRouteDenylist : transfers will never be routed through addr, unless addr is the target
+func (r *API) AddRouteDenylist(addr common.Address) error {
+	return r.Photon.AddRouteDenylist(addr)
+}
+
+// RemoveRouteDenylist : allow addr to be an intermediate hop again
+func (r *API) RemoveRouteDenylist(addr common.Address) error {
+	return r.Photon.RemoveRouteDenylist(addr)
+}
+
+// GetRouteDenylist : nodes which are never used as intermediate hops
+func (r *API) GetRouteDenylist() []common.Address {
+	return r.Photon.GetRouteDenylist()
+}
+
+// GetTransferMessages : signed MediatedTransfer, SecretRequest, RevealSecret and Unlock of a transfer, kept until the channel settles
+func (r *API) GetTransferMessages(lockSecretHash common.Hash) ([]encoding.SignedMessager, error) {
+	return r.Photon.GetTransferMessages(lockSecretHash)
+}
+
+// WaitForChannelState : block until channel reaches `state` or passes it, or ctx is done
+func (r *API) WaitForChannelState(ctx context.Context, channelIdentifier common.Hash, state channeltype.State) error {
+	return r.Photon.WaitForChannelState(ctx, channelIdentifier, state)
+}
+
+// GetChannelStats : count and volume of successful transfers sent, received and mediated on channel `channelAddress`
+func (r *API) GetChannelStats(channelAddress common.Hash) (sentCount, recvCount, mediatedCount int, sentVolume, recvVolume *big.Int, err error) {
+	return r.Photon.GetChannelStats(channelAddress)
+}
+
+// GetChannelBalanceHistory : our balance of channel `channelAddress` after each change between `fromBlock` and `toBlock`
+func (r *API) GetChannelBalanceHistory(channelAddress common.Hash, fromBlock, toBlock int64) (points []*models.BalancePoint, err error) {
+	return r.Photon.GetChannelBalanceHistory(channelAddress, fromBlock, toBlock)
+}
+
+// ResetChannelStats : clear transfer statistics of channel `channelAddress`
+func (r *API) ResetChannelStats(channelAddress common.Hash) error {
+	return r.Photon.ResetChannelStats(channelAddress)
+}
+
+// SetGasLimit : override gas limit of transactions of op(ChannelDeposit,ChannelClose,ChannelSettle...),0 means estimate automatically
+func (r *API) SetGasLimit(op string, limit uint64) error {
+	return r.Photon.Chain.SetGasLimit(models.TXInfoType(op), limit)
+}
+
+// GetGasLimits : operations whose gas limit is overridden
+func (r *API) GetGasLimits() map[models.TXInfoType]uint64 {
+	return r.Photon.Chain.GasLimits()
+}
+
+// SignMessage : sign data with node key for external authentication,the signature cannot be used as a protocol message
+func (r *API) SignMessage(data []byte) ([]byte, error) {
+	return r.Photon.SignMessage(data)
+}
+
+// VerifyMessage : returns the node which signed data with SignMessage
+func (r *API) VerifyMessage(data, signature []byte) (common.Address, error) {
+	return VerifyMessage(data, signature)
+}
+
+// GetReachableTargets : nodes which can be paid amount of token right now
+func (r *API) GetReachableTargets(tokenAddress common.Address, amount *big.Int) ([]common.Address, error) {
+	return r.Photon.GetReachableTargets(tokenAddress, amount)
+}
+
+// Pause : stop accepting new transfers for maintenance,see Service.Pause for what is still processed
+func (r *API) Pause() error {
+	return r.Photon.Pause()
+}
+
+// Resume : accept new transfers again after Pause
+func (r *API) Resume() error {
+	return r.Photon.Resume()
+}
+
+// GetStartupProgress : channels loaded at startup and whether photon is ready for transfers
+func (r *API) GetStartupProgress() StartupProgress {
+	return r.Photon.GetStartupProgress()
+}
+
+// GetLoopStats : number of transfers in flight, initiated by us or mediated by us
+func (r *API) GetLoopStats() (stats *LoopStats, err error) {
+	result := r.Photon.getLoopStatsClient()
+	err = <-result.Result
+	if err != nil {
+		return
+	}
+	stats = result.Tag.(*LoopStats)
+	return
+}
+
+// GetStuckTransfers : locks that need operator attention, with a suggested action for each
+func (r *API) GetStuckTransfers() (stucks []*StuckTransfer, err error) {
+	return r.Photon.GetStuckTransfers()
+}
+
+// CreateInvoice : expect `amount` of `tokenAddress` before block `expiry`, payers put invoice id in transfer data, 0 expiry means never expire
+func (r *API) CreateInvoice(tokenAddress common.Address, amount *big.Int, expiry int64) (invoiceID common.Hash, err error) {
+	return r.Photon.CreateInvoice(tokenAddress, amount, expiry)
+}
+
+// RegisterPaymentPointer : a receiving identifier label$address,payers put it in transfer data,the same label always gets the same pointer
+func (r *API) RegisterPaymentPointer(label string) (pointer string, err error) {
+	return r.Photon.RegisterPaymentPointer(label)
+}
+
+// GetPaymentPointers : all payment pointers registered
+func (r *API) GetPaymentPointers() ([]*models.PaymentPointer, error) {
+	return r.Photon.GetPaymentPointers()
+}
+
+// GetInvoiceStatus : amount received and status of an invoice
+func (r *API) GetInvoiceStatus(invoiceID common.Hash) (*models.Invoice, error) {
+	return r.Photon.GetInvoiceStatus(invoiceID)
+}
+
+// ExportCapacityReport : signed capacity of all my channels of token,for uploading to pathfinding service
+func (r *API) ExportCapacityReport(tokenAddress common.Address) (*CapacityReport, error) {
+	return r.Photon.ExportCapacityReport(tokenAddress)
+}
+
+// UpdateBalanceProof : submit partner's latest balance proof after partner closed the channel,needed only when AutoRespondToClose is disabled
+func (r *API) UpdateBalanceProof(channelIdentifier common.Hash) error {
+	return r.Photon.UpdateBalanceProof(channelIdentifier)
+}
+
+// PreviewCooperativeSettle : balances each participant would get if the channel were cooperatively settled now,nothing is sent
+func (r *API) PreviewCooperativeSettle(channelIdentifier common.Hash) (ourBalance, partnerBalance *big.Int, err error) {
+	return r.Photon.PreviewCooperativeSettle(channelIdentifier)
+}
+
+// PrepareTransfer : check everything a transfer needs without sending it,call Execute on the returned handle to send
+func (r *API) PrepareTransfer(tokenAddress, target common.Address, amount *big.Int, opts TransferOptions) (*PreparedTransfer, error) {
+	return r.Photon.PrepareTransfer(tokenAddress, target, amount, opts)
+}
+
+// ReloadChannel : rebuild a channel from the database,refused while transfers on it are in flight
+func (r *API) ReloadChannel(channelIdentifier common.Hash) error {
+	return r.Photon.ReloadChannel(channelIdentifier)
+}
+
+// GetKnownSecrets : secrets I know and the channels holding their locks,secrets are redacted unless includeSecret
+func (r *API) GetKnownSecrets(includeSecret bool) ([]SecretInfo, error) {
+	return r.Photon.GetKnownSecrets(includeSecret)
+}
+
+// DumpState : read-only snapshot of channels,transfers and token swaps in memory for debugging,secrets are redacted
+func (r *API) DumpState() (*StateSnapshot, error) {
+	return r.Photon.DumpState()
+}
+
+// GetPendingTokenSwaps : token swaps not finished yet,as maker or taker
+func (r *API) GetPendingTokenSwaps() ([]*TokenSwapInfo, error) {
+	return r.Photon.GetPendingTokenSwaps()
+}
+
+// CancelTokenSwap : give up a token swap,refused once the taker has sent its tokens
+func (r *API) CancelTokenSwap(lockSecretHash common.Hash, fromToken common.Address) error {
+	return r.Photon.CancelTokenSwap(lockSecretHash, fromToken)
+}
+
+// VerifyChannelProofs : check balance proofs of both sides are signed by the right node,err tells which one is invalid
+func (r *API) VerifyChannelProofs(channelIdentifier common.Hash) (ourProofValid, partnerProofValid bool, err error) {
+	return r.Photon.VerifyChannelProofs(channelIdentifier)
+}
+
+// GetDisposedLocks : locks I have announced disposed on channel,with block number and reason
+func (r *API) GetDisposedLocks(channelIdentifier common.Hash) ([]*models.DisposedLock, error) {
+	return r.Photon.GetDisposedLocks(channelIdentifier)
+}
+
+// SetBlockPollInterval : how often to poll the chain for new blocks,0 means default of the chain
+func (r *API) SetBlockPollInterval(interval time.Duration) error {
+	return r.Photon.SetBlockPollInterval(interval)
+}
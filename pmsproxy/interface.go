@@ -2,7 +2,6 @@ package pmsproxy
 
 import (
 	"bytes"
-	"crypto/ecdsa"
 	"encoding/binary"
 	"fmt"
 	"math/big"
@@ -59,7 +58,7 @@ type DelegateForPms struct {
 }
 
 //SignBalanceProofFor3rd make sure PartnerBalanceProof is not nil
-func SignBalanceProofFor3rd(c *channeltype.Serialization, privkey *ecdsa.PrivateKey) (sig []byte, err error) {
+func SignBalanceProofFor3rd(c *channeltype.Serialization, signer utils.Signer) (sig []byte, err error) {
 	if c.PartnerBalanceProof == nil {
 		log.Error(fmt.Sprintf("PartnerBalanceProof is nil,must ber a error"))
 		return nil, rerr.ErrChannelBalanceProofNil.Append("empty PartnerBalanceProof")
@@ -77,11 +76,11 @@ func SignBalanceProofFor3rd(c *channeltype.Serialization, privkey *ecdsa.Private
 		log.Error(fmt.Sprintf("buf write error %s", err))
 	}
 	dataToSign := buf.Bytes()
-	return utils.SignData(privkey, dataToSign)
+	return signer.SignMessage(dataToSign)
 }
 
 // SignUnlockFor3rd :
-func SignUnlockFor3rd(c *channeltype.Serialization, u *DelegateUnlock, thirdAddress common.Address, privkey *ecdsa.PrivateKey) (sig []byte, err error) {
+func SignUnlockFor3rd(c *channeltype.Serialization, u *DelegateUnlock, thirdAddress common.Address, signer utils.Signer) (sig []byte, err error) {
 	buf := new(bytes.Buffer)
 	_, err = buf.Write(params.ContractSignaturePrefix)
 	_, err = buf.Write([]byte(params.ContractUnlockDelegateProofMessageLength))
@@ -97,7 +96,7 @@ func SignUnlockFor3rd(c *channeltype.Serialization, u *DelegateUnlock, thirdAddr
 		return
 	}
 	dataToSign := buf.Bytes()
-	return utils.SignData(privkey, dataToSign)
+	return signer.SignMessage(dataToSign)
 }
 
 /*
@@ -0,0 +1,128 @@
+package photon
+
+import (
+	"fmt"
+	"math/big"
+	"sync/atomic"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/pfsproxy"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//TransferOptions PrepareTransfer 的可选参数,含义和 transferAsyncClient 的同名参数一致
+type TransferOptions struct {
+	Secret           common.Hash
+	Data             string
+	IsDirectTransfer bool
+	RouteInfo        []pfsproxy.FindPathResponse
+	Metadata         map[string]string
+}
+
+/*
+PreparedTransfer 已经通过检查的交易,调用 Execute 才会真正发出.
+从 Prepare 到 Execute 之间通道状态可能发生变化,所以 Execute 时还会再检查一次,
+这时的错误通过 Execute 返回的 AsyncResult 给出
+*/
+type PreparedTransfer struct {
+	TokenAddress common.Address
+	Target       common.Address
+	Amount       *big.Int
+	Options      TransferOptions
+	Direct       bool //检查时会以 DirectTransfer 的方式发送
+	RouteCount   int  //检查时可用的路由数量,DirectTransfer 为0
+	rs           *Service
+	executed     int32
+}
+
+/*
+Execute 发出交易,一个 PreparedTransfer 只能执行一次
+可以在任意线程中调用
+*/
+func (p *PreparedTransfer) Execute() *utils.AsyncResult {
+	if !atomic.CompareAndSwapInt32(&p.executed, 0, 1) {
+		return utils.NewAsyncResultWithError(rerr.ErrDuplicateTransfer.Append("prepared transfer already executed"))
+	}
+	log.Debug(fmt.Sprintf("execute prepared transfer target=%s token=%s amount=%s direct=%v",
+		utils.APex2(p.Target), utils.APex2(p.TokenAddress), p.Amount, p.Direct))
+	return p.rs.transferAsyncClient(p.TokenAddress, p.Amount, p.Target, p.Options.Secret, p.Options.IsDirectTransfer, p.Options.Data, p.Options.RouteInfo, p.Options.Metadata)
+}
+
+/*
+PrepareTransfer 检查交易能否发起,但是不发送.
+检查的内容和发起交易时完全一致:是否暂停,token 是否注册,金额,直接通道或者路由是否可用,以及同时进行的交易数量限制.
+方便 UI 在用户确认之前提示错误.
+可以在任意线程中调用
+*/
+func (rs *Service) PrepareTransfer(tokenAddress, target common.Address, amount *big.Int, opts TransferOptions) (*PreparedTransfer, error) {
+	result := rs.prepareTransferClient(tokenAddress, target, amount, opts)
+	err := <-result.Result
+	if err != nil {
+		return nil, err
+	}
+	return result.Tag.(*PreparedTransfer), nil
+}
+
+/*
+checkTransferReq 所有交易请求进入主线程后都要做的检查
+*/
+func (rs *Service) checkTransferReq(r *transferReq) error {
+	if rs.paused {
+		return rerr.ErrPaused
+	}
+	if err := checkTransferMetadata(r.Metadata); err != nil {
+		return err
+	}
+	if r.IsDirectTransfer && rs.Config.DisableDirectTransfers {
+		return rerr.ErrDirectTransfersDisabled
+	}
+	// DirectTransfer 不占用锁,不受同时进行的交易数量限制
+	if r.IsDirectTransfer {
+		return nil
+	}
+	return rs.checkInFlightTransfers()
+}
+
+/*
+prepareTransfer 只能在主线程中调用
+*/
+func (rs *Service) prepareTransfer(r *transferReq) (result *utils.AsyncResult) {
+	if err := rs.checkTransferReq(r); err != nil {
+		return utils.NewAsyncResultWithError(err)
+	}
+	var direct bool
+	var routeCount int
+	if r.IsDirectTransfer || rs.preferDirectTransfer(r.TokenAddress, r.Target, r.Amount, r.Secret) {
+		if _, err := rs.checkDirectTransfer(r.TokenAddress, r.Target, r.Amount); err != nil {
+			return utils.NewAsyncResultWithError(err)
+		}
+		direct = true
+	} else {
+		routes, err := rs.findMediatedRoutes(r.TokenAddress, r.Target, r.Amount, r.RouteInfo)
+		if err != nil {
+			return utils.NewAsyncResultWithError(err)
+		}
+		routeCount = len(routes)
+	}
+	p := &PreparedTransfer{
+		TokenAddress: r.TokenAddress,
+		Target:       r.Target,
+		Amount:       new(big.Int).Set(r.Amount),
+		Options: TransferOptions{
+			Secret:           r.Secret,
+			Data:             r.Data,
+			IsDirectTransfer: r.IsDirectTransfer,
+			RouteInfo:        r.RouteInfo,
+			Metadata:         r.Metadata,
+		},
+		Direct:     direct,
+		RouteCount: routeCount,
+		rs:         rs,
+	}
+	result = utils.NewAsyncResult()
+	result.Tag = p
+	result.Result <- nil
+	return
+}
@@ -0,0 +1,142 @@
+package photon
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/network"
+	"github.com/SmartMeshFoundation/Photon/network/graph"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/pfsproxy"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/transfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer/initiator"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func prepareTransferErrorCode(rs *Service, r *transferReq) int {
+	err := <-rs.prepareTransfer(r).Result
+	if err == nil {
+		return 0
+	}
+	return err.(rerr.StandardError).ErrorCode
+}
+
+func TestPrepareTransfer(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	our, partner, token := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	ourState := channel.NewChannelEndState(our, big.NewInt(100), nil, mtree.EmptyTree)
+	partnerState := channel.NewChannelEndState(partner, big.NewInt(0), nil, mtree.EmptyTree)
+	c, err := channel.NewChannel(ourState, partnerState, &channel.ExternalState{}, token,
+		&contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash()}, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewChannelGraph(our, token, nil)
+	g.PartenerAddress2Channel[partner] = c
+	tr := &presenceTransport{sent: make(map[common.Address]int)}
+	rs := &Service{
+		NodeAddress:           our,
+		Config:                &params.Config{},
+		Protocol:              network.NewPhotonProtocol(tr, key, nil),
+		IsChainEffective:      true,
+		Token2ChannelGraph:    map[common.Address]*graph.ChannelGraph{token: g},
+		Transfer2StateManager: make(map[common.Hash]*transfer.StateManager),
+		feeQuotes:             newFeeQuoteCache(),
+	}
+	routeInfo := []pfsproxy.FindPathResponse{{Fee: big.NewInt(0), Result: []string{partner.String()}}}
+
+	result := rs.prepareTransfer(&transferReq{TokenAddress: token, Target: partner, Amount: big.NewInt(10), IsDirectTransfer: true})
+	assert.Nil(t, <-result.Result)
+	p := result.Tag.(*PreparedTransfer)
+	assert.True(t, p.Direct)
+	assert.EqualValues(t, 10, p.Amount.Int64())
+
+	result = rs.prepareTransfer(&transferReq{TokenAddress: token, Target: partner, Amount: big.NewInt(10), RouteInfo: routeInfo})
+	assert.Nil(t, <-result.Result)
+	p = result.Tag.(*PreparedTransfer)
+	assert.False(t, p.Direct)
+	assert.Equal(t, 1, p.RouteCount)
+
+	//配置了 PreferDirectTransfer,没有指定密码时按照 DirectTransfer 检查
+	rs.Config.PreferDirectTransfer = true
+	result = rs.prepareTransfer(&transferReq{TokenAddress: token, Target: partner, Amount: big.NewInt(10)})
+	assert.Nil(t, <-result.Result)
+	assert.True(t, result.Tag.(*PreparedTransfer).Direct)
+	rs.Config.PreferDirectTransfer = false
+
+	assert.Equal(t, rerr.ErrInvalidAmount.ErrorCode,
+		prepareTransferErrorCode(rs, &transferReq{TokenAddress: token, Target: partner, Amount: big.NewInt(0), RouteInfo: routeInfo}))
+	assert.Equal(t, rerr.ErrTokenNotFound.ErrorCode,
+		prepareTransferErrorCode(rs, &transferReq{TokenAddress: utils.NewRandomAddress(), Target: partner, Amount: big.NewInt(10), RouteInfo: routeInfo}))
+	assert.Equal(t, rerr.ErrChannelNoEnoughBalance.ErrorCode,
+		prepareTransferErrorCode(rs, &transferReq{TokenAddress: token, Target: partner, Amount: big.NewInt(101), IsDirectTransfer: true}))
+	assert.Equal(t, rerr.ErrNoAvailabeRoute.ErrorCode,
+		prepareTransferErrorCode(rs, &transferReq{TokenAddress: token, Target: partner, Amount: big.NewInt(10),
+			RouteInfo: []pfsproxy.FindPathResponse{{Result: []string{utils.NewRandomAddress().String()}}}}))
+	assert.Equal(t, rerr.ErrArgumentError.ErrorCode,
+		prepareTransferErrorCode(rs, &transferReq{TokenAddress: token, Target: partner, Amount: big.NewInt(10), IsDirectTransfer: true,
+			Metadata: map[string]string{"": "invoice"}}))
+
+	rs.Config.MaxConcurrentTransfers = 1
+	rs.Transfer2StateManager[utils.NewRandomHash()] = transfer.NewStateManager(nil, &mediatedtransfer.InitiatorState{}, initiator.NameInitiatorTransition, utils.NewRandomHash(), token)
+	assert.Equal(t, rerr.ErrTooManyInFlight.ErrorCode,
+		prepareTransferErrorCode(rs, &transferReq{TokenAddress: token, Target: partner, Amount: big.NewInt(10), RouteInfo: routeInfo}))
+
+	rs.paused = true
+	assert.Equal(t, rerr.ErrPaused.ErrorCode,
+		prepareTransferErrorCode(rs, &transferReq{TokenAddress: token, Target: partner, Amount: big.NewInt(10), IsDirectTransfer: true}))
+}
+
+func TestDisableDirectTransfers(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	our, partner, token := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	ourState := channel.NewChannelEndState(our, big.NewInt(100), nil, mtree.EmptyTree)
+	partnerState := channel.NewChannelEndState(partner, big.NewInt(0), nil, mtree.EmptyTree)
+	c, err := channel.NewChannel(ourState, partnerState, &channel.ExternalState{}, token,
+		&contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash()}, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewChannelGraph(our, token, nil)
+	g.PartenerAddress2Channel[partner] = c
+	tr := &presenceTransport{sent: make(map[common.Address]int)}
+	rs := &Service{
+		NodeAddress:           our,
+		Config:                &params.Config{DisableDirectTransfers: true, PreferDirectTransfer: true},
+		Protocol:              network.NewPhotonProtocol(tr, key, nil),
+		IsChainEffective:      true,
+		Token2ChannelGraph:    map[common.Address]*graph.ChannelGraph{token: g},
+		Transfer2StateManager: make(map[common.Hash]*transfer.StateManager),
+		feeQuotes:             newFeeQuoteCache(),
+	}
+	req := &apiReq{
+		Name:   transferReqName,
+		Req:    &transferReq{TokenAddress: token, Target: partner, Amount: big.NewInt(10), IsDirectTransfer: true},
+		result: make(chan *utils.AsyncResult, 1),
+	}
+	rs.handleReq(req)
+	assert.Equal(t, rerr.ErrDirectTransfersDisabled, <-(<-req.result).Result)
+	assert.Equal(t, rerr.ErrDirectTransfersDisabled.ErrorCode,
+		prepareTransferErrorCode(rs, &transferReq{TokenAddress: token, Target: partner, Amount: big.NewInt(10), IsDirectTransfer: true}))
+
+	//PreferDirectTransfer 不再生效,按照 MediatedTransfer 检查
+	routeInfo := []pfsproxy.FindPathResponse{{Fee: big.NewInt(0), Result: []string{partner.String()}}}
+	result := rs.prepareTransfer(&transferReq{TokenAddress: token, Target: partner, Amount: big.NewInt(10), RouteInfo: routeInfo})
+	if assert.Nil(t, <-result.Result) {
+		assert.False(t, result.Tag.(*PreparedTransfer).Direct)
+	}
+}
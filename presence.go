@@ -0,0 +1,54 @@
+package photon
+
+import (
+	"fmt"
+
+	"github.com/SmartMeshFoundation/Photon/internal/rpanic"
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/network"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+AnnouncePresence 立即 ping 所有通道对方,transport 支持时同时广播我在线,
+重启或者网络恢复以后不用等下一轮健康检查,减少其他节点以为我离线而绕开我的时间.
+正在进行健康检查的对方交给健康检查立即 ping,结果由健康检查汇报给 SubscribeNetworkReachability,
+刚刚开始的健康检查已经 ping 过,不会重复 ping.
+没有健康检查的对方单独 ping 一次,只是让对方知道我在线.
+ping 和广播都在后台进行,不等待结果.
+通道从数据库读取,可以在任意线程中调用
+*/
+func (rs *Service) AnnouncePresence() error {
+	cs, err := rs.dao.GetChannelList(utils.EmptyAddress, utils.EmptyAddress)
+	if err != nil {
+		return err
+	}
+	if announcer, ok := rs.Transport.(network.PresenceAnnouncer); ok {
+		go func() {
+			defer rpanic.PanicRecover("announce presence")
+			err := announcer.AnnouncePresence()
+			if err != nil {
+				log.Info(fmt.Sprintf("announce presence err %s", err))
+			}
+		}()
+	}
+	neighbors := make(map[common.Address]bool)
+	for _, c := range cs {
+		neighbors[c.PartnerAddress()] = true
+	}
+	log.Info(fmt.Sprintf("announce presence to %d neighbors", len(neighbors)))
+	for addr := range neighbors {
+		if rs.checkHealthNow(addr) {
+			continue
+		}
+		go func(addr common.Address) {
+			defer rpanic.PanicRecover(fmt.Sprintf("announce presence to %s", utils.APex(addr)))
+			err := rs.Protocol.SendPing(addr)
+			if err != nil {
+				log.Info(fmt.Sprintf("announce presence ping %s err %s", utils.APex(addr), err))
+			}
+		}(addr)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package photon
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/network"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/SmartMeshFoundation/Photon/utils/utest"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+type presenceTransport struct {
+	lock      sync.Mutex
+	sent      map[common.Address]int
+	announced chan struct{}
+	offline   map[common.Address]bool
+}
+
+func (t *presenceTransport) Send(receiver common.Address, data []byte) error {
+	t.lock.Lock()
+	t.sent[receiver]++
+	t.lock.Unlock()
+	return nil
+}
+func (t *presenceTransport) Start()                                    {}
+func (t *presenceTransport) Stop()                                     {}
+func (t *presenceTransport) StopAccepting()                            {}
+func (t *presenceTransport) RegisterProtocol(network.ProtocolReceiver) {}
+func (t *presenceTransport) NodeStatus(addr common.Address) (deviceType string, isOnline bool) {
+	return "", !t.offline[addr]
+}
+func (t *presenceTransport) AnnouncePresence() error {
+	close(t.announced)
+	return nil
+}
+
+func TestAnnouncePresence(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := &presenceTransport{
+		sent:      make(map[common.Address]int),
+		announced: make(chan struct{}),
+	}
+	rs := &Service{
+		Transport:           tr,
+		Protocol:            network.NewPhotonProtocol(tr, key, nil),
+		Clock:               utils.NewRealClock(),
+		dao:                 codefortest.NewTestDB(""),
+		networkReachability: newNetworkReachability(),
+	}
+	defer rs.dao.CloseDB()
+	old, err := rs.dao.GetChannelList(utils.EmptyAddress, utils.EmptyAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//同一个邻居的两个通道只 ping 一次
+	b, c := utils.NewRandomAddress(), utils.NewRandomAddress()
+	for _, partner := range []common.Address{b, b, c} {
+		cs := channeltype.NewEmptySerialization()
+		cs.ChannelIdentifier.ChannelIdentifier = utils.NewRandomHash()
+		cs.Key = cs.ChannelIdentifier.ChannelIdentifier[:]
+		cs.TokenAddressBytes = utils.NewRandomAddress().Bytes()
+		cs.PartnerAddressBytes = partner[:]
+		assert.Nil(t, rs.dao.NewChannel(cs))
+	}
+	assert.Nil(t, rs.AnnouncePresence())
+	select {
+	case <-tr.announced:
+	case <-time.After(time.Second):
+		t.Error("presence not announced")
+	}
+	wait := time.Now().Add(time.Second)
+	for {
+		tr.lock.Lock()
+		n := len(tr.sent)
+		tr.lock.Unlock()
+		if n >= len(old)+2 || time.Now().After(wait) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	tr.lock.Lock()
+	defer tr.lock.Unlock()
+	assert.Equal(t, 1, tr.sent[b])
+	assert.Equal(t, 1, tr.sent[c])
+	//只有健康检查汇报对方能否到达
+	assert.Len(t, rs.networkReachability.reachable, 0)
+}
+
+//正在进行健康检查的对方由健康检查立即 ping,刚开始的健康检查不会重复 ping
+func TestAnnouncePresenceWithHealthCheck(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := &presenceTransport{sent: make(map[common.Address]int)}
+	clock := utest.NewFakeClock(time.Now())
+	rs := &Service{
+		Config:              &params.Config{EnableHealthCheck: true},
+		Protocol:            network.NewPhotonProtocol(tr, key, nil),
+		Clock:               clock,
+		dao:                 codefortest.NewTestDB(""),
+		HealthCheckMap:      make(map[common.Address]chan struct{}),
+		healthCheckNow:      make(map[common.Address]chan time.Time),
+		networkReachability: newNetworkReachability(),
+	}
+	defer rs.dao.CloseDB()
+	b, c := utils.NewRandomAddress(), utils.NewRandomAddress()
+	for _, partner := range []common.Address{b, c} {
+		cs := channeltype.NewEmptySerialization()
+		cs.ChannelIdentifier.ChannelIdentifier = utils.NewRandomHash()
+		cs.Key = cs.ChannelIdentifier.ChannelIdentifier[:]
+		cs.TokenAddressBytes = utils.NewRandomAddress().Bytes()
+		cs.PartnerAddressBytes = partner[:]
+		assert.Nil(t, rs.dao.NewChannel(cs))
+	}
+	sent := func(addr common.Address) int {
+		tr.lock.Lock()
+		defer tr.lock.Unlock()
+		return tr.sent[addr]
+	}
+	waitSent := func(addr common.Address, n int) bool {
+		deadline := time.Now().Add(time.Second)
+		for sent(addr) < n {
+			if time.Now().After(deadline) {
+				return false
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return true
+	}
+	//和 Start 中一样,健康检查开始以后立即宣告
+	rs.startHealthCheckFor(b)
+	defer rs.StopHealthCheckFor(b)
+	assert.Nil(t, rs.AnnouncePresence())
+	assert.True(t, waitSent(b, 1))
+	assert.True(t, waitSent(c, 1))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 1, sent(b))
+	assert.Equal(t, 1, sent(c))
+	rs.networkReachability.lock.Lock()
+	_, ok := rs.networkReachability.reachable[b]
+	assert.True(t, ok)
+	_, ok = rs.networkReachability.reachable[c]
+	assert.False(t, ok)
+	rs.networkReachability.lock.Unlock()
+
+	//之后再宣告,健康检查不用等下一轮
+	clock.Advance(time.Second)
+	assert.Nil(t, rs.AnnouncePresence())
+	assert.True(t, waitSent(b, 2), "health check not woken up")
+	assert.True(t, waitSent(c, 2))
+}
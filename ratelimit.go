@@ -0,0 +1,163 @@
+package photon
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/encoding"
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/network"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+rateLimitSweepInterval 每隔这么久清理一次不再活跃的节点,否则和很多节点交互过以后这些 map 会一直增长
+*/
+const rateLimitSweepInterval = 10 * time.Minute
+
+//droppedStatsTTL 这么久没有再丢弃某个节点的消息,就不再保留它的统计
+const droppedStatsTTL = time.Hour
+
+type rateLimitKey struct {
+	Peer common.Address
+	Cmd  int
+}
+
+//DroppedMessageStats 因为限速被丢弃的消息统计
+type DroppedMessageStats struct {
+	Peer           common.Address   `json:"peer"`
+	Dropped        map[string]int64 `json:"dropped"`
+	PenalizedUntil time.Time        `json:"penalized_until,omitempty"`
+}
+
+/*
+messageRateLimiter 在消息分发之前对每个节点的每种消息限速,
+Ping 在 Protocol 层就回复了 ack,不会经过这里,所以健康检查不受影响.
+限速在主线程中检查,统计信息会被api读取,所以需要锁保护
+*/
+type messageRateLimiter struct {
+	lock         sync.Mutex
+	limits       map[int]params.MessageRateLimit
+	defaultLimit params.MessageRateLimit
+	penalty      time.Duration
+	buckets      map[rateLimitKey]*network.TokenBucket
+	penalized    map[common.Address]time.Time
+	dropped      map[common.Address]map[string]int64
+	lastDropped  map[common.Address]time.Time
+	lastSweep    time.Time
+	timeFunc     func() time.Time
+}
+
+func newMessageRateLimiter(config *params.Config) *messageRateLimiter {
+	return &messageRateLimiter{
+		limits:       config.MessageRateLimits,
+		defaultLimit: config.DefaultMessageRateLimit,
+		penalty:      config.MessageRatePenalty,
+		buckets:      make(map[rateLimitKey]*network.TokenBucket),
+		penalized:    make(map[common.Address]time.Time),
+		dropped:      make(map[common.Address]map[string]int64),
+		lastDropped:  make(map[common.Address]time.Time),
+		timeFunc:     time.Now,
+	}
+}
+
+func (l *messageRateLimiter) limitFor(cmd int) params.MessageRateLimit {
+	if limit, ok := l.limits[cmd]; ok {
+		return limit
+	}
+	return l.defaultLimit
+}
+
+//allow 返回nil表示消息可以处理,否则消息应该被丢弃
+func (l *messageRateLimiter) allow(peer common.Address, cmd int) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := l.timeFunc()
+	if now.Sub(l.lastSweep) >= rateLimitSweepInterval {
+		l.sweep(now)
+	}
+	if until, ok := l.penalized[peer]; ok {
+		if now.Before(until) {
+			l.recordDrop(peer, cmd)
+			return rerr.ErrMessageRateLimited.Printf("%s is penalized until %s", utils.APex2(peer), until)
+		}
+		delete(l.penalized, peer)
+	}
+	limit := l.limitFor(cmd)
+	if limit.Capacity <= 0 {
+		return nil
+	}
+	key := rateLimitKey{peer, cmd}
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = network.NewTokenBucket(limit.Capacity, limit.FillRate, l.timeFunc)
+		l.buckets[key] = bucket
+	}
+	if bucket.Consume(1) <= 0 {
+		return nil
+	}
+	//被丢弃的消息不消耗令牌,否则对方会被永久限速
+	bucket.Tokens++
+	l.recordDrop(peer, cmd)
+	if l.penalty > 0 {
+		l.penalized[peer] = now.Add(l.penalty)
+		log.Warn(fmt.Sprintf("%s exceeds rate limit of %s, penalized for %s", utils.APex2(peer), encoding.MessageType(cmd), l.penalty))
+	}
+	return rerr.ErrMessageRateLimited.Printf("%s from %s", encoding.MessageType(cmd), utils.APex2(peer))
+}
+
+func (l *messageRateLimiter) recordDrop(peer common.Address, cmd int) {
+	m, ok := l.dropped[peer]
+	if !ok {
+		m = make(map[string]int64)
+		l.dropped[peer] = m
+	}
+	m[encoding.MessageType(cmd).String()]++
+	l.lastDropped[peer] = l.timeFunc()
+}
+
+/*
+sweep 删除已经填满的令牌桶,过期的惩罚和很久没有更新的丢弃统计.
+填满的令牌桶和新建的完全一样,所以删除它不会放松限速
+*/
+func (l *messageRateLimiter) sweep(now time.Time) {
+	l.lastSweep = now
+	for key, bucket := range l.buckets {
+		tokens := bucket.Tokens + bucket.FillRate*now.Sub(bucket.Timestamp).Seconds()
+		if tokens >= bucket.Capacity {
+			delete(l.buckets, key)
+		}
+	}
+	for peer, until := range l.penalized {
+		if !now.Before(until) {
+			delete(l.penalized, peer)
+		}
+	}
+	for peer, t := range l.lastDropped {
+		if _, ok := l.penalized[peer]; !ok && now.Sub(t) >= droppedStatsTTL {
+			delete(l.dropped, peer)
+			delete(l.lastDropped, peer)
+		}
+	}
+}
+
+func (l *messageRateLimiter) stats() (stats []*DroppedMessageStats) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for peer, m := range l.dropped {
+		s := &DroppedMessageStats{
+			Peer:           peer,
+			Dropped:        make(map[string]int64),
+			PenalizedUntil: l.penalized[peer],
+		}
+		for name, n := range m {
+			s.Dropped[name] = n
+		}
+		stats = append(stats, s)
+	}
+	return
+}
@@ -0,0 +1,77 @@
+package photon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/encoding"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageRateLimiter(t *testing.T) {
+	now := time.Now()
+	config := &params.Config{
+		MessageRateLimits: map[int]params.MessageRateLimit{
+			encoding.MediatedTransferCmdID: {Capacity: 2, FillRate: 1},
+		},
+	}
+	l := newMessageRateLimiter(config)
+	l.timeFunc = func() time.Time { return now }
+	peer := utils.NewRandomAddress()
+	assert.Nil(t, l.allow(peer, encoding.MediatedTransferCmdID))
+	assert.Nil(t, l.allow(peer, encoding.MediatedTransferCmdID))
+	assert.NotNil(t, l.allow(peer, encoding.MediatedTransferCmdID))
+	//没有配置的消息类型不限速
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, l.allow(peer, encoding.RevealSecretCmdID))
+	}
+	//其他节点不受影响
+	assert.Nil(t, l.allow(utils.NewRandomAddress(), encoding.MediatedTransferCmdID))
+	now = now.Add(time.Second)
+	assert.Nil(t, l.allow(peer, encoding.MediatedTransferCmdID))
+	stats := l.stats()
+	assert.Len(t, stats, 1)
+	assert.EqualValues(t, 1, stats[0].Dropped[encoding.MessageType(encoding.MediatedTransferCmdID).String()])
+
+	l.penalty = time.Minute
+	assert.NotNil(t, l.allow(peer, encoding.MediatedTransferCmdID))
+	assert.NotNil(t, l.allow(peer, encoding.RevealSecretCmdID))
+	now = now.Add(2 * time.Minute)
+	assert.Nil(t, l.allow(peer, encoding.RevealSecretCmdID))
+}
+
+func TestMessageRateLimiterSweep(t *testing.T) {
+	now := time.Now()
+	config := &params.Config{
+		MessageRateLimits: map[int]params.MessageRateLimit{
+			encoding.MediatedTransferCmdID: {Capacity: 1, FillRate: 0.001},
+		},
+		MessageRatePenalty: time.Minute,
+	}
+	l := newMessageRateLimiter(config)
+	l.timeFunc = func() time.Time { return now }
+	peer, other := utils.NewRandomAddress(), utils.NewRandomAddress()
+	assert.Nil(t, l.allow(peer, encoding.MediatedTransferCmdID))
+	assert.NotNil(t, l.allow(peer, encoding.MediatedTransferCmdID))
+	assert.Len(t, l.buckets, 1)
+	assert.Len(t, l.penalized, 1)
+	assert.Len(t, l.dropped, 1)
+
+	//令牌桶还没有填满,不能删除,否则 peer 马上又可以发送
+	now = now.Add(rateLimitSweepInterval)
+	assert.Nil(t, l.allow(other, encoding.RevealSecretCmdID))
+	assert.Len(t, l.buckets, 1)
+	assert.Empty(t, l.penalized)
+	assert.Len(t, l.dropped, 1)
+	assert.NotNil(t, l.allow(peer, encoding.MediatedTransferCmdID))
+
+	now = now.Add(droppedStatsTTL + time.Hour)
+	assert.Nil(t, l.allow(other, encoding.RevealSecretCmdID))
+	assert.Empty(t, l.buckets)
+	assert.Empty(t, l.penalized)
+	assert.Empty(t, l.dropped)
+	assert.Empty(t, l.lastDropped)
+	assert.Empty(t, l.stats())
+}
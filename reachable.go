@@ -0,0 +1,128 @@
+package photon
+
+import (
+	"bytes"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/network/graph"
+	"github.com/SmartMeshFoundation/Photon/network/xmpptransport"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//reachableTargetsCacheTTL 通道图变化不频繁,同样的查询在这段时间内直接返回上次的结果
+const reachableTargetsCacheTTL = 10 * time.Second
+
+/*
+reachableTargetsMaxHops 从我出发最多经过多少跳(包括最后到达 target 的那一跳).
+每经过一个中间节点锁的过期时间都要减去 RevealTimeout,路径太长的交易实际上无法完成,
+同时也避免在很大的通道图上遍历全部节点
+*/
+const reachableTargetsMaxHops = 10
+
+type reachableTargetsKey struct {
+	token  common.Address
+	amount string
+}
+
+type reachableTargetsEntry struct {
+	targets []common.Address
+	expire  time.Time
+}
+
+/*
+GetReachableTargets 现在可以向哪些节点支付 amount 个 token,结果按地址排序.
+路由规则和不指定路由发起交易时一样:
+	1. 余额足够的直接通道对方总是可以到达(direct transfer)
+	2. 没有有效公链时不允许 MediatedTransfer,只能到达直接通道的对方
+	3. 收费网络中不指定路由只能和直接通道的对方交易
+	4. 其他情况下第一跳必须在线并且余额足够,手机节点和路由黑名单中的节点不能作为中间节点
+除了第一跳,本地不知道其他通道的余额,所以实际交易仍然可能因为余额不足失败
+*/
+func (rs *Service) GetReachableTargets(tokenAddress common.Address, amount *big.Int) (targets []common.Address, err error) {
+	result := rs.getReachableTargetsClient(tokenAddress, amount)
+	err = <-result.Result
+	if err != nil {
+		return
+	}
+	targets = result.Tag.([]common.Address)
+	return
+}
+
+/*
+getReachableTargets 只能在主线程中调用
+*/
+func (rs *Service) getReachableTargets(tokenAddress common.Address, amount *big.Int) (result *utils.AsyncResult) {
+	result = utils.NewAsyncResult()
+	now := rs.Clock.Now()
+	if rs.reachableTargetsCache == nil {
+		rs.reachableTargetsCache = make(map[reachableTargetsKey]*reachableTargetsEntry)
+	}
+	for k, e := range rs.reachableTargetsCache {
+		if !now.Before(e.expire) {
+			delete(rs.reachableTargetsCache, k)
+		}
+	}
+	key := reachableTargetsKey{tokenAddress, amount.String()}
+	e := rs.reachableTargetsCache[key]
+	if e == nil {
+		targets, err := rs.reachableTargets(tokenAddress, amount, rs.Protocol)
+		if err != nil {
+			result.Result <- err
+			return
+		}
+		e = &reachableTargetsEntry{
+			targets: targets,
+			expire:  now.Add(reachableTargetsCacheTTL),
+		}
+		rs.reachableTargetsCache[key] = e
+	}
+	result.Tag = append([]common.Address{}, e.targets...)
+	result.Result <- nil
+	return
+}
+
+func (rs *Service) reachableTargets(tokenAddress common.Address, amount *big.Int, nodesStatus graph.NodesStatusGetter) (targets []common.Address, err error) {
+	if err = rs.checkTransferAmount(amount); err != nil {
+		return
+	}
+	g := rs.getToken2ChannelGraph(tokenAddress)
+	if g == nil {
+		return nil, rerr.ErrTokenNotFound
+	}
+	reached := make(map[common.Address]bool)
+	exclude := rs.makeRouteExclude(utils.EmptyAddress)
+	var sources []common.Address
+	for partner, c := range g.PartenerAddress2Channel {
+		if _, err := rs.checkDirectTransfer(tokenAddress, partner, amount); err == nil {
+			reached[partner] = true
+		}
+		if !rs.IsChainEffective || rs.PfsProxy != nil {
+			continue
+		}
+		if !c.CanTransfer() || amount.Cmp(c.Distributable()) > 0 {
+			continue
+		}
+		deviceType, isOnline := nodesStatus.GetNetworkStatus(partner)
+		if !isOnline {
+			continue
+		}
+		if deviceType == xmpptransport.TypeMobile {
+			exclude[partner] = true
+		}
+		sources = append(sources, partner)
+	}
+	for addr := range g.ReachableNodes(sources, exclude, reachableTargetsMaxHops) {
+		reached[addr] = true
+	}
+	for addr := range reached {
+		targets = append(targets, addr)
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		return bytes.Compare(targets[i][:], targets[j][:]) < 0
+	})
+	return
+}
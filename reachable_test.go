@@ -0,0 +1,162 @@
+package photon
+
+import (
+	"bytes"
+	"math/big"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/network/graph"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/network/xmpptransport"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/SmartMeshFoundation/Photon/utils/utest"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeNodesStatus map[common.Address]string
+
+func (f fakeNodesStatus) GetNetworkStatus(addr common.Address) (deviceType string, isOnline bool) {
+	deviceType, isOnline = f[addr]
+	return
+}
+
+func sortedAddresses(addrs ...common.Address) []common.Address {
+	sort.Slice(addrs, func(i, j int) bool {
+		return bytes.Compare(addrs[i][:], addrs[j][:]) < 0
+	})
+	return addrs
+}
+
+func TestReachableTargets(t *testing.T) {
+	our, b, c, d, e, f := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress(),
+		utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	token := utils.NewRandomAddress()
+	//our-b-d-e, our-c-f
+	g := graph.NewChannelGraph(our, token, []common.Address{b, d, d, e, c, f})
+	for partner, balance := range map[common.Address]int64{b: 100, c: 5} {
+		ourState := channel.NewChannelEndState(our, big.NewInt(balance), nil, mtree.EmptyTree)
+		partnerState := channel.NewChannelEndState(partner, big.NewInt(100), nil, mtree.EmptyTree)
+		ch, err := channel.NewChannel(ourState, partnerState, &channel.ExternalState{}, token,
+			&contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash()}, 5, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Nil(t, g.AddChannel(ch))
+	}
+	clock := utest.NewFakeClock(time.Now())
+	rs := &Service{
+		NodeAddress:        our,
+		Config:             &params.Config{},
+		Clock:              clock,
+		IsChainEffective:   true,
+		routeDenylist:      make(map[common.Address]bool),
+		Token2ChannelGraph: map[common.Address]*graph.ChannelGraph{token: g},
+	}
+	status := fakeNodesStatus{b: xmpptransport.TypeOtherDevice, c: xmpptransport.TypeOtherDevice}
+	targets, err := rs.reachableTargets(token, big.NewInt(10), status)
+	assert.Nil(t, err)
+	//c 的余额不够
+	assert.Equal(t, sortedAddresses(b, d, e), targets)
+	targets, err = rs.reachableTargets(token, big.NewInt(5), status)
+	assert.Nil(t, err)
+	assert.Equal(t, sortedAddresses(b, c, d, e, f), targets)
+
+	//黑名单中的节点可以作为 target,但是不能作为中间节点
+	rs.routeDenylist[d] = true
+	targets, _ = rs.reachableTargets(token, big.NewInt(10), status)
+	assert.Equal(t, sortedAddresses(b, d), targets)
+	delete(rs.routeDenylist, d)
+
+	//手机节点不能作为中间节点
+	status[b] = xmpptransport.TypeMobile
+	targets, _ = rs.reachableTargets(token, big.NewInt(10), status)
+	assert.Equal(t, sortedAddresses(b), targets)
+	status[b] = xmpptransport.TypeOtherDevice
+
+	//没有有效公链的时候只能 direct transfer
+	rs.IsChainEffective = false
+	rs.EffectiveChangeTimestamp = clock.Now().Unix()
+	targets, _ = rs.reachableTargets(token, big.NewInt(10), status)
+	assert.Equal(t, sortedAddresses(b), targets)
+	rs.IsChainEffective = true
+
+	_, err = rs.reachableTargets(utils.NewRandomAddress(), big.NewInt(10), status)
+	assert.NotNil(t, err)
+	_, err = rs.reachableTargets(token, big.NewInt(0), status)
+	assert.NotNil(t, err)
+}
+
+func TestReachableTargetsCache(t *testing.T) {
+	our, b := utils.NewRandomAddress(), utils.NewRandomAddress()
+	token := utils.NewRandomAddress()
+	clock := utest.NewFakeClock(time.Now())
+	//没有有效公链,只会检查 direct transfer
+	rs := &Service{
+		NodeAddress:              our,
+		Config:                   &params.Config{},
+		Clock:                    clock,
+		EffectiveChangeTimestamp: clock.Now().Unix(),
+		routeDenylist:            make(map[common.Address]bool),
+		Token2ChannelGraph:       map[common.Address]*graph.ChannelGraph{token: graph.NewChannelGraph(our, token, nil)},
+	}
+	result := rs.getReachableTargets(token, big.NewInt(10))
+	assert.Nil(t, <-result.Result)
+	assert.Empty(t, result.Tag)
+
+	//缓存期间通道的变化不会反映出来
+	ourState := channel.NewChannelEndState(our, big.NewInt(100), nil, mtree.EmptyTree)
+	partnerState := channel.NewChannelEndState(b, big.NewInt(100), nil, mtree.EmptyTree)
+	ch, err := channel.NewChannel(ourState, partnerState, &channel.ExternalState{}, token,
+		&contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash()}, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, rs.Token2ChannelGraph[token].AddChannel(ch))
+	result = rs.getReachableTargets(token, big.NewInt(10))
+	assert.Nil(t, <-result.Result)
+	assert.Empty(t, result.Tag)
+
+	clock.Advance(reachableTargetsCacheTTL)
+	result = rs.getReachableTargets(token, big.NewInt(10))
+	assert.Nil(t, <-result.Result)
+	assert.Equal(t, []common.Address{b}, result.Tag)
+}
+
+func TestReachableTargetsMaxHops(t *testing.T) {
+	our := utils.NewRandomAddress()
+	token := utils.NewRandomAddress()
+	//our-n[0]-n[1]-...,n[i] 距离我 i+1 跳
+	var nodes, edges []common.Address
+	for i := 0; i < reachableTargetsMaxHops+2; i++ {
+		nodes = append(nodes, utils.NewRandomAddress())
+		if i > 0 {
+			edges = append(edges, nodes[i-1], nodes[i])
+		}
+	}
+	g := graph.NewChannelGraph(our, token, edges)
+	ourState := channel.NewChannelEndState(our, big.NewInt(100), nil, mtree.EmptyTree)
+	partnerState := channel.NewChannelEndState(nodes[0], big.NewInt(100), nil, mtree.EmptyTree)
+	ch, err := channel.NewChannel(ourState, partnerState, &channel.ExternalState{}, token,
+		&contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash()}, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, g.AddChannel(ch))
+	rs := &Service{
+		NodeAddress:        our,
+		Config:             &params.Config{},
+		Clock:              utest.NewFakeClock(time.Now()),
+		IsChainEffective:   true,
+		routeDenylist:      make(map[common.Address]bool),
+		Token2ChannelGraph: map[common.Address]*graph.ChannelGraph{token: g},
+	}
+	targets, err := rs.reachableTargets(token, big.NewInt(10), fakeNodesStatus{nodes[0]: xmpptransport.TypeOtherDevice})
+	assert.Nil(t, err)
+	assert.Equal(t, sortedAddresses(append([]common.Address{}, nodes[:reachableTargetsMaxHops]...)...), targets)
+}
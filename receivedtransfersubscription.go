@@ -0,0 +1,171 @@
+package photon
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/utils"
+)
+
+/*
+ReceivedTransferEvent SubscribeReceivedTransfers 推送的事件
+*/
+type ReceivedTransferEvent struct {
+	//Transfer 收到的交易,ReplayDone 为 true 时为 nil
+	Transfer *models.ReceivedTransfer `json:"transfer,omitempty"`
+	//Replayed 这笔交易是从数据库中回放的,订阅之前就已经收到了
+	Replayed bool `json:"replayed"`
+	//ReplayDone 回放结束的分界,之后都是订阅以后新收到的交易
+	ReplayDone bool `json:"replay_done"`
+}
+
+/*
+receivedTransferSubscription 一个订阅者,主线程收到的交易先放入 queue,
+由订阅者自己的 goroutine 推送,消费者处理慢时不会阻塞主线程,也不会丢弃交易
+*/
+type receivedTransferSubscription struct {
+	lock   sync.Mutex
+	queue  []*models.ReceivedTransfer
+	notify chan struct{}
+	quit   chan struct{}
+	out    chan *ReceivedTransferEvent
+}
+
+func (s *receivedTransferSubscription) push(rt *models.ReceivedTransfer) {
+	s.lock.Lock()
+	s.queue = append(s.queue, rt)
+	s.lock.Unlock()
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *receivedTransferSubscription) pop() []*models.ReceivedTransfer {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	q := s.queue
+	s.queue = nil
+	return q
+}
+
+/*
+send 返回 false 表示订阅已经取消
+*/
+func (s *receivedTransferSubscription) send(e *ReceivedTransferEvent) bool {
+	select {
+	case s.out <- e:
+		return true
+	case <-s.quit:
+		return false
+	}
+}
+
+/*
+run 先回放,再发送分界,然后推送新收到的交易.
+交易的 Key 由通道和 nonce 组成,唯一标识一笔收款,回放过的交易不会再作为新交易推送
+*/
+func (s *receivedTransferSubscription) run(replay []*models.ReceivedTransfer) {
+	defer close(s.out)
+	replayed := make(map[string]bool, len(replay))
+	for _, rt := range replay {
+		replayed[rt.Key] = true
+		if !s.send(&ReceivedTransferEvent{Transfer: rt, Replayed: true}) {
+			return
+		}
+	}
+	if !s.send(&ReceivedTransferEvent{ReplayDone: true}) {
+		return
+	}
+	for {
+		for _, rt := range s.pop() {
+			if replayed[rt.Key] {
+				delete(replayed, rt.Key)
+				continue
+			}
+			if !s.send(&ReceivedTransferEvent{Transfer: rt}) {
+				return
+			}
+		}
+		select {
+		case <-s.notify:
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+/*
+receivedTransferSubscribers 订阅和取消订阅可能发生在任意goroutine中,所以需要锁保护
+*/
+type receivedTransferSubscribers struct {
+	lock sync.Mutex
+	subs map[*receivedTransferSubscription]bool
+}
+
+func newReceivedTransferSubscribers() *receivedTransferSubscribers {
+	return &receivedTransferSubscribers{
+		subs: make(map[*receivedTransferSubscription]bool),
+	}
+}
+
+/*
+publish 主线程保存了收到的交易以后调用,不会阻塞
+*/
+func (r *receivedTransferSubscribers) publish(rt *models.ReceivedTransfer) {
+	if r == nil || rt == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for s := range r.subs {
+		s.push(rt)
+	}
+}
+
+/*
+SubscribeReceivedTransfers 订阅收到的交易,适合商家后台这种重启以后需要补上遗漏交易的场景.
+先按块号顺序回放数据库中 sinceBlock(包含)以后收到的交易,然后发送一个 ReplayDone 的分界事件,
+之后推送新收到的交易.订阅在读数据库之前就开始了,所以两者之间收到的交易不会遗漏,
+同时出现在回放和新交易中的,按 Key 去重,只推送一次.
+交易至少推送一次,消费者应该按 Key 做幂等处理;处理慢时交易会在内存中排队,不会丢弃.
+调用返回的cancel以后chan会被关闭.
+可以在任意线程中调用
+*/
+func (rs *Service) SubscribeReceivedTransfers(sinceBlock int64) (<-chan *ReceivedTransferEvent, func(), error) {
+	s := &receivedTransferSubscription{
+		notify: make(chan struct{}, 1),
+		quit:   make(chan struct{}),
+		out:    make(chan *ReceivedTransferEvent),
+	}
+	r := rs.receivedTransferSubscribers
+	r.lock.Lock()
+	r.subs[s] = true
+	r.lock.Unlock()
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			r.lock.Lock()
+			delete(r.subs, s)
+			r.lock.Unlock()
+			close(s.quit)
+		})
+	}
+	replay, err := rs.dao.GetReceivedTransferList(utils.EmptyAddress, sinceBlock, -1, -1, -1)
+	if err != nil {
+		cancel()
+		log.Error(fmt.Sprintf("SubscribeReceivedTransfers GetReceivedTransferList err %s", err))
+		return nil, nil, err
+	}
+	sort.SliceStable(replay, func(i, j int) bool {
+		if replay[i].BlockNumber != replay[j].BlockNumber {
+			return replay[i].BlockNumber < replay[j].BlockNumber
+		}
+		return replay[i].TimeStamp < replay[j].TimeStamp
+	})
+	go s.run(replay)
+	return s.out, cancel, nil
+}
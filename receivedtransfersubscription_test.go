@@ -0,0 +1,70 @@
+package photon
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscribeReceivedTransfers(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	rs := &Service{
+		dao:                         dao,
+		receivedTransferSubscribers: newReceivedTransferSubscribers(),
+	}
+	token, channelID := utils.NewRandomAddress(), utils.NewRandomHash()
+	receive := func(blockNumber int64, nonce uint64) *models.ReceivedTransfer {
+		return dao.NewReceivedTransfer(blockNumber, channelID, 3, token, utils.NewRandomAddress(), nonce, big.NewInt(10), utils.NewRandomHash(), "", "")
+	}
+	receive(5, 1)
+	overlap := receive(12, 3)
+	receive(10, 2)
+
+	ch, cancel, err := rs.SubscribeReceivedTransfers(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := func() *ReceivedTransferEvent {
+		select {
+		case e := <-ch:
+			return e
+		case <-time.After(time.Second):
+			t.Fatal("no event")
+		}
+		return nil
+	}
+	//订阅之后读数据库之前收到的交易,既在回放中,又会推送给订阅者,只能收到一次
+	rs.receivedTransferSubscribers.publish(overlap)
+
+	var replayed []uint64
+	for {
+		e := next()
+		if e.ReplayDone {
+			break
+		}
+		assert.True(t, e.Replayed)
+		replayed = append(replayed, e.Transfer.Nonce)
+	}
+	assert.Equal(t, []uint64{2, 3}, replayed)
+
+	rs.receivedTransferSubscribers.publish(receive(14, 5))
+	rs.receivedTransferSubscribers.publish(receive(14, 6))
+	for _, nonce := range []uint64{5, 6} {
+		e := next()
+		assert.False(t, e.Replayed)
+		assert.EqualValues(t, nonce, e.Transfer.Nonce)
+	}
+
+	cancel()
+	cancel()
+	for range ch {
+	}
+	assert.Len(t, rs.receivedTransferSubscribers.subs, 0)
+	rs.receivedTransferSubscribers.publish(receive(15, 7))
+}
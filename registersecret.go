@@ -0,0 +1,46 @@
+package photon
+
+import (
+	"fmt"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+RegisterSecret 用户通过其他途径(比如跨链 swap)知道了密码,把它注册到所有有这个锁的通道,
+之后就可以在对方不肯 unlock 时关闭通道在链上解锁,锁快要过期时也会自动到链上注册密码.
+registerOnChain 为 true 时立即到链上 SecretRegistry 注册,并等待 tx 结束.
+我没有收到过这个锁时返回错误.
+不能在主线程中调用.
+*/
+func (rs *Service) RegisterSecret(secret common.Hash, registerOnChain bool) (err error) {
+	err = <-rs.registerSecretToChannelsClient(secret).Result
+	if err != nil || !registerOnChain {
+		return
+	}
+	return <-rs.registerSecretOnChainClient(secret).Result
+}
+
+/*
+registerSecretToChannels 只能在主线程中调用
+*/
+func (rs *Service) registerSecretToChannels(secret common.Hash) (result *utils.AsyncResult) {
+	lockSecretHash := utils.ShaSecret(secret[:])
+	held := false
+	for _, lockSecretHash2Channels := range rs.Token2LockSecretHash2Channels {
+		for _, c := range lockSecretHash2Channels[lockSecretHash] {
+			if c.PartnerState.IsKnown(lockSecretHash) {
+				held = true
+			}
+		}
+	}
+	if !held {
+		return utils.NewAsyncResultWithError(rerr.ErrChannelLockSecretHashNotFound.Printf("no received lock matches secret,lock secret hash=%s", utils.HPex(lockSecretHash)))
+	}
+	log.Info(fmt.Sprintf("user register secret of lock %s", utils.HPex(lockSecretHash)))
+	rs.registerSecret(secret)
+	return utils.NewAsyncResultWithError(nil)
+}
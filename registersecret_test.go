@@ -0,0 +1,53 @@
+package photon
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/notify"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterSecretToChannels(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	token := utils.NewRandomAddress()
+	ourState := channel.NewChannelEndState(utils.NewRandomAddress(), big.NewInt(100), nil, mtree.EmptyTree)
+	partnerState := channel.NewChannelEndState(utils.NewRandomAddress(), big.NewInt(100), nil, mtree.EmptyTree)
+	c, err := channel.NewChannel(ourState, partnerState, &channel.ExternalState{}, token,
+		&contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash()}, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := utils.NewRandomHash()
+	lock := &mtree.Lock{Expiration: 1000, Amount: big.NewInt(1), LockSecretHash: utils.ShaSecret(secret[:])}
+	rs := &Service{
+		dao:           dao,
+		NotifyHandler: notify.NewNotifyHandler(),
+		Token2LockSecretHash2Channels: map[common.Address]map[common.Hash][]*channel.Channel{
+			token: {lock.LockSecretHash: {c}},
+		},
+	}
+
+	//我发出的锁不算
+	c.OurState.Lock2PendingLocks[lock.LockSecretHash] = channeltype.PendingLock{Lock: lock, LockHash: lock.Hash()}
+	err = <-rs.registerSecretToChannels(secret).Result
+	e, ok := err.(rerr.StandardError)
+	assert.True(t, ok && e.ErrorCode == rerr.ErrChannelLockSecretHashNotFound.ErrorCode, "err=%v", err)
+	delete(c.OurState.Lock2PendingLocks, lock.LockSecretHash)
+
+	c.PartnerState.Lock2PendingLocks[lock.LockSecretHash] = channeltype.PendingLock{Lock: lock, LockHash: lock.Hash()}
+	assert.NotNil(t, <-rs.registerSecretToChannels(utils.NewRandomHash()).Result, "wrong secret")
+	assert.Nil(t, <-rs.registerSecretToChannels(secret).Result)
+	s, found := c.PartnerState.GetSecret(lock.LockSecretHash)
+	assert.True(t, found)
+	assert.Equal(t, secret, s)
+}
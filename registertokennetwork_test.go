@@ -0,0 +1,53 @@
+package photon
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/network/graph"
+	"github.com/SmartMeshFoundation/Photon/network/rpc"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterTokenNetworksSkipFailed(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	good, bad := utils.NewRandomAddress(), utils.NewRandomAddress()
+	tokens := models.AddressMap{good: utils.EmptyAddress, bad: utils.EmptyAddress}
+	rs := &Service{
+		dao:                dao,
+		NodeAddress:        utils.NewRandomAddress(),
+		Config:             &params.Config{},
+		Token2TokenNetwork: map[common.Address]common.Address{good: utils.EmptyAddress, bad: utils.EmptyAddress},
+		Token2ChannelGraph: make(map[common.Address]*graph.ChannelGraph),
+	}
+	err := rs.registerTokenNetworks(tokens, func(tokenAddress common.Address) (*rpc.TokenNetworkProxy, error) {
+		if tokenAddress == bad {
+			return nil, errors.New("cannot create token network")
+		}
+		return &rpc.TokenNetworkProxy{}, nil
+	})
+	assert.Nil(t, err)
+	assert.NotNil(t, rs.Token2ChannelGraph[good])
+	assert.Nil(t, rs.Token2ChannelGraph[bad])
+	_, ok := rs.Token2TokenNetwork[bad]
+	assert.False(t, ok)
+	p := rs.GetStartupProgress()
+	assert.Equal(t, 2, p.TotalTokens)
+	assert.Equal(t, 1, p.LoadedTokens)
+	assert.Equal(t, 1, p.SkippedTokens)
+	assert.False(t, p.Ready)
+
+	//没有返回错误,但是 proxy 为空也要跳过
+	delete(rs.Token2ChannelGraph, good)
+	err = rs.registerTokenNetworks(tokens, func(tokenAddress common.Address) (*rpc.TokenNetworkProxy, error) {
+		return nil, nil
+	})
+	assert.Nil(t, err)
+	assert.Empty(t, rs.Token2ChannelGraph)
+}
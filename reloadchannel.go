@@ -0,0 +1,111 @@
+package photon
+
+import (
+	"fmt"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/route"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+ReloadChannel 用数据库中保存的通道信息重建内存中的通道,比如手工修改过数据库,或者怀疑内存中的状态和数据库不一致.
+和启动时加载通道的过程一样,只是针对一个通道.
+通道上还有锁或者进行中的交易引用了这个通道时拒绝,因为交易持有旧的通道对象.
+需要以链上数据为准时,先调用 RepairChannelFromChain.
+可以在任意线程中调用
+*/
+func (rs *Service) ReloadChannel(channelIdentifier common.Hash) error {
+	return <-rs.reloadChannelClient(channelIdentifier).Result
+}
+
+/*
+reloadChannel 只能在主线程中调用
+*/
+func (rs *Service) reloadChannel(channelIdentifier common.Hash) (result *utils.AsyncResult) {
+	c := rs.getChannelWithAddr(channelIdentifier)
+	if c == nil {
+		return utils.NewAsyncResultWithError(rerr.ErrChannelNotFound.Printf("can not find channel %s", channelIdentifier.String()))
+	}
+	switch c.State {
+	case channeltype.StateOpened, channeltype.StateClosed, channeltype.StateError:
+	default:
+		return utils.NewAsyncResultWithError(rerr.ErrChannelInUse.Printf("channel %s is %s", utils.HPex(channelIdentifier), c.State))
+	}
+	if err := rs.checkChannelNotInUse(c); err != nil {
+		return utils.NewAsyncResultWithError(err)
+	}
+	cs, err := rs.dao.GetChannelByAddress(channelIdentifier)
+	if err != nil {
+		return utils.NewAsyncResultWithError(rerr.ErrChannelNotFound.Printf("can not find channel %s in db", channelIdentifier.String()))
+	}
+	ch, err := rs.channelSerilization2Channel(cs, c.ExternState.TokenNetwork)
+	if err != nil {
+		return utils.NewAsyncResultWithError(err)
+	}
+	g := rs.getToken2ChannelGraph(c.TokenAddress)
+	//通道双方不变,graph 中的边不用修改,直接替换通道对象
+	g.ChannelIdentifier2Channel[channelIdentifier] = ch
+	g.PartenerAddress2Channel[ch.PartnerState.Address] = ch
+	for _, chs := range rs.Token2LockSecretHash2Channels[c.TokenAddress] {
+		for i := range chs {
+			if chs[i] == c {
+				chs[i] = ch
+			}
+		}
+	}
+	log.Info(fmt.Sprintf("reload channel %s from db,state=%s,our balance=%s,partner balance=%s",
+		utils.HPex(channelIdentifier), ch.State, ch.OurState.Balance(ch.PartnerState), ch.PartnerState.Balance(ch.OurState)))
+	return utils.NewAsyncResultWithError(nil)
+}
+
+//checkChannelNotInUse 通道上有锁,或者有交易的路由引用了这个通道
+func (rs *Service) checkChannelNotInUse(c *channel.Channel) error {
+	locks := len(c.OurState.Lock2PendingLocks) + len(c.OurState.Lock2UnclaimedLocks) +
+		len(c.PartnerState.Lock2PendingLocks) + len(c.PartnerState.Lock2UnclaimedLocks)
+	if locks > 0 {
+		return rerr.ErrChannelInUse.Printf("channel %s has %d locks", utils.HPex(c.ChannelIdentifier.ChannelIdentifier), locks)
+	}
+	for _, sm := range rs.Transfer2StateManager {
+		for _, r := range stateRoutes(sm.CurrentState) {
+			if r != nil && r.ChannelIdentifier == c.ChannelIdentifier.ChannelIdentifier {
+				return rerr.ErrChannelInUse.Printf("channel %s is used by transfer %s",
+					utils.HPex(c.ChannelIdentifier.ChannelIdentifier), utils.HPex(sm.Identifier))
+			}
+		}
+	}
+	return nil
+}
+
+//stateRoutes 交易状态中所有的路由
+func stateRoutes(state interface{}) (routes []*route.State) {
+	addRoutesState := func(rs *route.RoutesState) {
+		if rs == nil {
+			return
+		}
+		routes = append(routes, rs.AvailableRoutes...)
+		routes = append(routes, rs.IgnoredRoutes...)
+		routes = append(routes, rs.RefundedRoutes...)
+		for _, cr := range rs.CanceledRoutes {
+			routes = append(routes, cr.Route)
+		}
+	}
+	switch s := state.(type) {
+	case *mediatedtransfer.InitiatorState:
+		routes = append(routes, s.Route)
+		addRoutesState(s.Routes)
+	case *mediatedtransfer.MediatorState:
+		addRoutesState(s.Routes)
+		for _, pair := range s.TransfersPair {
+			routes = append(routes, pair.PayerRoute, pair.PayeeRoute)
+		}
+	case *mediatedtransfer.TargetState:
+		routes = append(routes, s.FromRoute)
+	}
+	return
+}
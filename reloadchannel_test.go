@@ -0,0 +1,83 @@
+package photon
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/network/graph"
+	"github.com/SmartMeshFoundation/Photon/network/rpc"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/transfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer/target"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/transfer/route"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloadChannel(t *testing.T) {
+	key, our := utils.MakePrivateKeyAddress()
+	partner, token := utils.NewRandomAddress(), utils.NewRandomAddress()
+	ourState := channel.NewChannelEndState(our, big.NewInt(100), nil, mtree.EmptyTree)
+	partnerState := channel.NewChannelEndState(partner, big.NewInt(50), nil, mtree.EmptyTree)
+	c, err := channel.NewChannel(ourState, partnerState, &channel.ExternalState{}, token,
+		&contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash(), OpenBlockNumber: 3}, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewChannelGraph(our, token, nil)
+	err = g.AddChannel(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := &Service{
+		NodeAddress:                   our,
+		PrivateKey:                    key,
+		Chain:                         &rpc.BlockChainService{},
+		Config:                        &params.Config{},
+		Token2ChannelGraph:            map[common.Address]*graph.ChannelGraph{token: g},
+		Token2LockSecretHash2Channels: make(map[common.Address]map[common.Hash][]*channel.Channel),
+		Transfer2StateManager:         make(map[common.Hash]*transfer.StateManager),
+		dao:                           codefortest.NewTestDB(""),
+	}
+	err = rs.dao.NewChannel(channel.NewChannelSerialization(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	channelIdentifier := c.ChannelIdentifier.ChannelIdentifier
+	//内存中的通道和数据库不一致
+	c.OurState.ContractBalance = big.NewInt(1)
+
+	//有交易引用这个通道时拒绝
+	smKey := utils.NewRandomHash()
+	rs.Transfer2StateManager[smKey] = transfer.NewStateManager(nil, &mediatedtransfer.TargetState{
+		FromRoute: route.NewState(c, nil),
+	}, target.NameTargetTransition, utils.NewRandomHash(), token)
+	err = <-rs.reloadChannel(channelIdentifier).Result
+	assert.Equal(t, rerr.ErrChannelInUse.ErrorCode, err.(rerr.StandardError).ErrorCode)
+	delete(rs.Transfer2StateManager, smKey)
+
+	c.State = channeltype.StateWithdraw
+	err = <-rs.reloadChannel(channelIdentifier).Result
+	assert.Equal(t, rerr.ErrChannelInUse.ErrorCode, err.(rerr.StandardError).ErrorCode)
+	c.State = channeltype.StateOpened
+
+	err = <-rs.reloadChannel(channelIdentifier).Result
+	assert.Nil(t, err)
+	ch := g.ChannelIdentifier2Channel[channelIdentifier]
+	assert.True(t, ch != c)
+	assert.True(t, g.PartenerAddress2Channel[partner] == ch)
+	assert.EqualValues(t, 100, ch.OurState.ContractBalance.Int64())
+	assert.EqualValues(t, 50, ch.PartnerState.ContractBalance.Int64())
+	assert.EqualValues(t, 3, ch.ChannelIdentifier.OpenBlockNumber)
+
+	err = <-rs.reloadChannel(utils.NewRandomHash()).Result
+	assert.Equal(t, rerr.ErrChannelNotFound.ErrorCode, err.(rerr.StandardError).ErrorCode)
+}
@@ -2,8 +2,11 @@ package photon
 
 import (
 	"math/big"
+	"time"
 
 	"github.com/SmartMeshFoundation/Photon/pfsproxy"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer"
 	"github.com/SmartMeshFoundation/Photon/utils"
 	"github.com/ethereum/go-ethereum/common"
 )
@@ -29,6 +32,21 @@ type TokenSwap struct {
 	ToAmount        *big.Int
 	ToNodeAddress   common.Address //the node address of the owner of the `to_token`
 	RouteInfo       []pfsproxy.FindPathResponse
+	/*
+		multi-leg swap 中使用,两方 swap 时为空:
+		NextNodeAddress taker 收到付款以后向这个节点支付 `to_token`,为空表示付给 FromNodeAddress
+		LastPayerAddress maker 期望收到的 `to_token` 必须由这个节点发起,为空表示不检查
+	*/
+	NextNodeAddress  common.Address
+	LastPayerAddress common.Address
+}
+
+//takerPayee taker 收到付款以后要支付的节点
+func (ts *TokenSwap) takerPayee() common.Address {
+	if ts.NextNodeAddress != utils.EmptyAddress {
+		return ts.NextNodeAddress
+	}
+	return ts.FromNodeAddress
 }
 
 const transferReqName = "transfer"
@@ -49,6 +67,28 @@ const registerSecretReqName = "RegisterSecret"
 const getUnfinishedReceviedTransferReqName = "GetUnfinishedReceivedTransfer"
 const forceUnlockReqName = "ForceUnlock"
 const registerSecretOnChainReqName = "registerSecretOnChain"
+const repairChannelFromChainReqName = "RepairChannelFromChain"
+const getQueuedTransfersReqName = "GetQueuedTransfers"
+const cancelQueuedTransferReqName = "CancelQueuedTransfer"
+const setDefaultRevealTimeoutReqName = "SetDefaultRevealTimeout"
+const updateRouteDenylistReqName = "UpdateRouteDenylist"
+const settleAllReadyReqName = "SettleAllReady"
+const getLoopStatsReqName = "GetLoopStats"
+const getStuckTransfersReqName = "GetStuckTransfers"
+const registerSecretToChannelsReqName = "RegisterSecretToChannels"
+const setPausedReqName = "SetPaused"
+const getReachableTargetsReqName = "GetReachableTargets"
+const exportCapacityReportReqName = "ExportCapacityReport"
+const updateBalanceProofReqName = "UpdateBalanceProof"
+const previewCooperativeSettleReqName = "PreviewCooperativeSettle"
+const prepareTransferReqName = "PrepareTransfer"
+const getKnownSecretsReqName = "GetKnownSecrets"
+const reloadChannelReqName = "ReloadChannel"
+const dumpStateReqName = "DumpState"
+const getPendingTokenSwapsReqName = "GetPendingTokenSwaps"
+const cancelTokenSwapReqName = "CancelTokenSwap"
+const verifyChannelProofsReqName = "VerifyChannelProofs"
+const getSafeToTransactReqName = "GetSafeToTransact"
 
 /*
 transfer api
@@ -61,6 +101,10 @@ type transferReq struct {
 	IsDirectTransfer bool
 	Data             string
 	RouteInfo        []pfsproxy.FindPathResponse
+	Metadata         map[string]string //只保存在本地的元数据,不会发送给其他节点
+	QueueDeadline    time.Time         //not zero means queue this transfer until deadline when there is no route
+	QueueID          common.Hash       //id of the queued transfer,chosen by caller so it is known before the transfer is queued
+	CancelDeadline   time.Time         //not zero means cancel this transfer if secret is not revealed before deadline
 }
 
 /*
@@ -72,6 +116,7 @@ type newChannelReq struct {
 	settleTimeout  int
 	amount         *big.Int
 	isNewChannel   bool
+	force          bool
 }
 
 /*
@@ -138,7 +183,7 @@ Transfer `amount` between this node and `target`.
            - Network speed, making the transfer sufficiently fast so it doesn't
              expire.
 */
-func (rs *Service) transferAsyncClient(tokenAddress common.Address, amount *big.Int, target common.Address, secret common.Hash, isDirectTransfer bool, data string, routeInfo []pfsproxy.FindPathResponse) *utils.AsyncResult {
+func (rs *Service) transferAsyncClient(tokenAddress common.Address, amount *big.Int, target common.Address, secret common.Hash, isDirectTransfer bool, data string, routeInfo []pfsproxy.FindPathResponse, metadata map[string]string) *utils.AsyncResult {
 	req := &apiReq{
 		ReqID: utils.RandomString(10),
 		Name:  transferReqName,
@@ -150,18 +195,73 @@ func (rs *Service) transferAsyncClient(tokenAddress common.Address, amount *big.
 			IsDirectTransfer: isDirectTransfer,
 			Data:             data,
 			RouteInfo:        routeInfo,
+			Metadata:         metadata,
 		},
 	}
 	return rs.sendReqClient(req)
 	//return rs.startMediatedTransfer(tokenAddress, target, amount, identifier)
 }
+func (rs *Service) transferWithQueueClient(tokenAddress common.Address, amount *big.Int, target common.Address, secret common.Hash, data string, routeInfo []pfsproxy.FindPathResponse, queueID common.Hash, queueTimeout time.Duration) *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  transferReqName,
+		Req: &transferReq{
+			TokenAddress:  tokenAddress,
+			Amount:        amount,
+			Target:        target,
+			Secret:        secret,
+			Data:          data,
+			RouteInfo:     routeInfo,
+			QueueDeadline: rs.Clock.Now().Add(queueTimeout),
+			QueueID:       queueID,
+		},
+	}
+	return rs.sendReqClient(req)
+}
+func (rs *Service) transferWithTimeoutClient(tokenAddress common.Address, amount *big.Int, target common.Address, secret common.Hash, data string, routeInfo []pfsproxy.FindPathResponse, cancelTimeout time.Duration) *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  transferReqName,
+		Req: &transferReq{
+			TokenAddress:   tokenAddress,
+			Amount:         amount,
+			Target:         target,
+			Secret:         secret,
+			Data:           data,
+			RouteInfo:      routeInfo,
+			CancelDeadline: rs.Clock.Now().Add(cancelTimeout),
+		},
+	}
+	return rs.sendReqClient(req)
+}
+
+/*
+sendReqClient 同一个通道上的请求按照调用顺序依次处理,前一个请求有结果以后才会提交下一个,
+不同通道之间互不影响.
+*/
 func (rs *Service) sendReqClient(req *apiReq) *utils.AsyncResult {
+	key, ok := rs.channelReqKey(req)
+	if !ok {
+		return rs.submitReq(req)
+	}
+	release := rs.reqSequencer.acquire(key)
+	return rs.reqSequencer.releaseAfter(rs.submitReq(req), release)
+}
+
+/*
+submitReq 队列满的时候不阻塞调用者,直接返回 ErrPhotonBusy
+*/
+func (rs *Service) submitReq(req *apiReq) *utils.AsyncResult {
 	req.result = make(chan *utils.AsyncResult, 1)
-	rs.UserReqChan <- req
+	select {
+	case rs.UserReqChan <- req:
+	default:
+		return utils.NewAsyncResultWithError(rerr.ErrPhotonBusy.Printf("too many pending requests,%s rejected", req.Name))
+	}
 	ar := <-req.result
 	return ar
 }
-func (rs *Service) depositAndOpenChannelClient(token, partner common.Address, settleTimeout int, deposit *big.Int, isNewChannel bool) *utils.AsyncResult {
+func (rs *Service) depositAndOpenChannelClient(token, partner common.Address, settleTimeout int, deposit *big.Int, isNewChannel, force bool) *utils.AsyncResult {
 	req := &apiReq{
 		ReqID: utils.RandomString(10),
 		Name:  newChannelReqName,
@@ -171,6 +271,7 @@ func (rs *Service) depositAndOpenChannelClient(token, partner common.Address, se
 			settleTimeout:  settleTimeout,
 			amount:         deposit,
 			isNewChannel:   isNewChannel,
+			force:          force,
 		},
 	}
 	return rs.sendReqClient(req)
@@ -318,6 +419,17 @@ func (rs *Service) registerSecretClient(secret common.Hash, tokenAddress common.
 	return rs.sendReqClient(req)
 }
 
+func (rs *Service) registerSecretToChannelsClient(secret common.Hash) *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  registerSecretToChannelsReqName,
+		Req: &registerSecretReq{
+			Secret: secret,
+		},
+	}
+	return rs.sendReqClient(req)
+}
+
 type getUnfinishedReceivedTransferReq struct {
 	LockSecretHash common.Hash
 	TokenAddress   common.Address
@@ -362,3 +474,261 @@ func (rs *Service) registerSecretOnChainClient(secret common.Hash) *utils.AsyncR
 	}
 	return rs.sendReqClient(req)
 }
+
+/*
+repairChannelFromChainReq 链上查询到的通道信息,在主线程中用来修复本地通道
+channel info queried from the contract, used to repair local channel in main loop
+*/
+type repairChannelFromChainReq struct {
+	ChannelIdentifier common.Hash
+	OpenBlockNumber   int64
+	State             uint8
+	SettleBlockNumber uint64
+	SettleTimeout     uint64
+	OurDeposit        *big.Int
+	PartnerDeposit    *big.Int
+	OurNonce          uint64
+	PartnerNonce      uint64
+	Closed            *mediatedtransfer.ContractClosedStateChange //not nil when channel is closed on chain
+}
+
+func (rs *Service) repairChannelFromChainClient(r *repairChannelFromChainReq) *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  repairChannelFromChainReqName,
+		Req:   r,
+	}
+	return rs.sendReqClient(req)
+}
+
+func (rs *Service) getQueuedTransfersClient() *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  getQueuedTransfersReqName,
+	}
+	return rs.sendReqClient(req)
+}
+
+func (rs *Service) getLoopStatsClient() *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  getLoopStatsReqName,
+	}
+	return rs.sendReqClient(req)
+}
+
+func (rs *Service) getStuckTransfersClient() *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  getStuckTransfersReqName,
+	}
+	return rs.sendReqClient(req)
+}
+
+type getReachableTargetsReq struct {
+	TokenAddress common.Address
+	Amount       *big.Int
+}
+
+func (rs *Service) getReachableTargetsClient(tokenAddress common.Address, amount *big.Int) *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  getReachableTargetsReqName,
+		Req: &getReachableTargetsReq{
+			TokenAddress: tokenAddress,
+			Amount:       amount,
+		},
+	}
+	return rs.sendReqClient(req)
+}
+
+type setPausedReq struct {
+	Paused bool
+}
+
+func (rs *Service) setPausedClient(paused bool) *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  setPausedReqName,
+		Req: &setPausedReq{
+			Paused: paused,
+		},
+	}
+	return rs.sendReqClient(req)
+}
+
+type cancelQueuedTransferReq struct {
+	QueueID common.Hash
+}
+
+func (rs *Service) cancelQueuedTransferClient(queueID common.Hash) *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  cancelQueuedTransferReqName,
+		Req: &cancelQueuedTransferReq{
+			QueueID: queueID,
+		},
+	}
+	return rs.sendReqClient(req)
+}
+
+type setDefaultRevealTimeoutReq struct {
+	RevealTimeout int
+}
+
+func (rs *Service) setDefaultRevealTimeoutClient(revealTimeout int) *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  setDefaultRevealTimeoutReqName,
+		Req: &setDefaultRevealTimeoutReq{
+			RevealTimeout: revealTimeout,
+		},
+	}
+	return rs.sendReqClient(req)
+}
+
+type updateRouteDenylistReq struct {
+	Addr   common.Address
+	Remove bool
+}
+
+func (rs *Service) updateRouteDenylistClient(addr common.Address, remove bool) *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  updateRouteDenylistReqName,
+		Req: &updateRouteDenylistReq{
+			Addr:   addr,
+			Remove: remove,
+		},
+	}
+	return rs.sendReqClient(req)
+}
+
+func (rs *Service) settleAllReadyClient() *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  settleAllReadyReqName,
+	}
+	return rs.sendReqClient(req)
+}
+
+type exportCapacityReportReq struct {
+	TokenAddress common.Address
+}
+
+func (rs *Service) exportCapacityReportClient(tokenAddress common.Address) *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  exportCapacityReportReqName,
+		Req: &exportCapacityReportReq{
+			TokenAddress: tokenAddress,
+		},
+	}
+	return rs.sendReqClient(req)
+}
+
+func (rs *Service) updateBalanceProofClient(channelIdentifier common.Hash) *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  updateBalanceProofReqName,
+		Req: &closeSettleChannelReq{
+			addr: channelIdentifier,
+		},
+	}
+	return rs.sendReqClient(req)
+}
+
+func (rs *Service) previewCooperativeSettleClient(channelIdentifier common.Hash) *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  previewCooperativeSettleReqName,
+		Req: &closeSettleChannelReq{
+			addr: channelIdentifier,
+		},
+	}
+	return rs.sendReqClient(req)
+}
+
+func (rs *Service) prepareTransferClient(tokenAddress, target common.Address, amount *big.Int, opts TransferOptions) *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  prepareTransferReqName,
+		Req: &transferReq{
+			TokenAddress:     tokenAddress,
+			Amount:           amount,
+			Target:           target,
+			Secret:           opts.Secret,
+			IsDirectTransfer: opts.IsDirectTransfer,
+			Data:             opts.Data,
+			RouteInfo:        opts.RouteInfo,
+			Metadata:         opts.Metadata,
+		},
+	}
+	return rs.sendReqClient(req)
+}
+
+func (rs *Service) getKnownSecretsClient() *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  getKnownSecretsReqName,
+	}
+	return rs.sendReqClient(req)
+}
+
+func (rs *Service) reloadChannelClient(channelIdentifier common.Hash) *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  reloadChannelReqName,
+		Req: &closeSettleChannelReq{
+			addr: channelIdentifier,
+		},
+	}
+	return rs.sendReqClient(req)
+}
+
+func (rs *Service) dumpStateClient() *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  dumpStateReqName,
+	}
+	return rs.sendReqClient(req)
+}
+
+func (rs *Service) getPendingTokenSwapsClient() *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  getPendingTokenSwapsReqName,
+	}
+	return rs.sendReqClient(req)
+}
+
+func (rs *Service) cancelTokenSwapClient(lockSecretHash common.Hash, fromToken common.Address) *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  cancelTokenSwapReqName,
+		Req: &cancelTransferReq{
+			LockSecretHash: lockSecretHash,
+			TokenAddress:   fromToken,
+		},
+	}
+	return rs.sendReqClient(req)
+}
+
+func (rs *Service) verifyChannelProofsClient(channelIdentifier common.Hash) *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  verifyChannelProofsReqName,
+		Req: &closeSettleChannelReq{
+			addr: channelIdentifier,
+		},
+	}
+	return rs.sendReqClient(req)
+}
+
+func (rs *Service) getSafeToTransactClient() *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  getSafeToTransactReqName,
+	}
+	return rs.sendReqClient(req)
+}
@@ -0,0 +1,98 @@
+package photon
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+reqSequencer 保证同一个通道上的用户请求先进先出,比如先 deposit 再 close,
+每个调用者排在同一个 key 上一个调用者的后面,上一个请求有结果以后才轮到自己.
+*/
+type reqSequencer struct {
+	lock    sync.Mutex
+	tail    map[common.Hash]chan struct{} //每个 key 最后一个排队者,完成以后关闭
+	timeout time.Duration                 //请求超过这个时间还没有结果就不再阻塞后面的请求
+}
+
+func newReqSequencer() *reqSequencer {
+	return &reqSequencer{
+		tail:    make(map[common.Hash]chan struct{}),
+		timeout: params.MaxRequestTimeout,
+	}
+}
+
+/*
+acquire 等待 key 上之前的请求全部完成,返回的 release 必须调用一次
+*/
+func (s *reqSequencer) acquire(key common.Hash) (release func()) {
+	s.lock.Lock()
+	prev := s.tail[key]
+	mine := make(chan struct{})
+	s.tail[key] = mine
+	s.lock.Unlock()
+	if prev != nil {
+		<-prev
+	}
+	return func() {
+		s.lock.Lock()
+		if s.tail[key] == mine {
+			delete(s.tail, key)
+		}
+		s.lock.Unlock()
+		close(mine)
+	}
+}
+
+/*
+releaseAfter 请求有结果以后再 release,调用者拿到的是一个新的 AsyncResult.
+有的请求可能永远没有结果,比如主线程退出了,超时以后也 release,否则同一个 key 上后面的请求会永远阻塞,
+超时的请求有结果以后仍然会交给调用者
+*/
+func (s *reqSequencer) releaseAfter(ar *utils.AsyncResult, release func()) *utils.AsyncResult {
+	out := utils.NewAsyncResult()
+	out.LockSecretHash = ar.LockSecretHash
+	go func() {
+		var err error
+		select {
+		case err = <-ar.Result:
+			release()
+		case <-time.After(s.timeout):
+			log.Warn(fmt.Sprintf("request has no result after %s,release it to unblock following requests", s.timeout))
+			release()
+			err = <-ar.Result
+		}
+		out.Tag = ar.Tag
+		out.Result <- err
+	}()
+	return out
+}
+
+/*
+channelReqKey 通道相关的请求以 token 和 partner 作为 key,这样通道创建之前的 deposit 和之后的 close 也能排序
+*/
+func (rs *Service) channelReqKey(req *apiReq) (key common.Hash, ok bool) {
+	var channelIdentifier common.Hash
+	switch r := req.Req.(type) {
+	case *newChannelReq:
+		return utils.Sha3(r.tokenAddress[:], r.partnerAddress[:]), true
+	case *closeSettleChannelReq:
+		channelIdentifier = r.addr
+	case *withdrawReq:
+		channelIdentifier = r.addr
+	default:
+		return
+	}
+	c, err := rs.dao.GetChannelByAddress(channelIdentifier)
+	if err != nil {
+		return
+	}
+	token, partner := c.TokenAddress(), c.PartnerAddress()
+	return utils.Sha3(token[:], partner[:]), true
+}
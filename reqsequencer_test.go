@@ -0,0 +1,70 @@
+package photon
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReqSequencer(t *testing.T) {
+	s := newReqSequencer()
+	key := utils.NewRandomHash()
+	first := utils.NewAsyncResult()
+	out := s.releaseAfter(first, s.acquire(key))
+	acquired := make(chan struct{})
+	go func() {
+		s.acquire(key)()
+		close(acquired)
+	}()
+	//其他通道不受影响
+	s.acquire(utils.NewRandomHash())()
+	select {
+	case <-acquired:
+		t.Error("should wait previous request on the same channel")
+		return
+	case <-time.After(50 * time.Millisecond):
+	}
+	first.Tag = 3
+	first.Result <- nil
+	assert.Nil(t, <-out.Result)
+	assert.EqualValues(t, 3, out.Tag)
+	<-acquired
+	assert.Len(t, s.tail, 0)
+}
+
+//请求一直没有结果的话,超时以后后面的请求不再等待,结果晚到仍然交给调用者
+func TestReqSequencerTimeout(t *testing.T) {
+	s := newReqSequencer()
+	s.timeout = 50 * time.Millisecond
+	key := utils.NewRandomHash()
+	first := utils.NewAsyncResult()
+	out := s.releaseAfter(first, s.acquire(key))
+	acquired := make(chan struct{})
+	go func() {
+		s.acquire(key)()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+	case <-time.After(10 * time.Second):
+		t.Fatal("following request blocked by a request without result")
+	}
+	select {
+	case <-out.Result:
+		t.Fatal("result should not be delivered before the request finishes")
+	default:
+	}
+	first.Result <- errors.New("late")
+	assert.EqualError(t, <-out.Result, "late")
+	assert.Len(t, s.tail, 0)
+}
+
+func TestSubmitReqBusy(t *testing.T) {
+	rs := &Service{UserReqChan: make(chan *apiReq, 1)}
+	rs.UserReqChan <- &apiReq{}
+	result := rs.submitReq(&apiReq{Name: getQueuedTransfersReqName})
+	assert.NotNil(t, <-result.Result)
+}
@@ -176,6 +176,24 @@ var (
 	ErrNotChargeFee = NewError(1022, "ErrNotChargeFee")
 	//ErrNotAllowDirectTransfer not allow mediated transfer when mesh
 	ErrNotAllowDirectTransfer = NewError(1023, "can not send direct transfer after photon worked without effective chain for a long time")
+	//ErrTransferCanceled 排队等待路由的交易被用户取消
+	ErrTransferCanceled = NewError(1024, "TransferCanceled")
+	//ErrPhotonBusy 待处理的用户请求太多,稍后再试
+	ErrPhotonBusy = NewError(1025, "busy, try again")
+	//ErrAmountTooSmall 交易金额低于配置的最小金额
+	ErrAmountTooSmall = NewError(1026, "AmountTooSmall")
+	//ErrDBEncryptionKey 数据库加密密钥错误,或者数据库是否加密和配置不一致
+	ErrDBEncryptionKey = NewError(1027, "DBEncryptionKeyMismatch")
+	//ErrTooManyInFlight 正在进行的交易数量达到了配置的上限
+	ErrTooManyInFlight = NewError(1028, "TooManyInFlightTransfers")
+	//ErrPaused 节点暂停了交易处理,Resume 以后重试
+	ErrPaused = NewError(1029, "Paused")
+	//ErrMediationFeeTooLow 中转能收到的手续费低于配置的下限,拒绝中转
+	ErrMediationFeeTooLow = NewError(1030, "MediationFeeTooLow")
+	//ErrDirectTransfersDisabled 配置了 DisableDirectTransfers,不能发起 DirectTransfer
+	ErrDirectTransfersDisabled = NewError(1031, "DirectTransfersDisabled")
+	//ErrLockExpirationTooFar 锁的过期时间超过了配置的上限,拒绝中转
+	ErrLockExpirationTooFar = NewError(1032, "LockExpirationTooFar")
 	/*
 		以太坊报公链节点报的错误
 
@@ -216,6 +234,8 @@ var (
 	ErrSpectrumSyncError = NewError(2012, "ErrSpectrumSyncError")
 	//ErrSpectrumBlockError 本地已处理的块数和公链汇报块数不一致,比如我本地已经处理到了50000块,但是公链节点报告现在只有3000块
 	ErrSpectrumBlockError = NewError(2013, "ErrSpectrumBlockError")
+	//ErrTxWouldRevert 估算gas的时候公链节点报告交易会失败
+	ErrTxWouldRevert = NewError(2014, "ErrTxWouldRevert")
 	//ErrUnkownSpectrumRPCError 其他以太坊rpc错误
 	ErrUnkownSpectrumRPCError = NewError(2999, "unkown spectrum rpc error")
 	/*ErrTokenNotFound Raised when token not found
@@ -236,6 +256,16 @@ var (
 	ErrRejectTransferBecausePayerChannelClosed = NewError(3007, "payer's channel already closed ,reject mediated transfer")
 	// ErrChannelNoEnoughBalance 通道余额不足
 	ErrChannelNoEnoughBalance = NewError(3008, "no enough balance")
+	//ErrRouteChannelCannotTransfer 下一跳的通道状态不允许交易
+	ErrRouteChannelCannotTransfer = NewError(3009, "channel can not transfer")
+	//ErrRouteLockExpirationTooNear 锁剩余的时间不足以安全地继续转发
+	ErrRouteLockExpirationTooNear = NewError(3010, "too near to lock expiration")
+	//ErrRouteNoEnoughFee 上家给出的手续费不够
+	ErrRouteNoEnoughFee = NewError(3011, "no enough fee")
+	//ErrRouteCycle 唯一可用的下一跳就是上家
+	ErrRouteCycle = NewError(3012, "cycle route")
+	//ErrTooManyLocks 通道一方未解锁的锁达到了 Config.MaxPendingLocksPerChannel
+	ErrTooManyLocks = NewError(3013, "too many pending locks")
 	/*ErrPFS PFS Error
 	向PFS发起请求错误
 	*/
@@ -307,6 +337,12 @@ var (
 	/*ErrOpenChannelWithSelf 不能自己与自己创建通道
 	 */
 	ErrOpenChannelWithSelf = NewError(5027, "ErrOpenChannelWithSelf")
+	//ErrChannelInUse 通道上还有未完成的交易或者操作
+	ErrChannelInUse = NewError(5028, "ErrChannelInUse")
+	//ErrChannelDepositInProgress 通道上已经有还没有打包的存款交易
+	ErrChannelDepositInProgress = NewError(5029, "deposit already in progress")
+	//ErrChannelBalanceProofInvalid 保存的 balance proof 签名无效,关闭通道以后无法在链上使用
+	ErrChannelBalanceProofInvalid = NewError(5030, "ErrChannelBalanceProofInvalid")
 	/*
 		Transport error
 	*/
@@ -317,6 +353,8 @@ var (
 	ErrSubScribeNeighbor = NewError(6001, "ErrSubScribeNeighbor")
 	//ErrContractQueryError 合约查询发生错误
 	ErrContractQueryError = NewError(6002, "ErrContractQueryError")
+	//ErrMessageRateLimited 对方发送消息过于频繁,消息被丢弃
+	ErrMessageRateLimited = NewError(6003, "ErrMessageRateLimited")
 
 	// ErrUnknown 未知错误
 	ErrUnknown = NewError(9999, "unknown error")
@@ -144,6 +144,7 @@ type depositReq struct {
 	//  SettleTimeout 必须为0,表示只是存款,一定不要创建通道
 	SettleTimeout int  `json:"settle_timeout"`
 	NewChannel    bool `json:"new_channel"` //此次行为是创建通道并存款还是只存款
+	Force         bool `json:"force"`       //上一次存款还没有打包时也要存款
 }
 
 /*
@@ -176,7 +177,11 @@ func Deposit(w rest.ResponseWriter, r *rest.Request) {
 		return
 	}
 
-	c, err := API.DepositAndOpenChannel(tokenAddr, partnerAddr, req.SettleTimeout, API.Photon.Config.RevealTimeout, req.Balance, req.NewChannel)
+	deposit := API.DepositAndOpenChannel
+	if req.Force {
+		deposit = API.DepositAndOpenChannelForce
+	}
+	c, err := deposit(tokenAddr, partnerAddr, req.SettleTimeout, API.Photon.Config.RevealTimeout, req.Balance, req.NewChannel)
 	if err != nil {
 		resp = dto.NewExceptionAPIResponse(err)
 		return
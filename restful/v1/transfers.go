@@ -7,6 +7,7 @@ import (
 
 	"github.com/SmartMeshFoundation/Photon/rerr"
 
+	photon "github.com/SmartMeshFoundation/Photon"
 	"github.com/SmartMeshFoundation/Photon/dto"
 	"github.com/SmartMeshFoundation/Photon/log"
 	"github.com/SmartMeshFoundation/Photon/params"
@@ -25,9 +26,12 @@ type TransferData struct {
 	Secret         string                      `json:"secret,omitempty"` // 当用户想使用自己指定的密码,而非随机密码时使用	// client can assign specific secret
 	LockSecretHash string                      `json:"lockSecretHash"`
 	IsDirect       bool                        `json:"is_direct,omitempty"`
-	Sync           bool                        `json:"sync,omitempty"` //是否同步
-	Data           string                      `json:"data"`           // 交易附加信息,长度不超过256
-	RouteInfo      []pfsproxy.FindPathResponse `json:"route_info"`     // 指定的路由信息
+	Sync           bool                        `json:"sync,omitempty"`    //是否同步
+	Data           string                      `json:"data"`              // 交易附加信息,长度不超过256
+	RouteInfo      []pfsproxy.FindPathResponse `json:"route_info"`        // 指定的路由信息
+	Outcome        string                      `json:"outcome,omitempty"` // 同步交易成功时,交易是链下完成还是链上注册密码以后才完成
+	// 只保存在本地的元数据,比如订单号,可以在交易详情中查到,不会发送给其他节点
+	Metadata map[string]string `json:"metadata,omitempty"`
 }
 
 /*
@@ -47,7 +51,7 @@ func GetSentTransferDetails(w rest.ResponseWriter, r *rest.Request) {
 
 /*
 GetReceivedTransfers retuns list of received transfer between `from_block` and `to_block`
-it contains token swap
+it contains token swap,`payment_pointer` returns only transfers paid to this payment pointer
 */
 func GetReceivedTransfers(w rest.ResponseWriter, r *rest.Request) {
 	var resp *dto.APIResponse
@@ -56,7 +60,7 @@ func GetReceivedTransfers(w rest.ResponseWriter, r *rest.Request) {
 		writejson(w, resp)
 	}()
 	from, to := getFromTo(r)
-	trs, err := API.GetReceivedTransfers(utils.EmptyAddress, from, to, -1, -1)
+	trs, err := API.GetReceivedTransfers(utils.EmptyAddress, from, to, -1, -1, r.URL.Query().Get("payment_pointer"))
 	resp = dto.NewAPIResponse(err, trs)
 }
 
@@ -108,9 +112,9 @@ func Transfers(w rest.ResponseWriter, r *rest.Request) {
 	}
 	var result *utils.AsyncResult
 	if req.Sync {
-		result, err = API.Transfer(tokenAddr, req.Amount, targetAddr, common.HexToHash(req.Secret), params.MaxRequestTimeout, req.IsDirect, req.Data, req.RouteInfo)
+		result, err = API.Transfer(tokenAddr, req.Amount, targetAddr, common.HexToHash(req.Secret), params.MaxRequestTimeout, req.IsDirect, req.Data, req.RouteInfo, req.Metadata)
 	} else {
-		result, err = API.TransferAsync(tokenAddr, req.Amount, targetAddr, common.HexToHash(req.Secret), req.IsDirect, req.Data, req.RouteInfo)
+		result, err = API.TransferAsync(tokenAddr, req.Amount, targetAddr, common.HexToHash(req.Secret), req.IsDirect, req.Data, req.RouteInfo, req.Metadata)
 	}
 	if err != nil {
 		resp = dto.NewExceptionAPIResponse(err)
@@ -120,6 +124,9 @@ func Transfers(w rest.ResponseWriter, r *rest.Request) {
 	req.Target = target
 	req.Token = token
 	req.LockSecretHash = result.LockSecretHash.String()
+	if outcome, ok := photon.TransferOutcome(result); ok {
+		req.Outcome = outcome.String()
+	}
 	resp = dto.NewSuccessAPIResponse(req)
 }
 
@@ -0,0 +1,66 @@
+package photon
+
+import (
+	"fmt"
+
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/utils"
+)
+
+//minRevealTimeout 与 channel.NewChannel 的检查保持一致
+const minRevealTimeout = 3
+
+/*
+GetRevealTimeout 新建通道以及新发起的交易使用的 reveal timeout
+*/
+func (rs *Service) GetRevealTimeout() int {
+	return rs.Config.RevealTimeout
+}
+
+/*
+SetDefaultRevealTimeout 运行时修改 reveal timeout,只影响之后新建的通道和新发起的交易,
+已有通道继续使用创建时的值.
+调小这个值会减少新交易在链上注册密码以及解锁的时间余量,在公链拥堵时要谨慎.
+不能在主线程中调用.
+*/
+func (rs *Service) SetDefaultRevealTimeout(revealTimeout int) error {
+	result := rs.setDefaultRevealTimeoutClient(revealTimeout)
+	return <-result.Result
+}
+
+/*
+setDefaultRevealTimeout 必须小于默认的 settle timeout 以及所有可用通道的 settle timeout,
+否则经过这些通道的新交易锁的有效期会小于等于0.
+只能在主线程中调用
+*/
+func (rs *Service) setDefaultRevealTimeout(revealTimeout int) (result *utils.AsyncResult) {
+	if revealTimeout < minRevealTimeout {
+		return utils.NewAsyncResultWithError(rerr.ErrChannelRevealTimeout.Printf("reveal timeout must be at least %d", minRevealTimeout))
+	}
+	if revealTimeout >= rs.Config.SettleTimeout {
+		return utils.NewAsyncResultWithError(rerr.ErrChannelInvalidSettleTimeout.Printf("reveal timeout %d must be smaller than default settle timeout %d", revealTimeout, rs.Config.SettleTimeout))
+	}
+	for token, settleTimeout := range rs.Config.TokenSettleTimeouts {
+		if revealTimeout >= settleTimeout {
+			return utils.NewAsyncResultWithError(rerr.ErrChannelInvalidSettleTimeout.Printf("reveal timeout %d must be smaller than default settle timeout %d of token %s",
+				revealTimeout, settleTimeout, utils.APex2(token)))
+		}
+	}
+	for _, g := range rs.Token2ChannelGraph {
+		for _, c := range g.ChannelIdentifier2Channel {
+			if c.State != channeltype.StateOpened {
+				continue
+			}
+			if revealTimeout >= c.SettleTimeout {
+				return utils.NewAsyncResultWithError(rerr.ErrChannelInvalidSettleTimeout.Printf("reveal timeout %d must be smaller than settle timeout %d of channel %s",
+					revealTimeout, c.SettleTimeout, c.ChannelIdentifier.String()))
+			}
+		}
+	}
+	log.Info(fmt.Sprintf("change reveal timeout from %d to %d", rs.Config.RevealTimeout, revealTimeout))
+	//新发起的交易通过 ActionInitInitiatorStateChange 使用这个值计算锁的过期时间
+	rs.Config.RevealTimeout = revealTimeout
+	return utils.NewAsyncResultWithError(nil)
+}
@@ -0,0 +1,30 @@
+package photon
+
+import (
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/stretchr/testify/assert"
+)
+
+//修改 reveal timeout 只影响这个节点的配置,不能修改全局的默认值,同一个进程中可能有多个节点
+func TestSetDefaultRevealTimeout(t *testing.T) {
+	rs := &Service{Config: &params.Config{SettleTimeout: 600, RevealTimeout: 10}}
+	other := &Service{Config: &params.Config{SettleTimeout: 600, RevealTimeout: 10}}
+	defaultRevealTimeout := params.DefaultRevealTimeout
+	assert.Nil(t, <-rs.setDefaultRevealTimeout(50).Result)
+	assert.Equal(t, 50, rs.GetRevealTimeout())
+	assert.Equal(t, 10, other.GetRevealTimeout())
+	assert.Equal(t, defaultRevealTimeout, params.DefaultRevealTimeout)
+
+	err := <-rs.setDefaultRevealTimeout(minRevealTimeout - 1).Result
+	if assert.NotNil(t, err) {
+		assert.Equal(t, rerr.ErrChannelRevealTimeout.ErrorCode, err.(rerr.StandardError).ErrorCode)
+	}
+	err = <-rs.setDefaultRevealTimeout(600).Result
+	if assert.NotNil(t, err) {
+		assert.Equal(t, rerr.ErrChannelInvalidSettleTimeout.ErrorCode, err.(rerr.StandardError).ErrorCode)
+	}
+	assert.Equal(t, 50, rs.GetRevealTimeout())
+}
@@ -0,0 +1,89 @@
+package photon
+
+import (
+	"fmt"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/network/graph"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+AddRouteDenylist 以后发起或者中转的交易都不会经过 addr,
+只限制中间节点,如果 addr 就是交易的接收方,交易仍然可以进行.
+名单会保存在数据库中,重启以后依然有效.
+不能在主线程中调用.
+*/
+func (rs *Service) AddRouteDenylist(addr common.Address) error {
+	result := rs.updateRouteDenylistClient(addr, false)
+	return <-result.Result
+}
+
+/*
+RemoveRouteDenylist 允许 addr 重新作为中间节点
+不能在主线程中调用.
+*/
+func (rs *Service) RemoveRouteDenylist(addr common.Address) error {
+	result := rs.updateRouteDenylistClient(addr, true)
+	return <-result.Result
+}
+
+/*
+GetRouteDenylist 返回所有不能作为中间节点的地址
+*/
+func (rs *Service) GetRouteDenylist() []common.Address {
+	return rs.dao.GetRouteDenylist()
+}
+
+/*
+updateRouteDenylist 先保存到数据库,再修改内存中的名单
+只能在主线程中调用
+*/
+func (rs *Service) updateRouteDenylist(addr common.Address, remove bool) (result *utils.AsyncResult) {
+	if addr == utils.EmptyAddress || addr == rs.NodeAddress {
+		return utils.NewAsyncResultWithError(rerr.ErrArgumentError.Printf("cannot deny %s", addr.String()))
+	}
+	var err error
+	if remove {
+		err = rs.dao.RemoveRouteDenylist(addr)
+	} else {
+		err = rs.dao.AddRouteDenylist(addr)
+	}
+	if err != nil {
+		return utils.NewAsyncResultWithError(err)
+	}
+	if remove {
+		delete(rs.routeDenylist, addr)
+	} else {
+		rs.routeDenylist[addr] = true
+	}
+	log.Info(fmt.Sprintf("route denylist %s remove=%v", utils.APex2(addr), remove))
+	return utils.NewAsyncResultWithError(nil)
+}
+
+/*
+makeRouteExclude 在 addrs 的基础上排除所有被拒绝的节点,但是不能排除交易的接收方 target
+*/
+func (rs *Service) makeRouteExclude(target common.Address, addrs ...common.Address) map[common.Address]bool {
+	exclude := graph.MakeExclude(addrs...)
+	for addr := range rs.routeDenylist {
+		if addr != target {
+			exclude[addr] = true
+		}
+	}
+	return exclude
+}
+
+/*
+hasDeniedHop path 中除了 target 以外是否有被拒绝的节点
+*/
+func (rs *Service) hasDeniedHop(path []common.Address, target common.Address) bool {
+	for _, addr := range path {
+		if addr != target && rs.routeDenylist[addr] {
+			return true
+		}
+	}
+	return false
+}
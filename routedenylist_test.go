@@ -0,0 +1,24 @@
+package photon
+
+import (
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouteDenylistExclude(t *testing.T) {
+	denied, target, sender := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	rs := &Service{routeDenylist: map[common.Address]bool{denied: true}}
+	exclude := rs.makeRouteExclude(target, sender)
+	assert.True(t, exclude[denied])
+	assert.True(t, exclude[sender])
+	assert.False(t, exclude[target])
+	//接收方在名单中也不能被排除
+	assert.False(t, rs.makeRouteExclude(denied)[denied])
+
+	assert.True(t, rs.hasDeniedHop([]common.Address{sender, denied, target}, target))
+	assert.False(t, rs.hasDeniedHop([]common.Address{sender, denied}, denied))
+	assert.False(t, rs.hasDeniedHop([]common.Address{sender, target}, target))
+}
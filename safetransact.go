@@ -0,0 +1,106 @@
+package photon
+
+import (
+	"fmt"
+
+	"github.com/SmartMeshFoundation/Photon/encoding"
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/transfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer/mediator"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer/target"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+heldReveal 公链断开期间,作为接收方暂缓发送的 SecretRequest 或者 RevealSecret,
+这时块号可能已经过时,无法确认锁是否过期,贸然发送可能导致锁过期以后密码才泄露出去.
+只保存在内存中,重启以后丢失,交易会因为超时而被上家移除.
+*/
+type heldReveal struct {
+	receiver     common.Address
+	msg          encoding.SignedMessager
+	stateManager *transfer.StateManager
+}
+
+/*
+GetSafeToTransact 公链连接正常并且公链有效时,才能根据块号安全地判断锁是否过期.
+IsChainEffective 只在主线程中读写,所以通过主线程查询,不能在主线程中调用
+*/
+func (rs *Service) GetSafeToTransact() bool {
+	result := rs.getSafeToTransactClient()
+	err := <-result.Result
+	if err != nil {
+		//主线程太忙的时候按照不安全处理
+		return false
+	}
+	return result.Tag.(bool)
+}
+
+/*
+isSafeToTransact 只能在主线程中调用
+*/
+func (rs *Service) isSafeToTransact() bool {
+	return rs.Chain.Client.IsConnected() && rs.IsChainEffective
+}
+
+func (rs *Service) getSafeToTransact() (result *utils.AsyncResult) {
+	result = utils.NewAsyncResult()
+	result.Tag = rs.isSafeToTransact()
+	result.Result <- nil
+	return
+}
+
+/*
+holdRevealIfUnsafe 作为接收方,不安全的时候暂缓发送,返回true表示消息已经被保存,等待公链恢复
+只能在主线程中调用
+*/
+func (rs *Service) holdRevealIfUnsafe(receiver common.Address, msg encoding.SignedMessager, stateManager *transfer.StateManager) bool {
+	if stateManager == nil || stateManager.Name != target.NameTargetTransition || rs.isSafeToTransact() {
+		return false
+	}
+	rs.heldReveals = append(rs.heldReveals, &heldReveal{
+		receiver:     receiver,
+		msg:          msg,
+		stateManager: stateManager,
+	})
+	log.Warn(fmt.Sprintf("eth connection is not safe, hold %s to %s until reconnected", msg, utils.APex2(receiver)), rs.messageLogCtx(msg)...)
+	return true
+}
+
+/*
+releaseHeldReveals 公链恢复并且收到新块以后,重新检查锁的过期时间,再发送暂缓的消息
+只能在主线程中调用
+*/
+func (rs *Service) releaseHeldReveals() {
+	if len(rs.heldReveals) == 0 || !rs.isSafeToTransact() {
+		return
+	}
+	held := rs.heldReveals
+	rs.heldReveals = nil
+	blockNumber := rs.GetBlockNumber()
+	for _, h := range held {
+		state, ok := h.stateManager.CurrentState.(*mediatedtransfer.TargetState)
+		if !ok || state.FromTransfer == nil {
+			continue
+		}
+		logCtx := rs.messageLogCtx(h.msg)
+		switch h.msg.(type) {
+		case *encoding.SecretRequest:
+			if !mediator.IsSafeToWait(state.FromTransfer, state.FromRoute.RevealTimeout(), blockNumber) {
+				log.Warn(fmt.Sprintf("lock expires at %d, not safe to request secret at %d", state.FromTransfer.Expiration, blockNumber), logCtx...)
+				continue
+			}
+		case *encoding.RevealSecret:
+			if blockNumber > state.FromTransfer.Expiration {
+				log.Warn(fmt.Sprintf("lock expired at %d, drop held reveal secret", state.FromTransfer.Expiration), logCtx...)
+				continue
+			}
+		}
+		err := rs.sendAsync(h.receiver, h.msg)
+		if err != nil {
+			log.Error(fmt.Sprintf("send held %s err %s", h.msg, err), logCtx...)
+		}
+	}
+}
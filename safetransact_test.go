@@ -0,0 +1,117 @@
+package photon
+
+import (
+	"math/big"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/encoding"
+	"github.com/SmartMeshFoundation/Photon/network"
+	"github.com/SmartMeshFoundation/Photon/network/helper"
+	"github.com/SmartMeshFoundation/Photon/network/netshare"
+	"github.com/SmartMeshFoundation/Photon/network/rpc"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/transfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer/mediator"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer/target"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/SmartMeshFoundation/Photon/utils/utest"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+//公链断开期间暂缓发送 SecretRequest 和 RevealSecret,恢复以后检查锁的过期时间再发送
+func TestHoldAndReleaseReveals(t *testing.T) {
+	key, our := utils.MakePrivateKeyAddress()
+	tr := &presenceTransport{sent: make(map[common.Address]int)}
+	client := &helper.SafeEthClient{Status: netshare.Disconnected}
+	rs := &Service{
+		NodeAddress:                 our,
+		Config:                      &params.Config{},
+		Chain:                       &rpc.BlockChainService{Client: client},
+		IsChainEffective:            true,
+		BlockNumber:                 new(atomic.Value),
+		ProtocolMessageSendComplete: make(chan *protocolMessage, 10),
+		quitChan:                    make(chan struct{}),
+		dao:                         codefortest.NewTestDB(""),
+	}
+	rs.Protocol = network.NewPhotonProtocol(tr, key, rs)
+	defer rs.dao.CloseDB()
+	defer rs.Protocol.StopAndWait()
+	defer close(rs.quitChan)
+	rs.BlockNumber.Store(int64(10))
+
+	newTargetManager := func(expiration int64) *transfer.StateManager {
+		st := &mediatedtransfer.TargetState{
+			FromRoute:    utest.MakeRoute(utils.NewRandomAddress(), big.NewInt(10), utest.UnitSettleTimeout, 5, 0, utils.NewRandomHash()),
+			FromTransfer: &mediatedtransfer.LockedTransferState{Expiration: expiration},
+		}
+		return transfer.NewStateManager(target.StateTransiton, st, target.NameTargetTransition, utils.NewRandomHash(), utils.NewRandomAddress())
+	}
+	sign := func(m encoding.SignedMessager) encoding.SignedMessager {
+		assert.Nil(t, m.Sign(key, m))
+		return m
+	}
+	safeReceiver, expiredReceiver, closeToExpireReceiver := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	assert.True(t, rs.holdRevealIfUnsafe(safeReceiver, sign(encoding.NewSecretRequest(utils.NewRandomHash(), big.NewInt(10))), newTargetManager(100)))
+	assert.True(t, rs.holdRevealIfUnsafe(expiredReceiver, sign(encoding.NewRevealSecret(utils.NewRandomHash())), newTargetManager(15)))
+	assert.True(t, rs.holdRevealIfUnsafe(closeToExpireReceiver, sign(encoding.NewSecretRequest(utils.NewRandomHash(), big.NewInt(10))), newTargetManager(24)))
+	//只暂缓接收方的消息
+	mediatorManager := transfer.NewStateManager(mediator.StateTransition, nil, mediator.NameMediatorTransition, utils.NewRandomHash(), utils.NewRandomAddress())
+	assert.False(t, rs.holdRevealIfUnsafe(utils.NewRandomAddress(), sign(encoding.NewRevealSecret(utils.NewRandomHash())), mediatorManager))
+	assert.Len(t, rs.heldReveals, 3)
+
+	//公链还没有恢复
+	rs.releaseHeldReveals()
+	assert.Len(t, rs.heldReveals, 3)
+
+	client.Status = netshare.Connected
+	assert.False(t, rs.holdRevealIfUnsafe(safeReceiver, sign(encoding.NewRevealSecret(utils.NewRandomHash())), newTargetManager(100)))
+	rs.BlockNumber.Store(int64(20))
+	rs.releaseHeldReveals()
+	assert.Len(t, rs.heldReveals, 0)
+	sent := func(addr common.Address) int {
+		tr.lock.Lock()
+		defer tr.lock.Unlock()
+		return tr.sent[addr]
+	}
+	for i := 0; sent(safeReceiver) == 0; i++ {
+		if i > 1000 {
+			t.Fatal("held secret request not sent")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	//锁已经过期,或者离过期不到 reveal timeout,不再发送
+	assert.Equal(t, 0, sent(expiredReceiver))
+	assert.Equal(t, 0, sent(closeToExpireReceiver))
+}
+
+//其他线程通过主线程查询,不能直接读取 IsChainEffective
+func TestGetSafeToTransact(t *testing.T) {
+	client := &helper.SafeEthClient{Status: netshare.Connected}
+	rs := &Service{
+		Chain:        &rpc.BlockChainService{Client: client},
+		UserReqChan:  make(chan *apiReq, 10),
+		reqSequencer: newReqSequencer(),
+	}
+	quit := make(chan struct{})
+	defer close(quit)
+	go func() {
+		for {
+			select {
+			case req := <-rs.UserReqChan:
+				rs.handleReq(req)
+			case <-quit:
+				return
+			}
+		}
+	}()
+	assert.False(t, rs.GetSafeToTransact())
+	rs.IsChainEffective = true
+	assert.True(t, rs.GetSafeToTransact())
+	client.Status = netshare.Disconnected
+	assert.False(t, rs.GetSafeToTransact())
+}
@@ -0,0 +1,176 @@
+package photon
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/encoding"
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	//sendCompletionCycle 所有 worker 轮流等待一遍队列中的消息的目标时间,也就是已经有结果的消息最多推迟多久通知主线程
+	sendCompletionCycle = time.Second
+	//worker 每次等待一条消息的时间范围,见 next
+	minSendCompletionSlice = 10 * time.Millisecond
+	maxSendCompletionSlice = time.Second
+)
+
+/*
+pendingSend 一条已经交给 PhotonProtocol,还没有结果的消息
+*/
+type pendingSend struct {
+	recipient common.Address
+	msg       encoding.SignedMessager
+	logCtx    []interface{}
+}
+
+/*
+sendCompletionPool 等待消息发送结果的 goroutine 池,通过 ProtocolMessageSendComplete 通知主线程.
+每条消息都可能要重发很久,每条启动一个 goroutine 的话,突发大量消息时 goroutine 的数量没有上限.
+最多同时运行 size 个 worker,还没有结果的消息在队列中排队.
+发给离线节点的消息可能永远没有结果,所以 worker 每次只等待一条消息一小段时间,
+没有结果就放回队尾,这些消息不会一直占住 worker,推迟其他消息的完成通知.
+size<=0 表示不限制,每条消息一个 goroutine.
+add 不会阻塞,主线程调用时不会和 worker 互相等待
+*/
+type sendCompletionPool struct {
+	size    int
+	out     chan<- *protocolMessage
+	quit    <-chan struct{}
+	lock    sync.Mutex
+	running int
+	queue   []*utils.AsyncResult
+	sends   map[*utils.AsyncResult][]*pendingSend
+}
+
+func newSendCompletionPool(size int, out chan<- *protocolMessage, quit <-chan struct{}) *sendCompletionPool {
+	return &sendCompletionPool{
+		size:  size,
+		out:   out,
+		quit:  quit,
+		sends: make(map[*utils.AsyncResult][]*pendingSend),
+	}
+}
+
+/*
+add 等待 result,不会阻塞.
+同样的消息发送两次时 PhotonProtocol 返回同一个 result,两次都会通知主线程
+*/
+func (p *sendCompletionPool) add(result *utils.AsyncResult, ps *pendingSend) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	_, waiting := p.sends[result]
+	p.sends[result] = append(p.sends[result], ps)
+	if waiting {
+		return
+	}
+	if p.size <= 0 {
+		p.running++
+		go p.wait(result)
+		return
+	}
+	p.queue = append(p.queue, result)
+	if p.running < p.size {
+		p.running++
+		go p.work()
+	}
+}
+
+//wait 一直等到 result 有结果,只用于不限制 worker 数量的情况
+func (p *sendCompletionPool) wait(result *utils.AsyncResult) {
+	defer p.exit()
+	select {
+	case err := <-result.Result:
+		p.complete(result, err)
+	case <-p.quit:
+	}
+}
+
+func (p *sendCompletionPool) work() {
+	for {
+		result, slice := p.next()
+		if result == nil {
+			return
+		}
+		select {
+		case err := <-result.Result:
+			p.complete(result, err)
+		case <-time.After(slice):
+			//还没有结果,放回队尾
+			p.lock.Lock()
+			p.queue = append(p.queue, result)
+			p.lock.Unlock()
+		case <-p.quit:
+			p.exit()
+			return
+		}
+	}
+}
+
+/*
+next 队列中的第一条消息以及等待它的时间,队列为空时返回 nil,这个 worker 退出.
+在同一个锁里减少 running,add 看到 running 没有减少时,这个 worker 一定还会处理新加入的消息.
+等待的时间按照队列长度计算,尽量在 sendCompletionCycle 内轮流等待一遍
+*/
+func (p *sendCompletionPool) next() (result *utils.AsyncResult, slice time.Duration) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if len(p.queue) == 0 {
+		p.running--
+		return nil, 0
+	}
+	slice = sendCompletionCycle * time.Duration(p.running) / time.Duration(len(p.queue))
+	if slice < minSendCompletionSlice {
+		slice = minSendCompletionSlice
+	} else if slice > maxSendCompletionSlice {
+		slice = maxSendCompletionSlice
+	}
+	result = p.queue[0]
+	p.queue[0] = nil
+	p.queue = p.queue[1:]
+	return
+}
+
+func (p *sendCompletionPool) exit() {
+	p.lock.Lock()
+	p.running--
+	p.lock.Unlock()
+}
+
+//complete 通知主线程等待 result 的所有消息已经有结果
+func (p *sendCompletionPool) complete(result *utils.AsyncResult, err error) {
+	p.lock.Lock()
+	ps := p.sends[result]
+	delete(p.sends, result)
+	p.lock.Unlock()
+	for _, s := range ps {
+		if err != nil {
+			//如果通道已经settle,那么这个消息是没必要再发送了.这时候会失败
+			log.Error(fmt.Sprintf("message %s send finished ,but err=%s", utils.StringInterface(s.msg, 3), err), s.logCtx...)
+		}
+		//主线程已经退出的话没人会处理,不能永远阻塞
+		select {
+		case p.out <- &protocolMessage{
+			receiver: s.recipient,
+			Message:  s.msg,
+			err:      err,
+		}:
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+//stats 正在运行的 worker 以及正在等待结果的消息数
+func (p *sendCompletionPool) stats() (running, pending int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, ps := range p.sends {
+		pending += len(ps)
+	}
+	return p.running, pending
+}
@@ -0,0 +1,151 @@
+package photon
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/encoding"
+	"github.com/SmartMeshFoundation/Photon/network"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendCompletionPool(t *testing.T) {
+	const size = 3
+	out := make(chan *protocolMessage)
+	quit := make(chan struct{})
+	defer close(quit)
+	p := newSendCompletionPool(size, out, quit)
+	before := runtime.NumGoroutine()
+	//大量消息永远没有结果
+	for i := 0; i < 300; i++ {
+		p.add(utils.NewAsyncResult(), &pendingSend{recipient: utils.NewRandomAddress(), msg: encoding.NewPing(int64(i))})
+	}
+	running, pending := p.stats()
+	assert.Equal(t, size, running)
+	assert.Equal(t, 300, pending)
+	assert.True(t, runtime.NumGoroutine()-before <= size, "%d goroutines started", runtime.NumGoroutine()-before)
+
+	//同一个 result 对应两条消息,两条都要通知,排在没有结果的消息后面也不能一直等
+	ok, failed := utils.NewAsyncResult(), utils.NewAsyncResult()
+	ping1, ping2, ping3 := encoding.NewPing(10001), encoding.NewPing(10002), encoding.NewPing(10003)
+	p.add(ok, &pendingSend{msg: ping1})
+	p.add(ok, &pendingSend{msg: ping2})
+	p.add(failed, &pendingSend{msg: ping3})
+	_, pending = p.stats()
+	assert.Equal(t, 303, pending)
+	failed.Result <- errors.New("channel settled")
+	ok.Result <- nil
+	got := make(map[encoding.Messager]error)
+	for i := 0; i < 3; i++ {
+		select {
+		case m := <-out:
+			got[m.Message] = m.err
+		case <-time.After(10 * time.Second):
+			t.Fatal("send completion not delivered")
+		}
+	}
+	assert.Len(t, got, 3)
+	assert.Nil(t, got[ping1])
+	assert.Nil(t, got[ping2])
+	assert.Error(t, got[ping3])
+	running, pending = p.stats()
+	assert.True(t, running <= size)
+	assert.Equal(t, 300, pending)
+	assert.True(t, runtime.NumGoroutine()-before <= size, "%d goroutines started", runtime.NumGoroutine()-before)
+}
+
+func TestSendCompletionPoolQuit(t *testing.T) {
+	out := make(chan *protocolMessage)
+	quit := make(chan struct{})
+	before := runtime.NumGoroutine()
+	p := newSendCompletionPool(2, out, quit)
+	r := utils.NewAsyncResult()
+	p.add(r, &pendingSend{msg: encoding.NewPing(1)})
+	p.add(utils.NewAsyncResult(), &pendingSend{msg: encoding.NewPing(2)})
+	r.Result <- nil
+	//没人接收完成通知,退出时也不能阻塞
+	close(quit)
+	for i := 0; runtime.NumGoroutine() > before; i++ {
+		if i > 1000 {
+			t.Fatal("send completion pool not quit")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSendAsyncBounded(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	tr := &presenceTransport{sent: make(map[common.Address]int)}
+	rs := &Service{
+		NodeAddress:                 crypto.PubkeyToAddress(key.PublicKey),
+		Protocol:                    network.NewPhotonProtocol(tr, key, nil),
+		ProtocolMessageSendComplete: make(chan *protocolMessage, 100),
+		quitChan:                    make(chan struct{}),
+	}
+	defer close(rs.quitChan)
+	rs.sendCompletions = newSendCompletionPool(2, rs.ProtocolMessageSendComplete, rs.quitChan)
+	//对方不回复 ack,每条消息重发两次以后放弃
+	policy := network.SendPolicy{RetryInterval: 10 * time.Millisecond, MaxAttempts: 2}
+	for i := 0; i < 50; i++ {
+		ping := encoding.NewPing(int64(i))
+		assert.Nil(t, ping.Sign(key, ping))
+		assert.Nil(t, rs.sendAsyncWithPolicy(utils.NewRandomAddress(), ping, policy))
+		running, _ := rs.sendCompletions.stats()
+		assert.True(t, running <= 2)
+	}
+	for i := 0; i < 50; i++ {
+		select {
+		case m := <-rs.ProtocolMessageSendComplete:
+			assert.Error(t, m.err)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("only %d send completions", i)
+		}
+	}
+	running, pending := rs.sendCompletions.stats()
+	assert.Equal(t, 0, pending)
+	assert.True(t, running <= 2)
+}
+
+//对方永远不回复 ack,默认策略会一直重发,其他消息的完成通知不能被推迟
+func TestSendAsyncToOfflinePartners(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	tr := &presenceTransport{sent: make(map[common.Address]int)}
+	rs := &Service{
+		NodeAddress:                 crypto.PubkeyToAddress(key.PublicKey),
+		Protocol:                    network.NewPhotonProtocol(tr, key, nil),
+		ProtocolMessageSendComplete: make(chan *protocolMessage, 10),
+		quitChan:                    make(chan struct{}),
+	}
+	rs.sendCompletions = newSendCompletionPool(4, rs.ProtocolMessageSendComplete, rs.quitChan)
+	defer rs.Protocol.StopAndWait()
+	defer close(rs.quitChan)
+	var partners []common.Address
+	for i := 0; i < 5; i++ {
+		partners = append(partners, utils.NewRandomAddress())
+	}
+	for i := 0; i < 400; i++ {
+		ping := encoding.NewPing(int64(i))
+		assert.Nil(t, ping.Sign(key, ping))
+		assert.Nil(t, rs.sendAsyncWithPolicy(partners[i%len(partners)], ping, network.SendPolicy{}))
+	}
+	running, pending := rs.sendCompletions.stats()
+	assert.Equal(t, 400, pending)
+	assert.True(t, running <= 4)
+
+	//另一个节点回复了 ack
+	r := utils.NewAsyncResult()
+	ping := encoding.NewPing(1000)
+	rs.sendCompletions.add(r, &pendingSend{recipient: utils.NewRandomAddress(), msg: ping})
+	r.Result <- nil
+	select {
+	case m := <-rs.ProtocolMessageSendComplete:
+		assert.True(t, m.Message == ping)
+	case <-time.After(10 * time.Second):
+		t.Fatal("send completion blocked by offline partners")
+	}
+}
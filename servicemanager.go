@@ -0,0 +1,138 @@
+package photon
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/SmartMeshFoundation/Photon/blockchain"
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/network"
+	"github.com/SmartMeshFoundation/Photon/network/helper"
+	"github.com/SmartMeshFoundation/Photon/network/rpc"
+	"github.com/SmartMeshFoundation/Photon/notify"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+/*
+ServiceManager 在一个进程中运行多个账户的 Service,所有账户共享同一个公链连接,
+每个轮询周期对最新块和合约事件的查询也只做一次,适合交易所这种一个进程管理很多账户的部署.
+每个账户仍然使用自己的私钥,数据目录和 transport,数据目录的文件锁照旧,同一个目录只能被一个 Service 使用.
+可以在任意线程中调用
+*/
+type ServiceManager struct {
+	client       *helper.SafeEthClient
+	headerPoller *blockchain.SharedHeaderPoller
+	lock         sync.RWMutex
+	services     map[common.Address]*Service
+}
+
+//NewServiceManager 所有账户都会使用 client 访问公链,client 在 Stop 时关闭
+func NewServiceManager(client *helper.SafeEthClient) *ServiceManager {
+	return &ServiceManager{
+		client:       client,
+		headerPoller: blockchain.NewSharedHeaderPoller(client),
+		services:     make(map[common.Address]*Service),
+	}
+}
+
+/*
+NewBlockChainService 使用共享的公链连接为一个账户创建 BlockChainService,
+需要 BlockChainService 的 transport 可以在 AddService 之前用它创建
+*/
+func (m *ServiceManager) NewBlockChainService(privateKey *ecdsa.PrivateKey, registryAddress common.Address, notifyHandler *notify.Handler, dao models.Dao) (*rpc.BlockChainService, error) {
+	return rpc.NewBlockChainService(privateKey, registryAddress, m.client, notifyHandler, dao)
+}
+
+/*
+AddService 创建一个账户的 Service 并交给 manager 管理,参数和 NewPhotonService 一致,
+chain 必须是 NewBlockChainService 创建的.
+返回的 Service 还没有启动,调用者负责 Start
+*/
+func (m *ServiceManager) AddService(chain *rpc.BlockChainService, privateKey *ecdsa.PrivateKey, transport network.Transporter, config *params.Config, notifyHandler *notify.Handler, dao models.Dao) (rs *Service, err error) {
+	if chain.Client != m.client {
+		return nil, rerr.ErrArgumentError.Append("BlockChainService must use the eth client of ServiceManager")
+	}
+	address := crypto.PubkeyToAddress(privateKey.PublicKey)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.services[address]; ok {
+		return nil, rerr.ErrPhotonAlreadyRunning.Errorf("account %s already added", address.String())
+	}
+	rs, err = NewPhotonService(chain, privateKey, transport, config, notifyHandler, dao)
+	if err != nil {
+		return
+	}
+	rs.ethClientShared = true
+	rs.ethStatusChan = m.client.SubscribeStatus()
+	rs.BlockChainEvents.SetSharedHeaderPoller(m.headerPoller)
+	m.services[address] = rs
+	log.Info(fmt.Sprintf("service manager add account %s,data dir %s", address.String(), config.DataBasePath))
+	return
+}
+
+//Service 账户对应的 Service
+func (m *ServiceManager) Service(address common.Address) (*Service, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	rs, ok := m.services[address]
+	if !ok {
+		return nil, rerr.ErrNotFound.Errorf("account %s not found", address.String())
+	}
+	return rs, nil
+}
+
+//API 把请求交给账户对应的 API
+func (m *ServiceManager) API(address common.Address) (*API, error) {
+	rs, err := m.Service(address)
+	if err != nil {
+		return nil, err
+	}
+	return NewPhotonAPI(rs), nil
+}
+
+//Accounts 所有账户,按地址排序
+func (m *ServiceManager) Accounts() []common.Address {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	accounts := make([]common.Address, 0, len(m.services))
+	for addr := range m.services {
+		accounts = append(accounts, addr)
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].Hex() < accounts[j].Hex()
+	})
+	return accounts
+}
+
+/*
+RemoveService 停止一个账户并释放它的数据目录,取消它对连接状态的订阅,共享的公链连接不受影响
+*/
+func (m *ServiceManager) RemoveService(address common.Address) error {
+	m.lock.Lock()
+	rs, ok := m.services[address]
+	delete(m.services, address)
+	m.lock.Unlock()
+	if !ok {
+		return rerr.ErrNotFound.Errorf("account %s not found", address.String())
+	}
+	m.client.UnsubscribeStatus(rs.ethStatusChan)
+	rs.Stop()
+	return nil
+}
+
+//Stop 停止所有账户,然后关闭共享的公链连接
+func (m *ServiceManager) Stop() {
+	for _, addr := range m.Accounts() {
+		err := m.RemoveService(addr)
+		if err != nil {
+			log.Error(fmt.Sprintf("stop %s err %s", addr.String(), err))
+		}
+	}
+	m.client.Close()
+}
@@ -0,0 +1,89 @@
+package photon
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/network/helper"
+	"github.com/SmartMeshFoundation/Photon/network/netshare"
+	"github.com/SmartMeshFoundation/Photon/network/rpc"
+	"github.com/SmartMeshFoundation/Photon/notify"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceManager(t *testing.T) {
+	client := &helper.SafeEthClient{Status: netshare.Connected}
+	m := NewServiceManager(client)
+	//共享连接的账户订阅时就能知道已经连上
+	assert.Equal(t, netshare.Connected, <-client.SubscribeStatus())
+	addr1, addr2 := utils.NewRandomAddress(), utils.NewRandomAddress()
+	m.services[addr1] = &Service{NodeAddress: addr1}
+	m.services[addr2] = &Service{NodeAddress: addr2}
+
+	accounts := m.Accounts()
+	assert.Len(t, accounts, 2)
+	assert.True(t, accounts[0].Hex() < accounts[1].Hex())
+
+	api, err := m.API(addr2)
+	assert.Nil(t, err)
+	assert.Equal(t, addr2, api.Address())
+	_, err = m.API(utils.NewRandomAddress())
+	assert.NotNil(t, err)
+	assert.NotNil(t, m.RemoveService(utils.NewRandomAddress()))
+
+	//每个账户都必须使用 manager 的公链连接
+	key, _ := crypto.GenerateKey()
+	other := &rpc.BlockChainService{Client: &helper.SafeEthClient{}}
+	_, err = m.AddService(other, key, nil, &params.Config{}, nil, nil)
+	assert.NotNil(t, err)
+	assert.Equal(t, []common.Address{accounts[0], accounts[1]}, m.Accounts())
+}
+
+func TestServiceManagerAddRemove(t *testing.T) {
+	client := &helper.SafeEthClient{Status: netshare.Connected}
+	m := NewServiceManager(client)
+	key, _ := crypto.GenerateKey()
+	addr := crypto.PubkeyToAddress(key.PublicKey)
+	chain := &rpc.BlockChainService{Client: client}
+	dir, err := ioutil.TempDir("", "servicemanager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config := &params.Config{DataBasePath: filepath.Join(dir, "log.db")}
+	transport := &presenceTransport{sent: make(map[common.Address]int)}
+
+	rs, err := m.AddService(chain, key, transport, config, notify.NewNotifyHandler(), codefortest.NewTestDB(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []common.Address{addr}, m.Accounts())
+	got, err := m.Service(addr)
+	assert.Nil(t, err)
+	assert.Equal(t, rs, got)
+	assert.True(t, rs.ethClientShared)
+	assert.Equal(t, 1, client.StatusSubscribers())
+	assert.Equal(t, netshare.Connected, <-rs.ethStatusChan)
+	//同一个账户不能添加两次
+	_, err = m.AddService(chain, key, transport, config, notify.NewNotifyHandler(), nil)
+	assert.NotNil(t, err)
+
+	assert.Nil(t, m.RemoveService(addr))
+	assert.Empty(t, m.Accounts())
+	//不再收到连接状态,共享的连接也没有关闭
+	assert.Equal(t, 0, client.StatusSubscribers())
+	assert.Equal(t, netshare.Connected, client.Status)
+	assert.NotNil(t, m.RemoveService(addr))
+
+	//数据目录已经释放,可以再次添加
+	_, err = m.AddService(chain, key, transport, config, notify.NewNotifyHandler(), codefortest.NewTestDB(""))
+	assert.Nil(t, err)
+	assert.Nil(t, m.RemoveService(addr))
+}
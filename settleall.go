@@ -0,0 +1,51 @@
+package photon
+
+import (
+	"fmt"
+
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+SettleAllReady 一次性 settle 所有已经到期的关闭通道,适合长时间离线以后回收资金.
+返回每个关闭通道的结果,还没有到期的通道结果中是说明原因的错误.
+不能在主线程中调用.
+*/
+func (rs *Service) SettleAllReady() (results map[common.Hash]*utils.AsyncResult, err error) {
+	result := rs.settleAllReadyClient()
+	err = <-result.Result
+	if err != nil {
+		return
+	}
+	results = result.Tag.(map[common.Hash]*utils.AsyncResult)
+	return
+}
+
+/*
+settleAllReady 只能在主线程中调用
+*/
+func (rs *Service) settleAllReady() (result *utils.AsyncResult) {
+	result = utils.NewAsyncResult()
+	results := make(map[common.Hash]*utils.AsyncResult)
+	blockNumber := rs.GetBlockNumber()
+	for _, g := range rs.Token2ChannelGraph {
+		for _, c := range g.ChannelIdentifier2Channel {
+			if c.State != channeltype.StateClosed {
+				continue
+			}
+			channelIdentifier := c.ChannelIdentifier.ChannelIdentifier
+			if err := checkSettleTimeout(c, blockNumber); err != nil {
+				results[channelIdentifier] = utils.NewAsyncResultWithError(err)
+				continue
+			}
+			results[channelIdentifier] = rs.closeOrSettleChannel(channelIdentifier, settleChannelReqName)
+		}
+	}
+	log.Info(fmt.Sprintf("settle all ready channels at %d,closed channels=%d", blockNumber, len(results)))
+	result.Tag = results
+	result.Result <- nil
+	return
+}
@@ -0,0 +1,91 @@
+package photon
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
+	"github.com/SmartMeshFoundation/Photon/network/graph"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSettleAllReadySkipNotReady(t *testing.T) {
+	newChannel := func(state channeltype.State, closedBlock int64) *channel.Channel {
+		return &channel.Channel{
+			ChannelIdentifier: contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash()},
+			ExternState:       &channel.ExternalState{ClosedBlock: closedBlock},
+			SettleTimeout:     100,
+			State:             state,
+		}
+	}
+	opened := newChannel(channeltype.StateOpened, 0)
+	notReady := newChannel(channeltype.StateClosed, 50)
+	g := &graph.ChannelGraph{
+		ChannelIdentifier2Channel: map[common.Hash]*channel.Channel{
+			opened.ChannelIdentifier.ChannelIdentifier:   opened,
+			notReady.ChannelIdentifier.ChannelIdentifier: notReady,
+		},
+	}
+	rs := &Service{
+		BlockNumber:        new(atomic.Value),
+		Token2ChannelGraph: map[common.Address]*graph.ChannelGraph{utils.NewRandomAddress(): g},
+	}
+	defer func(n int64) { params.PunishBlockNumber = n }(params.PunishBlockNumber)
+	params.PunishBlockNumber = 257
+	//已经过了 settle timeout,但是还在留给 punish 的块内,提交的 tx 会失败
+	for _, blockNumber := range []int64{150, 407} {
+		rs.BlockNumber.Store(blockNumber)
+		result := rs.settleAllReady()
+		assert.Nil(t, <-result.Result)
+		results := result.Tag.(map[common.Hash]*utils.AsyncResult)
+		//打开的通道不需要 settle
+		assert.Len(t, results, 1)
+		err := <-results[notReady.ChannelIdentifier.ChannelIdentifier].Result
+		if assert.NotNil(t, err) {
+			assert.Equal(t, rerr.ErrChannelSettleTimeout.ErrorCode, err.(rerr.StandardError).ErrorCode)
+		}
+		assert.EqualValues(t, channeltype.StateClosed, notReady.State)
+	}
+}
+
+func TestSettleBeforeTimeout(t *testing.T) {
+	c := &channel.Channel{
+		ChannelIdentifier: contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash()},
+		ExternState:       &channel.ExternalState{ClosedBlock: 50},
+		SettleTimeout:     100,
+		State:             channeltype.StateClosed,
+	}
+	g := &graph.ChannelGraph{
+		ChannelIdentifier2Channel: map[common.Hash]*channel.Channel{c.ChannelIdentifier.ChannelIdentifier: c},
+	}
+	rs := &Service{
+		BlockNumber:        new(atomic.Value),
+		Token2ChannelGraph: map[common.Address]*graph.ChannelGraph{utils.NewRandomAddress(): g},
+	}
+	defer func(n int64) { params.PunishBlockNumber = n }(params.PunishBlockNumber)
+	params.PunishBlockNumber = 257
+	//50+100+257 以后才能 settle
+	rs.BlockNumber.Store(int64(407))
+	//还在留给 punish 的块内,不会提交 tx
+	err := <-rs.closeOrSettleChannel(c.ChannelIdentifier.ChannelIdentifier, settleChannelReqName).Result
+	if assert.NotNil(t, err) {
+		assert.Equal(t, rerr.ErrChannelSettleTimeout.ErrorCode, err.(rerr.StandardError).ErrorCode)
+		assert.Contains(t, err.Error(), "1 blocks remain")
+	}
+	assert.EqualValues(t, channeltype.StateClosed, c.State)
+
+	assert.NotNil(t, checkSettleTimeout(c, 149))
+	assert.NotNil(t, checkSettleTimeout(c, 150))
+	assert.NotNil(t, checkSettleTimeout(c, 407))
+	assert.Nil(t, checkSettleTimeout(c, 408))
+	assert.Nil(t, checkSettleTimeout(c, 500))
+	//没有关闭的通道由 Settle 检查状态
+	c.State = channeltype.StateOpened
+	assert.Nil(t, checkSettleTimeout(c, 0))
+}
@@ -0,0 +1,55 @@
+package photon
+
+import (
+	"math/big"
+
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+type cooperativeSettlePreview struct {
+	ourBalance     *big.Int
+	partnerBalance *big.Int
+}
+
+/*
+PreviewCooperativeSettle 如果现在 cooperative settle,我和对方各自能拿到多少 token.
+和 CooperativeSettle 使用同样的计算,但是既不发送 SettleRequest 也不修改通道状态,
+方便用户在确认前看到结果.
+可以在任意线程中调用
+*/
+func (rs *Service) PreviewCooperativeSettle(channelIdentifier common.Hash) (ourBalance, partnerBalance *big.Int, err error) {
+	result := rs.previewCooperativeSettleClient(channelIdentifier)
+	err = <-result.Result
+	if err != nil {
+		return
+	}
+	p := result.Tag.(*cooperativeSettlePreview)
+	return p.ourBalance, p.partnerBalance, nil
+}
+
+/*
+previewCooperativeSettle 只能在主线程中调用
+*/
+func (rs *Service) previewCooperativeSettle(channelIdentifier common.Hash) (result *utils.AsyncResult) {
+	c, err := rs.findChannelByIdentifier(channelIdentifier)
+	if err != nil {
+		return utils.NewAsyncResultWithError(rerr.ErrChannelNotFound)
+	}
+	if c.State != channeltype.StateOpened && c.State != channeltype.StatePrepareForCooperativeSettle {
+		return utils.NewAsyncResultWithError(rerr.ChannelStateError(c.State))
+	}
+	ourBalance, partnerBalance, err := c.CooperativeSettleBalances()
+	if err != nil {
+		return utils.NewAsyncResultWithError(err)
+	}
+	result = utils.NewAsyncResult()
+	result.Tag = &cooperativeSettlePreview{
+		ourBalance:     ourBalance,
+		partnerBalance: partnerBalance,
+	}
+	result.Result <- nil
+	return
+}
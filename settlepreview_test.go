@@ -0,0 +1,58 @@
+package photon
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
+	"github.com/SmartMeshFoundation/Photon/network/graph"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreviewCooperativeSettle(t *testing.T) {
+	our, partner := utils.NewRandomAddress(), utils.NewRandomAddress()
+	token := utils.NewRandomAddress()
+	ourState := channel.NewChannelEndState(our, big.NewInt(100), nil, mtree.EmptyTree)
+	partnerState := channel.NewChannelEndState(partner, big.NewInt(100), nil, mtree.EmptyTree)
+	ch, err := channel.NewChannel(ourState, partnerState, &channel.ExternalState{}, token,
+		&contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash()}, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewChannelGraph(our, token, nil)
+	assert.Nil(t, g.AddChannel(ch))
+	rs := &Service{
+		NodeAddress:        our,
+		Config:             &params.Config{},
+		Token2ChannelGraph: map[common.Address]*graph.ChannelGraph{token: g},
+	}
+	ch.PartnerState.BalanceProofState.TransferAmount = big.NewInt(30)
+	ch.OurState.BalanceProofState.TransferAmount = big.NewInt(10)
+
+	result := rs.previewCooperativeSettle(utils.NewRandomHash())
+	assert.NotNil(t, <-result.Result)
+
+	result = rs.previewCooperativeSettle(ch.ChannelIdentifier.ChannelIdentifier)
+	assert.Nil(t, <-result.Result)
+	p := result.Tag.(*cooperativeSettlePreview)
+	assert.EqualValues(t, 120, p.ourBalance.Int64())
+	assert.EqualValues(t, 80, p.partnerBalance.Int64())
+	//预览不会修改通道
+	assert.EqualValues(t, channeltype.StateOpened, ch.State)
+
+	lockSecretHash := utils.NewRandomHash()
+	ch.PartnerState.Lock2UnclaimedLocks[lockSecretHash] = channeltype.UnlockPartialProof{}
+	result = rs.previewCooperativeSettle(ch.ChannelIdentifier.ChannelIdentifier)
+	assert.NotNil(t, <-result.Result, "channel holding locks")
+	delete(ch.PartnerState.Lock2UnclaimedLocks, lockSecretHash)
+
+	ch.State = channeltype.StateClosed
+	result = rs.previewCooperativeSettle(ch.ChannelIdentifier.ChannelIdentifier)
+	assert.NotNil(t, <-result.Result)
+}
@@ -0,0 +1,20 @@
+package photon
+
+import (
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+SignMessage 用节点的私钥对 data 签名,用于向外部服务证明节点身份.
+签名的数据带有 utils.AuthSignaturePrefix 前缀,不能被当作协议消息或者 balance proof 使用,
+可以在任意线程中调用
+*/
+func (rs *Service) SignMessage(data []byte) ([]byte, error) {
+	return utils.SignAuthMessage(rs.Signer, data)
+}
+
+//VerifyMessage 返回使用 Service.SignMessage 对 data 签名的节点地址
+func VerifyMessage(data, signature []byte) (signer common.Address, err error) {
+	return utils.VerifyAuthMessage(data, signature)
+}
@@ -0,0 +1,138 @@
+package photon
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/encoding"
+	"github.com/SmartMeshFoundation/Photon/network"
+	"github.com/SmartMeshFoundation/Photon/network/graph"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/notify"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignMessage(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := &Service{Signer: utils.NewPrivateKeySigner(key)}
+	data := []byte("auth challenge")
+	sig, err := rs.SignMessage(data)
+	assert.Nil(t, err)
+	addr, err := VerifyMessage(data, sig)
+	assert.Nil(t, err)
+	assert.Equal(t, crypto.PubkeyToAddress(key.PublicKey), addr)
+}
+
+//failingSigner 模拟外部签名服务不可用
+type failingSigner struct {
+	addr common.Address
+}
+
+func (s *failingSigner) SignMessage(data []byte) ([]byte, error) {
+	return nil, errors.New("signer unavailable")
+}
+func (s *failingSigner) Address() common.Address {
+	return s.addr
+}
+
+//签名失败时不能修改通道状态,也不能发出没有签名的消息
+func TestSignFailure(t *testing.T) {
+	key, our := utils.MakePrivateKeyAddress()
+	partner, token := utils.NewRandomAddress(), utils.NewRandomAddress()
+	c, err := channel.NewChannel(channel.NewChannelEndState(our, big.NewInt(100), nil, mtree.EmptyTree),
+		channel.NewChannelEndState(partner, big.NewInt(50), nil, mtree.EmptyTree), &channel.ExternalState{}, token,
+		&contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash(), OpenBlockNumber: 3}, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewChannelGraph(our, token, nil)
+	assert.Nil(t, g.AddChannel(c))
+	tr := &presenceTransport{sent: make(map[common.Address]int)}
+	rs := &Service{
+		NodeAddress:        our,
+		Signer:             &failingSigner{addr: our},
+		NotifyHandler:      notify.NewNotifyHandler(),
+		Config:             &params.Config{},
+		IsChainEffective:   true,
+		Protocol:           network.NewPhotonProtocol(tr, key, nil),
+		Token2ChannelGraph: map[common.Address]*graph.ChannelGraph{token: g},
+		dao:                codefortest.NewTestDB(""),
+	}
+	defer rs.dao.CloseDB()
+	assert.Nil(t, rs.dao.NewChannel(channel.NewChannelSerialization(c)))
+
+	err = <-rs.directTransferAsync(token, partner, big.NewInt(10), "").Result
+	assert.EqualError(t, err, "signer unavailable")
+	assert.EqualValues(t, 0, c.OurState.BalanceProofState.Nonce)
+	assert.EqualValues(t, 100, c.Balance().Int64())
+
+	err = <-rs.cooperativeSettleChannel(c.ChannelIdentifier.ChannelIdentifier).Result
+	assert.EqualError(t, err, "signer unavailable")
+	assert.EqualValues(t, channeltype.StateOpened, c.State)
+	err = <-rs.withdraw(c.ChannelIdentifier.ChannelIdentifier, big.NewInt(10)).Result
+	assert.EqualError(t, err, "signer unavailable")
+	assert.EqualValues(t, channeltype.StateOpened, c.State)
+	tr.lock.Lock()
+	assert.Equal(t, 0, tr.sent[partner])
+	tr.lock.Unlock()
+}
+
+//签名失败时回复对方的合作关闭和取现请求以及链上操作都返回错误,而不是让节点崩溃
+func TestSignFailureOnResponse(t *testing.T) {
+	our, partner, token := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	newChannels := func() (c, pc *channel.Channel, graphs map[common.Address]*graph.ChannelGraph) {
+		c, err := channel.NewChannel(channel.NewChannelEndState(our, big.NewInt(100), nil, mtree.EmptyTree),
+			channel.NewChannelEndState(partner, big.NewInt(50), nil, mtree.EmptyTree), &channel.ExternalState{}, token,
+			&contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash(), OpenBlockNumber: 3}, 5, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g := graph.NewChannelGraph(our, token, nil)
+		assert.Nil(t, g.AddChannel(c))
+		pc, err = channel.NewChannel(channel.NewChannelEndState(partner, big.NewInt(50), nil, mtree.EmptyTree),
+			channel.NewChannelEndState(our, big.NewInt(100), nil, mtree.EmptyTree), &channel.ExternalState{}, token,
+			&contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash(), OpenBlockNumber: 3}, 5, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pc.ChannelIdentifier = c.ChannelIdentifier
+		return c, pc, map[common.Address]*graph.ChannelGraph{token: g}
+	}
+	c, pc, graphs := newChannels()
+	rs := &Service{
+		NodeAddress:        our,
+		Signer:             &failingSigner{addr: our},
+		NotifyHandler:      notify.NewNotifyHandler(),
+		Config:             &params.Config{},
+		Token2ChannelGraph: graphs,
+	}
+	mh := newPhotonMessageHandler(rs)
+	settleRequest, err := pc.CreateCooperativeSettleRequest()
+	assert.Nil(t, err)
+	settleRequest.Sender = partner
+	assert.EqualError(t, mh.messageSettleRequest(settleRequest), "signer unavailable")
+
+	c, pc, rs.Token2ChannelGraph = newChannels()
+	withdrawRequest, err := pc.CreateWithdrawRequest(big.NewInt(10))
+	assert.Nil(t, err)
+	withdrawRequest.Sender = partner
+	assert.EqualError(t, mh.messageWithdrawRequest(withdrawRequest), "signer unavailable")
+
+	c.ExternState.SetSigner(&failingSigner{addr: our})
+	assert.EqualError(t, <-c.CooperativeSettleChannel(&encoding.SettleResponse{}).Result, "signer unavailable")
+	withdrawResponse := &encoding.WithdrawResponse{}
+	withdrawResponse.Participant1Withdraw = big.NewInt(10)
+	assert.EqualError(t, <-c.Withdraw(withdrawResponse).Result, "signer unavailable")
+}
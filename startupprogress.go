@@ -0,0 +1,105 @@
+package photon
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//startupChannelBatchSize 启动时每次从数据库读取的通道数量,避免通道很多的节点一次性把所有通道读到内存中
+const startupChannelBatchSize = 1000
+
+//StartupProgress 启动进度,Ready 之前不会接收任何交易
+type StartupProgress struct {
+	TotalTokens    int  `json:"total_tokens"`
+	LoadedTokens   int  `json:"loaded_tokens"`
+	SkippedTokens  int  `json:"skipped_tokens"` //创建不了 TokenNetworkProxy 而被跳过的 token
+	LoadedChannels int  `json:"loaded_channels"`
+	Ready          bool `json:"ready"` //通道加载完毕,并且历史事件处理完毕
+}
+
+//GetStartupProgress 可以在任意线程中调用
+func (rs *Service) GetStartupProgress() StartupProgress {
+	rs.startupProgressLock.Lock()
+	defer rs.startupProgressLock.Unlock()
+	return rs.startupProgress
+}
+
+func (rs *Service) updateStartupProgress(fn func(p *StartupProgress)) {
+	rs.startupProgressLock.Lock()
+	defer rs.startupProgressLock.Unlock()
+	fn(&rs.startupProgress)
+}
+
+/*
+waitHistoryEvents 等待主循环处理完积压的链上事件,返回是否处理完毕.
+Config.MaxStartupWait 不为0时最多等待这么久,超时以后继续启动,剩下的事件由主循环照常处理,
+但是仍然要等它们处理完才接收消息,见 startReceiving
+*/
+func (rs *Service) waitHistoryEvents(complete <-chan struct{}) bool {
+	if rs.Config.MaxStartupWait <= 0 {
+		<-complete
+		return true
+	}
+	select {
+	case <-complete:
+		return true
+	case <-time.After(rs.Config.MaxStartupWait):
+		log.Warn(fmt.Sprintf("history events are not processed after %s,continue to start up,remaining events will be processed later", rs.Config.MaxStartupWait))
+		return false
+	}
+}
+
+/*
+startReceiving 开始接收其他节点的消息.
+historyDone 为 false 表示 waitHistoryEvents 超时,Start 继续启动,但是接收消息要推迟到积压的链上事件处理完毕,
+否则消息会作用在还没有同步完的通道状态上. 推迟期间没有接收的消息对方会一直重发
+*/
+func (rs *Service) startReceiving(complete <-chan struct{}, historyDone bool, startReceive func()) {
+	if historyDone {
+		startReceive()
+		return
+	}
+	go func() {
+		select {
+		case <-complete:
+			log.Info("history events process complete,start receiving messages")
+			startReceive()
+		case <-rs.quitChan:
+		}
+	}()
+}
+
+/*
+loadTokenChannels 分批读取 token 下的通道,每批处理完以后释放,并输出进度.
+fn 返回错误则停止读取
+*/
+func (rs *Service) loadTokenChannels(tokenAddress common.Address, fn func(css []*channeltype.Serialization) error) error {
+	var after []byte
+	for {
+		css, err := rs.dao.GetChannelListByTokenPage(tokenAddress, after, startupChannelBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(css) > 0 {
+			after = css[len(css)-1].Key
+		}
+		err = fn(css)
+		if err != nil {
+			return err
+		}
+		rs.updateStartupProgress(func(p *StartupProgress) {
+			p.LoadedChannels += len(css)
+		})
+		if len(css) < startupChannelBatchSize {
+			return nil
+		}
+		p := rs.GetStartupProgress()
+		log.Info(fmt.Sprintf("loading channels of token %s,%d channels loaded,%d/%d tokens done",
+			utils.APex2(tokenAddress), p.LoadedChannels, p.LoadedTokens, p.TotalTokens))
+	}
+}
@@ -0,0 +1,124 @@
+package photon
+
+import (
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/blockchain"
+	"github.com/SmartMeshFoundation/Photon/network"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/transfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+//启动过程中主循环一直很忙,积压的事件一直处理不完,Start 也要在 MaxStartupWait 之后返回
+func TestWaitHistoryEventsUnderLoad(t *testing.T) {
+	rs := &Service{
+		Config:                                &params.Config{MaxStartupWait: 200 * time.Millisecond},
+		Protocol:                              &network.PhotonProtocol{},
+		BlockChainEvents:                      &blockchain.Events{StateChangeChannel: make(chan transfer.StateChange, 10)},
+		UserReqChan:                           make(chan *apiReq, 10),
+		ProtocolMessageSendComplete:           make(chan *protocolMessage, 10),
+		quitChan:                              make(chan struct{}),
+		ChanHistoryContractEventsDealComplete: make(chan struct{}),
+	}
+	historyEventsComplete := rs.ChanHistoryContractEventsDealComplete
+	loopDone := make(chan struct{})
+	go func() {
+		rs.loop()
+		close(loopDone)
+	}()
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					rs.getKnownSecretsClient()
+				}
+			}
+		}()
+	}
+
+	start := time.Now()
+	assert.False(t, rs.waitHistoryEvents(historyEventsComplete))
+	assert.True(t, time.Since(start) < 2*time.Second, "waited %s", time.Since(start))
+
+	//历史事件处理完以后立即返回
+	rs.BlockChainEvents.StateChangeChannel <- &mediatedtransfer.ContractHistoryEventCompleteStateChange{}
+	rs.Config.MaxStartupWait = time.Minute
+	assert.True(t, rs.waitHistoryEvents(historyEventsComplete))
+
+	close(stop)
+	wg.Wait()
+	close(rs.quitChan)
+	<-loopDone
+}
+
+//积压的链上事件一直处理不完,Start 超时返回,但是处理完之前不能接收消息
+func TestStartReceivingAfterHistoryEvents(t *testing.T) {
+	rs := &Service{
+		Config:                                &params.Config{MaxStartupWait: 200 * time.Millisecond},
+		Protocol:                              &network.PhotonProtocol{},
+		BlockChainEvents:                      &blockchain.Events{StateChangeChannel: make(chan transfer.StateChange, 10)},
+		UserReqChan:                           make(chan *apiReq, 10),
+		ProtocolMessageSendComplete:           make(chan *protocolMessage, 10),
+		quitChan:                              make(chan struct{}),
+		ChanHistoryContractEventsDealComplete: make(chan struct{}),
+	}
+	rs.StateMachineEventHandler = newStateMachineEventHandler(rs)
+	historyEventsComplete := rs.ChanHistoryContractEventsDealComplete
+	loopDone := make(chan struct{})
+	go func() {
+		rs.loop()
+		close(loopDone)
+	}()
+	stop := make(chan struct{})
+	backlogDone := make(chan struct{})
+	var processed int
+	go func() {
+		defer close(backlogDone)
+		for {
+			select {
+			case <-stop:
+				rs.BlockChainEvents.StateChangeChannel <- &mediatedtransfer.ContractHistoryEventCompleteStateChange{}
+				return
+			case rs.BlockChainEvents.StateChangeChannel <- &mediatedtransfer.ContractBalanceStateChange{
+				ChannelIdentifier: utils.NewRandomHash(),
+				Balance:           big.NewInt(1),
+				BlockNumber:       1,
+			}:
+				processed++
+			}
+		}
+	}()
+
+	assert.False(t, rs.waitHistoryEvents(historyEventsComplete))
+	received := make(chan struct{})
+	rs.startReceiving(historyEventsComplete, false, func() { close(received) })
+	select {
+	case <-received:
+		t.Fatal("start receiving before history events are processed")
+	case <-time.After(200 * time.Millisecond):
+	}
+	close(stop)
+	<-backlogDone
+	assert.True(t, processed > 0)
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("should start receiving after history events are processed")
+	}
+
+	close(rs.quitChan)
+	<-loopDone
+}
@@ -0,0 +1,228 @@
+package photon
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/transfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//LockSnapshot 通道中的一个锁,不包含密码
+type LockSnapshot struct {
+	LockSecretHash common.Hash `json:"lock_secret_hash"`
+	Amount         *big.Int    `json:"amount"`
+	Expiration     int64       `json:"expiration"`
+	Revealed       bool        `json:"revealed"` //在 Lock2UnclaimedLocks 中,密码已经披露
+}
+
+//EndStateSnapshot 通道一方的状态
+type EndStateSnapshot struct {
+	Address         common.Address  `json:"address"`
+	ContractBalance *big.Int        `json:"contract_balance"`
+	Balance         *big.Int        `json:"balance"`
+	TransferAmount  *big.Int        `json:"transfer_amount"`
+	Nonce           uint64          `json:"nonce"`
+	LocksRoot       common.Hash     `json:"locks_root"`
+	Locks           []*LockSnapshot `json:"locks"`
+}
+
+//ChannelSnapshot 内存中的一个通道
+type ChannelSnapshot struct {
+	ChannelIdentifier common.Hash       `json:"channel_identifier"`
+	OpenBlockNumber   int64             `json:"open_block_number"`
+	TokenAddress      common.Address    `json:"token_address"`
+	State             string            `json:"state"`
+	SettleTimeout     int               `json:"settle_timeout"`
+	RevealTimeout     int               `json:"reveal_timeout"`
+	Our               *EndStateSnapshot `json:"our"`
+	Partner           *EndStateSnapshot `json:"partner"`
+}
+
+//StateManagerSnapshot 一个进行中交易的摘要,密码只给出是否已经知道
+type StateManagerSnapshot struct {
+	Identifier     common.Hash    `json:"identifier"`
+	Name           string         `json:"name"`
+	State          string         `json:"state"` //CurrentState 的类型
+	LockSecretHash common.Hash    `json:"lock_secret_hash"`
+	TokenAddress   common.Address `json:"token_address"`
+	Initiator      common.Address `json:"initiator"`
+	Target         common.Address `json:"target"`
+	Amount         *big.Int       `json:"amount"`
+	Expiration     int64          `json:"expiration"`
+	SecretKnown    bool           `json:"secret_known"`
+}
+
+//TokenSwapSnapshot 一个还没有完成的 token swap,不包含 maker 的密码
+type TokenSwapSnapshot struct {
+	LockSecretHash  common.Hash    `json:"lock_secret_hash"`
+	FromToken       common.Address `json:"from_token"`
+	FromAmount      *big.Int       `json:"from_amount"`
+	FromNodeAddress common.Address `json:"from_node_address"`
+	ToToken         common.Address `json:"to_token"`
+	ToAmount        *big.Int       `json:"to_amount"`
+	ToNodeAddress   common.Address `json:"to_node_address"`
+}
+
+/*
+StateSnapshot 某一时刻内存中全部状态的只读副本,用于调试时比较两个节点对同一批通道和交易的看法.
+所有列表都排好序,同样的状态得到同样的 json
+*/
+type StateSnapshot struct {
+	NodeAddress   common.Address          `json:"node_address"`
+	BlockNumber   int64                   `json:"block_number"`
+	Channels      []*ChannelSnapshot      `json:"channels"`
+	StateManagers []*StateManagerSnapshot `json:"state_managers"`
+	TokenSwaps    []*TokenSwapSnapshot    `json:"token_swaps"`
+}
+
+/*
+DumpState 在主线程中生成 StateSnapshot,保证通道和交易处于同一个时刻,并且不和主线程竞争这些 map.
+所有密码都不会出现在结果中.
+不能在主线程中调用.
+*/
+func (rs *Service) DumpState() (*StateSnapshot, error) {
+	result := rs.dumpStateClient()
+	err := <-result.Result
+	if err != nil {
+		return nil, err
+	}
+	return result.Tag.(*StateSnapshot), nil
+}
+
+/*
+dumpState 只能在主线程中调用
+*/
+func (rs *Service) dumpState() (result *utils.AsyncResult) {
+	s := &StateSnapshot{
+		NodeAddress:   rs.NodeAddress,
+		BlockNumber:   rs.GetBlockNumber(),
+		Channels:      []*ChannelSnapshot{},
+		StateManagers: []*StateManagerSnapshot{},
+		TokenSwaps:    []*TokenSwapSnapshot{},
+	}
+	for _, g := range rs.Token2ChannelGraph {
+		for _, c := range g.ChannelIdentifier2Channel {
+			s.Channels = append(s.Channels, newChannelSnapshot(c))
+		}
+	}
+	sort.Slice(s.Channels, func(i, j int) bool {
+		return bytes.Compare(s.Channels[i].ChannelIdentifier[:], s.Channels[j].ChannelIdentifier[:]) < 0
+	})
+	for _, sm := range rs.Transfer2StateManager {
+		s.StateManagers = append(s.StateManagers, newStateManagerSnapshot(sm))
+	}
+	sort.Slice(s.StateManagers, func(i, j int) bool {
+		return bytes.Compare(s.StateManagers[i].Identifier[:], s.StateManagers[j].Identifier[:]) < 0
+	})
+	for _, ts := range rs.SwapKey2TokenSwap {
+		s.TokenSwaps = append(s.TokenSwaps, &TokenSwapSnapshot{
+			LockSecretHash:  ts.LockSecretHash,
+			FromToken:       ts.FromToken,
+			FromAmount:      copyBigInt(ts.FromAmount),
+			FromNodeAddress: ts.FromNodeAddress,
+			ToToken:         ts.ToToken,
+			ToAmount:        copyBigInt(ts.ToAmount),
+			ToNodeAddress:   ts.ToNodeAddress,
+		})
+	}
+	sort.Slice(s.TokenSwaps, func(i, j int) bool {
+		a, b := s.TokenSwaps[i], s.TokenSwaps[j]
+		if a.LockSecretHash != b.LockSecretHash {
+			return bytes.Compare(a.LockSecretHash[:], b.LockSecretHash[:]) < 0
+		}
+		return bytes.Compare(a.FromToken[:], b.FromToken[:]) < 0
+	})
+	result = utils.NewAsyncResult()
+	result.Tag = s
+	result.Result <- nil
+	return
+}
+
+func newChannelSnapshot(c *channel.Channel) *ChannelSnapshot {
+	return &ChannelSnapshot{
+		ChannelIdentifier: c.ChannelIdentifier.ChannelIdentifier,
+		OpenBlockNumber:   c.ChannelIdentifier.OpenBlockNumber,
+		TokenAddress:      c.TokenAddress,
+		State:             c.State.String(),
+		SettleTimeout:     c.SettleTimeout,
+		RevealTimeout:     c.RevealTimeout,
+		Our:               newEndStateSnapshot(c.OurState, c.PartnerState),
+		Partner:           newEndStateSnapshot(c.PartnerState, c.OurState),
+	}
+}
+
+func newEndStateSnapshot(es, counterpart *channel.EndState) *EndStateSnapshot {
+	s := &EndStateSnapshot{
+		Address:         es.Address,
+		ContractBalance: copyBigInt(es.ContractBalance),
+		Balance:         es.Balance(counterpart),
+		TransferAmount:  copyBigInt(es.BalanceProofState.TransferAmount),
+		Nonce:           es.BalanceProofState.Nonce,
+		LocksRoot:       es.BalanceProofState.LocksRoot,
+		Locks:           []*LockSnapshot{},
+	}
+	for _, pending := range es.Lock2PendingLocks {
+		s.Locks = append(s.Locks, newLockSnapshot(pending.Lock, false))
+	}
+	for _, proof := range es.Lock2UnclaimedLocks {
+		s.Locks = append(s.Locks, newLockSnapshot(proof.Lock, true))
+	}
+	sort.Slice(s.Locks, func(i, j int) bool {
+		return bytes.Compare(s.Locks[i].LockSecretHash[:], s.Locks[j].LockSecretHash[:]) < 0
+	})
+	return s
+}
+
+func newLockSnapshot(lock *mtree.Lock, revealed bool) *LockSnapshot {
+	return &LockSnapshot{
+		LockSecretHash: lock.LockSecretHash,
+		Amount:         copyBigInt(lock.Amount),
+		Expiration:     lock.Expiration,
+		Revealed:       revealed,
+	}
+}
+
+func newStateManagerSnapshot(sm *transfer.StateManager) *StateManagerSnapshot {
+	s := &StateManagerSnapshot{
+		Identifier: sm.Identifier,
+		Name:       sm.Name,
+		State:      fmt.Sprintf("%T", sm.CurrentState),
+	}
+	var tr *mediatedtransfer.LockedTransferState
+	switch st := sm.CurrentState.(type) {
+	case *mediatedtransfer.InitiatorState:
+		tr = st.Transfer
+	case *mediatedtransfer.MediatorState:
+		if len(st.TransfersPair) > 0 {
+			tr = st.TransfersPair[0].PayerTransfer
+		}
+		s.TokenAddress = st.Token
+	case *mediatedtransfer.TargetState:
+		tr = st.FromTransfer
+	}
+	if tr != nil {
+		s.TokenAddress = tr.Token
+		s.Initiator = tr.Initiator
+		s.Target = tr.Target
+		s.Amount = copyBigInt(tr.Amount)
+		s.Expiration = tr.Expiration
+	}
+	var secret common.Hash
+	s.LockSecretHash, secret = stateManagerSecret(sm.CurrentState)
+	s.SecretKnown = secret != utils.EmptyHash
+	return s
+}
+
+func copyBigInt(x *big.Int) *big.Int {
+	if x == nil {
+		return nil
+	}
+	return new(big.Int).Set(x)
+}
@@ -0,0 +1,119 @@
+package photon
+
+import (
+	"encoding/json"
+	"math/big"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
+	"github.com/SmartMeshFoundation/Photon/network/graph"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/transfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer/initiator"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDumpState(t *testing.T) {
+	our, partner, token := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	ourState := channel.NewChannelEndState(our, big.NewInt(100), nil, mtree.EmptyTree)
+	partnerState := channel.NewChannelEndState(partner, big.NewInt(50), nil, mtree.EmptyTree)
+	c, err := channel.NewChannel(ourState, partnerState, &channel.ExternalState{}, token,
+		&contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash(), OpenBlockNumber: 3}, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewChannelGraph(our, token, nil)
+	assert.Nil(t, g.AddChannel(c))
+	rs := &Service{
+		NodeAddress:           our,
+		Config:                &params.Config{},
+		BlockNumber:           new(atomic.Value),
+		Token2ChannelGraph:    map[common.Address]*graph.ChannelGraph{token: g},
+		Transfer2StateManager: make(map[common.Hash]*transfer.StateManager),
+		SwapKey2TokenSwap:     make(map[swapKey]*TokenSwap),
+	}
+	rs.BlockNumber.Store(int64(20))
+
+	secret := utils.NewRandomHash()
+	lockSecretHash := utils.ShaSecret(secret[:])
+	ourState.Lock2PendingLocks[lockSecretHash] = channeltype.PendingLock{
+		Lock: &mtree.Lock{Amount: big.NewInt(5), Expiration: 40, LockSecretHash: lockSecretHash},
+	}
+	revealedSecret := utils.NewRandomHash()
+	revealedHash := utils.ShaSecret(revealedSecret[:])
+	partnerState.Lock2UnclaimedLocks[revealedHash] = channeltype.UnlockPartialProof{
+		Lock:   &mtree.Lock{Amount: big.NewInt(3), Expiration: 30, LockSecretHash: revealedHash},
+		Secret: revealedSecret,
+	}
+	smID := utils.NewRandomHash()
+	rs.Transfer2StateManager[smID] = transfer.NewStateManager(nil, &mediatedtransfer.InitiatorState{
+		LockSecretHash: lockSecretHash,
+		Secret:         secret,
+		Transfer: &mediatedtransfer.LockedTransferState{
+			Amount:         big.NewInt(5),
+			Token:          token,
+			Initiator:      our,
+			Target:         partner,
+			Expiration:     40,
+			LockSecretHash: lockSecretHash,
+			Secret:         secret,
+		},
+	}, initiator.NameInitiatorTransition, smID, token)
+	swapSecret := utils.NewRandomHash()
+	rs.SwapKey2TokenSwap[swapKey{LockSecretHash: lockSecretHash, FromToken: token, FromAmount: "5"}] = &TokenSwap{
+		LockSecretHash: lockSecretHash,
+		Secret:         swapSecret,
+		FromToken:      token,
+		FromAmount:     big.NewInt(5),
+		ToAmount:       big.NewInt(6),
+	}
+
+	result := rs.dumpState()
+	assert.Nil(t, <-result.Result)
+	s := result.Tag.(*StateSnapshot)
+	assert.Equal(t, our, s.NodeAddress)
+	assert.EqualValues(t, 20, s.BlockNumber)
+	if assert.Len(t, s.Channels, 1) {
+		cs := s.Channels[0]
+		assert.Equal(t, c.ChannelIdentifier.ChannelIdentifier, cs.ChannelIdentifier)
+		assert.EqualValues(t, 3, cs.OpenBlockNumber)
+		assert.Equal(t, channeltype.State(channeltype.StateOpened).String(), cs.State)
+		assert.EqualValues(t, big.NewInt(100), cs.Our.Balance)
+		assert.EqualValues(t, big.NewInt(50), cs.Partner.Balance)
+		if assert.Len(t, cs.Our.Locks, 1) {
+			assert.Equal(t, lockSecretHash, cs.Our.Locks[0].LockSecretHash)
+			assert.False(t, cs.Our.Locks[0].Revealed)
+		}
+		if assert.Len(t, cs.Partner.Locks, 1) {
+			assert.True(t, cs.Partner.Locks[0].Revealed)
+		}
+	}
+	if assert.Len(t, s.StateManagers, 1) {
+		sm := s.StateManagers[0]
+		assert.Equal(t, smID, sm.Identifier)
+		assert.Equal(t, lockSecretHash, sm.LockSecretHash)
+		assert.Equal(t, partner, sm.Target)
+		assert.EqualValues(t, big.NewInt(5), sm.Amount)
+		assert.True(t, sm.SecretKnown)
+	}
+	assert.Len(t, s.TokenSwaps, 1)
+
+	//快照和内存中的状态互不影响
+	s.Channels[0].Our.ContractBalance.SetInt64(1)
+	assert.EqualValues(t, big.NewInt(100), ourState.ContractBalance)
+
+	//json 中不能出现任何密码
+	buf, err := json.Marshal(s)
+	assert.Nil(t, err)
+	for _, secret := range []common.Hash{secret, revealedSecret, swapSecret} {
+		assert.False(t, strings.Contains(strings.ToLower(string(buf)), strings.ToLower(secret.Hex()[2:])), "secret %s leaked", secret.Hex())
+	}
+}
@@ -0,0 +1,154 @@
+package photon
+
+import (
+	"math/big"
+	"sort"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//StuckAction 对卡住的交易建议的处理方式
+type StuckAction string
+
+const (
+	//StuckActionWait 不需要人工干预,等待对方或者锁过期以后自动处理
+	StuckActionWait StuckAction = "wait"
+	//StuckActionRegisterSecret 锁快要过期了,对方还没有发送 unlock,应该尽快在链上注册密码
+	StuckActionRegisterSecret StuckAction = "register_secret"
+	//StuckActionCancel 密码没有泄露,可以撤销交易;或者锁已经无法挽回,放弃这个锁
+	StuckActionCancel StuckAction = "cancel"
+)
+
+//StuckTransfer 一个需要关注的锁
+type StuckTransfer struct {
+	LockSecretHash    common.Hash    `json:"lock_secret_hash"`
+	TokenAddress      common.Address `json:"token_address"`
+	ChannelIdentifier common.Hash    `json:"channel_identifier"`
+	PartnerAddress    common.Address `json:"partner_address"`
+	Amount            *big.Int       `json:"amount"`
+	Expiration        int64          `json:"expiration"`
+	IsSent            bool           `json:"is_sent"`      //锁是我发出的还是我收到的
+	SecretKnown       bool           `json:"secret_known"` //我是否已经知道密码
+	Role              string         `json:"role"`         //处理这个交易的 StateManager,没有则为空
+	Reason            string         `json:"reason"`
+	Action            StuckAction    `json:"action"`
+}
+
+/*
+GetStuckTransfers 列出通道上需要人工关注的锁,用于启动以后的恢复:
+1. 锁进入了 reveal timeout 窗口或者已经过期,但是还没有结束
+2. 没有任何 StateManager 在处理的锁,不会有人推动它完成
+不能在主线程中调用.
+*/
+func (rs *Service) GetStuckTransfers() (stucks []*StuckTransfer, err error) {
+	result := rs.getStuckTransfersClient()
+	err = <-result.Result
+	if err != nil {
+		return
+	}
+	stucks = result.Tag.([]*StuckTransfer)
+	return
+}
+
+/*
+getStuckTransfers 遍历所有通道双方的锁进行分类.
+只能在主线程中调用
+*/
+func (rs *Service) getStuckTransfers() (result *utils.AsyncResult) {
+	blockNumber := rs.GetBlockNumber()
+	stucks := []*StuckTransfer{}
+	for _, g := range rs.Token2ChannelGraph {
+		for _, c := range g.ChannelIdentifier2Channel {
+			stucks = append(stucks, rs.channelStuckTransfers(c, blockNumber)...)
+		}
+	}
+	sort.SliceStable(stucks, func(i, j int) bool {
+		return stucks[i].Expiration < stucks[j].Expiration
+	})
+	result = utils.NewAsyncResult()
+	result.Tag = stucks
+	result.Result <- nil
+	return
+}
+
+func (rs *Service) channelStuckTransfers(c *channel.Channel, blockNumber int64) (stucks []*StuckTransfer) {
+	add := func(lock *mtree.Lock, isSent, secretKnown, registered bool) {
+		s := &StuckTransfer{
+			LockSecretHash:    lock.LockSecretHash,
+			TokenAddress:      c.TokenAddress,
+			ChannelIdentifier: c.ChannelIdentifier.ChannelIdentifier,
+			PartnerAddress:    c.PartnerState.Address,
+			Amount:            lock.Amount,
+			Expiration:        lock.Expiration,
+			IsSent:            isSent,
+			SecretKnown:       secretKnown,
+		}
+		smkey := utils.Sha3(lock.LockSecretHash[:], c.TokenAddress[:])
+		if mgr := rs.Transfer2StateManager[smkey]; mgr != nil && mgr.CurrentState != nil {
+			s.Role = mgr.Name
+		}
+		if classifyStuckLock(s, blockNumber, int64(c.RevealTimeout), registered) {
+			stucks = append(stucks, s)
+		}
+	}
+	for _, l := range c.OurState.Lock2PendingLocks {
+		add(l.Lock, true, false, false)
+	}
+	for _, l := range c.OurState.Lock2UnclaimedLocks {
+		add(l.Lock, true, true, l.IsRegisteredOnChain)
+	}
+	for _, l := range c.PartnerState.Lock2PendingLocks {
+		add(l.Lock, false, false, false)
+	}
+	for _, l := range c.PartnerState.Lock2UnclaimedLocks {
+		add(l.Lock, false, true, l.IsRegisteredOnChain)
+	}
+	return
+}
+
+/*
+classifyStuckLock 填写 Reason 和 Action,返回 false 表示这个锁正常,不用关注.
+registered 表示密码已经在链上注册过了
+*/
+func classifyStuckLock(s *StuckTransfer, blockNumber, revealTimeout int64, registered bool) bool {
+	expired := blockNumber >= s.Expiration
+	inDanger := blockNumber >= s.Expiration-revealTimeout
+	if !expired && !inDanger && s.Role != "" {
+		return false
+	}
+	switch {
+	case s.IsSent && !s.SecretKnown:
+		//对方不能再用这个锁向我要钱,过期以后撤销
+		if expired {
+			s.Reason, s.Action = "sent lock expired without secret", StuckActionCancel
+		} else if s.Role == "" {
+			s.Reason, s.Action = "sent lock has no transfer handling it", StuckActionWait
+		} else {
+			s.Reason, s.Action = "secret not revealed near expiration", StuckActionCancel
+		}
+	case s.IsSent && s.SecretKnown:
+		//对方知道密码,会自己在链上注册,我只能等待
+		s.Reason, s.Action = "secret revealed but unlock not sent yet", StuckActionWait
+	case !s.IsSent && !s.SecretKnown:
+		if expired {
+			s.Reason, s.Action = "received lock expired without secret", StuckActionWait
+		} else {
+			s.Reason, s.Action = "received lock still waiting for secret", StuckActionWait
+		}
+	default:
+		//我知道密码,但是对方一直没有给我 unlock
+		if registered {
+			s.Reason, s.Action = "secret registered on chain,unlock on chain after channel closed", StuckActionWait
+		} else if expired {
+			s.Reason, s.Action = "received lock expired before secret registered,lock is lost", StuckActionCancel
+		} else if inDanger {
+			s.Reason, s.Action = "partner does not unlock near expiration", StuckActionRegisterSecret
+		} else {
+			s.Reason, s.Action = "secret known but no transfer handling it", StuckActionWait
+		}
+	}
+	return true
+}
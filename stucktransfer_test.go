@@ -0,0 +1,82 @@
+package photon
+
+import (
+	"math/big"
+	"sync/atomic"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
+	"github.com/SmartMeshFoundation/Photon/network/graph"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/transfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer/initiator"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetStuckTransfers(t *testing.T) {
+	token := utils.NewRandomAddress()
+	c := &channel.Channel{
+		OurState:          channel.NewChannelEndState(utils.NewRandomAddress(), big.NewInt(100), nil, mtree.EmptyTree),
+		PartnerState:      channel.NewChannelEndState(utils.NewRandomAddress(), big.NewInt(100), nil, mtree.EmptyTree),
+		ChannelIdentifier: contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash()},
+		TokenAddress:      token,
+		RevealTimeout:     10,
+	}
+	newLock := func(expiration int64) *mtree.Lock {
+		return &mtree.Lock{Expiration: expiration, Amount: big.NewInt(1), LockSecretHash: utils.NewRandomHash()}
+	}
+	rs := &Service{
+		BlockNumber:           new(atomic.Value),
+		Transfer2StateManager: make(map[common.Hash]*transfer.StateManager),
+		Token2ChannelGraph: map[common.Address]*graph.ChannelGraph{token: {
+			ChannelIdentifier2Channel: map[common.Hash]*channel.Channel{c.ChannelIdentifier.ChannelIdentifier: c},
+		}},
+	}
+	rs.BlockNumber.Store(int64(100))
+	handled := func(l *mtree.Lock) {
+		smkey := utils.Sha3(l.LockSecretHash[:], token[:])
+		rs.Transfer2StateManager[smkey] = transfer.NewStateManager(nil, &mediatedtransfer.InitiatorState{}, initiator.NameInitiatorTransition, l.LockSecretHash, token)
+	}
+	//正常进行中的交易
+	normal := newLock(200)
+	handled(normal)
+	c.OurState.Lock2PendingLocks[normal.LockSecretHash] = channeltype.PendingLock{Lock: normal}
+	//密码迟迟没有泄露,可以撤销
+	sentInDanger := newLock(105)
+	handled(sentInDanger)
+	c.OurState.Lock2PendingLocks[sentInDanger.LockSecretHash] = channeltype.PendingLock{Lock: sentInDanger}
+	//对方不给 unlock,需要注册密码
+	unclaimed := newLock(108)
+	c.PartnerState.Lock2UnclaimedLocks[unclaimed.LockSecretHash] = channeltype.UnlockPartialProof{Lock: unclaimed}
+	//已经在链上注册过了
+	registered := newLock(90)
+	c.PartnerState.Lock2UnclaimedLocks[registered.LockSecretHash] = channeltype.UnlockPartialProof{Lock: registered, IsRegisteredOnChain: true}
+	//没有人处理的收到的锁
+	orphan := newLock(200)
+	c.PartnerState.Lock2PendingLocks[orphan.LockSecretHash] = channeltype.PendingLock{Lock: orphan}
+
+	result := rs.getStuckTransfers()
+	assert.Nil(t, <-result.Result)
+	stucks := result.Tag.([]*StuckTransfer)
+	if !assert.Len(t, stucks, 4) {
+		return
+	}
+	//按过期时间排序
+	assert.Equal(t, registered.LockSecretHash, stucks[0].LockSecretHash)
+	assert.Equal(t, StuckActionWait, stucks[0].Action)
+	assert.Equal(t, sentInDanger.LockSecretHash, stucks[1].LockSecretHash)
+	assert.Equal(t, StuckActionCancel, stucks[1].Action)
+	assert.Equal(t, initiator.NameInitiatorTransition, stucks[1].Role)
+	assert.True(t, stucks[1].IsSent)
+	assert.Equal(t, unclaimed.LockSecretHash, stucks[2].LockSecretHash)
+	assert.Equal(t, StuckActionRegisterSecret, stucks[2].Action)
+	assert.True(t, stucks[2].SecretKnown)
+	assert.Equal(t, orphan.LockSecretHash, stucks[3].LockSecretHash)
+	assert.Equal(t, StuckActionWait, stucks[3].Action)
+	assert.Empty(t, stucks[3].Role)
+}
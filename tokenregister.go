@@ -0,0 +1,44 @@
+package photon
+
+import (
+	"fmt"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/network/graph"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+getOrRegisterTokenGraph 返回 token 对应的 graph,本地没有这个 token 时:
+如果配置了 AutoRegisterTokenOnReceive,去链上确认 token 已经注册以后自动添加,否则返回错误,
+调用者不用再担心 graph 为 nil.
+只能在主线程中调用
+*/
+func (rs *Service) getOrRegisterTokenGraph(token common.Address) (g *graph.ChannelGraph, err error) {
+	g = rs.Token2ChannelGraph[token]
+	if g != nil {
+		return
+	}
+	if !rs.Config.AutoRegisterTokenOnReceive {
+		return nil, rerr.ErrTransferUnwanted.Printf("unknown token %s", utils.APex2(token))
+	}
+	registered, err := rs.Chain.RegistryProxy.TokenNetworkByToken(token)
+	if err != nil {
+		return nil, rerr.ErrContractQueryError.Printf("query token %s err %s", utils.APex2(token), err)
+	}
+	if !registered {
+		return nil, rerr.ErrTransferUnwanted.Printf("token %s is not registered on chain", utils.APex2(token))
+	}
+	log.Info(fmt.Sprintf("auto register token %s", utils.APex2(token)))
+	err = rs.StateMachineEventHandler.HandleTokenAdded(&mediatedtransfer.ContractTokenAddedStateChange{
+		TokenAddress: token,
+		BlockNumber:  rs.GetBlockNumber(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rs.Token2ChannelGraph[token], nil
+}
@@ -0,0 +1,37 @@
+package photon
+
+import (
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+checkTokenSettleTimeouts 每个 token 的默认 settle timeout 都要在链上允许的范围内,
+并且大于 reveal timeout,否则用它新建的通道不能交易
+*/
+func checkTokenSettleTimeouts(timeouts map[common.Address]int, minSettleTimeout, revealTimeout int) error {
+	for token, settleTimeout := range timeouts {
+		if settleTimeout < minSettleTimeout || settleTimeout > params.ChannelSettleTimeoutMax {
+			return rerr.ErrChannelInvalidSettleTimeout.Printf("settle timeout %d of token %s must be between %d and %d",
+				settleTimeout, utils.APex2(token), minSettleTimeout, params.ChannelSettleTimeoutMax)
+		}
+		if settleTimeout <= revealTimeout {
+			return rerr.ErrChannelInvalidSettleTimeout.Printf("settle timeout %d of token %s must be bigger than reveal timeout %d",
+				settleTimeout, utils.APex2(token), revealTimeout)
+		}
+	}
+	return nil
+}
+
+/*
+defaultSettleTimeout 新建通道时没有指定 settle timeout 使用的值,
+优先使用 Config.TokenSettleTimeouts 中这个 token 的配置,没有的话使用 Config.SettleTimeout
+*/
+func (rs *Service) defaultSettleTimeout(tokenAddress common.Address) int {
+	if settleTimeout, ok := rs.Config.TokenSettleTimeouts[tokenAddress]; ok {
+		return settleTimeout
+	}
+	return rs.Config.SettleTimeout
+}
@@ -0,0 +1,41 @@
+package photon
+
+import (
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenSettleTimeouts(t *testing.T) {
+	stable, volatile := utils.NewRandomAddress(), utils.NewRandomAddress()
+	timeouts := map[common.Address]int{stable: 100, volatile: 50000}
+	assert.Nil(t, checkTokenSettleTimeouts(nil, 60, 10))
+	assert.Nil(t, checkTokenSettleTimeouts(timeouts, 60, 10))
+	for _, m := range []map[common.Address]int{
+		{stable: 59},
+		{stable: params.ChannelSettleTimeoutMax + 1},
+		{stable: 100, volatile: 10},
+	} {
+		err := checkTokenSettleTimeouts(m, 60, 10)
+		if assert.NotNil(t, err) {
+			assert.Equal(t, rerr.ErrChannelInvalidSettleTimeout.ErrorCode, err.(rerr.StandardError).ErrorCode)
+		}
+	}
+	assert.NotNil(t, checkTokenSettleTimeouts(timeouts, 60, 100))
+
+	rs := &Service{Config: &params.Config{SettleTimeout: 600, RevealTimeout: 10, TokenSettleTimeouts: timeouts}}
+	assert.Equal(t, 100, rs.defaultSettleTimeout(stable))
+	assert.Equal(t, 50000, rs.defaultSettleTimeout(volatile))
+	assert.Equal(t, 600, rs.defaultSettleTimeout(utils.NewRandomAddress()))
+
+	//reveal timeout 也不能超过按 token 配置的 settle timeout
+	err := <-rs.setDefaultRevealTimeout(100).Result
+	if assert.NotNil(t, err) {
+		assert.Equal(t, rerr.ErrChannelInvalidSettleTimeout.ErrorCode, err.(rerr.StandardError).ErrorCode)
+	}
+	assert.Equal(t, 10, rs.Config.RevealTimeout)
+}
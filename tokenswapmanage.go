@@ -0,0 +1,211 @@
+package photon
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//TokenSwapRole 我在 token swap 中的角色
+type TokenSwapRole string
+
+const (
+	//TokenSwapRoleMaker 知道密码,先发出 FromToken
+	TokenSwapRoleMaker TokenSwapRole = "maker"
+	//TokenSwapRoleTaker 收到 maker 的交易以后发出 ToToken
+	TokenSwapRoleTaker TokenSwapRole = "taker"
+)
+
+/*
+TokenSwapInfo 一个还没有结束的 token swap.
+TakerActed 表示 taker 已经发出了 ToToken: 对 maker 是收到了 taker 的交易,对 taker 是已经发起了交易,
+这之后 swap 不能再撤销
+*/
+type TokenSwapInfo struct {
+	Role            TokenSwapRole  `json:"role"`
+	LockSecretHash  common.Hash    `json:"lock_secret_hash"`
+	FromToken       common.Address `json:"from_token"`
+	FromAmount      *big.Int       `json:"from_amount"`
+	FromNodeAddress common.Address `json:"from_node_address"`
+	ToToken         common.Address `json:"to_token"`
+	ToAmount        *big.Int       `json:"to_amount"`
+	ToNodeAddress   common.Address `json:"to_node_address"`
+	TakerActed      bool           `json:"taker_acted"`
+}
+
+/*
+pendingTokenSwap 记录进行中的 token swap,swap 本身的逻辑仍然在各个 listener 中.
+maker 的 listener 以指针为 key 保存,撤销时需要用到
+*/
+type pendingTokenSwap struct {
+	role          TokenSwapRole
+	tokenSwap     *TokenSwap
+	takerActed    bool
+	sentHook      *SentMediatedTransferListener
+	receivedHook  *ReceivedMediatedTrasnferListener
+	secretRequest common.Hash //maker 当前注册了 SecretRequestPredictor 的 lockSecretHash
+}
+
+func newSwapKey(tokenSwap *TokenSwap) swapKey {
+	return swapKey{
+		LockSecretHash: tokenSwap.LockSecretHash,
+		FromToken:      tokenSwap.FromToken,
+		FromAmount:     tokenSwap.FromAmount.String(),
+	}
+}
+
+/*
+ourTransferKey 我在 swap 中发起的交易在 Transfer2StateManager 中的 key,
+taker 在发起交易之前还没有自己的交易,ok 为 false
+*/
+func (p *pendingTokenSwap) ourTransferKey() (smkey common.Hash, ok bool) {
+	token := p.tokenSwap.FromToken
+	if p.role == TokenSwapRoleTaker {
+		if !p.takerActed {
+			return
+		}
+		token = p.tokenSwap.ToToken
+	}
+	return utils.Sha3(p.tokenSwap.LockSecretHash[:], token[:]), true
+}
+
+/*
+ourSwapTransferFinished 我在 swap 中发起的交易已经结束,swap 也就结束了.
+*/
+func (rs *Service) ourSwapTransferFinished(p *pendingTokenSwap) bool {
+	smkey, ok := p.ourTransferKey()
+	return ok && rs.Transfer2StateManager[smkey] == nil
+}
+
+/*
+tokenSwapTransferFinished 我发起的交易成功,失败或者 state manager 被移除以后,对应的 swap 也结束了,
+立即清理,不依赖于有人调用 GetPendingTokenSwaps.只能在主线程中调用
+*/
+func (rs *Service) tokenSwapTransferFinished(smkey common.Hash) {
+	for key, p := range rs.pendingTokenSwaps {
+		if k, ok := p.ourTransferKey(); ok && k == smkey {
+			rs.removeTokenSwap(key, p)
+		}
+	}
+}
+
+/*
+removeTokenSwap 去掉 swap 注册的所有 listener,只能在主线程中调用
+*/
+func (rs *Service) removeTokenSwap(key swapKey, p *pendingTokenSwap) {
+	delete(rs.pendingTokenSwaps, key)
+	if p.role == TokenSwapRoleTaker {
+		delete(rs.SwapKey2TokenSwap, key)
+		return
+	}
+	delete(rs.SentMediatedTransferListenerMap, p.sentHook)
+	delete(rs.ReceivedMediatedTrasnferListenerMap, p.receivedHook)
+	if p.secretRequest != utils.EmptyHash {
+		delete(rs.SecretRequestPredictorMap, p.secretRequest)
+	}
+}
+
+/*
+GetPendingTokenSwaps 列出所有还没有结束的 token swap,包括等待 maker 交易的 taker.
+不能在主线程中调用.
+*/
+func (rs *Service) GetPendingTokenSwaps() ([]*TokenSwapInfo, error) {
+	result := rs.getPendingTokenSwapsClient()
+	err := <-result.Result
+	if err != nil {
+		return nil, err
+	}
+	return result.Tag.([]*TokenSwapInfo), nil
+}
+
+/*
+getPendingTokenSwaps 只能在主线程中调用,顺便清理已经结束的 swap
+*/
+func (rs *Service) getPendingTokenSwaps() (result *utils.AsyncResult) {
+	infos := []*TokenSwapInfo{}
+	for key, p := range rs.pendingTokenSwaps {
+		if rs.ourSwapTransferFinished(p) {
+			rs.removeTokenSwap(key, p)
+			continue
+		}
+		ts := p.tokenSwap
+		infos = append(infos, &TokenSwapInfo{
+			Role:            p.role,
+			LockSecretHash:  ts.LockSecretHash,
+			FromToken:       ts.FromToken,
+			FromAmount:      copyBigInt(ts.FromAmount),
+			FromNodeAddress: ts.FromNodeAddress,
+			ToToken:         ts.ToToken,
+			ToAmount:        copyBigInt(ts.ToAmount),
+			ToNodeAddress:   ts.ToNodeAddress,
+			TakerActed:      p.takerActed,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		a, b := infos[i], infos[j]
+		if a.LockSecretHash != b.LockSecretHash {
+			return bytes.Compare(a.LockSecretHash[:], b.LockSecretHash[:]) < 0
+		}
+		return bytes.Compare(a.FromToken[:], b.FromToken[:]) < 0
+	})
+	result = utils.NewAsyncResult()
+	result.Tag = infos
+	result.Result <- nil
+	return
+}
+
+/*
+CancelTokenSwap 撤销一个 token swap.
+taker 还没有收到 maker 的交易时,只是不再等待;
+maker 还没有收到 taker 的交易时,撤销已经发出的交易,和 CancelTransfer 一样,密码泄露以后不能撤销.
+taker 已经发出 ToToken 以后,双方都只能等待 swap 完成或者锁过期,所以拒绝撤销.
+不能在主线程中调用.
+*/
+func (rs *Service) CancelTokenSwap(lockSecretHash common.Hash, fromToken common.Address) error {
+	result := rs.cancelTokenSwapClient(lockSecretHash, fromToken)
+	return <-result.Result
+}
+
+/*
+cancelTokenSwap 只能在主线程中调用
+*/
+func (rs *Service) cancelTokenSwap(lockSecretHash common.Hash, fromToken common.Address) (result *utils.AsyncResult) {
+	var key swapKey
+	var p *pendingTokenSwap
+	for k, v := range rs.pendingTokenSwaps {
+		if k.LockSecretHash == lockSecretHash && k.FromToken == fromToken && !rs.ourSwapTransferFinished(v) {
+			key, p = k, v
+			break
+		}
+	}
+	if p == nil {
+		return utils.NewAsyncResultWithError(rerr.ErrTransferNotFound.Printf("token swap %s of token %s not found", utils.HPex(lockSecretHash), utils.APex2(fromToken)))
+	}
+	if p.takerActed {
+		return utils.NewAsyncResultWithError(rerr.ErrTransferCannotCancel.Printf("taker already sent %s,token swap %s can only complete or expire",
+			utils.APex2(p.tokenSwap.ToToken), utils.HPex(lockSecretHash)))
+	}
+	if p.role == TokenSwapRoleMaker {
+		req := &cancelTransferReq{
+			LockSecretHash: lockSecretHash,
+			TokenAddress:   fromToken,
+		}
+		if _, err := rs.checkCancelTransfer(req); err != nil {
+			return utils.NewAsyncResultWithError(err)
+		}
+		rs.removeTokenSwap(key, p)
+		log.Info(fmt.Sprintf("cancel token swap %s as maker", utils.HPex(lockSecretHash)))
+		return rs.cancelTransfer(req)
+	}
+	rs.removeTokenSwap(key, p)
+	log.Info(fmt.Sprintf("cancel token swap %s as taker", utils.HPex(lockSecretHash)))
+	result = utils.NewAsyncResult()
+	result.Result <- nil
+	return
+}
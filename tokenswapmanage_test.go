@@ -0,0 +1,186 @@
+package photon
+
+import (
+	"math/big"
+	"sync/atomic"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/encoding"
+	"github.com/SmartMeshFoundation/Photon/network/graph"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/transfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer/target"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestTokenSwap() *TokenSwap {
+	return &TokenSwap{
+		LockSecretHash:  utils.NewRandomHash(),
+		Secret:          utils.NewRandomHash(),
+		FromToken:       utils.NewRandomAddress(),
+		FromAmount:      big.NewInt(5),
+		FromNodeAddress: utils.NewRandomAddress(),
+		ToToken:         utils.NewRandomAddress(),
+		ToAmount:        big.NewInt(6),
+		ToNodeAddress:   utils.NewRandomAddress(),
+	}
+}
+
+func TestCancelTokenSwapTaker(t *testing.T) {
+	rs := &Service{
+		Transfer2StateManager: make(map[common.Hash]*transfer.StateManager),
+		SwapKey2TokenSwap:     make(map[swapKey]*TokenSwap),
+		pendingTokenSwaps:     make(map[swapKey]*pendingTokenSwap),
+	}
+	waiting, acted := newTestTokenSwap(), newTestTokenSwap()
+	assert.Nil(t, <-rs.tokenSwapTaker(waiting).Result)
+	assert.Nil(t, <-rs.tokenSwapTaker(acted).Result)
+	//taker 收到 maker 的交易以后发起了自己的交易
+	rs.pendingTokenSwaps[newSwapKey(acted)].takerActed = true
+	delete(rs.SwapKey2TokenSwap, newSwapKey(acted))
+	smKey := utils.Sha3(acted.LockSecretHash[:], acted.ToToken[:])
+	rs.Transfer2StateManager[smKey] = &transfer.StateManager{}
+
+	result := rs.getPendingTokenSwaps()
+	assert.Nil(t, <-result.Result)
+	infos := result.Tag.([]*TokenSwapInfo)
+	if assert.Len(t, infos, 2) {
+		for _, info := range infos {
+			assert.Equal(t, TokenSwapRoleTaker, info.Role)
+			assert.Equal(t, info.LockSecretHash == acted.LockSecretHash, info.TakerActed)
+		}
+	}
+
+	err := <-rs.cancelTokenSwap(acted.LockSecretHash, acted.FromToken).Result
+	if assert.NotNil(t, err) {
+		assert.Equal(t, rerr.ErrTransferCannotCancel.ErrorCode, err.(rerr.StandardError).ErrorCode)
+	}
+	assert.Nil(t, <-rs.cancelTokenSwap(waiting.LockSecretHash, waiting.FromToken).Result)
+	assert.Len(t, rs.SwapKey2TokenSwap, 0)
+	err = <-rs.cancelTokenSwap(waiting.LockSecretHash, waiting.FromToken).Result
+	if assert.NotNil(t, err) {
+		assert.Equal(t, rerr.ErrTransferNotFound.ErrorCode, err.(rerr.StandardError).ErrorCode)
+	}
+
+	//taker 的交易结束以后不再列出
+	delete(rs.Transfer2StateManager, smKey)
+	result = rs.getPendingTokenSwaps()
+	assert.Nil(t, <-result.Result)
+	assert.Len(t, result.Tag.([]*TokenSwapInfo), 0)
+	assert.Len(t, rs.pendingTokenSwaps, 0)
+}
+
+func TestCancelTokenSwapMaker(t *testing.T) {
+	rs := &Service{
+		Transfer2StateManager:               make(map[common.Hash]*transfer.StateManager),
+		SentMediatedTransferListenerMap:     make(map[*SentMediatedTransferListener]bool),
+		ReceivedMediatedTrasnferListenerMap: make(map[*ReceivedMediatedTrasnferListener]bool),
+		SecretRequestPredictorMap:           make(map[common.Hash]SecretRequestPredictor),
+		pendingTokenSwaps:                   make(map[swapKey]*pendingTokenSwap),
+	}
+	ts := newTestTokenSwap()
+	var sentHook SentMediatedTransferListener
+	var receivedHook ReceivedMediatedTrasnferListener
+	p := &pendingTokenSwap{
+		role:          TokenSwapRoleMaker,
+		tokenSwap:     ts,
+		sentHook:      &sentHook,
+		receivedHook:  &receivedHook,
+		secretRequest: ts.LockSecretHash,
+	}
+	rs.pendingTokenSwaps[newSwapKey(ts)] = p
+	rs.SentMediatedTransferListenerMap[&sentHook] = true
+	rs.ReceivedMediatedTrasnferListenerMap[&receivedHook] = true
+	rs.SecretRequestPredictorMap[ts.LockSecretHash] = func(*encoding.SecretRequest) bool { return true }
+	smKey := utils.Sha3(ts.LockSecretHash[:], ts.FromToken[:])
+	rs.Transfer2StateManager[smKey] = &transfer.StateManager{Name: target.NameTargetTransition}
+
+	//交易不能撤销时保留 swap
+	err := <-rs.cancelTokenSwap(ts.LockSecretHash, ts.FromToken).Result
+	if assert.NotNil(t, err) {
+		assert.Equal(t, rerr.ErrTransferCannotCancel.ErrorCode, err.(rerr.StandardError).ErrorCode)
+	}
+	assert.Len(t, rs.pendingTokenSwaps, 1)
+	assert.Len(t, rs.SentMediatedTransferListenerMap, 1)
+
+	p.takerActed = true
+	err = <-rs.cancelTokenSwap(ts.LockSecretHash, ts.FromToken).Result
+	if assert.NotNil(t, err) {
+		assert.Equal(t, rerr.ErrTransferCannotCancel.ErrorCode, err.(rerr.StandardError).ErrorCode)
+	}
+
+	//maker 的交易结束以后清理所有 listener
+	delete(rs.Transfer2StateManager, smKey)
+	result := rs.getPendingTokenSwaps()
+	assert.Nil(t, <-result.Result)
+	assert.Len(t, result.Tag.([]*TokenSwapInfo), 0)
+	assert.Len(t, rs.SentMediatedTransferListenerMap, 0)
+	assert.Len(t, rs.ReceivedMediatedTrasnferListenerMap, 0)
+	assert.Len(t, rs.SecretRequestPredictorMap, 0)
+}
+
+//交易结束时立即清理 swap,不需要有人查询 GetPendingTokenSwaps
+func TestTokenSwapRemovedWhenTransferFinished(t *testing.T) {
+	rs := &Service{
+		Config:                              &params.Config{},
+		Token2ChannelGraph:                  make(map[common.Address]*graph.ChannelGraph),
+		Transfer2StateManager:               make(map[common.Hash]*transfer.StateManager),
+		Transfer2Result:                     make(map[common.Hash]*utils.AsyncResult),
+		SwapKey2TokenSwap:                   make(map[swapKey]*TokenSwap),
+		SentMediatedTransferListenerMap:     make(map[*SentMediatedTransferListener]bool),
+		ReceivedMediatedTrasnferListenerMap: make(map[*ReceivedMediatedTrasnferListener]bool),
+		SecretRequestPredictorMap:           make(map[common.Hash]SecretRequestPredictor),
+		pendingTokenSwaps:                   make(map[swapKey]*pendingTokenSwap),
+		BlockNumber:                         new(atomic.Value),
+	}
+	rs.BlockNumber.Store(int64(10))
+	eh := newStateMachineEventHandler(rs)
+
+	//maker 的交易没有发起
+	maker := newTestTokenSwap()
+	assert.NotNil(t, <-rs.tokenSwapMaker(maker).Result)
+	assert.Len(t, rs.pendingTokenSwaps, 0)
+	assert.Len(t, rs.SentMediatedTransferListenerMap, 0)
+	assert.Len(t, rs.ReceivedMediatedTrasnferListenerMap, 0)
+
+	//maker 的交易失败
+	var sentHook SentMediatedTransferListener
+	var receivedHook ReceivedMediatedTrasnferListener
+	rs.pendingTokenSwaps[newSwapKey(maker)] = &pendingTokenSwap{
+		role:         TokenSwapRoleMaker,
+		tokenSwap:    maker,
+		sentHook:     &sentHook,
+		receivedHook: &receivedHook,
+	}
+	rs.SentMediatedTransferListenerMap[&sentHook] = true
+	rs.ReceivedMediatedTrasnferListenerMap[&receivedHook] = true
+	//taker 还没有发起交易,同一个 LockSecretHash 不受影响
+	waiting := newTestTokenSwap()
+	waiting.LockSecretHash = maker.LockSecretHash
+	waiting.ToToken = maker.FromToken
+	assert.Nil(t, <-rs.tokenSwapTaker(waiting).Result)
+	eh.finishOneTransfer(&transfer.EventTransferSentFailed{
+		LockSecretHash: maker.LockSecretHash,
+		Token:          maker.FromToken,
+		Reason:         "no route",
+	})
+	assert.Len(t, rs.pendingTokenSwaps, 1)
+	assert.NotNil(t, rs.pendingTokenSwaps[newSwapKey(waiting)])
+	assert.Len(t, rs.SentMediatedTransferListenerMap, 0)
+	assert.Len(t, rs.ReceivedMediatedTrasnferListenerMap, 0)
+
+	//taker 的 state manager 被移除
+	acted := newTestTokenSwap()
+	assert.Nil(t, <-rs.tokenSwapTaker(acted).Result)
+	rs.pendingTokenSwaps[newSwapKey(acted)].takerActed = true
+	smKey := utils.Sha3(acted.LockSecretHash[:], acted.ToToken[:])
+	rs.Transfer2StateManager[smKey] = &transfer.StateManager{}
+	assert.Nil(t, eh.OnEvent(&mediatedtransfer.EventRemoveStateManager{Key: smKey}, nil))
+	assert.Nil(t, rs.pendingTokenSwaps[newSwapKey(acted)])
+	assert.Nil(t, rs.SwapKey2TokenSwap[newSwapKey(acted)])
+	assert.Len(t, rs.pendingTokenSwaps, 1)
+}
@@ -8,6 +8,36 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 )
 
+/*
+SettlementOutcome 交易成功时是如何完成的.
+正常情况下交易通过 unlock 消息在链下完成,
+如果某个节点不得不在链上注册密码,交易虽然成功了,但是有 gas 消耗,也需要等待链上确认,
+对账以及提示用户时需要区分这两种情况.
+*/
+type SettlementOutcome int
+
+const (
+	//OutcomeOffChain 交易完全在链下完成
+	OutcomeOffChain SettlementOutcome = iota
+	//OutcomeSecretRegisteredOnChain 密码在链上注册以后交易才完成
+	OutcomeSecretRegisteredOnChain
+)
+
+func (o SettlementOutcome) String() string {
+	switch o {
+	case OutcomeOffChain:
+		return "OffChain"
+	case OutcomeSecretRegisteredOnChain:
+		return "SecretRegisteredOnChain"
+	}
+	return "Unknown"
+}
+
+//OnChain 交易完成的过程中是否发生了链上操作
+func (o SettlementOutcome) OnChain() bool {
+	return o != OutcomeOffChain
+}
+
 /*
 EventTransferSentSuccess emitted by the initiator when a transfer is considered sucessful.
 
@@ -36,6 +66,7 @@ type EventTransferSentSuccess struct {
 	ChannelIdentifier common.Hash
 	Token             common.Address
 	Data              string
+	Outcome           SettlementOutcome
 }
 
 /*
@@ -50,6 +81,7 @@ type EventTransferSentFailed struct {
 	Reason         string
 	Target         common.Address //transfer's target, may be not the same as receipient
 	Token          common.Address
+	Failures       []*RouteFailure //每条尝试过的路由失败的原因,没有尝试过任何路由时为空
 }
 
 /*
@@ -66,6 +98,7 @@ type EventTransferReceivedSuccess struct {
 	Initiator         common.Address
 	ChannelIdentifier common.Hash
 	Data              string
+	Outcome           SettlementOutcome
 }
 
 func init() {
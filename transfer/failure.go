@@ -0,0 +1,66 @@
+package transfer
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//FailureReason 交易在某条路由上失败的原因分类
+type FailureReason int
+
+const (
+	//FailureReasonUnknown 无法识别的错误码,比如来自旧版本的节点
+	FailureReasonUnknown FailureReason = iota
+	//FailureReasonNoRoute 没有可以继续尝试的路由,目标不可达
+	FailureReasonNoRoute
+	//FailureReasonInsufficientBalance 某一跳通道余额不足
+	FailureReasonInsufficientBalance
+	//FailureReasonInsufficientFee 给出的手续费不足以支付某一跳的收费
+	FailureReasonInsufficientFee
+	//FailureReasonLockExpirationTooNear 锁剩余时间不够继续转发
+	FailureReasonLockExpirationTooNear
+	//FailureReasonChannelUnavailable 某一跳通道状态不允许交易,比如已经关闭
+	FailureReasonChannelUnavailable
+	//FailureReasonCycleRoute 路由形成了环路
+	FailureReasonCycleRoute
+	//FailureReasonRejected 中间节点主动拒绝,比如持有上家太多的锁
+	FailureReasonRejected
+	//FailureReasonCanceled 发起方自己取消
+	FailureReasonCanceled
+)
+
+var failureReasonNames = map[FailureReason]string{
+	FailureReasonUnknown:               "unknown",
+	FailureReasonNoRoute:               "no_route",
+	FailureReasonInsufficientBalance:   "insufficient_balance",
+	FailureReasonInsufficientFee:       "insufficient_fee",
+	FailureReasonLockExpirationTooNear: "lock_expiration_too_near",
+	FailureReasonChannelUnavailable:    "channel_unavailable",
+	FailureReasonCycleRoute:            "cycle_route",
+	FailureReasonRejected:              "rejected",
+	FailureReasonCanceled:              "canceled",
+}
+
+//String fmt.Stringer
+func (r FailureReason) String() string {
+	if s, ok := failureReasonNames[r]; ok {
+		return s
+	}
+	return failureReasonNames[FailureReasonUnknown]
+}
+
+//MarshalText 在 json 中以名字而不是数字出现
+func (r FailureReason) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+/*
+RouteFailure 一条路由失败的原因.
+中间节点没有其他路由可以尝试时,会把下游的失败原因原样转发给上家,
+所以 ErrorCode 和 ErrorMsg 描述的是最初发现问题的那一跳,ErrorMsg 中包含了出问题的通道.
+*/
+type RouteFailure struct {
+	Hop       common.Address `json:"hop"` //这条路由的下一跳,也就是通知我失败的节点
+	Reason    FailureReason  `json:"reason"`
+	ErrorCode int            `json:"error_code"`
+	ErrorMsg  string         `json:"error_message"`
+}
@@ -224,6 +224,7 @@ func transferSuccessEvents(l *mt.LockAndChannel) (events []transfer.Event) {
 
 	unlockSuccess := &mt.EventUnlockSuccess{
 		LockSecretHash: l.Lock.LockSecretHash,
+		Outcome:        transfer.OutcomeSecretRegisteredOnChain,
 	}
 	events = []transfer.Event{unlockLock, unlockSuccess}
 	return events
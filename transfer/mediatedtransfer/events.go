@@ -6,6 +6,7 @@ import (
 	"math/big"
 
 	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/transfer"
 	"github.com/ethereum/go-ethereum/common"
 )
 
@@ -186,6 +187,12 @@ type EventContractSendUnlock struct {
 //EventUnlockSuccess emitted when a lock unlock succeded ,emit this event after receive a revealsecret message
 type EventUnlockSuccess struct {
 	LockSecretHash common.Hash
+	//下面几个字段只有中间节点会设置,用于统计通道上的中转交易
+	// fields below are only set by mediator, used for channel statistics
+	PayerChannelIdentifier common.Hash
+	PayeeChannelIdentifier common.Hash
+	Amount                 *big.Int
+	Outcome                transfer.SettlementOutcome
 }
 
 /*
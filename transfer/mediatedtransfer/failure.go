@@ -0,0 +1,33 @@
+package mediatedtransfer
+
+import (
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/transfer"
+)
+
+/*
+FailureReasonFromErrorCode AnnounceDisposed 中只携带了 rerr 的错误码,
+这里把它转换为发起方可以理解的分类.
+*/
+func FailureReasonFromErrorCode(code int) transfer.FailureReason {
+	switch code {
+	case rerr.ErrNoAvailabeRoute.ErrorCode:
+		return transfer.FailureReasonNoRoute
+	case rerr.ErrChannelNoEnoughBalance.ErrorCode, rerr.ErrInsufficientBalance.ErrorCode:
+		return transfer.FailureReasonInsufficientBalance
+	case rerr.ErrRouteNoEnoughFee.ErrorCode:
+		return transfer.FailureReasonInsufficientFee
+	case rerr.ErrRouteLockExpirationTooNear.ErrorCode:
+		return transfer.FailureReasonLockExpirationTooNear
+	case rerr.ErrRouteChannelCannotTransfer.ErrorCode:
+		return transfer.FailureReasonChannelUnavailable
+	case rerr.ErrRouteCycle.ErrorCode:
+		return transfer.FailureReasonCycleRoute
+	case rerr.ErrRejectTransferBecauseChannelHoldingTooMuchLock.ErrorCode, rerr.ErrRejectTransferBecausePayerChannelClosed.ErrorCode,
+		rerr.ErrTooManyLocks.ErrorCode, rerr.ErrLockExpirationTooFar.ErrorCode:
+		return transfer.FailureReasonRejected
+	case rerr.ErrTransferCanceled.ErrorCode:
+		return transfer.FailureReasonCanceled
+	}
+	return transfer.FailureReasonUnknown
+}
@@ -13,6 +13,7 @@ import (
 
 	"github.com/SmartMeshFoundation/Photon/encoding"
 	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/rerr"
 	"github.com/SmartMeshFoundation/Photon/transfer"
 	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer"
 	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
@@ -88,6 +89,26 @@ func TestNextRoute(t *testing.T) {
 	assert(t, state.Routes.CanceledRoutes != nil, true)
 }
 
+//锁的过期时间使用发起交易时节点的 reveal timeout,而不是全局的默认值
+func TestInitWithRevealTimeout(t *testing.T) {
+	blockNumber := utest.UnitBlockNumber
+	routes := []*route.State{
+		utest.MakeRoute(utest.HOP1, utest.UnitTransferAmount, utest.UnitSettleTimeout, utest.UnitRevealTimeout, 0, utils.NewRandomHash()),
+	}
+	for _, revealTimeout := range []int{0, 7} {
+		initStateChange := makeInitStateChange(routes, utest.HOP2, utest.UnitTransferAmount, blockNumber, utest.ADDR, utest.UnitTokenAddress)
+		initStateChange.RevealTimeout = revealTimeout
+		it := StateTransition(nil, initStateChange)
+		state := it.NewState.(*mediatedtransfer.InitiatorState)
+		assert(t, state.RevealTimeout, revealTimeout)
+		expected := blockNumber + int64(utest.UnitSettleTimeout) - int64(revealTimeout)
+		if revealTimeout == 0 {
+			expected = blockNumber + int64(utest.UnitSettleTimeout) - int64(params.DefaultRevealTimeout)
+		}
+		assert(t, state.Message.Expiration, expected)
+	}
+}
+
 func TestInitWithUsableRoutes(t *testing.T) {
 	amount := utest.UnitTransferAmount
 	blockNumber := utest.UnitBlockNumber
@@ -218,9 +239,46 @@ func TestStateWaitUnlockValid(t *testing.T) {
 	assert(t, EventUnlockSuccess != nil, true)
 
 	assert(t, EventSendBalanceProof.Receiver, mediatorAddress)
+	assert(t, EventTransferSentSuccess.Outcome, transfer.OutcomeOffChain)
 	assert(t, sm.CurrentState, nil, "state must be cleaned")
 }
 
+func TestSecretRevealOnChainOutcome(t *testing.T) {
+	amount := utest.UnitTransferAmount
+	blockNumber := utest.UnitBlockNumber
+	mediatorAddress := utest.HOP1
+	targetAddress := utest.HOP2
+	ourAddress := utest.ADDR
+	token := utest.UnitTokenAddress
+
+	routes := []*route.State{
+		utest.MakeRoute(mediatorAddress, amount, utest.UnitSettleTimeout, utest.UnitRevealTimeout, 0, utils.NewRandomHash()),
+	}
+	currentState := makeInitiatorState(routes, targetAddress, utest.UnitTransferAmount, blockNumber, ourAddress, token)
+	it := handleSecretRevealOnChain(currentState, &mediatedtransfer.ContractSecretRevealOnChainStateChange{
+		Secret:         currentState.Transfer.Secret,
+		LockSecretHash: currentState.LockSecretHash,
+		BlockNumber:    blockNumber + 1,
+	})
+	var sentSuccess *transfer.EventTransferSentSuccess
+	var unlockSuccess *mediatedtransfer.EventUnlockSuccess
+	for _, e := range it.Events {
+		switch e2 := e.(type) {
+		case *transfer.EventTransferSentSuccess:
+			sentSuccess = e2
+		case *mediatedtransfer.EventUnlockSuccess:
+			unlockSuccess = e2
+		}
+	}
+	if assert(t, sentSuccess != nil, true) {
+		assert(t, sentSuccess.Outcome, transfer.OutcomeSecretRegisteredOnChain)
+		assert(t, sentSuccess.Outcome.OnChain(), true)
+	}
+	if assert(t, unlockSuccess != nil, true) {
+		assert(t, unlockSuccess.Outcome, transfer.OutcomeSecretRegisteredOnChain)
+	}
+}
+
 func TestStateWaitUnlockInvalid(t *testing.T) {
 	amount := utest.UnitTransferAmount
 	blockNumber := utest.UnitBlockNumber
@@ -331,6 +389,44 @@ func TestRefundTransferNoMoreRoutes(t *testing.T) {
 	assert(t, ok, true)
 	assert(t, sm.CurrentState == nil, true)
 }
+func TestRefundTransferFailureReason(t *testing.T) {
+	amount := utest.UnitTransferAmount
+	mediatorAddress := utest.HOP1
+	token := utest.UnitTokenAddress
+
+	routes := []*route.State{
+		utest.MakeRoute(mediatorAddress, amount, utest.UnitSettleTimeout, utest.UnitRevealTimeout, 0, utils.NewRandomHash()),
+	}
+	currentState := makeInitiatorState(routes, utest.HOP2, amount, utest.UnitBlockNumber, utest.ADDR, token)
+	reason := rerr.ErrChannelNoEnoughBalance.Append("channel with HOP2-HOP3 can not transfer because balance not enough")
+	stateChange := &mediatedtransfer.ReceiveAnnounceDisposedStateChange{
+		Sender: mediatorAddress,
+		Token:  token,
+		Message: &encoding.AnnounceDisposed{
+			ErrorCode: reason.ErrorCode,
+			ErrorMsg:  reason.ErrorMsg,
+		},
+		Lock: &mtree.Lock{
+			Expiration:     currentState.Transfer.Expiration,
+			LockSecretHash: currentState.LockSecretHash,
+			Amount:         amount,
+		},
+	}
+	sm := transfer.NewStateManager(StateTransition, currentState, NameInitiatorTransition, utils.ShaSecret([]byte("3")), utils.NewRandomAddress())
+
+	events := sm.Dispatch(stateChange)
+	failed, ok := events[0].(*transfer.EventTransferSentFailed)
+	assert(t, ok, true)
+	assert2.Len(t, failed.Failures, 1)
+	f := failed.Failures[0]
+	assert(t, f.Hop, mediatorAddress)
+	assert(t, f.Reason, transfer.FailureReasonInsufficientBalance)
+	assert(t, f.ErrorCode, reason.ErrorCode)
+	assert(t, f.ErrorMsg, reason.ErrorMsg)
+	buf, err := json.Marshal(f)
+	assert(t, err, nil)
+	assert2.Contains(t, string(buf), `"reason":"insufficient_balance"`)
+}
 func TestRefundTransferInvalidSender(t *testing.T) {
 	amount := utest.UnitTransferAmount
 	blockNumber := utest.UnitBlockNumber
@@ -25,13 +25,15 @@ Clear current state and try a new route.
 - Add the current route to the canceled list
 - Add the current message to the canceled transfers
 */
-func cancelCurrentRoute(state *mt.InitiatorState, reason string) *transfer.TransitionResult {
+func cancelCurrentRoute(state *mt.InitiatorState, reason rerr.StandardError) *transfer.TransitionResult {
 	if state.RevealSecret != nil {
 		panic("cannot cancel a transfer with a RevealSecret in flight")
 	}
 	state.Routes.CanceledRoutes = append(state.Routes.CanceledRoutes, &route.CanceledRoute{
-		Route:  state.Route,
-		Reason: reason,
+		Route:     state.Route,
+		Reason:    reason.Error(),
+		ErrorCode: reason.ErrorCode,
+		ErrorMsg:  reason.ErrorMsg,
 	})
 	state.Message = nil
 	state.Route = nil
@@ -77,7 +79,8 @@ func tryNewRoute(state *mt.InitiatorState) *transfer.TransitionResult {
 		/*
 			发起方计算的时候应该把整条路径的费用考虑进去,也就是下面新创建的LockedTransferState中的Amount
 		*/
-		if !r.CanTransfer() || r.AvailableBalance().Cmp(new(big.Int).Add(state.Transfer.TargetAmount, r.TotalFee)) < 0 {
+		if !r.CanTransfer() || r.AvailableBalance().Cmp(new(big.Int).Add(state.Transfer.TargetAmount, r.TotalFee)) < 0 ||
+			r.Channel().CheckPendingLocksLimit(r.Channel().OurState) != nil {
 			state.Routes.IgnoredRoutes = append(state.Routes.IgnoredRoutes, r)
 		} else {
 			tryRoute = r
@@ -101,6 +104,12 @@ func tryNewRoute(state *mt.InitiatorState) *transfer.TransitionResult {
 		}
 		for _, canceledRoute := range state.Routes.CanceledRoutes {
 			transferFailed.Reason = fmt.Sprintf("%s,%s", transferFailed.Reason, canceledRoute.Reason)
+			transferFailed.Failures = append(transferFailed.Failures, &transfer.RouteFailure{
+				Hop:       canceledRoute.Route.HopNode(),
+				Reason:    mt.FailureReasonFromErrorCode(canceledRoute.ErrorCode),
+				ErrorCode: canceledRoute.ErrorCode,
+				ErrorMsg:  canceledRoute.ErrorMsg,
+			})
 		}
 		if transferFailed.Reason == "" {
 			transferFailed.Reason = "no route available"
@@ -126,7 +135,11 @@ func tryNewRoute(state *mt.InitiatorState) *transfer.TransitionResult {
 		         The two nodes will most likely disagree on latest block, as far as
 		         the expiration goes this is no problem.
 	*/
-	lockExpiration := state.BlockNumber + int64(tryRoute.SettleTimeout()) - int64(params.DefaultRevealTimeout) // - revealTimeout for test
+	revealTimeout := state.RevealTimeout
+	if revealTimeout == 0 {
+		revealTimeout = params.DefaultRevealTimeout
+	}
+	lockExpiration := state.BlockNumber + int64(tryRoute.SettleTimeout()) - int64(revealTimeout) // - revealTimeout for test
 	if lockExpiration > state.Transfer.Expiration && state.Transfer.Expiration != 0 {
 		lockExpiration = state.Transfer.Expiration
 	}
@@ -212,7 +225,7 @@ func handleRefund(state *mt.InitiatorState, stateChange *mt.ReceiveAnnounceDispo
 		it := cancelCurrentRoute(state, rerr.StandardError{
 			ErrorCode: stateChange.Message.ErrorCode,
 			ErrorMsg:  stateChange.Message.ErrorMsg,
-		}.Error())
+		})
 		ev := &mt.EventSendAnnounceDisposedResponse{
 			LockSecretHash: stateChange.Lock.LockSecretHash,
 			Token:          state.Transfer.Token,
@@ -229,7 +242,7 @@ func handleRefund(state *mt.InitiatorState, stateChange *mt.ReceiveAnnounceDispo
 
 func handleCancelRoute(state *mt.InitiatorState, stateChange *mt.ActionCancelRouteStateChange) *transfer.TransitionResult {
 	if stateChange.LockSecretHash == state.Transfer.LockSecretHash {
-		return cancelCurrentRoute(state, "initiator cancel")
+		return cancelCurrentRoute(state, rerr.ErrTransferCanceled.Append("initiator cancel"))
 	}
 	return &transfer.TransitionResult{
 		NewState: state,
@@ -319,11 +332,11 @@ func handleSecretRevealOnChain(state *mt.InitiatorState, st *mt.ContractSecretRe
 	// assume transfer succeed.
 	return &transfer.TransitionResult{
 		NewState: state,
-		Events:   transferSuccessEvents(state),
+		Events:   transferSuccessEvents(state, transfer.OutcomeSecretRegisteredOnChain),
 	}
 }
 
-func transferSuccessEvents(state *mt.InitiatorState) (events []transfer.Event) {
+func transferSuccessEvents(state *mt.InitiatorState, outcome transfer.SettlementOutcome) (events []transfer.Event) {
 	tr := state.Transfer
 	unlockLock := &mt.EventSendBalanceProof{
 		LockSecretHash:    tr.LockSecretHash,
@@ -338,9 +351,11 @@ func transferSuccessEvents(state *mt.InitiatorState) (events []transfer.Event) {
 		ChannelIdentifier: state.Route.ChannelIdentifier,
 		Token:             tr.Token,
 		Data:              tr.Data,
+		Outcome:           outcome,
 	}
 	unlockSuccess := &mt.EventUnlockSuccess{
 		LockSecretHash: tr.LockSecretHash,
+		Outcome:        outcome,
 	}
 	removeManager := &mt.EventRemoveStateManager{
 		Key: utils.Sha3(tr.LockSecretHash[:], tr.Token[:]),
@@ -371,7 +386,7 @@ func handleSecretReveal(state *mt.InitiatorState, st *mt.ReceiveSecretRevealStat
 		*/
 		return &transfer.TransitionResult{
 			NewState: nil,
-			Events:   transferSuccessEvents(state),
+			Events:   transferSuccessEvents(state, transfer.OutcomeOffChain),
 		}
 	}
 	return &transfer.TransitionResult{
@@ -418,6 +433,7 @@ func StateTransition(originalState transfer.State, st transfer.StateChange) *tra
 				CancelByExceptionSecretRequest: false,
 				IsEffectiveChain:               true, // 仅有效公链的情况下才能进行MediatedTransfer,所以默认为true
 				EffectiveChangeTimestamp:       0,
+				RevealTimeout:                  staii.RevealTimeout,
 			}
 			return tryNewRoute(state)
 		}
@@ -480,9 +496,9 @@ func StateTransition(originalState transfer.State, st transfer.StateChange) *tra
 				panic(fmt.Sprintf("secret already revealed,transfer cannot canceled"))
 			}
 		case *mt.ContractCooperativeSettledStateChange:
-			it = cancelCurrentRoute(state, "partner cooperative settle channel with me")
+			it = cancelCurrentRoute(state, rerr.ErrRouteChannelCannotTransfer.Append("partner cooperative settle channel with me"))
 		case *mt.ContractChannelWithdrawStateChange:
-			it = cancelCurrentRoute(state, "partner withdraw on channel with me")
+			it = cancelCurrentRoute(state, rerr.ErrRouteChannelCannotTransfer.Append("partner withdraw on channel with me"))
 		case *transfer.EffectiveChainStateChange:
 			state.IsEffectiveChain = st2.IsEffective
 			state.EffectiveChangeTimestamp = st2.LastBlockNumberTimestamp
@@ -433,6 +433,62 @@ func TestEventsForRefund(t *testing.T) {
 	assert(t, ev.LockSecretHash, refundTransfer.LockSecretHash)
 }
 
+func TestNextRouteFailureReason(t *testing.T) {
+	var amount = big.NewInt(10)
+	fnNextPaymentAmount := func(r *route.State) *big.Int {
+		return amount
+	}
+	fromRoute := utest.MakeRoute(utest.HOP6, amount, 0, 10, 0, utils.NewRandomHash())
+	routesState := route.NewRoutesState([]*route.State{
+		utest.MakeRoute(utest.HOP2, big.NewInt(5), 0, 10, 0, utils.NewRandomHash()),
+	})
+	r, err := nextRoute(fromRoute, routesState, 40, utils.BigInt0, fnNextPaymentAmount)
+	assert(t, r == nil, true)
+	assert(t, err.(rerr.StandardError).ErrorCode, rerr.ErrChannelNoEnoughBalance.ErrorCode)
+
+	routesState = route.NewRoutesState([]*route.State{
+		utest.MakeRoute(utest.HOP2, amount, 0, 10, 0, utils.NewRandomHash()),
+	})
+	_, err = nextRoute(fromRoute, routesState, 5, utils.BigInt0, fnNextPaymentAmount)
+	assert(t, err.(rerr.StandardError).ErrorCode, rerr.ErrRouteLockExpirationTooNear.ErrorCode)
+}
+
+func TestNextRouteTooManyLocks(t *testing.T) {
+	var amount = big.NewInt(10)
+	fnNextPaymentAmount := func(r *route.State) *big.Int {
+		return amount
+	}
+	fromRoute := utest.MakeRoute(utest.HOP6, amount, 0, 10, 0, utils.NewRandomHash())
+	r2 := utest.MakeRoute(utest.HOP2, amount, 0, 10, 0, utils.NewRandomHash())
+	ch := r2.Channel()
+	ch.MaxPendingLocks = 2
+	ch.OurState.Lock2PendingLocks[utils.NewRandomHash()] = channeltype.PendingLock{Lock: &mtree.Lock{Amount: big.NewInt(0)}}
+	r, err := nextRoute(fromRoute, route.NewRoutesState([]*route.State{r2}), 40, utils.BigInt0, fnNextPaymentAmount)
+	assert(t, err, nil)
+	assert(t, r, r2)
+
+	//到达上限,包括已知密码但是尚未 unlock 的锁
+	ch.OurState.Lock2UnclaimedLocks[utils.NewRandomHash()] = channeltype.UnlockPartialProof{Lock: &mtree.Lock{Amount: big.NewInt(0)}}
+	r, err = nextRoute(fromRoute, route.NewRoutesState([]*route.State{r2}), 40, utils.BigInt0, fnNextPaymentAmount)
+	assert(t, r == nil, true)
+	assert(t, err.(rerr.StandardError).ErrorCode, rerr.ErrTooManyLocks.ErrorCode)
+	assert(t, mediatedtransfer.FailureReasonFromErrorCode(rerr.ErrTooManyLocks.ErrorCode), transfer.FailureReasonRejected)
+}
+
+func TestForwardDownstreamFailure(t *testing.T) {
+	downstream := rerr.ErrChannelNoEnoughBalance.Append("channel with HOP3-HOP4 can not transfer because balance not enough")
+	noRoute := &mediatedtransfer.EventSendAnnounceDisposed{Reason: rerr.ErrNoAvailabeRoute}
+	tooBusy := &mediatedtransfer.EventSendAnnounceDisposed{Reason: rerr.ErrRejectTransferBecauseChannelHoldingTooMuchLock}
+	forwardDownstreamFailure([]transfer.Event{noRoute, tooBusy}, &downstream)
+	//没有其他路由可以尝试时转发下游的原因,我自己拒绝的保持不变
+	assert(t, noRoute.Reason, downstream)
+	assert(t, tooBusy.Reason, rerr.ErrRejectTransferBecauseChannelHoldingTooMuchLock)
+
+	noRoute.Reason = rerr.ErrNoAvailabeRoute
+	forwardDownstreamFailure([]transfer.Event{noRoute}, nil)
+	assert(t, noRoute.Reason, rerr.ErrNoAvailabeRoute)
+}
+
 /*
  The secret is revealed backwards to the payer once the payee sent the
     SecretReveal.
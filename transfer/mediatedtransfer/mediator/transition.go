@@ -271,7 +271,7 @@ func nextRoute(fromRoute *route.State, rss *route.RoutesState, timeoutBlocks int
 		lockTimeout := timeoutBlocks - route.RevealTimeout()
 		// 通道状态校验
 		if !route.CanTransfer() {
-			err = rerr.ErrNoAvailabeRoute.Errorf("channel with %s-%s can not transfer because state=%s",
+			err = rerr.ErrRouteChannelCannotTransfer.Errorf("channel with %s-%s can not transfer because state=%s",
 				utils.APex(ch.OurState.Address),
 				utils.APex(ch.PartnerState.Address),
 				ch.State)
@@ -280,15 +280,21 @@ func nextRoute(fromRoute *route.State, rss *route.RoutesState, timeoutBlocks int
 		}
 		// 通道余额校验
 		if route.AvailableBalance().Cmp(fnNextPaymentAmount(route)) < 0 {
-			err = rerr.ErrNoAvailabeRoute.Errorf("channel with %s-%s can not transfer because balance not enough",
+			err = rerr.ErrChannelNoEnoughBalance.Errorf("channel with %s-%s can not transfer because balance not enough",
 				utils.APex(ch.OurState.Address),
 				utils.APex(ch.PartnerState.Address))
 			rss.IgnoredRoutes = append(rss.IgnoredRoutes, route)
 			continue
 		}
+		// 我在这个通道中发出的锁的数量校验
+		if err2 := ch.CheckPendingLocksLimit(ch.OurState); err2 != nil {
+			err = err2
+			rss.IgnoredRoutes = append(rss.IgnoredRoutes, route)
+			continue
+		}
 		// 该笔交易的lock剩余时间校验
 		if lockTimeout <= 0 {
-			err = rerr.ErrNoAvailabeRoute.Errorf("channel with %s-%s can not transfer because too near to lock expiration",
+			err = rerr.ErrRouteLockExpirationTooNear.Errorf("channel with %s-%s can not transfer because too near to lock expiration",
 				utils.APex(ch.OurState.Address),
 				utils.APex(ch.PartnerState.Address))
 			rss.IgnoredRoutes = append(rss.IgnoredRoutes, route)
@@ -296,7 +302,7 @@ func nextRoute(fromRoute *route.State, rss *route.RoutesState, timeoutBlocks int
 		}
 		// 手续费校验
 		if fee.Cmp(route.Fee) < 0 {
-			err = rerr.ErrNoAvailabeRoute.Errorf("channel with %s-%s can not transfer because no enough fee: need %d ,left fee %d",
+			err = rerr.ErrRouteNoEnoughFee.Errorf("channel with %s-%s can not transfer because no enough fee: need %d ,left fee %d",
 				utils.APex(ch.OurState.Address),
 				utils.APex(ch.PartnerState.Address),
 				route.Fee.Int64(),
@@ -305,7 +311,7 @@ func nextRoute(fromRoute *route.State, rss *route.RoutesState, timeoutBlocks int
 			continue
 		}
 		if route.HopNode() == fromRoute.HopNode() {
-			err = rerr.ErrNoAvailabeRoute.Errorf("channel with %s-%s can not transfer because cycle route",
+			err = rerr.ErrRouteCycle.Errorf("channel with %s-%s can not transfer because cycle route",
 				utils.APex(ch.OurState.Address),
 				utils.APex(ch.PartnerState.Address))
 			rss.IgnoredRoutes = append(rss.IgnoredRoutes, route)
@@ -671,7 +677,10 @@ func eventsForBalanceProof(transfersPair []*mediatedtransfer.MediationPairState,
 				Receiver:          pair.PayeeRoute.HopNode(),
 			}
 			unlockSuccess := &mediatedtransfer.EventUnlockSuccess{
-				LockSecretHash: pair.PayerTransfer.LockSecretHash,
+				LockSecretHash:         pair.PayerTransfer.LockSecretHash,
+				PayerChannelIdentifier: pair.PayerRoute.ChannelIdentifier,
+				PayeeChannelIdentifier: pair.PayeeRoute.ChannelIdentifier,
+				Amount:                 pair.PayeeTransfer.Amount,
 			}
 			events = append(events, balanceProof, unlockSuccess)
 		}
@@ -760,6 +769,8 @@ func mediateTransfer(state *mediatedtransfer.MediatorState, payerRoute *route.St
 	var err error
 	if timeoutBlocks > 0 {
 		transferPair, events, err = nextTransferPair(payerRoute, payerTransfer, state.Routes, timeoutBlocks, state.BlockNumber)
+	} else {
+		err = rerr.ErrRouteLockExpirationTooNear.Errorf("timeout blocks=%d", timeoutBlocks)
 	}
 	if transferPair == nil {
 		if err != nil {
@@ -819,7 +830,7 @@ func mediateTransfer(state *mediatedtransfer.MediatorState, payerRoute *route.St
 /*
 
  */
-func cancelCurrentRoute(state *mediatedtransfer.MediatorState, refundChannelIdentify common.Hash) *transfer.TransitionResult {
+func cancelCurrentRoute(state *mediatedtransfer.MediatorState, refundChannelIdentify common.Hash, downstreamReason *rerr.StandardError) *transfer.TransitionResult {
 	var it = &transfer.TransitionResult{
 		NewState: state,
 		Events:   nil,
@@ -855,9 +866,27 @@ func cancelCurrentRoute(state *mediatedtransfer.MediatorState, refundChannelIden
 		return it
 	}
 	it = mediateTransfer(state, transferPair.PayerRoute, transferPair.PayerTransfer)
+	forwardDownstreamFailure(it.Events, downstreamReason)
 	return it
 }
 
+/*
+forwardDownstreamFailure 下游拒绝了交易,而我已经没有其他路由可以尝试,
+这时候把下游的失败原因原样告诉上家,这样发起方能够知道交易真正失败的原因,而不只是 no availabe route.
+如果尝试其他路由时又遇到了新的问题,那么告诉上家的是新的问题.
+*/
+func forwardDownstreamFailure(events []transfer.Event, downstreamReason *rerr.StandardError) {
+	if downstreamReason == nil {
+		return
+	}
+	for _, e := range events {
+		ev, ok := e.(*mediatedtransfer.EventSendAnnounceDisposed)
+		if ok && ev.Reason.ErrorCode == rerr.ErrNoAvailabeRoute.ErrorCode {
+			ev.Reason = *downstreamReason
+		}
+	}
+}
+
 /*
 又收到了一个 mediatedtransfer
 */
@@ -967,7 +996,10 @@ func handleAnnouceDisposed(state *mediatedtransfer.MediatorState, st *mediatedtr
 			 *	which means we receive refund of F, then we should assume that payeeTransfer invalid,
 			 *  which acts like receiving transfer of E, then begin to find a route again.
 			 */
-			it = cancelCurrentRoute(state, st.Message.ChannelIdentifier)
+			it = cancelCurrentRoute(state, st.Message.ChannelIdentifier, &rerr.StandardError{
+				ErrorCode: st.Message.ErrorCode,
+				ErrorMsg:  st.Message.ErrorMsg,
+			})
 			ev := &mediatedtransfer.EventSendAnnounceDisposedResponse{
 				Token:          state.Token,
 				LockSecretHash: st.Lock.LockSecretHash,
@@ -1039,6 +1071,17 @@ func handleSecretRevealOnChain(state *mediatedtransfer.MediatorState, st *mediat
 					Receiver:          route.HopNode(),
 				}
 				events = append(events, ev)
+				if !stateTransferPaidMaps[pair.PayeeState] {
+					//链下已经给下家发送过 unlock 的,之前已经有过 EventUnlockSuccess 了
+					// unlock sent off-chain before already has its EventUnlockSuccess
+					events = append(events, &mediatedtransfer.EventUnlockSuccess{
+						LockSecretHash:         pair.PayerTransfer.LockSecretHash,
+						PayerChannelIdentifier: pair.PayerRoute.ChannelIdentifier,
+						PayeeChannelIdentifier: pair.PayeeRoute.ChannelIdentifier,
+						Amount:                 pair.PayeeTransfer.Amount,
+						Outcome:                transfer.OutcomeSecretRegisteredOnChain,
+					})
+				}
 				pair.PayeeState = mediatedtransfer.StatePayeeBalanceProof
 				//至于 payer 一方,不发送也不影响我所得,需要浪费 gas 进行链上兑现.
 				// As for payer, he will not be impacted even he does not send BalanceProof, but cost gas to on-chain secret register.
@@ -1102,7 +1145,10 @@ func handleBalanceProof(state *mediatedtransfer.MediatorState, st *mediatedtrans
 				Receiver:          pair.PayeeRoute.HopNode(),
 			}
 			unlockSuccess := &mediatedtransfer.EventUnlockSuccess{
-				LockSecretHash: pair.PayerTransfer.LockSecretHash,
+				LockSecretHash:         pair.PayerTransfer.LockSecretHash,
+				PayerChannelIdentifier: pair.PayerRoute.ChannelIdentifier,
+				PayeeChannelIdentifier: pair.PayeeRoute.ChannelIdentifier,
+				Amount:                 pair.PayeeTransfer.Amount,
 			}
 			events = append(events, balanceProof, unlockSuccess)
 		}
@@ -1188,9 +1234,9 @@ func StateTransition(originalState transfer.State, stateChange transfer.StateCha
 			never receive from channel with payer
 		*/
 		case *mediatedtransfer.ContractCooperativeSettledStateChange:
-			it = cancelCurrentRoute(state, st2.ChannelIdentifier)
+			it = cancelCurrentRoute(state, st2.ChannelIdentifier, nil)
 		case *mediatedtransfer.ContractChannelWithdrawStateChange:
-			it = cancelCurrentRoute(state, st2.ChannelIdentifier.ChannelIdentifier)
+			it = cancelCurrentRoute(state, st2.ChannelIdentifier.ChannelIdentifier, nil)
 		case *transfer.EffectiveChainStateChange:
 			state.IsEffectiveChain = st2.IsEffective
 			state.EffectiveChangeTimestamp = st2.LastBlockNumberTimestamp
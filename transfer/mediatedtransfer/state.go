@@ -91,6 +91,7 @@ type InitiatorState struct {
 	CancelByExceptionSecretRequest bool // set true when receive exception SecretRequest
 	IsEffectiveChain               bool
 	EffectiveChangeTimestamp       int64
+	RevealTimeout                  int //0 for states saved by old version,use params.DefaultRevealTimeout
 }
 
 /*
@@ -134,6 +135,9 @@ const StateSecretRequest = "secret_request"
 //StateRevealSecret receive reveal secret
 const StateRevealSecret = "reveal_secret"
 
+//StateWaitingRevealConfirmation target 已经知道密码,等待上家通道被确认以后再披露
+const StateWaitingRevealConfirmation = "waiting_reveal_confirmation"
+
 //StateBalanceProof receive balance proof
 const StateBalanceProof = "balance_proof"
 
@@ -163,6 +167,7 @@ type TargetState struct {
 	Db                       channeltype.Db
 	IsEffectiveChain         bool
 	EffectiveChangeTimestamp int64
+	RevealBlockNumber        int64 //到这个块才向上家披露密码,0表示收到以后立即披露
 }
 
 /*
@@ -38,6 +38,7 @@ type ActionInitInitiatorStateChange struct {
 	Db             channeltype.Db       //get the latest channel state
 	LockSecretHash common.Hash
 	Secret         common.Hash
+	RevealTimeout  int //reveal timeout of this node when the transfer starts,lock expiration leaves this many blocks
 }
 
 //ActionInitMediatorStateChange  Initial state for a new mediator.
@@ -71,6 +72,7 @@ type ActionInitTargetStateChange struct {
 	Db                       channeltype.Db             //get the latest channel state
 	IsEffectiveChain         bool
 	EffectiveChangeTimestamp int64
+	RevealBlockNumber        int64 //到这个块才向上家披露密码,0表示收到以后立即披露
 }
 
 /*
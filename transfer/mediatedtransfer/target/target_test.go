@@ -175,6 +175,77 @@ func TestHandleSecretReveal(t *testing.T) {
 
 }
 
+func TestHandleSecretRevealConfirmed(t *testing.T) {
+	var blockNumber int64 = 1
+	var amount = big.NewInt(1)
+	var expire int64 = 20
+	initiator := utest.HOP1
+	ourAddress := utest.ADDR
+	secret := utest.UnitSecret
+	state := makeTargetState(ourAddress, amount.Int64(), blockNumber, initiator, expire)
+	state.FromRoute = utest.MakeRoute(utest.HOP2, amount, utest.UnitSettleTimeout, utest.UnitRevealTimeout, 0, utils.NewRandomHash())
+	state.RevealBlockNumber = blockNumber + 2
+	stateChange := &mediatedtransfer.ReceiveSecretRevealStateChange{
+		Secret:  secret,
+		Sender:  initiator,
+		Message: &encoding.RevealSecret{},
+	}
+	it := StateTransiton(state, stateChange)
+	assert(t, len(it.Events), 0)
+	assert(t, state.State, mediatedtransfer.StateWaitingRevealConfirmation)
+	assert(t, state.FromTransfer.Secret, secret)
+
+	//还差一个块,继续等待
+	it = StateTransiton(state, &transfer.BlockStateChange{BlockNumber: blockNumber + 1})
+	assert(t, len(it.Events), 0)
+	it = StateTransiton(state, &transfer.BlockStateChange{BlockNumber: blockNumber + 2})
+	if assert(t, len(it.Events), 1) {
+		ev := it.Events[0].(*mediatedtransfer.EventSendRevealSecret)
+		assert(t, ev.Secret, secret)
+		assert(t, ev.Receiver, utest.HOP2)
+	}
+	assert(t, state.State, mediatedtransfer.StateRevealSecret)
+	//只披露一次
+	it = StateTransiton(state, &transfer.BlockStateChange{BlockNumber: blockNumber + 3})
+	assert(t, len(it.Events), 0)
+}
+
+func TestHandleSecretRegisteredOnChain(t *testing.T) {
+	var blockNumber int64 = 1
+	var amount = big.NewInt(1)
+	var expire = int64(utest.UnitRevealTimeout) + blockNumber
+	initiator := utest.HOP1
+	ourAddress := utest.ADDR
+	secret := utest.UnitSecret
+	state := makeTargetState(ourAddress, amount.Int64(), blockNumber, initiator, expire)
+	st := &mediatedtransfer.ContractSecretRevealOnChainStateChange{
+		Secret:         secret,
+		LockSecretHash: state.FromTransfer.LockSecretHash,
+		BlockNumber:    expire,
+	}
+	it := handleSecretRegisteredOnChain(state, st)
+	var received *transfer.EventTransferReceivedSuccess
+	for _, e := range it.Events {
+		if e2, ok := e.(*transfer.EventTransferReceivedSuccess); ok {
+			received = e2
+		}
+	}
+	if assert(t, received != nil, true) {
+		assert(t, received.Outcome, transfer.OutcomeSecretRegisteredOnChain)
+		assert(t, received.Amount, amount)
+		assert(t, received.Initiator, initiator)
+	}
+
+	//锁已经过期,链上注册也没有用了
+	state = makeTargetState(ourAddress, amount.Int64(), blockNumber, initiator, expire)
+	st.BlockNumber = expire + 1
+	it = handleSecretRegisteredOnChain(state, st)
+	for _, e := range it.Events {
+		_, ok := e.(*transfer.EventTransferReceivedSuccess)
+		assert(t, ok, false)
+	}
+}
+
 func TestHandleBlock(t *testing.T) {
 	initiator := utest.HOP6
 	ourAddress := utest.ADDR
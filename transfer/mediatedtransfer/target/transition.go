@@ -54,6 +54,7 @@ func handleInitTarget(st *mediatedtransfer.ActionInitTargetStateChange) *transfe
 		Db:                       st.Db,
 		IsEffectiveChain:         st.IsEffectiveChain,
 		EffectiveChangeTimestamp: st.EffectiveChangeTimestamp,
+		RevealBlockNumber:        st.RevealBlockNumber,
 	}
 	safeToWait := mediator.IsSafeToWait(tr, route.RevealTimeout(), blockNumber)
 	/*
@@ -101,6 +102,18 @@ func handleSecretRegisteredOnChain(state *mediatedtransfer.TargetState, st *medi
 		events = append(events, ev)
 		state.Secret = st.Secret
 		state.FromTransfer.Secret = st.Secret
+		if st.BlockNumber <= state.FromTransfer.Expiration {
+			//密码在锁过期之前注册到了链上,这笔钱我一定能拿到,交易成功
+			// secret registered before the lock expired, the transfer succeeds with an on-chain claim
+			events = append(events, &transfer.EventTransferReceivedSuccess{
+				LockSecretHash:    state.FromTransfer.LockSecretHash,
+				Amount:            state.FromTransfer.Amount,
+				Initiator:         state.FromTransfer.Initiator,
+				ChannelIdentifier: state.FromRoute.ChannelIdentifier,
+				Data:              state.FromTransfer.Data,
+				Outcome:           transfer.OutcomeSecretRegisteredOnChain,
+			})
+		}
 		//链上注册没有过期,并且通道已经关闭,说明我还需要再次unlock
 		if st.BlockNumber < state.FromTransfer.Expiration && state.FromRoute.State() == channeltype.StateClosed {
 			events = append(events, &mediatedtransfer.EventContractSendUnlock{
@@ -126,21 +139,19 @@ func handleSecretReveal(state *mediatedtransfer.TargetState, st *mediatedtransfe
 	var events []transfer.Event
 	if validSecret && !isExpired {
 		tr := state.FromTransfer
-		route := state.FromRoute
-		state.State = mediatedtransfer.StateRevealSecret
 		// 仅在第一次收到reveal secret消息的时候,保留data字段
 		if tr.Secret == utils.EmptyHash {
 			tr.Data = string(st.Message.Data)
 		}
 		tr.Secret = st.Secret
-		reveal := &mediatedtransfer.EventSendRevealSecret{
-			LockSecretHash: tr.LockSecretHash,
-			Secret:         tr.Secret,
-			Token:          tr.Token,
-			Receiver:       route.HopNode(),
-			Sender:         state.OurAddress,
+		if state.BlockNumber < state.RevealBlockNumber {
+			//上家通道还没有被足够的块确认,先不披露,等 handleBlock
+			state.State = mediatedtransfer.StateWaitingRevealConfirmation
+			log.Info(fmt.Sprintf("target delay reveal secret of %s until block %d", utils.HPex(tr.LockSecretHash), state.RevealBlockNumber))
+		} else {
+			state.State = mediatedtransfer.StateRevealSecret
+			events = append(events, eventRevealSecretToPayer(state))
 		}
-		events = append(events, reveal)
 	} else {
 		// TODO: event for byzantine behavior
 	}
@@ -151,6 +162,16 @@ func handleSecretReveal(state *mediatedtransfer.TargetState, st *mediatedtransfe
 	return
 }
 
+func eventRevealSecretToPayer(state *mediatedtransfer.TargetState) *mediatedtransfer.EventSendRevealSecret {
+	return &mediatedtransfer.EventSendRevealSecret{
+		LockSecretHash: state.FromTransfer.LockSecretHash,
+		Secret:         state.FromTransfer.Secret,
+		Token:          state.FromTransfer.Token,
+		Receiver:       state.FromRoute.HopNode(),
+		Sender:         state.OurAddress,
+	}
+}
+
 /*
 我收到了对方的 unlock 消息以后,就算是彻底结束了.
 */
@@ -189,8 +210,14 @@ func handleBlock(state *mediatedtransfer.TargetState, st *transfer.BlockStateCha
 
 	*/
 	var events []transfer.Event
+	//上家通道已经被足够的块确认,可以披露密码了
+	if state.State == mediatedtransfer.StateWaitingRevealConfirmation && state.BlockNumber >= state.RevealBlockNumber &&
+		state.BlockNumber <= state.FromTransfer.Expiration {
+		state.State = mediatedtransfer.StateRevealSecret
+		events = append(events, eventRevealSecretToPayer(state))
+	}
 	if state.State != mediatedtransfer.StateWaitingRegisterSecret && state.State != mediatedtransfer.StateSecretRegistered {
-		events = eventsForRegisterSecret(state)
+		events = append(events, eventsForRegisterSecret(state)...)
 	}
 	it = &transfer.TransitionResult{
 		NewState: state,
@@ -98,8 +98,10 @@ func (rs *State) StateName() string {
 
 // CanceledRoute 保存失败原因
 type CanceledRoute struct {
-	Route  *State
-	Reason string
+	Route     *State
+	Reason    string
+	ErrorCode int    //失败原因对应的 rerr 错误码
+	ErrorMsg  string //不包含错误码的失败原因
 }
 
 /*
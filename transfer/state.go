@@ -97,6 +97,15 @@ func (bpf *BalanceProofState) IsBalanceProofValid() bool {
 	return err == nil && utils.PubkeyToAddress(pubkey) != utils.EmptyAddress
 }
 
+/*
+Signer 按照合约的格式恢复这个 balance proof 的签名者,
+和 IsBalanceProofValid 不同,调用者需要比较签名者是不是预期的节点
+*/
+func (bpf *BalanceProofState) Signer() (common.Address, error) {
+	bp := encoding.NewBalanceProof(bpf.Nonce, bpf.TransferAmount, bpf.LocksRoot, &bpf.ChannelIdentifier)
+	return encoding.BalanceProofSigner(bp, bpf.MessageHash, bpf.Signature)
+}
+
 //StateName name of state
 func (bpf *BalanceProofState) StateName() string {
 	return "BalanceProofState"
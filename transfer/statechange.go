@@ -66,7 +66,17 @@ type EffectiveChainStateChange struct {
 	LastBlockNumberTimestamp int64
 }
 
+/*
+ChainReorgStateChange 公链发生了分叉,ForkBlockNumber 及以后的块被替换了,
+这些块中打包的 tx 可能已经不在链上了
+*/
+type ChainReorgStateChange struct {
+	ForkBlockNumber int64
+	BlockNumber     int64 //发现分叉时的最新块
+}
+
 func init() {
+	gob.Register(&ChainReorgStateChange{})
 	gob.Register(&BlockStateChange{})
 	gob.Register(&ActionCancelTransferStateChange{})
 	gob.Register(&ActionTransferDirectStateChange{})
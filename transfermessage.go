@@ -0,0 +1,44 @@
+package photon
+
+import (
+	"github.com/SmartMeshFoundation/Photon/encoding"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+GetTransferMessages 返回交易 lockSecretHash 收发过的所有签名消息,按照保存的先后顺序排列,
+包括 MediatedTransfer,SecretRequest,RevealSecret 以及 Unlock,可以作为争议的证据.
+消息在通道 settle 以后删除.
+*/
+func (rs *Service) GetTransferMessages(lockSecretHash common.Hash) (msgs []encoding.SignedMessager, err error) {
+	list, err := rs.dao.GetTransferMessages(lockSecretHash)
+	if err != nil {
+		return
+	}
+	for _, tm := range list {
+		msgs = append(msgs, tm.Message)
+	}
+	return
+}
+
+/*
+saveTransferMessage 只保存和交易相关的消息,其他消息直接忽略
+*/
+func (rs *Service) saveTransferMessage(msg encoding.SignedMessager, isSent bool) {
+	var lockSecretHash common.Hash
+	channelIdentifier := utils.EmptyHash
+	switch m := msg.(type) {
+	case *encoding.MediatedTransfer:
+		lockSecretHash, channelIdentifier = m.LockSecretHash, m.ChannelIdentifier
+	case *encoding.UnLock:
+		lockSecretHash, channelIdentifier = m.LockSecretHash(), m.ChannelIdentifier
+	case *encoding.SecretRequest:
+		lockSecretHash = m.LockSecretHash
+	case *encoding.RevealSecret:
+		lockSecretHash = m.LockSecretHash()
+	default:
+		return
+	}
+	rs.dao.NewTransferMessage(lockSecretHash, channelIdentifier, msg, isSent)
+}
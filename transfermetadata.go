@@ -0,0 +1,42 @@
+package photon
+
+import (
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+checkTransferMetadata 交易元数据只保存在本地,但是会随 SentTransferDetail 一起存入数据库,
+所以限制项数和每一项的长度
+*/
+func checkTransferMetadata(metadata map[string]string) error {
+	if len(metadata) > params.MaxTransferMetadataKeys {
+		return rerr.ErrArgumentError.Printf("too many metadata entries %d,max %d", len(metadata), params.MaxTransferMetadataKeys)
+	}
+	for k, v := range metadata {
+		if len(k) == 0 || len(k) > params.MaxTransferMetadataKeyLen {
+			return rerr.ErrArgumentError.Printf("invalid metadata key %q,length must be between 1 and %d", k, params.MaxTransferMetadataKeyLen)
+		}
+		if len(v) > params.MaxTransferMetadataValueLen {
+			return rerr.ErrArgumentError.Printf("metadata value of %q too long,max %d", k, params.MaxTransferMetadataValueLen)
+		}
+	}
+	return nil
+}
+
+/*
+saveTransferMetadata 交易发起以后把元数据写入对应的 SentTransferDetail,
+只能在主线程中调用,交易没有发起时 lockSecretHash 为空,什么也不做
+*/
+func (rs *Service) saveTransferMetadata(tokenAddress common.Address, lockSecretHash common.Hash, metadata map[string]string) {
+	if len(metadata) == 0 || lockSecretHash == utils.EmptyHash {
+		return
+	}
+	m := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		m[k] = v
+	}
+	rs.dao.UpdateSentTransferDetailMetadata(tokenAddress, lockSecretHash, m)
+}
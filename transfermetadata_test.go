@@ -0,0 +1,57 @@
+package photon
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckTransferMetadata(t *testing.T) {
+	assert.Nil(t, checkTransferMetadata(nil))
+	assert.Nil(t, checkTransferMetadata(map[string]string{"invoice": "2019-0001", "customer": ""}))
+
+	tooMany := make(map[string]string)
+	for i := 0; i <= params.MaxTransferMetadataKeys; i++ {
+		tooMany[utils.RandomString(8)] = "v"
+	}
+	for _, m := range []map[string]string{
+		tooMany,
+		{"": "v"},
+		{strings.Repeat("k", params.MaxTransferMetadataKeyLen+1): "v"},
+		{"invoice": strings.Repeat("v", params.MaxTransferMetadataValueLen+1)},
+	} {
+		err := checkTransferMetadata(m)
+		if assert.NotNil(t, err) {
+			assert.Equal(t, rerr.ErrArgumentError.ErrorCode, err.(rerr.StandardError).ErrorCode)
+		}
+	}
+}
+
+func TestSaveTransferMetadata(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	rs := &Service{dao: dao}
+	token := utils.NewRandomAddress()
+	lockSecretHash := utils.NewRandomHash()
+	dao.NewSentTransferDetail(token, utils.NewRandomAddress(), big.NewInt(1), "", false, lockSecretHash)
+
+	metadata := map[string]string{"invoice": "2019-0001"}
+	rs.saveTransferMetadata(token, lockSecretHash, metadata)
+	//保存的是副本,调用者之后修改不会影响数据库中的值
+	metadata["invoice"] = "changed"
+	std, err := dao.GetSentTransferDetail(token, lockSecretHash)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"invoice": "2019-0001"}, std.Metadata)
+
+	//交易没有发起时什么也不做
+	rs.saveTransferMetadata(token, utils.EmptyHash, metadata)
+	list, err := dao.GetSentTransferDetailList(token, -1, -1, -1, -1)
+	assert.Nil(t, err)
+	assert.Len(t, list, 1)
+}
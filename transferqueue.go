@@ -0,0 +1,186 @@
+package photon
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/pfsproxy"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+queuedTransfer 暂时没有可用路由的交易,
+在新块或者通道,余额变化的时候重试,直到成功发起或者超过Deadline.
+进入队列时就确定密码,调用者立即可以拿到 LockSecretHash
+*/
+type queuedTransfer struct {
+	QueueID        common.Hash
+	TokenAddress   common.Address
+	Target         common.Address
+	Amount         *big.Int
+	Secret         common.Hash
+	LockSecretHash common.Hash
+	Data           string
+	RouteInfo      []pfsproxy.FindPathResponse
+	Metadata       map[string]string
+	Deadline       time.Time
+	Attempts       int
+	result         *utils.AsyncResult
+}
+
+//QueuedTransferDetail info of a queued transfer for user
+type QueuedTransferDetail struct {
+	QueueID        common.Hash    `json:"queue_id"`
+	TokenAddress   common.Address `json:"token_address"`
+	Target         common.Address `json:"target_address"`
+	Amount         *big.Int       `json:"amount"`
+	LockSecretHash common.Hash    `json:"lock_secret_hash"`
+	Data           string         `json:"data"`
+	Deadline       time.Time      `json:"deadline"`
+	Attempts       int            `json:"attempts"`
+}
+
+/*
+startOrQueueMediatedTransfer 有路由就直接发起交易,否则进入队列等待
+*/
+func (rs *Service) startOrQueueMediatedTransfer(r *transferReq) (result *utils.AsyncResult) {
+	if rs.hasAvailableRoute(r.TokenAddress, r.Target, r.Amount, r.RouteInfo) {
+		return rs.startMediatedTransfer(r.TokenAddress, r.Target, r.Amount, r.Secret, r.Data, r.RouteInfo)
+	}
+	result = utils.NewAsyncResult()
+	if rs.getToken2ChannelGraph(r.TokenAddress) == nil {
+		result.Result <- rerr.ErrTokenNotFound
+		return
+	}
+	queueID := r.QueueID
+	if queueID == utils.EmptyHash {
+		queueID = utils.NewRandomHash()
+	}
+	q := &queuedTransfer{
+		QueueID:      queueID,
+		TokenAddress: r.TokenAddress,
+		Target:       r.Target,
+		Amount:       r.Amount,
+		Data:         r.Data,
+		RouteInfo:    r.RouteInfo,
+		Metadata:     r.Metadata,
+		Deadline:     r.QueueDeadline,
+		result:       result,
+	}
+	//调用者拿到 result 以后不能再修改 LockSecretHash
+	q.Secret, q.LockSecretHash = rs.newTransferSecret(r.Secret)
+	result.LockSecretHash = q.LockSecretHash
+	rs.queuedTransfers[q.QueueID] = q
+	log.Info(fmt.Sprintf("no route for transfer to %s amount=%s now, queued %s until %s",
+		utils.APex2(r.Target), r.Amount, utils.HPex(q.QueueID), q.Deadline))
+	return
+}
+
+func (rs *Service) hasAvailableRoute(tokenAddress, target common.Address, amount *big.Int, routeInfo []pfsproxy.FindPathResponse) bool {
+	g := rs.getToken2ChannelGraph(tokenAddress)
+	if g == nil {
+		return false
+	}
+	return len(rs.findAvailableRoutes(g, tokenAddress, target, amount, routeInfo)) > 0
+}
+
+/*
+channelChanged 通道或者余额发生了变化,主线程处理完当前事件以后重试排队的交易.
+收到的大部分消息不会带来新的路由,所以不在每次收到消息的时候重试
+*/
+func (rs *Service) channelChanged() {
+	if len(rs.queuedTransfers) > 0 {
+		rs.queueChanged = true
+	}
+}
+
+/*
+retryQueuedTransfersIfChanged 只能在主线程中调用
+*/
+func (rs *Service) retryQueuedTransfersIfChanged() {
+	if !rs.queueChanged {
+		return
+	}
+	rs.queueChanged = false
+	rs.retryQueuedTransfers()
+}
+
+//removeQueuedTransfer 交易不会再发起,用户指定的密码不再需要保护
+func (rs *Service) removeQueuedTransfer(q *queuedTransfer, err error) {
+	delete(rs.queuedTransfers, q.QueueID)
+	delete(rs.SecretRequestPredictorMap, q.LockSecretHash)
+	q.result.Result <- err
+}
+
+/*
+retryQueuedTransfers 只能在主线程中调用
+*/
+func (rs *Service) retryQueuedTransfers() {
+	if len(rs.queuedTransfers) == 0 || !rs.IsChainEffective {
+		return
+	}
+	now := rs.Clock.Now()
+	for id, q := range rs.queuedTransfers {
+		if now.After(q.Deadline) {
+			rs.removeQueuedTransfer(q, rerr.ErrNoAvailabeRoute.Printf("no route before deadline %s after %d attempts", q.Deadline, q.Attempts))
+			continue
+		}
+		//暂停期间只处理超时
+		if rs.paused {
+			continue
+		}
+		q.Attempts++
+		if rs.checkInFlightTransfers() != nil {
+			continue
+		}
+		if !rs.hasAvailableRoute(q.TokenAddress, q.Target, q.Amount, q.RouteInfo) {
+			continue
+		}
+		delete(rs.queuedTransfers, id)
+		log.Info(fmt.Sprintf("route available for queued transfer %s,start it", utils.HPex(id)))
+		r := rs.startMediatedTransferWithSecret(q.TokenAddress, q.Target, q.Amount, q.Secret, q.LockSecretHash, q.Data, q.RouteInfo)
+		rs.saveTransferMetadata(q.TokenAddress, q.LockSecretHash, q.Metadata)
+		go func(q *queuedTransfer) {
+			err := <-r.Result
+			//Tag 在 Result 之前设置,调用者收到 Result 以后才会读取
+			q.result.Tag = r.Tag
+			q.result.Result <- err
+		}(q)
+	}
+}
+
+func (rs *Service) getQueuedTransfers() (result *utils.AsyncResult) {
+	result = utils.NewAsyncResult()
+	var details []*QueuedTransferDetail
+	for _, q := range rs.queuedTransfers {
+		details = append(details, &QueuedTransferDetail{
+			QueueID:        q.QueueID,
+			TokenAddress:   q.TokenAddress,
+			Target:         q.Target,
+			Amount:         q.Amount,
+			LockSecretHash: q.LockSecretHash,
+			Data:           q.Data,
+			Deadline:       q.Deadline,
+			Attempts:       q.Attempts,
+		})
+	}
+	result.Tag = details
+	result.Result <- nil
+	return
+}
+
+func (rs *Service) cancelQueuedTransfer(queueID common.Hash) (result *utils.AsyncResult) {
+	result = utils.NewAsyncResult()
+	q, ok := rs.queuedTransfers[queueID]
+	if !ok {
+		result.Result <- rerr.ErrTransferNotFound.Printf("queued transfer %s not found", queueID.String())
+		return
+	}
+	rs.removeQueuedTransfer(q, rerr.ErrTransferCanceled)
+	result.Result <- nil
+	return
+}
@@ -0,0 +1,124 @@
+package photon
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/network/graph"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/notify"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/pfsproxy"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/SmartMeshFoundation/Photon/utils/utest"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func newQueueTestService(t *testing.T) (rs *Service, c *channel.Channel) {
+	our, partner, token := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	c, err := channel.NewChannel(channel.NewChannelEndState(our, big.NewInt(100), nil, mtree.EmptyTree),
+		channel.NewChannelEndState(partner, big.NewInt(0), nil, mtree.EmptyTree), &channel.ExternalState{}, token,
+		&contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash(), OpenBlockNumber: 3}, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewChannelGraph(our, token, nil)
+	assert.Nil(t, g.AddChannel(c))
+	rs = &Service{
+		NodeAddress:               our,
+		Config:                    &params.Config{},
+		NotifyHandler:             notify.NewNotifyHandler(),
+		Clock:                     utest.NewFakeClock(time.Now()),
+		IsChainEffective:          true,
+		Token2ChannelGraph:        map[common.Address]*graph.ChannelGraph{token: g},
+		queuedTransfers:           make(map[common.Hash]*queuedTransfer),
+		SecretRequestPredictorMap: make(map[common.Hash]SecretRequestPredictor),
+		dao:                       codefortest.NewTestDB(""),
+	}
+	return
+}
+
+//没有路由的交易进入队列,调用者立即可以拿到 LockSecretHash 和 QueueID
+func TestQueueTransfer(t *testing.T) {
+	rs, c := newQueueTestService(t)
+	defer rs.dao.CloseDB()
+	//指定的路由上没有通道
+	noRoute := []pfsproxy.FindPathResponse{{Result: []string{utils.NewRandomAddress().String()}}}
+	secret, queueID := utils.NewRandomHash(), utils.NewRandomHash()
+	result := rs.startOrQueueMediatedTransfer(&transferReq{
+		TokenAddress:  c.TokenAddress,
+		Target:        utils.NewRandomAddress(),
+		Amount:        big.NewInt(10),
+		Secret:        secret,
+		RouteInfo:     noRoute,
+		QueueDeadline: rs.Clock.Now().Add(time.Minute),
+		QueueID:       queueID,
+	})
+	assert.Equal(t, utils.ShaSecret(secret[:]), result.LockSecretHash)
+	assert.NotNil(t, rs.SecretRequestPredictorMap[result.LockSecretHash], "user's secret must not be sent before allowed")
+	r := rs.getQueuedTransfers()
+	assert.Nil(t, <-r.Result)
+	details := r.Tag.([]*QueuedTransferDetail)
+	if assert.Len(t, details, 1) {
+		assert.Equal(t, queueID, details[0].QueueID)
+		assert.Equal(t, result.LockSecretHash, details[0].LockSecretHash)
+	}
+	select {
+	case err := <-result.Result:
+		t.Fatalf("queued transfer finished,err=%v", err)
+	default:
+	}
+
+	//没有指定密码时,进入队列时就生成
+	result2 := rs.startOrQueueMediatedTransfer(&transferReq{
+		TokenAddress:  c.TokenAddress,
+		Target:        utils.NewRandomAddress(),
+		Amount:        big.NewInt(10),
+		RouteInfo:     noRoute,
+		QueueDeadline: rs.Clock.Now().Add(time.Minute),
+	})
+	assert.NotEqual(t, utils.EmptyHash, result2.LockSecretHash)
+	assert.Len(t, rs.queuedTransfers, 2)
+
+	assert.Nil(t, <-rs.cancelQueuedTransfer(queueID).Result)
+	assert.Equal(t, rerr.ErrTransferCanceled, <-result.Result)
+	assert.Nil(t, rs.SecretRequestPredictorMap[result.LockSecretHash])
+	err := <-rs.cancelQueuedTransfer(queueID).Result
+	assert.Equal(t, rerr.ErrTransferNotFound.ErrorCode, err.(rerr.StandardError).ErrorCode)
+}
+
+//只有通道或者余额变化以后才重试
+func TestRetryQueuedTransfersOnChannelChange(t *testing.T) {
+	rs, c := newQueueTestService(t)
+	defer rs.dao.CloseDB()
+	assert.Nil(t, rs.dao.NewChannel(channel.NewChannelSerialization(c)))
+	//没有排队的交易时不需要重试
+	assert.Nil(t, rs.UpdateChannelNoTx(channel.NewChannelSerialization(c)))
+	assert.False(t, rs.queueChanged)
+
+	result := rs.startOrQueueMediatedTransfer(&transferReq{
+		TokenAddress:  c.TokenAddress,
+		Target:        utils.NewRandomAddress(),
+		Amount:        big.NewInt(10),
+		RouteInfo:     []pfsproxy.FindPathResponse{{Result: []string{utils.NewRandomAddress().String()}}},
+		QueueDeadline: rs.Clock.Now().Add(time.Minute),
+	})
+	rs.Clock.(*utest.FakeClock).Advance(2 * time.Minute)
+	//通道没有变化,不会重试
+	rs.retryQueuedTransfersIfChanged()
+	assert.Len(t, rs.queuedTransfers, 1)
+
+	assert.Nil(t, rs.UpdateChannelNoTx(channel.NewChannelSerialization(c)))
+	assert.True(t, rs.queueChanged)
+	rs.retryQueuedTransfersIfChanged()
+	assert.False(t, rs.queueChanged)
+	assert.Len(t, rs.queuedTransfers, 0)
+	err := <-result.Result
+	assert.Equal(t, rerr.ErrNoAvailabeRoute.ErrorCode, err.(rerr.StandardError).ErrorCode)
+}
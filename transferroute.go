@@ -0,0 +1,33 @@
+package photon
+
+import (
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+GetTransferRoute 查询我发起的交易实际使用的路径,从第一个节点到接收方.
+发起方在交易过程中可能换路径(secret 不变),每次发出 MediatedTransfer 都会记录当前使用的路径,
+所以交易成功以后返回的就是最终使用的路径;交易还没有结束时 inProgress 为 true,返回的是当前尝试的路径.
+直接交易的路径只有接收方;从来没有找到路径的交易返回 ErrNoAvailabeRoute.
+只读取数据库,可以在任意线程中调用
+*/
+func (rs *Service) GetTransferRoute(tokenAddress common.Address, lockSecretHash common.Hash) (route []common.Address, inProgress bool, err error) {
+	std, err := rs.dao.GetSentTransferDetail(tokenAddress, lockSecretHash)
+	if err != nil {
+		return nil, false, rerr.ErrTransferNotFound.Printf("transfer of token %s lockSecretHash %s not found", tokenAddress.String(), lockSecretHash.String())
+	}
+	inProgress = std.Status != models.TransferStatusSuccess &&
+		std.Status != models.TransferStatusCanceled &&
+		std.Status != models.TransferStatusFailed
+	if std.IsDirect {
+		return []common.Address{std.TargetAddress}, inProgress, nil
+	}
+	if len(std.Route) == 0 {
+		return nil, inProgress, rerr.ErrNoAvailabeRoute.Printf("transfer %s has not found a route yet,status=%d %s", lockSecretHash.String(), std.Status, std.StatusMessage)
+	}
+	route = make([]common.Address, len(std.Route))
+	copy(route, std.Route)
+	return
+}
@@ -0,0 +1,57 @@
+package photon
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetTransferRoute(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	rs := &Service{dao: dao}
+	token, target := utils.NewRandomAddress(), utils.NewRandomAddress()
+	lockSecretHash := utils.NewRandomHash()
+
+	_, _, err := rs.GetTransferRoute(token, lockSecretHash)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, rerr.ErrTransferNotFound.ErrorCode, err.(rerr.StandardError).ErrorCode)
+	}
+
+	//还没有找到路径
+	dao.NewSentTransferDetail(token, target, big.NewInt(1), "", false, lockSecretHash)
+	_, inProgress, err := rs.GetTransferRoute(token, lockSecretHash)
+	assert.True(t, inProgress)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, rerr.ErrNoAvailabeRoute.ErrorCode, err.(rerr.StandardError).ErrorCode)
+	}
+
+	//换路径以后返回的是当前尝试的路径
+	first := []common.Address{utils.NewRandomAddress(), target}
+	second := []common.Address{utils.NewRandomAddress(), utils.NewRandomAddress(), target}
+	dao.UpdateSentTransferDetailRoute(token, lockSecretHash, first)
+	dao.UpdateSentTransferDetailRoute(token, lockSecretHash, second)
+	route, inProgress, err := rs.GetTransferRoute(token, lockSecretHash)
+	assert.Nil(t, err)
+	assert.True(t, inProgress)
+	assert.Equal(t, second, route)
+
+	dao.UpdateSentTransferDetailStatus(token, lockSecretHash, models.TransferStatusSuccess, "success", nil)
+	route, inProgress, err = rs.GetTransferRoute(token, lockSecretHash)
+	assert.Nil(t, err)
+	assert.False(t, inProgress)
+	assert.Equal(t, second, route)
+
+	//直接交易
+	directHash := utils.NewRandomHash()
+	dao.NewSentTransferDetail(token, target, big.NewInt(1), "", true, directHash)
+	route, _, err = rs.GetTransferRoute(token, directHash)
+	assert.Nil(t, err)
+	assert.Equal(t, []common.Address{target}, route)
+}
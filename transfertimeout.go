@@ -0,0 +1,76 @@
+package photon
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+timedTransfer 设置了超时的交易,超过 Deadline 密码还没有泄露就自动撤销,
+调用者在有限的时间内得到结果,而不用等到锁在链上过期
+*/
+type timedTransfer struct {
+	LockSecretHash common.Hash
+	TokenAddress   common.Address
+	Deadline       time.Time
+	result         *utils.AsyncResult
+}
+
+/*
+startMediatedTransferWithTimeout 发起交易,并在每个新块检查是否超时
+*/
+func (rs *Service) startMediatedTransferWithTimeout(r *transferReq) (result *utils.AsyncResult) {
+	result = rs.startMediatedTransfer(r.TokenAddress, r.Target, r.Amount, r.Secret, r.Data, r.RouteInfo)
+	smkey := utils.Sha3(result.LockSecretHash[:], r.TokenAddress[:])
+	if rs.Transfer2StateManager[smkey] == nil { //没有发起成功
+		return
+	}
+	rs.timedTransfers[smkey] = &timedTransfer{
+		LockSecretHash: result.LockSecretHash,
+		TokenAddress:   r.TokenAddress,
+		Deadline:       r.CancelDeadline,
+		result:         result,
+	}
+	return
+}
+
+/*
+cancelTimedOutTransfers 只能在主线程中调用.
+超时的交易如果还能撤销,先以 ErrTransferTimeout 结束,再撤销,撤销引起的失败通知会被丢弃;
+密码已经泄露的交易不能撤销,只能等待它正常结束.
+*/
+func (rs *Service) cancelTimedOutTransfers() {
+	if len(rs.timedTransfers) == 0 {
+		return
+	}
+	now := rs.Clock.Now()
+	for key, t := range rs.timedTransfers {
+		if rs.Transfer2StateManager[key] == nil { //交易已经结束
+			delete(rs.timedTransfers, key)
+			continue
+		}
+		if now.Before(t.Deadline) {
+			continue
+		}
+		delete(rs.timedTransfers, key)
+		req := &cancelTransferReq{
+			LockSecretHash: t.LockSecretHash,
+			TokenAddress:   t.TokenAddress,
+		}
+		if _, err := rs.checkCancelTransfer(req); err != nil {
+			log.Info(fmt.Sprintf("transfer %s timeout,but cannot cancel:%s,wait for it to finish", utils.HPex(t.LockSecretHash), err),
+				utils.TransferLogCtx(t.LockSecretHash, t.TokenAddress)...)
+			continue
+		}
+		t.result.SetResult(rerr.ErrTransferTimeout.Printf("secret is not revealed before %s,transfer canceled", t.Deadline))
+		err := <-rs.cancelTransfer(req).Result
+		if err != nil {
+			log.Error(fmt.Sprintf("cancel timeout transfer %s err %s", utils.HPex(t.LockSecretHash), err))
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package photon
+
+import (
+	"math/big"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/notify"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/transfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer/initiator"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/SmartMeshFoundation/Photon/utils/utest"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCancelTimedOutTransfers(t *testing.T) {
+	dbPath := path.Join(os.TempDir(), "testtimedtransfer.db")
+	os.RemoveAll(dbPath)
+	os.RemoveAll(dbPath + ".lock")
+	dao := codefortest.NewTestDB(dbPath)
+	defer dao.CloseDB()
+	clock := utest.NewFakeClock(time.Now())
+	rs := &Service{
+		dao:                   dao,
+		Clock:                 clock,
+		NotifyHandler:         notify.NewNotifyHandler(),
+		Transfer2StateManager: make(map[common.Hash]*transfer.StateManager),
+		timedTransfers:        make(map[common.Hash]*timedTransfer),
+	}
+	rs.StateMachineEventHandler = newStateMachineEventHandler(rs)
+	token := utils.NewRandomAddress()
+	noop := func(state transfer.State, stateChange transfer.StateChange) *transfer.TransitionResult {
+		return &transfer.TransitionResult{NewState: state}
+	}
+	newTimedTransfer := func(name string, status models.TransferStatusCode) *timedTransfer {
+		lockSecretHash := utils.NewRandomHash()
+		smkey := utils.Sha3(lockSecretHash[:], token[:])
+		rs.Transfer2StateManager[smkey] = transfer.NewStateManager(noop, nil, name, lockSecretHash, token)
+		dao.NewSentTransferDetail(token, utils.NewRandomAddress(), big.NewInt(1), "", false, lockSecretHash)
+		dao.UpdateSentTransferDetailStatus(token, lockSecretHash, status, "", nil)
+		tt := &timedTransfer{
+			LockSecretHash: lockSecretHash,
+			TokenAddress:   token,
+			Deadline:       clock.Now().Add(time.Minute),
+			result:         utils.NewAsyncResult(),
+		}
+		rs.timedTransfers[smkey] = tt
+		return tt
+	}
+	cancelable := newTimedTransfer(initiator.NameInitiatorTransition, models.TransferStatusCanCancel)
+	revealed := newTimedTransfer(initiator.NameInitiatorTransition, models.TransferStatusCanNotCancel)
+
+	rs.cancelTimedOutTransfers()
+	assert.Len(t, rs.timedTransfers, 2)
+
+	clock.Advance(2 * time.Minute)
+	rs.cancelTimedOutTransfers()
+	assert.Empty(t, rs.timedTransfers)
+	err := <-cancelable.result.Result
+	assert.EqualValues(t, rerr.ErrTransferTimeout.ErrorCode, err.(rerr.StandardError).ErrorCode)
+	std, err := dao.GetSentTransferDetail(token, cancelable.LockSecretHash)
+	assert.Nil(t, err)
+	assert.EqualValues(t, models.TransferStatusCanceled, std.Status)
+	//密码已经泄露的交易只能等待正常结束
+	assert.Len(t, revealed.result.Result, 0)
+}
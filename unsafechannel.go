@@ -0,0 +1,59 @@
+package photon
+
+import (
+	"fmt"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/notify"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//UnsafeChannelEvent 对方打开了 settle timeout 太短的通道,我没有使用这个通道
+type UnsafeChannelEvent struct {
+	ChannelIdentifier common.Hash    `json:"channel_identifier"`
+	OpenBlockNumber   int64          `json:"open_block_number"`
+	TokenAddress      common.Address `json:"token_address"`
+	PartnerAddress    common.Address `json:"partner_address"`
+	SettleTimeout     int            `json:"settle_timeout"`
+	MinSettleTimeout  int            `json:"min_settle_timeout"` //我能接受的最小 settle timeout
+}
+
+/*
+minAcceptableSettleTimeout 通道的 settle timeout 不能低于这个值,
+Config.MinAcceptableSettleTimeout 小于链上允许的最小值时按链上的计算
+*/
+func (rs *Service) minAcceptableSettleTimeout() int {
+	minSettleTimeout := rs.getMinSettleTimeout()
+	if rs.Config.MinAcceptableSettleTimeout > minSettleTimeout {
+		minSettleTimeout = rs.Config.MinAcceptableSettleTimeout
+	}
+	return minSettleTimeout
+}
+
+/*
+checkSettleTimeoutAcceptable 链上的打开我无法阻止,只能不注册这个通道,不通过它交易,也不对它做健康检查,并通知上层.
+这样的通道中只有对方的押金,对方可以自己关闭并结算,我不会因此损失 token.
+只能在主线程中调用
+*/
+func (rs *Service) checkSettleTimeoutAcceptable(tokenAddress, partnerAddress common.Address, channelIdentifier *contracts.ChannelUniqueID, settleTimeout int) bool {
+	minSettleTimeout := rs.minAcceptableSettleTimeout()
+	if settleTimeout >= minSettleTimeout {
+		return true
+	}
+	log.Warn(fmt.Sprintf("ignore channel %s with %s,settle timeout %d is less than %d",
+		channelIdentifier.String(), utils.APex2(partnerAddress), settleTimeout, minSettleTimeout))
+	rs.NotifyHandler.Notify(notify.LevelWarn, &notify.InfoStruct{
+		Type: notify.InfoTypeUnsafeChannel,
+		Message: &UnsafeChannelEvent{
+			ChannelIdentifier: channelIdentifier.ChannelIdentifier,
+			OpenBlockNumber:   channelIdentifier.OpenBlockNumber,
+			TokenAddress:      tokenAddress,
+			PartnerAddress:    partnerAddress,
+			SettleTimeout:     settleTimeout,
+			MinSettleTimeout:  minSettleTimeout,
+		},
+	})
+	return false
+}
@@ -0,0 +1,45 @@
+package photon
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/notify"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterChannelWithShortSettleTimeout(t *testing.T) {
+	rs := &Service{
+		Config:        &params.Config{},
+		NotifyHandler: notify.NewNotifyHandler(),
+	}
+	//没有配置时使用链上允许的最小值
+	assert.Equal(t, rs.getMinSettleTimeout(), rs.minAcceptableSettleTimeout())
+	rs.Config.MinAcceptableSettleTimeout = 1
+	assert.Equal(t, rs.getMinSettleTimeout(), rs.minAcceptableSettleTimeout())
+	rs.Config.MinAcceptableSettleTimeout = rs.getMinSettleTimeout() + 100
+	assert.Equal(t, rs.getMinSettleTimeout()+100, rs.minAcceptableSettleTimeout())
+
+	token, partner := utils.NewRandomAddress(), utils.NewRandomAddress()
+	id := &contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash(), OpenBlockNumber: 3}
+	assert.True(t, rs.checkSettleTimeoutAcceptable(token, partner, id, rs.Config.MinAcceptableSettleTimeout))
+	assert.Len(t, rs.NotifyHandler.GetNoticeChan(), 0)
+
+	//不会去创建 TokenNetwork,Chain 为空也不会出错
+	assert.False(t, rs.registerChannel(token, partner, id, rs.Config.MinAcceptableSettleTimeout-1))
+	notices := rs.NotifyHandler.GetNoticeChan()
+	assert.Len(t, notices, 1)
+	var info struct {
+		Type    int
+		Message *UnsafeChannelEvent
+	}
+	assert.Nil(t, json.Unmarshal([]byte((<-notices).Info), &info))
+	assert.Equal(t, notify.InfoTypeUnsafeChannel, info.Type)
+	assert.Equal(t, id.ChannelIdentifier, info.Message.ChannelIdentifier)
+	assert.Equal(t, partner, info.Message.PartnerAddress)
+	assert.Equal(t, rs.Config.MinAcceptableSettleTimeout-1, info.Message.SettleTimeout)
+	assert.Equal(t, rs.Config.MinAcceptableSettleTimeout, info.Message.MinSettleTimeout)
+}
@@ -5,6 +5,8 @@ import "github.com/ethereum/go-ethereum/common"
 /*
 AsyncResult is designed for async notify
 and Tag can be save anything by user.
+Result 的容量是1,调用者不读取结果也不会阻塞写入者,
+但是同一个结果只能写一次,可能重复写入的地方必须使用 SetResult.
 */
 type AsyncResult struct {
 	Result         chan error
@@ -25,3 +27,16 @@ func NewAsyncResultWithError(err error) *AsyncResult {
 	r.Result <- err
 	return r
 }
+
+/*
+SetResult 写入结果但是从不阻塞,已经有一个没有被读取的结果时丢弃 err 并返回 false,
+这样即使调用者放弃了这个结果,重复通知也不会让写入的 goroutine 永远阻塞.
+*/
+func (r *AsyncResult) SetResult(err error) bool {
+	select {
+	case r.Result <- err:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,52 @@
+package utils
+
+import "time"
+
+/*
+Clock 对时间的访问,测试时可以替换成可控的实现,不用真的等待
+*/
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+/*
+Timer 与 time.Timer 相同,只是 C 改成了方法
+*/
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+//RealClock use time package directly
+type RealClock struct{}
+
+//NewRealClock create a clock for production
+func NewRealClock() Clock {
+	return RealClock{}
+}
+
+//Now is time.Now
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+//After is time.After
+func (RealClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+//NewTimer is time.NewTimer
+func (RealClock) NewTimer(d time.Duration) Timer {
+	return &realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t *realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
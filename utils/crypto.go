@@ -33,6 +33,69 @@ func SignData(privKey *ecdsa.PrivateKey, data []byte) (sig []byte, err error) {
 	return
 }
 
+/*
+Signer 对消息进行签名,私钥可以保存在外部,比如硬件钱包或者远程签名服务
+Signer signs data in the same format as SignData,the key may live outside of this process.
+*/
+type Signer interface {
+	//SignMessage returns signature of Sha3(data)
+	SignMessage(data []byte) ([]byte, error)
+	//Address of the account which signs
+	Address() common.Address
+}
+
+//PrivateKeySigner sign with a private key in memory
+type PrivateKeySigner struct {
+	key  *ecdsa.PrivateKey
+	addr common.Address
+}
+
+//NewPrivateKeySigner create a Signer from private key
+func NewPrivateKeySigner(key *ecdsa.PrivateKey) *PrivateKeySigner {
+	return &PrivateKeySigner{
+		key:  key,
+		addr: crypto.PubkeyToAddress(key.PublicKey),
+	}
+}
+
+//SignMessage impl Signer
+func (s *PrivateKeySigner) SignMessage(data []byte) ([]byte, error) {
+	return SignData(s.key, data)
+}
+
+//Address impl Signer
+func (s *PrivateKeySigner) Address() common.Address {
+	return s.addr
+}
+
+/*
+AuthSignaturePrefix 外部认证(比如向 pfs 或者 REST 服务证明节点身份)签名的数据前缀.
+协议消息的第一个字节是命令号,都是奇数或者0,链上的 balance proof 以 "\x19Spectrum Signed Message:\n" 开头,
+所以以 0x1a 开头的签名不可能被当作协议消息或者 balance proof 重放.
+*/
+var AuthSignaturePrefix = []byte("\x1aPhoton Signed Message:\n")
+
+//AuthMessageData 外部认证时实际签名的数据: AuthSignaturePrefix + 十进制的 len(data) + data
+func AuthMessageData(data []byte) []byte {
+	buf := make([]byte, 0, len(AuthSignaturePrefix)+20+len(data))
+	buf = append(buf, AuthSignaturePrefix...)
+	buf = append(buf, []byte(fmt.Sprintf("%d", len(data)))...)
+	return append(buf, data...)
+}
+
+//SignAuthMessage 使用 signer 对外部认证数据签名
+func SignAuthMessage(signer Signer, data []byte) ([]byte, error) {
+	return signer.SignMessage(AuthMessageData(data))
+}
+
+//VerifyAuthMessage 返回 SignAuthMessage 签名的地址
+func VerifyAuthMessage(data, signature []byte) (addr common.Address, err error) {
+	//Ecrecover 会临时修改 signature
+	sig := make([]byte, len(signature))
+	copy(sig, signature)
+	return Ecrecover(Sha3(AuthMessageData(data)), sig)
+}
+
 //Ecrecover is a wrapper for crypto.Ecrecover
 func Ecrecover(hash common.Hash, signature []byte) (addr common.Address, err error) {
 	if len(signature) != 65 {
@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/ethereum/go-ethereum/common"
 )
 
 //MyCallerFuncHandler handler for log
@@ -24,3 +25,27 @@ func MyStreamHandler(wr io.Writer) log.Handler {
 	})
 	return log.LazyHandler(log.SyncHandler(MyCallerFuncHandler(h)))
 }
+
+/*
+TransferCorrelationID 同一笔交易在各个节点上都相同的标识,由 lockSecretHash 和 token 计算得到,
+和节点中 Transfer2StateManager 使用的key是一致的,重启以后也不会变化
+*/
+func TransferCorrelationID(lockSecretHash common.Hash, token common.Address) string {
+	key := Sha3(lockSecretHash[:], token[:])
+	return common.Bytes2Hex(key[:8])
+}
+
+/*
+TransferLogCtx 交易相关日志的结构化字段,用法: log.Info(msg, utils.TransferLogCtx(lockSecretHash, token)...)
+有些消息(比如RevealSecret)不知道token,这时只记录lockhash
+*/
+func TransferLogCtx(lockSecretHash common.Hash, token common.Address) []interface{} {
+	if token == EmptyAddress {
+		return []interface{}{"lockhash", HPex(lockSecretHash)}
+	}
+	return []interface{}{
+		"cid", TransferCorrelationID(lockSecretHash, token),
+		"lockhash", HPex(lockSecretHash),
+		"token", APex2(token),
+	}
+}
@@ -0,0 +1,128 @@
+package utest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/utils"
+)
+
+/*
+FakeClock for test only,时间只有调用 Advance 才会前进
+*/
+type FakeClock struct {
+	lock   sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+//NewFakeClock create a clock starts at `now`
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+//Now returns the fake time
+func (c *FakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+//After fires when time is advanced by at least d
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+//NewTimer fires when time is advanced by at least d
+func (c *FakeClock) NewTimer(d time.Duration) utils.Timer {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := &fakeTimer{
+		clock: c,
+		c:     make(chan time.Time, 1),
+	}
+	c.add(t, d)
+	return t
+}
+
+//Advance move time forward and fire all expired timers
+func (c *FakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+	var left []*fakeTimer
+	for _, t := range c.timers {
+		if t.deadline.After(c.now) {
+			left = append(left, t)
+			continue
+		}
+		t.fire(c.now)
+	}
+	c.timers = left
+}
+
+//Waiters returns how many timers are waiting,tests can use it to make sure a goroutine is sleeping before Advance
+func (c *FakeClock) Waiters() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.timers)
+}
+
+//BlockUntil wait until at least n timers are waiting
+func (c *FakeClock) BlockUntil(n int) {
+	for c.Waiters() < n {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (c *FakeClock) add(t *fakeTimer, d time.Duration) {
+	t.deadline = c.now.Add(d)
+	if d <= 0 {
+		t.fire(c.now)
+		return
+	}
+	c.timers = append(c.timers, t)
+}
+
+//remove returns true if t is still waiting
+func (c *FakeClock) remove(t *fakeTimer) bool {
+	for i, t2 := range c.timers {
+		if t2 == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTimer struct {
+	clock    *FakeClock
+	deadline time.Time
+	c        chan time.Time
+}
+
+//fire never blocks,like time.Timer,the tick is dropped if the previous one is not received
+func (t *fakeTimer) fire(now time.Time) {
+	select {
+	case t.c <- now:
+	default:
+	}
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+	return t.clock.remove(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+	active := t.clock.remove(t)
+	t.clock.add(t, d)
+	return active
+}
@@ -0,0 +1,43 @@
+package utest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Unix(1000, 0)
+	c := NewFakeClock(start)
+	ch := c.After(time.Second)
+	timer := c.NewTimer(2 * time.Second)
+	c.Advance(500 * time.Millisecond)
+	select {
+	case <-ch:
+		t.Error("should not fire")
+		return
+	default:
+	}
+	c.Advance(500 * time.Millisecond)
+	select {
+	case now := <-ch:
+		if !now.Equal(start.Add(time.Second)) {
+			t.Errorf("fire at %s", now)
+			return
+		}
+	default:
+		t.Error("should fire")
+		return
+	}
+	if !timer.Stop() {
+		t.Error("timer should be active")
+		return
+	}
+	c.Advance(time.Minute)
+	if c.Waiters() != 0 || len(timer.C()) != 0 {
+		t.Error("stopped timer should not fire")
+		return
+	}
+	timer.Reset(time.Second)
+	go c.Advance(time.Second)
+	<-timer.C()
+}
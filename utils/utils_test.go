@@ -3,12 +3,14 @@ package utils
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
 	"math/big"
 	"testing"
 
 	"crypto/sha256"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
 )
 
 func TestBigIntTo32Bytes(t *testing.T) {
@@ -61,3 +63,91 @@ func TestWriteVarInt(t *testing.T) {
 		return
 	}
 }
+
+func TestPrivateKeySigner(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	signer := NewPrivateKeySigner(key)
+	if signer.Address() != crypto.PubkeyToAddress(key.PublicKey) {
+		t.Error("signer address not match")
+	}
+	data := []byte("abc")
+	sig, err := signer.SignMessage(data)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	sig2, _ := SignData(key, data)
+	if !bytes.Equal(sig, sig2) {
+		t.Error("signature should be same as SignData")
+	}
+	addr, err := Ecrecover(Sha3(data), sig)
+	if err != nil || addr != signer.Address() {
+		t.Errorf("recover address err=%v,addr=%s", err, addr.String())
+	}
+}
+
+func TestTransferLogCtx(t *testing.T) {
+	lockSecretHash := NewRandomHash()
+	token := NewRandomAddress()
+	ctx := TransferLogCtx(lockSecretHash, token)
+	if len(ctx) != 6 || ctx[1] != TransferCorrelationID(lockSecretHash, token) {
+		t.Errorf("ctx=%v", ctx)
+	}
+	if TransferCorrelationID(lockSecretHash, token) == TransferCorrelationID(lockSecretHash, NewRandomAddress()) {
+		t.Error("correlation id should depend on token")
+	}
+	if len(TransferLogCtx(lockSecretHash, EmptyAddress)) != 2 {
+		t.Error("should only have lockhash without token")
+	}
+}
+
+func TestAsyncResultSetResult(t *testing.T) {
+	r := NewAsyncResult()
+	if !r.SetResult(nil) {
+		t.Error("first result should be set")
+	}
+	//没人读取结果,重复写入也不能阻塞
+	if r.SetResult(errors.New("again")) {
+		t.Error("second result should be dropped")
+	}
+	if err := <-r.Result; err != nil {
+		t.Errorf("should get first result,got %s", err)
+	}
+	if !r.SetResult(errors.New("after read")) {
+		t.Error("result should be set after read")
+	}
+}
+
+func TestAuthMessage(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	signer := NewPrivateKeySigner(key)
+	data := []byte("challenge")
+	sig, err := SignAuthMessage(signer, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig2 := make([]byte, len(sig))
+	copy(sig2, sig)
+	addr, err := VerifyAuthMessage(data, sig)
+	if err != nil || addr != signer.Address() {
+		t.Errorf("recover address err=%v,addr=%s", err, addr.String())
+	}
+	if !bytes.Equal(sig, sig2) {
+		t.Error("signature should not be changed")
+	}
+	addr, err = VerifyAuthMessage([]byte("challengf"), sig)
+	if err == nil && addr == signer.Address() {
+		t.Error("signature of other data should not match")
+	}
+	//和协议消息的签名分开
+	addr, err = Ecrecover(Sha3(data), sig2)
+	if err == nil && addr == signer.Address() {
+		t.Error("auth signature should not be valid for raw data")
+	}
+	if _, err = VerifyAuthMessage(data, sig[:64]); err == nil {
+		t.Error("short signature should fail")
+	}
+	if !bytes.HasPrefix(AuthMessageData(data), []byte("\x1aPhoton Signed Message:\n9challenge")) {
+		t.Errorf("auth message data=%q", AuthMessageData(data))
+	}
+}
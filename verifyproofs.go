@@ -0,0 +1,84 @@
+package photon
+
+import (
+	"fmt"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/transfer"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//channelProofsResult 双方 balance proof 的签名是否有效
+type channelProofsResult struct {
+	ourProofValid     bool
+	partnerProofValid bool
+}
+
+/*
+VerifyChannelProofs 按照合约的格式重新计算签名数据,检查保存的双方 balance proof 是否由对应的节点签名.
+对方的 balance proof 无效意味着通道关闭以后我无法在链上拿回对方转给我的 token,是很严重的问题.
+任意一个无效时返回 ErrChannelBalanceProofInvalid,说明是哪一个以及原因.
+还没有交易过的一方没有 balance proof,认为是有效的.
+可以在任意线程中调用
+*/
+func (rs *Service) VerifyChannelProofs(channelIdentifier common.Hash) (ourProofValid, partnerProofValid bool, err error) {
+	result := rs.verifyChannelProofsClient(channelIdentifier)
+	err = <-result.Result
+	if r, ok := result.Tag.(*channelProofsResult); ok {
+		ourProofValid, partnerProofValid = r.ourProofValid, r.partnerProofValid
+	}
+	return
+}
+
+/*
+verifyChannelProofs 只能在主线程中调用
+*/
+func (rs *Service) verifyChannelProofs(channelIdentifier common.Hash) (result *utils.AsyncResult) {
+	c := rs.getChannelWithAddr(channelIdentifier)
+	if c == nil {
+		return utils.NewAsyncResultWithError(rerr.ErrChannelNotFound.Printf("can not find channel %s", channelIdentifier.String()))
+	}
+	ourErr := verifyBalanceProof(c, c.OurState.BalanceProofState, c.OurState.Address)
+	partnerErr := verifyBalanceProof(c, c.PartnerState.BalanceProofState, c.PartnerState.Address)
+	result = utils.NewAsyncResult()
+	result.Tag = &channelProofsResult{
+		ourProofValid:     ourErr == nil,
+		partnerProofValid: partnerErr == nil,
+	}
+	var err error
+	switch {
+	case ourErr != nil && partnerErr != nil:
+		err = rerr.ErrChannelBalanceProofInvalid.Printf("our proof:%s,partner's proof:%s", ourErr, partnerErr)
+	case ourErr != nil:
+		err = rerr.ErrChannelBalanceProofInvalid.Printf("our proof:%s", ourErr)
+	case partnerErr != nil:
+		err = rerr.ErrChannelBalanceProofInvalid.Printf("partner's proof:%s", partnerErr)
+	}
+	if partnerErr != nil {
+		log.Error(fmt.Sprintf("balance proof of %s on channel %s is invalid,can not claim it on chain: %s",
+			utils.APex2(c.PartnerState.Address), c.ChannelIdentifier.String(), partnerErr))
+	}
+	result.Result <- err
+	return
+}
+
+//verifyBalanceProof bp 必须是 signer 对通道 c 的签名
+func verifyBalanceProof(c *channel.Channel, bp *transfer.BalanceProofState, signer common.Address) error {
+	if bp == nil || (bp.Nonce == 0 && len(bp.Signature) == 0) {
+		return nil
+	}
+	if bp.ChannelIdentifier != c.ChannelIdentifier {
+		return fmt.Errorf("nonce=%d belongs to channel %s", bp.Nonce, bp.ChannelIdentifier.String())
+	}
+	addr, err := bp.Signer()
+	if err != nil {
+		return fmt.Errorf("nonce=%d can not recover signer: %s", bp.Nonce, err)
+	}
+	if addr != signer {
+		return fmt.Errorf("nonce=%d signed by %s,expect %s", bp.Nonce, utils.APex2(addr), utils.APex2(signer))
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package photon
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/encoding"
+	"github.com/SmartMeshFoundation/Photon/network/graph"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/transfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func signedBalanceProof(t *testing.T, key *ecdsa.PrivateKey, nonce uint64, channelID *contracts.ChannelUniqueID) *transfer.BalanceProofState {
+	msg := encoding.NewDirectTransfer(encoding.NewBalanceProof(nonce, big.NewInt(int64(nonce)*10), utils.NewRandomHash(), channelID))
+	if err := msg.Sign(key, msg); err != nil {
+		t.Fatal(err)
+	}
+	return transfer.NewBalanceProofStateFromEnvelopMessage(msg)
+}
+
+func TestVerifyChannelProofs(t *testing.T) {
+	ourKey, _ := crypto.GenerateKey()
+	partnerKey, _ := crypto.GenerateKey()
+	our, partner := crypto.PubkeyToAddress(ourKey.PublicKey), crypto.PubkeyToAddress(partnerKey.PublicKey)
+	token := utils.NewRandomAddress()
+	channelID := &contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash(), OpenBlockNumber: 3}
+	ourState := channel.NewChannelEndState(our, big.NewInt(100), nil, mtree.EmptyTree)
+	partnerState := channel.NewChannelEndState(partner, big.NewInt(50), nil, mtree.EmptyTree)
+	c, err := channel.NewChannel(ourState, partnerState, &channel.ExternalState{}, token, channelID, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewChannelGraph(our, token, nil)
+	assert.Nil(t, g.AddChannel(c))
+	rs := &Service{
+		NodeAddress:        our,
+		Token2ChannelGraph: map[common.Address]*graph.ChannelGraph{token: g},
+	}
+	verify := func() (ourValid, partnerValid bool, err error) {
+		result := rs.verifyChannelProofs(channelID.ChannelIdentifier)
+		err = <-result.Result
+		r := result.Tag.(*channelProofsResult)
+		return r.ourProofValid, r.partnerProofValid, err
+	}
+
+	//还没有交易过
+	ourValid, partnerValid, err := verify()
+	assert.Nil(t, err)
+	assert.True(t, ourValid)
+	assert.True(t, partnerValid)
+
+	ourState.BalanceProofState = signedBalanceProof(t, ourKey, 2, channelID)
+	partnerState.BalanceProofState = signedBalanceProof(t, partnerKey, 3, channelID)
+	ourValid, partnerValid, err = verify()
+	assert.Nil(t, err)
+	assert.True(t, ourValid)
+	assert.True(t, partnerValid)
+
+	//签名者不对,或者签名数据被修改
+	partnerState.BalanceProofState = signedBalanceProof(t, ourKey, 3, channelID)
+	ourState.BalanceProofState.TransferAmount = big.NewInt(1)
+	ourValid, partnerValid, err = verify()
+	assert.False(t, ourValid)
+	assert.False(t, partnerValid)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, rerr.ErrChannelBalanceProofInvalid.ErrorCode, err.(rerr.StandardError).ErrorCode)
+	}
+
+	//属于另一个通道
+	ourState.BalanceProofState = signedBalanceProof(t, ourKey, 2, channelID)
+	partnerState.BalanceProofState = signedBalanceProof(t, partnerKey, 3, &contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash(), OpenBlockNumber: 3})
+	ourValid, partnerValid, err = verify()
+	assert.True(t, ourValid)
+	assert.False(t, partnerValid)
+	assert.NotNil(t, err)
+
+	result := rs.verifyChannelProofs(utils.NewRandomHash())
+	err = <-result.Result
+	if assert.NotNil(t, err) {
+		assert.Equal(t, rerr.ErrChannelNotFound.ErrorCode, err.(rerr.StandardError).ErrorCode)
+	}
+}
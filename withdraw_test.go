@@ -0,0 +1,168 @@
+package photon
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/encoding"
+	"github.com/SmartMeshFoundation/Photon/network"
+	"github.com/SmartMeshFoundation/Photon/network/graph"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/notify"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/transfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+//withdraw 完成以后通道回到 StateOpened,按照新的余额继续交易
+func TestTransferAfterWithdraw(t *testing.T) {
+	our, partner := utils.NewRandomAddress(), utils.NewRandomAddress()
+	token := utils.NewRandomAddress()
+	ourState := channel.NewChannelEndState(our, big.NewInt(100), nil, mtree.EmptyTree)
+	partnerState := channel.NewChannelEndState(partner, big.NewInt(50), nil, mtree.EmptyTree)
+	ch, err := channel.NewChannel(ourState, partnerState, &channel.ExternalState{}, token,
+		&contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash(), OpenBlockNumber: 3}, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewChannelGraph(our, token, nil)
+	assert.Nil(t, g.AddChannel(ch))
+	rs := &Service{
+		NodeAddress:           our,
+		Config:                &params.Config{},
+		NotifyHandler:         notify.NewNotifyHandler(),
+		Token2ChannelGraph:    map[common.Address]*graph.ChannelGraph{token: g},
+		Transfer2StateManager: make(map[common.Hash]*transfer.StateManager),
+		Clock:                 utils.NewRealClock(),
+		IsChainEffective:      true,
+		dao:                   codefortest.NewTestDB(""),
+	}
+	eh := newStateMachineEventHandler(rs)
+	assert.Nil(t, rs.dao.NewChannel(channel.NewChannelSerialization(ch)))
+
+	//withdraw 期间不能交易
+	ch.State = channeltype.StateWithdraw
+	_, err = rs.checkDirectTransfer(token, partner, big.NewInt(10))
+	assert.Error(t, err)
+
+	err = eh.handleWithdraw(&mediatedtransfer.ContractChannelWithdrawStateChange{
+		ChannelIdentifier:   &contracts.ChannelUniqueID{ChannelIdentifier: ch.ChannelIdentifier.ChannelIdentifier, OpenBlockNumber: 3},
+		Participant1:        our,
+		Participant1Balance: big.NewInt(60),
+		Participant2:        partner,
+		Participant2Balance: big.NewInt(50),
+		BlockNumber:         20,
+	})
+	assert.Nil(t, err)
+	assert.EqualValues(t, channeltype.StateOpened, ch.State)
+	assert.EqualValues(t, 20, ch.ChannelIdentifier.OpenBlockNumber)
+	assert.EqualValues(t, big.NewInt(60), ch.OurState.ContractBalance)
+	cs, err := rs.dao.GetChannelByAddress(ch.ChannelIdentifier.ChannelIdentifier)
+	if assert.Nil(t, err) {
+		assert.EqualValues(t, channeltype.StateOpened, cs.State)
+		assert.EqualValues(t, big.NewInt(60), cs.OurContractBalance)
+		assert.EqualValues(t, 20, cs.ChannelIdentifier.OpenBlockNumber)
+	}
+
+	//withdraw 以后立即交易
+	_, err = rs.checkDirectTransfer(token, partner, big.NewInt(61))
+	assert.Equal(t, rerr.ErrChannelNoEnoughBalance, err)
+	c, err := rs.checkDirectTransfer(token, partner, big.NewInt(60))
+	assert.Nil(t, err)
+	tr, err := c.CreateDirectTransfer(big.NewInt(60))
+	if assert.Nil(t, err) {
+		assert.EqualValues(t, 1, tr.Nonce)
+		assert.EqualValues(t, 20, tr.OpenBlockNumber)
+	}
+}
+
+//合作关闭或者取现请求一直没有 ack,放弃以后通道保持原状态,不能在本地恢复为 StateOpened
+func TestHandshakeGiveUp(t *testing.T) {
+	key, our := utils.MakePrivateKeyAddress()
+	partner, token := utils.NewRandomAddress(), utils.NewRandomAddress()
+	ch, err := channel.NewChannel(channel.NewChannelEndState(our, big.NewInt(100), nil, mtree.EmptyTree),
+		channel.NewChannelEndState(partner, big.NewInt(50), nil, mtree.EmptyTree), &channel.ExternalState{}, token,
+		&contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash(), OpenBlockNumber: 3}, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewChannelGraph(our, token, nil)
+	assert.Nil(t, g.AddChannel(ch))
+	tr := &presenceTransport{sent: make(map[common.Address]int)}
+	rs := &Service{
+		NodeAddress:                 our,
+		Signer:                      utils.NewPrivateKeySigner(key),
+		Config:                      &params.Config{HandshakeRetryInterval: 10 * time.Millisecond, HandshakeMaxAttempts: 2},
+		NotifyHandler:               notify.NewNotifyHandler(),
+		Token2ChannelGraph:          map[common.Address]*graph.ChannelGraph{token: g},
+		ProtocolMessageSendComplete: make(chan *protocolMessage, 10),
+		quitChan:                    make(chan struct{}),
+		dao:                         codefortest.NewTestDB(""),
+	}
+	//对方永远不回复 ack
+	rs.Protocol = network.NewPhotonProtocol(tr, key, rs)
+	defer rs.dao.CloseDB()
+	defer rs.Protocol.StopAndWait()
+	defer close(rs.quitChan)
+	assert.Nil(t, rs.dao.NewChannel(channel.NewChannelSerialization(ch)))
+	waitSendFailed := func() {
+		select {
+		case m := <-rs.ProtocolMessageSendComplete:
+			assert.NotNil(t, m.err)
+			rs.handleSentMessage(m)
+		case <-time.After(10 * time.Second):
+			t.Fatal("handshake not given up")
+		}
+	}
+
+	notices := rs.NotifyHandler.GetNoticeChan()
+	//只关心放弃发送的通知,忽略通道状态变化的通知
+	drainNotices := func() {
+		for len(notices) > 0 {
+			<-notices
+		}
+	}
+	assert.Nil(t, <-rs.withdraw(ch.ChannelIdentifier.ChannelIdentifier, big.NewInt(10)).Result)
+	assert.EqualValues(t, channeltype.StateWithdraw, ch.State)
+	drainNotices()
+	waitSendFailed()
+	//对方可能已经收到了请求,只能关闭通道来重新同步
+	assert.EqualValues(t, channeltype.StateWithdraw, ch.State)
+	cs, err := rs.dao.GetChannelByAddress(ch.ChannelIdentifier.ChannelIdentifier)
+	if assert.Nil(t, err) {
+		assert.EqualValues(t, channeltype.StateWithdraw, cs.State)
+	}
+	assert.Len(t, notices, 1)
+	n := <-notices
+	assert.Contains(t, n.Info, "close it to resync")
+	assert.NotNil(t, <-rs.withdraw(ch.ChannelIdentifier.ChannelIdentifier, big.NewInt(10)).Result)
+
+	ch.State = channeltype.StateOpened
+	assert.Nil(t, <-rs.cooperativeSettleChannel(ch.ChannelIdentifier.ChannelIdentifier).Result)
+	assert.EqualValues(t, channeltype.StateCooprativeSettle, ch.State)
+	drainNotices()
+	waitSendFailed()
+	assert.EqualValues(t, channeltype.StateCooprativeSettle, ch.State)
+	assert.Len(t, notices, 1)
+
+	//通道已经关闭的话保持原状态
+	ch.State = channeltype.StateClosed
+	rs.handleSentMessage(&protocolMessage{
+		receiver: partner,
+		Message: encoding.NewWithdrawRequest(&encoding.WithdrawRequestData{
+			ChannelIDInMessage: encoding.ChannelIDInMessage{ChannelIdentifier: ch.ChannelIdentifier.ChannelIdentifier},
+		}),
+		err: errors.New("no ack after max attempts"),
+	})
+	assert.EqualValues(t, channeltype.StateClosed, ch.State)
+}